@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // TwinMakerCustomMeta is the standard metadata
 type SelectableString struct {
 	Label       string `json:"label"`
@@ -26,3 +31,237 @@ type OptionsInfo struct {
 	Components []SelectableProps  `json:"components,omitempty"`
 	Properties []SelectableString `json:"properties,omitempty"`
 }
+
+// VariableOption is a template variable value in the {text, value} format Grafana variables read
+type VariableOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// VariableKind is the resource a chained template variable lists
+type VariableKind = string
+
+const (
+	VariableEntities       VariableKind = "entities"
+	VariableComponents     VariableKind = "components"
+	VariableProperties     VariableKind = "properties"
+	VariableComponentTypes VariableKind = "componentTypes"
+)
+
+// VariableParents are the values of the variables a chained variable depends on. The workspace
+// defaults to the datasource workspace.
+type VariableParents struct {
+	WorkspaceId     string `json:"workspaceId,omitempty"`
+	EntityId        string `json:"entityId,omitempty"`
+	ComponentName   string `json:"componentName,omitempty"`
+	ComponentTypeId string `json:"componentTypeId,omitempty"`
+}
+
+// ResourcePage is a single page of a paginated resource listing
+type ResourcePage struct {
+	Items     interface{} `json:"items"`
+	NextToken string      `json:"nextToken,omitempty"`
+}
+
+// AlarmReference identifies an alarm component on an entity
+type AlarmReference struct {
+	EntityId      string `json:"entityId"`
+	ComponentName string `json:"componentName"`
+}
+
+type AlarmAckFailure struct {
+	AlarmReference
+	ErrorCode    string `json:"errorCode,omitempty"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// AlarmAckReport lists which alarms were acknowledged and which writes failed
+type AlarmAckReport struct {
+	Acknowledged []AlarmReference  `json:"acknowledged"`
+	Failed       []AlarmAckFailure `json:"failed,omitempty"`
+}
+
+// AlarmSnoozeReport lists which alarms were snoozed in AWS IoT Events and which failed
+type AlarmSnoozeReport struct {
+	Snoozed []AlarmReference  `json:"snoozed"`
+	Failed  []AlarmAckFailure `json:"failed,omitempty"`
+}
+
+// EntityTags are the resource tags of an entity, Error is set when they could not be read
+type EntityTags struct {
+	EntityId string            `json:"entityId"`
+	Arn      string            `json:"arn,omitempty"`
+	Tags     map[string]string `json:"tags"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// EntityTagUpdate sets and removes resource tags of an entity, removals are applied last
+type EntityTagUpdate struct {
+	EntityId string            `json:"entityId"`
+	Set      map[string]string `json:"set,omitempty"`
+	Remove   []string          `json:"remove,omitempty"`
+}
+
+type EntityTagFailure struct {
+	EntityId     string `json:"entityId"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// EntityTagReport lists which entities were updated and which updates failed
+type EntityTagReport struct {
+	Updated []string           `json:"updated"`
+	Failed  []EntityTagFailure `json:"failed,omitempty"`
+}
+
+// WorkspaceCheck is the result of validating the access to one configured workspace
+type WorkspaceCheck struct {
+	WorkspaceId string `json:"workspaceId"`
+	Ok          bool   `json:"ok"`
+	Message     string `json:"message,omitempty"`
+}
+
+// AlarmSummary counts the alarms of a workspace by their latest status
+type AlarmSummary struct {
+	WorkspaceId  string `json:"workspaceId"`
+	Active       int    `json:"active"`
+	Acknowledged int    `json:"acknowledged"`
+	Snoozed      int    `json:"snoozed"`
+	Total        int    `json:"total"`
+	Message      string `json:"message,omitempty"` // why the alarms could not be counted
+}
+
+// WatchlistItem is a single entity property polled by the watchlist
+type WatchlistItem struct {
+	EntityId      string `json:"entityId"`
+	ComponentName string `json:"componentName"`
+	PropertyName  string `json:"propertyName"`
+}
+
+// PropertyFavorite is an entity property a user saved for query authoring, the label is optional
+type PropertyFavorite struct {
+	WorkspaceId   string `json:"workspaceId,omitempty"`
+	EntityId      string `json:"entityId"`
+	ComponentName string `json:"componentName"`
+	PropertyName  string `json:"propertyName"`
+	Label         string `json:"label,omitempty"`
+}
+
+// SceneDataBinding is an entity property bound to a component of a scene node
+type SceneDataBinding struct {
+	EntityId      string `json:"entityId"`
+	ComponentName string `json:"componentName"`
+	PropertyName  string `json:"propertyName"`
+}
+
+// SceneTagState is the result of evaluating the rule bound to a scene tag
+type SceneTagState struct {
+	NodeName      string `json:"nodeName"`
+	Ref           string `json:"ref,omitempty"`
+	RuleId        string `json:"ruleId"`
+	EntityId      string `json:"entityId"`
+	ComponentName string `json:"componentName"`
+	PropertyName  string `json:"propertyName,omitempty"`
+	// Target of the first matching statement, empty when nothing matched
+	Target string `json:"target,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditRecord is a single property write made through the datasource
+type AuditRecord struct {
+	Time          time.Time `json:"time"`
+	User          string    `json:"user,omitempty"`
+	Action        string    `json:"action"`
+	EntityId      string    `json:"entityId"`
+	ComponentName string    `json:"componentName"`
+	PropertyName  string    `json:"propertyName"`
+	OldValue      string    `json:"oldValue,omitempty"`
+	NewValue      string    `json:"newValue,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// QueryEstimate is the expected AWS usage of running a query once
+type QueryEstimate struct {
+	QueryType TwinMakerQueryType `json:"queryType"`
+	// Calls per AWS API, e.g. "iottwinmaker:GetPropertyValueHistory"
+	Calls      map[string]int `json:"calls"`
+	TotalCalls int            `json:"totalCalls"`
+	// Estimated charge in USD using list prices
+	EstimatedCost float64  `json:"estimatedCost"`
+	Notes         []string `json:"notes,omitempty"`
+}
+
+// DebugBundle is a replay of a query for bug reports: the query, the AWS calls it made and the
+// converted result. Property values are redacted like in query results.
+type DebugBundle struct {
+	Created   time.Time      `json:"created"`
+	Query     TwinMakerQuery `json:"query"`
+	TimeRange DebugTimeRange `json:"timeRange"`
+	Calls     []DebugCall    `json:"calls"`
+	// CallsTruncated is set when the query made more calls than the bundle records
+	CallsTruncated bool `json:"callsTruncated,omitempty"`
+	// Response is the result in the QueryDataResponse format of the query API
+	Response json.RawMessage `json:"response"`
+}
+
+type DebugTimeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// DebugCall is an AWS call of a DebugBundle with the query it was made for
+type DebugCall struct {
+	// e.g. "iottwinmaker:GetPropertyValueHistory"
+	Method     string         `json:"method"`
+	Query      TwinMakerQuery `json:"query"`
+	TimeRange  DebugTimeRange `json:"timeRange"`
+	Output     interface{}    `json:"output,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	DurationMs int64          `json:"durationMs"`
+}
+
+// SceneAsset is a model uploaded to the workspace bucket for the scene composer
+type SceneAsset struct {
+	Location    string `json:"location"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+}
+
+// DemoWorkspaceRequest names the workspace the getting started page creates
+type DemoWorkspaceRequest struct {
+	WorkspaceId string `json:"workspaceId"`
+	// ARN of the bucket for the workspace resources, and the execution role of the workspace
+	S3Location string `json:"s3Location"`
+	Role       string `json:"role"`
+}
+
+// DemoWorkspaceResource is a resource of the demo workspace, its status is planned, created or exists
+type DemoWorkspaceResource struct {
+	Type   string `json:"type"`
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// DemoWorkspaceReport lists the resources of the demo workspace and how many sample values were written
+type DemoWorkspaceReport struct {
+	WorkspaceId string                  `json:"workspaceId"`
+	Resources   []DemoWorkspaceResource `json:"resources"`
+	Samples     int                     `json:"samples"`
+}
+
+// AnnotationNote is a Grafana annotation written back to the entity of the panel it was created on
+type AnnotationNote struct {
+	EntityId      string   `json:"entityId"`
+	ComponentName string   `json:"componentName"`
+	Time          int64    `json:"time"` // epoch milliseconds
+	Text          string   `json:"text"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+// AnnotationNoteResult is the property value the annotation was written as
+type AnnotationNoteResult struct {
+	EntityId      string `json:"entityId"`
+	ComponentName string `json:"componentName"`
+	PropertyName  string `json:"propertyName"`
+	Time          string `json:"time"`
+	Value         string `json:"value"`
+}
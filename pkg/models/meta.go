@@ -5,6 +5,21 @@ import "github.com/grafana/grafana-plugin-sdk-go/backend"
 // TwinMakerCustomMeta is the standard metadata
 type TwinMakerCustomMeta struct {
 	NextToken string `json:"nextToken,omitempty"`
+
+	// CacheTTL is a query caching hint in seconds derived from how often the data is updated
+	CacheTTL int `json:"cacheTTL,omitempty"`
+
+	// RowOffset is the position of the first row of this frame in the appended result
+	RowOffset int `json:"rowOffset,omitempty"`
+
+	// CorrelationId echoes the correlation id of the query
+	CorrelationId string `json:"correlationId,omitempty"`
+
+	// FieldNaming echoes the naming scheme of the query, empty for the default names
+	FieldNaming string `json:"fieldNaming,omitempty"`
+
+	// ResolutionSnapshot is the time of the externalId resolution snapshot of the query, RFC3339
+	ResolutionSnapshot string `json:"resolutionSnapshot,omitempty"`
 }
 
 // LoadFromResponse returns the first non-empty TwinMakerCustomMeta from a DataResponse.
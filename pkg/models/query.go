@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,14 +15,78 @@ import (
 type TwinMakerQueryType = string
 
 const (
-	QueryTypeListWorkspace    TwinMakerQueryType = "ListWorkspace" // each datasource will have a default workspace
-	QueryTypeListScenes       TwinMakerQueryType = "ListScenes"    // required for scene viewer
-	QueryTypeListEntities     TwinMakerQueryType = "ListEntities"  //
-	QueryTypeGetEntity        TwinMakerQueryType = "GetEntity"     //
-	QueryTypeGetPropertyValue TwinMakerQueryType = "GetPropertyValue"
-	QueryTypeComponentHistory TwinMakerQueryType = "ComponentHistory"
-	QueryTypeEntityHistory    TwinMakerQueryType = "EntityHistory"
-	QueryTypeGetAlarms        TwinMakerQueryType = "GetAlarms"
+	QueryTypeListWorkspace     TwinMakerQueryType = "ListWorkspace" // each datasource will have a default workspace
+	QueryTypeListScenes        TwinMakerQueryType = "ListScenes"    // required for scene viewer
+	QueryTypeListEntities      TwinMakerQueryType = "ListEntities"  //
+	QueryTypeGetEntity         TwinMakerQueryType = "GetEntity"     //
+	QueryTypeGetPropertyValue  TwinMakerQueryType = "GetPropertyValue"
+	QueryTypeComponentHistory  TwinMakerQueryType = "ComponentHistory"
+	QueryTypeEntityHistory     TwinMakerQueryType = "EntityHistory"
+	QueryTypeGetAlarms         TwinMakerQueryType = "GetAlarms"
+	QueryTypeWorkspaceEvents   TwinMakerQueryType = "WorkspaceEvents"   // requires cloudtrail:LookupEvents
+	QueryTypeAlarmAnnotations  TwinMakerQueryType = "AlarmAnnotations"  // alarm windows from alarm_status transitions
+	QueryTypeWatchlist         TwinMakerQueryType = "Watchlist"         // latest values of the datasource watchlist
+	QueryTypeAuditLog          TwinMakerQueryType = "AuditLog"          // write operations recorded by this datasource
+	QueryTypePropertyHeatmap   TwinMakerQueryType = "PropertyHeatmap"   // one property of a component type bucketed per entity
+	QueryTypeDataAvailability  TwinMakerQueryType = "DataAvailability"  // number of history values per hour or day
+	QueryTypeStateChanges      TwinMakerQueryType = "StateChanges"      // transitions of state properties, not every sample
+	QueryTypePropertyHistogram TwinMakerQueryType = "PropertyHistogram" // distribution of the values of one property
+	QueryTypeExecuteQuery      TwinMakerQueryType = "ExecuteQuery"      // PartiQL statement on the knowledge graph
+	QueryTypeBaselineCompare   TwinMakerQueryType = "BaselineCompare"   // property history next to an earlier window
+	QueryTypeSceneTags         TwinMakerQueryType = "SceneTags"         // data bound tags of a scene, for variables
+	QueryTypeAlarmVideo        TwinMakerQueryType = "AlarmVideo"        // alarm windows with their video recordings
+	QueryTypeDefinitionDiff    TwinMakerQueryType = "DefinitionDiff"    // properties of a component that differ from its component type
+	QueryTypeInterpolated      TwinMakerQueryType = "Interpolated"      // evenly spaced SiteWise values of a SiteWise connected component
+)
+
+type AvailabilityInterval = string
+
+const (
+	AvailabilityHour AvailabilityInterval = "hour"
+	AvailabilityDay  AvailabilityInterval = "day"
+)
+
+type EntityMetadataColumn = string
+
+const (
+	EntityMetadataDescription EntityMetadataColumn = "description"
+	EntityMetadataArn         EntityMetadataColumn = "arn"
+	EntityMetadataCreated     EntityMetadataColumn = "creationDateTime"
+	EntityMetadataUpdated     EntityMetadataColumn = "updateDateTime"
+)
+
+type HeatmapAggregation = string
+
+const (
+	HeatmapAvg   HeatmapAggregation = "avg"
+	HeatmapMin   HeatmapAggregation = "min"
+	HeatmapMax   HeatmapAggregation = "max"
+	HeatmapLast  HeatmapAggregation = "last"
+	HeatmapCount HeatmapAggregation = "count"
+)
+
+// SiteWiseResolution is the interval of SiteWise aggregates
+type SiteWiseResolution = string
+
+const (
+	SiteWiseResolution1m  SiteWiseResolution = "1m"
+	SiteWiseResolution15m SiteWiseResolution = "15m"
+	SiteWiseResolution1h  SiteWiseResolution = "1h"
+)
+
+// FieldNaming is the scheme of the series names. By default fields are named after the property
+// display names and entity names, which change when assets are renamed.
+type FieldNaming = string
+
+const (
+	// FieldNamingStableV1 names every series by ids only, so alert rules keep matching after renames:
+	//
+	//	<entityId>/<componentName>/<propertyName>
+	//
+	// Component type histories are named after the entities their externalIds resolve to, the
+	// entityId and componentName are empty for those that do not resolve. Display names are kept in the field config. The format of a version never changes, a new
+	// format is a new scheme.
+	FieldNamingStableV1 FieldNaming = "stable-v1"
 )
 
 type TwinMakerResultOrder = string
@@ -132,6 +197,15 @@ func (tabularConditions *TwinMakerTabularConditions) ToTwinMakerTabularCondition
 	return tabularCondition
 }
 
+// TwinMakerBooleanDisplay configures how BOOLEAN property history is displayed
+type TwinMakerBooleanDisplay struct {
+	StateTimeline bool   `json:"stateTimeline,omitempty"`
+	TrueLabel     string `json:"trueLabel,omitempty"`
+	FalseLabel    string `json:"falseLabel,omitempty"`
+	TrueColor     string `json:"trueColor,omitempty"`
+	FalseColor    string `json:"falseColor,omitempty"`
+}
+
 // TwinMakerQuery model
 type TwinMakerQuery struct {
 	GrafanaLiveEnabled bool      `json:"grafanaLiveEnabled,omitempty"`
@@ -139,27 +213,151 @@ type TwinMakerQuery struct {
 	WorkspaceId        string    `json:"workspaceId,omitempty"`
 	EntityId           string    `json:"entityId,omitempty"`
 	Properties         []*string `json:"properties,omitempty"`
+	// Runs the query with this role instead of the dashboard role, it must be one of the
+	// queryRoleArns of the datasource. Anonymous requests keep the viewer role when one is set.
+	RoleArn string `json:"roleArn,omitempty"`
+	// EntityHistory and GetPropertyValue run for each of these entities instead of EntityId, e.g.
+	// the values of a multi-value variable, at most MaxEntityIds. The frames are labeled by entityId.
+	EntityIds []string `json:"entityIds,omitempty"`
 	// Optional metadata saved with the query.  When this matches properties used in the results, it will
 	// replace the display name
 	PropertyDisplayNames map[string]string             `json:"propertyDisplayNames,omitempty"`
 	NextToken            string                        `json:"nextToken,omitempty"`
 	ComponentName        string                        `json:"componentName,omitempty"`
+	SceneId              string                        `json:"sceneId,omitempty"`
 	ComponentTypeId      string                        `json:"componentTypeId,omitempty"`
 	PropertyFilter       []TwinMakerPropertyFilter     `json:"filter,omitempty"`
 	ListEntitiesFilter   []TwinMakerListEntitiesFilter `json:"listEntitiesFilter,omitempty"`
 	Order                TwinMakerResultOrder          `json:"order,omitempty"`
 	MaxResults           int                           `json:"maxResults,omitempty"`
+	// EntityHistory values of each property, the first ones in the query order. With descending
+	// order these are the latest values, e.g. for tables of the last N events. The pages up to the
+	// limit are loaded at once, the result has no nextToken.
+	Limit int `json:"limit,omitempty"`
+	// PageSize of the GetPropertyValueHistory and ListEntities calls, above the API maximum the
+	// maximum is used. Smaller pages return the first values sooner, larger ones need fewer calls.
+	// Defaults to the datasource defaultPageSize, the history page size adapts to the data without.
+	PageSize int `json:"pageSize,omitempty"`
+	// Entity columns of ListEntities and GetEntity. ListEntities shows description, creationDateTime
+	// and arn when empty, GetEntity only the components.
+	EntityMetadata []EntityMetadataColumn `json:"entityMetadata,omitempty"`
+	// Load EntityHistory by ComponentTypeId when the entity no longer exists
+	IncludeDeletedEntities bool `json:"includeDeletedEntities,omitempty"`
+	// externalId properties of the deleted entity component, component type histories have no
+	// entityId and are matched on them
+	ExternalIds map[string]string `json:"externalIds,omitempty"`
+	// Snap the start of history queries to the sample interval of the property and set it as the
+	// field interval, so bar and heatmap panels align without interval overrides
+	AlignToResolution bool `json:"alignToResolution,omitempty"`
+	// GetAlarms status to keep, one of NORMAL, ACTIVE, SNOOZE_DISABLED or ACKNOWLEDGED. It is
+	// pushed down as an alarm_status property filter, all alarms are returned when empty.
+	AlarmStatus string `json:"alarmStatus,omitempty"`
+	// Optional display settings for BOOLEAN history
+	BooleanDisplay *TwinMakerBooleanDisplay `json:"booleanDisplay,omitempty"`
+	// Return one page per request for panels that load more rows on demand. NextToken is then
+	// the cursor from the previous response and Live does not stream the remaining pages.
+	Append bool `json:"append,omitempty"`
+	// Stream the new values of an EntityHistory query of one property on the Live channel of the
+	// property, panels watching the same property share its polling
+	PropertyStream bool `json:"propertyStream,omitempty"`
+	// Add a summary frame with the rows, null values, time range and gaps of every time series
+	DataSummary bool `json:"dataSummary,omitempty"`
+	// Set by the query editor, it is added to the logs and traces and echoed in the frame meta
+	CorrelationId string `json:"correlationId,omitempty"`
+	// Set by the query editor while editing, the query loads one page of at most 100 rows with a short timeout
+	Preview bool `json:"preview,omitempty"`
+	// PropertyHeatmap bucket size (defaults to 1/60 of the range) and how values in a bucket are combined (defaults to avg)
+	BucketSeconds int                `json:"bucketSeconds,omitempty"`
+	Aggregation   HeatmapAggregation `json:"aggregation,omitempty"`
+	// History of series with more values than the MaxDataPoints of the panel is combined per
+	// bucket of the range divided by MaxDataPoints, and at least the panel interval. Numbers
+	// use the aggregation, other values and last keep the latest value of the bucket.
+	Downsample HeatmapAggregation `json:"downsample,omitempty"`
+	// EntityHistory of SiteWise connected components as SiteWise aggregates (avg, min, max or count)
+	// at the resolution, defaults to 1m, instead of the raw values. The field names end with both,
+	// e.g. "Temperature avg 15m".
+	Aggregate          HeatmapAggregation `json:"aggregate,omitempty"`
+	SiteWiseResolution SiteWiseResolution `json:"sitewiseResolution,omitempty"`
+	// Expression of the fields of each row added as a severity field, so alarm tables can be sorted
+	// by it. Fields are referenced by name or property name, times are seconds since the epoch like
+	// now, e.g. (alarmStatus == 'ACTIVE') * 10 + (now - Time) / 3600. Supports + - * / %,
+	// comparisons, && || !, abs, min, max and if(condition, then, else).
+	SeverityExpression string `json:"severityExpression,omitempty"`
+	// PropertyHistogram number of equal width buckets, defaults to 20
+	HistogramBuckets int `json:"histogramBuckets,omitempty"`
+	// BaselineCompare days between the time range and the baseline window, defaults to 28 so
+	// the weekdays match
+	BaselineDays int `json:"baselineDays,omitempty"`
+	// DataAvailability histogram interval, defaults to hours for ranges up to two days
+	AvailabilityInterval AvailabilityInterval `json:"availabilityInterval,omitempty"`
+	// ExecuteQuery PartiQL statement, for example
+	// SELECT e FROM EntityGraph MATCH (e) WHERE e.entityName = 'Mixer_0'
+	QueryStatement string `json:"queryStatement,omitempty"`
+	// Resolve the externalIds of component history results from a snapshot, so re-running the
+	// query resolves the same entities after the twin model changed. PinResolution takes a
+	// snapshot on the first run of the query and keeps it for its later runs, its time is in the
+	// frame meta and ResolutionSnapshot reuses it.
+	PinResolution      bool       `json:"pinResolution,omitempty"`
+	ResolutionSnapshot *QueryTime `json:"resolutionSnapshot,omitempty"`
+	// Scheme of the frame and field names, empty for the default display names
+	FieldNaming FieldNaming `json:"fieldNaming,omitempty"`
+	// Name of the time field instead of "Time", and an additional "<name>_iso" column of the
+	// times as ISO8601 strings for exports to tools without a time type
+	TimeFieldName string `json:"timeFieldName,omitempty"`
+	TimeString    bool   `json:"timeString,omitempty"`
 
 	// Athena Data Connector parameters for iottwinmaker.GetPropertyValue
 	TabularConditions TwinMakerTabularConditions `json:"tabularConditions,omitempty"`
 	PropertyGroupName string                     `json:"propertyGroupName,omitempty"`
+	// Columns of a tabular query, only these are fetched and converted. All properties when empty.
+	SelectedProperties []string `json:"selectedProperties,omitempty"`
 
 	IntervalStreamingSeconds int           `json:"intervalStreaming,string,omitempty"`
 	IntervalStreaming        time.Duration `json:"_"`
 
+	// Optional overrides for the dashboard time range
+	StartTime *QueryTime `json:"startTime,omitempty"`
+	EndTime   *QueryTime `json:"endTime,omitempty"`
+
 	// Direct from the gRPC interfaces
-	QueryType TwinMakerQueryType `json:"-"`
-	TimeRange backend.TimeRange  `json:"-"`
+	QueryType     TwinMakerQueryType `json:"-"`
+	TimeRange     backend.TimeRange  `json:"-"`
+	MaxDataPoints int64              `json:"-"`
+	Interval      time.Duration      `json:"-"`
+}
+
+// QueryTime accepts epoch milliseconds (as a number or string) or an ISO8601 timestamp
+type QueryTime struct {
+	time.Time
+}
+
+func (t *QueryTime) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch val := v.(type) {
+	case float64:
+		t.Time = time.UnixMilli(int64(val)).UTC()
+		return nil
+	case string:
+		if ms, err := strconv.ParseInt(val, 10, 64); err == nil {
+			t.Time = time.UnixMilli(ms).UTC()
+			return nil
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return fmt.Errorf("invalid time %q, expected epoch milliseconds or ISO8601", val)
+		}
+		t.Time = parsed.UTC()
+		return nil
+	}
+	return fmt.Errorf("invalid time %s, expected epoch milliseconds or ISO8601", string(b))
+}
+
+func (t QueryTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Time.UTC().Format(time.RFC3339Nano))
 }
 
 func (q *TwinMakerQuery) CacheKey(prefix string) string {
@@ -188,6 +386,9 @@ func (q *TwinMakerQuery) CacheKey(prefix string) string {
 	return key
 }
 
+// MaxEntityIds bounds the entities of one query, each adds its own requests
+const MaxEntityIds = 100
+
 // ReadQuery will read and validate Settings from the DataSourceConfig
 func ReadQuery(query backend.DataQuery) (TwinMakerQuery, error) {
 	model := TwinMakerQuery{}
@@ -204,8 +405,95 @@ func ReadQuery(query backend.DataQuery) (TwinMakerQuery, error) {
 		model.IntervalStreaming = 30 * time.Second
 	}
 
+	// the filters are pushed down as propertyFilters, the connector implements the operators
+	for i, f := range model.PropertyFilter {
+		model.PropertyFilter[i].Op = strings.TrimSpace(f.Op)
+		if model.PropertyFilter[i].Op == "" {
+			model.PropertyFilter[i].Op = "="
+		}
+	}
+
+	// orderByTime of GetPropertyValueHistory, ASC and DESC are short for the API values
+	switch strings.ToUpper(model.Order) {
+	case "":
+	case "ASC", ResultOrderAsc:
+		model.Order = ResultOrderAsc
+	case "DESC", ResultOrderDesc:
+		model.Order = ResultOrderDesc
+	default:
+		return model, fmt.Errorf("invalid order %q, expected ASCENDING or DESCENDING", model.Order)
+	}
+	if model.Limit < 0 {
+		return model, fmt.Errorf("limit must not be negative")
+	}
+	if model.PageSize < 0 {
+		return model, fmt.Errorf("pageSize must not be negative")
+	}
+	switch model.Downsample {
+	case "", HeatmapAvg, HeatmapMin, HeatmapMax, HeatmapLast, HeatmapCount:
+	default:
+		return model, fmt.Errorf("invalid downsample %q, expected avg, min, max, last or count", model.Downsample)
+	}
+	switch model.Aggregate {
+	case "", HeatmapAvg, HeatmapMin, HeatmapMax, HeatmapCount:
+	default:
+		return model, fmt.Errorf("invalid aggregate %q, expected avg, min, max or count", model.Aggregate)
+	}
+	switch model.SiteWiseResolution {
+	case SiteWiseResolution1m, SiteWiseResolution15m, SiteWiseResolution1h:
+		if model.Aggregate == "" {
+			return model, fmt.Errorf("sitewiseResolution requires an aggregate")
+		}
+	case "":
+		if model.Aggregate != "" {
+			model.SiteWiseResolution = SiteWiseResolution1m
+		}
+	default:
+		return model, fmt.Errorf("invalid sitewiseResolution %q, expected 1m, 15m or 1h", model.SiteWiseResolution)
+	}
+
+	// a single entity runs as a plain entity query
+	model.EntityIds = uniqueEntityIds(model.EntityIds)
+	if len(model.EntityIds) == 1 {
+		model.EntityId = model.EntityIds[0]
+		model.EntityIds = nil
+	}
+	if len(model.EntityIds) > 0 {
+		if query.QueryType != string(QueryTypeEntityHistory) && query.QueryType != string(QueryTypeGetPropertyValue) {
+			return model, fmt.Errorf("entityIds is only supported by EntityHistory and GetPropertyValue queries")
+		}
+		if len(model.EntityIds) > MaxEntityIds {
+			return model, fmt.Errorf("entityIds has %d entities, at most %d are supported", len(model.EntityIds), MaxEntityIds)
+		}
+	}
+
 	// From the raw query
 	model.TimeRange = query.TimeRange
 	model.QueryType = query.QueryType
+	model.MaxDataPoints = query.MaxDataPoints
+	model.Interval = query.Interval
+
+	if model.StartTime != nil {
+		model.TimeRange.From = model.StartTime.Time
+	}
+	if model.EndTime != nil {
+		model.TimeRange.To = model.EndTime.Time
+	}
+	if (model.StartTime != nil || model.EndTime != nil) && !model.TimeRange.From.Before(model.TimeRange.To) {
+		return model, fmt.Errorf("startTime must be before endTime")
+	}
 	return model, nil
 }
+
+// uniqueEntityIds drops empty and repeated ids, nil when none are left
+func uniqueEntityIds(ids []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
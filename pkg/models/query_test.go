@@ -0,0 +1,148 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadQueryTimeOverrides(t *testing.T) {
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	dashboard := backend.TimeRange{From: from, To: from.Add(time.Hour)}
+	read := func(json string) (TwinMakerQuery, error) {
+		return ReadQuery(backend.DataQuery{JSON: []byte(json), TimeRange: dashboard})
+	}
+
+	t.Run("no overrides keeps the dashboard range", func(t *testing.T) {
+		q, err := read(`{}`)
+		require.NoError(t, err)
+		require.Equal(t, dashboard, q.TimeRange)
+	})
+
+	t.Run("epoch millis and ISO8601 are normalized to UTC", func(t *testing.T) {
+		q, err := read(`{"startTime": 1651017600000, "endTime": "2022-04-27T03:00:00+02:00"}`)
+		require.NoError(t, err)
+		require.Equal(t, from, q.TimeRange.From)
+		require.Equal(t, from.Add(time.Hour), q.TimeRange.To)
+	})
+
+	t.Run("epoch millis as string", func(t *testing.T) {
+		q, err := read(`{"endTime": "1651021200000"}`)
+		require.NoError(t, err)
+		require.Equal(t, from, q.TimeRange.From)
+		require.Equal(t, from.Add(time.Hour), q.TimeRange.To)
+		require.Equal(t, time.UTC, q.TimeRange.To.Location())
+	})
+
+	t.Run("invalid values are rejected", func(t *testing.T) {
+		_, err := read(`{"startTime": "yesterday"}`)
+		require.ErrorContains(t, err, "expected epoch milliseconds or ISO8601")
+
+		_, err = read(`{"startTime": true}`)
+		require.Error(t, err)
+	})
+
+	t.Run("start must be before end", func(t *testing.T) {
+		_, err := read(`{"startTime": "2022-04-27T02:00:00Z", "endTime": "2022-04-27T01:00:00Z"}`)
+		require.ErrorContains(t, err, "startTime must be before endTime")
+	})
+}
+
+func TestReadQueryEntityIds(t *testing.T) {
+	read := func(queryType TwinMakerQueryType, json string) (TwinMakerQuery, error) {
+		return ReadQuery(backend.DataQuery{QueryType: string(queryType), JSON: []byte(json)})
+	}
+
+	q, err := read(QueryTypeEntityHistory, `{"entityId": "Mixer_0", "entityIds": ["Mixer_0", "", "Mixer_1", "Mixer_0"]}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Mixer_0", "Mixer_1"}, q.EntityIds)
+
+	// a single entity is a plain entity query
+	q, err = read(QueryTypeGetAlarms, `{"entityIds": ["Mixer_1", "Mixer_1"]}`)
+	require.NoError(t, err)
+	require.Equal(t, "Mixer_1", q.EntityId)
+	require.Nil(t, q.EntityIds)
+
+	// other query types do not run for several entities
+	_, err = read(QueryTypeGetAlarms, `{"entityIds": ["Mixer_0", "Mixer_1"]}`)
+	require.ErrorContains(t, err, "entityIds is only supported by EntityHistory and GetPropertyValue queries")
+
+	ids := make([]string, MaxEntityIds+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("Mixer_%d", i)
+	}
+	b, _ := json.Marshal(map[string]interface{}{"entityIds": ids})
+	_, err = read(QueryTypeGetPropertyValue, string(b))
+	require.ErrorContains(t, err, "entityIds has 101 entities, at most 100 are supported")
+}
+
+func TestReadQueryOrder(t *testing.T) {
+	read := func(json string) (TwinMakerQuery, error) {
+		return ReadQuery(backend.DataQuery{JSON: []byte(json)})
+	}
+
+	q, err := read(`{"order": "desc"}`)
+	require.NoError(t, err)
+	require.Equal(t, ResultOrderDesc, q.Order)
+	q, err = read(`{"order": "ASCENDING"}`)
+	require.NoError(t, err)
+	require.Equal(t, ResultOrderAsc, q.Order)
+
+	_, err = read(`{"order": "newest"}`)
+	require.Error(t, err)
+	_, err = read(`{"limit": -1}`)
+	require.Error(t, err)
+	_, err = read(`{"pageSize": -1}`)
+	require.Error(t, err)
+}
+
+func TestReadQueryFilter(t *testing.T) {
+	q, err := ReadQuery(backend.DataQuery{JSON: []byte(`{"filter": [
+		{"name": "temperature", "op": " > ", "value": {"doubleValue": 80}},
+		{"name": "status", "value": {"stringValue": "ON"}}
+	]}`)})
+	require.NoError(t, err)
+	require.Equal(t, []TwinMakerPropertyFilter{
+		{Name: "temperature", Op: ">", Value: TwinMakerFilterValue{DoubleValue: aws.Float64(80)}},
+		{Name: "status", Op: "=", Value: TwinMakerFilterValue{StringValue: aws.String("ON")}},
+	}, q.PropertyFilter)
+	require.Equal(t, ">", *q.PropertyFilter[0].ToTwinMakerFilter().Operator)
+}
+
+func TestReadQueryDownsample(t *testing.T) {
+	q, err := ReadQuery(backend.DataQuery{
+		JSON:          []byte(`{"downsample": "last"}`),
+		MaxDataPoints: 500,
+		Interval:      time.Minute,
+	})
+	require.NoError(t, err)
+	require.Equal(t, HeatmapLast, q.Downsample)
+	require.Equal(t, int64(500), q.MaxDataPoints)
+	require.Equal(t, time.Minute, q.Interval)
+
+	_, err = ReadQuery(backend.DataQuery{JSON: []byte(`{"downsample": "median"}`)})
+	require.Error(t, err)
+}
+
+func TestReadQueryAggregate(t *testing.T) {
+	q, err := ReadQuery(backend.DataQuery{JSON: []byte(`{"aggregate": "max"}`)})
+	require.NoError(t, err)
+	require.Equal(t, HeatmapMax, q.Aggregate)
+	require.Equal(t, SiteWiseResolution1m, q.SiteWiseResolution)
+
+	q, err = ReadQuery(backend.DataQuery{JSON: []byte(`{"aggregate": "count", "sitewiseResolution": "1h"}`)})
+	require.NoError(t, err)
+	require.Equal(t, SiteWiseResolution1h, q.SiteWiseResolution)
+
+	_, err = ReadQuery(backend.DataQuery{JSON: []byte(`{"aggregate": "last"}`)})
+	require.Error(t, err)
+	_, err = ReadQuery(backend.DataQuery{JSON: []byte(`{"aggregate": "avg", "sitewiseResolution": "5m"}`)})
+	require.Error(t, err)
+	_, err = ReadQuery(backend.DataQuery{JSON: []byte(`{"sitewiseResolution": "15m"}`)})
+	require.EqualError(t, err, "sitewiseResolution requires an aggregate")
+}
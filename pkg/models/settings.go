@@ -3,16 +3,93 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
 )
 
+// TwinMakerDefaultQuery is an admin configured starting point for new panel queries
+type TwinMakerDefaultQuery struct {
+	QueryType       TwinMakerQueryType `json:"queryType,omitempty"`
+	WorkspaceId     string             `json:"workspaceId,omitempty"`
+	EntityId        string             `json:"entityId,omitempty"`
+	ComponentName   string             `json:"componentName,omitempty"`
+	ComponentTypeId string             `json:"componentTypeId,omitempty"`
+	Properties      []string           `json:"properties,omitempty"`
+}
+
+type RedactionAction = string
+
+const (
+	RedactionMask RedactionAction = "mask" // values are replaced with a placeholder
+	RedactionDrop RedactionAction = "drop" // values are removed from the response
+)
+
+// RedactionRule hides the values of properties whose name matches the pattern (path.Match syntax)
+type RedactionRule struct {
+	Pattern string          `json:"pattern"`
+	Action  RedactionAction `json:"action,omitempty"` // defaults to mask
+}
+
+// DefaultLinkSchemes are the schemes of URL values that get data links when LinkSchemes is empty
+var DefaultLinkSchemes = []string{"https", "s3"}
+
+// UnsafeLinkSchemes run script or embed content in the browser, values with them are removed from
+// query responses and they can not be link schemes
+var UnsafeLinkSchemes = []string{"javascript", "data", "vbscript"}
+
+// MaxEntityShards bounds EntityShards, every shard adds its own workers and data plane calls
+const MaxEntityShards = 16
+
 type TwinMakerDataSourceSetting struct {
 	awsds.AWSDatasourceSettings
-	AssumeRoleARNWriter string `json:"assumeRoleArnWriter"`
-	WorkspaceID         string `json:"workspaceId"`
-	UID                 string `json:"uid"`
+	AssumeRoleARNWriter string                 `json:"assumeRoleArnWriter"`
+	AssumeRoleARNBase   string                 `json:"assumeRoleArnBase,omitempty"`   // optional first hop for the dashboard and writer roles
+	AssumeRoleARNViewer string                 `json:"assumeRoleArnViewer,omitempty"` // optional narrower role for anonymous (kiosk) requests
+	QueryRoleARNs       []string               `json:"queryRoleArns,omitempty"`       // roles queries may run with instead of the dashboard role
+	WorkspaceID         string                 `json:"workspaceId"`
+	AllowedWorkspaces   []string               `json:"allowedWorkspaces,omitempty"` // other workspaces queries may use, any when empty
+	OrgWorkspaces       map[int64]string       `json:"orgWorkspaces,omitempty"`     // workspace each Grafana org ID is bound to, other orgs use workspaceId
+	DefaultQuery        *TwinMakerDefaultQuery `json:"defaultQuery,omitempty"`
+	WorkspaceEvents     bool                   `json:"workspaceEvents,omitempty"`
+	AuditS3Location     string                 `json:"auditS3Location,omitempty"` // optional s3://bucket/prefix copy of the write audit log
+	SecondaryRegion     string                 `json:"secondaryRegion,omitempty"` // reads fail over here when the primary region is unreachable
+	RedactionRules      []RedactionRule        `json:"redactionRules,omitempty"`
+	LinkSchemes         []string               `json:"linkSchemes,omitempty"`            // schemes of URL values that get data links, DefaultLinkSchemes when empty
+	DisableUrlLinks     bool                   `json:"disableUrlLinks,omitempty"`        // URL values are shown as text without data links
+	SceneAssetUploads   bool                   `json:"sceneAssetUploads,omitempty"`      // allows glb/gltf uploads to the workspace bucket
+	EntityTagEditing    bool                   `json:"entityTagEditing,omitempty"`       // allows admins to change entity resource tags with the writer role
+	MetadataCacheFile   string                 `json:"metadataCacheFile,omitempty"`      // optional bolt file keeping the entity metadata cache across restarts, it holds entity property values (redacted) and should be protected like the datasource secrets
+	AnnotationProperty  string                 `json:"annotationProperty,omitempty"`     // entity property Grafana annotations are written to, off when empty
+	AlarmModelSync      bool                   `json:"alarmModelSync,omitempty"`         // acknowledges and snoozes alarms of SiteWise alarm models in AWS IoT Events too
+	ExternalIdCacheSecs int                    `json:"externalIdCacheSeconds,omitempty"` // how long resolved externalIds are reused, 0 for the default and -1 to resolve on every query
+	MaxResponseBytes    int                    `json:"maxResponseBytes,omitempty"`       // per query, frames of larger query responses are downsampled, unlimited when 0
+	DefaultPageSize     int                    `json:"defaultPageSize,omitempty"`        // pageSize of queries without one
+	EntityShards        int                    `json:"entityShards,omitempty"`           // multi-entity queries split their entities into shards with their own workers and rate limit, off when 0
+	MaxHistoryCalls     int                    `json:"maxHistoryCalls,omitempty"`        // GetPropertyValueHistory calls of the paged history requests of a query, unlimited until the query deadline when 0
+	UID                 string                 `json:"uid"`
+
+	// Retries of throttled and failed AWS requests, the SDK defaults (3 retries with exponential
+	// backoff) when 0. MaxRetries -1 disables retries.
+	MaxRetries       int `json:"maxRetries,omitempty"`
+	RetryBaseDelayMs int `json:"retryBaseDelayMs,omitempty"` // delay of the first retry, doubled on each attempt
+	RetryMaxDelayMs  int `json:"retryMaxDelayMs,omitempty"`  // caps the backoff and the Retry-After of throttling responses
+
+	// Endpoint overrides next to the TwinMaker endpoint, e.g. VPC interface endpoints or an emulator
+	SiteWiseEndpoint     string `json:"sitewiseEndpoint,omitempty"`
+	KinesisVideoEndpoint string `json:"kinesisVideoEndpoint,omitempty"` // stream data endpoints are still looked up
+
+	// Private Data source Connect, the AWS calls go through the Grafana secure socks proxy
+	EnableSecureSocksProxy   bool   `json:"enableSecureSocksProxy,omitempty"`
+	SecureSocksProxyUsername string `json:"secureSocksProxyUsername,omitempty"` // defaults to the data source UID
+	SecureSocksProxyPassword string `json:"-"`
 }
 
 func (s *TwinMakerDataSourceSetting) Load(config backend.DataSourceInstanceSettings) error {
@@ -34,14 +111,249 @@ func (s *TwinMakerDataSourceSetting) Load(config backend.DataSourceInstanceSetti
 
 	s.AccessKey = config.DecryptedSecureJSONData["accessKey"]
 	s.SecretKey = config.DecryptedSecureJSONData["secretKey"]
+	s.SecureSocksProxyPassword = config.DecryptedSecureJSONData["secureSocksProxyPassword"]
+	return nil
+}
+
+// ProxyOptions are the secure socks proxy options of the AWS clients, nil when the proxy is off
+func (s *TwinMakerDataSourceSetting) ProxyOptions() *proxy.Options {
+	if !s.EnableSecureSocksProxy {
+		return nil
+	}
+	username := s.SecureSocksProxyUsername
+	if username == "" {
+		username = s.UID
+	}
+	return &proxy.Options{
+		Enabled: true,
+		Auth: &proxy.AuthOptions{
+			Username: username,
+			Password: s.SecureSocksProxyPassword,
+		},
+	}
+}
+
+// GetDefaultQuery returns the configured default query, falling back to the datasource workspace
+func (s *TwinMakerDataSourceSetting) GetDefaultQuery() TwinMakerDefaultQuery {
+	q := TwinMakerDefaultQuery{}
+	if s.DefaultQuery != nil {
+		q = *s.DefaultQuery
+	}
+	if q.WorkspaceId == "" {
+		q.WorkspaceId = s.WorkspaceID
+	}
+	return q
+}
+
+// Workspaces is the datasource workspace followed by the other allowed workspaces
+func (s *TwinMakerDataSourceSetting) Workspaces() []string {
+	workspaces := []string{}
+	seen := map[string]bool{}
+	for _, id := range append([]string{s.WorkspaceID}, s.AllowedWorkspaces...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			workspaces = append(workspaces, id)
+		}
+	}
+	return workspaces
+}
+
+// WorkspaceAllowed is true when queries may read the workspace
+func (s *TwinMakerDataSourceSetting) WorkspaceAllowed(id string) bool {
+	if len(s.AllowedWorkspaces) == 0 || id == s.WorkspaceID {
+		return true
+	}
+	for _, allowed := range s.AllowedWorkspaces {
+		if allowed == id {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkspaceForOrg is the workspace of the Grafana org, the one OrgWorkspaces binds it to or the
+// datasource workspace
+func (s *TwinMakerDataSourceSetting) WorkspaceForOrg(orgID int64) string {
+	if id, ok := s.OrgWorkspaces[orgID]; ok {
+		return id
+	}
+	return s.WorkspaceID
+}
+
+// WorkspacesForOrg is Workspaces for the Grafana org, only its own workspace when it is bound
+func (s *TwinMakerDataSourceSetting) WorkspacesForOrg(orgID int64) []string {
+	if id, ok := s.OrgWorkspaces[orgID]; ok {
+		return []string{id}
+	}
+	return s.Workspaces()
+}
+
+// WorkspaceAllowedForOrg is WorkspaceAllowed for the Grafana org. A bound org may only read its
+// own workspace, and the workspaces bound to orgs are not allowed for the other orgs.
+func (s *TwinMakerDataSourceSetting) WorkspaceAllowedForOrg(orgID int64, id string) bool {
+	if bound, ok := s.OrgWorkspaces[orgID]; ok {
+		return id == bound
+	}
+	if id != s.WorkspaceID {
+		for _, bound := range s.OrgWorkspaces {
+			if bound == id {
+				return false
+			}
+		}
+	}
+	return s.WorkspaceAllowed(id)
+}
+
+// QueryRoleAllowed is true when queries may run with the role
+func (s *TwinMakerDataSourceSetting) QueryRoleAllowed(arn string) bool {
+	for _, allowed := range s.QueryRoleARNs {
+		if allowed == arn {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryDelays are the base and maximum backoff of retried AWS requests, zero for the SDK defaults
+func (s *TwinMakerDataSourceSetting) RetryDelays() (base time.Duration, max time.Duration) {
+	return time.Duration(s.RetryBaseDelayMs) * time.Millisecond, time.Duration(s.RetryMaxDelayMs) * time.Millisecond
+}
+
+// ExternalIdCacheTTL is the expiry of resolved externalIds, negative when they are not cached and
+// zero for the default
+func (s *TwinMakerDataSourceSetting) ExternalIdCacheTTL() time.Duration {
+	if s.ExternalIdCacheSecs < 0 {
+		return -1
+	}
+	return time.Duration(s.ExternalIdCacheSecs) * time.Second
+}
+
+// validEndpoint accepts an empty endpoint, a host or an http(s) URL. Like the AWS SDK, a host
+// without a scheme uses https.
+func validEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q, expected an http or https URL", endpoint)
+	}
+	return nil
+}
+
+// RegionPartition is the AWS partition of a region, e.g. aws-us-gov for us-gov-west-1 or aws-cn
+// for cn-north-1. Regions the SDK does not know are matched by name, anything else is aws.
+func RegionPartition(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
+// Partition is the AWS partition of the configured region, the roles, buckets and keys of the
+// workspace are in the same partition
+func (s *TwinMakerDataSourceSetting) Partition() string {
+	return RegionPartition(s.Region)
+}
+
+// validPartition rejects roles and regions of another partition, credentials do not work
+// across partitions so these would only fail on the first query
+func (s *TwinMakerDataSourceSetting) validPartition() error {
+	partition := s.Partition()
+	if s.SecondaryRegion != "" && RegionPartition(s.SecondaryRegion) != partition {
+		return fmt.Errorf("secondary region %s is not in the %s partition of region %s", s.SecondaryRegion, partition, s.Region)
+	}
+	roles := append([]string{s.AssumeRoleARN, s.AssumeRoleARNBase, s.AssumeRoleARNWriter, s.AssumeRoleARNViewer}, s.QueryRoleARNs...)
+	for _, role := range roles {
+		if a, err := arn.Parse(role); err == nil && a.Partition != partition {
+			return fmt.Errorf("role %s is not in the %s partition of region %s", role, partition, s.Region)
+		}
+	}
 	return nil
 }
 
 func (s *TwinMakerDataSourceSetting) Validate() error {
-	// OK
+	if err := s.validPartition(); err != nil {
+		return err
+	}
+	for _, endpoint := range []string{s.SiteWiseEndpoint, s.KinesisVideoEndpoint} {
+		if err := validEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+	for org, id := range s.OrgWorkspaces {
+		if org <= 0 || id == "" {
+			return fmt.Errorf("invalid workspace %q of org %d", id, org)
+		}
+	}
+	for _, arn := range s.QueryRoleARNs {
+		if !strings.HasPrefix(arn, "arn:") {
+			return fmt.Errorf("invalid query role %q", arn)
+		}
+	}
+	if s.MaxRetries < -1 {
+		return fmt.Errorf("invalid maximum retries %d, expected -1 to disable retries or a positive count", s.MaxRetries)
+	}
+	if s.RetryBaseDelayMs < 0 || s.RetryMaxDelayMs < 0 {
+		return fmt.Errorf("invalid retry delay, expected milliseconds")
+	}
+	if s.RetryMaxDelayMs > 0 && s.RetryBaseDelayMs > s.RetryMaxDelayMs {
+		return fmt.Errorf("retry base delay %dms is longer than the maximum delay %dms", s.RetryBaseDelayMs, s.RetryMaxDelayMs)
+	}
+	if s.MaxResponseBytes < 0 {
+		return fmt.Errorf("invalid maximum response size %d", s.MaxResponseBytes)
+	}
+	if s.DefaultPageSize < 0 {
+		return fmt.Errorf("invalid default page size %d", s.DefaultPageSize)
+	}
+	if s.MaxHistoryCalls < 0 {
+		return fmt.Errorf("invalid max history calls %d", s.MaxHistoryCalls)
+	}
+	if s.EntityShards < 0 || s.EntityShards > MaxEntityShards {
+		return fmt.Errorf("invalid entity shards %d, expected at most %d", s.EntityShards, MaxEntityShards)
+	}
+	for _, scheme := range s.LinkSchemes {
+		if !validScheme(scheme) {
+			return fmt.Errorf("invalid link scheme %q", scheme)
+		}
+		for _, unsafe := range UnsafeLinkSchemes {
+			if strings.EqualFold(scheme, unsafe) {
+				return fmt.Errorf("link scheme %q is not allowed", scheme)
+			}
+		}
+	}
+	for _, rule := range s.RedactionRules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("invalid redaction pattern %q", rule.Pattern)
+		}
+		switch rule.Action {
+		case "", RedactionMask, RedactionDrop:
+		default:
+			return fmt.Errorf("invalid redaction action %q, expected %q or %q", rule.Action, RedactionMask, RedactionDrop)
+		}
+	}
 	return nil
 }
 
+// validScheme is a URI scheme (RFC 3986) without the colon
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for i, c := range scheme {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func (s *TwinMakerDataSourceSetting) ToAWSDatasourceSettings() awsds.AWSDatasourceSettings {
 	cfg := awsds.AWSDatasourceSettings{
 		Profile:       s.Profile,
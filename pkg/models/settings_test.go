@@ -0,0 +1,158 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRedactionRules(t *testing.T) {
+	s := TwinMakerDataSourceSetting{RedactionRules: []RedactionRule{{Pattern: "["}}}
+	require.Error(t, s.Validate())
+
+	s.RedactionRules = []RedactionRule{{Pattern: "Operator*", Action: "hide"}}
+	require.Error(t, s.Validate())
+
+	s.RedactionRules = []RedactionRule{{Pattern: "Operator*"}, {Pattern: "Temp*", Action: RedactionDrop}}
+	require.NoError(t, s.Validate())
+}
+
+func TestAllowedWorkspaces(t *testing.T) {
+	s := TwinMakerDataSourceSetting{WorkspaceID: "main"}
+	require.Equal(t, []string{"main"}, s.Workspaces())
+	require.True(t, s.WorkspaceAllowed("other"))
+
+	s.AllowedWorkspaces = []string{"plant-a", "main", "plant-b"}
+	require.Equal(t, []string{"main", "plant-a", "plant-b"}, s.Workspaces())
+	require.True(t, s.WorkspaceAllowed("main"))
+	require.True(t, s.WorkspaceAllowed("plant-b"))
+	require.False(t, s.WorkspaceAllowed("other"))
+}
+
+func TestOrgWorkspaces(t *testing.T) {
+	s := TwinMakerDataSourceSetting{}
+	require.NoError(t, s.Load(backend.DataSourceInstanceSettings{JSONData: []byte(`{
+		"workspaceId": "main",
+		"orgWorkspaces": {"2": "tenant-a", "3": "tenant-b"}
+	}`)}))
+	require.NoError(t, s.Validate())
+	require.Equal(t, "tenant-a", s.WorkspaceForOrg(2))
+	require.Equal(t, "main", s.WorkspaceForOrg(1))
+	require.Equal(t, []string{"tenant-b"}, s.WorkspacesForOrg(3))
+
+	// bound orgs only read their own workspace
+	require.True(t, s.WorkspaceAllowedForOrg(2, "tenant-a"))
+	require.False(t, s.WorkspaceAllowedForOrg(2, "main"))
+	require.False(t, s.WorkspaceAllowedForOrg(2, "tenant-b"))
+	// the other orgs do not read the bound workspaces
+	require.True(t, s.WorkspaceAllowedForOrg(1, "main"))
+	require.True(t, s.WorkspaceAllowedForOrg(1, "other"))
+	require.False(t, s.WorkspaceAllowedForOrg(1, "tenant-a"))
+
+	s.OrgWorkspaces[4] = ""
+	require.Error(t, s.Validate())
+}
+
+func TestProxyOptions(t *testing.T) {
+	s := TwinMakerDataSourceSetting{}
+	require.NoError(t, s.Load(backend.DataSourceInstanceSettings{UID: "twinmaker-uid", JSONData: []byte(`{"workspaceId": "main"}`)}))
+	require.Nil(t, s.ProxyOptions())
+
+	require.NoError(t, s.Load(backend.DataSourceInstanceSettings{
+		UID:                     "twinmaker-uid",
+		JSONData:                []byte(`{"enableSecureSocksProxy": true}`),
+		DecryptedSecureJSONData: map[string]string{"secureSocksProxyPassword": "secret"},
+	}))
+	opts := s.ProxyOptions()
+	require.True(t, opts.Enabled)
+	require.Equal(t, "twinmaker-uid", opts.Auth.Username)
+	require.Equal(t, "secret", opts.Auth.Password)
+
+	s.SecureSocksProxyUsername = "pdc-user"
+	require.Equal(t, "pdc-user", s.ProxyOptions().Auth.Username)
+}
+
+func TestValidateEndpoints(t *testing.T) {
+	s := TwinMakerDataSourceSetting{SiteWiseEndpoint: "https://vpce-0123.iotsitewise.us-east-1.vpce.amazonaws.com"}
+	require.NoError(t, s.Validate())
+
+	s.KinesisVideoEndpoint = "localhost:4566"
+	require.NoError(t, s.Validate())
+
+	s.KinesisVideoEndpoint = "ftp://localhost:4566"
+	require.Error(t, s.Validate())
+
+	s.KinesisVideoEndpoint = "https://"
+	require.Error(t, s.Validate())
+}
+
+func TestValidatePartition(t *testing.T) {
+	require.Equal(t, "aws", RegionPartition("us-east-1"))
+	require.Equal(t, "aws-us-gov", RegionPartition("us-gov-west-1"))
+	require.Equal(t, "aws-cn", RegionPartition("cn-northwest-1"))
+
+	s := TwinMakerDataSourceSetting{}
+	s.Region = "us-gov-west-1"
+	s.AssumeRoleARN = "arn:aws-us-gov:iam::123456789012:role/Dashboard"
+	s.SecondaryRegion = "us-gov-east-1"
+	require.NoError(t, s.Validate())
+
+	s.AssumeRoleARNWriter = "arn:aws:iam::123456789012:role/Writer"
+	require.Error(t, s.Validate())
+
+	s.AssumeRoleARNWriter = ""
+	s.SecondaryRegion = "us-east-1"
+	require.Error(t, s.Validate())
+}
+
+func TestValidateRetries(t *testing.T) {
+	s := TwinMakerDataSourceSetting{MaxRetries: -1}
+	require.NoError(t, s.Validate())
+
+	s = TwinMakerDataSourceSetting{MaxRetries: 8, RetryBaseDelayMs: 100, RetryMaxDelayMs: 5000}
+	require.NoError(t, s.Validate())
+	base, max := s.RetryDelays()
+	require.Equal(t, 100*time.Millisecond, base)
+	require.Equal(t, 5*time.Second, max)
+
+	s.MaxRetries = -2
+	require.Error(t, s.Validate())
+
+	s.MaxRetries = 3
+	s.RetryBaseDelayMs = 10000
+	require.Error(t, s.Validate())
+}
+
+func TestValidateDefaultPageSize(t *testing.T) {
+	s := TwinMakerDataSourceSetting{DefaultPageSize: 100}
+	require.NoError(t, s.Validate())
+	s.DefaultPageSize = -1
+	require.Error(t, s.Validate())
+}
+
+func TestValidateEntityShards(t *testing.T) {
+	s := TwinMakerDataSourceSetting{EntityShards: 4}
+	require.NoError(t, s.Validate())
+	s.EntityShards = -1
+	require.Error(t, s.Validate())
+	s.EntityShards = MaxEntityShards + 1
+	require.Error(t, s.Validate())
+}
+
+func TestValidateMaxHistoryCalls(t *testing.T) {
+	s := TwinMakerDataSourceSetting{MaxHistoryCalls: 10}
+	require.NoError(t, s.Validate())
+	s.MaxHistoryCalls = -1
+	require.Error(t, s.Validate())
+}
+
+func TestValidateLinkSchemes(t *testing.T) {
+	s := TwinMakerDataSourceSetting{LinkSchemes: []string{"https", "s3", "ms-teams"}}
+	require.NoError(t, s.Validate())
+	s.LinkSchemes = []string{"https:"}
+	require.EqualError(t, s.Validate(), `invalid link scheme "https:"`)
+	s.LinkSchemes = []string{"JavaScript"}
+	require.EqualError(t, s.Validate(), `link scheme "JavaScript" is not allowed`)
+}
@@ -2,6 +2,7 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
@@ -34,15 +35,26 @@ func NewTwinMakerInstance(s backend.DataSourceInstanceSettings) (instancemgmt.In
 }
 
 type TwinMakerDatasource struct {
-	settings models.TwinMakerDataSourceSetting
+	*twinmaker.Datasource
+
 	router   *mux.Router
-	client   twinmaker.TwinMakerClient // only used for healthcheck
-	handler  twinmaker.TwinMakerHandler
-	res      twinmaker.TwinMakerResources
 	streamMu sync.RWMutex
 	streams  map[string]models.TwinMakerQuery
+
+	// background work of the instance (watchlist polling, cache warming) stops with ctx on Dispose
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// the last health check, concurrent checks wait for the running one
+	healthMu   sync.Mutex
+	health     *backend.CheckHealthResult
+	healthTime time.Time
 }
 
+// healthCheckTTL is how long a health check result is reused, provisioning and the config page
+// tend to check many times in a row
+const healthCheckTTL = 10 * time.Second
+
 // Make sure TwinMakerDatasource implements required interfaces.
 // This is important to do since otherwise we will only get a
 // not implemented error response from plugin in runtime.
@@ -56,37 +68,68 @@ var (
 
 // NewTwinMakerDatasource creates a new datasource instance.
 func NewTwinMakerDatasource(settings models.TwinMakerDataSourceSetting) *TwinMakerDatasource {
-	c, err := twinmaker.NewTwinMakerClient(settings)
+	// the clients of all roles share the request rate of the instance
+	rate := twinmaker.NewRateLimiter()
+	c, err := twinmaker.NewTwinMakerClient(settings, rate)
 	if err != nil {
 		backend.Logger.Error("Error initializing TwinMakerTokenProvider", "err", err)
 		return nil
 	}
 
-	// Caching the frame results -- not twinmaker raw results
-	// cached := twinmaker.NewCachingClient(c, 30*time.Minute)
+	ds := newTwinMakerDatasource(settings, c)
+	viewer, err := twinmaker.NewViewerClient(settings, rate)
+	if err != nil {
+		backend.Logger.Error("Error initializing the viewer role client", "err", err)
+		return nil
+	}
+	if viewer != nil {
+		ds.SetViewerClient(viewer)
+	}
+	ds.SetQueryRoleClients(func(roleArn string) (twinmaker.TwinMakerClient, error) {
+		return twinmaker.NewQueryRoleClient(settings, roleArn, rate)
+	})
+	// instances are created when the datasource is saved, so the first dashboard finds a warm cache
+	go ds.WarmCache(ds.ctx)
+	return ds
+}
 
-	return newTwinMakerDatasource(settings, c)
+// anonymous users (e.g. kiosk displays) have no login, requests without a user such as alert
+// evaluations keep the primary role
+func anonymous(user *backend.User) bool {
+	return user != nil && user.Login == ""
 }
 
 func newTwinMakerDatasource(settings models.TwinMakerDataSourceSetting, c twinmaker.TwinMakerClient) *TwinMakerDatasource {
-	ttl := 30 * time.Minute
-	cachingClient := twinmaker.NewCachingClient(c, ttl)
-
 	r := mux.NewRouter()
+	ctx, cancel := context.WithCancel(context.Background())
 	ds := &TwinMakerDatasource{
-		settings: settings,
-		client:   c,
-		router:   r,
-		handler:  twinmaker.NewTwinMakerHandler(cachingClient),
-		streams:  make(map[string]models.TwinMakerQuery),
-
-		// Since the whole result is cached, this does not use the cached client
-		res: twinmaker.NewCachingResource(
-			twinmaker.NewTwinMakerResource(c, settings.WorkspaceID),
-			ttl),
+		Datasource: twinmaker.NewDatasourceWithClient(settings, c),
+		router:     r,
+		streams:    make(map[string]models.TwinMakerQuery),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
+	go ds.Watchlist.Run(ctx)
+
 	r.HandleFunc("/token", ds.HandleGetToken)
+	r.HandleFunc("/default-query", ds.HandleGetDefaultQuery)
+	r.HandleFunc("/workspaces/validate", ds.HandleValidateWorkspaces)
 	r.HandleFunc("/entity-properties", ds.HandleBatchPutPropertyValues)
+	r.HandleFunc("/entities/tags", ds.HandleEntityTags)
+	r.HandleFunc("/external-ids/invalidate", ds.HandleInvalidateExternalIds)
+	r.HandleFunc("/alarms/acknowledge", ds.HandleAcknowledgeAlarms)
+	r.HandleFunc("/alarms/snooze", ds.HandleSnoozeAlarms)
+	r.HandleFunc("/alarms/export", ds.HandleExportAlarmHistory)
+	r.HandleFunc("/alarms/summary", ds.HandleAlarmSummary)
+	r.HandleFunc("/annotations", ds.HandleWriteAnnotation)
+	r.HandleFunc("/watchlist", ds.HandleWatchlist)
+	r.HandleFunc("/favorites", ds.HandleFavorites)
+	r.HandleFunc("/scene/rules", ds.HandleEvaluateSceneRules)
+	r.HandleFunc("/scene/assets", ds.HandleUploadSceneAsset)
+	r.HandleFunc("/bootstrap/demo", ds.HandleDemoWorkspace)
+	r.HandleFunc("/estimate", ds.HandleEstimate)
+	r.HandleFunc("/debug/bundle", ds.HandleDebugBundle)
+	r.HandleFunc("/openapi.json", HandleOpenAPI)
 
 	// they are now cached depending on the res set in the ds above
 	r.HandleFunc("/entity", ds.HandleGetEntity)
@@ -94,6 +137,14 @@ func newTwinMakerDatasource(settings models.TwinMakerDataSourceSetting, c twinma
 	r.HandleFunc("/list/scenes", ds.HandleListScenes)
 	r.HandleFunc("/list/options", ds.HandleListOptions)
 	r.HandleFunc("/list/entity", ds.HandleListEntityOptions)
+	r.HandleFunc("/variables/entities", ds.HandleVariableOptions)
+	r.HandleFunc("/variables/components", ds.HandleVariableOptions)
+	r.HandleFunc("/variables/properties", ds.HandleVariableOptions)
+	r.HandleFunc("/variables/componentTypes", ds.HandleVariableOptions)
+
+	// paginated, not cached
+	r.HandleFunc("/entities", ds.HandleListEntitiesPage)
+	r.HandleFunc("/component-types", ds.HandleListComponentTypesPage)
 	return ds
 }
 
@@ -102,12 +153,19 @@ func newTwinMakerDatasource(settings models.TwinMakerDataSourceSetting, c twinma
 // by SDK old datasource instance will be disposed and a new one will be created
 // using NewTwinMakerDatasource factory function.
 func (ds *TwinMakerDatasource) Dispose() {
-	// Nothing to clean up yet.
-	backend.Logger.Info("Called when the settings change", "cfg", ds.settings)
+	ds.cancel()
+	if err := ds.Close(); err != nil {
+		backend.Logger.Warn("could not close the metadata cache", "err", err)
+	}
+	backend.Logger.Info("Called when the settings change", "cfg", ds.Settings)
 }
 
 func (ds *TwinMakerDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	response := backend.NewQueryDataResponse()
+	ctx = twinmaker.WithOrg(ctx, req.PluginContext.OrgID)
+	if anonymous(req.PluginContext.User) {
+		ctx = twinmaker.WithViewerRole(ctx)
+	}
 
 	for _, q := range req.Queries {
 		query, err := models.ReadQuery(q)
@@ -123,6 +181,7 @@ func (ds *TwinMakerDatasource) QueryData(ctx context.Context, req *backend.Query
 			response.Responses[q.RefID] = res
 			continue
 		}
+		keepResolutionSnapshot(&query, res)
 
 		// if the results are paged, save the next token in the query
 		// so that RunStream can start from the next page
@@ -130,9 +189,26 @@ func (ds *TwinMakerDatasource) QueryData(ctx context.Context, req *backend.Query
 			query.NextToken = customMeta.NextToken
 		}
 
+		// property streams use the shared channel of the property instead of continuing the query.
+		// It is polled with the primary role, so anonymous queries stream like other queries.
+		if query.GrafanaLiveEnabled && query.PropertyStream && !query.Preview && len(res.Frames) > 0 && !anonymous(req.PluginContext.User) {
+			if query.WorkspaceId == "" {
+				query.WorkspaceId = ds.Settings.WorkspaceForOrg(req.PluginContext.OrgID)
+			}
+			if path, ok := historyStreamPath(query); ok {
+				if res.Frames[0].Meta == nil {
+					res.Frames[0].Meta = &data.FrameMeta{}
+				}
+				res.Frames[0].Meta.Channel = fmt.Sprintf("ds/%s/%s", ds.Settings.UID, path)
+				response.Responses[q.RefID] = res
+				continue
+			}
+		}
+
 		// we don't need to continue if Live is disabled, the query is not streaming updates,
-		// or if the result is empty.
-		if !query.GrafanaLiveEnabled || (query.NextToken == "" && !query.IsStreaming) || len(res.Frames) == 0 {
+		// or if the result is empty. Append queries request the next page themselves and previews
+		// are never continued.
+		if !query.GrafanaLiveEnabled || ((query.NextToken == "" || query.Append) && !query.IsStreaming) || query.Preview || len(res.Frames) == 0 {
 			response.Responses[q.RefID] = res
 			continue
 		}
@@ -142,7 +218,7 @@ func (ds *TwinMakerDatasource) QueryData(ctx context.Context, req *backend.Query
 		if res.Frames[0].Meta == nil {
 			res.Frames[0].Meta = &data.FrameMeta{}
 		}
-		res.Frames[0].Meta.Channel = fmt.Sprintf("ds/%s/%s", ds.settings.UID, queryUID)
+		res.Frames[0].Meta.Channel = fmt.Sprintf("ds/%s/%s", ds.Settings.UID, queryUID)
 		response.Responses[q.RefID] = res
 
 		// set the new time range for the first streaming request
@@ -163,21 +239,41 @@ func (ds *TwinMakerDatasource) QueryData(ctx context.Context, req *backend.Query
 }
 
 func (ds *TwinMakerDatasource) CheckHealth(ctx context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	if ds.settings.WorkspaceID == "" {
+	ds.healthMu.Lock()
+	defer ds.healthMu.Unlock()
+	if ds.health != nil && time.Since(ds.healthTime) < healthCheckTTL {
+		return ds.health, nil
+	}
+
+	res, err := ds.checkHealth(twinmaker.WithFeature(ctx, "CheckHealth"))
+	if res != nil {
+		// the user agent lets AWS support and CloudTrail find the calls of the plugin
+		res.JSONDetails, _ = json.Marshal(map[string]string{"userAgent": twinmaker.UserAgent()})
+	}
+	// a cancelled check says nothing about the configuration
+	if err == nil && ctx.Err() == nil {
+		ds.health = res
+		ds.healthTime = time.Now()
+	}
+	return res, err
+}
+
+func (ds *TwinMakerDatasource) checkHealth(ctx context.Context) (*backend.CheckHealthResult, error) {
+	if ds.Settings.WorkspaceID == "" {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
 			Message: "Missing WorkspaceID configuration",
 		}, nil
 	}
 	// TODO: add in changelog
-	if ds.settings.AssumeRoleARN == "" {
+	if ds.Settings.AssumeRoleARN == "" {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
 			Message: "Assume Role ARN is required",
 		}, nil
 	}
 
-	_, err := ds.handler.GetSessionToken(ctx, time.Second*3600, ds.settings.WorkspaceID)
+	_, err := ds.Handler.GetSessionToken(ctx, time.Second*3600, ds.Settings.WorkspaceID)
 	if err != nil {
 		awsErr, ok := err.(awserr.Error)
 		if ok {
@@ -192,8 +288,8 @@ func (ds *TwinMakerDatasource) CheckHealth(ctx context.Context, _ *backend.Check
 		}, nil
 	}
 
-	res, err := ds.client.GetWorkspace(ctx, models.TwinMakerQuery{
-		WorkspaceId: ds.settings.WorkspaceID,
+	res, err := ds.Client.GetWorkspace(ctx, models.TwinMakerQuery{
+		WorkspaceId: ds.Settings.WorkspaceID,
 	})
 	if err != nil {
 		awsErr, ok := err.(awserr.Error)
@@ -216,8 +312,8 @@ func (ds *TwinMakerDatasource) CheckHealth(ctx context.Context, _ *backend.Check
 		workspace = *res.WorkspaceId
 	}
 
-	if ds.settings.AssumeRoleARNWriter != "" {
-		_, err := ds.handler.GetWriteSessionToken(ctx, time.Second*3600, ds.settings.WorkspaceID)
+	if ds.Settings.AssumeRoleARNWriter != "" {
+		_, err := ds.Handler.GetWriteSessionToken(ctx, time.Second*3600, ds.Settings.WorkspaceID)
 		if err != nil {
 			awsErr, ok := err.(awserr.Error)
 			if ok {
@@ -241,6 +337,18 @@ func (ds *TwinMakerDatasource) CheckHealth(ctx context.Context, _ *backend.Check
 
 func (ds *TwinMakerDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
 	status := backend.SubscribeStreamStatusNotFound
+	if sceneId, ok := sceneStreamId(req.Path); ok && sceneId != "" {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusOK,
+		}, nil
+	}
+
+	// the shared property channels are polled with the primary role
+	if query, ok := historyStreamQuery(req.Path); ok && !anonymous(req.PluginContext.User) && ds.Settings.WorkspaceAllowedForOrg(req.PluginContext.OrgID, query.WorkspaceId) {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusOK,
+		}, nil
+	}
 
 	ds.streamMu.RLock()
 	if _, ok := ds.streams[req.Path]; ok {
@@ -256,6 +364,28 @@ func (ds *TwinMakerDatasource) SubscribeStream(_ context.Context, req *backend.S
 func (ds *TwinMakerDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	ctx = twinmaker.WithOrg(ctx, req.PluginContext.OrgID)
+	if anonymous(req.PluginContext.User) {
+		ctx = twinmaker.WithViewerRole(ctx)
+	}
+
+	if sceneId, ok := sceneStreamId(req.Path); ok {
+		frames, err := newFrameSender(sender, req.Data)
+		if err != nil {
+			return err
+		}
+		defer frames.Close()
+		return ds.runSceneStream(twinmaker.WithFeature(ctx, "scene-stream"), sceneId, frames)
+	}
+
+	if query, ok := historyStreamQuery(req.Path); ok {
+		frames, err := newFrameSender(sender, req.Data)
+		if err != nil {
+			return err
+		}
+		defer frames.Close()
+		return ds.runHistoryStream(twinmaker.WithFeature(ctx, "history-stream"), query, frames)
+	}
 
 	ds.streamMu.Lock()
 	query, ok := ds.streams[req.Path]
@@ -266,6 +396,12 @@ func (ds *TwinMakerDatasource) RunStream(ctx context.Context, req *backend.RunSt
 	delete(ds.streams, req.Path)
 	ds.streamMu.Unlock()
 
+	frames, err := newFrameSender(sender, req.Data)
+	if err != nil {
+		return err
+	}
+	defer frames.Close()
+
 	resChannel := make(chan *backend.DataResponse)
 	go ds.RequestLoop(ctx, query, resChannel)
 
@@ -281,7 +417,7 @@ func (ds *TwinMakerDatasource) RunStream(ctx context.Context, req *backend.RunSt
 				return res.Error
 			}
 			for _, frame := range res.Frames {
-				if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				if err := frames.SendFrame(frame, data.IncludeAll); err != nil {
 					return err
 				}
 			}
@@ -296,33 +432,23 @@ func (ds *TwinMakerDatasource) PublishStream(_ context.Context, _ *backend.Publi
 }
 
 func (ds *TwinMakerDatasource) DoQuery(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
-	response := backend.DataResponse{}
-
-	// set the default datasource WorkspaceId if missing in the query
-	if query.WorkspaceId == "" {
-		query.WorkspaceId = ds.settings.WorkspaceID
-	}
-
-	switch query.QueryType {
-	case models.QueryTypeListWorkspace:
-		return ds.handler.ListWorkspaces(ctx, query)
-	case models.QueryTypeListScenes:
-		return ds.handler.ListScenes(ctx, query)
-	case models.QueryTypeListEntities:
-		return ds.handler.ListEntities(ctx, query)
-	case models.QueryTypeGetEntity:
-		return ds.handler.GetEntity(ctx, query)
-	case models.QueryTypeGetPropertyValue:
-		return ds.handler.GetPropertyValue(ctx, query)
-	case models.QueryTypeEntityHistory:
-		return ds.handler.GetEntityHistory(ctx, query)
-	case models.QueryTypeComponentHistory:
-		return ds.handler.GetComponentHistory(ctx, query)
-	case models.QueryTypeGetAlarms:
-		return ds.handler.GetAlarms(ctx, query)
-	}
-
-	return response
+	return ds.Query(ctx, query)
+}
+
+// keepResolutionSnapshot makes the next pages and stream updates of a pinned query resolve from
+// the snapshot of its response
+func keepResolutionSnapshot(query *models.TwinMakerQuery, res backend.DataResponse) {
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			continue
+		}
+		if meta, ok := frame.Meta.Custom.(models.TwinMakerCustomMeta); ok && meta.ResolutionSnapshot != "" {
+			if t, err := time.Parse(time.RFC3339, meta.ResolutionSnapshot); err == nil {
+				query.ResolutionSnapshot = &models.QueryTime{Time: t}
+			}
+			return
+		}
+	}
 }
 
 func (ds *TwinMakerDatasource) RequestLoop(ctx context.Context, query models.TwinMakerQuery, resChannel chan *backend.DataResponse) {
@@ -340,6 +466,7 @@ func (ds *TwinMakerDatasource) RequestLoop(ctx context.Context, query models.Twi
 		resChannel <- nil
 		return
 	}
+	keepResolutionSnapshot(&query, res)
 
 	customMeta := models.LoadMetaFromResponse(res)
 	// if the results are paged, request the next page
@@ -374,6 +501,11 @@ func (d *TwinMakerDatasource) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 // CallResource HTTP style resource
 func (ds *TwinMakerDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	ctx = twinmaker.WithFeature(ctx, "resource/"+req.Path)
+	ctx = twinmaker.WithOrg(ctx, req.PluginContext.OrgID)
+	if anonymous(req.PluginContext.User) {
+		ctx = twinmaker.WithViewerRole(ctx)
+	}
 	return httpadapter.New(ds).CallResource(ctx, req, sender)
 }
 
@@ -386,20 +518,23 @@ func getFromTimestamp(res backend.DataResponse) *time.Time {
 				continue
 			}
 
-			ts := time.Unix(0, 0)
-			switch field.Type() {
-			case data.FieldTypeTime:
-				if t, ok := field.At(field.Len() - 1).(time.Time); ok {
-					ts = t
+			// the latest value is the last row, or the first one of descending results
+			for _, i := range []int{0, field.Len() - 1} {
+				ts := time.Unix(0, 0)
+				switch field.Type() {
+				case data.FieldTypeTime:
+					if t, ok := field.At(i).(time.Time); ok {
+						ts = t
+					}
+				case data.FieldTypeNullableTime:
+					if t, ok := field.At(i).(*time.Time); ok && t != nil {
+						ts = *t
+					}
 				}
-			case data.FieldTypeNullableTime:
-				if t, ok := field.At(field.Len() - 1).(*time.Time); ok && t != nil {
-					ts = *t
-				}
-			}
 
-			if lastTimestamp == nil || ts.After(*lastTimestamp) {
-				lastTimestamp = &ts
+				if lastTimestamp == nil || ts.After(*lastTimestamp) {
+					lastTimestamp = &ts
+				}
 			}
 		}
 	}
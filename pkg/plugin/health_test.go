@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/plugin/twinmaker"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type healthMockClient struct {
+	twinmaker.TwinMakerClient
+	calls int32
+}
+
+func (c *healthMockClient) GetSessionToken(ctx context.Context, duration time.Duration, workspaceId string) (*sts.Credentials, error) {
+	atomic.AddInt32(&c.calls, 1)
+	// slow enough for the checks to overlap
+	time.Sleep(10 * time.Millisecond)
+	return &sts.Credentials{AccessKeyId: aws.String("key")}, nil
+}
+
+func (c *healthMockClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
+	return &iottwinmaker.GetWorkspaceOutput{WorkspaceId: aws.String(query.WorkspaceId)}, nil
+}
+
+func TestCheckHealthCached(t *testing.T) {
+	mock, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	c := &healthMockClient{TwinMakerClient: mock}
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{
+		AWSDatasourceSettings: awsds.AWSDatasourceSettings{AssumeRoleARN: "arn:aws:iam::123456789012:role/dashboard"},
+		WorkspaceID:           "w",
+	}, c)
+	defer ds.Dispose()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			require.NoError(t, err)
+			require.Equal(t, backend.HealthStatusOk, res.Status)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&c.calls))
+
+	// the details show the user agent of the AWS calls
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"userAgent": %q}`, twinmaker.UserAgent()), string(res.JSONDetails))
+
+	// expired results are checked again
+	ds.healthTime = time.Now().Add(-healthCheckTTL)
+	_, err = ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&c.calls))
+
+	// a cancelled check is not kept
+	ds.healthTime = time.Now().Add(-healthCheckTTL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ds.CheckHealth(ctx, &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	_, err = ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int32(4), atomic.LoadInt32(&c.calls))
+}
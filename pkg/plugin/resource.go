@@ -1,15 +1,32 @@
 package plugin
 
 import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/plugin/twinmaker"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 )
 
+// openAPISpec describes the routes registered in newTwinMakerDatasource, keep them in sync
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 func writeJsonResponse(w http.ResponseWriter, rsp interface{}, err error) {
 	w.Header().Add("Content-Type", "application/json")
 
@@ -21,16 +38,196 @@ func writeJsonResponse(w http.ResponseWriter, rsp interface{}, err error) {
 	}
 }
 
+// writeDigestResponse is writeJsonResponse with an ETag digest of the body. Pollers that send the
+// digest back in If-None-Match get 304 Not Modified without the body while the result is unchanged.
+func writeDigestResponse(w http.ResponseWriter, r *http.Request, rsp interface{}, err error) {
+	writeCachedResponse(w, r, rsp, err, "no-cache")
+}
+
+// writeCachedResponse is writeDigestResponse with the Cache-Control header of the caller
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, rsp interface{}, err error, cacheControl string) {
+	if err != nil {
+		writeJsonResponse(w, rsp, err)
+		return
+	}
+	body, err := json.Marshal(rsp)
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	digest := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// HandleOpenAPI serves the OpenAPI document of the resource API
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(openAPISpec)
+}
+
 func (ds *TwinMakerDatasource) HandleGetToken(w http.ResponseWriter, r *http.Request) {
-	if ds.settings.AssumeRoleARN == "" {
+	if ds.Settings.AssumeRoleARN == "" {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(`{"message": "Assume Role ARN is missing in datasource configuration"}`))
 		return
 	}
-	token, err := ds.handler.GetSessionToken(r.Context(), time.Second*3600, ds.settings.WorkspaceID)
+	ctx := r.Context()
+	token, err := ds.HandlerFor(ctx).GetSessionToken(ctx, time.Second*3600, ds.Settings.WorkspaceForOrg(twinmaker.OrgFrom(ctx)))
 	writeJsonResponse(w, token, err)
 }
 
+// HandleValidateWorkspaces checks the access to the datasource workspace and the other allowed
+// workspaces concurrently, or to the workspaceId params when set, and reports it per workspace
+func (ds *TwinMakerDatasource) HandleValidateWorkspaces(w http.ResponseWriter, r *http.Request) {
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Role != "Admin" {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "validating workspaces needs the admin role"}`))
+		return
+	}
+
+	org := twinmaker.OrgFrom(r.Context())
+	workspaces := ds.Settings.WorkspacesForOrg(org)
+	if ids := r.URL.Query()["workspaceId"]; len(ids) > 0 {
+		for _, id := range ids {
+			if !ds.Settings.WorkspaceAllowedForOrg(org, id) {
+				writeJsonResponse(w, nil, fmt.Errorf("workspace %s is not configured", id))
+				return
+			}
+		}
+		workspaces = ids
+	}
+	if len(workspaces) == 0 {
+		writeJsonResponse(w, nil, fmt.Errorf("no workspace is configured"))
+		return
+	}
+
+	rsp := struct {
+		Workspaces []models.WorkspaceCheck `json:"workspaces"`
+	}{ds.ValidateWorkspaces(r.Context(), workspaces)}
+	writeJsonResponse(w, rsp, nil)
+}
+
+// HandleAlarmSummary counts the alarms of the configured workspaces, or of the workspaceId params,
+// by their latest status, as seen by the request role or the roleArn param. The counts are cached briefly and sent with cache headers, so status
+// pages and chat bots can poll it cheaply through the Grafana API.
+func (ds *TwinMakerDatasource) HandleAlarmSummary(w http.ResponseWriter, r *http.Request) {
+	org := twinmaker.OrgFrom(r.Context())
+	workspaces := ds.Settings.WorkspacesForOrg(org)
+	if ids := r.URL.Query()["workspaceId"]; len(ids) > 0 {
+		for _, id := range ids {
+			if !ds.Settings.WorkspaceAllowedForOrg(org, id) {
+				writeJsonResponse(w, nil, fmt.Errorf("workspace %s is not configured", id))
+				return
+			}
+		}
+		workspaces = ids
+	}
+	if len(workspaces) == 0 {
+		writeJsonResponse(w, nil, fmt.Errorf("no workspace is configured"))
+		return
+	}
+
+	ctx := r.Context()
+	summaries, err := ds.AlarmSummaries(ctx, workspaces, r.URL.Query().Get("roleArn"))
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	rsp := struct {
+		Workspaces []models.AlarmSummary `json:"workspaces"`
+	}{summaries}
+	writeCachedResponse(w, r, rsp, nil, fmt.Sprintf("private, max-age=%d", int(twinmaker.AlarmSummaryTTL.Seconds())))
+}
+
+// HandleInvalidateExternalIds drops the resolved externalIds of the workspaceId param, or of all
+// workspaces without it, so the next component history queries look them up again. Orgs bound to
+// a workspace only drop the ones of their workspace. Only editors and admins can use it.
+func (ds *TwinMakerDatasource) HandleInvalidateExternalIds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"message": "invalidating externalIds needs a POST request"}`))
+		return
+	}
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || (user.Role != "Admin" && user.Role != "Editor") {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "invalidating externalIds needs the editor or admin role"}`))
+		return
+	}
+	org := twinmaker.OrgFrom(r.Context())
+	workspaceId := r.URL.Query().Get("workspaceId")
+	if workspaceId != "" && !ds.Settings.WorkspaceAllowedForOrg(org, workspaceId) {
+		writeJsonResponse(w, nil, fmt.Errorf("workspace %s is not configured", workspaceId))
+		return
+	}
+	if bound, ok := ds.Settings.OrgWorkspaces[org]; ok {
+		workspaceId = bound
+	}
+	rsp := struct {
+		Invalidated int `json:"invalidated"`
+	}{ds.InvalidateExternalIds(workspaceId)}
+	writeJsonResponse(w, rsp, nil)
+}
+
+// HandleEntityTags reads the resource tags of the entityId parameters, POST applies the tag updates
+// of the body. Only admins can use it, updates also need entity tag editing to be enabled.
+func (ds *TwinMakerDatasource) HandleEntityTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Role != "Admin" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "entity tags need the admin role"}`))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		ids := r.URL.Query()["entityId"]
+		if len(ids) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "missing entityId"}`))
+			return
+		}
+		tags, err := ds.ResourcesFor(r.Context()).GetEntityTags(r.Context(), ids)
+		writeJsonResponse(w, map[string]interface{}{"entities": tags}, err)
+		return
+	}
+
+	if !ds.Settings.EntityTagEditing {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "entity tag editing is not enabled in datasource configuration"}`))
+		return
+	}
+	req := struct {
+		Updates []models.EntityTagUpdate `json:"updates"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).UpdateEntityTags(r.Context(), req.Updates)
+	writeJsonResponse(w, rsp, err)
+}
+
+func (ds *TwinMakerDatasource) HandleGetDefaultQuery(w http.ResponseWriter, r *http.Request) {
+	writeJsonResponse(w, ds.Settings.GetDefaultQuery(), nil)
+}
+
 func (ds *TwinMakerDatasource) HandleGetEntity(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	params := r.URL.Query()
@@ -41,23 +238,34 @@ func (ds *TwinMakerDatasource) HandleGetEntity(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	rsp, err := ds.res.GetEntity(r.Context(), entityId)
+	rsp, err := ds.ResourcesFor(r.Context()).GetEntity(r.Context(), entityId)
 	writeJsonResponse(w, rsp, err)
 }
 
+// HandleListWorkspaces lists the workspaces of the account, only its own one for an org bound to
+// a workspace
 func (ds *TwinMakerDatasource) HandleListWorkspaces(w http.ResponseWriter, r *http.Request) {
-	rsp, err := ds.res.ListWorkspaces(r.Context())
+	rsp, err := ds.ResourcesFor(r.Context()).ListWorkspaces(r.Context())
+	if bound, ok := ds.Settings.OrgWorkspaces[twinmaker.OrgFrom(r.Context())]; ok && err == nil {
+		workspaces := []models.SelectableString{}
+		for _, workspace := range rsp {
+			if workspace.Value == bound {
+				workspaces = append(workspaces, workspace)
+			}
+		}
+		rsp = workspaces
+	}
 	writeJsonResponse(w, rsp, err)
 }
 
 func (ds *TwinMakerDatasource) HandleListScenes(w http.ResponseWriter, r *http.Request) {
-	rsp, err := ds.res.ListScenes(r.Context())
+	rsp, err := ds.ResourcesFor(r.Context()).ListScenes(r.Context())
 	writeJsonResponse(w, rsp, err)
 }
 
 func (ds *TwinMakerDatasource) HandleListOptions(w http.ResponseWriter, r *http.Request) {
-	rsp, err := ds.res.ListOptions(r.Context())
-	writeJsonResponse(w, rsp, err)
+	rsp, err := ds.ResourcesFor(r.Context()).ListOptions(r.Context())
+	writeDigestResponse(w, r, rsp, err)
 }
 
 func (ds *TwinMakerDatasource) HandleListEntityOptions(w http.ResponseWriter, r *http.Request) {
@@ -69,13 +277,193 @@ func (ds *TwinMakerDatasource) HandleListEntityOptions(w http.ResponseWriter, r
 		return
 	}
 
-	rsp, err := ds.res.ListEntity(r.Context(), entityId)
+	rsp, err := ds.ResourcesFor(r.Context()).ListEntity(r.Context(), entityId)
+	writeJsonResponse(w, rsp, err)
+}
+
+// HandleVariableOptions lists the {text, value} options of the variable kind in the last path
+// segment, the workspaceId, entityId, componentName and componentTypeId params are the values of
+// the parent variables
+func (ds *TwinMakerDatasource) HandleVariableOptions(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	parents := models.VariableParents{
+		WorkspaceId:     params.Get("workspaceId"),
+		EntityId:        params.Get("entityId"),
+		ComponentName:   params.Get("componentName"),
+		ComponentTypeId: params.Get("componentTypeId"),
+	}
+	if parents.WorkspaceId != "" && !ds.Settings.WorkspaceAllowedForOrg(twinmaker.OrgFrom(r.Context()), parents.WorkspaceId) {
+		writeJsonResponse(w, nil, fmt.Errorf("workspace %s is not configured", parents.WorkspaceId))
+		return
+	}
+
+	rsp, err := ds.ResourcesFor(r.Context()).ListVariableOptions(r.Context(), path.Base(r.URL.Path), parents)
+	writeJsonResponse(w, rsp, err)
+}
+
+func (ds *TwinMakerDatasource) HandleEvaluateSceneRules(w http.ResponseWriter, r *http.Request) {
+	sceneId := r.URL.Query().Get("id")
+	if sceneId == "" {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "missing id (scene)"}`))
+		return
+	}
+
+	rsp, err := ds.ResourcesFor(r.Context()).EvaluateSceneRules(r.Context(), sceneId)
+	writeJsonResponse(w, rsp, err)
+}
+
+// HandleUploadSceneAsset stores the request body as a glb/gltf model in the workspace bucket,
+// the file name is the name parameter. Only editors and admins can upload.
+func (ds *TwinMakerDatasource) HandleUploadSceneAsset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"message": "asset uploads use POST"}`))
+		return
+	}
+	if !ds.Settings.SceneAssetUploads {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "scene asset uploads are not enabled in datasource configuration"}`))
+		return
+	}
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || (user.Role != "Admin" && user.Role != "Editor") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "scene asset uploads need the editor or admin role"}`))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "missing name (asset)"}`))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, twinmaker.MaxSceneAssetSize+1))
+	if err != nil {
+		log.DefaultLogger.Error("failed to read request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to read request body"}`))
+		return
+	}
+	if len(body) > twinmaker.MaxSceneAssetSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"message": "asset is larger than %d bytes"}`, twinmaker.MaxSceneAssetSize)))
+		return
+	}
+
+	rsp, err := ds.ResourcesFor(r.Context()).UploadSceneAsset(r.Context(), name, body)
+	writeJsonResponse(w, rsp, err)
+}
+
+// HandleDemoWorkspace returns the resources of the demo workspace, and creates them on POST.
+// Creating is an explicit admin action that needs the writer role.
+func (ds *TwinMakerDatasource) HandleDemoWorkspace(w http.ResponseWriter, r *http.Request) {
+	req := models.DemoWorkspaceRequest{}
+	if r.Method == http.MethodGet {
+		params := r.URL.Query()
+		req.WorkspaceId = params.Get("workspaceId")
+		req.S3Location = params.Get("s3Location")
+		req.Role = params.Get("role")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+	if req.WorkspaceId == "" {
+		req.WorkspaceId = twinmaker.DefaultDemoWorkspaceId
+	}
+
+	if r.Method == http.MethodGet {
+		rsp, err := ds.ResourcesFor(r.Context()).PlanDemoWorkspace(req)
+		writeJsonResponse(w, rsp, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Role != "Admin" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "creating the demo workspace needs the admin role"}`))
+		return
+	}
+	if ds.Settings.AssumeRoleARNWriter == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "Assume Role ARN Write is missing in datasource configuration"}`))
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).CreateDemoWorkspace(r.Context(), req)
+	writeJsonResponse(w, rsp, err)
+}
+
+func readPageParams(r *http.Request) (cursor string, maxResults int, err error) {
+	params := r.URL.Query()
+	cursor = params.Get("nextToken")
+	if v := params.Get("maxResults"); v != "" {
+		maxResults, err = strconv.Atoi(v)
+		if err != nil || maxResults < 1 || maxResults > 200 {
+			return cursor, 0, fmt.Errorf("maxResults must be between 1 and 200")
+		}
+	}
+	return cursor, maxResults, nil
+}
+
+func (ds *TwinMakerDatasource) HandleListEntitiesPage(w http.ResponseWriter, r *http.Request) {
+	cursor, maxResults, err := readPageParams(r)
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).ListEntitiesPage(r.Context(), cursor, maxResults)
+	writeDigestResponse(w, r, rsp, err)
+}
+
+func (ds *TwinMakerDatasource) HandleListComponentTypesPage(w http.ResponseWriter, r *http.Request) {
+	cursor, maxResults, err := readPageParams(r)
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).ListComponentTypesPage(r.Context(), cursor, maxResults)
 	writeJsonResponse(w, rsp, err)
 }
 
 func (ds *TwinMakerDatasource) HandleBatchPutPropertyValues(w http.ResponseWriter, r *http.Request) {
 	req := struct {
-		Entries []*iottwinmaker.PropertyValueEntry `json:"entries"`
+		Entries     []*iottwinmaker.PropertyValueEntry `json:"entries"`
+		WaitVisible bool                               `json:"waitVisible"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).BatchPutPropertyValues(r.Context(), req.Entries)
+	if err == nil {
+		ds.afterWrite(r.Context(), w, req.WaitVisible, succeededEntries(req.Entries, rsp))
+	}
+	writeJsonResponse(w, rsp, err)
+}
+
+// HandleAcknowledgeAlarms acknowledges the alarms of the body with the write role. Only editors and
+// admins can use it.
+func (ds *TwinMakerDatasource) HandleAcknowledgeAlarms(w http.ResponseWriter, r *http.Request) {
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || (user.Role != "Admin" && user.Role != "Editor") {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "acknowledging alarms needs the editor or admin role"}`))
+		return
+	}
+	req := struct {
+		Alarms      []models.AlarmReference `json:"alarms"`
+		WaitVisible bool                    `json:"waitVisible"`
 	}{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -84,6 +472,350 @@ func (ds *TwinMakerDatasource) HandleBatchPutPropertyValues(w http.ResponseWrite
 		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
 		return
 	}
-	rsp, err := ds.res.BatchPutPropertyValues(r.Context(), req.Entries)
+	rsp, err := ds.ResourcesFor(r.Context()).AcknowledgeAlarms(r.Context(), req.Alarms)
+	if err == nil {
+		ds.afterWrite(r.Context(), w, req.WaitVisible, twinmaker.AlarmStatusEntries(rsp.Acknowledged, "ACKNOWLEDGED"))
+	}
+	writeJsonResponse(w, rsp, err)
+}
+
+// HandleSnoozeAlarms snoozes the alarms of the body in IoT Events with the write role. Only editors
+// and admins can use it.
+func (ds *TwinMakerDatasource) HandleSnoozeAlarms(w http.ResponseWriter, r *http.Request) {
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || (user.Role != "Admin" && user.Role != "Editor") {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "snoozing alarms needs the editor or admin role"}`))
+		return
+	}
+	req := struct {
+		Alarms        []models.AlarmReference `json:"alarms"`
+		SnoozeSeconds int64                   `json:"snoozeSeconds"`
+		WaitVisible   bool                    `json:"waitVisible"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).SnoozeAlarms(r.Context(), req.Alarms, time.Duration(req.SnoozeSeconds)*time.Second)
+	if err == nil {
+		// the alarm model syncs the snoozed status back to TwinMaker
+		ds.afterWrite(r.Context(), w, req.WaitVisible, twinmaker.AlarmStatusEntries(rsp.Snoozed, "SNOOZE_DISABLED"))
+	}
+	writeJsonResponse(w, rsp, err)
+}
+
+// afterWrite makes the next reads reflect a successful write. With waitVisible the response waits
+// until the written values are read back, the X-Write-Visible header reports whether they were in
+// time. The cached reads are dropped afterwards, so the refreshed watchlist sees the new values.
+func (ds *TwinMakerDatasource) afterWrite(ctx context.Context, w http.ResponseWriter, waitVisible bool, entries []*iottwinmaker.PropertyValueEntry) {
+	if waitVisible {
+		w.Header().Set("X-Write-Visible", strconv.FormatBool(ds.AwaitWrites(ctx, entries)))
+	}
+	ds.InvalidateWrites(ctx)
+}
+
+// succeededEntries drops the entries the write reported as failed
+func succeededEntries(entries []*iottwinmaker.PropertyValueEntry, rsp *iottwinmaker.BatchPutPropertyValuesOutput) []*iottwinmaker.PropertyValueEntry {
+	if rsp == nil || len(rsp.ErrorEntries) == 0 {
+		return entries
+	}
+	failed := map[string]bool{}
+	for _, errorEntry := range rsp.ErrorEntries {
+		for _, e := range errorEntry.Errors {
+			if e.Entry != nil && e.Entry.EntityPropertyReference != nil {
+				failed[e.Entry.EntityPropertyReference.String()] = true
+			}
+		}
+	}
+	succeeded := []*iottwinmaker.PropertyValueEntry{}
+	for _, entry := range entries {
+		if entry.EntityPropertyReference == nil || !failed[entry.EntityPropertyReference.String()] {
+			succeeded = append(succeeded, entry)
+		}
+	}
+	return succeeded
+}
+
+// csvResponseWriter sets the CSV headers on the first write, so errors before any output
+// can still be sent as a JSON message
+type csvResponseWriter struct {
+	http.ResponseWriter
+	filename string
+	started  bool
+}
+
+func (w *csvResponseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, w.filename))
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *csvResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// exportTime parses a from/to parameter, epoch milliseconds or ISO8601 like startTime/endTime
+func exportTime(params url.Values, name string) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return time.Time{}, fmt.Errorf("missing %s", name)
+	}
+	t := models.QueryTime{}
+	if err := json.Unmarshal([]byte(strconv.Quote(v)), &t); err != nil {
+		return time.Time{}, err
+	}
+	return t.Time, nil
+}
+
+// HandleExportAlarmHistory streams the complete alarm history of the from/to range as CSV. The
+// history is paged in the backend and written page by page, so it is not truncated like the
+// panel download.
+func (ds *TwinMakerDatasource) HandleExportAlarmHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := models.TwinMakerQuery{WorkspaceId: ds.Settings.WorkspaceForOrg(twinmaker.OrgFrom(r.Context()))}
+	var err error
+	if query.TimeRange.From, err = exportTime(params, "from"); err == nil {
+		query.TimeRange.To, err = exportTime(params, "to")
+	}
+	if err == nil && !query.TimeRange.From.Before(query.TimeRange.To) {
+		err = fmt.Errorf("from must be before to")
+	}
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+
+	ctx := r.Context()
+	out := &csvResponseWriter{
+		ResponseWriter: w,
+		filename:       fmt.Sprintf("alarms-%s-%s.csv", query.TimeRange.From.Format("20060102T150405Z"), query.TimeRange.To.Format("20060102T150405Z")),
+	}
+	err = ds.HandlerFor(ctx).ExportAlarmHistory(ctx, query, out)
+	if err != nil {
+		if !out.started {
+			writeJsonResponse(w, nil, err)
+			return
+		}
+		// the status is already sent, the download ends early
+		log.DefaultLogger.Error("alarm history export failed", "error", err)
+	}
+}
+
+// HandleWriteAnnotation writes a Grafana annotation to the note property of its entity. The
+// property is set in the datasource configuration and the write uses the writer role.
+func (ds *TwinMakerDatasource) HandleWriteAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"message": "annotations are written with POST"}`))
+		return
+	}
+	if ds.Settings.AnnotationProperty == "" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "annotation write-back is not enabled in datasource configuration"}`))
+		return
+	}
+	if ds.Settings.AssumeRoleARNWriter == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "Assume Role ARN Write is missing in datasource configuration"}`))
+		return
+	}
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || (user.Role != "Admin" && user.Role != "Editor") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "writing annotations needs the editor or admin role"}`))
+		return
+	}
+
+	note := models.AnnotationNote{}
+	if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+	rsp, err := ds.ResourcesFor(r.Context()).WriteAnnotation(r.Context(), ds.Settings.AnnotationProperty, note)
+	writeJsonResponse(w, rsp, err)
+}
+
+// HandleWatchlist returns the watchlist items, or replaces them on PUT. Only editors and admins of
+// orgs allowed to read the datasource workspace can replace them.
+func (ds *TwinMakerDatasource) HandleWatchlist(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		// the watchlist is shared by the datasource and polled with its primary role
+		user := httpadapter.UserFromContext(r.Context())
+		if user == nil || (user.Role != "Admin" && user.Role != "Editor") {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "changing the watchlist needs the editor or admin role"}`))
+			return
+		}
+		if !ds.Settings.WorkspaceAllowedForOrg(twinmaker.OrgFrom(r.Context()), ds.Settings.WorkspaceID) {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "the watchlist is not in the workspace of the org"}`))
+			return
+		}
+		req := struct {
+			Items []models.WatchlistItem `json:"items"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.DefaultLogger.Error("failed to decode request", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+			return
+		}
+		if err := ds.Watchlist.SetItems(req.Items); err != nil {
+			writeJsonResponse(w, nil, err)
+			return
+		}
+	}
+	writeJsonResponse(w, map[string]interface{}{"items": ds.Watchlist.Items()}, nil)
+}
+
+// HandleFavorites returns the property favorites of the signed in user, or replaces them on PUT
+func (ds *TwinMakerDatasource) HandleFavorites(w http.ResponseWriter, r *http.Request) {
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Login == "" {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "favorites need a signed in user"}`))
+		return
+	}
+
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		req := struct {
+			Favorites []models.PropertyFavorite `json:"favorites"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.DefaultLogger.Error("failed to decode request", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+			return
+		}
+		favorites, err := ds.Favorites.Set(user.Login, req.Favorites)
+		writeJsonResponse(w, map[string]interface{}{"favorites": favorites}, err)
+		return
+	}
+	writeJsonResponse(w, map[string]interface{}{"favorites": ds.Favorites.Get(user.Login)}, nil)
+}
+
+// HandleEstimate estimates the AWS calls of a query. The body is the query JSON with its queryType,
+// startTime/endTime set the time range (defaults to the last hour).
+func (ds *TwinMakerDatasource) HandleEstimate(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	req := struct {
+		QueryType models.TwinMakerQueryType `json:"queryType"`
+	}{}
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+
+	now := time.Now()
+	query, err := models.ReadQuery(backend.DataQuery{
+		JSON:      body,
+		QueryType: req.QueryType,
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	})
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	rsp, err := ds.Estimate(r.Context(), query)
+	writeJsonResponse(w, rsp, err)
+}
+
+// debugTimeRange is the time range of the from/to params of HandleDebugBundle, the last hour
+// before to (or now) when they are omitted. startTime and endTime of the query take precedence.
+func debugTimeRange(params url.Values) (backend.TimeRange, error) {
+	tr := backend.TimeRange{To: time.Now()}
+	var err error
+	if params.Get("to") != "" {
+		if tr.To, err = exportTime(params, "to"); err != nil {
+			return tr, err
+		}
+	}
+	tr.From = tr.To.Add(-time.Hour)
+	if params.Get("from") != "" {
+		if tr.From, err = exportTime(params, "from"); err != nil {
+			return tr, err
+		}
+	}
+	if !tr.From.Before(tr.To) {
+		return tr, fmt.Errorf("from must be before to")
+	}
+	return tr, nil
+}
+
+// HandleDebugBundle runs a query again and sends its AWS calls and result as a download, so a
+// failing query can be attached to an issue. The body is the query JSON like for HandleEstimate,
+// it runs for the from/to params like the panel.
+// Only admins can use it since the bundle contains the raw twin model of the workspace.
+func (ds *TwinMakerDatasource) HandleDebugBundle(w http.ResponseWriter, r *http.Request) {
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Role != "Admin" {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "debug bundles need the admin role"}`))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"message": "debug bundles need a POST request"}`))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	req := struct {
+		QueryType models.TwinMakerQueryType `json:"queryType"`
+	}{}
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("failed to decode request", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message": "unable to parse request body"}`))
+		return
+	}
+
+	timeRange, err := debugTimeRange(r.URL.Query())
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	query, err := models.ReadQuery(backend.DataQuery{
+		JSON:      body,
+		QueryType: req.QueryType,
+		TimeRange: timeRange,
+	})
+	if err != nil {
+		writeJsonResponse(w, nil, err)
+		return
+	}
+	rsp, err := ds.DebugBundle(r.Context(), query)
+	if err == nil {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="twinmaker-debug-%s.json"`, rsp.Created.Format("20060102T150405Z")))
+	}
 	writeJsonResponse(w, rsp, err)
 }
@@ -0,0 +1,284 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/plugin/twinmaker"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSender struct {
+	rsp *backend.CallResourceResponse
+}
+
+func (s *recordingSender) Send(rsp *backend.CallResourceResponse) error {
+	s.rsp = rsp
+	return nil
+}
+
+// callResource sends a resource request of the user with the role
+func callResource(t *testing.T, ds *TwinMakerDatasource, role string, method string, path string) *backend.CallResourceResponse {
+	return callOrgResource(t, ds, 0, role, method, path)
+}
+
+// callOrgResource is callResource of a user of the Grafana org, the path may have query params
+func callOrgResource(t *testing.T, ds *TwinMakerDatasource, orgID int64, role string, method string, path string) *backend.CallResourceResponse {
+	sender := &recordingSender{}
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{OrgID: orgID, User: &backend.User{Login: "user", Role: role}},
+		Method:        method,
+		Path:          strings.SplitN(path, "?", 2)[0],
+		URL:           path,
+	}, sender)
+	require.NoError(t, err)
+	require.NotNil(t, sender.rsp)
+	return sender.rsp
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	spec := struct {
+		Paths map[string]interface{} `json:"paths"`
+	}{}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec))
+
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{}, c)
+	defer ds.Dispose()
+
+	routes := map[string]bool{}
+	err = ds.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		routes[path] = true
+		require.Contains(t, spec.Paths, path, "route missing in openapi.json")
+		return nil
+	})
+	require.NoError(t, err)
+	for path := range spec.Paths {
+		require.True(t, routes[path], "openapi.json documents unknown route %s", path)
+	}
+
+	rec := httptest.NewRecorder()
+	ds.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.JSONEq(t, string(openAPISpec), rec.Body.String())
+}
+
+func TestWriteDigestResponse(t *testing.T) {
+	rsp := models.OptionsInfo{}
+	rec := httptest.NewRecorder()
+	writeDigestResponse(rec, httptest.NewRequest(http.MethodGet, "/list/options", nil), rsp, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.JSONEq(t, `{}`, rec.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/list/options", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	writeDigestResponse(rec, req, rsp, nil)
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Empty(t, rec.Body.String())
+
+	// a changed result is sent again
+	rsp.Entities = []models.SelectableString{{Value: "mixer_0", Label: "Mixer_0"}}
+	rec = httptest.NewRecorder()
+	writeDigestResponse(rec, req, rsp, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotEqual(t, etag, rec.Header().Get("ETag"))
+	require.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+
+	rec = httptest.NewRecorder()
+	writeCachedResponse(rec, req, rsp, nil, "private, max-age=30")
+	require.Equal(t, "private, max-age=30", rec.Header().Get("Cache-Control"))
+}
+
+func TestInvalidateExternalIdsRole(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{}, c)
+	defer ds.Dispose()
+
+	rsp := callResource(t, ds, "Viewer", http.MethodPost, "external-ids/invalidate")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+
+	rsp = callResource(t, ds, "Editor", http.MethodPost, "external-ids/invalidate")
+	require.Equal(t, http.StatusOK, rsp.Status)
+	require.JSONEq(t, `{"invalidated": 0}`, string(rsp.Body))
+}
+
+func TestInvalidateExternalIdsOrg(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{
+		WorkspaceID:   "main",
+		OrgWorkspaces: map[int64]string{2: "tenant-a"},
+	}, c)
+	defer ds.Dispose()
+
+	// the orgs cannot drop the externalIds of the workspaces of each other
+	rsp := callOrgResource(t, ds, 2, "Editor", http.MethodPost, "external-ids/invalidate?workspaceId=main")
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+	rsp = callOrgResource(t, ds, 1, "Editor", http.MethodPost, "external-ids/invalidate?workspaceId=tenant-a")
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+
+	rsp = callOrgResource(t, ds, 2, "Editor", http.MethodPost, "external-ids/invalidate?workspaceId=tenant-a")
+	require.Equal(t, http.StatusOK, rsp.Status)
+	rsp = callOrgResource(t, ds, 2, "Editor", http.MethodPost, "external-ids/invalidate")
+	require.Equal(t, http.StatusOK, rsp.Status)
+}
+
+// workspacesMockClient lists the workspaces of an account, main, tenant-a and tenant-b unless ids
+// are set
+type workspacesMockClient struct {
+	twinmaker.TwinMakerClient
+	ids []string
+}
+
+func (c *workspacesMockClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	ids := c.ids
+	if ids == nil {
+		ids = []string{"main", "tenant-a", "tenant-b"}
+	}
+	rsp := &iottwinmaker.ListWorkspacesOutput{}
+	for _, id := range ids {
+		rsp.WorkspaceSummaries = append(rsp.WorkspaceSummaries, &iottwinmaker.WorkspaceSummary{WorkspaceId: aws.String(id)})
+	}
+	return rsp, nil
+}
+
+func TestListWorkspacesOrg(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{
+		WorkspaceID:   "main",
+		OrgWorkspaces: map[int64]string{2: "tenant-a"},
+	}, &workspacesMockClient{TwinMakerClient: c})
+	defer ds.Dispose()
+
+	workspaces := func(orgID int64) []string {
+		rsp := callOrgResource(t, ds, orgID, "Viewer", http.MethodGet, "list/workspaces")
+		require.Equal(t, http.StatusOK, rsp.Status)
+		var items []models.SelectableString
+		require.NoError(t, json.Unmarshal(rsp.Body, &items))
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item.Value)
+		}
+		return ids
+	}
+	require.Equal(t, []string{"tenant-a"}, workspaces(2))
+	require.Equal(t, []string{"main", "tenant-a", "tenant-b"}, workspaces(1))
+}
+
+func TestResourcesViewerRole(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{WorkspaceID: "main"}, &workspacesMockClient{TwinMakerClient: c})
+	defer ds.Dispose()
+	ds.SetViewerClient(&workspacesMockClient{TwinMakerClient: c, ids: []string{"main"}})
+
+	workspaces := func(login string) []models.SelectableString {
+		sender := &recordingSender{}
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: &backend.User{Login: login, Role: "Viewer"}},
+			Method:        http.MethodGet,
+			Path:          "list/workspaces",
+			URL:           "list/workspaces",
+		}, sender)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, sender.rsp.Status)
+		var items []models.SelectableString
+		require.NoError(t, json.Unmarshal(sender.rsp.Body, &items))
+		return items
+	}
+	// anonymous users list with the viewer role
+	require.Len(t, workspaces(""), 1)
+	require.Len(t, workspaces("user"), 3)
+}
+
+func TestDebugTimeRange(t *testing.T) {
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	tr, err := debugTimeRange(url.Values{"from": {strconv.FormatInt(from.UnixMilli(), 10)}, "to": {"2022-04-28T00:00:00Z"}})
+	require.NoError(t, err)
+	require.True(t, from.Equal(tr.From))
+	require.True(t, to.Equal(tr.To))
+
+	// the last hour without a from
+	tr, err = debugTimeRange(url.Values{"to": {"2022-04-28T00:00:00Z"}})
+	require.NoError(t, err)
+	require.True(t, to.Add(-time.Hour).Equal(tr.From))
+	tr, err = debugTimeRange(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, tr.To.Sub(tr.From))
+
+	_, err = debugTimeRange(url.Values{"from": {"2022-04-28T00:00:00Z"}, "to": {"2022-04-27T00:00:00Z"}})
+	require.EqualError(t, err, "from must be before to")
+	_, err = debugTimeRange(url.Values{"from": {"yesterday"}})
+	require.Error(t, err)
+}
+
+func TestWatchlistRole(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{
+		WorkspaceID:   "main",
+		OrgWorkspaces: map[int64]string{2: "tenant-a"},
+	}, c)
+	defer ds.Dispose()
+
+	rsp := callResource(t, ds, "Viewer", http.MethodPut, "watchlist")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+	rsp = callResource(t, ds, "", http.MethodPost, "watchlist")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+
+	// the watchlist polls the datasource workspace, bound orgs can not change it
+	rsp = callOrgResource(t, ds, 2, "Editor", http.MethodPut, "watchlist")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+
+	rsp = callResource(t, ds, "Viewer", http.MethodGet, "watchlist")
+	require.Equal(t, http.StatusOK, rsp.Status)
+}
+
+func TestAcknowledgeAlarmsRole(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{}, c)
+	defer ds.Dispose()
+
+	rsp := callResource(t, ds, "Viewer", http.MethodPost, "alarms/acknowledge")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+	rsp = callResource(t, ds, "", http.MethodPost, "alarms/acknowledge")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+}
+
+func TestSnoozeAlarmsRole(t *testing.T) {
+	c, err := twinmaker.NewTwinMakerMockClient("")
+	require.NoError(t, err)
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{AlarmModelSync: true}, c)
+	defer ds.Dispose()
+
+	rsp := callResource(t, ds, "Viewer", http.MethodPost, "alarms/snooze")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+	rsp = callResource(t, ds, "", http.MethodPost, "alarms/snooze")
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+}
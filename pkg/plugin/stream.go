@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/klauspost/compress/zstd"
+)
+
+const streamEncodingZstd = "zstd"
+
+// streamOptions are negotiated by the subscriber in the Live subscription data
+type streamOptions struct {
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// encodedFrame wraps a compressed frame, Live payloads must be JSON so the data is base64 encoded
+type encodedFrame struct {
+	Encoding string `json:"encoding"`
+	Data     []byte `json:"data"`
+}
+
+type frameSender struct {
+	sender  *backend.StreamSender
+	encoder *zstd.Encoder
+}
+
+// newFrameSender sends plain frames unless the subscriber asked for zstd compressed payloads
+func newFrameSender(sender *backend.StreamSender, subscription json.RawMessage) (*frameSender, error) {
+	s := &frameSender{sender: sender}
+	if len(subscription) == 0 {
+		return s, nil
+	}
+
+	opts := streamOptions{}
+	if err := json.Unmarshal(subscription, &opts); err != nil {
+		return nil, fmt.Errorf("invalid stream options: %w", err)
+	}
+	switch opts.Encoding {
+	case "":
+	case streamEncodingZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return nil, err
+		}
+		s.encoder = encoder
+	default:
+		return nil, fmt.Errorf("unsupported stream encoding: %s", opts.Encoding)
+	}
+	return s, nil
+}
+
+func (s *frameSender) SendFrame(frame *data.Frame, include data.FrameInclude) error {
+	if s.encoder == nil {
+		return s.sender.SendFrame(frame, include)
+	}
+	payload, err := s.encode(frame, include)
+	if err != nil {
+		return err
+	}
+	return s.sender.SendJSON(payload)
+}
+
+func (s *frameSender) encode(frame *data.Frame, include data.FrameInclude) ([]byte, error) {
+	frameJSON, err := data.FrameToJSON(frame, include)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encodedFrame{
+		Encoding: streamEncodingZstd,
+		Data:     s.encoder.EncodeAll(frameJSON, nil),
+	})
+}
+
+func (s *frameSender) Close() {
+	if s.encoder != nil {
+		_ = s.encoder.Close()
+	}
+}
+
+// Scene streams push the values bound in a scene on the ds/<uid>/scene/<sceneId> channel, so the
+// scene viewer subscribes once instead of running a query per bound tag
+const (
+	sceneStreamPrefix   = "scene/"
+	sceneStreamInterval = 5 * time.Second
+)
+
+func sceneStreamId(path string) (string, bool) {
+	if !strings.HasPrefix(path, sceneStreamPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(path, sceneStreamPrefix), true
+}
+
+// runSceneStream sends the bound values every sceneStreamInterval until the subscription ends,
+// the scene is read once when the stream starts
+func (ds *TwinMakerDatasource) runSceneStream(ctx context.Context, sceneId string, frames *frameSender) error {
+	bindings, err := ds.ResourcesFor(ctx).SceneBindings(ctx, sceneId)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(sceneStreamInterval)
+	defer ticker.Stop()
+	for {
+		frame, err := ds.ResourcesFor(ctx).SceneBindingValues(ctx, bindings)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		frame.Name = sceneId
+		if err := frames.SendFrame(frame, data.IncludeAll); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Property history streams push the new values of an entity property on the
+// ds/<uid>/history/<workspaceId>/<entityId>/<componentName>/<propertyName> channel. Live runs one
+// stream per channel, so all panels watching the property share the polling.
+const (
+	historyStreamPrefix   = "history/"
+	historyStreamInterval = 5 * time.Second
+)
+
+// Live channel paths only allow these characters, e.g. entity ids with a colon can not stream
+var historyStreamSegment = regexp.MustCompile(`^[A-Za-z0-9_\-=.]+$`)
+
+// historyStreamPath is the channel of a PropertyStream query, false when the query can not use one.
+// Channels are polled with the primary role, queries of a query role stream on their own.
+func historyStreamPath(query models.TwinMakerQuery) (string, bool) {
+	if query.QueryType != models.QueryTypeEntityHistory || len(query.EntityIds) > 0 || len(query.Properties) != 1 || query.Properties[0] == nil || len(query.PropertyFilter) > 0 || query.Aggregate != "" || query.RoleArn != "" {
+		return "", false
+	}
+	segments := []string{query.WorkspaceId, query.EntityId, query.ComponentName, *query.Properties[0]}
+	for _, segment := range segments {
+		if !historyStreamSegment.MatchString(segment) {
+			return "", false
+		}
+	}
+	return historyStreamPrefix + strings.Join(segments, "/"), true
+}
+
+// historyStreamQuery is the EntityHistory query of a property history channel
+func historyStreamQuery(path string) (models.TwinMakerQuery, bool) {
+	if !strings.HasPrefix(path, historyStreamPrefix) {
+		return models.TwinMakerQuery{}, false
+	}
+	segments := strings.Split(strings.TrimPrefix(path, historyStreamPrefix), "/")
+	if len(segments) != 4 {
+		return models.TwinMakerQuery{}, false
+	}
+	for _, segment := range segments {
+		if !historyStreamSegment.MatchString(segment) {
+			return models.TwinMakerQuery{}, false
+		}
+	}
+	return models.TwinMakerQuery{
+		QueryType:     models.QueryTypeEntityHistory,
+		WorkspaceId:   segments[0],
+		EntityId:      segments[1],
+		ComponentName: segments[2],
+		Properties:    []*string{&segments[3]},
+	}, true
+}
+
+// runHistoryStream polls the history since the last sent value every historyStreamInterval and
+// sends the values with newer timestamps until the subscription ends. The first poll covers the
+// last interval.
+func (ds *TwinMakerDatasource) runHistoryStream(ctx context.Context, query models.TwinMakerQuery, frames *frameSender) error {
+	since := time.Now().Add(-historyStreamInterval)
+	ticker := time.NewTicker(historyStreamInterval)
+	defer ticker.Stop()
+	for {
+		query.TimeRange = backend.TimeRange{From: since, To: time.Now()}
+		res := ds.DoQuery(ctx, query)
+		if res.Error != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return res.Error
+		}
+		for _, frame := range res.Frames {
+			frame, err := rowsAfter(frame, since)
+			if err != nil {
+				return err
+			}
+			if frame.Rows() == 0 {
+				continue
+			}
+			if err := frames.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+		if ts := getFromTimestamp(res); ts != nil && ts.After(since) {
+			since = *ts
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// rowsAfter keeps the rows of the frame with a time after since, the start time of a history
+// request is inclusive so the last sent value comes back on the next poll
+func rowsAfter(frame *data.Frame, since time.Time) (*data.Frame, error) {
+	for i, field := range frame.Fields {
+		switch field.Type() {
+		case data.FieldTypeTime:
+			return frame.FilterRowsByField(i, func(v interface{}) (bool, error) {
+				return v.(time.Time).After(since), nil
+			})
+		case data.FieldTypeNullableTime:
+			return frame.FilterRowsByField(i, func(v interface{}) (bool, error) {
+				t := v.(*time.Time)
+				return t != nil && t.After(since), nil
+			})
+		}
+	}
+	return frame, nil
+}
@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/plugin/twinmaker"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameSender(t *testing.T) {
+	t.Run("plain frames by default", func(t *testing.T) {
+		s, err := newFrameSender(nil, nil)
+		require.NoError(t, err)
+		require.Nil(t, s.encoder)
+
+		s, err = newFrameSender(nil, json.RawMessage(`{}`))
+		require.NoError(t, err)
+		require.Nil(t, s.encoder)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		_, err := newFrameSender(nil, json.RawMessage(`{"encoding":"brotli"}`))
+		require.Error(t, err)
+	})
+
+	t.Run("zstd payload round trip", func(t *testing.T) {
+		s, err := newFrameSender(nil, json.RawMessage(`{"encoding":"zstd"}`))
+		require.NoError(t, err)
+		defer s.Close()
+
+		frame := data.NewFrame("", data.NewField("value", nil, []float64{1, 2, 3}))
+		payload, err := s.encode(frame, data.IncludeAll)
+		require.NoError(t, err)
+		require.True(t, json.Valid(payload))
+
+		msg := encodedFrame{}
+		require.NoError(t, json.Unmarshal(payload, &msg))
+		require.Equal(t, streamEncodingZstd, msg.Encoding)
+
+		decoder, err := zstd.NewReader(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+		frameJSON, err := decoder.DecodeAll(msg.Data, nil)
+		require.NoError(t, err)
+
+		expected, err := data.FrameToJSON(frame, data.IncludeAll)
+		require.NoError(t, err)
+		require.JSONEq(t, string(expected), string(frameJSON))
+	})
+}
+
+type sceneStreamMockClient struct {
+	twinmaker.TwinMakerClient
+}
+
+func (c *sceneStreamMockClient) GetScene(ctx context.Context, workspaceId string, sceneId string) (*iottwinmaker.GetSceneOutput, error) {
+	return &iottwinmaker.GetSceneOutput{SceneId: aws.String(sceneId), ContentLocation: aws.String("s3://bucket/scene.json")}, nil
+}
+
+func (c *sceneStreamMockClient) GetSceneContent(ctx context.Context, contentLocation string) ([]byte, error) {
+	return []byte(`{"nodes": [{"name": "Mixer_0", "components": [{"type": "Tag", "valueDataBinding": {"dataBindingContext": {
+		"entityId": "Mixer_0", "componentName": "MixerComponent", "propertyName": "RPM"
+	}}}]}]}`), nil
+}
+
+func (c *sceneStreamMockClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	return &iottwinmaker.GetPropertyValueOutput{PropertyValues: map[string]*iottwinmaker.PropertyLatestValue{
+		"RPM": {PropertyValue: &iottwinmaker.DataValue{DoubleValue: aws.Float64(12)}},
+	}}, nil
+}
+
+// packetRecorder keeps the stream packets and cancels the stream after the first one
+type packetRecorder struct {
+	packets []*backend.StreamPacket
+	cancel  context.CancelFunc
+}
+
+func (r *packetRecorder) Send(p *backend.StreamPacket) error {
+	r.packets = append(r.packets, p)
+	r.cancel()
+	return nil
+}
+
+func TestSceneStream(t *testing.T) {
+	ds := newTwinMakerDatasource(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, &sceneStreamMockClient{})
+	defer ds.Dispose()
+
+	// scene channels are always available, other paths only after a query
+	for path, status := range map[string]backend.SubscribeStreamStatus{
+		"scene/CookieFactory": backend.SubscribeStreamStatusOK,
+		"scene/":              backend.SubscribeStreamStatusNotFound,
+		"unknown":             backend.SubscribeStreamStatusNotFound,
+	} {
+		rsp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: path})
+		require.NoError(t, err)
+		require.Equal(t, status, rsp.Status, path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := &packetRecorder{cancel: cancel}
+	err := ds.RunStream(ctx, &backend.RunStreamRequest{Path: "scene/CookieFactory"}, backend.NewStreamSender(recorder))
+	require.NoError(t, err)
+	require.Len(t, recorder.packets, 1)
+
+	frame := &data.Frame{}
+	require.NoError(t, json.Unmarshal(recorder.packets[0].Data, frame))
+	require.Equal(t, "CookieFactory", frame.Name)
+	rpm, _ := frame.FieldByName("RPM")
+	require.NotNil(t, rpm)
+	require.Equal(t, "Mixer_0", rpm.Labels["entityId"])
+}
+
+// historyStreamMockClient returns a value at the start of the requested range and one a second later
+type historyStreamMockClient struct {
+	twinmaker.TwinMakerClient
+}
+
+// the entity has no property hints
+func (c *historyStreamMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{}, nil
+}
+
+func (c *historyStreamMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	value := func(t time.Time, v float64) *iottwinmaker.PropertyValue {
+		return &iottwinmaker.PropertyValue{
+			Time:  aws.String(t.UTC().Format(time.RFC3339Nano)),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(v)},
+		}
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String(query.EntityId),
+			ComponentName: aws.String(query.ComponentName),
+			PropertyName:  query.Properties[0],
+		},
+		Values: []*iottwinmaker.PropertyValue{
+			value(query.TimeRange.From, 1),
+			value(query.TimeRange.From.Add(time.Second), 2),
+		},
+	}}}, nil
+}
+
+func TestHistoryStream(t *testing.T) {
+	settings := models.TwinMakerDataSourceSetting{WorkspaceID: "w", AllowedWorkspaces: []string{"w2"}, UID: "uid"}
+	ds := newTwinMakerDatasource(settings, &historyStreamMockClient{})
+	defer ds.Dispose()
+
+	t.Run("property queries stream on the channel of the property", func(t *testing.T) {
+		rsp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{{
+			RefID:     "A",
+			QueryType: models.QueryTypeEntityHistory,
+			TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()},
+			JSON: json.RawMessage(`{"grafanaLiveEnabled": true, "propertyStream": true,
+				"entityId": "Mixer_0", "componentName": "MixerComponent", "properties": ["RPM"]}`),
+		}}})
+		require.NoError(t, err)
+		res := rsp.Responses["A"]
+		require.NoError(t, res.Error)
+		require.Equal(t, "ds/uid/history/w/Mixer_0/MixerComponent/RPM", res.Frames[0].Meta.Channel)
+		require.Empty(t, ds.streams)
+
+		// anonymous users do not get the channel, their queries stream like other queries
+		rsp, err = ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{User: &backend.User{Role: "Viewer"}},
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				QueryType: models.QueryTypeEntityHistory,
+				TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()},
+				JSON: json.RawMessage(`{"grafanaLiveEnabled": true, "propertyStream": true,
+					"entityId": "Mixer_0", "componentName": "MixerComponent", "properties": ["RPM"]}`),
+			}},
+		})
+		require.NoError(t, err)
+		meta := rsp.Responses["A"].Frames[0].Meta
+		require.True(t, meta == nil || meta.Channel == "")
+	})
+
+	t.Run("channel paths", func(t *testing.T) {
+		query := models.TwinMakerQuery{
+			QueryType:     models.QueryTypeEntityHistory,
+			WorkspaceId:   "w",
+			EntityId:      "Mixer_0",
+			ComponentName: "MixerComponent",
+			Properties:    []*string{aws.String("RPM")},
+		}
+		path, ok := historyStreamPath(query)
+		require.True(t, ok)
+		parsed, ok := historyStreamQuery(path)
+		require.True(t, ok)
+		require.Equal(t, query, parsed)
+
+		query.EntityId = "arn:mixer"
+		_, ok = historyStreamPath(query)
+		require.False(t, ok)
+
+		// aggregates are not streamed
+		query.EntityId = "Mixer_0"
+		query.Aggregate = models.HeatmapAvg
+		_, ok = historyStreamPath(query)
+		require.False(t, ok)
+
+		// query roles do not share the channel of the primary role
+		query.Aggregate = ""
+		query.RoleArn = "arn:aws:iam::123456789012:role/LineA"
+		_, ok = historyStreamPath(query)
+		require.False(t, ok)
+
+		for path, status := range map[string]backend.SubscribeStreamStatus{
+			"history/w/Mixer_0/MixerComponent/RPM":     backend.SubscribeStreamStatusOK,
+			"history/w2/Mixer_0/MixerComponent/RPM":    backend.SubscribeStreamStatusOK,
+			"history/other/Mixer_0/MixerComponent/RPM": backend.SubscribeStreamStatusNotFound,
+			"history/w/Mixer_0/RPM":                    backend.SubscribeStreamStatusNotFound,
+		} {
+			rsp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: path})
+			require.NoError(t, err)
+			require.Equal(t, status, rsp.Status, path)
+		}
+
+		// anonymous users do not subscribe to the channels of the primary role
+		rsp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path:          "history/w/Mixer_0/MixerComponent/RPM",
+			PluginContext: backend.PluginContext{User: &backend.User{Role: "Viewer"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, backend.SubscribeStreamStatusNotFound, rsp.Status)
+	})
+
+	t.Run("sends only values after the last poll", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		recorder := &packetRecorder{cancel: cancel}
+		err := ds.RunStream(ctx, &backend.RunStreamRequest{Path: "history/w/Mixer_0/MixerComponent/RPM"}, backend.NewStreamSender(recorder))
+		require.NoError(t, err)
+		require.Len(t, recorder.packets, 1)
+
+		frame := &data.Frame{}
+		require.NoError(t, json.Unmarshal(recorder.packets[0].Data, frame))
+		require.Equal(t, 1, frame.Rows())
+		value, _ := frame.FieldByName("RPM")
+		require.NotNil(t, value)
+		require.Equal(t, 2.0, *value.At(0).(*float64))
+	})
+}
+
+func TestKeepResolutionSnapshot(t *testing.T) {
+	query := models.TwinMakerQuery{PinResolution: true}
+	keepResolutionSnapshot(&query, backend.DataResponse{Frames: data.Frames{data.NewFrame("")}})
+	require.Nil(t, query.ResolutionSnapshot)
+
+	frame := data.NewFrame("")
+	frame.Meta = &data.FrameMeta{Custom: models.TwinMakerCustomMeta{ResolutionSnapshot: "2022-04-27T10:00:00Z"}}
+	keepResolutionSnapshot(&query, backend.DataResponse{Frames: data.Frames{data.NewFrame(""), frame}})
+	require.Equal(t, time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC), query.ResolutionSnapshot.Time)
+}
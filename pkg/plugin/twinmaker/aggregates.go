@@ -0,0 +1,146 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxAggregatesPageSize is the most aggregates GetAssetPropertyAggregates returns per page
+const maxAggregatesPageSize = 250
+
+// sitewiseAggregateTypes are the SiteWise aggregates of the query aggregates
+var sitewiseAggregateTypes = map[models.HeatmapAggregation]string{
+	models.HeatmapAvg:   iotsitewise.AggregateTypeAverage,
+	models.HeatmapMin:   iotsitewise.AggregateTypeMinimum,
+	models.HeatmapMax:   iotsitewise.AggregateTypeMaximum,
+	models.HeatmapCount: iotsitewise.AggregateTypeCount,
+}
+
+// numericAggregate is false for the avg, min and max aggregates of properties that are not numbers,
+// SiteWise only counts their values
+func numericAggregate(aggregate models.HeatmapAggregation, dataType string) bool {
+	if aggregate == models.HeatmapCount {
+		return true
+	}
+	return dataType == iotsitewise.PropertyDataTypeDouble || dataType == iotsitewise.PropertyDataTypeInteger
+}
+
+// resolutionDuration is the interval of a SiteWise aggregate resolution
+func resolutionDuration(resolution models.SiteWiseResolution) time.Duration {
+	switch resolution {
+	case models.SiteWiseResolution15m:
+		return 15 * time.Minute
+	case models.SiteWiseResolution1h:
+		return time.Hour
+	}
+	return time.Minute
+}
+
+// getAggregatedHistory is the entity history of a component of the SiteWise connector as one
+// SiteWise aggregate per resolution interval, the value fields are named after the aggregate and
+// resolution and have them as labels
+func (s *twinMakerHandler) getAggregatedHistory(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
+	results, failures, err := s.sitewiseHistory(ctx, query, func(assetId string, property *iotsitewise.AssetProperty) ([]*iottwinmaker.PropertyValue, bool, error) {
+		return s.aggregatedValues(ctx, query, assetId, property)
+	})
+	dr := s.processHistory(results, err, failures, query, nil)
+	suffix := " " + query.Aggregate + " " + query.SiteWiseResolution
+	if query.FieldNaming == models.FieldNamingStableV1 {
+		suffix = "/" + query.Aggregate + "/" + query.SiteWiseResolution
+	}
+	interval := resolutionDuration(query.SiteWiseResolution)
+	for _, frame := range dr.Frames {
+		for _, field := range frame.Fields {
+			switch {
+			case field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime:
+				field.Config = &data.FieldConfig{Interval: float64(interval.Milliseconds())}
+			case field.Labels["propertyName"] != "":
+				field.Name += suffix
+				if field.Config != nil && field.Config.DisplayName != "" {
+					field.Config.DisplayName += " " + query.Aggregate + " " + query.SiteWiseResolution
+				}
+				field.Labels["aggregate"] = query.Aggregate
+				field.Labels["resolution"] = query.SiteWiseResolution
+			}
+		}
+	}
+	return dr
+}
+
+// aggregatedValues pages through the aggregates of an asset property in the query order as
+// TwinMaker history values, stopped is true when paging ended before the last page
+func (s *twinMakerHandler) aggregatedValues(ctx context.Context, query models.TwinMakerQuery, assetId string, property *iotsitewise.AssetProperty) (values []*iottwinmaker.PropertyValue, stopped bool, err error) {
+	if !numericAggregate(query.Aggregate, aws.StringValue(property.DataType)) {
+		return nil, false, fmt.Errorf("property %s of type %s has no %s aggregate", aws.StringValue(property.Name), aws.StringValue(property.DataType), query.Aggregate)
+	}
+	order := iotsitewise.TimeOrderingAscending
+	if query.Order == models.ResultOrderDesc {
+		order = iotsitewise.TimeOrderingDescending
+	}
+	req := &iotsitewise.GetAssetPropertyAggregatesInput{
+		AssetId:        aws.String(assetId),
+		PropertyId:     property.Id,
+		AggregateTypes: []*string{aws.String(sitewiseAggregateTypes[query.Aggregate])},
+		Resolution:     aws.String(query.SiteWiseResolution),
+		StartDate:      aws.Time(query.TimeRange.From),
+		EndDate:        aws.Time(query.TimeRange.To),
+		Qualities:      []*string{aws.String(iotsitewise.QualityGood)},
+		TimeOrdering:   aws.String(order),
+		MaxResults:     aws.Int64(maxAggregatesPageSize),
+	}
+	countHistoryCall(ctx)
+	calls := 0
+	for {
+		start := time.Now()
+		page, err := s.client.GetAssetPropertyAggregates(ctx, req)
+		if err != nil {
+			return nil, false, err
+		}
+		lastPage := time.Since(start)
+		calls++
+		for _, v := range page.AggregatedValues {
+			if v == nil || v.Timestamp == nil || v.Value == nil {
+				continue
+			}
+			value := aggregateValue(v.Value, query.Aggregate)
+			if value == nil {
+				continue
+			}
+			t := v.Timestamp.UTC()
+			values = append(values, &iottwinmaker.PropertyValue{
+				Time:  getTimeStringFromTimeObject(&t),
+				Value: &iottwinmaker.DataValue{DoubleValue: value},
+			})
+		}
+		if page.NextToken == nil {
+			break
+		}
+		if deadlineNear(ctx, lastPage) || previewPageLoaded(ctx, calls) || callBudgetReached(ctx) {
+			stopped = true
+			break
+		}
+		req.NextToken = page.NextToken
+	}
+	return values, stopped, nil
+}
+
+// aggregateValue is the aggregate of the query in a SiteWise aggregate value
+func aggregateValue(v *iotsitewise.Aggregates, aggregate models.HeatmapAggregation) *float64 {
+	switch aggregate {
+	case models.HeatmapMin:
+		return v.Minimum
+	case models.HeatmapMax:
+		return v.Maximum
+	case models.HeatmapCount:
+		return v.Count
+	}
+	return v.Average
+}
@@ -0,0 +1,100 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+// aggregatesMockClient serves the aggregates of the first three resolution intervals
+type aggregatesMockClient struct {
+	*interpolatedMockClient
+	requests []*iotsitewise.GetAssetPropertyAggregatesInput
+}
+
+func (c *aggregatesMockClient) GetAssetPropertyAggregates(ctx context.Context, req *iotsitewise.GetAssetPropertyAggregatesInput) (*iotsitewise.GetAssetPropertyAggregatesOutput, error) {
+	c.requests = append(c.requests, req)
+	rsp := &iotsitewise.GetAssetPropertyAggregatesOutput{}
+	for i := 0; i < 3; i++ {
+		rsp.AggregatedValues = append(rsp.AggregatedValues, &iotsitewise.AggregatedValue{
+			Timestamp: aws.Time(req.StartDate.Add(time.Duration(i) * 15 * time.Minute)),
+			Value: &iotsitewise.Aggregates{
+				Average: aws.Float64(float64(i) + 0.5),
+				Maximum: aws.Float64(float64(i) + 1),
+			},
+		})
+	}
+	return rsp, nil
+}
+
+func TestAggregatedEntityHistory(t *testing.T) {
+	client := &aggregatesMockClient{interpolatedMockClient: &interpolatedMockClient{twinMakerMockClient: &twinMakerMockClient{}}}
+	start := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		QueryType:          models.QueryTypeEntityHistory,
+		EntityId:           "Mixer_0",
+		ComponentName:      "MixerComponent",
+		Properties:         []*string{aws.String("Temperature")},
+		Aggregate:          models.HeatmapMax,
+		SiteWiseResolution: models.SiteWiseResolution15m,
+		Order:              models.ResultOrderDesc,
+		TimeRange:          backend.TimeRange{From: start, To: start.Add(time.Hour)},
+	}
+
+	dr := newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	req := client.requests[0]
+	require.Equal(t, "temperature-id", *req.PropertyId)
+	require.Equal(t, []*string{aws.String(iotsitewise.AggregateTypeMaximum)}, req.AggregateTypes)
+	require.Equal(t, "15m", *req.Resolution)
+	require.Equal(t, iotsitewise.TimeOrderingDescending, *req.TimeOrdering)
+
+	frame := dr.Frames[0]
+	require.Equal(t, 3, frame.Rows())
+	value, _ := frame.FieldByName("Temperature max 15m")
+	require.NotNil(t, value)
+	require.Equal(t, "max", value.Labels["aggregate"])
+	require.Equal(t, "15m", value.Labels["resolution"])
+	require.Equal(t, 3.0, *value.At(2).(*float64))
+	require.Equal(t, float64((15 * time.Minute).Milliseconds()), frame.Fields[1].Config.Interval)
+
+	// stable names end with the aggregate and resolution too
+	query.FieldNaming = models.FieldNamingStableV1
+	query.Aggregate = models.HeatmapAvg
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	value, _ = dr.Frames[0].FieldByName("Mixer_0/MixerComponent/Temperature/avg/15m")
+	require.NotNil(t, value)
+	require.Equal(t, 0.5, *value.At(0).(*float64))
+	require.Equal(t, "Temperature avg 15m", value.Config.DisplayName)
+
+	// count is not in the aggregates, no values are returned
+	query.Aggregate = models.HeatmapCount
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Empty(t, dr.Frames)
+
+	// only the values of other properties are counted
+	query.Properties = []*string{aws.String("State")}
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	query.Aggregate = models.HeatmapMin
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.EqualError(t, dr.Error, "property State of type STRING has no min aggregate")
+
+	// aggregates are not filtered and have no deleted entities
+	query.Properties = []*string{aws.String("Temperature")}
+	query.PropertyFilter = []models.TwinMakerPropertyFilter{{Name: "Temperature", Op: "=", Value: models.TwinMakerFilterValue{DoubleValue: aws.Float64(1)}}}
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.EqualError(t, dr.Error, "aggregate can not be combined with property filters")
+	query.PropertyFilter = nil
+	query.IncludeDeletedEntities = true
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.EqualError(t, dr.Error, "aggregate can not be combined with includeDeletedEntities")
+}
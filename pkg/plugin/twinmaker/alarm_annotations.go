@@ -0,0 +1,161 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const alarmStatusNormal = "NORMAL"
+
+type alarmWindow struct {
+	start time.Time
+	end   time.Time
+	// ongoing windows end with the time range
+	ongoing bool
+	state   string
+	alarm   string
+	entity  string
+}
+
+// alarmWindows are the periods an alarm spent outside NORMAL, one per state
+func alarmWindows(p PropertyReference, end time.Time) []alarmWindow {
+	alarm, entity := "", aws.StringValue(p.entityName)
+	if ref := p.entityPropertyReference; ref != nil {
+		alarm = aws.StringValue(ref.ComponentName)
+		if alarm == "" {
+			alarm = aws.StringValue(ref.ExternalIdProperty[alarmExternalIdKey])
+		}
+		if entity == "" {
+			entity = aws.StringValue(ref.EntityId)
+		}
+	}
+
+	windows := []alarmWindow{}
+	changes := stateChanges(p.values)
+	for i, c := range changes {
+		if c.to == alarmStatusNormal {
+			continue
+		}
+		w := alarmWindow{start: c.time, end: end, ongoing: true, state: c.to, alarm: alarm, entity: entity}
+		if i+1 < len(changes) {
+			w.end = changes[i+1].time
+			w.ongoing = false
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// entityAlarmHistory loads the alarm_status history of every alarm component of the entity
+func (s *twinMakerHandler) entityAlarmHistory(ctx context.Context, query models.TwinMakerQuery) ([]PropertyReference, []data.Notice, error) {
+	entity, err := s.client.GetEntity(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{}
+	for name, component := range entity.Components {
+		if component != nil && component.Properties[alarmStatusProperty] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if entity.EntityName == nil {
+		entity.EntityName = aws.String(query.EntityId)
+	}
+
+	references := []PropertyReference{}
+	failures := []data.Notice{}
+	for _, name := range names {
+		query.ComponentName = name
+		refs, notices, err := s.getHistoryReferences(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ref := range refs {
+			ref.entityName = entity.EntityName
+			references = append(references, ref)
+		}
+		failures = append(failures, notices...)
+	}
+	return references, failures, nil
+}
+
+// alarmHistoryWindows are the alarm windows of the query in the time range, sorted by start. A
+// window starts with a transition out of NORMAL and ends with the next transition, or with the time
+// range while the state lasts. Entities without a component name read all their alarm components.
+// Alarms already active before the range start with their first value in it.
+func (s *twinMakerHandler) alarmHistoryWindows(ctx context.Context, query models.TwinMakerQuery) ([]alarmWindow, []data.Notice, error) {
+	// the windows would still show when the state changed
+	if s.redaction.action(alarmStatusProperty) != "" {
+		return nil, nil, fmt.Errorf("%s is redacted in datasource configuration", alarmStatusProperty)
+	}
+	query.Properties = []*string{aws.String(alarmStatusProperty)}
+	query.PropertyFilter = nil
+	query.Order = models.ResultOrderAsc
+
+	var references []PropertyReference
+	var failures []data.Notice
+	var err error
+	if query.EntityId != "" && query.ComponentName == "" {
+		references, failures, err = s.entityAlarmHistory(ctx, query)
+	} else {
+		references, failures, err = s.getHistoryReferences(ctx, query)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	windows := []alarmWindow{}
+	for _, p := range references {
+		windows = append(windows, alarmWindows(p, query.TimeRange.To)...)
+	}
+	sort.SliceStable(windows, func(i, j int) bool {
+		return windows[i].start.Before(windows[j].start)
+	})
+	return windows, failures, nil
+}
+
+// GetAlarmAnnotations returns the alarm windows of an entity or component type as annotation
+// regions
+func (s *twinMakerHandler) GetAlarmAnnotations(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" && query.ComponentTypeId == "" {
+		dr.Error = fmt.Errorf("missing entity or component type parameter")
+		return
+	}
+	windows, failures, err := s.alarmHistoryWindows(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	fields := newTwinMakerFrameBuilder(len(windows))
+	t := fields.Time()
+	timeEnd := fields.TimeEnd()
+	title := fields.Title()
+	text := fields.Text()
+	tags := fields.Tags()
+	for i, w := range windows {
+		w := w
+		t.Set(i, &w.start)
+		timeEnd.Set(i, &w.end)
+		title.Set(i, aws.String(w.alarm+" "+w.state))
+		description := fmt.Sprintf("%s on %s", w.state, w.entity)
+		if w.ongoing {
+			description += " (ongoing)"
+		}
+		text.Set(i, aws.String(description))
+		tags.Set(i, aws.String("twinmaker,alarm,"+w.state))
+	}
+
+	frame := fields.ToFrame("", nil)
+	frame.AppendNotices(failures...)
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
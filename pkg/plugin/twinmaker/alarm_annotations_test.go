@@ -0,0 +1,71 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type alarmEntityMockClient struct {
+	*alarmExportMockClient
+}
+
+func (c *alarmEntityMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	e, err := c.alarmExportMockClient.GetEntity(ctx, query)
+	e.EntityName = aws.String("Mixer 0")
+	e.Components["TemperatureAlarm"].Properties[alarmStatusProperty] = &iottwinmaker.PropertyResponse{}
+	e.Components["Mixer"] = &iottwinmaker.ComponentResponse{Properties: map[string]*iottwinmaker.PropertyResponse{}}
+	return e, err
+}
+
+func TestGetAlarmAnnotations(t *testing.T) {
+	end := time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		WorkspaceId:     "w",
+		ComponentTypeId: "com.example.alarm",
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   end,
+		},
+	}
+
+	client := &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	dr := handler.GetAlarmAnnotations(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, models.ResultOrderAsc, client.historyCalls[0].Order)
+
+	// ACTIVE and ACKNOWLEDGED windows, the NORMAL state is not annotated
+	frame := dr.Frames[0]
+	require.Equal(t, 2, frame.Rows())
+	timeEnd, _ := frame.FieldByName("timeEnd")
+	title, _ := frame.FieldByName("title")
+	tags, _ := frame.FieldByName("tags")
+	require.Equal(t, time.Date(2022, 4, 27, 10, 5, 0, 0, time.UTC), *timeEnd.At(0).(*time.Time))
+	require.Equal(t, "TemperatureAlarm ACTIVE", *title.At(0).(*string))
+	require.Equal(t, "twinmaker,alarm,ACKNOWLEDGED", *tags.At(1).(*string))
+
+	// entities read the history of their alarm components
+	query.ComponentTypeId = ""
+	query.EntityId = "Mixer_0"
+	client = &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler = newTwinMakerHandler(&alarmEntityMockClient{client}, nil)
+	dr = handler.GetAlarmAnnotations(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.NotEmpty(t, client.historyCalls)
+	for _, call := range client.historyCalls {
+		require.Equal(t, "TemperatureAlarm", call.ComponentName)
+	}
+	text, _ := dr.Frames[0].FieldByName("text")
+	require.Equal(t, "ACTIVE on Mixer 0", *text.At(0).(*string))
+
+	query.EntityId = ""
+	dr = handler.GetAlarmAnnotations(context.Background(), query)
+	require.Error(t, dr.Error)
+}
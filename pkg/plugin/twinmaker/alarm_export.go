@@ -0,0 +1,145 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+const (
+	alarmComponentTypeId         = "com.amazon.iottwinmaker.alarm.basic"
+	sitewiseAlarmComponentTypeId = "com.amazon.iotsitewise.alarm"
+	alarmExternalIdKey           = "alarm_key"
+	alarmStatusProperty          = "alarm_status"
+)
+
+// alarmHistoryColumns is the CSV header of the alarm history export
+var alarmHistoryColumns = []string{"time", "alarmName", "alarmId", "entityId", "entityName", "status"}
+
+// alarmComponentTypes lists the component types that extend from the basic alarm type.
+// list-component-types only supports direct child extend checks, so the children of the
+// SiteWise alarm type are listed separately and the SiteWise type itself, which has no data, is left out.
+func (s *twinMakerHandler) alarmComponentTypes(ctx context.Context, query models.TwinMakerQuery) ([]*iottwinmaker.ComponentTypeSummary, error) {
+	query.ComponentTypeId = alarmComponentTypeId
+	basicComponentTypes, err := s.client.ListComponentTypes(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if basicComponentTypes == nil {
+		return nil, fmt.Errorf("error loading componentTypes for GetAlarms query")
+	}
+
+	query.ComponentTypeId = sitewiseAlarmComponentTypeId
+	sitewiseComponentTypes, err := s.client.ListComponentTypes(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if sitewiseComponentTypes == nil {
+		return nil, fmt.Errorf("error loading componentTypes for GetAlarms query")
+	}
+
+	summaries := make([]*iottwinmaker.ComponentTypeSummary, 0, len(basicComponentTypes.ComponentTypeSummaries)+len(sitewiseComponentTypes.ComponentTypeSummaries))
+	for _, summary := range basicComponentTypes.ComponentTypeSummaries {
+		if aws.StringValue(summary.ComponentTypeId) != sitewiseAlarmComponentTypeId {
+			summaries = append(summaries, summary)
+		}
+	}
+	return append(summaries, sitewiseComponentTypes.ComponentTypeSummaries...), nil
+}
+
+// ExportAlarmHistory writes every alarm status value in the query time range to w as CSV.
+// Unlike GetAlarms the history is not limited to the latest value or the query deadline: each
+// page is written as soon as it is loaded and w is flushed when it is an http.Flusher, so large
+// exports stream to the client instead of being held in a frame.
+func (s *twinMakerHandler) ExportAlarmHistory(ctx context.Context, query models.TwinMakerQuery, w io.Writer) error {
+	componentTypes, err := s.alarmComponentTypes(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(alarmHistoryColumns); err != nil {
+		return err
+	}
+
+	// one page per call, the paging is done here so pages are written as they arrive
+	page := func(ctx context.Context, query models.TwinMakerQuery, _ map[string]*iottwinmaker.PropertyDefinitionResponse) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+		return s.client.GetPropertyValueHistory(ctx, query)
+	}
+
+	for _, componentType := range componentTypes {
+		query.EntityId = ""
+		query.ComponentTypeId = aws.StringValue(componentType.ComponentTypeId)
+		query.Properties = []*string{aws.String(alarmStatusProperty)}
+		query.PropertyFilter = nil
+		query.Order = models.ResultOrderAsc
+		query.MaxResults = maxHistoryPageSize
+		query.NextToken = ""
+
+		for {
+			references, nextToken, _, err := s.GetComponentHistoryWithLookupHelper(ctx, query, page)
+			if err != nil {
+				return err
+			}
+			if err := s.writeAlarmHistoryPage(out, references); err != nil {
+				return err
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			if nextToken == nil {
+				break
+			}
+			query.NextToken = *nextToken
+		}
+	}
+	return nil
+}
+
+// writeAlarmHistoryPage writes the values of one page ordered by time
+func (s *twinMakerHandler) writeAlarmHistoryPage(out *csv.Writer, references []PropertyReference) error {
+	type row struct {
+		time   time.Time
+		fields []string
+	}
+	rows := []row{}
+	for _, reference := range references {
+		ref := reference.entityPropertyReference
+		for _, value := range reference.values {
+			t, err := getTimeObjectFromStringTime(value.Time)
+			if err != nil {
+				return fmt.Errorf("error parsing timestamp during alarm history export")
+			}
+			status := ""
+			if value.Value != nil {
+				status = s.redaction.valueString(alarmStatusProperty, aws.StringValue(value.Value.StringValue))
+			}
+			rows = append(rows, row{time: *t, fields: []string{
+				t.UTC().Format(time.RFC3339Nano),
+				aws.StringValue(ref.ComponentName),
+				aws.StringValue(ref.ExternalIdProperty[alarmExternalIdKey]),
+				aws.StringValue(ref.EntityId),
+				aws.StringValue(reference.entityName),
+				status,
+			}})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].time.Before(rows[j].time)
+	})
+	for _, r := range rows {
+		if err := out.Write(r.fields); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
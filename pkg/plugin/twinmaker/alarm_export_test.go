@@ -0,0 +1,117 @@
+package twinmaker
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+// alarmExportMockClient serves one alarm component type with two history pages
+type alarmExportMockClient struct {
+	*twinMakerMockClient
+	historyCalls []models.TwinMakerQuery
+}
+
+func (c *alarmExportMockClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	if query.ComponentTypeId == alarmComponentTypeId {
+		return &iottwinmaker.ListComponentTypesOutput{ComponentTypeSummaries: []*iottwinmaker.ComponentTypeSummary{
+			{ComponentTypeId: aws.String("com.example.alarm")},
+			{ComponentTypeId: aws.String(sitewiseAlarmComponentTypeId)},
+		}}, nil
+	}
+	return &iottwinmaker.ListComponentTypesOutput{}, nil
+}
+
+func (c *alarmExportMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		alarmExternalIdKey: {IsExternalId: aws.Bool(true)},
+	}}, nil
+}
+
+func (c *alarmExportMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return &iottwinmaker.ListEntitiesOutput{EntitySummaries: []*iottwinmaker.EntitySummary{
+		{EntityId: aws.String("Mixer_0"), EntityName: aws.String("Mixer 0")},
+	}}, nil
+}
+
+func (c *alarmExportMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{Components: map[string]*iottwinmaker.ComponentResponse{
+		"TemperatureAlarm": {
+			ComponentName:   aws.String("TemperatureAlarm"),
+			ComponentTypeId: aws.String("com.example.alarm"),
+			Properties: map[string]*iottwinmaker.PropertyResponse{
+				alarmExternalIdKey: {
+					Definition: &iottwinmaker.PropertyDefinitionResponse{IsExternalId: aws.Bool(true)},
+					Value:      &iottwinmaker.DataValue{StringValue: aws.String("alarm-1")},
+				},
+			},
+		},
+	}}, nil
+}
+
+func (c *alarmExportMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	c.historyCalls = append(c.historyCalls, query)
+	value := func(t string, status string) *iottwinmaker.PropertyValue {
+		return &iottwinmaker.PropertyValue{Time: aws.String(t), Value: &iottwinmaker.DataValue{StringValue: aws.String(status)}}
+	}
+	history := &iottwinmaker.PropertyValueHistory{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			ExternalIdProperty: map[string]*string{alarmExternalIdKey: aws.String("alarm-1")},
+			PropertyName:       aws.String(alarmStatusProperty),
+		},
+	}
+	out := &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{history}}
+	if query.NextToken == "" {
+		history.Values = []*iottwinmaker.PropertyValue{value("2022-04-27T10:00:00Z", "ACTIVE"), value("2022-04-27T10:05:00Z", "ACKNOWLEDGED")}
+		out.NextToken = aws.String("page2")
+	} else {
+		history.Values = []*iottwinmaker.PropertyValue{value("2022-04-27T10:10:00Z", "NORMAL")}
+	}
+	return out, nil
+}
+
+func TestExportAlarmHistory(t *testing.T) {
+	client := &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	query := models.TwinMakerQuery{
+		WorkspaceId: "w",
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, handler.ExportAlarmHistory(context.Background(), query, buf))
+
+	// the sitewise base type is skipped and every page is read
+	require.Len(t, client.historyCalls, 2)
+	require.Equal(t, "com.example.alarm", client.historyCalls[0].ComponentTypeId)
+	require.Equal(t, models.ResultOrderAsc, client.historyCalls[0].Order)
+	require.Equal(t, "page2", client.historyCalls[1].NextToken)
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		alarmHistoryColumns,
+		{"2022-04-27T10:00:00Z", "TemperatureAlarm", "alarm-1", "Mixer_0", "Mixer 0", "ACTIVE"},
+		{"2022-04-27T10:05:00Z", "TemperatureAlarm", "alarm-1", "Mixer_0", "Mixer 0", "ACKNOWLEDGED"},
+		{"2022-04-27T10:10:00Z", "TemperatureAlarm", "alarm-1", "Mixer_0", "Mixer 0", "NORMAL"},
+	}, rows)
+
+	// masked status values are exported masked
+	handler = newTwinMakerHandler(client, newRedactor([]models.RedactionRule{{Pattern: alarmStatusProperty, Action: models.RedactionMask}}))
+	buf.Reset()
+	require.NoError(t, handler.ExportAlarmHistory(context.Background(), query, buf))
+	rows, err = csv.NewReader(buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, redactedValue, rows[1][5])
+}
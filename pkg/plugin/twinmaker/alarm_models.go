@@ -0,0 +1,181 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ioteventsdata"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+const (
+	// alarm components backed by a SiteWise alarm model name its IoT Events alarm model, the key
+	// value is only set for alarm models with a key
+	alarmModelNameProperty = "alarmModelName"
+	alarmModelKeyProperty  = "alarmKeyValue"
+
+	// IoT Events accepts at most 10 alarm actions per batch call
+	maxAlarmActionBatch = 10
+
+	alarmActionNote = "from Grafana"
+)
+
+// alarmModelAlarm is an alarm and the IoT Events alarm model instance behind it
+type alarmModelAlarm struct {
+	alarm    models.AlarmReference
+	model    string
+	keyValue *string
+}
+
+// alarmModelAlarms reads the alarm model of each alarm component. Alarms without an alarm model
+// are skipped, failed entity lookups are reported.
+func (r *twinMakerResource) alarmModelAlarms(ctx context.Context, alarms []models.AlarmReference) ([]alarmModelAlarm, []models.AlarmReference, []models.AlarmAckFailure) {
+	found := []alarmModelAlarm{}
+	skipped := []models.AlarmReference{}
+	failed := []models.AlarmAckFailure{}
+	for _, alarm := range alarms {
+		e, err := r.client.GetEntity(ctx, models.TwinMakerQuery{WorkspaceId: r.workspaceId, EntityId: alarm.EntityId})
+		if err != nil {
+			failed = append(failed, models.AlarmAckFailure{
+				AlarmReference: alarm,
+				ErrorMessage:   fmt.Sprintf("alarm model lookup: %s", err.Error()),
+			})
+			continue
+		}
+		component := e.Components[alarm.ComponentName]
+		if component == nil {
+			skipped = append(skipped, alarm)
+			continue
+		}
+		model := component.Properties[alarmModelNameProperty]
+		if model == nil || model.Value == nil || aws.StringValue(model.Value.StringValue) == "" {
+			skipped = append(skipped, alarm)
+			continue
+		}
+		a := alarmModelAlarm{alarm: alarm, model: *model.Value.StringValue}
+		if key := component.Properties[alarmModelKeyProperty]; key != nil && key.Value != nil && aws.StringValue(key.Value.StringValue) != "" {
+			a.keyValue = key.Value.StringValue
+		}
+		found = append(found, a)
+	}
+	return found, skipped, failed
+}
+
+// sendAlarmActions calls send in batches, the request id of an alarm is its index. Alarms IoT
+// Events rejected are reported with the error code.
+func sendAlarmActions(alarms []alarmModelAlarm, send func(start int, batch []alarmModelAlarm) ([]*ioteventsdata.BatchAlarmActionErrorEntry, error)) ([]models.AlarmReference, []models.AlarmAckFailure) {
+	done := []models.AlarmReference{}
+	failed := []models.AlarmAckFailure{}
+	for start := 0; start < len(alarms); start += maxAlarmActionBatch {
+		end := start + maxAlarmActionBatch
+		if end > len(alarms) {
+			end = len(alarms)
+		}
+		batch := alarms[start:end]
+
+		errorEntries, err := send(start, batch)
+		if err != nil {
+			for _, a := range batch {
+				failed = append(failed, models.AlarmAckFailure{
+					AlarmReference: a.alarm,
+					ErrorMessage:   fmt.Sprintf("IoT Events: %s", err.Error()),
+				})
+			}
+			continue
+		}
+
+		rejected := map[int]bool{}
+		for _, e := range errorEntries {
+			i, err := strconv.Atoi(aws.StringValue(e.RequestId))
+			if err != nil || i < start || i >= end {
+				continue
+			}
+			rejected[i] = true
+			failed = append(failed, models.AlarmAckFailure{
+				AlarmReference: alarms[i].alarm,
+				ErrorCode:      aws.StringValue(e.ErrorCode),
+				ErrorMessage:   fmt.Sprintf("IoT Events: %s", aws.StringValue(e.ErrorMessage)),
+			})
+		}
+		for i, a := range batch {
+			if !rejected[start+i] {
+				done = append(done, a.alarm)
+			}
+		}
+	}
+	return done, failed
+}
+
+// acknowledgeAlarmModels acknowledges the alarms of SiteWise alarm models in IoT Events, so the
+// alarm state is the same in the AWS consoles
+func (r *twinMakerResource) acknowledgeAlarmModels(ctx context.Context, alarms []models.AlarmReference) []models.AlarmAckFailure {
+	found, _, failed := r.alarmModelAlarms(ctx, alarms)
+	_, rejected := sendAlarmActions(found, func(start int, batch []alarmModelAlarm) ([]*ioteventsdata.BatchAlarmActionErrorEntry, error) {
+		input := &ioteventsdata.BatchAcknowledgeAlarmInput{}
+		for i, a := range batch {
+			input.AcknowledgeActionRequests = append(input.AcknowledgeActionRequests, &ioteventsdata.AcknowledgeAlarmActionRequest{
+				AlarmModelName: aws.String(a.model),
+				KeyValue:       a.keyValue,
+				Note:           aws.String(alarmActionNote),
+				RequestId:      aws.String(strconv.Itoa(start + i)),
+			})
+		}
+		rsp, err := r.client.BatchAcknowledgeAlarm(ctx, input)
+		if err != nil || rsp == nil {
+			return nil, err
+		}
+		return rsp.ErrorEntries, nil
+	})
+	return append(failed, rejected...)
+}
+
+// SnoozeAlarms snoozes alarms of SiteWise alarm models in IoT Events for the duration. Alarms
+// without an alarm model can not be snoozed and are reported as failed.
+func (r *twinMakerResource) SnoozeAlarms(ctx context.Context, alarms []models.AlarmReference, duration time.Duration) (models.AlarmSnoozeReport, error) {
+	report := models.AlarmSnoozeReport{
+		Snoozed: []models.AlarmReference{},
+	}
+	if !r.alarmModelSync {
+		return report, fmt.Errorf("alarm model sync is not enabled in datasource configuration")
+	}
+	if len(alarms) == 0 {
+		return report, fmt.Errorf("missing alarms")
+	}
+	if duration < time.Second {
+		return report, fmt.Errorf("missing snooze duration")
+	}
+
+	found, skipped, failed := r.alarmModelAlarms(ctx, alarms)
+	for _, alarm := range skipped {
+		failed = append(failed, models.AlarmAckFailure{
+			AlarmReference: alarm,
+			ErrorMessage:   "not an alarm of a SiteWise alarm model",
+		})
+	}
+	snoozed, rejected := sendAlarmActions(found, func(start int, batch []alarmModelAlarm) ([]*ioteventsdata.BatchAlarmActionErrorEntry, error) {
+		input := &ioteventsdata.BatchSnoozeAlarmInput{}
+		for i, a := range batch {
+			input.SnoozeActionRequests = append(input.SnoozeActionRequests, &ioteventsdata.SnoozeAlarmActionRequest{
+				AlarmModelName: aws.String(a.model),
+				KeyValue:       a.keyValue,
+				Note:           aws.String(alarmActionNote),
+				RequestId:      aws.String(strconv.Itoa(start + i)),
+				SnoozeDuration: aws.Int64(int64(duration.Seconds())),
+			})
+		}
+		rsp, err := r.client.BatchSnoozeAlarm(ctx, input)
+		if err != nil || rsp == nil {
+			return nil, err
+		}
+		return rsp.ErrorEntries, nil
+	})
+
+	report.Snoozed = append(report.Snoozed, snoozed...)
+	if failed = append(failed, rejected...); len(failed) > 0 {
+		report.Failed = failed
+	}
+	return report, nil
+}
@@ -0,0 +1,140 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ioteventsdata"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type alarmModelMockClient struct {
+	*batchPutMockClient
+	acknowledged [][]*ioteventsdata.AcknowledgeAlarmActionRequest
+	snoozed      [][]*ioteventsdata.SnoozeAlarmActionRequest
+	rejectModel  map[string]bool
+}
+
+// GetEntity returns alarm components with an alarm model for the even mixers
+func (c *alarmModelMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	var n int
+	_, _ = fmt.Sscanf(query.EntityId, "Mixer_%d", &n)
+	properties := map[string]*iottwinmaker.PropertyResponse{}
+	if n%2 == 0 {
+		properties[alarmModelNameProperty] = &iottwinmaker.PropertyResponse{
+			Value: &iottwinmaker.DataValue{StringValue: aws.String(fmt.Sprintf("model_%d", n))},
+		}
+		properties[alarmModelKeyProperty] = &iottwinmaker.PropertyResponse{
+			Value: &iottwinmaker.DataValue{StringValue: aws.String(query.EntityId)},
+		}
+	}
+	return &iottwinmaker.GetEntityOutput{
+		EntityId: aws.String(query.EntityId),
+		Components: map[string]*iottwinmaker.ComponentResponse{
+			"AlarmComponent": {Properties: properties},
+		},
+	}, nil
+}
+
+func (c *alarmModelMockClient) BatchAcknowledgeAlarm(ctx context.Context, req *ioteventsdata.BatchAcknowledgeAlarmInput) (*ioteventsdata.BatchAcknowledgeAlarmOutput, error) {
+	c.acknowledged = append(c.acknowledged, req.AcknowledgeActionRequests)
+	rsp := &ioteventsdata.BatchAcknowledgeAlarmOutput{}
+	for _, r := range req.AcknowledgeActionRequests {
+		if c.rejectModel[*r.AlarmModelName] {
+			rsp.ErrorEntries = append(rsp.ErrorEntries, &ioteventsdata.BatchAlarmActionErrorEntry{
+				RequestId:    r.RequestId,
+				ErrorCode:    aws.String(ioteventsdata.ErrorCodeResourceNotFoundException),
+				ErrorMessage: aws.String("alarm not found"),
+			})
+		}
+	}
+	return rsp, nil
+}
+
+func (c *alarmModelMockClient) BatchSnoozeAlarm(ctx context.Context, req *ioteventsdata.BatchSnoozeAlarmInput) (*ioteventsdata.BatchSnoozeAlarmOutput, error) {
+	c.snoozed = append(c.snoozed, req.SnoozeActionRequests)
+	return &ioteventsdata.BatchSnoozeAlarmOutput{}, nil
+}
+
+func newAlarmModelMockClient() *alarmModelMockClient {
+	return &alarmModelMockClient{
+		batchPutMockClient: &batchPutMockClient{twinMakerMockClient: &twinMakerMockClient{}},
+		rejectModel:        map[string]bool{"model_4": true},
+	}
+}
+
+func mixerAlarms(count int) []models.AlarmReference {
+	alarms := []models.AlarmReference{}
+	for i := 0; i < count; i++ {
+		alarms = append(alarms, models.AlarmReference{
+			EntityId:      fmt.Sprintf("Mixer_%d", i),
+			ComponentName: "AlarmComponent",
+		})
+	}
+	return alarms
+}
+
+func TestAcknowledgeAlarmModels(t *testing.T) {
+	t.Run("only with alarm model sync", func(t *testing.T) {
+		client := newAlarmModelMockClient()
+		res := newTwinMakerResource(client, "AlarmWorkspace", nil)
+		report, err := res.AcknowledgeAlarms(context.Background(), mixerAlarms(4))
+		require.NoError(t, err)
+		require.Len(t, report.Acknowledged, 4)
+		require.Empty(t, client.acknowledged)
+	})
+
+	t.Run("acknowledges alarm model alarms in batches", func(t *testing.T) {
+		client := newAlarmModelMockClient()
+		res := newTwinMakerResource(client, "AlarmWorkspace", nil)
+		res.alarmModelSync = true
+
+		report, err := res.AcknowledgeAlarms(context.Background(), mixerAlarms(24))
+		require.NoError(t, err)
+		// the alarm IoT Events rejects is only failed
+		require.Len(t, report.Acknowledged, 23)
+		require.NotContains(t, report.Acknowledged, models.AlarmReference{EntityId: "Mixer_4", ComponentName: "AlarmComponent"})
+
+		// 12 even mixers have an alarm model
+		require.Len(t, client.acknowledged, 2)
+		require.Len(t, client.acknowledged[0], maxAlarmActionBatch)
+		require.Len(t, client.acknowledged[1], 2)
+		first := client.acknowledged[0][0]
+		require.Equal(t, "model_0", *first.AlarmModelName)
+		require.Equal(t, "Mixer_0", *first.KeyValue)
+		require.Equal(t, "0", *first.RequestId)
+		require.Equal(t, "10", *client.acknowledged[1][0].RequestId)
+
+		require.Equal(t, []models.AlarmAckFailure{{
+			AlarmReference: models.AlarmReference{EntityId: "Mixer_4", ComponentName: "AlarmComponent"},
+			ErrorCode:      ioteventsdata.ErrorCodeResourceNotFoundException,
+			ErrorMessage:   "IoT Events: alarm not found",
+		}}, report.Failed)
+	})
+}
+
+func TestSnoozeAlarms(t *testing.T) {
+	client := newAlarmModelMockClient()
+	res := newTwinMakerResource(client, "AlarmWorkspace", nil)
+
+	_, err := res.SnoozeAlarms(context.Background(), mixerAlarms(2), time.Hour)
+	require.EqualError(t, err, "alarm model sync is not enabled in datasource configuration")
+
+	res.alarmModelSync = true
+	_, err = res.SnoozeAlarms(context.Background(), mixerAlarms(2), 0)
+	require.EqualError(t, err, "missing snooze duration")
+
+	report, err := res.SnoozeAlarms(context.Background(), mixerAlarms(2), time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []models.AlarmReference{{EntityId: "Mixer_0", ComponentName: "AlarmComponent"}}, report.Snoozed)
+	require.Equal(t, []models.AlarmAckFailure{{
+		AlarmReference: models.AlarmReference{EntityId: "Mixer_1", ComponentName: "AlarmComponent"},
+		ErrorMessage:   "not an alarm of a SiteWise alarm model",
+	}}, report.Failed)
+	require.Equal(t, int64(3600), *client.snoozed[0][0].SnoozeDuration)
+}
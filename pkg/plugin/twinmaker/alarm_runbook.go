@@ -0,0 +1,43 @@
+package twinmaker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// runbookProperties are the component type properties whose default value links the runbook of an alarm
+var runbookProperties = []string{"runbookUrl", "runbook_url", "runbookURL", "runbook"}
+
+// alarmRunbook returns the runbook URL the alarm component type defines, empty when it has none
+func (s *twinMakerHandler) alarmRunbook(ctx context.Context, query models.TwinMakerQuery) string {
+	ct, err := s.client.GetComponentType(ctx, query)
+	if err != nil || ct == nil {
+		return ""
+	}
+	for _, name := range runbookProperties {
+		definition, ok := ct.PropertyDefinitions[name]
+		if !ok || definition == nil || definition.DefaultValue == nil {
+			continue
+		}
+		if url := aws.StringValue(definition.DefaultValue.StringValue); strings.Contains(url, "://") {
+			return url
+		}
+	}
+	return ""
+}
+
+// setRunbookLink links the alarm name to the runbook column of the row
+func setRunbookLink(field *data.Field) {
+	if field.Config == nil {
+		field.Config = &data.FieldConfig{}
+	}
+	field.Config.Links = append(field.Config.Links, data.DataLink{
+		Title:       "Runbook",
+		URL:         "${__data.fields.runbook}",
+		TargetBlank: true,
+	})
+}
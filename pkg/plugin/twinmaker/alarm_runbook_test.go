@@ -0,0 +1,51 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type runbookMockClient struct {
+	*alarmExportMockClient
+}
+
+func (c *runbookMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	ct, err := c.alarmExportMockClient.GetComponentType(ctx, query)
+	ct.PropertyDefinitions["runbookUrl"] = &iottwinmaker.PropertyDefinitionResponse{
+		DefaultValue: &iottwinmaker.DataValue{StringValue: aws.String("https://wiki.example.com/runbooks/temperature")},
+	}
+	return ct, err
+}
+
+func TestGetAlarmsRunbook(t *testing.T) {
+	query := models.TwinMakerQuery{
+		WorkspaceId: "w",
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	// without a runbook the frame is unchanged
+	handler := newTwinMakerHandler(&alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}, nil)
+	dr := handler.GetAlarms(context.Background(), query)
+	require.NoError(t, dr.Error)
+	field, _ := dr.Frames[0].FieldByName("runbook")
+	require.Nil(t, field)
+
+	handler = newTwinMakerHandler(&runbookMockClient{&alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}}, nil)
+	dr = handler.GetAlarms(context.Background(), query)
+	require.NoError(t, dr.Error)
+	field, _ = dr.Frames[0].FieldByName("runbook")
+	require.NotNil(t, field)
+	require.Equal(t, "https://wiki.example.com/runbooks/temperature", *field.At(0).(*string))
+	name, _ := dr.Frames[0].FieldByName("alarmName")
+	require.Equal(t, "${__data.fields.runbook}", name.Config.Links[0].URL)
+}
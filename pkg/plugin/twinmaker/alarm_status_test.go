@@ -0,0 +1,44 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAlarmsStatus(t *testing.T) {
+	client := &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	query := models.TwinMakerQuery{
+		WorkspaceId: "w",
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+		},
+		AlarmStatus: "ACTIVE",
+	}
+
+	dr := handler.GetAlarms(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.NotEmpty(t, client.historyCalls)
+	filter := client.historyCalls[0].PropertyFilter
+	require.Len(t, filter, 1)
+	require.Equal(t, alarmStatusProperty, filter[0].Name)
+	require.Equal(t, "ACTIVE", *filter[0].Value.StringValue)
+
+	status, _ := dr.Frames[0].FieldByName("alarmStatus")
+	require.NotNil(t, status)
+	require.Equal(t, "Status", status.Config.DisplayName)
+	mapper := status.Config.Mappings[0].(data.ValueMapper)
+	require.Equal(t, "red", mapper["ACTIVE"].Color)
+	require.Equal(t, "blue", mapper["ACKNOWLEDGED"].Color)
+
+	query.AlarmStatus = "FIRING"
+	dr = handler.GetAlarms(context.Background(), query)
+	require.Error(t, dr.Error)
+}
@@ -0,0 +1,100 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	// AlarmSummaryTTL is how long alarm counts are reused, pollers get them from the cache
+	AlarmSummaryTTL = 30 * time.Second
+	// the latest status of an alarm is read from this much history
+	alarmSummaryWindow = 7 * 24 * time.Hour
+)
+
+func newAlarmSummaryCache() *cache.Cache {
+	return cache.New(AlarmSummaryTTL, AlarmSummaryTTL*2)
+}
+
+// AlarmSummaries counts the alarms of each workspace by their latest status, in the order of the
+// workspaces. The counts come from GetAlarms queries of the request role, or of the query role when
+// roleArn is set, and are cached for AlarmSummaryTTL, so frequent pollers such as status pages do
+// not load the alarm history.
+func (ds *Datasource) AlarmSummaries(ctx context.Context, workspaces []string, roleArn string) ([]models.AlarmSummary, error) {
+	handler, err := ds.queryHandler(ctx, models.TwinMakerQuery{RoleArn: roleArn})
+	if err != nil {
+		return nil, err
+	}
+	role := roleArn
+	if ds.Viewer != nil && usesViewerRole(ctx) {
+		// the viewer role takes precedence over query roles
+		role = "viewer"
+	}
+
+	summaries := make([]models.AlarmSummary, len(workspaces))
+	slots := make(chan struct{}, maxWorkspaceChecks)
+	var wg sync.WaitGroup
+	for i, id := range workspaces {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			summaries[i] = ds.alarmSummary(ctx, handler, id, role)
+		}(i, id)
+	}
+	wg.Wait()
+	return summaries, nil
+}
+
+// the roles may see different alarms, role is empty for the primary role
+func alarmSummaryKey(workspaceId string, role string) string {
+	return fmt.Sprintf("%s/%s", workspaceId, role)
+}
+
+func (ds *Datasource) alarmSummary(ctx context.Context, handler TwinMakerHandler, id string, role string) models.AlarmSummary {
+	key := alarmSummaryKey(id, role)
+	if v, ok := ds.alarmSummaries.Get(key); ok {
+		return v.(models.AlarmSummary)
+	}
+
+	summary := models.AlarmSummary{WorkspaceId: id}
+	now := time.Now()
+	dr := handler.GetAlarms(ctx, models.TwinMakerQuery{
+		WorkspaceId: id,
+		TimeRange:   backend.TimeRange{From: now.Add(-alarmSummaryWindow), To: now},
+	})
+	if dr.Error != nil {
+		summary.Message = workspaceCheckMessage(dr.Error)
+		return summary
+	}
+	for _, frame := range dr.Frames {
+		status, _ := frame.FieldByName("alarmStatus")
+		if status == nil {
+			continue
+		}
+		for i := 0; i < status.Len(); i++ {
+			v, ok := status.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			summary.Total++
+			switch v {
+			case "ACTIVE":
+				summary.Active++
+			case "ACKNOWLEDGED":
+				summary.Acknowledged++
+			case "SNOOZE_DISABLED":
+				summary.Snoozed++
+			}
+		}
+	}
+	ds.alarmSummaries.SetDefault(key, summary)
+	return summary
+}
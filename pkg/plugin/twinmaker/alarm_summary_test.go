@@ -0,0 +1,52 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlarmSummaries(t *testing.T) {
+	client := &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, client)
+
+	summaries, err := ds.AlarmSummaries(context.Background(), []string{"w"}, "")
+	require.NoError(t, err)
+	require.Equal(t, []models.AlarmSummary{{WorkspaceId: "w", Active: 1, Total: 1}}, summaries)
+	calls := len(client.historyCalls)
+	require.NotZero(t, calls)
+
+	// polls within the TTL are served from the cache
+	cached, err := ds.AlarmSummaries(context.Background(), []string{"w"}, "")
+	require.NoError(t, err)
+	require.Equal(t, summaries, cached)
+	require.Len(t, client.historyCalls, calls)
+}
+
+func TestAlarmSummariesQueryRole(t *testing.T) {
+	client := &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	role := &alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{
+		WorkspaceID:   "w",
+		QueryRoleARNs: []string{"arn:aws:iam::123456789012:role/maintenance"},
+	}, client)
+	ds.SetQueryRoleClients(func(roleArn string) (TwinMakerClient, error) {
+		return role, nil
+	})
+
+	_, err := ds.AlarmSummaries(context.Background(), []string{"w"}, "")
+	require.NoError(t, err)
+	calls := len(client.historyCalls)
+
+	// the counts of the primary role are not reused for the query role
+	summaries, err := ds.AlarmSummaries(context.Background(), []string{"w"}, "arn:aws:iam::123456789012:role/maintenance")
+	require.NoError(t, err)
+	require.Equal(t, []models.AlarmSummary{{WorkspaceId: "w", Active: 1, Total: 1}}, summaries)
+	require.Len(t, client.historyCalls, calls)
+	require.NotEmpty(t, role.historyCalls)
+
+	_, err = ds.AlarmSummaries(context.Background(), []string{"w"}, "arn:aws:iam::123456789012:role/admin")
+	require.Error(t, err)
+}
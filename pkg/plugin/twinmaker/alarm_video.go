@@ -0,0 +1,175 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesisvideoarchivedmedia"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// videoStreamProperty is the property of the video component naming its Kinesis video stream
+const videoStreamProperty = "kvsStreamName"
+
+// maxVideoWindows bounds the alarm windows whose recordings are listed, the latest ones
+const maxVideoWindows = 50
+
+// videoComponent is the first component of the entity with a kvsStreamName value, by name. The
+// stream name is not redacted, it is only shown redacted.
+func (s *twinMakerHandler) videoComponent(ctx context.Context, query models.TwinMakerQuery) (string, string, error) {
+	query.ComponentName = ""
+	entity, err := s.client.GetEntity(ctx, query)
+	if err != nil {
+		return "", "", err
+	}
+	names := []string{}
+	for name := range entity.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		component := entity.Components[name]
+		if component == nil || component.Properties[videoStreamProperty] == nil {
+			continue
+		}
+		if v := component.Properties[videoStreamProperty].Value; v != nil && aws.StringValue(v.StringValue) != "" {
+			return name, *v.StringValue, nil
+		}
+	}
+	return "", "", fmt.Errorf("entity %s has no video component with a %s value", query.EntityId, videoStreamProperty)
+}
+
+// recordedDuration is the time between start and end covered by the fragments
+func recordedDuration(fragments []*kinesisvideoarchivedmedia.Fragment, start time.Time, end time.Time) time.Duration {
+	type interval struct{ from, to time.Time }
+	intervals := []interval{}
+	for _, f := range fragments {
+		if f == nil || f.ProducerTimestamp == nil {
+			continue
+		}
+		from := *f.ProducerTimestamp
+		to := from.Add(time.Duration(aws.Int64Value(f.FragmentLengthInMilliseconds)) * time.Millisecond)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			intervals = append(intervals, interval{from, to})
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].from.Before(intervals[j].from) })
+
+	// fragments may overlap, they are merged before adding up
+	var recorded time.Duration
+	var last time.Time
+	for _, i := range intervals {
+		if i.from.Before(last) {
+			i.from = last
+		}
+		if i.to.After(i.from) {
+			recorded += i.to.Sub(i.from)
+			last = i.to
+		}
+	}
+	return recorded
+}
+
+// GetAlarmVideo returns the alarm windows of an entity in the time range with the Kinesis video
+// stream of the entity and how much of each window was recorded, so alarm tables can link to the
+// video at the incident. The stream is read from the kvsStreamName of the entity's video component.
+func (s *twinMakerHandler) GetAlarmVideo(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" {
+		dr.Error = fmt.Errorf("missing entity parameter")
+		return
+	}
+	videoComponent, stream, err := s.videoComponent(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+	windows, failures, err := s.alarmHistoryWindows(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	// the recordings are listed with the stream, the frame shows it redacted
+	shown := s.redaction.valueString(videoStreamProperty, stream)
+	first := 0
+	if len(windows) > maxVideoWindows {
+		first = len(windows) - maxVideoWindows
+		failures = append(failures, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("recordings of the latest %d of %d alarm windows are listed", maxVideoWindows, len(windows)),
+		})
+	}
+
+	fields := newTwinMakerFrameBuilder(len(windows))
+	t := fields.Time()
+	timeEnd := fields.TimeEnd()
+	alarm := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(windows)), "alarm")
+	state := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(windows)), "state")
+	entityId := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(windows)), "entityId")
+	componentName := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(windows)), "componentName")
+	streamName := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(windows)), videoStreamProperty)
+	recorded := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, len(windows)), "recorded")
+	recorded.Config = &data.FieldConfig{Unit: "s"}
+	coverage := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, len(windows)), "coverage")
+	coverage.Config = &data.FieldConfig{Unit: "percentunit"}
+	truncated := 0
+	for i, w := range windows {
+		w := w
+		t.Set(i, &w.start)
+		timeEnd.Set(i, &w.end)
+		alarm.Set(i, w.alarm)
+		state.Set(i, w.state)
+		entityId.Set(i, query.EntityId)
+		componentName.Set(i, videoComponent)
+		streamName.Set(i, shown)
+		if i < first {
+			continue
+		}
+
+		fragments, err := s.client.ListVideoFragments(ctx, stream, w.start, w.end)
+		if err != nil {
+			// the window is still listed, without its recording
+			failures = append(failures, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("recordings of %s at %s: %s", shown, w.start.Format(time.RFC3339), err.Error()),
+			})
+			continue
+		}
+		if fragments.NextToken != nil {
+			truncated++
+		}
+		d := recordedDuration(fragments.Fragments, w.start, w.end)
+		seconds := d.Seconds()
+		recorded.Set(i, &seconds)
+		if length := w.end.Sub(w.start); length > 0 {
+			c := float64(d) / float64(length)
+			coverage.Set(i, &c)
+		}
+	}
+	if truncated > 0 {
+		failures = append(failures, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d alarm windows have more than %d pages of fragments, their recorded time is too low", truncated, maxVideoPages),
+		})
+	}
+
+	name := shown
+	if name == "" {
+		name = query.EntityId
+	}
+	frame := fields.ToFrame(name, nil)
+	frame.AppendNotices(failures...)
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
@@ -0,0 +1,152 @@
+package twinmaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/aws/aws-sdk-go/service/kinesisvideo"
+	"github.com/aws/aws-sdk-go/service/kinesisvideoarchivedmedia"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+)
+
+type alarmVideoMockClient struct {
+	*alarmEntityMockClient
+	videoCalls []string
+	truncated  bool
+}
+
+func (c *alarmVideoMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	e, err := c.alarmEntityMockClient.GetEntity(ctx, query)
+	e.Components["Camera"] = &iottwinmaker.ComponentResponse{Properties: map[string]*iottwinmaker.PropertyResponse{
+		videoStreamProperty: {Value: &iottwinmaker.DataValue{StringValue: aws.String("mixer-0-camera")}},
+	}}
+	return e, err
+}
+
+func (c *alarmVideoMockClient) ListVideoFragments(ctx context.Context, streamName string, start time.Time, end time.Time) (*kinesisvideoarchivedmedia.ListFragmentsOutput, error) {
+	c.videoCalls = append(c.videoCalls, streamName)
+	if start.Minute() != 0 {
+		return nil, errors.New("stream not found")
+	}
+	fragment := func(t time.Time, seconds int64) *kinesisvideoarchivedmedia.Fragment {
+		return &kinesisvideoarchivedmedia.Fragment{ProducerTimestamp: &t, FragmentLengthInMilliseconds: aws.Int64(seconds * 1000)}
+	}
+	out := &kinesisvideoarchivedmedia.ListFragmentsOutput{
+		// overlapping fragments, the second one ends at 10:01:30
+		Fragments: []*kinesisvideoarchivedmedia.Fragment{
+			fragment(start.Add(-30*time.Second), 60),
+			fragment(start.Add(30*time.Second), 60),
+		},
+	}
+	if c.truncated {
+		out.NextToken = aws.String("next")
+	}
+	return out, nil
+}
+
+func TestGetAlarmVideo(t *testing.T) {
+	query := models.TwinMakerQuery{
+		WorkspaceId: "w",
+		EntityId:    "Mixer_0",
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	client := &alarmVideoMockClient{alarmEntityMockClient: &alarmEntityMockClient{&alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}}}
+	handler := newTwinMakerHandler(client, nil)
+
+	dr := handler.GetAlarmVideo(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, []string{"mixer-0-camera", "mixer-0-camera"}, client.videoCalls)
+
+	frame := dr.Frames[0]
+	require.Equal(t, 2, frame.Rows())
+	alarm, _ := frame.FieldByName("alarm")
+	component, _ := frame.FieldByName("componentName")
+	stream, _ := frame.FieldByName(videoStreamProperty)
+	recorded, _ := frame.FieldByName("recorded")
+	coverage, _ := frame.FieldByName("coverage")
+	require.Equal(t, "alarm-1", alarm.At(0))
+	require.Equal(t, "Camera", component.At(0))
+	require.Equal(t, "mixer-0-camera", stream.At(0))
+
+	// 10:00 to 10:01:30 of the five minute ACTIVE window
+	require.Equal(t, 90.0, *recorded.At(0).(*float64))
+	require.Equal(t, 0.3, *coverage.At(0).(*float64))
+
+	// the ACKNOWLEDGED window is listed without its recording
+	require.Nil(t, recorded.At(1))
+	require.Len(t, frame.Meta.Notices, 1)
+
+	// fragments beyond the page limit are noticed
+	client.truncated = true
+	dr = handler.GetAlarmVideo(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, dr.Frames[0].Meta.Notices, 2)
+	require.Contains(t, dr.Frames[0].Meta.Notices[1].Text, "1 alarm windows have more than 10 pages")
+
+	query.EntityId = ""
+	dr = handler.GetAlarmVideo(context.Background(), query)
+	require.Error(t, dr.Error)
+}
+
+func TestAlarmVideoRedaction(t *testing.T) {
+	query := models.TwinMakerQuery{
+		WorkspaceId: "w",
+		EntityId:    "Mixer_0",
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	client := &alarmVideoMockClient{alarmEntityMockClient: &alarmEntityMockClient{&alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}}}
+	handler := newTwinMakerHandler(client, newRedactor([]models.RedactionRule{{Pattern: videoStreamProperty}}))
+
+	dr := handler.GetAlarmVideo(context.Background(), query)
+	require.NoError(t, dr.Error)
+	// the recordings are still listed with the stream
+	require.Equal(t, []string{"mixer-0-camera", "mixer-0-camera"}, client.videoCalls)
+	frame := dr.Frames[0]
+	require.Equal(t, redactedValue, frame.Name)
+	stream, _ := frame.FieldByName(videoStreamProperty)
+	require.Equal(t, redactedValue, stream.At(0))
+	require.NotContains(t, frame.Meta.Notices[0].Text, "mixer-0-camera")
+}
+
+func TestVideoEndpointCached(t *testing.T) {
+	endpoints := []string{}
+	c := &twinMakerClient{
+		videoEndpoints: cache.New(videoEndpointTTL, videoEndpointTTL),
+		videoService: func() (*kinesisvideo.KinesisVideo, error) {
+			return nil, errors.New("GetDataEndpoint called")
+		},
+		videoMediaService: func(endpoint string) (*kinesisvideoarchivedmedia.KinesisVideoArchivedMedia, error) {
+			endpoints = append(endpoints, endpoint)
+			return nil, errors.New("no media")
+		},
+	}
+	c.videoEndpoints.SetDefault("mixer-0-camera", "https://media.example.com")
+	for i := 0; i < 2; i++ {
+		_, err := c.ListVideoFragments(context.Background(), "mixer-0-camera", time.Now(), time.Now())
+		require.EqualError(t, err, "no media")
+	}
+	require.Equal(t, []string{"https://media.example.com", "https://media.example.com"}, endpoints)
+
+	_, err := c.ListVideoFragments(context.Background(), "other-camera", time.Now(), time.Now())
+	require.EqualError(t, err, "GetDataEndpoint called")
+}
+
+func TestVideoComponentMissing(t *testing.T) {
+	client := &alarmEntityMockClient{&alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}}
+	handler := newTwinMakerHandler(client, nil)
+	dr := handler.GetAlarmVideo(context.Background(), models.TwinMakerQuery{WorkspaceId: "w", EntityId: "Mixer_0"})
+	require.EqualError(t, dr.Error, "entity Mixer_0 has no video component with a kvsStreamName value")
+}
@@ -0,0 +1,82 @@
+package twinmaker
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/patrickmn/go-cache"
+)
+
+// alignmentTTL is how long the sample interval detected for a query aligns its next runs
+const alignmentTTL = time.Hour
+
+func newIntervalCache() *cache.Cache {
+	return cache.New(alignmentTTL, alignmentTTL*2)
+}
+
+// sampleInterval is the typical time between the values of the first page, the median gap so
+// single outages or bursts do not change it. Intervals of a second or more are whole seconds.
+func sampleInterval(values []*iottwinmaker.PropertyValue) time.Duration {
+	if len(values) > maxHistoryPageSize {
+		values = values[:maxHistoryPageSize]
+	}
+	gaps := make([]time.Duration, 0, len(values))
+	var last *time.Time
+	for _, v := range values {
+		t, err := getTimeObjectFromStringTime(v.Time)
+		if err != nil {
+			continue
+		}
+		if last != nil {
+			gap := t.Sub(*last)
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap > 0 {
+				gaps = append(gaps, gap)
+			}
+		}
+		last = t
+	}
+	if len(gaps) == 0 {
+		return 0
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	interval := gaps[len(gaps)/2]
+	if interval >= time.Second {
+		interval = interval.Round(time.Second)
+	}
+	return interval
+}
+
+// alignStart snaps the start of the time range down to a boundary of the interval remembered for
+// the query, so the buckets of bar and heatmap panels line up with the samples. Until an interval
+// is detected, the expected interval of the property hints is used, then the interval of the panel.
+func (s *twinMakerHandler) alignStart(query *models.TwinMakerQuery, expected time.Duration) {
+	if !query.AlignToResolution {
+		return
+	}
+	key := query.CacheKey("align")
+	if key == "" {
+		return // continued pages keep the start of the first page
+	}
+	if v, ok := s.intervals.Get(key); ok {
+		query.TimeRange.From = query.TimeRange.From.Truncate(v.(time.Duration))
+	} else if expected > 0 {
+		query.TimeRange.From = query.TimeRange.From.Truncate(expected)
+	} else if query.Interval > 0 || query.MaxDataPoints > 0 {
+		query.TimeRange.From = query.TimeRange.From.Truncate(interpolationInterval(*query))
+	}
+}
+
+// rememberInterval keeps the sample interval detected from the first page for the next runs
+func (s *twinMakerHandler) rememberInterval(query models.TwinMakerQuery, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	if key := query.CacheKey("align"); key != "" {
+		s.intervals.SetDefault(key, interval)
+	}
+}
@@ -0,0 +1,83 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func samples(start time.Time, gaps ...time.Duration) []*iottwinmaker.PropertyValue {
+	values := []*iottwinmaker.PropertyValue{}
+	t := start
+	values = append(values, &iottwinmaker.PropertyValue{Time: aws.String(t.Format(time.RFC3339Nano)), Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(1)}})
+	for _, gap := range gaps {
+		t = t.Add(gap)
+		values = append(values, &iottwinmaker.PropertyValue{Time: aws.String(t.Format(time.RFC3339Nano)), Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(1)}})
+	}
+	return values
+}
+
+func TestSampleInterval(t *testing.T) {
+	start := time.Date(2022, 4, 27, 10, 0, 3, 0, time.UTC)
+	require.Equal(t, time.Duration(0), sampleInterval(samples(start)))
+	// an outage does not change the typical interval
+	require.Equal(t, time.Minute, sampleInterval(samples(start, time.Minute, time.Minute, time.Hour, 59*time.Second+800*time.Millisecond)))
+	require.Equal(t, 250*time.Millisecond, sampleInterval(samples(start, 250*time.Millisecond, 250*time.Millisecond)))
+}
+
+type alignmentMockClient struct {
+	*twinMakerMockClient
+	from []time.Time
+}
+
+func (c *alignmentMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	c.from = append(c.from, query.TimeRange.From)
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String("Mixer_0"),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Temperature"),
+			},
+			Values: samples(time.Date(2022, 4, 27, 10, 5, 0, 0, time.UTC), 5*time.Minute, 5*time.Minute),
+		}},
+	}, nil
+}
+
+func TestAlignToResolution(t *testing.T) {
+	client := &alignmentMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	from := time.Date(2022, 4, 27, 10, 2, 30, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		EntityId:          "Mixer_0",
+		ComponentName:     "MixerComponent",
+		Properties:        []*string{aws.String("Temperature")},
+		AlignToResolution: true,
+		TimeRange:         backend.TimeRange{From: from, To: from.Add(time.Hour)},
+	}
+
+	dr := handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	timeField, _ := dr.Frames[0].FieldByName(data.TimeSeriesTimeFieldName)
+	require.NotNil(t, timeField)
+	require.Equal(t, float64(5*time.Minute/time.Millisecond), timeField.Config.Interval)
+
+	// the next run starts on the interval boundary
+	dr = handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, []time.Time{from, time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)}, client.from)
+
+	// without a remembered interval the first run aligns to the interval of the panel
+	client = &alignmentMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	query.MaxDataPoints = 20
+	dr = newTwinMakerHandler(client, nil).GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, []time.Time{time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)}, client.from)
+}
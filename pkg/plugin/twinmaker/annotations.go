@@ -0,0 +1,95 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// TwinMaker string values are at most 256 characters
+const maxAnnotationNoteLength = 256
+
+// annotationNoteValue is the annotation text with its tags appended as #tag
+func annotationNoteValue(note models.AnnotationNote) string {
+	value := strings.TrimSpace(note.Text)
+	for _, tag := range note.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			value += " #" + tag
+		}
+	}
+	return value
+}
+
+// WriteAnnotation stores a Grafana annotation as a timestamped value of the note property of the
+// entity component. Only string time series properties the component already defines are written,
+// so an annotation can not overwrite other data or add properties.
+func (r *twinMakerResource) WriteAnnotation(ctx context.Context, propertyName string, note models.AnnotationNote) (models.AnnotationNoteResult, error) {
+	result := models.AnnotationNoteResult{
+		EntityId:      note.EntityId,
+		ComponentName: note.ComponentName,
+		PropertyName:  propertyName,
+	}
+	if note.EntityId == "" || note.ComponentName == "" {
+		return result, fmt.Errorf("missing entityId or componentName")
+	}
+	if note.Time <= 0 {
+		return result, fmt.Errorf("missing time")
+	}
+	result.Value = annotationNoteValue(note)
+	if result.Value == "" {
+		return result, fmt.Errorf("missing text")
+	}
+	if n := utf8.RuneCountInString(result.Value); n > maxAnnotationNoteLength {
+		return result, fmt.Errorf("annotation is %d characters long, notes are limited to %d", n, maxAnnotationNoteLength)
+	}
+
+	entity, err := r.client.GetEntity(ctx, models.TwinMakerQuery{WorkspaceId: r.workspaceId, EntityId: note.EntityId})
+	if err != nil {
+		return result, err
+	}
+	var property *iottwinmaker.PropertyResponse
+	if component, ok := entity.Components[note.ComponentName]; ok && component != nil {
+		property = component.Properties[propertyName]
+	}
+	if property == nil || property.Definition == nil || property.Definition.DataType == nil ||
+		aws.StringValue(property.Definition.DataType.Type) != iottwinmaker.TypeString {
+		return result, fmt.Errorf("component %s of entity %s has no string property %s", note.ComponentName, note.EntityId, propertyName)
+	}
+	if aws.BoolValue(property.Definition.IsExternalId) || aws.BoolValue(property.Definition.IsFinal) {
+		return result, fmt.Errorf("property %s can not hold annotations", propertyName)
+	}
+	// a property without history keeps only its last value, each note would replace the previous one
+	if !aws.BoolValue(property.Definition.IsTimeSeries) {
+		return result, fmt.Errorf("property %s is not a time series, notes would overwrite each other", propertyName)
+	}
+
+	result.Time = time.UnixMilli(note.Time).UTC().Format(time.RFC3339Nano)
+	rsp, err := r.BatchPutPropertyValues(ctx, []*iottwinmaker.PropertyValueEntry{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String(note.EntityId),
+			ComponentName: aws.String(note.ComponentName),
+			PropertyName:  aws.String(propertyName),
+		},
+		PropertyValues: []*iottwinmaker.PropertyValue{{
+			Time:  aws.String(result.Time),
+			Value: &iottwinmaker.DataValue{StringValue: aws.String(result.Value)},
+		}},
+	}})
+	if err != nil {
+		return result, err
+	}
+	if rsp != nil {
+		for _, errorEntry := range rsp.ErrorEntries {
+			for _, e := range errorEntry.Errors {
+				return result, fmt.Errorf("writing the annotation failed: %s", aws.StringValue(e.ErrorMessage))
+			}
+		}
+	}
+	return result, nil
+}
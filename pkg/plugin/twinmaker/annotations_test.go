@@ -0,0 +1,81 @@
+package twinmaker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type annotationMockClient struct {
+	*twinMakerMockClient
+	writes []*iottwinmaker.BatchPutPropertyValuesInput
+}
+
+func (c *annotationMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	property := func(dataType string, timeSeries bool) *iottwinmaker.PropertyResponse {
+		return &iottwinmaker.PropertyResponse{Definition: &iottwinmaker.PropertyDefinitionResponse{
+			DataType:     &iottwinmaker.DataType{Type: aws.String(dataType)},
+			IsExternalId: aws.Bool(false),
+			IsFinal:      aws.Bool(false),
+			IsTimeSeries: aws.Bool(timeSeries),
+		}}
+	}
+	return &iottwinmaker.GetEntityOutput{
+		EntityId: aws.String(query.EntityId),
+		Components: map[string]*iottwinmaker.ComponentResponse{
+			"MixerComponent": {Properties: map[string]*iottwinmaker.PropertyResponse{
+				"note":        property(iottwinmaker.TypeString, true),
+				"label":       property(iottwinmaker.TypeString, false),
+				"Temperature": property(iottwinmaker.TypeDouble, true),
+			}},
+		},
+	}, nil
+}
+
+func (c *annotationMockClient) BatchPutPropertyValues(ctx context.Context, req *iottwinmaker.BatchPutPropertyValuesInput) (*iottwinmaker.BatchPutPropertyValuesOutput, error) {
+	c.writes = append(c.writes, req)
+	return &iottwinmaker.BatchPutPropertyValuesOutput{}, nil
+}
+
+func TestWriteAnnotation(t *testing.T) {
+	client := &annotationMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	res := newTwinMakerResource(client, "w", nil)
+	at := time.Date(2022, 4, 27, 10, 30, 0, 0, time.UTC)
+	note := models.AnnotationNote{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Time:          at.UnixMilli(),
+		Text:          "bearing replaced ",
+		Tags:          []string{"maintenance"},
+	}
+
+	result, err := res.WriteAnnotation(context.Background(), "note", note)
+	require.NoError(t, err)
+	require.Equal(t, "bearing replaced #maintenance", result.Value)
+	require.Len(t, client.writes, 1)
+	entry := client.writes[0].Entries[0]
+	require.Equal(t, "note", *entry.EntityPropertyReference.PropertyName)
+	require.Equal(t, "2022-04-27T10:30:00Z", *entry.PropertyValues[0].Time)
+	require.Equal(t, "bearing replaced #maintenance", *entry.PropertyValues[0].Value.StringValue)
+
+	// guarded: only existing string properties are written
+	_, err = res.WriteAnnotation(context.Background(), "Temperature", note)
+	require.Error(t, err)
+	_, err = res.WriteAnnotation(context.Background(), "missing", note)
+	require.Error(t, err)
+	_, err = res.WriteAnnotation(context.Background(), "label", note)
+	require.ErrorContains(t, err, "not a time series")
+	long := note
+	long.Text = strings.Repeat("x", maxAnnotationNoteLength+1)
+	_, err = res.WriteAnnotation(context.Background(), "note", long)
+	require.Error(t, err)
+	_, err = res.WriteAnnotation(context.Background(), "note", models.AnnotationNote{EntityId: "Mixer_0", ComponentName: "MixerComponent", Text: "x"})
+	require.Error(t, err)
+	require.Len(t, client.writes, 1)
+}
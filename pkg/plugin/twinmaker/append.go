@@ -0,0 +1,115 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// appendCursor is the NextToken of append mode queries. Besides the AWS token it keeps how many
+// rows of each frame were already returned, so a page can say where its rows belong.
+type appendCursor struct {
+	NextToken string         `json:"t,omitempty"`
+	Offsets   map[string]int `json:"o,omitempty"`
+	// Alarms are not paged by AWS, this many are skipped instead
+	Skip int `json:"s,omitempty"`
+}
+
+func decodeAppendCursor(cursor string) (appendCursor, error) {
+	c := appendCursor{}
+	if cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			err = json.Unmarshal(b, &c)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid nextToken for append query")
+		}
+	}
+	if c.Offsets == nil {
+		c.Offsets = map[string]int{}
+	}
+	return c, nil
+}
+
+func (c appendCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// appendFrameKey identifies the same frame across pages, e.g. one history frame per property
+func appendFrameKey(frame *data.Frame) string {
+	key := frame.Name
+	for _, f := range frame.Fields {
+		if len(f.Labels) > 0 {
+			key += "/" + f.Labels.String()
+		}
+	}
+	return key
+}
+
+// queryAppend runs a single page of an append mode query. Each frame gets the row offset of
+// the page in its meta, the next page is requested with the returned NextToken.
+func (ds *Datasource) queryAppend(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
+	cursor, err := decodeAppendCursor(query.NextToken)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	query.NextToken = cursor.NextToken
+
+	pageSize := 0
+	if query.QueryType == models.QueryTypeGetAlarms {
+		pageSize = query.MaxResults
+		query.MaxResults += cursor.Skip
+	}
+
+	res := ds.query(ctx, query)
+	if res.Error != nil || len(res.Frames) == 0 {
+		return res
+	}
+
+	next := appendCursor{Offsets: map[string]int{}}
+	if meta := models.LoadMetaFromResponse(res); meta != nil {
+		next.NextToken = meta.NextToken
+	}
+	more := next.NextToken != ""
+
+	for _, frame := range res.Frames {
+		if pageSize > 0 {
+			for i := 0; i < cursor.Skip && frame.Rows() > 0; i++ {
+				frame.DeleteRow(0)
+			}
+			// a full page, there may be more alarms
+			more = frame.Rows() >= pageSize
+			next.Skip = cursor.Skip + frame.Rows()
+		}
+
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		meta, _ := frame.Meta.Custom.(models.TwinMakerCustomMeta)
+		key := appendFrameKey(frame)
+		meta.RowOffset = cursor.Offsets[key]
+		meta.NextToken = ""
+		frame.Meta.Custom = meta
+		next.Offsets[key] = meta.RowOffset + frame.Rows()
+	}
+
+	if more {
+		// frames without rows in this page keep their offset
+		for key, offset := range cursor.Offsets {
+			if _, ok := next.Offsets[key]; !ok {
+				next.Offsets[key] = offset
+			}
+		}
+		meta := res.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta)
+		meta.NextToken = next.encode()
+		res.Frames[0].Meta.Custom = meta
+	}
+	return res
+}
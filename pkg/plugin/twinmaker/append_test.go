@@ -0,0 +1,66 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type pagedEntitiesMockClient struct {
+	*twinMakerMockClient
+	tokens []string
+}
+
+func (c *pagedEntitiesMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	c.tokens = append(c.tokens, query.NextToken)
+	page := 0
+	if query.NextToken != "" {
+		_, _ = fmt.Sscanf(query.NextToken, "page-%d", &page)
+	}
+	rsp := &iottwinmaker.ListEntitiesOutput{}
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("entity-%d", page*2+i)
+		rsp.EntitySummaries = append(rsp.EntitySummaries, &iottwinmaker.EntitySummary{
+			Arn:              aws.String("arn:" + id),
+			EntityId:         aws.String(id),
+			EntityName:       aws.String(id),
+			CreationDateTime: aws.Time(time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)),
+		})
+	}
+	if page < 1 {
+		rsp.NextToken = aws.String(fmt.Sprintf("page-%d", page+1))
+	}
+	return rsp, nil
+}
+
+func TestAppendQuery(t *testing.T) {
+	client := &pagedEntitiesMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+	query := models.TwinMakerQuery{QueryType: models.QueryTypeListEntities, Append: true}
+
+	res := ds.Query(context.Background(), query)
+	require.NoError(t, res.Error)
+	meta := res.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta)
+	require.Equal(t, 0, meta.RowOffset)
+	require.NotEmpty(t, meta.NextToken)
+	require.NotEqual(t, "page-1", meta.NextToken) // wrapped in the append cursor
+
+	query.NextToken = meta.NextToken
+	res = ds.Query(context.Background(), query)
+	require.NoError(t, res.Error)
+	meta = res.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta)
+	require.Equal(t, 2, meta.RowOffset)
+	require.Empty(t, meta.NextToken)
+	require.Equal(t, "entity-2", *res.Frames[0].Fields[0].At(0).(*string))
+	require.Equal(t, []string{"", "page-1"}, client.tokens)
+
+	query.NextToken = "not a cursor"
+	res = ds.Query(context.Background(), query)
+	require.Error(t, res.Error)
+}
@@ -0,0 +1,252 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// DefaultAuditLogSize is how many write records are kept in memory
+const DefaultAuditLogSize = 1000
+
+// AuditLog is a ring buffer of the property writes made through this datasource.
+// When a sink location is set, every batch of records is also written to S3.
+type AuditLog struct {
+	client    TwinMakerClient
+	location  string // s3://bucket/prefix
+	redaction *redactor
+
+	mu      sync.RWMutex
+	records []models.AuditRecord
+	next    int
+	full    bool
+}
+
+func NewAuditLog(client TwinMakerClient, size int, location string) *AuditLog {
+	return &AuditLog{
+		client:   client,
+		location: strings.TrimSuffix(location, "/"),
+		records:  make([]models.AuditRecord, size),
+	}
+}
+
+func (a *AuditLog) Add(ctx context.Context, records ...models.AuditRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	for _, r := range records {
+		a.records[a.next] = r
+		a.next = (a.next + 1) % len(a.records)
+		if a.next == 0 {
+			a.full = true
+		}
+	}
+	a.mu.Unlock()
+
+	if a.location == "" {
+		return
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		log.DefaultLogger.Error("failed to encode audit records", "error", err)
+		return
+	}
+	t := records[0].Time.UTC()
+	key := fmt.Sprintf("%s/%s/%s.json", a.location, t.Format("2006/01/02"), t.Format("20060102T150405.000000000Z"))
+	if err := a.client.PutAuditObject(ctx, key, body); err != nil {
+		log.DefaultLogger.Error("failed to write audit records", "location", key, "error", err)
+	}
+}
+
+// Records returns the buffered records in the time range, oldest first
+func (a *AuditLog) Records(from time.Time, to time.Time) []models.AuditRecord {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ordered := a.records[:a.next]
+	if a.full {
+		ordered = append(append([]models.AuditRecord{}, a.records[a.next:]...), a.records[:a.next]...)
+	}
+
+	records := []models.AuditRecord{}
+	for _, r := range ordered {
+		if !r.Time.Before(from) && !r.Time.After(to) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func (a *AuditLog) Query(query models.TwinMakerQuery) (dr backend.DataResponse) {
+	records := a.Records(query.TimeRange.From, query.TimeRange.To)
+
+	fields := newTwinMakerFrameBuilder(len(records))
+	t := fields.Time()
+	user := data.NewFieldFromFieldType(data.FieldTypeString, len(records))
+	action := data.NewFieldFromFieldType(data.FieldTypeString, len(records))
+	eId := fields.EntityID()
+	component := fields.Component()
+	property := fields.Property()
+	oldValue := data.NewFieldFromFieldType(data.FieldTypeString, len(records))
+	newValue := data.NewFieldFromFieldType(data.FieldTypeString, len(records))
+	errors := data.NewFieldFromFieldType(data.FieldTypeString, len(records))
+	fields.add(user, "user")
+	fields.add(action, "action")
+	fields.add(oldValue, "oldValue")
+	fields.add(newValue, "newValue")
+	fields.add(errors, "error")
+
+	for i, r := range records {
+		ts := r.Time
+		t.Set(i, &ts)
+		user.Set(i, r.User)
+		action.Set(i, r.Action)
+		eId.Set(i, aws.String(r.EntityId))
+		component.Set(i, r.ComponentName)
+		property.Set(i, r.PropertyName)
+		oldValue.Set(i, a.redaction.valueString(r.PropertyName, r.OldValue))
+		newValue.Set(i, a.redaction.valueString(r.PropertyName, r.NewValue))
+		errors.Set(i, r.Error)
+	}
+
+	dr.Frames = append(dr.Frames, fields.ToFrame("", nil))
+	return
+}
+
+// auditingClient records the writes of the wrapped client in the audit log
+type auditingClient struct {
+	TwinMakerClient
+	audit *AuditLog
+}
+
+func newAuditingClient(client TwinMakerClient, audit *AuditLog) TwinMakerClient {
+	return &auditingClient{
+		TwinMakerClient: client,
+		audit:           audit,
+	}
+}
+
+func (c *auditingClient) BatchPutPropertyValues(ctx context.Context, req *iottwinmaker.BatchPutPropertyValuesInput) (*iottwinmaker.BatchPutPropertyValuesOutput, error) {
+	oldValues := c.latestValues(ctx, req)
+	rsp, err := c.TwinMakerClient.BatchPutPropertyValues(ctx, req)
+
+	failures := map[string]string{}
+	if rsp != nil {
+		for _, errorEntry := range rsp.ErrorEntries {
+			for _, e := range errorEntry.Errors {
+				if e.Entry != nil {
+					failures[auditKey(e.Entry.EntityPropertyReference)] = aws.StringValue(e.ErrorMessage)
+				}
+			}
+		}
+	}
+
+	user := ""
+	if u := httpadapter.UserFromContext(ctx); u != nil {
+		user = u.Login
+	}
+	now := time.Now()
+	records := []models.AuditRecord{}
+	for _, entry := range req.Entries {
+		ref := entry.EntityPropertyReference
+		if ref == nil {
+			continue
+		}
+		record := models.AuditRecord{
+			Time:          now,
+			User:          user,
+			Action:        "BatchPutPropertyValues",
+			EntityId:      aws.StringValue(ref.EntityId),
+			ComponentName: aws.StringValue(ref.ComponentName),
+			PropertyName:  aws.StringValue(ref.PropertyName),
+			OldValue:      oldValues[auditKey(ref)],
+			Error:         failures[auditKey(ref)],
+		}
+		if n := len(entry.PropertyValues); n > 0 {
+			record.NewValue = auditValueString(entry.PropertyValues[n-1].Value)
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		records = append(records, record)
+	}
+	c.audit.Add(ctx, records...)
+
+	return rsp, err
+}
+
+// latestValues reads the current values before a write, properties without a latest value
+// (e.g. time series) are skipped
+func (c *auditingClient) latestValues(ctx context.Context, req *iottwinmaker.BatchPutPropertyValuesInput) map[string]string {
+	type component struct {
+		entityId      string
+		componentName string
+	}
+	groups := map[component][]*string{}
+	for _, entry := range req.Entries {
+		ref := entry.EntityPropertyReference
+		if ref == nil || ref.EntityId == nil || ref.ComponentName == nil || ref.PropertyName == nil {
+			continue
+		}
+		key := component{*ref.EntityId, *ref.ComponentName}
+		groups[key] = append(groups[key], ref.PropertyName)
+	}
+
+	values := map[string]string{}
+	for key, properties := range groups {
+		rsp, err := c.TwinMakerClient.GetPropertyValue(ctx, models.TwinMakerQuery{
+			WorkspaceId:   aws.StringValue(req.WorkspaceId),
+			EntityId:      key.entityId,
+			ComponentName: key.componentName,
+			Properties:    properties,
+		})
+		if err != nil || rsp == nil {
+			continue
+		}
+		for _, v := range rsp.PropertyValues {
+			if v == nil || v.PropertyReference == nil {
+				continue
+			}
+			values[auditKey(&iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(key.entityId),
+				ComponentName: aws.String(key.componentName),
+				PropertyName:  v.PropertyReference.PropertyName,
+			})] = auditValueString(v.PropertyValue)
+		}
+	}
+	return values
+}
+
+func auditKey(ref *iottwinmaker.EntityPropertyReference) string {
+	if ref == nil {
+		return ""
+	}
+	return aws.StringValue(ref.EntityId) + "/" + aws.StringValue(ref.ComponentName) + "/" + aws.StringValue(ref.PropertyName)
+}
+
+func auditValueString(v *iottwinmaker.DataValue) string {
+	if value, ok := ruleValue(v); ok {
+		return fmt.Sprintf("%v", value)
+	}
+	if v == nil {
+		return ""
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
@@ -0,0 +1,110 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type auditMockClient struct {
+	*batchPutMockClient
+	objects map[string][]byte
+}
+
+func (c *auditMockClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	rsp := &iottwinmaker.GetPropertyValueOutput{PropertyValues: map[string]*iottwinmaker.PropertyLatestValue{}}
+	for _, p := range query.Properties {
+		rsp.PropertyValues[*p] = &iottwinmaker.PropertyLatestValue{
+			PropertyReference: &iottwinmaker.EntityPropertyReference{PropertyName: p},
+			PropertyValue:     &iottwinmaker.DataValue{StringValue: aws.String("ACTIVE")},
+		}
+	}
+	return rsp, nil
+}
+
+func (c *auditMockClient) PutAuditObject(ctx context.Context, location string, body []byte) error {
+	c.objects[location] = body
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	client := &auditMockClient{
+		batchPutMockClient: &batchPutMockClient{
+			twinMakerMockClient: &twinMakerMockClient{},
+			reject:              map[string]bool{"Mixer_1": true},
+		},
+		objects: map[string][]byte{},
+	}
+
+	t.Run("records writes when the writer role is configured", func(t *testing.T) {
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{
+			WorkspaceID:         "AlarmWorkspace",
+			AssumeRoleARNWriter: "arn:aws:iam::123456789012:role/writer",
+			AuditS3Location:     "s3://audit-bucket/twinmaker/",
+		}, client)
+
+		_, err := ds.Resources.AcknowledgeAlarms(context.Background(), []models.AlarmReference{
+			{EntityId: "Mixer_0", ComponentName: "AlarmComponent"},
+			{EntityId: "Mixer_1", ComponentName: "AlarmComponent"},
+		})
+		require.NoError(t, err)
+
+		records := ds.Audit.Records(time.Now().Add(-time.Minute), time.Now())
+		require.Len(t, records, 2)
+		require.Equal(t, "Mixer_0", records[0].EntityId)
+		require.Equal(t, "alarm_status", records[0].PropertyName)
+		require.Equal(t, "ACTIVE", records[0].OldValue)
+		require.Equal(t, "ACKNOWLEDGED", records[0].NewValue)
+		require.Empty(t, records[0].Error)
+		require.Equal(t, "not allowed", records[1].Error)
+
+		require.Len(t, client.objects, 1)
+		for key, body := range client.objects {
+			require.True(t, strings.HasPrefix(key, "s3://audit-bucket/twinmaker/"+time.Now().UTC().Format("2006/01/02")+"/"), key)
+			sink := []models.AuditRecord{}
+			require.NoError(t, json.Unmarshal(body, &sink))
+			require.Len(t, sink, 2)
+		}
+
+		res := ds.Query(context.Background(), models.TwinMakerQuery{
+			QueryType: models.QueryTypeAuditLog,
+			TimeRange: backend.TimeRange{From: time.Now().Add(-time.Minute), To: time.Now()},
+		})
+		require.NoError(t, res.Error)
+		require.Equal(t, 2, res.Frames[0].Rows())
+
+		// anonymous users do not see the logins and values of the writes
+		res = ds.Query(WithViewerRole(context.Background()), models.TwinMakerQuery{
+			QueryType: models.QueryTypeAuditLog,
+			TimeRange: backend.TimeRange{From: time.Now().Add(-time.Minute), To: time.Now()},
+		})
+		require.EqualError(t, res.Error, "the audit log is not available to anonymous users")
+	})
+
+	t.Run("audit query requires the writer role", func(t *testing.T) {
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+		require.Nil(t, ds.Audit)
+		res := ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeAuditLog})
+		require.Error(t, res.Error)
+	})
+
+	t.Run("ring buffer keeps the newest records", func(t *testing.T) {
+		audit := NewAuditLog(client, 2, "")
+		start := time.Now()
+		for i, id := range []string{"a", "b", "c"} {
+			audit.Add(context.Background(), models.AuditRecord{Time: start.Add(time.Duration(i) * time.Second), EntityId: id})
+		}
+		records := audit.Records(start, start.Add(time.Minute))
+		require.Len(t, records, 2)
+		require.Equal(t, "b", records[0].EntityId)
+		require.Equal(t, "c", records[1].EntityId)
+	})
+}
@@ -0,0 +1,167 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxAvailabilityPages stops counting dense properties early, the counts after the last page
+// read are unknown
+const maxAvailabilityPages = 40
+
+// availabilityInterval is the histogram bucket size, hours for ranges up to two days
+func availabilityInterval(query models.TwinMakerQuery) (time.Duration, error) {
+	switch query.AvailabilityInterval {
+	case models.AvailabilityHour:
+		return time.Hour, nil
+	case models.AvailabilityDay:
+		return 24 * time.Hour, nil
+	case "":
+		if query.TimeRange.To.Sub(query.TimeRange.From) <= 48*time.Hour {
+			return time.Hour, nil
+		}
+		return 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown availability interval %q, expected %q or %q", query.AvailabilityInterval, models.AvailabilityHour, models.AvailabilityDay)
+}
+
+// GetDataAvailability counts the history values of the query properties per hour or day, so
+// users can see where data exists before loading it. History is read oldest first and paging
+// stops after maxAvailabilityPages or when the query deadline is near.
+func (s *twinMakerHandler) GetDataAvailability(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" && query.ComponentTypeId == "" {
+		dr.Error = fmt.Errorf("missing entity or component type parameter")
+		return
+	}
+	interval, err := availabilityInterval(query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	from := query.TimeRange.From.Truncate(interval)
+	count := int((query.TimeRange.To.Sub(from) + interval - 1) / interval)
+	if count < 1 {
+		count = 1
+	}
+
+	type series struct {
+		name   string
+		labels data.Labels
+		counts []int64
+	}
+	all := map[string]*series{}
+
+	query.Order = models.ResultOrderAsc
+	query.MaxResults = maxHistoryPageSize
+	query.NextToken = ""
+	complete, stopped := true, false
+	var until time.Time
+	var lastPage time.Duration
+	for page := 0; ; page++ {
+		if page == maxAvailabilityPages {
+			complete = false
+			break
+		}
+		if deadlineNear(ctx, lastPage) || previewPageLoaded(ctx, page) {
+			complete, stopped = false, true
+			break
+		}
+		start := time.Now()
+		rsp, err := s.client.GetPropertyValueHistory(ctx, query)
+		if err != nil {
+			dr.Error = err
+			return
+		}
+		lastPage = time.Since(start)
+		for _, prop := range rsp.PropertyValues {
+			ref := prop.EntityPropertyReference
+			if ref == nil || ref.PropertyName == nil || s.redaction.action(*ref.PropertyName) == models.RedactionDrop {
+				continue
+			}
+			labels := data.Labels{"propertyName": *ref.PropertyName}
+			if ref.EntityId != nil {
+				labels["entityId"] = *ref.EntityId
+			}
+			if ref.ComponentName != nil {
+				labels["componentName"] = *ref.ComponentName
+			}
+			for key, val := range ref.ExternalIdProperty {
+				if key != "propertyName" && val != nil {
+					labels[key] = *val
+				}
+			}
+			key := labels.String()
+			row, ok := all[key]
+			if !ok {
+				row = &series{name: *ref.PropertyName, labels: labels, counts: make([]int64, count)}
+				if name, ok := query.PropertyDisplayNames[row.name]; ok {
+					row.name = name
+				}
+				all[key] = row
+			}
+			for _, v := range prop.Values {
+				t, err := getTimeObjectFromStringTime(v.Time)
+				if err != nil {
+					continue
+				}
+				if t.After(until) {
+					until = *t
+				}
+				if i := int(t.Sub(from) / interval); i >= 0 && i < count {
+					row.counts[i]++
+				}
+			}
+		}
+		if rsp.NextToken == nil {
+			break
+		}
+		query.NextToken = *rsp.NextToken
+	}
+
+	rows := make([]*series, 0, len(all))
+	for _, row := range all {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].labels.String() < rows[j].labels.String()
+	})
+
+	fields := newTwinMakerFrameBuilder(count)
+	t := fields.Time()
+	for i := 0; i < count; i++ {
+		bucket := from.Add(time.Duration(i) * interval)
+		t.Set(i, &bucket)
+	}
+	for _, row := range rows {
+		f := data.NewFieldFromFieldType(data.FieldTypeNullableInt64, count)
+		for i, c := range row.counts {
+			// buckets after the last value read are unknown when paging stopped early
+			if !complete && from.Add(time.Duration(i)*interval).After(until) {
+				continue
+			}
+			c := c
+			f.Set(i, &c)
+		}
+		f.Labels = row.labels
+		fields.add(f, row.name)
+	}
+
+	frame := fields.ToFrame("availability", nil)
+	if stopped {
+		frame.AppendNotices(partialNotice(ctx, false))
+	} else if !complete {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("counts stop at %s, the history has more values than can be counted in one query", until.UTC().Format(time.RFC3339)),
+		})
+	}
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
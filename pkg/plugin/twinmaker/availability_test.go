@@ -0,0 +1,106 @@
+package twinmaker
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+// availabilityMockClient returns pages of one value per page index, every page is 30 minutes
+// after the previous one
+type availabilityMockClient struct {
+	*twinMakerMockClient
+	pages int
+	calls int
+}
+
+func (c *availabilityMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	c.calls++
+	page := 0
+	if query.NextToken != "" {
+		page, _ = strconv.Atoi(query.NextToken)
+	}
+	rsp := &iottwinmaker.GetPropertyValueHistoryOutput{}
+	if page+1 < c.pages {
+		rsp.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	rsp.PropertyValues = []*iottwinmaker.PropertyValueHistory{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String(query.EntityId),
+			ComponentName: aws.String(query.ComponentName),
+			PropertyName:  aws.String("Temperature"),
+		},
+		Values: []*iottwinmaker.PropertyValue{{
+			Time:  aws.String(time.Date(2022, 4, 27, 0, 30*page, 0, 0, time.UTC).Format(time.RFC3339)),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(1)},
+		}},
+	}}
+	return rsp, nil
+}
+
+func TestGetDataAvailability(t *testing.T) {
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 27, 4, 0, 0, 0, time.UTC),
+		},
+	}
+	counts := func(f *data.Field) []interface{} {
+		v := []interface{}{}
+		for i := 0; i < f.Len(); i++ {
+			if p := f.At(i).(*int64); p != nil {
+				v = append(v, *p)
+			} else {
+				v = append(v, nil)
+			}
+		}
+		return v
+	}
+
+	t.Run("hourly counts", func(t *testing.T) {
+		client := &availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: 5}
+		dr := NewTwinMakerHandler(client).GetDataAvailability(context.Background(), query)
+		require.NoError(t, dr.Error)
+		require.Len(t, dr.Frames, 1)
+
+		frame := dr.Frames[0]
+		require.Equal(t, "availability", frame.Name)
+		require.Equal(t, 4, frame.Rows())
+		require.Equal(t, []interface{}{int64(2), int64(2), int64(1), int64(0)}, counts(frame.Fields[1]))
+		require.Equal(t, "Mixer_0", frame.Fields[1].Labels["entityId"])
+		require.Empty(t, frame.Meta.Notices)
+	})
+
+	t.Run("early exit", func(t *testing.T) {
+		client := &availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: maxAvailabilityPages + 10}
+		q := query
+		q.AvailabilityInterval = models.AvailabilityDay
+		q.TimeRange.To = time.Date(2022, 4, 29, 0, 0, 0, 0, time.UTC)
+		dr := NewTwinMakerHandler(client).GetDataAvailability(context.Background(), q)
+		require.NoError(t, dr.Error)
+		require.Equal(t, maxAvailabilityPages, client.calls)
+
+		// the second day is after the last value read
+		frame := dr.Frames[0]
+		require.Equal(t, []interface{}{int64(maxAvailabilityPages), nil}, counts(frame.Fields[1]))
+		require.Len(t, frame.Meta.Notices, 1)
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		q := query
+		q.AvailabilityInterval = "week"
+		dr := NewTwinMakerHandler(&availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}}).GetDataAvailability(context.Background(), q)
+		require.Error(t, dr.Error)
+	})
+}
@@ -0,0 +1,144 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// four weeks back keeps the weekday
+	defaultBaselineDays = 28
+	maxBaselineDays     = 366
+)
+
+// baselineOffset is how far back the baseline window starts
+func baselineOffset(query models.TwinMakerQuery) (time.Duration, error) {
+	days := query.BaselineDays
+	switch {
+	case days == 0:
+		days = defaultBaselineDays
+	case days < 0 || days > maxBaselineDays:
+		return 0, fmt.Errorf("baselineDays must be between 1 and %d", maxBaselineDays)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+type timedValue struct {
+	time  time.Time
+	value float64
+}
+
+// numericHistory is the sorted numeric history of a property, shifted by offset
+func numericHistory(references []PropertyReference, offset time.Duration) ([]timedValue, int) {
+	values := []timedValue{}
+	skipped := 0
+	for _, p := range references {
+		for _, v := range p.values {
+			t, err := getTimeObjectFromStringTime(v.Time)
+			if err != nil {
+				continue
+			}
+			n, ok := heatmapValue(v.Value)
+			if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+				skipped++
+				continue
+			}
+			values = append(values, timedValue{time: t.Add(offset), value: n})
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].time.Before(values[j].time)
+	})
+	return values, skipped
+}
+
+// GetBaselineCompare returns the history of one entity property next to the history of the same
+// window BaselineDays earlier. The baseline is moved onto the time axis of the current values, each
+// value is compared with the latest baseline value at or before its time and the deviation is the
+// difference.
+func (s *twinMakerHandler) GetBaselineCompare(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" || query.ComponentName == "" {
+		dr.Error = fmt.Errorf("missing entity or component parameter")
+		return
+	}
+	if len(query.Properties) != 1 || query.Properties[0] == nil {
+		dr.Error = fmt.Errorf("baseline queries need exactly one property")
+		return
+	}
+	property := *query.Properties[0]
+	if s.redaction.action(property) != "" {
+		dr.Error = fmt.Errorf("property %s is redacted", property)
+		return
+	}
+	offset, err := baselineOffset(query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+	query.Order = models.ResultOrderAsc
+
+	current, failures, err := s.getHistoryReferences(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+	baselineQuery := query
+	baselineQuery.TimeRange = backend.TimeRange{
+		From: query.TimeRange.From.Add(-offset),
+		To:   query.TimeRange.To.Add(-offset),
+	}
+	baseline, baselineFailures, err := s.getHistoryReferences(ctx, baselineQuery)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+	failures = append(failures, baselineFailures...)
+
+	values, skipped := numericHistory(current, 0)
+	baselineValues, baselineSkipped := numericHistory(baseline, offset)
+	skipped += baselineSkipped
+
+	name := property
+	if display, ok := query.PropertyDisplayNames[property]; ok {
+		name = display
+	}
+	fields := newTwinMakerFrameBuilder(len(values))
+	t := fields.Time()
+	value := fields.add(data.NewFieldFromFieldType(data.FieldTypeFloat64, len(values)), name)
+	base := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, len(values)), "baseline")
+	deviation := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, len(values)), "deviation")
+	next := 0
+	for i, v := range values {
+		v := v
+		t.Set(i, &v.time)
+		value.Set(i, v.value)
+		for next < len(baselineValues) && !baselineValues[next].time.After(v.time) {
+			next++
+		}
+		if next == 0 {
+			continue
+		}
+		b := baselineValues[next-1].value
+		d := v.value - b
+		base.Set(i, &b)
+		deviation.Set(i, &d)
+	}
+
+	if skipped > 0 {
+		failures = append(failures, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%d non numeric values were skipped", skipped),
+		})
+	}
+	frame := fields.ToFrame(name, nil)
+	frame.AppendNotices(failures...)
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
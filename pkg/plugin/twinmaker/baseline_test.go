@@ -0,0 +1,78 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+// baselineMockClient serves values at minute offsets from the start of each requested range
+type baselineMockClient struct {
+	*twinMakerMockClient
+	current, baseline map[int]float64
+	ranges            []backend.TimeRange
+}
+
+func (c *baselineMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	c.ranges = append(c.ranges, query.TimeRange)
+	series := c.current
+	if len(c.ranges) > 1 {
+		series = c.baseline
+	}
+	values := []*iottwinmaker.PropertyValue{}
+	for minute, v := range series {
+		values = append(values, &iottwinmaker.PropertyValue{
+			Time:  aws.String(query.TimeRange.From.Add(time.Duration(minute) * time.Minute).Format(time.RFC3339)),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(v)},
+		})
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String("Mixer_0"),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Temperature"),
+			},
+			Values: values,
+		}},
+	}, nil
+}
+
+func TestGetBaselineCompare(t *testing.T) {
+	client := &baselineMockClient{
+		twinMakerMockClient: &twinMakerMockClient{},
+		current:             map[int]float64{60: 10, 120: 20, 180: 30},
+		baseline:            map[int]float64{60: 8, 150: 25},
+	}
+	handler := newTwinMakerHandler(client, nil)
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+		TimeRange:     backend.TimeRange{From: from, To: from.Add(24 * time.Hour)},
+	}
+
+	dr := handler.GetBaselineCompare(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, client.ranges, 2)
+	require.Equal(t, from.AddDate(0, 0, -28), client.ranges[1].From)
+
+	frame := dr.Frames[0]
+	require.Equal(t, 3, frame.Rows())
+	baseline, _ := frame.FieldByName("baseline")
+	deviation, _ := frame.FieldByName("deviation")
+	require.Equal(t, 8.0, *baseline.At(1).(*float64))
+	require.Equal(t, 12.0, *deviation.At(1).(*float64))
+	require.Equal(t, 5.0, *deviation.At(2).(*float64))
+
+	query.BaselineDays = -1
+	dr = handler.GetBaselineCompare(context.Background(), query)
+	require.Error(t, dr.Error)
+}
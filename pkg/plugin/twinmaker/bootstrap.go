@@ -0,0 +1,320 @@
+package twinmaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// The demo workspace: two mixers whose Temperature and RPM come from SiteWise assets through
+// the SiteWise connector, with an hour of sample values
+const (
+	DefaultDemoWorkspaceId = "GrafanaDemo"
+
+	demoComponentTypeId = "com.grafana.demo.mixer"
+	demoComponentName   = "MixerComponent"
+	demoSamples         = 10 // per property, over the last hour
+)
+
+var demoEntities = []string{"Mixer_0", "Mixer_1"}
+
+var demoProperties = []struct {
+	name string
+	unit string
+	base float64
+}{
+	{"Temperature", "Celsius", 20},
+	{"RPM", "RPM", 3},
+}
+
+var (
+	demoPollInterval = 2 * time.Second
+	demoPollTimeout  = 2 * time.Minute
+)
+
+var demoWorkspaceId = regexp.MustCompile(`^[a-zA-Z_0-9][a-zA-Z_\-0-9]*[a-zA-Z0-9]+$`)
+
+func validateDemoWorkspaceRequest(req models.DemoWorkspaceRequest) error {
+	if !demoWorkspaceId.MatchString(req.WorkspaceId) {
+		return fmt.Errorf("invalid workspaceId %q", req.WorkspaceId)
+	}
+	// any partition, e.g. arn:aws-us-gov:s3:::bucket in GovCloud
+	if !isServiceArn(req.S3Location, "s3") {
+		return fmt.Errorf("s3Location must be a bucket ARN (arn:aws:s3:::bucket)")
+	}
+	if !isServiceArn(req.Role, "iam") {
+		return fmt.Errorf("role must be an IAM role ARN")
+	}
+	return nil
+}
+
+func demoAssetModelName(workspaceId string) string {
+	return workspaceId + "-Mixer"
+}
+
+func demoAssetName(workspaceId string, entityId string) string {
+	return workspaceId + "-" + entityId
+}
+
+func isConflict(err error) bool {
+	if aErr, ok := err.(awserr.Error); ok {
+		return aErr.Code() == iottwinmaker.ErrCodeConflictException
+	}
+	return false
+}
+
+// siteWiseExisting is the id of the resource a SiteWise create call conflicted with
+func siteWiseExisting(err error) (string, bool) {
+	var exists *iotsitewise.ResourceAlreadyExistsException
+	if errors.As(err, &exists) && exists.ResourceId != nil {
+		return *exists.ResourceId, true
+	}
+	return "", false
+}
+
+// waitForDemoResource polls the state of a resource until it is active
+func waitForDemoResource(ctx context.Context, what string, state func() (string, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, demoPollTimeout)
+	defer cancel()
+	for {
+		s, err := state()
+		if err != nil {
+			return err
+		}
+		switch s {
+		case "ACTIVE":
+			return nil
+		case iotsitewise.AssetStateFailed, iottwinmaker.StateError:
+			return fmt.Errorf("creating %s failed", what)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to become active", what)
+		case <-time.After(demoPollInterval):
+		}
+	}
+}
+
+// PlanDemoWorkspace lists the resources CreateDemoWorkspace would create
+func (r *twinMakerResource) PlanDemoWorkspace(req models.DemoWorkspaceRequest) (models.DemoWorkspaceReport, error) {
+	report := models.DemoWorkspaceReport{WorkspaceId: req.WorkspaceId}
+	if err := validateDemoWorkspaceRequest(req); err != nil {
+		return report, err
+	}
+	add := func(kind string, id string) {
+		report.Resources = append(report.Resources, models.DemoWorkspaceResource{Type: kind, Id: id, Status: "planned"})
+	}
+	add("workspace", req.WorkspaceId)
+	add("assetModel", demoAssetModelName(req.WorkspaceId))
+	for _, e := range demoEntities {
+		add("asset", demoAssetName(req.WorkspaceId, e))
+	}
+	add("componentType", demoComponentTypeId)
+	for _, e := range demoEntities {
+		add("entity", e)
+	}
+	report.Samples = len(demoEntities) * len(demoProperties) * demoSamples
+	return report, nil
+}
+
+// CreateDemoWorkspace creates the demo workspace with the writer role. Resources that already
+// exist are kept, so a failed run can be repeated.
+func (r *twinMakerResource) CreateDemoWorkspace(ctx context.Context, req models.DemoWorkspaceRequest) (models.DemoWorkspaceReport, error) {
+	report := models.DemoWorkspaceReport{WorkspaceId: req.WorkspaceId, Resources: []models.DemoWorkspaceResource{}}
+	if err := validateDemoWorkspaceRequest(req); err != nil {
+		return report, err
+	}
+	add := func(kind string, id string, exists bool) {
+		status := "created"
+		if exists {
+			status = "exists"
+		}
+		report.Resources = append(report.Resources, models.DemoWorkspaceResource{Type: kind, Id: id, Status: status})
+	}
+
+	_, err := r.client.CreateWorkspace(ctx, &iottwinmaker.CreateWorkspaceInput{
+		WorkspaceId: aws.String(req.WorkspaceId),
+		S3Location:  aws.String(req.S3Location),
+		Role:        aws.String(req.Role),
+		Description: aws.String("Demo workspace created by Grafana"),
+	})
+	if err != nil && !isConflict(err) {
+		return report, err
+	}
+	add("workspace", req.WorkspaceId, err != nil)
+
+	// SiteWise asset model and assets with the sample values
+	modelReq := &iotsitewise.CreateAssetModelInput{
+		AssetModelName:        aws.String(demoAssetModelName(req.WorkspaceId)),
+		AssetModelDescription: aws.String("Mixer of the Grafana TwinMaker demo workspace"),
+	}
+	for _, p := range demoProperties {
+		modelReq.AssetModelProperties = append(modelReq.AssetModelProperties, &iotsitewise.AssetModelPropertyDefinition{
+			Name:     aws.String(p.name),
+			DataType: aws.String(iotsitewise.PropertyDataTypeDouble),
+			Unit:     aws.String(p.unit),
+			Type:     &iotsitewise.PropertyType{Measurement: &iotsitewise.Measurement{}},
+		})
+	}
+	model, err := r.client.CreateAssetModel(ctx, modelReq)
+	assetModelId, exists := siteWiseExisting(err)
+	if err != nil && !exists {
+		return report, err
+	}
+	if model != nil && model.AssetModelId != nil {
+		assetModelId = *model.AssetModelId
+	}
+	err = waitForDemoResource(ctx, "asset model "+*modelReq.AssetModelName, func() (string, error) {
+		rsp, err := r.client.DescribeAssetModel(ctx, assetModelId)
+		if err != nil || rsp.AssetModelStatus == nil || rsp.AssetModelStatus.State == nil {
+			return "", err
+		}
+		return *rsp.AssetModelStatus.State, nil
+	})
+	if err != nil {
+		return report, err
+	}
+	add("assetModel", assetModelId, exists)
+
+	assetIds := map[string]string{}
+	entries := []*iotsitewise.PutAssetPropertyValueEntry{}
+	now := time.Now()
+	for i, entityId := range demoEntities {
+		name := demoAssetName(req.WorkspaceId, entityId)
+		asset, err := r.client.CreateAsset(ctx, &iotsitewise.CreateAssetInput{
+			AssetName:    aws.String(name),
+			AssetModelId: aws.String(assetModelId),
+		})
+		assetId, exists := siteWiseExisting(err)
+		if err != nil && !exists {
+			return report, err
+		}
+		if asset != nil && asset.AssetId != nil {
+			assetId = *asset.AssetId
+		}
+
+		var properties []*iotsitewise.AssetProperty
+		err = waitForDemoResource(ctx, "asset "+name, func() (string, error) {
+			rsp, err := r.client.DescribeAsset(ctx, assetId)
+			if err != nil || rsp.AssetStatus == nil || rsp.AssetStatus.State == nil {
+				return "", err
+			}
+			properties = rsp.AssetProperties
+			return *rsp.AssetStatus.State, nil
+		})
+		if err != nil {
+			return report, err
+		}
+		add("asset", assetId, exists)
+		assetIds[entityId] = assetId
+
+		for _, property := range properties {
+			if property.Id == nil || property.Name == nil {
+				continue
+			}
+			for _, p := range demoProperties {
+				if p.name != *property.Name {
+					continue
+				}
+				entry := &iotsitewise.PutAssetPropertyValueEntry{
+					EntryId:    aws.String(fmt.Sprintf("%s-%s", entityId, p.name)),
+					AssetId:    aws.String(assetId),
+					PropertyId: property.Id,
+				}
+				for j := 0; j < demoSamples; j++ {
+					t := now.Add(-time.Duration(demoSamples-j) * time.Hour / demoSamples)
+					v := p.base * (1 + 0.1*math.Sin(float64(i+j)))
+					entry.PropertyValues = append(entry.PropertyValues, &iotsitewise.AssetPropertyValue{
+						Timestamp: &iotsitewise.TimeInNanos{TimeInSeconds: aws.Int64(t.Unix())},
+						Value:     &iotsitewise.Variant{DoubleValue: aws.Float64(v)},
+						Quality:   aws.String(iotsitewise.QualityGood),
+					})
+				}
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	// BatchPutAssetPropertyValue accepts at most 10 entries per call
+	for start := 0; start < len(entries); start += 10 {
+		end := start + 10
+		if end > len(entries) {
+			end = len(entries)
+		}
+		rsp, err := r.client.BatchPutAssetPropertyValue(ctx, &iotsitewise.BatchPutAssetPropertyValueInput{
+			Entries: entries[start:end],
+		})
+		if err != nil {
+			return report, err
+		}
+		failed := map[string]bool{}
+		if rsp != nil {
+			for _, e := range rsp.ErrorEntries {
+				if e.EntryId != nil {
+					failed[*e.EntryId] = true
+				}
+			}
+		}
+		for _, e := range entries[start:end] {
+			if !failed[*e.EntryId] {
+				report.Samples += len(e.PropertyValues)
+			}
+		}
+	}
+
+	_, err = r.client.CreateComponentType(ctx, &iottwinmaker.CreateComponentTypeInput{
+		WorkspaceId:     aws.String(req.WorkspaceId),
+		ComponentTypeId: aws.String(demoComponentTypeId),
+		Description:     aws.String("Mixer of the Grafana demo, values are read from SiteWise"),
+		ExtendsFrom:     []*string{aws.String("com.amazon.iotsitewise.connector")},
+	})
+	if err != nil && !isConflict(err) {
+		return report, err
+	}
+	exists = err != nil
+	err = waitForDemoResource(ctx, "component type "+demoComponentTypeId, func() (string, error) {
+		rsp, err := r.client.GetComponentType(ctx, models.TwinMakerQuery{
+			WorkspaceId:     req.WorkspaceId,
+			ComponentTypeId: demoComponentTypeId,
+		})
+		if err != nil || rsp.Status == nil || rsp.Status.State == nil {
+			return "", err
+		}
+		return *rsp.Status.State, nil
+	})
+	if err != nil {
+		return report, err
+	}
+	add("componentType", demoComponentTypeId, exists)
+
+	for _, entityId := range demoEntities {
+		_, err := r.client.CreateEntity(ctx, &iottwinmaker.CreateEntityInput{
+			WorkspaceId: aws.String(req.WorkspaceId),
+			EntityId:    aws.String(entityId),
+			EntityName:  aws.String(entityId),
+			Components: map[string]*iottwinmaker.ComponentRequest{
+				demoComponentName: {
+					ComponentTypeId: aws.String(demoComponentTypeId),
+					Properties: map[string]*iottwinmaker.PropertyRequest{
+						"sitewiseAssetId":      {Value: &iottwinmaker.DataValue{StringValue: aws.String(assetIds[entityId])}},
+						"sitewiseAssetModelId": {Value: &iottwinmaker.DataValue{StringValue: aws.String(assetModelId)}},
+					},
+				},
+			},
+		})
+		if err != nil && !isConflict(err) {
+			return report, err
+		}
+		add("entity", entityId, err != nil)
+	}
+	return report, nil
+}
@@ -0,0 +1,124 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type bootstrapMockClient struct {
+	*twinMakerMockClient
+	modelPolls int
+	samples    []*iotsitewise.PutAssetPropertyValueEntry
+	entities   []*iottwinmaker.CreateEntityInput
+}
+
+func (c *bootstrapMockClient) CreateAssetModel(ctx context.Context, req *iotsitewise.CreateAssetModelInput) (*iotsitewise.CreateAssetModelOutput, error) {
+	// a previous run created the model
+	return nil, &iotsitewise.ResourceAlreadyExistsException{ResourceId: aws.String("model-id")}
+}
+
+func (c *bootstrapMockClient) DescribeAssetModel(ctx context.Context, assetModelId string) (*iotsitewise.DescribeAssetModelOutput, error) {
+	c.modelPolls++
+	state := iotsitewise.AssetModelStateCreating
+	if c.modelPolls > 1 {
+		state = iotsitewise.AssetModelStateActive
+	}
+	return &iotsitewise.DescribeAssetModelOutput{AssetModelStatus: &iotsitewise.AssetModelStatus{State: aws.String(state)}}, nil
+}
+
+func (c *bootstrapMockClient) CreateAsset(ctx context.Context, req *iotsitewise.CreateAssetInput) (*iotsitewise.CreateAssetOutput, error) {
+	return &iotsitewise.CreateAssetOutput{AssetId: aws.String("asset-" + *req.AssetName)}, nil
+}
+
+func (c *bootstrapMockClient) DescribeAsset(ctx context.Context, assetId string) (*iotsitewise.DescribeAssetOutput, error) {
+	return &iotsitewise.DescribeAssetOutput{
+		AssetStatus: &iotsitewise.AssetStatus{State: aws.String(iotsitewise.AssetStateActive)},
+		AssetProperties: []*iotsitewise.AssetProperty{
+			{Id: aws.String(assetId + "-temperature"), Name: aws.String("Temperature")},
+			{Id: aws.String(assetId + "-rpm"), Name: aws.String("RPM")},
+		},
+	}, nil
+}
+
+func (c *bootstrapMockClient) BatchPutAssetPropertyValue(ctx context.Context, req *iotsitewise.BatchPutAssetPropertyValueInput) (*iotsitewise.BatchPutAssetPropertyValueOutput, error) {
+	c.samples = append(c.samples, req.Entries...)
+	return &iotsitewise.BatchPutAssetPropertyValueOutput{}, nil
+}
+
+func (c *bootstrapMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return &iottwinmaker.GetComponentTypeOutput{Status: &iottwinmaker.Status{State: aws.String(iottwinmaker.StateActive)}}, nil
+}
+
+func (c *bootstrapMockClient) CreateEntity(ctx context.Context, req *iottwinmaker.CreateEntityInput) (*iottwinmaker.CreateEntityOutput, error) {
+	if *req.EntityId == "Mixer_1" {
+		return nil, awserr.New(iottwinmaker.ErrCodeConflictException, "entity already exists", nil)
+	}
+	c.entities = append(c.entities, req)
+	return &iottwinmaker.CreateEntityOutput{}, nil
+}
+
+func TestDemoWorkspace(t *testing.T) {
+	interval := demoPollInterval
+	demoPollInterval = time.Millisecond
+	defer func() { demoPollInterval = interval }()
+
+	req := models.DemoWorkspaceRequest{
+		WorkspaceId: "GrafanaDemo",
+		S3Location:  "arn:aws:s3:::demo-bucket",
+		Role:        "arn:aws:iam::123456789012:role/TwinMakerWorkspace",
+	}
+
+	t.Run("plan", func(t *testing.T) {
+		res := NewTwinMakerResource(&twinMakerMockClient{}, "")
+		plan, err := res.PlanDemoWorkspace(req)
+		require.NoError(t, err)
+		require.Len(t, plan.Resources, 7)
+		require.Equal(t, "planned", plan.Resources[0].Status)
+		require.Equal(t, 40, plan.Samples)
+
+		_, err = res.PlanDemoWorkspace(models.DemoWorkspaceRequest{WorkspaceId: "GrafanaDemo", S3Location: "demo-bucket", Role: req.Role})
+		require.Error(t, err)
+
+		_, err = res.PlanDemoWorkspace(models.DemoWorkspaceRequest{
+			WorkspaceId: "GrafanaDemo",
+			S3Location:  "arn:aws-us-gov:s3:::demo-bucket",
+			Role:        "arn:aws-us-gov:iam::123456789012:role/TwinMakerWorkspace",
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		client := &bootstrapMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+		res := NewTwinMakerResource(client, "")
+		report, err := res.CreateDemoWorkspace(context.Background(), req)
+		require.NoError(t, err)
+
+		require.Equal(t, []models.DemoWorkspaceResource{
+			{Type: "workspace", Id: "GrafanaDemo", Status: "created"},
+			{Type: "assetModel", Id: "model-id", Status: "exists"},
+			{Type: "asset", Id: "asset-GrafanaDemo-Mixer_0", Status: "created"},
+			{Type: "asset", Id: "asset-GrafanaDemo-Mixer_1", Status: "created"},
+			{Type: "componentType", Id: demoComponentTypeId, Status: "created"},
+			{Type: "entity", Id: "Mixer_0", Status: "created"},
+			{Type: "entity", Id: "Mixer_1", Status: "exists"},
+		}, report.Resources)
+		require.Equal(t, 2, client.modelPolls)
+
+		require.Equal(t, 40, report.Samples)
+		require.Len(t, client.samples, 4)
+		require.Equal(t, "asset-GrafanaDemo-Mixer_0-temperature", *client.samples[0].PropertyId)
+
+		require.Len(t, client.entities, 1)
+		component := client.entities[0].Components[demoComponentName]
+		require.Equal(t, "asset-GrafanaDemo-Mixer_0", *component.Properties["sitewiseAssetId"].Value.StringValue)
+		require.Equal(t, "model-id", *component.Properties["sitewiseAssetModelId"].Value.StringValue)
+	})
+}
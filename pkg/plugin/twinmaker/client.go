@@ -1,22 +1,31 @@
 package twinmaker
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
-	"runtime"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/ioteventsdata"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/aws/aws-sdk-go/service/kinesisvideo"
+	"github.com/aws/aws-sdk-go/service/kinesisvideoarchivedmedia"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
-	"github.com/grafana/grafana-plugin-sdk-go/build"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
 	httplogger "github.com/grafana/grafana-plugin-sdk-go/experimental/http_logger"
+	"github.com/patrickmn/go-cache"
 )
 
 // TwinMakerClient calls AWS services and returns the raw results
@@ -28,29 +37,120 @@ type TwinMakerClient interface {
 	ListScenes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListScenesOutput, error)
 	ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error)
 	ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error)
+
+	// NOTE: single page variants, starting from query.NextToken
+	ListEntitiesPage(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error)
+	ListComponentTypesPage(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error)
+
 	GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error)
 	GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error)
 
 	BatchPutPropertyValues(ctx context.Context, req *iottwinmaker.BatchPutPropertyValuesInput) (*iottwinmaker.BatchPutPropertyValuesOutput, error)
+	// Writes an object with the writer role, used for the s3:// audit log sink
+	PutAuditObject(ctx context.Context, location string, body []byte) error
+	// Writes a scene composer asset (glb/gltf model) with the writer role
+	PutSceneAsset(ctx context.Context, location string, contentType string, body []byte) error
+
+	// Resource tags, all pages are read. Tag and untag use the writer role.
+	ListTagsForResource(ctx context.Context, resourceArn string) (*iottwinmaker.ListTagsForResourceOutput, error)
+	TagResource(ctx context.Context, req *iottwinmaker.TagResourceInput) (*iottwinmaker.TagResourceOutput, error)
+	UntagResource(ctx context.Context, req *iottwinmaker.UntagResourceInput) (*iottwinmaker.UntagResourceOutput, error)
 
 	// NOTE: only works with non-timeseries data
 	GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error)
 
 	// NOTE: only works with timeseries data
 	GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error)
+
+	// Runs query.QueryStatement on the knowledge graph, pages are read until query.MaxResults rows
+	ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (*ExecuteQueryOutput, error)
+
+	GetScene(ctx context.Context, workspaceId string, sceneId string) (*iottwinmaker.GetSceneOutput, error)
+	// Reads the scene JSON from the s3:// content location of a scene
+	GetSceneContent(ctx context.Context, contentLocation string) ([]byte, error)
+
+	// CloudTrail management events recorded for TwinMaker in the query time range
+	LookupWorkspaceEvents(ctx context.Context, query models.TwinMakerQuery) (*cloudtrail.LookupEventsOutput, error)
+
+	// Kinesis video fragments of the stream recorded (producer time) between start and end
+	ListVideoFragments(ctx context.Context, streamName string, start time.Time, end time.Time) (*kinesisvideoarchivedmedia.ListFragmentsOutput, error)
+
+	// Properties of the SiteWise asset of a component of the SiteWise connector
+	GetAssetProperties(ctx context.Context, assetId string) ([]*iotsitewise.AssetProperty, error)
+	// Evenly spaced values of a SiteWise asset property, one page
+	GetInterpolatedAssetPropertyValues(ctx context.Context, req *iotsitewise.GetInterpolatedAssetPropertyValuesInput) (*iotsitewise.GetInterpolatedAssetPropertyValuesOutput, error)
+	// Aggregates of a SiteWise asset property at a resolution, one page
+	GetAssetPropertyAggregates(ctx context.Context, req *iotsitewise.GetAssetPropertyAggregatesInput) (*iotsitewise.GetAssetPropertyAggregatesOutput, error)
+
+	// NOTE: writer role, used to create the demo workspace
+	CreateWorkspace(ctx context.Context, req *iottwinmaker.CreateWorkspaceInput) (*iottwinmaker.CreateWorkspaceOutput, error)
+	CreateComponentType(ctx context.Context, req *iottwinmaker.CreateComponentTypeInput) (*iottwinmaker.CreateComponentTypeOutput, error)
+	CreateEntity(ctx context.Context, req *iottwinmaker.CreateEntityInput) (*iottwinmaker.CreateEntityOutput, error)
+	CreateAssetModel(ctx context.Context, req *iotsitewise.CreateAssetModelInput) (*iotsitewise.CreateAssetModelOutput, error)
+	DescribeAssetModel(ctx context.Context, assetModelId string) (*iotsitewise.DescribeAssetModelOutput, error)
+	CreateAsset(ctx context.Context, req *iotsitewise.CreateAssetInput) (*iotsitewise.CreateAssetOutput, error)
+	DescribeAsset(ctx context.Context, assetId string) (*iotsitewise.DescribeAssetOutput, error)
+	BatchPutAssetPropertyValue(ctx context.Context, req *iotsitewise.BatchPutAssetPropertyValueInput) (*iotsitewise.BatchPutAssetPropertyValueOutput, error)
+
+	// NOTE: writer role, alarms of SiteWise alarm models are acknowledged and snoozed in IoT Events
+	BatchAcknowledgeAlarm(ctx context.Context, req *ioteventsdata.BatchAcknowledgeAlarmInput) (*ioteventsdata.BatchAcknowledgeAlarmOutput, error)
+	BatchSnoozeAlarm(ctx context.Context, req *ioteventsdata.BatchSnoozeAlarmInput) (*ioteventsdata.BatchSnoozeAlarmOutput, error)
 }
 
 type twinMakerClient struct {
 	tokenRole       string
 	tokenRoleWriter string
+	externalId      string // required by the trust policy of cross account roles
+	region          string // session policies construct ARNs in the partition of the region
+	viewer          bool
+
+	twinMakerService  func() (*iottwinmaker.IoTTwinMaker, error)
+	writerService     func() (*iottwinmaker.IoTTwinMaker, error)
+	tokenService      func() (*sts.STS, error)
+	cloudTrailService func() (*cloudtrail.CloudTrail, error)
+	videoService      func() (*kinesisvideo.KinesisVideo, error)
+	videoMediaService func(endpoint string) (*kinesisvideoarchivedmedia.KinesisVideoArchivedMedia, error)
+	s3Service         func() (*s3.S3, error)
+	writerS3Service   func() (*s3.S3, error)
+	siteWiseService   func() (*iotsitewise.IoTSiteWise, error)
+	writerSiteWise    func() (*iotsitewise.IoTSiteWise, error)
+	writerIoTEvents   func() (*ioteventsdata.IoTEventsData, error)
+
+	// adapts the concurrent property value and history calls to the account limits
+	dataPlane *adaptiveLimiter
+
+	// ListFragments data endpoints by stream name
+	videoEndpoints *cache.Cache
+}
 
-	twinMakerService func() (*iottwinmaker.IoTTwinMaker, error)
-	writerService    func() (*iottwinmaker.IoTTwinMaker, error)
-	tokenService     func() (*sts.STS, error)
+// NewTwinMakerClient provides a twinMakerClient for the session and associated calls. The clients
+// of a datasource share its rate, a nil rate is a limiter of the client's own.
+func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting, rate *RateLimiter) (TwinMakerClient, error) {
+	return newTwinMakerClient(settings, rate, false)
 }
 
-// NewTwinMakerClient provides a twinMakerClient for the session and associated calls
-func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting) (TwinMakerClient, error) {
+// NewViewerClient is the client of the viewer role, nil when no viewer role is configured. It
+// can not write and its session tokens get the read only viewer policy.
+func NewViewerClient(settings models.TwinMakerDataSourceSetting, rate *RateLimiter) (TwinMakerClient, error) {
+	if settings.AssumeRoleARNViewer == "" {
+		return nil, nil
+	}
+	viewer := settings
+	viewer.AssumeRoleARN = settings.AssumeRoleARNViewer
+	viewer.AssumeRoleARNWriter = ""
+	return newTwinMakerClient(viewer, rate, true)
+}
+
+// NewQueryRoleClient is the client of a query role, it is assumed like the dashboard role and can
+// not write
+func NewQueryRoleClient(settings models.TwinMakerDataSourceSetting, roleArn string, rate *RateLimiter) (TwinMakerClient, error) {
+	role := settings
+	role.AssumeRoleARN = roleArn
+	role.AssumeRoleARNWriter = ""
+	return newTwinMakerClient(role, rate, false)
+}
+
+func newTwinMakerClient(settings models.TwinMakerDataSourceSetting, rate *RateLimiter, viewer bool) (TwinMakerClient, error) {
 	httpClient, err := httpclient.New()
 	if err != nil {
 		return nil, err
@@ -59,9 +159,21 @@ func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting) (TwinMakerCl
 	if err != nil {
 		return nil, err
 	}
+	// with Private Data source Connect every AWS call, including STS, dials through the proxy
+	if opts := settings.ProxyOptions(); proxy.SecureSocksProxyEnabled(opts) {
+		httpClient, err = httpclient.New(httpclient.Options{
+			ProxyOptions: opts,
+			Middlewares:  []httpclient.Middleware{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		transport = httpClient.Transport
+	}
 	httpClient.Transport = httplogger.NewHTTPLogger("grafana-iot-twinmaker-datasource", transport)
 	sessions := awsds.NewSessionCache()
-	agent := userAgentString("grafana-iot-twinmaker-app")
+	agent := UserAgent()
+	throttle := newThrottle(settings, rate)
 
 	// Clients should not use a custom endpoint to load session credentials
 	noEndpointSettings := settings.AWSDatasourceSettings
@@ -85,7 +197,7 @@ func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting) (TwinMakerCl
 
 	// STS client can not use scoped down role to generate tokens
 	stsSettings := noEndpointSettings
-	stsSettings.AssumeRoleARN = ""
+	stsSettings.AssumeRoleARN = settings.AssumeRoleARNBase
 
 	stsSessionConfig := awsds.SessionConfig{
 		Settings:      stsSettings,
@@ -93,18 +205,30 @@ func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting) (TwinMakerCl
 		UserAgentName: &agent,
 	}
 
+	// With a base role, the dashboard and writer roles are assumed from the base role session
+	getSession := sessions.GetSession
+	getWriterSession := sessions.GetSession
+	if settings.AssumeRoleARNBase != "" {
+		dashboardChain := newRoleChain(sessions, stsSessionConfig, settings.AssumeRoleARN)
+		getSession = func(awsds.SessionConfig) (*session.Session, error) {
+			return dashboardChain.GetSession()
+		}
+		writerChain := newRoleChain(sessions, stsSessionConfig, settings.AssumeRoleARNWriter)
+		getWriterSession = func(awsds.SessionConfig) (*session.Session, error) {
+			return writerChain.GetSession()
+		}
+	}
+
 	twinMakerService := func() (*iottwinmaker.IoTTwinMaker, error) {
-		session, err := sessions.GetSession(noEndpointSessionConfig)
+		session, err := getSession(noEndpointSessionConfig)
 		if err != nil {
 			return nil, err
 		}
 		session.Config.Endpoint = &settings.AWSDatasourceSettings.Endpoint
 
-		svc := iottwinmaker.New(session, aws.NewConfig())
-		svc.Handlers.Send.PushFront(func(r *request.Request) {
-			r.HTTPRequest.Header.Set("User-Agent", agent)
-
-		})
+		svc := iottwinmaker.New(session, throttle.config())
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
 		return svc, err
 	}
 
@@ -112,17 +236,15 @@ func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting) (TwinMakerCl
 		if writerSessionConfig.Settings.AssumeRoleARN == "" {
 			return nil, fmt.Errorf("writer role not configured")
 		}
-		session, err := sessions.GetSession(writerSessionConfig)
+		session, err := getWriterSession(writerSessionConfig)
 		if err != nil {
 			return nil, err
 		}
 		session.Config.Endpoint = &settings.AWSDatasourceSettings.Endpoint
 
-		svc := iottwinmaker.New(session, aws.NewConfig())
-		svc.Handlers.Send.PushFront(func(r *request.Request) {
-			r.HTTPRequest.Header.Set("User-Agent", agent)
-
-		})
+		svc := iottwinmaker.New(session, throttle.config())
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
 		return svc, err
 	}
 
@@ -131,20 +253,148 @@ func NewTwinMakerClient(settings models.TwinMakerDataSourceSetting) (TwinMakerCl
 		if err != nil {
 			return nil, err
 		}
-		svc := sts.New(session, aws.NewConfig())
-		svc.Handlers.Send.PushFront(func(r *request.Request) {
-			r.HTTPRequest.Header.Set("User-Agent", agent)
-		})
+		// tokens are issued by the STS endpoint of the region rather than the global aws endpoint
+		svc := sts.New(session, throttle.config().WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	cloudTrailService := func() (*cloudtrail.CloudTrail, error) {
+		session, err := getSession(noEndpointSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := cloudtrail.New(session, throttle.config())
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	videoService := func() (*kinesisvideo.KinesisVideo, error) {
+		session, err := getSession(noEndpointSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := kinesisvideo.New(session, throttle.config().WithEndpoint(settings.KinesisVideoEndpoint))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
 		return svc, err
 	}
 
-	return &twinMakerClient{
-		twinMakerService: twinMakerService,
-		tokenService:     tokenService,
-		writerService:    writerService,
-		tokenRole:        settings.AWSDatasourceSettings.AssumeRoleARN,
-		tokenRoleWriter:  settings.AssumeRoleARNWriter,
-	}, nil
+	// archived media is served from the data endpoint of each stream
+	videoMediaService := func(endpoint string) (*kinesisvideoarchivedmedia.KinesisVideoArchivedMedia, error) {
+		session, err := getSession(noEndpointSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := kinesisvideoarchivedmedia.New(session, throttle.config().WithEndpoint(endpoint))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	s3Service := func() (*s3.S3, error) {
+		session, err := getSession(noEndpointSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		// the custom endpoint only applies to TwinMaker
+		svc := s3.New(session, throttle.config().WithEndpoint(""))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	writerS3Service := func() (*s3.S3, error) {
+		if writerSessionConfig.Settings.AssumeRoleARN == "" {
+			return nil, fmt.Errorf("writer role not configured")
+		}
+		session, err := getWriterSession(writerSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := s3.New(session, throttle.config().WithEndpoint(""))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	siteWiseService := func() (*iotsitewise.IoTSiteWise, error) {
+		session, err := getSession(noEndpointSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := iotsitewise.New(session, throttle.config().WithEndpoint(settings.SiteWiseEndpoint))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	writerSiteWise := func() (*iotsitewise.IoTSiteWise, error) {
+		if writerSessionConfig.Settings.AssumeRoleARN == "" {
+			return nil, fmt.Errorf("writer role not configured")
+		}
+		session, err := getWriterSession(writerSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := iotsitewise.New(session, throttle.config().WithEndpoint(settings.SiteWiseEndpoint))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	writerIoTEvents := func() (*ioteventsdata.IoTEventsData, error) {
+		if writerSessionConfig.Settings.AssumeRoleARN == "" {
+			return nil, fmt.Errorf("writer role not configured")
+		}
+		session, err := getWriterSession(writerSessionConfig)
+		if err != nil {
+			return nil, err
+		}
+		svc := ioteventsdata.New(session, throttle.config().WithEndpoint(""))
+		svc.Handlers.Sign.PushFront(throttle.wait)
+		svc.Handlers.Send.PushFront(setUserAgent(agent))
+		return svc, err
+	}
+
+	var client TwinMakerClient = &twinMakerClient{
+		twinMakerService:  twinMakerService,
+		tokenService:      tokenService,
+		writerService:     writerService,
+		cloudTrailService: cloudTrailService,
+		videoService:      videoService,
+		videoMediaService: videoMediaService,
+		s3Service:         s3Service,
+		writerS3Service:   writerS3Service,
+		siteWiseService:   siteWiseService,
+		writerSiteWise:    writerSiteWise,
+		writerIoTEvents:   writerIoTEvents,
+		tokenRole:         settings.AWSDatasourceSettings.AssumeRoleARN,
+		tokenRoleWriter:   settings.AssumeRoleARNWriter,
+		externalId:        settings.ExternalID,
+		region:            settings.Region,
+		viewer:            viewer,
+		dataPlane:         newAdaptiveLimiter(),
+		videoEndpoints:    cache.New(videoEndpointTTL, videoEndpointTTL),
+	}
+
+	// the workspace is replicated with the same id, a custom endpoint is region specific
+	if region := settings.SecondaryRegion; region != "" && region != settings.Region {
+		secondarySettings := settings
+		secondarySettings.Region = region
+		secondarySettings.Endpoint = ""
+		secondarySettings.SiteWiseEndpoint = ""
+		secondarySettings.KinesisVideoEndpoint = ""
+		secondarySettings.SecondaryRegion = ""
+		secondary, err := newTwinMakerClient(secondarySettings, throttle.rate, viewer)
+		if err != nil {
+			return nil, err
+		}
+		client = newFailoverClient(client, secondary, region)
+	}
+	return client, nil
 }
 
 func (c *twinMakerClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
@@ -213,16 +463,14 @@ func (c *twinMakerClient) ListScenes(ctx context.Context, query models.TwinMaker
 	return scenes, nil
 }
 
-func (c *twinMakerClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
-	client, err := c.twinMakerService()
-	if err != nil {
-		return nil, err
-	}
-
+func listEntitiesInput(query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesInput, error) {
 	params := &iottwinmaker.ListEntitiesInput{
-		MaxResults:  aws.Int64(200),
+		MaxResults:  aws.Int64(maxListEntitiesPageSize),
 		WorkspaceId: &query.WorkspaceId,
 	}
+	if size := pageSize(query, maxListEntitiesPageSize); size > 0 {
+		params.MaxResults = aws.Int64(int64(size))
+	}
 
 	// this will be overridden if a filter is set
 	if query.ComponentTypeId != "" {
@@ -247,6 +495,20 @@ func (c *twinMakerClient) ListEntities(ctx context.Context, query models.TwinMak
 		}
 	}
 
+	return params, nil
+}
+
+func (c *twinMakerClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	client, err := c.twinMakerService()
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := listEntitiesInput(query)
+	if err != nil {
+		return nil, err
+	}
+
 	entities, err := client.ListEntitiesWithContext(ctx, params)
 	if err != nil {
 		return nil, err
@@ -268,12 +530,27 @@ func (c *twinMakerClient) ListEntities(ctx context.Context, query models.TwinMak
 	return entities, nil
 }
 
-func (c *twinMakerClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+func (c *twinMakerClient) ListEntitiesPage(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
 	client, err := c.twinMakerService()
 	if err != nil {
 		return nil, err
 	}
 
+	params, err := listEntitiesInput(query)
+	if err != nil {
+		return nil, err
+	}
+	if query.MaxResults > 0 {
+		params.MaxResults = aws.Int64(int64(query.MaxResults))
+	}
+	if query.NextToken != "" {
+		params.NextToken = aws.String(query.NextToken)
+	}
+
+	return client.ListEntitiesWithContext(ctx, params)
+}
+
+func listComponentTypesInput(query models.TwinMakerQuery) *iottwinmaker.ListComponentTypesInput {
 	params := &iottwinmaker.ListComponentTypesInput{
 		MaxResults:  aws.Int64(200),
 		NextToken:   aws.String(query.NextToken),
@@ -286,6 +563,16 @@ func (c *twinMakerClient) ListComponentTypes(ctx context.Context, query models.T
 			ExtendsFrom: &query.ComponentTypeId,
 		}
 	}
+	return params
+}
+
+func (c *twinMakerClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	client, err := c.twinMakerService()
+	if err != nil {
+		return nil, err
+	}
+
+	params := listComponentTypesInput(query)
 
 	componentTypes, err := client.ListComponentTypesWithContext(ctx, params)
 	if err != nil {
@@ -308,6 +595,23 @@ func (c *twinMakerClient) ListComponentTypes(ctx context.Context, query models.T
 	return componentTypes, nil
 }
 
+func (c *twinMakerClient) ListComponentTypesPage(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	client, err := c.twinMakerService()
+	if err != nil {
+		return nil, err
+	}
+
+	params := listComponentTypesInput(query)
+	if query.MaxResults > 0 {
+		params.MaxResults = aws.Int64(int64(query.MaxResults))
+	}
+	if query.NextToken == "" {
+		params.NextToken = nil
+	}
+
+	return client.ListComponentTypesWithContext(ctx, params)
+}
+
 func (c *twinMakerClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
 	client, err := c.twinMakerService()
 	if err != nil {
@@ -357,6 +661,51 @@ func (c *twinMakerClient) GetWorkspace(ctx context.Context, query models.TwinMak
 	return client.GetWorkspaceWithContext(ctx, params)
 }
 
+func (c *twinMakerClient) GetScene(ctx context.Context, workspaceId string, sceneId string) (*iottwinmaker.GetSceneOutput, error) {
+	client, err := c.twinMakerService()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &iottwinmaker.GetSceneInput{
+		WorkspaceId: &workspaceId,
+		SceneId:     &sceneId,
+	}
+
+	return client.GetSceneWithContext(ctx, params)
+}
+
+func parseS3Location(location string) (bucket string, key string, ok bool) {
+	if !strings.HasPrefix(location, "s3://") {
+		return "", "", false
+	}
+	bucket, key, ok = strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	return bucket, key, ok && bucket != "" && key != ""
+}
+
+func (c *twinMakerClient) GetSceneContent(ctx context.Context, contentLocation string) ([]byte, error) {
+	bucket, key, ok := parseS3Location(contentLocation)
+	if !ok {
+		return nil, fmt.Errorf("invalid scene content location: %s", contentLocation)
+	}
+
+	client, err := c.s3Service()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, kmsError(err, bucket)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
 func (c *twinMakerClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
 	client, err := c.twinMakerService()
 	if err != nil {
@@ -369,14 +718,18 @@ func (c *twinMakerClient) GetPropertyValue(ctx context.Context, query models.Twi
 	if query.ComponentName == "" {
 		return nil, fmt.Errorf("missing component name")
 	}
-	if query.Properties == nil || len(query.Properties) < 1 {
+	selected := query.Properties
+	if query.PropertyGroupName != "" && len(query.SelectedProperties) > 0 {
+		selected = aws.StringSlice(query.SelectedProperties)
+	}
+	if len(selected) < 1 {
 		return nil, fmt.Errorf("missing property")
 	}
 
 	params := &iottwinmaker.GetPropertyValueInput{
 		EntityId:           &query.EntityId,
 		ComponentName:      &query.ComponentName,
-		SelectedProperties: query.Properties,
+		SelectedProperties: selected,
 		WorkspaceId:        &query.WorkspaceId,
 		MaxResults:         aws.Int64(200),
 	}
@@ -391,7 +744,10 @@ func (c *twinMakerClient) GetPropertyValue(ctx context.Context, query models.Twi
 		params.TabularConditions = tabularConditions
 	}
 
-	propertyValues, err := client.GetPropertyValueWithContext(ctx, params)
+	getPropertyValue := func() (*iottwinmaker.GetPropertyValueOutput, error) {
+		return client.GetPropertyValueWithContext(ctx, params)
+	}
+	propertyValues, err := limitCall(ctx, c.dataPlane, getPropertyValue)
 	if err != nil {
 		return nil, err
 	}
@@ -400,7 +756,7 @@ func (c *twinMakerClient) GetPropertyValue(ctx context.Context, query models.Twi
 	for cPropertyValues.NextToken != nil {
 		params.NextToken = cPropertyValues.NextToken
 
-		cPropertyValues, err := client.GetPropertyValueWithContext(ctx, params)
+		cPropertyValues, err := limitCall(ctx, c.dataPlane, getPropertyValue)
 		if err != nil {
 			return nil, err
 		}
@@ -422,6 +778,9 @@ func (c *twinMakerClient) GetPropertyValueHistory(ctx context.Context, query mod
 		return nil, fmt.Errorf("missing entity id & component type id - either one required")
 	}
 	maxR := int64(query.MaxResults)
+	if maxR == 0 {
+		maxR = int64(pageSize(query, maxHistoryPageSize))
+	}
 
 	params := &iottwinmaker.GetPropertyValueHistoryInput{
 		EndTime:            getTimeStringFromTimeObject(&query.TimeRange.To),
@@ -471,7 +830,161 @@ func (c *twinMakerClient) GetPropertyValueHistory(ctx context.Context, query mod
 		params.SetPropertyFilters(filter)
 	}
 
-	return client.GetPropertyValueHistoryWithContext(ctx, params)
+	return limitCall(ctx, c.dataPlane, func() (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+		return propertyValueHistoryPage(ctx, client, params)
+	})
+}
+
+func (c *twinMakerClient) ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (*ExecuteQueryOutput, error) {
+	client, err := c.twinMakerService()
+	if err != nil {
+		return nil, err
+	}
+
+	if query.QueryStatement == "" {
+		return nil, fmt.Errorf("missing query statement")
+	}
+	maxRows := query.MaxResults
+	if maxRows <= 0 {
+		maxRows = defaultExecuteQueryRows
+	}
+
+	params := &iottwinmaker.ExecuteQueryInput{
+		QueryStatement: &query.QueryStatement,
+		WorkspaceId:    &query.WorkspaceId,
+	}
+	if query.NextToken != "" {
+		params.NextToken = &query.NextToken
+	}
+
+	result := &ExecuteQueryOutput{}
+	for {
+		pageSize := maxRows - len(result.Rows)
+		if pageSize > maxExecuteQueryPage {
+			pageSize = maxExecuteQueryPage
+		}
+		params.MaxResults = aws.Int64(int64(pageSize))
+		page, err := executeQueryPage(ctx, client, params)
+		if err != nil {
+			return nil, err
+		}
+		if result.ColumnDescriptions == nil {
+			result.ColumnDescriptions = page.ColumnDescriptions
+		}
+		result.Rows = append(result.Rows, page.Rows...)
+		result.NextToken = page.NextToken
+		if page.NextToken == nil || len(result.Rows) >= maxRows {
+			return result, nil
+		}
+		params.NextToken = page.NextToken
+	}
+}
+
+func (c *twinMakerClient) LookupWorkspaceEvents(ctx context.Context, query models.TwinMakerQuery) (*cloudtrail.LookupEventsOutput, error) {
+	client, err := c.cloudTrailService()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &cloudtrail.LookupEventsInput{
+		StartTime: aws.Time(query.TimeRange.From),
+		EndTime:   aws.Time(query.TimeRange.To),
+		LookupAttributes: []*cloudtrail.LookupAttribute{
+			{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventSource),
+				AttributeValue: aws.String("iottwinmaker.amazonaws.com"),
+			},
+		},
+		MaxResults: aws.Int64(50),
+	}
+
+	events, err := client.LookupEventsWithContext(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// LookupEvents is limited to 2 requests per second, so cap the number of pages
+	maxPages := 10
+	cEvents := events
+	for page := 1; cEvents.NextToken != nil && page < maxPages; page++ {
+		params.NextToken = cEvents.NextToken
+
+		cEvents, err = client.LookupEventsWithContext(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		events.Events = append(events.Events, cEvents.Events...)
+		events.NextToken = cEvents.NextToken
+	}
+
+	return events, nil
+}
+
+// maxVideoPages bounds the ListFragments pages of one time window, 1000 fragments each
+const maxVideoPages = 10
+
+// videoEndpointTTL is how long the data endpoint of a stream is reused, it only changes when the
+// stream is recreated
+const videoEndpointTTL = time.Hour
+
+// videoEndpoint is the ListFragments data endpoint of the stream, every alarm window of a query
+// reads the same stream
+func (c *twinMakerClient) videoEndpoint(ctx context.Context, streamName string) (string, error) {
+	if endpoint, ok := c.videoEndpoints.Get(streamName); ok {
+		return endpoint.(string), nil
+	}
+	client, err := c.videoService()
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := client.GetDataEndpointWithContext(ctx, &kinesisvideo.GetDataEndpointInput{
+		APIName:    aws.String(kinesisvideo.APINameListFragments),
+		StreamName: &streamName,
+	})
+	if err != nil {
+		return "", err
+	}
+	c.videoEndpoints.SetDefault(streamName, aws.StringValue(endpoint.DataEndpoint))
+	return aws.StringValue(endpoint.DataEndpoint), nil
+}
+
+// ListVideoFragments lists the fragments of the stream in the time window, NextToken is set when
+// there were more than maxVideoPages pages
+func (c *twinMakerClient) ListVideoFragments(ctx context.Context, streamName string, start time.Time, end time.Time) (*kinesisvideoarchivedmedia.ListFragmentsOutput, error) {
+	endpoint, err := c.videoEndpoint(ctx, streamName)
+	if err != nil {
+		return nil, err
+	}
+
+	media, err := c.videoMediaService(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &kinesisvideoarchivedmedia.ListFragmentsInput{
+		StreamName: &streamName,
+		FragmentSelector: &kinesisvideoarchivedmedia.FragmentSelector{
+			FragmentSelectorType: aws.String(kinesisvideoarchivedmedia.FragmentSelectorTypeProducerTimestamp),
+			TimestampRange: &kinesisvideoarchivedmedia.TimestampRange{
+				StartTimestamp: &start,
+				EndTimestamp:   &end,
+			},
+		},
+	}
+	fragments := &kinesisvideoarchivedmedia.ListFragmentsOutput{Fragments: []*kinesisvideoarchivedmedia.Fragment{}}
+	page := 0
+	err = media.ListFragmentsPagesWithContext(ctx, params, func(out *kinesisvideoarchivedmedia.ListFragmentsOutput, last bool) bool {
+		fragments.Fragments = append(fragments.Fragments, out.Fragments...)
+		fragments.NextToken = out.NextToken
+		page++
+		return page < maxVideoPages
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fragments, nil
 }
 
 func (c *twinMakerClient) GetSessionToken(ctx context.Context, duration time.Duration, workspaceId string) (*sts.Credentials, error) {
@@ -496,7 +1009,14 @@ func (c *twinMakerClient) GetSessionToken(ctx context.Context, duration time.Dur
 			return nil, err
 		}
 
-		policy, err := LoadPolicy(workspace)
+		key := ""
+		if workspace.S3Location != nil {
+			if s3Client, err := c.s3Service(); err == nil {
+				key = bucketKey(ctx, s3Client, sceneAssetBucket(*workspace.S3Location))
+			}
+		}
+
+		policy, err := loadPolicy(workspace, c.region, key, c.viewer)
 		if err != nil {
 			return nil, err
 		}
@@ -507,6 +1027,9 @@ func (c *twinMakerClient) GetSessionToken(ctx context.Context, duration time.Dur
 			RoleSessionName: aws.String("grafana"),
 			Policy:          aws.String(policy),
 		}
+		if c.externalId != "" {
+			input.ExternalId = aws.String(c.externalId)
+		}
 
 		out, err := tokenService.AssumeRoleWithContext(ctx, input)
 		if err != nil {
@@ -534,6 +1057,9 @@ func (c *twinMakerClient) GetWriteSessionToken(ctx context.Context, duration tim
 		DurationSeconds: aws.Int64(int64(duration.Seconds())),
 		RoleSessionName: aws.String("grafana"),
 	}
+	if c.externalId != "" {
+		input.ExternalId = aws.String(c.externalId)
+	}
 
 	out, err := tokenService.AssumeRoleWithContext(ctx, input)
 	if err != nil {
@@ -551,25 +1077,208 @@ func (c *twinMakerClient) BatchPutPropertyValues(ctx context.Context, req *iottw
 	return client.BatchPutPropertyValuesWithContext(ctx, req)
 }
 
-// TODO, move to https://github.com/grafana/grafana-plugin-sdk-go
-func userAgentString(name string) string {
-	buildInfo, err := build.GetBuildInfo()
+func (c *twinMakerClient) PutAuditObject(ctx context.Context, location string, body []byte) error {
+	bucket, key, ok := parseS3Location(location)
+	if !ok {
+		return fmt.Errorf("invalid audit log location: %s", location)
+	}
+
+	client, err := c.writerS3Service()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return kmsError(err, bucket)
+}
+
+func (c *twinMakerClient) PutSceneAsset(ctx context.Context, location string, contentType string, body []byte) error {
+	bucket, key, ok := parseS3Location(location)
+	if !ok {
+		return fmt.Errorf("invalid scene asset location: %s", location)
+	}
+
+	client, err := c.writerS3Service()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return kmsError(err, bucket)
+}
+
+func (c *twinMakerClient) ListTagsForResource(ctx context.Context, resourceArn string) (*iottwinmaker.ListTagsForResourceOutput, error) {
+	client, err := c.twinMakerService()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &iottwinmaker.ListTagsForResourceInput{
+		MaxResults:  aws.Int64(200),
+		ResourceARN: aws.String(resourceArn),
+	}
+
+	tags, err := client.ListTagsForResourceWithContext(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if tags.Tags == nil {
+		tags.Tags = map[string]*string{}
+	}
+
+	for tags.NextToken != nil {
+		params.NextToken = tags.NextToken
+
+		page, err := client.ListTagsForResourceWithContext(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range page.Tags {
+			tags.Tags[k] = v
+		}
+		tags.NextToken = page.NextToken
+	}
+
+	return tags, nil
+}
+
+func (c *twinMakerClient) TagResource(ctx context.Context, req *iottwinmaker.TagResourceInput) (*iottwinmaker.TagResourceOutput, error) {
+	client, err := c.writerService()
+	if err != nil {
+		return nil, err
+	}
+	return client.TagResourceWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) UntagResource(ctx context.Context, req *iottwinmaker.UntagResourceInput) (*iottwinmaker.UntagResourceOutput, error) {
+	client, err := c.writerService()
+	if err != nil {
+		return nil, err
+	}
+	return client.UntagResourceWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) CreateWorkspace(ctx context.Context, req *iottwinmaker.CreateWorkspaceInput) (*iottwinmaker.CreateWorkspaceOutput, error) {
+	client, err := c.writerService()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateWorkspaceWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) CreateComponentType(ctx context.Context, req *iottwinmaker.CreateComponentTypeInput) (*iottwinmaker.CreateComponentTypeOutput, error) {
+	client, err := c.writerService()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateComponentTypeWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) CreateEntity(ctx context.Context, req *iottwinmaker.CreateEntityInput) (*iottwinmaker.CreateEntityOutput, error) {
+	client, err := c.writerService()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateEntityWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) GetAssetProperties(ctx context.Context, assetId string) ([]*iotsitewise.AssetProperty, error) {
+	client, err := c.siteWiseService()
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := client.DescribeAssetWithContext(ctx, &iotsitewise.DescribeAssetInput{
+		AssetId: &assetId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rsp.AssetProperties, nil
+}
+
+func (c *twinMakerClient) GetInterpolatedAssetPropertyValues(ctx context.Context, req *iotsitewise.GetInterpolatedAssetPropertyValuesInput) (*iotsitewise.GetInterpolatedAssetPropertyValuesOutput, error) {
+	client, err := c.siteWiseService()
+	if err != nil {
+		return nil, err
+	}
+	return client.GetInterpolatedAssetPropertyValuesWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) GetAssetPropertyAggregates(ctx context.Context, req *iotsitewise.GetAssetPropertyAggregatesInput) (*iotsitewise.GetAssetPropertyAggregatesOutput, error) {
+	client, err := c.siteWiseService()
+	if err != nil {
+		return nil, err
+	}
+	return client.GetAssetPropertyAggregatesWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) CreateAssetModel(ctx context.Context, req *iotsitewise.CreateAssetModelInput) (*iotsitewise.CreateAssetModelOutput, error) {
+	client, err := c.writerSiteWise()
 	if err != nil {
-		buildInfo.Version = "dev"
-		buildInfo.Hash = "?"
+		return nil, err
 	}
+	return client.CreateAssetModelWithContext(ctx, req)
+}
 
-	if len(buildInfo.Hash) > 8 {
-		buildInfo.Hash = buildInfo.Hash[0:8]
+func (c *twinMakerClient) DescribeAssetModel(ctx context.Context, assetModelId string) (*iotsitewise.DescribeAssetModelOutput, error) {
+	client, err := c.writerSiteWise()
+	if err != nil {
+		return nil, err
 	}
+	return client.DescribeAssetModelWithContext(ctx, &iotsitewise.DescribeAssetModelInput{
+		AssetModelId: &assetModelId,
+	})
+}
 
-	return fmt.Sprintf("%s/%s (%s; %s;) %s/%s-%s Grafana/%s",
-		aws.SDKName,
-		aws.SDKVersion,
-		runtime.Version(),
-		runtime.GOOS,
-		name,
-		buildInfo.Version,
-		buildInfo.Hash,
-		os.Getenv("GF_VERSION"))
+func (c *twinMakerClient) CreateAsset(ctx context.Context, req *iotsitewise.CreateAssetInput) (*iotsitewise.CreateAssetOutput, error) {
+	client, err := c.writerSiteWise()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateAssetWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) DescribeAsset(ctx context.Context, assetId string) (*iotsitewise.DescribeAssetOutput, error) {
+	client, err := c.writerSiteWise()
+	if err != nil {
+		return nil, err
+	}
+	return client.DescribeAssetWithContext(ctx, &iotsitewise.DescribeAssetInput{
+		AssetId: &assetId,
+	})
+}
+
+func (c *twinMakerClient) BatchPutAssetPropertyValue(ctx context.Context, req *iotsitewise.BatchPutAssetPropertyValueInput) (*iotsitewise.BatchPutAssetPropertyValueOutput, error) {
+	client, err := c.writerSiteWise()
+	if err != nil {
+		return nil, err
+	}
+	return client.BatchPutAssetPropertyValueWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) BatchAcknowledgeAlarm(ctx context.Context, req *ioteventsdata.BatchAcknowledgeAlarmInput) (*ioteventsdata.BatchAcknowledgeAlarmOutput, error) {
+	client, err := c.writerIoTEvents()
+	if err != nil {
+		return nil, err
+	}
+	return client.BatchAcknowledgeAlarmWithContext(ctx, req)
+}
+
+func (c *twinMakerClient) BatchSnoozeAlarm(ctx context.Context, req *ioteventsdata.BatchSnoozeAlarmInput) (*ioteventsdata.BatchSnoozeAlarmOutput, error) {
+	client, err := c.writerIoTEvents()
+	if err != nil {
+		return nil, err
+	}
+	return client.BatchSnoozeAlarmWithContext(ctx, req)
 }
@@ -4,16 +4,21 @@ import (
 	"context"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/ioteventsdata"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/aws/aws-sdk-go/service/kinesisvideoarchivedmedia"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
-	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/patrickmn/go-cache"
 )
 
 type cachingClient struct {
 	client       TwinMakerClient
 	generalCache cache.Cache
+	// optional, keeps the entity and component type metadata across restarts
+	store *metadataStore
 }
 
 func NewCachingClient(client TwinMakerClient, ttl time.Duration) TwinMakerClient {
@@ -23,13 +28,26 @@ func NewCachingClient(client TwinMakerClient, ttl time.Duration) TwinMakerClient
 	}
 }
 
-func (c *cachingClient) getOrExecuteQuery(key string, runner func() (interface{}, error)) (interface{}, error) {
+type refreshKey struct{}
+
+// withRefresh makes the cached calls of the context load their value again and replace the cached
+// one, for results that must not be older than a cache of the caller, e.g. externalId resolutions
+func withRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+func isRefresh(ctx context.Context) bool {
+	v, _ := ctx.Value(refreshKey{}).(bool)
+	return v
+}
+
+func (c *cachingClient) getOrExecuteQuery(ctx context.Context, key string, runner func() (interface{}, error)) (interface{}, error) {
 	if key == "" {
 		return runner()
 	}
 	val, ok := c.generalCache.Get(key)
-	if ok {
-		backend.Logger.Debug("using cached value", "key", key)
+	if ok && !isRefresh(ctx) {
+		loggerFromContext(ctx).Debug("using cached value", "key", key)
 		return val, nil
 	}
 	val, err := runner()
@@ -39,8 +57,33 @@ func (c *cachingClient) getOrExecuteQuery(key string, runner func() (interface{}
 	return val, nil
 }
 
+// getOrExecutePersisted is getOrExecuteQuery that also reads and writes the metadata store,
+// val is the empty value a stored entry is decoded into
+func (c *cachingClient) getOrExecutePersisted(ctx context.Context, key string, val interface{}, runner func() (interface{}, error)) (interface{}, error) {
+	if c.store == nil || key == "" {
+		return c.getOrExecuteQuery(ctx, key, runner)
+	}
+	if v, ok := c.generalCache.Get(key); ok && !isRefresh(ctx) {
+		loggerFromContext(ctx).Debug("using cached value", "key", key)
+		return v, nil
+	}
+	if !isRefresh(ctx) && c.store.get(key, val) {
+		loggerFromContext(ctx).Debug("using stored value", "key", key)
+		c.generalCache.Set(key, val, 0)
+		return val, nil
+	}
+	v, err := runner()
+	if err != nil {
+		return nil, err
+	}
+	c.generalCache.Set(key, v, 0)
+	c.store.put(key, v)
+	return v, nil
+}
+
 func (c *cachingClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
 	val, err := c.getOrExecuteQuery(
+		ctx,
 		query.CacheKey("ListWorkspace"),
 		func() (interface{}, error) {
 			return c.client.ListWorkspaces(ctx, query)
@@ -57,6 +100,7 @@ func (c *cachingClient) ListWorkspaces(ctx context.Context, query models.TwinMak
 
 func (c *cachingClient) ListScenes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListScenesOutput, error) {
 	val, err := c.getOrExecuteQuery(
+		ctx,
 		query.CacheKey("ListScenes"),
 		func() (interface{}, error) {
 			return c.client.ListScenes(ctx, query)
@@ -72,8 +116,10 @@ func (c *cachingClient) ListScenes(ctx context.Context, query models.TwinMakerQu
 }
 
 func (c *cachingClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
-	val, err := c.getOrExecuteQuery(
+	val, err := c.getOrExecutePersisted(
+		ctx,
 		query.CacheKey("ListEntities"),
+		&iottwinmaker.ListEntitiesOutput{},
 		func() (interface{}, error) {
 			return c.client.ListEntities(ctx, query)
 		},
@@ -88,8 +134,10 @@ func (c *cachingClient) ListEntities(ctx context.Context, query models.TwinMaker
 }
 
 func (c *cachingClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
-	val, err := c.getOrExecuteQuery(
+	val, err := c.getOrExecutePersisted(
+		ctx,
 		query.CacheKey("ListComponentTypes"),
+		&iottwinmaker.ListComponentTypesOutput{},
 		func() (interface{}, error) {
 			return c.client.ListComponentTypes(ctx, query)
 		},
@@ -103,9 +151,21 @@ func (c *cachingClient) ListComponentTypes(ctx context.Context, query models.Twi
 	return nil, err
 }
 
+func (c *cachingClient) ListEntitiesPage(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	// not cached
+	return c.client.ListEntitiesPage(ctx, query)
+}
+
+func (c *cachingClient) ListComponentTypesPage(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	// not cached
+	return c.client.ListComponentTypesPage(ctx, query)
+}
+
 func (c *cachingClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
-	val, err := c.getOrExecuteQuery(
+	val, err := c.getOrExecutePersisted(
+		ctx,
 		query.CacheKey("GetComponentType"),
+		&iottwinmaker.GetComponentTypeOutput{},
 		func() (interface{}, error) {
 			return c.client.GetComponentType(ctx, query)
 		},
@@ -120,8 +180,10 @@ func (c *cachingClient) GetComponentType(ctx context.Context, query models.TwinM
 }
 
 func (c *cachingClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
-	val, err := c.getOrExecuteQuery(
+	val, err := c.getOrExecutePersisted(
+		ctx,
 		query.CacheKey("GetEntity"),
+		&iottwinmaker.GetEntityOutput{},
 		func() (interface{}, error) {
 			return c.client.GetEntity(ctx, query)
 		},
@@ -137,6 +199,7 @@ func (c *cachingClient) GetEntity(ctx context.Context, query models.TwinMakerQue
 
 func (c *cachingClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
 	val, err := c.getOrExecuteQuery(
+		ctx,
 		query.CacheKey("GetWorkspace"),
 		func() (interface{}, error) {
 			return c.client.GetWorkspace(ctx, query)
@@ -161,6 +224,79 @@ func (c *cachingClient) GetPropertyValueHistory(ctx context.Context, query model
 	return c.client.GetPropertyValueHistory(ctx, query)
 }
 
+func (c *cachingClient) ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (*ExecuteQueryOutput, error) {
+	// not cached
+	return c.client.ExecuteQuery(ctx, query)
+}
+
+func (c *cachingClient) PutAuditObject(ctx context.Context, location string, body []byte) error {
+	return c.client.PutAuditObject(ctx, location, body)
+}
+
+func (c *cachingClient) PutSceneAsset(ctx context.Context, location string, contentType string, body []byte) error {
+	return c.client.PutSceneAsset(ctx, location, contentType, body)
+}
+
+func (c *cachingClient) ListTagsForResource(ctx context.Context, resourceArn string) (*iottwinmaker.ListTagsForResourceOutput, error) {
+	// not cached, read right before the tags are edited
+	return c.client.ListTagsForResource(ctx, resourceArn)
+}
+
+func (c *cachingClient) TagResource(ctx context.Context, req *iottwinmaker.TagResourceInput) (*iottwinmaker.TagResourceOutput, error) {
+	return c.client.TagResource(ctx, req)
+}
+
+func (c *cachingClient) UntagResource(ctx context.Context, req *iottwinmaker.UntagResourceInput) (*iottwinmaker.UntagResourceOutput, error) {
+	return c.client.UntagResource(ctx, req)
+}
+
+func (c *cachingClient) GetScene(ctx context.Context, workspaceId string, sceneId string) (*iottwinmaker.GetSceneOutput, error) {
+	// not cached
+	return c.client.GetScene(ctx, workspaceId, sceneId)
+}
+
+func (c *cachingClient) GetSceneContent(ctx context.Context, contentLocation string) ([]byte, error) {
+	// not cached
+	return c.client.GetSceneContent(ctx, contentLocation)
+}
+
+func (c *cachingClient) LookupWorkspaceEvents(ctx context.Context, query models.TwinMakerQuery) (*cloudtrail.LookupEventsOutput, error) {
+	// not cached
+	return c.client.LookupWorkspaceEvents(ctx, query)
+}
+
+func (c *cachingClient) ListVideoFragments(ctx context.Context, streamName string, start time.Time, end time.Time) (*kinesisvideoarchivedmedia.ListFragmentsOutput, error) {
+	// not cached
+	return c.client.ListVideoFragments(ctx, streamName, start, end)
+}
+
+func (c *cachingClient) GetAssetProperties(ctx context.Context, assetId string) ([]*iotsitewise.AssetProperty, error) {
+	val, err := c.getOrExecuteQuery(
+		ctx,
+		"AssetProperties/"+assetId,
+		func() (interface{}, error) {
+			return c.client.GetAssetProperties(ctx, assetId)
+		},
+	)
+	if err == nil {
+		a, ok := val.([]*iotsitewise.AssetProperty)
+		if ok {
+			return a, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *cachingClient) GetInterpolatedAssetPropertyValues(ctx context.Context, req *iotsitewise.GetInterpolatedAssetPropertyValuesInput) (*iotsitewise.GetInterpolatedAssetPropertyValuesOutput, error) {
+	// not cached
+	return c.client.GetInterpolatedAssetPropertyValues(ctx, req)
+}
+
+func (c *cachingClient) GetAssetPropertyAggregates(ctx context.Context, req *iotsitewise.GetAssetPropertyAggregatesInput) (*iotsitewise.GetAssetPropertyAggregatesOutput, error) {
+	// not cached
+	return c.client.GetAssetPropertyAggregates(ctx, req)
+}
+
 func (c *cachingClient) GetSessionToken(ctx context.Context, duration time.Duration, workspaceId string) (*sts.Credentials, error) {
 	// not cached
 	return c.client.GetSessionToken(ctx, duration, workspaceId)
@@ -175,3 +311,45 @@ func (c *cachingClient) BatchPutPropertyValues(ctx context.Context, request *iot
 	// not cached
 	return c.client.BatchPutPropertyValues(ctx, request)
 }
+
+// NOTE: the demo workspace calls are writes, and the status polls must not be cached
+
+func (c *cachingClient) CreateWorkspace(ctx context.Context, req *iottwinmaker.CreateWorkspaceInput) (*iottwinmaker.CreateWorkspaceOutput, error) {
+	return c.client.CreateWorkspace(ctx, req)
+}
+
+func (c *cachingClient) CreateComponentType(ctx context.Context, req *iottwinmaker.CreateComponentTypeInput) (*iottwinmaker.CreateComponentTypeOutput, error) {
+	return c.client.CreateComponentType(ctx, req)
+}
+
+func (c *cachingClient) CreateEntity(ctx context.Context, req *iottwinmaker.CreateEntityInput) (*iottwinmaker.CreateEntityOutput, error) {
+	return c.client.CreateEntity(ctx, req)
+}
+
+func (c *cachingClient) CreateAssetModel(ctx context.Context, req *iotsitewise.CreateAssetModelInput) (*iotsitewise.CreateAssetModelOutput, error) {
+	return c.client.CreateAssetModel(ctx, req)
+}
+
+func (c *cachingClient) DescribeAssetModel(ctx context.Context, assetModelId string) (*iotsitewise.DescribeAssetModelOutput, error) {
+	return c.client.DescribeAssetModel(ctx, assetModelId)
+}
+
+func (c *cachingClient) CreateAsset(ctx context.Context, req *iotsitewise.CreateAssetInput) (*iotsitewise.CreateAssetOutput, error) {
+	return c.client.CreateAsset(ctx, req)
+}
+
+func (c *cachingClient) DescribeAsset(ctx context.Context, assetId string) (*iotsitewise.DescribeAssetOutput, error) {
+	return c.client.DescribeAsset(ctx, assetId)
+}
+
+func (c *cachingClient) BatchPutAssetPropertyValue(ctx context.Context, req *iotsitewise.BatchPutAssetPropertyValueInput) (*iotsitewise.BatchPutAssetPropertyValueOutput, error) {
+	return c.client.BatchPutAssetPropertyValue(ctx, req)
+}
+
+func (c *cachingClient) BatchAcknowledgeAlarm(ctx context.Context, req *ioteventsdata.BatchAcknowledgeAlarmInput) (*ioteventsdata.BatchAcknowledgeAlarmOutput, error) {
+	return c.client.BatchAcknowledgeAlarm(ctx, req)
+}
+
+func (c *cachingClient) BatchSnoozeAlarm(ctx context.Context, req *ioteventsdata.BatchSnoozeAlarmInput) (*ioteventsdata.BatchSnoozeAlarmOutput, error) {
+	return c.client.BatchSnoozeAlarm(ctx, req)
+}
@@ -0,0 +1,146 @@
+package twinmaker
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// metadataStore keeps the entity and component type cache in a bolt file, so a restarted plugin
+// does not have to list the whole workspace again. The file is plain JSON readable by anyone with
+// access to the plugin data directory, it is created 0600 and entities are stored with the
+// redaction rules applied.
+type metadataStore struct {
+	file   *metadataFile
+	bucket []byte
+	ttl    time.Duration
+	// applied to the property values of stored entities, masked and dropped values never reach
+	// the disk
+	redaction *redactor
+}
+
+// metadataFile is shared by the datasources using the same file, a new instance opens it before
+// the previous one is disposed
+type metadataFile struct {
+	path string
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	metadataFilesMu sync.Mutex
+	metadataFiles   = map[string]*metadataFile{}
+)
+
+type metadataEntry struct {
+	Time  time.Time       `json:"t"`
+	Value json.RawMessage `json:"v"`
+}
+
+// openMetadataStore opens (or creates) the bolt file, entries are kept per bucket
+func openMetadataStore(path string, bucket string, ttl time.Duration) (*metadataStore, error) {
+	metadataFilesMu.Lock()
+	defer metadataFilesMu.Unlock()
+
+	f, ok := metadataFiles[path]
+	if !ok {
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, err
+		}
+		f = &metadataFile{path: path, db: db}
+		metadataFiles[path] = f
+	}
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		if f.refs == 0 {
+			_ = f.db.Close()
+			delete(metadataFiles, path)
+		}
+		return nil, err
+	}
+	f.refs++
+	return &metadataStore{file: f, bucket: []byte(bucket), ttl: ttl}, nil
+}
+
+// get decodes an entry younger than the ttl into val
+func (s *metadataStore) get(key string, val interface{}) bool {
+	return s.read(key, val, s.ttl)
+}
+
+// read decodes an entry younger than maxAge into val, any entry when maxAge is zero
+func (s *metadataStore) read(key string, val interface{}, maxAge time.Duration) bool {
+	found := false
+	err := s.file.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket).Get([]byte(key))
+		if b == nil {
+			return nil
+		}
+		entry := metadataEntry{}
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return err
+		}
+		if maxAge > 0 && time.Since(entry.Time) > maxAge {
+			return nil
+		}
+		if err := json.Unmarshal(entry.Value, val); err != nil {
+			return err
+		}
+		found = true
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Warn("could not read the metadata cache", "key", key, "error", err)
+		return false
+	}
+	return found
+}
+
+func (s *metadataStore) put(key string, val interface{}) {
+	value, err := json.Marshal(val)
+	if entity, ok := val.(*iottwinmaker.GetEntityOutput); ok && err == nil && s.redaction != nil && entity != nil {
+		value, err = s.redactedEntity(value)
+	}
+	if err == nil {
+		var b []byte
+		b, err = json.Marshal(metadataEntry{Time: time.Now(), Value: value})
+		if err == nil {
+			err = s.file.db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket(s.bucket).Put([]byte(key), b)
+			})
+		}
+	}
+	if err != nil {
+		log.DefaultLogger.Warn("could not write the metadata cache", "key", key, "error", err)
+	}
+}
+
+// redactedEntity is the JSON of a redacted copy of the entity JSON, the cached entity is kept as is
+func (s *metadataStore) redactedEntity(value []byte) ([]byte, error) {
+	entity := &iottwinmaker.GetEntityOutput{}
+	if err := json.Unmarshal(value, entity); err != nil {
+		return nil, err
+	}
+	s.redaction.entity(entity)
+	return json.Marshal(entity)
+}
+
+// close releases the file, it is closed when no datasource uses it
+func (s *metadataStore) close() error {
+	metadataFilesMu.Lock()
+	defer metadataFilesMu.Unlock()
+
+	s.file.refs--
+	if s.file.refs > 0 {
+		return nil
+	}
+	delete(metadataFiles, s.file.path)
+	return s.file.db.Close()
+}
@@ -0,0 +1,99 @@
+package twinmaker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type listEntitiesMockClient struct {
+	*twinMakerMockClient
+	calls int
+}
+
+func (c *listEntitiesMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	c.calls++
+	return &iottwinmaker.ListEntitiesOutput{
+		EntitySummaries: []*iottwinmaker.EntitySummary{{EntityId: aws.String("Mixer_0"), EntityName: aws.String("Mixer 0")}},
+	}, nil
+}
+
+func TestMetadataStore(t *testing.T) {
+	settings := models.TwinMakerDataSourceSetting{
+		MetadataCacheFile: filepath.Join(t.TempDir(), "metadata.db"),
+		UID:               "abc",
+	}
+	query := models.TwinMakerQuery{WorkspaceId: "w"}
+	client := &listEntitiesMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+
+	ds := NewDatasourceWithClient(settings, client)
+	require.NotNil(t, ds.store)
+	cached := ds.Handler.(*twinMakerHandler).client
+	_, err := cached.ListEntities(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, 1, client.calls)
+
+	// a second instance opens the file while the first is still in use
+	restarted := NewDatasourceWithClient(settings, client)
+	require.NoError(t, ds.Close())
+	rsp, err := restarted.Handler.(*twinMakerHandler).client.ListEntities(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, 1, client.calls)
+	require.Equal(t, "Mixer 0", *rsp.EntitySummaries[0].EntityName)
+
+	// entries of another datasource are not shared
+	settings.UID = "other"
+	other := NewDatasourceWithClient(settings, client)
+	_, err = other.Handler.(*twinMakerHandler).client.ListEntities(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, 2, client.calls)
+
+	require.NoError(t, other.Close())
+	require.NoError(t, restarted.Close())
+	require.Empty(t, metadataFiles)
+}
+
+type secretEntityMockClient struct {
+	*twinMakerMockClient
+}
+
+func (c *secretEntityMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{
+		EntityId: aws.String("Mixer_0"),
+		Components: map[string]*iottwinmaker.ComponentResponse{
+			"Config": {Properties: map[string]*iottwinmaker.PropertyResponse{
+				"password": {Value: &iottwinmaker.DataValue{StringValue: aws.String("hunter2")}},
+				"token":    {Value: &iottwinmaker.DataValue{StringValue: aws.String("abc123")}},
+				"location": {Value: &iottwinmaker.DataValue{StringValue: aws.String("hall 1")}},
+			}},
+		},
+	}, nil
+}
+
+func TestMetadataStoreRedaction(t *testing.T) {
+	settings := models.TwinMakerDataSourceSetting{
+		MetadataCacheFile: filepath.Join(t.TempDir(), "metadata.db"),
+		RedactionRules: []models.RedactionRule{
+			{Pattern: "password"},
+			{Pattern: "token", Action: models.RedactionDrop},
+		},
+	}
+	ds := NewDatasourceWithClient(settings, &secretEntityMockClient{twinMakerMockClient: &twinMakerMockClient{}})
+	entity, err := ds.Handler.(*twinMakerHandler).client.GetEntity(context.Background(), models.TwinMakerQuery{WorkspaceId: "w", EntityId: "Mixer_0"})
+	require.NoError(t, err)
+	// the cache in memory keeps the raw values, they are redacted when converted
+	require.Equal(t, "hunter2", *entity.Components["Config"].Properties["password"].Value.StringValue)
+	require.NoError(t, ds.Close())
+
+	b, err := os.ReadFile(settings.MetadataCacheFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "hunter2")
+	require.NotContains(t, string(b), "abc123")
+	require.Contains(t, string(b), "hall 1")
+}
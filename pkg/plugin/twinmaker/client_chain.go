@@ -0,0 +1,64 @@
+package twinmaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+)
+
+// chainExpiryWindow refreshes the second hop credentials before they expire, so queries never
+// wait on (or fail with) an expired session
+const chainExpiryWindow = 5 * time.Minute
+
+// roleChain assumes a second role from the session of the base role (first hop). Both sessions
+// are cached: the base session by the awsds session cache, the chained one here until the base
+// session is replaced. The external ID of the base settings is passed on to the second hop.
+type roleChain struct {
+	sessions *awsds.SessionCache
+	base     awsds.SessionConfig
+	roleArn  string
+
+	mu          sync.Mutex
+	baseSession *session.Session
+	session     *session.Session
+}
+
+func newRoleChain(sessions *awsds.SessionCache, base awsds.SessionConfig, roleArn string) *roleChain {
+	return &roleChain{
+		sessions: sessions,
+		base:     base,
+		roleArn:  roleArn,
+	}
+}
+
+func (c *roleChain) GetSession() (*session.Session, error) {
+	baseSession, err := c.sessions.GetSession(c.base)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil && c.baseSession == baseSession {
+		return c.session, nil
+	}
+
+	// the second hop is assumed with the STS endpoint of the region, which resolves in its partition
+	stsSession := baseSession.Copy(aws.NewConfig().WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint))
+	creds := stscreds.NewCredentials(stsSession, c.roleArn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "grafana"
+		p.ExpiryWindow = chainExpiryWindow
+		if id := c.base.Settings.ExternalID; id != "" {
+			p.ExternalID = aws.String(id)
+		}
+	})
+	c.session = baseSession.Copy(&aws.Config{Credentials: creds})
+	c.baseSession = baseSession
+	return c.session, nil
+}
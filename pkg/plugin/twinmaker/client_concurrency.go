@@ -0,0 +1,138 @@
+package twinmaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Limits of the data plane calls (property values and history) in flight per client
+const (
+	initialDataPlaneLimit = 4
+	minDataPlaneLimit     = 1
+	maxDataPlaneLimit     = 32
+
+	// latency above the tolerance times the baseline stops the limit from growing
+	latencyTolerance = 2
+	// the limit shrinks at most once per backoffInterval, a burst of throttles is one signal
+	backoffInterval = time.Second
+)
+
+// adaptiveLimiter bounds the concurrent calls with additive increase, multiplicative decrease:
+// the limit grows by about one per round of calls while the latency stays near the baseline, and
+// halves after a throttle or timeout. A nil limiter does not limit anything.
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	// the lowest recent latency, slowly drifting up so a single fast call does not stick
+	baseline time.Duration
+	backoff  time.Time
+	// closed and replaced when a call finishes, so waiting calls check again
+	wake chan struct{}
+}
+
+func newAdaptiveLimiter() *adaptiveLimiter {
+	return &adaptiveLimiter{
+		limit: initialDataPlaneLimit,
+		wake:  make(chan struct{}),
+	}
+}
+
+// Limit is the current number of calls allowed at once
+func (l *adaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *adaptiveLimiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	close(l.wake)
+	l.wake = make(chan struct{})
+
+	previous := int(l.limit)
+	switch {
+	case congested(err):
+		if now := time.Now(); now.After(l.backoff) {
+			l.backoff = now.Add(backoffInterval)
+			l.limit /= 2
+			if l.limit < minDataPlaneLimit {
+				l.limit = minDataPlaneLimit
+			}
+		}
+	case err == nil:
+		if l.baseline == 0 || latency < l.baseline {
+			l.baseline = latency
+		} else {
+			l.baseline += (latency - l.baseline) / 100
+		}
+		if latency <= latencyTolerance*l.baseline {
+			l.limit += 1 / l.limit
+			if l.limit > maxDataPlaneLimit {
+				l.limit = maxDataPlaneLimit
+			}
+		}
+	}
+	if current := int(l.limit); current != previous {
+		log.DefaultLogger.Debug("data plane concurrency changed", "limit", current, "error", err)
+	}
+}
+
+// congested is true for errors that mean the service or the network is overloaded
+func congested(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || request.IsErrorThrottle(err) {
+		return true
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code() == request.ErrCodeResponseTimeout || awsErr.Code() == "RequestTimeout"
+	}
+	return false
+}
+
+// limitCall runs fn in a slot of the limiter and adapts the limit to how it went. Calls of a
+// sharded query use the limiter of their shard.
+func limitCall[T any](ctx context.Context, l *adaptiveLimiter, fn func() (T, error)) (T, error) {
+	l = dataPlaneLimiterFrom(ctx, l)
+	if l == nil {
+		return fn()
+	}
+	if err := l.acquire(ctx); err != nil {
+		var empty T
+		return empty, err
+	}
+	start := time.Now()
+	rsp, err := fn()
+	l.release(time.Since(start), err)
+	return rsp, err
+}
@@ -0,0 +1,81 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "rate exceeded", nil)
+
+	t.Run("grows while latency is healthy", func(t *testing.T) {
+		l := newAdaptiveLimiter()
+		// about one more per round of calls
+		for i := 0; i < initialDataPlaneLimit+1; i++ {
+			l.release(10*time.Millisecond, nil)
+		}
+		require.Equal(t, initialDataPlaneLimit+1, l.Limit())
+
+		// slow calls keep the limit
+		for i := 0; i < 10; i++ {
+			l.release(time.Second, nil)
+		}
+		require.Equal(t, initialDataPlaneLimit+1, l.Limit())
+
+		for i := 0; i < 10000; i++ {
+			l.release(10*time.Millisecond, nil)
+		}
+		require.Equal(t, maxDataPlaneLimit, l.Limit())
+	})
+
+	t.Run("halves once per interval on throttles", func(t *testing.T) {
+		l := newAdaptiveLimiter()
+		l.release(0, throttled)
+		l.release(0, throttled)
+		require.Equal(t, initialDataPlaneLimit/2, l.Limit())
+
+		l.backoff = time.Time{}
+		l.release(0, context.DeadlineExceeded)
+		require.Equal(t, minDataPlaneLimit, l.Limit())
+
+		l.backoff = time.Time{}
+		l.release(0, throttled)
+		require.Equal(t, minDataPlaneLimit, l.Limit())
+	})
+
+	t.Run("ignores other errors", func(t *testing.T) {
+		l := newAdaptiveLimiter()
+		l.release(0, fmt.Errorf("entity not found"))
+		require.Equal(t, initialDataPlaneLimit, l.Limit())
+	})
+
+	t.Run("waits for a slot", func(t *testing.T) {
+		l := newAdaptiveLimiter()
+		l.limit = 1
+		require.NoError(t, l.acquire(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+
+		done := make(chan error)
+		go func() {
+			done <- l.acquire(context.Background())
+		}()
+		l.release(time.Millisecond, nil)
+		require.NoError(t, <-done)
+	})
+
+	t.Run("nil limiter", func(t *testing.T) {
+		rsp, err := limitCall(context.Background(), nil, func() (string, error) {
+			return "ok", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "ok", rsp)
+	})
+}
@@ -0,0 +1,131 @@
+package twinmaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// failoverClient retries reads against a secondary region when the primary region can not be reached.
+// Writes, tokens and other calls always use the primary region.
+type failoverClient struct {
+	TwinMakerClient
+	secondary       TwinMakerClient
+	secondaryRegion string
+}
+
+func newFailoverClient(primary TwinMakerClient, secondary TwinMakerClient, secondaryRegion string) TwinMakerClient {
+	return &failoverClient{
+		TwinMakerClient: primary,
+		secondary:       secondary,
+		secondaryRegion: secondaryRegion,
+	}
+}
+
+type failoverKey struct{}
+
+// withFailoverTracking returns a context that records when a read failed over
+func withFailoverTracking(ctx context.Context) (context.Context, *atomic.Bool) {
+	used := &atomic.Bool{}
+	return context.WithValue(ctx, failoverKey{}, used), used
+}
+
+// isUnreachable is true for connection errors and server side failures, not for client errors
+func isUnreachable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode() >= 500
+	}
+	var aErr awserr.Error
+	if errors.As(err, &aErr) {
+		return aErr.Code() == request.ErrCodeRequestError || aErr.Code() == request.ErrCodeResponseTimeout
+	}
+	return false
+}
+
+func withFailover[T any](ctx context.Context, c *failoverClient, call func(TwinMakerClient) (T, error)) (T, error) {
+	v, err := call(c.TwinMakerClient)
+	if !isUnreachable(ctx, err) {
+		return v, err
+	}
+
+	loggerFromContext(ctx).Warn("primary region unreachable, retrying in secondary region", "region", c.secondaryRegion, "error", err)
+	if used, ok := ctx.Value(failoverKey{}).(*atomic.Bool); ok {
+		used.Store(true)
+	}
+	return call(c.secondary)
+}
+
+func (c *failoverClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.ListWorkspacesOutput, error) {
+		return client.ListWorkspaces(ctx, query)
+	})
+}
+
+func (c *failoverClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.GetWorkspaceOutput, error) {
+		return client.GetWorkspace(ctx, query)
+	})
+}
+
+func (c *failoverClient) ListScenes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListScenesOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.ListScenesOutput, error) {
+		return client.ListScenes(ctx, query)
+	})
+}
+
+func (c *failoverClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.ListEntitiesOutput, error) {
+		return client.ListEntities(ctx, query)
+	})
+}
+
+func (c *failoverClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.ListComponentTypesOutput, error) {
+		return client.ListComponentTypes(ctx, query)
+	})
+}
+
+func (c *failoverClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.GetComponentTypeOutput, error) {
+		return client.GetComponentType(ctx, query)
+	})
+}
+
+func (c *failoverClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.GetEntityOutput, error) {
+		return client.GetEntity(ctx, query)
+	})
+}
+
+func (c *failoverClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.GetPropertyValueOutput, error) {
+		return client.GetPropertyValue(ctx, query)
+	})
+}
+
+func (c *failoverClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+		return client.GetPropertyValueHistory(ctx, query)
+	})
+}
+
+func (c *failoverClient) ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (*ExecuteQueryOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*ExecuteQueryOutput, error) {
+		return client.ExecuteQuery(ctx, query)
+	})
+}
+
+func (c *failoverClient) GetScene(ctx context.Context, workspaceId string, sceneId string) (*iottwinmaker.GetSceneOutput, error) {
+	return withFailover(ctx, c, func(client TwinMakerClient) (*iottwinmaker.GetSceneOutput, error) {
+		return client.GetScene(ctx, workspaceId, sceneId)
+	})
+}
@@ -0,0 +1,61 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+type unreachableMockClient struct {
+	*twinMakerMockClient
+	err error
+}
+
+func (c *unreachableMockClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	return nil, c.err
+}
+
+func TestFailoverClient(t *testing.T) {
+	secondary, err := NewTwinMakerMockClient("list-workspaces")
+	require.NoError(t, err)
+	settings := models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace", SecondaryRegion: "us-west-2"}
+	query := models.TwinMakerQuery{QueryType: models.QueryTypeListWorkspace}
+
+	t.Run("connection errors fail over with a notice", func(t *testing.T) {
+		primary := &unreachableMockClient{
+			twinMakerMockClient: &twinMakerMockClient{},
+			err:                 awserr.New(request.ErrCodeRequestError, "send request failed", nil),
+		}
+		ds := NewDatasourceWithClient(settings, newFailoverClient(primary, secondary, "us-west-2"))
+
+		res := ds.Query(context.Background(), query)
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+		require.Equal(t, []data.Notice{{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "primary region unreachable, results were read from us-west-2",
+		}}, res.Frames[0].Meta.Notices)
+	})
+
+	t.Run("server errors fail over", func(t *testing.T) {
+		err := awserr.NewRequestFailure(awserr.New(iottwinmaker.ErrCodeInternalServerException, "internal", nil), 500, "id")
+		require.True(t, isUnreachable(context.Background(), err))
+	})
+
+	t.Run("client errors do not fail over", func(t *testing.T) {
+		primary := &unreachableMockClient{
+			twinMakerMockClient: &twinMakerMockClient{},
+			err:                 awserr.NewRequestFailure(awserr.New(iottwinmaker.ErrCodeAccessDeniedException, "denied", nil), 403, "id"),
+		}
+		ds := NewDatasourceWithClient(settings, newFailoverClient(primary, secondary, "us-west-2"))
+
+		res := ds.Query(context.Background(), query)
+		require.Error(t, res.Error)
+	})
+}
@@ -0,0 +1,120 @@
+package twinmaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// the request rate never drops below this, in requests per second
+	minRequestRate = 1
+	// after a throttle the rate grows back to twice its limit over this period, then the
+	// requests are no longer limited
+	rateRecoveryPeriod = 30 * time.Second
+)
+
+// RateLimiter is a token bucket shared by all requests of a datasource instance, so panels and
+// roles slow down together instead of each retrying on its own. Requests are not limited until a
+// throttle: the rate is then half the rate requests were sent at, and grows linearly until
+// rateRecoveryPeriod passes without another throttle. Each throttle halves it again, a burst of
+// throttles within backoffInterval counts once.
+type RateLimiter struct {
+	mu sync.Mutex
+	// rate after the last throttle, 0 while requests are not limited
+	limit     float64
+	throttled time.Time
+	tokens    float64
+	refilled  time.Time
+
+	// requests sent in the current and the previous second, the rate before any throttle
+	window   time.Time
+	sent     int
+	lastSent int
+}
+
+// NewRateLimiter is the limiter of a datasource instance, it is passed to the clients of all its
+// roles as they call the same account
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{}
+}
+
+// Rate is the current requests per second, 0 while requests are not limited
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate(time.Now())
+}
+
+func (l *RateLimiter) rate(now time.Time) float64 {
+	if l.limit == 0 {
+		return 0
+	}
+	elapsed := now.Sub(l.throttled)
+	if elapsed >= rateRecoveryPeriod {
+		l.limit = 0
+		log.DefaultLogger.Debug("request rate no longer limited")
+		return 0
+	}
+	return l.limit * (1 + float64(elapsed)/float64(rateRecoveryPeriod))
+}
+
+// count keeps the number of requests sent per second
+func (l *RateLimiter) count(now time.Time) {
+	switch elapsed := now.Sub(l.window); {
+	case elapsed >= 2*time.Second:
+		l.window, l.sent, l.lastSent = now, 0, 0
+	case elapsed >= time.Second:
+		l.window, l.sent, l.lastSent = l.window.Add(time.Second), 0, l.sent
+	}
+	l.sent++
+}
+
+// reserve takes a token for a request and returns how long the request has to wait for it
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count(now)
+
+	rate := l.rate(now)
+	if rate == 0 {
+		return 0
+	}
+	// at most a second of requests is sent at once
+	l.tokens += now.Sub(l.refilled).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.refilled = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / rate * float64(time.Second))
+}
+
+// throttle halves the request rate after a throttling response
+func (l *RateLimiter) throttle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.throttled) < backoffInterval {
+		return
+	}
+
+	rate := l.rate(now)
+	if rate == 0 {
+		rate = float64(l.lastSent)
+		if rate < float64(l.sent) {
+			rate = float64(l.sent)
+		}
+		l.tokens = 0
+		l.refilled = now
+	}
+	l.limit = rate / 2
+	if l.limit < minRequestRate {
+		l.limit = minRequestRate
+	}
+	l.throttled = now
+	log.DefaultLogger.Debug("request rate limited after a throttle", "rate", l.limit)
+}
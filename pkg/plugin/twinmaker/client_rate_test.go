@@ -0,0 +1,66 @@
+package twinmaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter()
+	now := time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)
+
+	// not limited before a throttle
+	for i := 0; i < 40; i++ {
+		require.Zero(t, l.reserve(now.Add(time.Duration(i)*25*time.Millisecond)))
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 40; i++ {
+		require.Zero(t, l.reserve(now.Add(time.Duration(i)*25*time.Millisecond)))
+	}
+
+	// half the 40 requests per second that were sent
+	l.throttle(now)
+	require.Equal(t, 20.0, l.limit)
+	require.Equal(t, 50*time.Millisecond, l.reserve(now))
+	require.Equal(t, 100*time.Millisecond, l.reserve(now))
+	require.Zero(t, l.reserve(now.Add(time.Second)))
+
+	// a burst of throttles counts once, the next one halves the rate again
+	l.throttle(now.Add(100 * time.Millisecond))
+	require.Equal(t, 20.0, l.limit)
+	l.throttle(now.Add(backoffInterval))
+	require.InDelta(t, 10.0, l.limit, 1)
+
+	// the rate grows back, then requests are no longer limited
+	throttled := l.throttled
+	l.mu.Lock()
+	require.InDelta(t, 1.5*l.limit, l.rate(throttled.Add(rateRecoveryPeriod/2)), 0.01)
+	require.Zero(t, l.rate(throttled.Add(rateRecoveryPeriod)))
+	l.mu.Unlock()
+	require.Zero(t, l.Rate())
+}
+
+func TestRateLimiterMinimum(t *testing.T) {
+	l := NewRateLimiter()
+	now := time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)
+	l.reserve(now)
+	l.throttle(now)
+	require.Equal(t, float64(minRequestRate), l.limit)
+}
+
+func TestDatasourceRate(t *testing.T) {
+	// the roles of a datasource share the limiter
+	rate := NewRateLimiter()
+	primary := newThrottle(models.TwinMakerDataSourceSetting{UID: "rate-test"}, rate)
+	viewer := newThrottle(models.TwinMakerDataSourceSetting{UID: "rate-test", AWSDatasourceSettings: awsds.AWSDatasourceSettings{AssumeRoleARN: "viewer"}}, rate)
+	require.Same(t, primary.rate, viewer.rate)
+
+	// an instance replacing another after a settings change starts over
+	other := newThrottle(models.TwinMakerDataSourceSetting{UID: "rate-test"}, NewRateLimiter())
+	require.NotSame(t, primary.rate, other.rate)
+	require.NotSame(t, newThrottle(models.TwinMakerDataSourceSetting{}, nil).rate, newThrottle(models.TwinMakerDataSourceSetting{}, nil).rate)
+}
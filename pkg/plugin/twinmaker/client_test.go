@@ -3,12 +3,18 @@ package twinmaker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -26,7 +32,7 @@ func TestFetchAWSData(t *testing.T) {
 				AssumeRoleARN: "arn:aws:iam::166800769179:role/IoTTwinMakerDashboardRole-8cf9aa9e",
 				Region:        "us-east-1",
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 
 		WorkspaceId := "AlarmWorkspace"
@@ -45,7 +51,7 @@ func TestFetchAWSData(t *testing.T) {
 				SecretKey:    "dummySecretKeyId",
 				SessionToken: "dummySessionToken", // this means creds are already temp
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 
 		WorkspaceId := "GrafanaWorkspace"
@@ -63,7 +69,7 @@ func TestFetchAWSData(t *testing.T) {
 				AssumeRoleARN: "arn:aws:iam::166800769179:role/IoTTwinMakerDashboardRole-8cf9aa9e",
 				Region:        "us-east-1",
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 
 		WorkspaceId := "AlarmWorkspace"
@@ -82,7 +88,7 @@ func TestFetchAWSData(t *testing.T) {
 				AuthType: awsds.AuthTypeDefault,
 				Region:   "us-east-1",
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 
 		w, err := c.ListWorkspaces(context.Background(), models.TwinMakerQuery{})
@@ -195,7 +201,7 @@ func TestFetchAWSData(t *testing.T) {
 				Region:   "us-east-1",
 				Endpoint: "https://gamma.us-east-1.twinmaker.iot.aws.dev",
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 
 		pv, err := c.GetPropertyValue(context.Background(), models.TwinMakerQuery{
@@ -210,6 +216,98 @@ func TestFetchAWSData(t *testing.T) {
 	})
 }
 
+func TestRoleChain(t *testing.T) {
+	sessions := awsds.NewSessionCache()
+	chain := newRoleChain(sessions, awsds.SessionConfig{
+		Settings: awsds.AWSDatasourceSettings{
+			AuthType:  awsds.AuthTypeKeys,
+			AccessKey: "dummyAccessKeyId",
+			SecretKey: "dummySecretKeyId",
+			Region:    "us-east-1",
+		},
+	}, "arn:aws:iam::123456789012:role/IoTTwinMakerDashboardRole")
+
+	first, err := chain.GetSession()
+	require.NoError(t, err)
+	second, err := chain.GetSession()
+	require.NoError(t, err)
+
+	// the chained session is reused while the base session is cached
+	require.Same(t, first, second)
+	base, err := sessions.GetSession(chain.base)
+	require.NoError(t, err)
+	require.NotSame(t, base.Config.Credentials, first.Config.Credentials)
+}
+
+func TestSessionTokenExternalId(t *testing.T) {
+	var input *sts.AssumeRoleInput
+	tokenService := func() (*sts.STS, error) {
+		svc := sts.New(session.Must(session.NewSession(&aws.Config{
+			Region:      aws.String("us-east-1"),
+			Credentials: credentials.NewStaticCredentials("dummyAccessKeyId", "dummySecretKeyId", ""),
+		})))
+		// capture the request instead of sending it
+		svc.Handlers.Send.Clear()
+		svc.Handlers.Send.PushBack(func(r *request.Request) {
+			input = r.Params.(*sts.AssumeRoleInput)
+			r.Error = errors.New("not sent")
+		})
+		return svc, nil
+	}
+
+	c := &twinMakerClient{
+		tokenRoleWriter: "arn:aws:iam::123456789012:role/IoTTwinMakerWriterRole",
+		tokenService:    tokenService,
+	}
+	_, err := c.GetWriteSessionToken(context.Background(), time.Hour, "AlarmWorkspace")
+	require.Error(t, err)
+	require.Nil(t, input.ExternalId)
+
+	c.externalId = "grafana-external-id"
+	_, err = c.GetWriteSessionToken(context.Background(), time.Hour, "AlarmWorkspace")
+	require.Error(t, err)
+	require.Equal(t, "grafana-external-id", aws.StringValue(input.ExternalId))
+	require.Equal(t, c.tokenRoleWriter, aws.StringValue(input.RoleArn))
+}
+
+func TestLookupWorkspaceEventsPages(t *testing.T) {
+	pages := map[string]*cloudtrail.LookupEventsOutput{
+		"":   {Events: []*cloudtrail.Event{{EventId: aws.String("e1")}, {EventId: aws.String("e2")}}, NextToken: aws.String("p2")},
+		"p2": {Events: []*cloudtrail.Event{{EventId: aws.String("e3")}}, NextToken: aws.String("p3")},
+		"p3": {Events: []*cloudtrail.Event{{EventId: aws.String("e4")}}},
+	}
+	calls := 0
+	c := &twinMakerClient{
+		cloudTrailService: func() (*cloudtrail.CloudTrail, error) {
+			svc := cloudtrail.New(session.Must(session.NewSession(&aws.Config{
+				Region:      aws.String("us-east-1"),
+				Credentials: credentials.NewStaticCredentials("dummyAccessKeyId", "dummySecretKeyId", ""),
+			})))
+			// serve the page of the token instead of sending the request
+			svc.Handlers.Send.Clear()
+			svc.Handlers.ValidateResponse.Clear()
+			svc.Handlers.UnmarshalMeta.Clear()
+			svc.Handlers.Unmarshal.Clear()
+			svc.Handlers.Send.PushBack(func(r *request.Request) {
+				calls++
+				token := aws.StringValue(r.Params.(*cloudtrail.LookupEventsInput).NextToken)
+				*r.Data.(*cloudtrail.LookupEventsOutput) = *pages[token]
+			})
+			return svc, nil
+		},
+	}
+
+	events, err := c.LookupWorkspaceEvents(context.Background(), models.TwinMakerQuery{})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	ids := []string{}
+	for _, e := range events.Events {
+		ids = append(ids, aws.StringValue(e.EventId))
+	}
+	require.Equal(t, []string{"e1", "e2", "e3", "e4"}, ids)
+	require.Nil(t, events.NextToken)
+}
+
 // This will write the results to local json file
 //
 //nolint:golint,unused
@@ -0,0 +1,160 @@
+package twinmaker
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// maxRetryAfter caps the delay requested by a throttling response
+const maxRetryAfter = 30 * time.Second
+
+// throttle is shared by the AWS services of a client. After a throttling response all requests
+// wait for the delay the service asked for, instead of each retrying on its own schedule.
+type throttle struct {
+	mu    sync.Mutex
+	until time.Time
+
+	// slows down all requests of the datasource after throttles, nil when off
+	rate *RateLimiter
+
+	// retry settings of the datasource, zero values are the SDK defaults
+	retries   int // negative for no retries
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// newThrottle uses a limiter of its own when rate is nil
+func newThrottle(settings models.TwinMakerDataSourceSetting, rate *RateLimiter) *throttle {
+	if rate == nil {
+		rate = NewRateLimiter()
+	}
+	base, max := settings.RetryDelays()
+	return &throttle{rate: rate, retries: settings.MaxRetries, baseDelay: base, maxDelay: max}
+}
+
+// backoff pauses requests for at least d
+func (t *throttle) backoff(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// delay is how long a request has to wait, with some jitter so waiting requests do not all
+// start at the same time
+func (t *throttle) delay() time.Duration {
+	t.mu.Lock()
+	d := time.Until(t.until)
+	t.mu.Unlock()
+	if d <= 0 {
+		return 0
+	}
+	spread := d / 10
+	if spread > time.Second {
+		spread = time.Second
+	}
+	if spread > 0 {
+		d += time.Duration(rand.Int63n(int64(spread)))
+	}
+	return d
+}
+
+// wait is a Sign handler that holds each attempt while the service is throttling and until the
+// rate limiter allows it, so the signature is not older than the request
+func (t *throttle) wait(r *request.Request) {
+	d := t.delay()
+	if t.rate != nil {
+		d += t.rate.reserve(time.Now())
+	}
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+		r.Error = r.Context().Err()
+	}
+}
+
+// config is the service config with the throttle aware retryer
+func (t *throttle) config() *aws.Config {
+	retries := client.DefaultRetryerMaxNumRetries
+	if t.retries > 0 {
+		retries = t.retries
+	} else if t.retries < 0 {
+		retries = 0
+	}
+	return request.WithRetryer(aws.NewConfig(), throttleRetryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    retries,
+			MinRetryDelay:    t.baseDelay,
+			MinThrottleDelay: t.baseDelay,
+			MaxRetryDelay:    t.maxDelay,
+			MaxThrottleDelay: t.maxDelay,
+		},
+		throttle: t,
+	})
+}
+
+// throttleRetryer retries throttled requests after the Retry-After delay of the response, the
+// exponential backoff of the default retryer is used for everything else
+type throttleRetryer struct {
+	client.DefaultRetryer
+	throttle *throttle
+}
+
+func (r throttleRetryer) RetryRules(req *request.Request) time.Duration {
+	if !req.IsErrorThrottle() {
+		return r.DefaultRetryer.RetryRules(req)
+	}
+	if r.throttle.rate != nil {
+		r.throttle.rate.throttle(time.Now())
+	}
+	delay, ok := retryAfter(req.HTTPResponse, time.Now())
+	if !ok {
+		delay = r.DefaultRetryer.RetryRules(req)
+	}
+	if max := r.throttle.maxDelay; max > 0 && delay > max {
+		delay = max
+	}
+	r.throttle.backoff(delay)
+	// the shared wait in the Sign handler adds the jitter
+	return 0
+}
+
+// retryAfter parses the Retry-After header, either seconds or an HTTP date
+func retryAfter(rsp *http.Response, now time.Time) (time.Duration, bool) {
+	if rsp == nil {
+		return 0, false
+	}
+	v := rsp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if seconds, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
@@ -0,0 +1,131 @@
+package twinmaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	rsp := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{v}}}
+	}
+
+	d, ok := retryAfter(rsp("3"), now)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, d)
+
+	d, ok = retryAfter(rsp(now.Add(5*time.Second).Format(http.TimeFormat)), now)
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, d)
+
+	d, ok = retryAfter(rsp("3600"), now)
+	require.True(t, ok)
+	require.Equal(t, maxRetryAfter, d)
+
+	_, ok = retryAfter(rsp("soon"), now)
+	require.False(t, ok)
+	_, ok = retryAfter(&http.Response{Header: http.Header{}}, now)
+	require.False(t, ok)
+	_, ok = retryAfter(nil, now)
+	require.False(t, ok)
+}
+
+func TestThrottleRetry(t *testing.T) {
+	var calls int32
+	var throttled int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.CompareAndSwapInt32(&throttled, 0, 1) {
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("X-Amzn-Errortype", "ThrottlingException")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message":"Rate exceeded"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"workspaceSummaries":[]}`))
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+
+		DisableEndpointHostPrefix: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	th := &throttle{}
+	svc := iottwinmaker.New(sess, th.config())
+	svc.Handlers.Sign.PushFront(th.wait)
+
+	// the first call is throttled, the second one waits for the same window instead of hitting the service
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			_, errs[i] = svc.ListWorkspaces(&iottwinmaker.ListWorkspacesInput{})
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetrySettings(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "30")
+		w.Header().Set("X-Amzn-Errortype", "ThrottlingException")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"message":"Rate exceeded"}`))
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+
+		DisableEndpointHostPrefix: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	list := func(th *throttle) error {
+		atomic.StoreInt32(&calls, 0)
+		svc := iottwinmaker.New(sess, th.config())
+		svc.Handlers.Sign.PushFront(th.wait)
+		_, err := svc.ListWorkspaces(&iottwinmaker.ListWorkspacesInput{})
+		return err
+	}
+
+	// the maximum delay caps the Retry-After of the response
+	start := time.Now()
+	require.Error(t, list(newThrottle(models.TwinMakerDataSourceSetting{MaxRetries: 2, RetryBaseDelayMs: 1, RetryMaxDelayMs: 10}, nil)))
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	require.Error(t, list(newThrottle(models.TwinMakerDataSourceSetting{MaxRetries: -1}, nil)))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,54 @@
+package twinmaker
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// maxComponentHistorySeries bounds the series of a component type history response, each one
+// needs its own entity lookup
+const maxComponentHistorySeries = 250
+
+// validateComponentTypeHistory checks that the history of the queried properties can be read
+// across the entities of the component type. Only concrete types bound to a connector have history
+// without an entity, the results carry the externalId the entity is looked up with. Properties the
+// type defines as not time series have no history.
+func validateComponentTypeHistory(ct *iottwinmaker.GetComponentTypeOutput, query models.TwinMakerQuery) error {
+	id := query.ComponentTypeId
+	if aws.BoolValue(ct.IsAbstract) {
+		return fmt.Errorf("component type %s is abstract, query a component type that extends it", id)
+	}
+
+	externalId := false
+	for _, definition := range ct.PropertyDefinitions {
+		if definition != nil && aws.BoolValue(definition.IsExternalId) {
+			externalId = true
+		}
+	}
+	if !externalId {
+		return fmt.Errorf("component type %s has no externalId property, query the history of its entities instead", id)
+	}
+
+	for _, p := range query.Properties {
+		if p == nil {
+			continue
+		}
+		definition := ct.PropertyDefinitions[*p]
+		if definition != nil && definition.IsTimeSeries != nil && !*definition.IsTimeSeries {
+			return fmt.Errorf("property %s of component type %s is not a time series property", *p, id)
+		}
+	}
+	return nil
+}
+
+// checkComponentHistoryFanOut fails responses that would need more than maxComponentHistorySeries
+// entity lookups
+func checkComponentHistoryFanOut(result *iottwinmaker.GetPropertyValueHistoryOutput, query models.TwinMakerQuery) error {
+	if n := len(result.PropertyValues); n > maxComponentHistorySeries {
+		return fmt.Errorf("component type %s returned %d series, more than the limit of %d: narrow the query with a property filter or query the entities", query.ComponentTypeId, n, maxComponentHistorySeries)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package twinmaker
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateComponentTypeHistory(t *testing.T) {
+	query := models.TwinMakerQuery{
+		ComponentTypeId: "com.example.alarm",
+		Properties:      []*string{aws.String("alarm_status")},
+	}
+	componentType := func(abstract bool, definitions map[string]*iottwinmaker.PropertyDefinitionResponse) *iottwinmaker.GetComponentTypeOutput {
+		return &iottwinmaker.GetComponentTypeOutput{IsAbstract: aws.Bool(abstract), PropertyDefinitions: definitions}
+	}
+
+	require.NoError(t, validateComponentTypeHistory(componentType(false, map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_key":    {IsExternalId: aws.Bool(true), IsTimeSeries: aws.Bool(false)},
+		"alarm_status": {IsExternalId: aws.Bool(false), IsTimeSeries: aws.Bool(true)},
+	}), query))
+
+	err := validateComponentTypeHistory(componentType(true, map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_key": {IsExternalId: aws.Bool(true)},
+	}), query)
+	require.EqualError(t, err, "component type com.example.alarm is abstract, query a component type that extends it")
+
+	err = validateComponentTypeHistory(componentType(false, map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_status": {IsTimeSeries: aws.Bool(true)},
+	}), query)
+	require.EqualError(t, err, "component type com.example.alarm has no externalId property, query the history of its entities instead")
+
+	err = validateComponentTypeHistory(componentType(false, map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_key":    {IsExternalId: aws.Bool(true)},
+		"alarm_status": {IsTimeSeries: aws.Bool(false)},
+	}), query)
+	require.EqualError(t, err, "property alarm_status of component type com.example.alarm is not a time series property")
+}
+
+func TestComponentHistoryFanOut(t *testing.T) {
+	query := models.TwinMakerQuery{ComponentTypeId: "com.example.alarm"}
+	result := &iottwinmaker.GetPropertyValueHistoryOutput{}
+	for i := 0; i < maxComponentHistorySeries; i++ {
+		result.PropertyValues = append(result.PropertyValues, &iottwinmaker.PropertyValueHistory{})
+	}
+	require.NoError(t, checkComponentHistoryFanOut(result, query))
+
+	result.PropertyValues = append(result.PropertyValues, &iottwinmaker.PropertyValueHistory{})
+	require.EqualError(t, checkComponentHistoryFanOut(result, query),
+		"component type com.example.alarm returned 251 series, more than the limit of 250: narrow the query with a property filter or query the entities")
+}
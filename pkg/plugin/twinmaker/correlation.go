@@ -0,0 +1,31 @@
+package twinmaker
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+type correlationKey struct{}
+
+// WithCorrelationId returns a context whose logs are tagged with the frontend correlation id
+func WithCorrelationId(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationId returns the correlation id set with WithCorrelationId
+func CorrelationId(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// loggerFromContext is the default logger, with the correlation id when the context has one
+func loggerFromContext(ctx context.Context) log.Logger {
+	if id := CorrelationId(ctx); id != "" {
+		return log.DefaultLogger.With("correlationId", id)
+	}
+	return log.DefaultLogger
+}
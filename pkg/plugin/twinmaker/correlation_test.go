@@ -0,0 +1,30 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationId(t *testing.T) {
+	client := &pagedEntitiesMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+
+	res := ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListEntities, CorrelationId: "abc-123"})
+	require.NoError(t, res.Error)
+	meta := res.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta)
+	require.Equal(t, "abc-123", meta.CorrelationId)
+	require.Equal(t, "page-1", meta.NextToken)
+
+	res = ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListEntities})
+	require.NoError(t, res.Error)
+	require.Empty(t, res.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta).CorrelationId)
+
+	res = ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListEntities, Append: true, NextToken: "?", CorrelationId: "abc-123"})
+	require.EqualError(t, res.Error, "invalid nextToken for append query (correlation id abc-123)")
+
+	require.Equal(t, "abc-123", CorrelationId(WithCorrelationId(context.Background(), "abc-123")))
+	require.Empty(t, CorrelationId(context.Background()))
+}
@@ -0,0 +1,149 @@
+package twinmaker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// a gap is a time between two values of more than summaryGapFactor sample intervals
+const summaryGapFactor = 3
+
+// qualities counted by the summary, in column order
+var summaryQualities = []string{"GOOD", "UNCERTAIN", "BAD"}
+
+type seriesSummary struct {
+	name      string
+	count     int64
+	nulls     int64
+	first     *time.Time
+	last      *time.Time
+	gaps      int64
+	qualities map[string]int64
+}
+
+// appendDataSummary adds the summary frame with one row per time series of the response: the rows,
+// null values, first and last time and the detected gaps. Responses with a quality field also get
+// the rows per quality.
+func appendDataSummary(res *backend.DataResponse) {
+	summaries := []seriesSummary{}
+	withQuality := false
+	for _, frame := range res.Frames {
+		summary, ok := summarizeFrame(frame)
+		if !ok {
+			continue
+		}
+		withQuality = withQuality || summary.qualities != nil
+		summaries = append(summaries, summary)
+	}
+	if len(summaries) == 0 {
+		return
+	}
+
+	fields := newTwinMakerFrameBuilder(len(summaries))
+	series := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(summaries)), "series")
+	count := fields.add(data.NewFieldFromFieldType(data.FieldTypeInt64, len(summaries)), "count")
+	nulls := fields.add(data.NewFieldFromFieldType(data.FieldTypeInt64, len(summaries)), "nulls")
+	first := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableTime, len(summaries)), "first")
+	last := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableTime, len(summaries)), "last")
+	gaps := fields.add(data.NewFieldFromFieldType(data.FieldTypeInt64, len(summaries)), "gaps")
+	var qualities []*data.Field
+	if withQuality {
+		for _, q := range summaryQualities {
+			qualities = append(qualities, fields.add(data.NewFieldFromFieldType(data.FieldTypeInt64, len(summaries)), strings.ToLower(q)))
+		}
+	}
+	for i, s := range summaries {
+		series.Set(i, s.name)
+		count.Set(i, s.count)
+		nulls.Set(i, s.nulls)
+		first.Set(i, s.first)
+		last.Set(i, s.last)
+		gaps.Set(i, s.gaps)
+		for q, f := range qualities {
+			f.Set(i, s.qualities[summaryQualities[q]])
+		}
+	}
+
+	frame := fields.ToFrame("summary", nil)
+	frame.Meta.PreferredVisualization = data.VisTypeTable
+	res.Frames = append(res.Frames, frame)
+}
+
+// summarizeFrame is false for frames without a time field
+func summarizeFrame(frame *data.Frame) (seriesSummary, bool) {
+	summary := seriesSummary{name: frame.Name, count: int64(frame.Rows())}
+	var times []time.Time
+	timeField := -1
+	for i, field := range frame.Fields {
+		if t := field.Type(); t == data.FieldTypeTime || t == data.FieldTypeNullableTime {
+			if timeField < 0 {
+				timeField = i
+				for row := 0; row < field.Len(); row++ {
+					if t, ok := field.ConcreteAt(row); ok {
+						times = append(times, t.(time.Time))
+					}
+				}
+			}
+			continue
+		}
+		if strings.EqualFold(field.Name, "quality") && field.Type().NullableType() == data.FieldTypeNullableString {
+			summary.qualities = map[string]int64{}
+			for row := 0; row < field.Len(); row++ {
+				if q, ok := field.ConcreteAt(row); ok {
+					summary.qualities[strings.ToUpper(q.(string))]++
+				}
+			}
+			continue
+		}
+		if summary.name == "" {
+			summary.name = field.Name
+			if field.Config != nil && field.Config.DisplayName != "" {
+				summary.name = field.Config.DisplayName
+			}
+		}
+		for row := 0; row < field.Len(); row++ {
+			if _, ok := field.ConcreteAt(row); !ok {
+				summary.nulls++
+			}
+		}
+	}
+	if timeField < 0 {
+		return summary, false
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	if len(times) > 0 {
+		summary.first = &times[0]
+		summary.last = &times[len(times)-1]
+	}
+	summary.gaps = countGaps(times)
+	return summary, true
+}
+
+// countGaps counts the times between sorted values longer than summaryGapFactor times the median
+func countGaps(times []time.Time) int64 {
+	intervals := make([]time.Duration, 0, len(times))
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d > 0 {
+			intervals = append(intervals, d)
+		}
+	}
+	if len(intervals) < 2 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, intervals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	var gaps int64
+	for _, d := range intervals {
+		if d > summaryGapFactor*median {
+			gaps++
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,72 @@
+package twinmaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendDataSummary(t *testing.T) {
+	start := time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)
+	at := func(minutes ...int) []*time.Time {
+		times := []*time.Time{}
+		for _, m := range minutes {
+			ts := start.Add(time.Duration(m) * time.Minute)
+			times = append(times, &ts)
+		}
+		return times
+	}
+	value := func(v float64) *float64 { return &v }
+	quality := func(q string) *string { return &q }
+
+	// one value per minute with a 10 minute outage
+	rpm := data.NewFrame("RPM",
+		data.NewField("time", nil, at(0, 1, 2, 3, 13, 14)),
+		data.NewField("RPM", nil, []*float64{value(1), nil, value(3), value(4), nil, value(6)}),
+	)
+	status := data.NewFrame("status",
+		data.NewField("time", nil, at(5, 0)),
+		data.NewField("status", nil, []*string{quality("ACTIVE"), quality("NORMAL")}),
+		data.NewField("quality", nil, []*string{quality("GOOD"), quality("bad")}),
+	)
+	entities := data.NewFrame("entities", data.NewField("entityId", nil, []string{"Mixer_0"}))
+
+	res := backend.DataResponse{Frames: data.Frames{rpm, status, entities}}
+	appendDataSummary(&res)
+	require.Len(t, res.Frames, 4)
+	summary := res.Frames[3]
+	require.Equal(t, "summary", summary.Name)
+	require.Equal(t, 2, summary.Rows())
+
+	row := func(i int) map[string]interface{} {
+		values := map[string]interface{}{}
+		for _, f := range summary.Fields {
+			values[f.Name] = f.At(i)
+		}
+		return values
+	}
+	require.Equal(t, map[string]interface{}{
+		"series":    "RPM",
+		"count":     int64(6),
+		"nulls":     int64(2),
+		"first":     at(0)[0],
+		"last":      at(14)[0],
+		"gaps":      int64(1),
+		"good":      int64(0),
+		"uncertain": int64(0),
+		"bad":       int64(0),
+	}, row(0))
+	require.Equal(t, at(0)[0], row(1)["first"])
+	require.Equal(t, int64(1), row(1)["good"])
+	require.Equal(t, int64(1), row(1)["bad"])
+	require.Equal(t, int64(0), row(1)["nulls"])
+
+	t.Run("no summary without time series", func(t *testing.T) {
+		res := backend.DataResponse{Frames: data.Frames{entities}}
+		appendDataSummary(&res)
+		require.Len(t, res.Frames, 1)
+	})
+}
@@ -0,0 +1,372 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultCacheTTL is how long entity, component type and workspace metadata is cached
+const DefaultCacheTTL = 30 * time.Minute
+
+// Datasource is the TwinMaker query engine without the Grafana plugin wiring (instance management,
+// resource routing, streaming). Other backend plugins can embed it to run TwinMaker queries:
+//
+//	ds, err := twinmaker.NewDatasource(settings)
+//	if err != nil {
+//		return err
+//	}
+//	res := ds.Query(ctx, models.TwinMakerQuery{QueryType: models.QueryTypeEntityHistory, ...})
+type Datasource struct {
+	Settings models.TwinMakerDataSourceSetting
+
+	// Client is the uncached AWS client
+	Client TwinMakerClient
+	// Handler converts cached client results into data frames
+	Handler TwinMakerHandler
+	// Viewer runs the queries of anonymous users with the viewer role, nil unless configured
+	Viewer TwinMakerHandler
+	// Resources serves the resource (non-query) calls, results are cached as a whole
+	Resources TwinMakerResources
+	// Watchlist polls only while Watchlist.Run is active, otherwise it is fetched on the first query
+	Watchlist *Watchlist
+	// Audit records the writes, nil unless a writer role is configured
+	Audit *AuditLog
+	// Favorites are the entity properties saved per user for query authoring
+	Favorites *Favorites
+
+	// the cached client of Handler, see WarmCache
+	cached TwinMakerClient
+
+	// alarm counts of AlarmSummaries by workspace and role
+	alarmSummaries *cache.Cache
+	// externalId resolution snapshots of pinned queries by workspace, role and time
+	resolutionSnapshots *cache.Cache
+	// the snapshot time of each pinned query, by workspace, role and query
+	resolutionPins *cache.Cache
+	// data plane limiters of the entity shards, nil unless sharding is configured
+	shardLimiters []*adaptiveLimiter
+
+	// the metadata cache file, nil unless configured
+	store     *metadataStore
+	redaction *redactor
+
+	// the uncached client of Viewer for its resources, nil unless configured
+	viewerClient TwinMakerClient
+
+	// resources of the orgs bound to other workspaces and of the viewer role, created on first use
+	orgMu        sync.Mutex
+	orgResources map[orgResourcesKey]TwinMakerResources
+
+	// handlers of the query roles, created on first use
+	rolesMu       sync.Mutex
+	roles         map[string]TwinMakerHandler
+	newRoleClient func(roleArn string) (TwinMakerClient, error)
+}
+
+// NewDatasource creates the AWS clients for the settings and wires up caching
+func NewDatasource(settings models.TwinMakerDataSourceSetting) (*Datasource, error) {
+	// the clients of all roles share the request rate of the instance
+	rate := NewRateLimiter()
+	c, err := NewTwinMakerClient(settings, rate)
+	if err != nil {
+		return nil, err
+	}
+	viewer, err := NewViewerClient(settings, rate)
+	if err != nil {
+		return nil, err
+	}
+	ds := NewDatasourceWithClient(settings, c)
+	if viewer != nil {
+		ds.SetViewerClient(viewer)
+	}
+	ds.SetQueryRoleClients(func(roleArn string) (TwinMakerClient, error) {
+		return NewQueryRoleClient(settings, roleArn, rate)
+	})
+	return ds, nil
+}
+
+// NewDatasourceWithClient is NewDatasource with an existing client, useful for testing
+func NewDatasourceWithClient(settings models.TwinMakerDataSourceSetting, c TwinMakerClient) *Datasource {
+	var audit *AuditLog
+	if settings.AssumeRoleARNWriter != "" {
+		audit = NewAuditLog(c, DefaultAuditLogSize, settings.AuditS3Location)
+		c = newAuditingClient(c, audit)
+	}
+
+	// Caching the frame results -- not twinmaker raw results
+	cached := &cachingClient{
+		client:       c,
+		generalCache: *cache.New(DefaultCacheTTL, DefaultCacheTTL*2),
+	}
+	// Applied while results are converted, so the client cache keeps the raw values. Only the
+	// metadata file is written redacted.
+	redaction := newRedactor(settings.RedactionRules)
+	if settings.MetadataCacheFile != "" {
+		bucket := settings.UID
+		if bucket == "" {
+			bucket = "default"
+		}
+		store, err := openMetadataStore(settings.MetadataCacheFile, bucket, DefaultCacheTTL)
+		if err != nil {
+			log.DefaultLogger.Warn("metadata cache file not used", "path", settings.MetadataCacheFile, "error", err)
+		} else {
+			store.redaction = redaction
+			cached.store = store
+		}
+	}
+
+	watchlist := NewWatchlist(c, settings.WorkspaceID, DefaultWatchlistInterval)
+	watchlist.redaction = redaction
+	if audit != nil {
+		audit.redaction = redaction
+	}
+	handler := newRoleHandler(cached, settings, redaction)
+	resources := newTwinMakerResource(c, settings.WorkspaceID, redaction)
+	resources.alarmModelSync = settings.AlarmModelSync
+
+	return &Datasource{
+		Settings: settings,
+		Client:   c,
+		Handler:  handler,
+
+		// Since the whole result is cached, this does not use the cached client
+		Resources: NewCachingResource(resources, DefaultCacheTTL),
+
+		Watchlist: watchlist,
+		Audit:     audit,
+		Favorites: newFavorites(cached.store),
+
+		cached:              cached,
+		alarmSummaries:      newAlarmSummaryCache(),
+		resolutionSnapshots: newResolutionSnapshotCache(),
+		resolutionPins:      newResolutionSnapshotCache(),
+		shardLimiters:       newShardLimiters(settings.EntityShards),
+		store:               cached.store,
+		redaction:           redaction,
+	}
+}
+
+// Close releases the metadata cache file
+func (ds *Datasource) Close() error {
+	if ds.store == nil {
+		return nil
+	}
+	return ds.store.close()
+}
+
+// Query runs a single query against the configured workspace
+func (ds *Datasource) Query(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
+	ctx = WithCorrelationId(ctx, query.CorrelationId)
+	ctx = WithFeature(ctx, query.QueryType)
+	ctx, span := tracing.DefaultTracer().Start(ctx, "twinmaker.Query", trace.WithAttributes(
+		attribute.String("queryType", query.QueryType),
+		attribute.String("correlationId", query.CorrelationId),
+	))
+	defer span.End()
+	start := time.Now()
+
+	if query.Preview {
+		var cancel context.CancelFunc
+		ctx, cancel = withPreview(ctx)
+		defer cancel()
+		query = previewQuery(query)
+	}
+
+	var severity expr
+	if query.SeverityExpression != "" {
+		var err error
+		if severity, err = parseExpression(query.SeverityExpression); err != nil {
+			return backend.DataResponse{Error: fmt.Errorf("invalid severity expression: %w", err)}
+		}
+	}
+
+	ctx = withHistoryCalls(ctx, ds.Settings.MaxHistoryCalls)
+	snapshot, snapshotNotices := ds.resolutionSnapshot(ctx, query)
+	ctx = withResolutionSnapshot(ctx, snapshot)
+
+	ctx, failover := withFailoverTracking(ctx)
+	var res backend.DataResponse
+	if query.Append {
+		res = ds.queryAppend(ctx, query)
+	} else {
+		res = ds.query(ctx, query)
+	}
+	if query.Preview {
+		truncatePreview(&res)
+	}
+	if failover.Load() && len(res.Frames) > 0 {
+		res.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("primary region unreachable, results were read from %s", ds.Settings.SecondaryRegion),
+		})
+	}
+
+	if res.Error != nil {
+		span.RecordError(res.Error)
+		span.SetStatus(codes.Error, res.Error.Error())
+	}
+	if severity != nil && res.Error == nil {
+		addSeverity(&res, severity, start)
+	}
+	stripUnsafeURIs(&res)
+	// the summary describes the rows that are shown
+	limitResponseSize(&res, ds.Settings.MaxResponseBytes)
+	// continued pages would replace the summary of the first page with one of their own rows
+	if query.DataSummary && query.NextToken == "" && res.Error == nil {
+		appendDataSummary(&res)
+	}
+	if query.FieldNaming != "" {
+		setFieldNaming(&res, query.FieldNaming)
+	}
+	if snapshot != nil {
+		snapshot.save(ds.store)
+		setResolutionSnapshot(&res, snapshot, snapshotNotices)
+	}
+	if query.TimeFieldName != "" || query.TimeString {
+		formatTimeFields(&res, query.TimeFieldName, query.TimeString)
+	}
+	if query.CorrelationId != "" {
+		loggerFromContext(ctx).Debug("query", "queryType", query.QueryType, "duration", time.Since(start), "error", res.Error)
+		setCorrelationId(&res, query.CorrelationId)
+	}
+	return res
+}
+
+// setCorrelationId echoes the id in the frame meta, or in the error when there are no frames
+func setCorrelationId(res *backend.DataResponse, id string) {
+	if res.Error != nil && len(res.Frames) == 0 {
+		res.Error = fmt.Errorf("%w (correlation id %s)", res.Error, id)
+		return
+	}
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		meta, _ := frame.Meta.Custom.(models.TwinMakerCustomMeta)
+		meta.CorrelationId = id
+		frame.Meta.Custom = meta
+	}
+}
+
+func (ds *Datasource) query(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	// set the default datasource WorkspaceId if missing in the query
+	if query.WorkspaceId == "" {
+		query.WorkspaceId = ds.workspaceFor(ctx)
+	}
+	if query.PageSize == 0 {
+		query.PageSize = ds.Settings.DefaultPageSize
+	}
+
+	if query.QueryType != models.QueryTypeListWorkspace && !ds.Settings.WorkspaceAllowedForOrg(OrgFrom(ctx), query.WorkspaceId) {
+		response.Error = fmt.Errorf("workspace %s is not allowed in datasource configuration", query.WorkspaceId)
+		return response
+	}
+
+	if err := validFieldNaming(query.FieldNaming); err != nil {
+		response.Error = err
+		return response
+	}
+
+	handler, err := ds.queryHandler(ctx, query)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if shards := entityShards(query.EntityIds, len(ds.shardLimiters)); shards != nil {
+		switch query.QueryType {
+		case models.QueryTypeGetPropertyValue:
+			return ds.shardEntities(ctx, query, shards, handler.GetPropertyValue)
+		case models.QueryTypeEntityHistory:
+			return ds.shardEntities(ctx, query, shards, handler.GetEntityHistory)
+		}
+	}
+	switch query.QueryType {
+	case models.QueryTypeListWorkspace:
+		return ds.orgWorkspaces(ctx, handler.ListWorkspaces(ctx, query))
+	case models.QueryTypeListScenes:
+		return handler.ListScenes(ctx, query)
+	case models.QueryTypeSceneTags:
+		return handler.GetSceneTags(ctx, query)
+	case models.QueryTypeListEntities:
+		return handler.ListEntities(ctx, query)
+	case models.QueryTypeGetEntity:
+		return handler.GetEntity(ctx, query)
+	case models.QueryTypeDefinitionDiff:
+		return handler.GetDefinitionDiff(ctx, query)
+	case models.QueryTypeGetPropertyValue:
+		return handler.GetPropertyValue(ctx, query)
+	case models.QueryTypeEntityHistory:
+		return handler.GetEntityHistory(ctx, query)
+	case models.QueryTypeComponentHistory:
+		return handler.GetComponentHistory(ctx, query)
+	case models.QueryTypeInterpolated:
+		return handler.GetInterpolatedHistory(ctx, query)
+	case models.QueryTypeGetAlarms:
+		return handler.GetAlarms(ctx, query)
+	case models.QueryTypeWorkspaceEvents:
+		if !ds.Settings.WorkspaceEvents {
+			response.Error = fmt.Errorf("workspace events are not enabled in datasource configuration")
+			return response
+		}
+		return handler.GetWorkspaceEvents(ctx, query)
+	case models.QueryTypeAlarmAnnotations:
+		return handler.GetAlarmAnnotations(ctx, query)
+	case models.QueryTypeAlarmVideo:
+		return handler.GetAlarmVideo(ctx, query)
+	case models.QueryTypePropertyHeatmap:
+		return handler.GetPropertyHeatmap(ctx, query)
+	case models.QueryTypeDataAvailability:
+		return handler.GetDataAvailability(ctx, query)
+	case models.QueryTypeStateChanges:
+		return handler.GetStateChanges(ctx, query)
+	case models.QueryTypePropertyHistogram:
+		return handler.GetPropertyHistogram(ctx, query)
+	case models.QueryTypeBaselineCompare:
+		return handler.GetBaselineCompare(ctx, query)
+	case models.QueryTypeExecuteQuery:
+		return handler.ExecuteQuery(ctx, query)
+	case models.QueryTypeWatchlist:
+		// the values are polled with the primary role, anonymous requests do not see them
+		if usesViewerRole(ctx) {
+			response.Error = fmt.Errorf("the watchlist is not available to anonymous users")
+			return response
+		}
+		// the datasource workspace is polled, orgs bound to another one do not see it
+		if ds.workspaceFor(ctx) != ds.Settings.WorkspaceID {
+			response.Error = fmt.Errorf("the watchlist only covers workspace %s", ds.Settings.WorkspaceID)
+			return response
+		}
+		return ds.Watchlist.Query(ctx)
+	case models.QueryTypeAuditLog:
+		// the log records the writes of all orgs with the logins of their users
+		if usesViewerRole(ctx) {
+			response.Error = fmt.Errorf("the audit log is not available to anonymous users")
+			return response
+		}
+		if _, bound := ds.Settings.OrgWorkspaces[OrgFrom(ctx)]; bound {
+			response.Error = fmt.Errorf("the audit log is not available to orgs bound to a workspace")
+			return response
+		}
+		if ds.Audit == nil {
+			response.Error = fmt.Errorf("write operations are not enabled in datasource configuration")
+			return response
+		}
+		return ds.Audit.Query(query)
+	}
+
+	return response
+}
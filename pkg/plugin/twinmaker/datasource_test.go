@@ -0,0 +1,162 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatasourceQuery(t *testing.T) {
+	client, err := NewTwinMakerMockClient("list-workspaces")
+	require.NoError(t, err)
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+
+	t.Run("dispatches by query type", func(t *testing.T) {
+		client.path = "list-workspaces"
+		res := ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListWorkspace})
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+	})
+
+	t.Run("workspace events must be enabled", func(t *testing.T) {
+		client.path = "workspace-events"
+		res := ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeWorkspaceEvents})
+		require.Error(t, res.Error)
+	})
+
+	t.Run("workspaces outside the allowed list are rejected", func(t *testing.T) {
+		client.path = "list-scenes"
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace", AllowedWorkspaces: []string{"plant-a"}}, client)
+		res := ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListScenes, WorkspaceId: "plant-b"})
+		require.Error(t, res.Error)
+		res = ds.Query(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListScenes, WorkspaceId: "plant-a"})
+		require.NoError(t, res.Error)
+	})
+
+	t.Run("anonymous requests use the viewer role", func(t *testing.T) {
+		client.path = "list-workspaces"
+		viewer := &countingMockClient{twinMakerMockClient: &twinMakerMockClient{path: "list-workspaces"}}
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+		query := models.TwinMakerQuery{QueryType: models.QueryTypeListWorkspace}
+
+		// without a viewer role anonymous requests keep the primary role
+		res := ds.Query(WithViewerRole(context.Background()), query)
+		require.NoError(t, res.Error)
+
+		ds.SetViewerClient(viewer)
+		res = ds.Query(context.Background(), query)
+		require.NoError(t, res.Error)
+		require.Equal(t, 0, viewer.calls)
+		res = ds.Query(WithViewerRole(context.Background()), query)
+		require.NoError(t, res.Error)
+		require.Equal(t, 1, viewer.calls)
+	})
+
+	t.Run("queries use allowed query roles", func(t *testing.T) {
+		role := &countingMockClient{twinMakerMockClient: &twinMakerMockClient{path: "list-workspaces"}}
+		created := []string{}
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{
+			WorkspaceID:   "AlarmWorkspace",
+			QueryRoleARNs: []string{"arn:aws:iam::123456789012:role/maintenance"},
+		}, client)
+		query := models.TwinMakerQuery{QueryType: models.QueryTypeListWorkspace, RoleArn: "arn:aws:iam::123456789012:role/maintenance"}
+
+		// roles need a client factory
+		res := ds.Query(context.Background(), query)
+		require.Error(t, res.Error)
+
+		ds.SetQueryRoleClients(func(roleArn string) (TwinMakerClient, error) {
+			created = append(created, roleArn)
+			return role, nil
+		})
+		for i := 0; i < 2; i++ {
+			res = ds.Query(context.Background(), query)
+			require.NoError(t, res.Error)
+		}
+		require.Equal(t, []string{query.RoleArn}, created)
+		require.Equal(t, 1, role.calls)
+
+		// resolutions of the query roles are invalidated with the others
+		handler, err := ds.queryHandler(context.Background(), query)
+		require.NoError(t, err)
+		handler.(*twinMakerHandler).externalIds.set("AlarmWorkspace", "com.example.alarm", "alarm-1", externalIdResolution{})
+		require.Equal(t, 1, ds.InvalidateExternalIds("AlarmWorkspace"))
+
+		query.RoleArn = "arn:aws:iam::123456789012:role/admin"
+		res = ds.Query(context.Background(), query)
+		require.Error(t, res.Error)
+
+		// the viewer role of anonymous requests is kept
+		ds.SetViewerClient(&countingMockClient{twinMakerMockClient: &twinMakerMockClient{path: "list-workspaces"}})
+		res = ds.Query(WithViewerRole(context.Background()), query)
+		require.NoError(t, res.Error)
+	})
+}
+
+type previewMockClient struct {
+	*twinMakerMockClient
+	maxResults []int
+}
+
+func (c *previewMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	c.maxResults = append(c.maxResults, query.MaxResults)
+	// more values than asked for, the preview still cuts them
+	values := make([]*iottwinmaker.PropertyValue, 0, 150)
+	for i := 0; i < 150; i++ {
+		values = append(values, &iottwinmaker.PropertyValue{
+			Time:  aws.String(time.Unix(int64(i), 0).UTC().Format(time.RFC3339)),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(float64(i))},
+		})
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		NextToken: aws.String("next"),
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String("Mixer_0"),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Temperature"),
+			},
+			Values: values,
+		}},
+	}, nil
+}
+
+func TestDatasourcePreview(t *testing.T) {
+	client := &previewMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, client)
+
+	res := ds.Query(context.Background(), models.TwinMakerQuery{
+		QueryType: models.QueryTypeEntityHistory,
+		EntityId:  "Mixer_0",
+		Preview:   true,
+	})
+	require.NoError(t, res.Error)
+	require.Equal(t, []int{previewMaxRows}, client.maxResults)
+	require.Len(t, res.Frames, 1)
+	require.Equal(t, previewMaxRows, res.Frames[0].Rows())
+	require.Equal(t, data.NoticeSeverityInfo, res.Frames[0].Meta.Notices[0].Severity)
+
+	ctx, cancel := withPreview(context.Background())
+	defer cancel()
+	// previews stop after their page, not because the deadline is near
+	require.False(t, deadlineNear(ctx, 0))
+	require.False(t, previewPageLoaded(ctx, 0))
+	require.True(t, previewPageLoaded(ctx, 1))
+	require.False(t, previewPageLoaded(context.Background(), 1))
+}
+
+type countingMockClient struct {
+	*twinMakerMockClient
+	calls int
+}
+
+func (c *countingMockClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	c.calls++
+	return c.twinMakerMockClient.ListWorkspaces(ctx, query)
+}
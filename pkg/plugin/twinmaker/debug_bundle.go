@@ -0,0 +1,222 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// maxDebugCalls bounds the AWS calls recorded in a debug bundle, long paged queries keep the first
+const maxDebugCalls = 50
+
+// recordingClient records the calls of the twin model and data reads of a query for a debug
+// bundle, the other calls pass through unrecorded. The outputs are recorded as redacted copies,
+// the query converts the originals.
+type recordingClient struct {
+	TwinMakerClient
+	redaction *redactor
+
+	mu        sync.Mutex
+	calls     []models.DebugCall
+	truncated bool
+}
+
+func record[T any](c *recordingClient, method string, query models.TwinMakerQuery, fn func() (T, error)) (T, error) {
+	start := time.Now()
+	out, err := fn()
+	call := models.DebugCall{
+		Method:     method,
+		Query:      query,
+		TimeRange:  models.DebugTimeRange{From: query.TimeRange.From, To: query.TimeRange.To},
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		call.Error = err.Error()
+	} else {
+		call.Output = c.redactedCopy(out)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.calls) >= maxDebugCalls {
+		c.truncated = true
+	} else {
+		c.calls = append(c.calls, call)
+	}
+	return out, err
+}
+
+// redactedCopy copies the output through JSON, so redacting it does not change the query result
+func (c *recordingClient) redactedCopy(out interface{}) interface{} {
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil
+	}
+	switch out.(type) {
+	case *iottwinmaker.GetEntityOutput:
+		cp := &iottwinmaker.GetEntityOutput{}
+		if json.Unmarshal(b, cp) != nil {
+			return nil
+		}
+		c.redaction.entity(cp)
+		return cp
+	case *iottwinmaker.GetPropertyValueOutput:
+		cp := &iottwinmaker.GetPropertyValueOutput{}
+		if json.Unmarshal(b, cp) != nil {
+			return nil
+		}
+		for name, latest := range cp.PropertyValues {
+			if latest != nil && latest.PropertyValue != nil {
+				latest.PropertyValue, _ = c.redaction.value(name, latest.PropertyValue)
+			}
+		}
+		return cp
+	case *iottwinmaker.GetPropertyValueHistoryOutput:
+		cp := &iottwinmaker.GetPropertyValueHistoryOutput{}
+		if json.Unmarshal(b, cp) != nil {
+			return nil
+		}
+		for _, history := range cp.PropertyValues {
+			if history == nil || history.EntityPropertyReference == nil || history.EntityPropertyReference.PropertyName == nil {
+				continue
+			}
+			name := *history.EntityPropertyReference.PropertyName
+			values := []*iottwinmaker.PropertyValue{}
+			for _, v := range history.Values {
+				if v == nil {
+					continue
+				}
+				if value, ok := c.redaction.value(name, v.Value); ok {
+					v.Value = value
+					values = append(values, v)
+				}
+			}
+			history.Values = values
+		}
+		return cp
+	case *iottwinmaker.GetComponentTypeOutput:
+		cp := &iottwinmaker.GetComponentTypeOutput{}
+		if json.Unmarshal(b, cp) != nil {
+			return nil
+		}
+		// the definitions are kept like for entities, only their default values are redacted
+		for name, definition := range cp.PropertyDefinitions {
+			if definition != nil && definition.DefaultValue != nil {
+				definition.DefaultValue, _ = c.redaction.value(name, definition.DefaultValue)
+			}
+		}
+		return cp
+	case *ExecuteQueryOutput:
+		cp := &ExecuteQueryOutput{}
+		if json.Unmarshal(b, cp) != nil {
+			return nil
+		}
+		for _, row := range cp.Rows {
+			for i, v := range row.RowData {
+				row.RowData[i] = redactedDocument(v, c.redaction)
+			}
+		}
+		return cp
+	}
+	// the other outputs hold no property values
+	return json.RawMessage(b)
+}
+
+func (c *recordingClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	return record(c, "iottwinmaker:ListWorkspaces", query, func() (*iottwinmaker.ListWorkspacesOutput, error) {
+		return c.TwinMakerClient.ListWorkspaces(ctx, query)
+	})
+}
+
+func (c *recordingClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
+	return record(c, "iottwinmaker:GetWorkspace", query, func() (*iottwinmaker.GetWorkspaceOutput, error) {
+		return c.TwinMakerClient.GetWorkspace(ctx, query)
+	})
+}
+
+func (c *recordingClient) ListScenes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListScenesOutput, error) {
+	return record(c, "iottwinmaker:ListScenes", query, func() (*iottwinmaker.ListScenesOutput, error) {
+		return c.TwinMakerClient.ListScenes(ctx, query)
+	})
+}
+
+func (c *recordingClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return record(c, "iottwinmaker:ListEntities", query, func() (*iottwinmaker.ListEntitiesOutput, error) {
+		return c.TwinMakerClient.ListEntities(ctx, query)
+	})
+}
+
+func (c *recordingClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	return record(c, "iottwinmaker:ListComponentTypes", query, func() (*iottwinmaker.ListComponentTypesOutput, error) {
+		return c.TwinMakerClient.ListComponentTypes(ctx, query)
+	})
+}
+
+func (c *recordingClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return record(c, "iottwinmaker:GetComponentType", query, func() (*iottwinmaker.GetComponentTypeOutput, error) {
+		return c.TwinMakerClient.GetComponentType(ctx, query)
+	})
+}
+
+func (c *recordingClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return record(c, "iottwinmaker:GetEntity", query, func() (*iottwinmaker.GetEntityOutput, error) {
+		return c.TwinMakerClient.GetEntity(ctx, query)
+	})
+}
+
+func (c *recordingClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	return record(c, "iottwinmaker:GetPropertyValue", query, func() (*iottwinmaker.GetPropertyValueOutput, error) {
+		return c.TwinMakerClient.GetPropertyValue(ctx, query)
+	})
+}
+
+func (c *recordingClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	return record(c, "iottwinmaker:GetPropertyValueHistory", query, func() (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+		return c.TwinMakerClient.GetPropertyValueHistory(ctx, query)
+	})
+}
+
+func (c *recordingClient) ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (*ExecuteQueryOutput, error) {
+	return record(c, "iottwinmaker:ExecuteQuery", query, func() (*ExecuteQueryOutput, error) {
+		return c.TwinMakerClient.ExecuteQuery(ctx, query)
+	})
+}
+
+type debugHandlerKey struct{}
+
+// DebugBundle runs the query once more without the caches and records its AWS calls and result.
+// The query runs with the primary role, query roles and the viewer role are not replayed.
+func (ds *Datasource) DebugBundle(ctx context.Context, query models.TwinMakerQuery) (models.DebugBundle, error) {
+	rec := &recordingClient{TwinMakerClient: ds.Client, redaction: ds.redaction}
+	// without an externalId cache, so the lookups are part of the recording
+	handler := newTwinMakerHandler(rec, ds.redaction)
+	ctx = context.WithValue(ctx, debugHandlerKey{}, TwinMakerHandler(handler))
+
+	bundle := models.DebugBundle{
+		Created:   time.Now().UTC(),
+		Query:     query,
+		TimeRange: models.DebugTimeRange{From: query.TimeRange.From, To: query.TimeRange.To},
+	}
+	res := ds.Query(ctx, query)
+	rsp, err := json.Marshal(backend.QueryDataResponse{Responses: backend.Responses{"A": res}})
+	if err != nil {
+		return bundle, err
+	}
+	bundle.Response = rsp
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	bundle.Calls = append([]models.DebugCall{}, rec.calls...)
+	bundle.CallsTruncated = rec.truncated
+	return bundle, nil
+}
+
+func debugHandlerFrom(ctx context.Context) TwinMakerHandler {
+	handler, _ := ctx.Value(debugHandlerKey{}).(TwinMakerHandler)
+	return handler
+}
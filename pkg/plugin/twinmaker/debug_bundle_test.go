@@ -0,0 +1,80 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugBundle(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{
+		WorkspaceID:    "w",
+		RedactionRules: []models.RedactionRule{{Pattern: "Running"}},
+	}, &booleanHistoryMockClient{twinMakerMockClient: &twinMakerMockClient{}})
+	query := models.TwinMakerQuery{
+		QueryType:     models.QueryTypeEntityHistory,
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Running")},
+	}
+
+	bundle, err := ds.DebugBundle(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, "Mixer_0", bundle.Query.EntityId)
+	require.False(t, bundle.CallsTruncated)
+
+	var history *models.DebugCall
+	for i, call := range bundle.Calls {
+		if call.Method == "iottwinmaker:GetPropertyValueHistory" {
+			history = &bundle.Calls[i]
+		}
+	}
+	require.NotNil(t, history)
+	require.Equal(t, "Mixer_0", history.Query.EntityId)
+	// the recorded output is redacted like the result
+	output := history.Output.(*iottwinmaker.GetPropertyValueHistoryOutput)
+	require.Len(t, output.PropertyValues[0].Values, 2)
+	for _, v := range output.PropertyValues[0].Values {
+		require.Equal(t, redactedValue, aws.StringValue(v.Value.StringValue))
+		require.Nil(t, v.Value.BooleanValue)
+	}
+	require.Contains(t, string(bundle.Response), `"results":{"A":`)
+	require.Contains(t, string(bundle.Response), redactedValue)
+
+	// regular queries do not record
+	require.Nil(t, debugHandlerFrom(context.Background()))
+}
+
+func TestDebugBundleRedactedCopy(t *testing.T) {
+	c := &recordingClient{redaction: newRedactor([]models.RedactionRule{
+		{Pattern: "serial", Action: models.RedactionMask},
+		{Pattern: "secret", Action: models.RedactionDrop},
+	})}
+
+	componentType := &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"serial": {DefaultValue: &iottwinmaker.DataValue{StringValue: aws.String("SN-123")}},
+		"secret": {DefaultValue: &iottwinmaker.DataValue{StringValue: aws.String("hunter2")}},
+	}}
+	cp := c.redactedCopy(componentType).(*iottwinmaker.GetComponentTypeOutput)
+	require.Equal(t, redactedValue, aws.StringValue(cp.PropertyDefinitions["serial"].DefaultValue.StringValue))
+	require.NotNil(t, cp.PropertyDefinitions["secret"])
+	require.Nil(t, cp.PropertyDefinitions["secret"].DefaultValue)
+	require.Equal(t, "SN-123", aws.StringValue(componentType.PropertyDefinitions["serial"].DefaultValue.StringValue))
+
+	result := &ExecuteQueryOutput{Rows: []ExecuteQueryRow{{RowData: []interface{}{map[string]interface{}{
+		"properties": []interface{}{
+			map[string]interface{}{"propertyName": "serial", "propertyValue": "SN-123"},
+			map[string]interface{}{"propertyName": "secret", "propertyValue": "hunter2"},
+		},
+	}}}}}
+	b, err := json.Marshal(c.redactedCopy(result))
+	require.NoError(t, err)
+	require.Contains(t, string(b), `{"propertyName":"serial","propertyValue":"***"}`)
+	require.NotContains(t, string(b), "SN-123")
+	require.NotContains(t, string(b), "hunter2")
+}
@@ -0,0 +1,124 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// status of a property in a definition diff
+const (
+	definitionMissing      = "missing"       // defined by the component type, not on the component
+	definitionExtra        = "extra"         // on the component, not defined by the component type
+	definitionTypeMismatch = "type mismatch" // on both with different data types
+)
+
+// GetDefinitionDiff compares the properties of an entity component with the property definitions
+// of a component type, the one of the component unless the query sets another. There is a row for
+// each property that is missing, extra or has another data type, properties dropped by the
+// redaction rules are left out.
+func (s *twinMakerHandler) GetDefinitionDiff(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" || query.ComponentName == "" {
+		dr.Error = fmt.Errorf("missing entity or component parameter")
+		return
+	}
+	entity, err := s.client.GetEntity(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+	component, ok := entity.Components[query.ComponentName]
+	if !ok || component == nil {
+		dr.Error = fmt.Errorf("entity %s has no component %s", query.EntityId, query.ComponentName)
+		return
+	}
+	if query.ComponentTypeId == "" {
+		query.ComponentTypeId = aws.StringValue(component.ComponentTypeId)
+	}
+	ct, err := s.client.GetComponentType(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	type propertyDiff struct {
+		name     string
+		status   string
+		expected string
+		actual   string
+	}
+	diffs := []propertyDiff{}
+	for name, definition := range ct.PropertyDefinitions {
+		if s.redaction.action(name) == models.RedactionDrop {
+			continue
+		}
+		expected := ""
+		if definition != nil {
+			expected = dataTypeName(definition.DataType)
+		}
+		property, ok := component.Properties[name]
+		if !ok || property == nil {
+			diffs = append(diffs, propertyDiff{name: name, status: definitionMissing, expected: expected})
+			continue
+		}
+		actual := ""
+		if property.Definition != nil {
+			actual = dataTypeName(property.Definition.DataType)
+		}
+		if actual != expected {
+			diffs = append(diffs, propertyDiff{name: name, status: definitionTypeMismatch, expected: expected, actual: actual})
+		}
+	}
+	for name, property := range component.Properties {
+		if _, ok := ct.PropertyDefinitions[name]; ok || s.redaction.action(name) == models.RedactionDrop {
+			continue
+		}
+		actual := ""
+		if property != nil && property.Definition != nil {
+			actual = dataTypeName(property.Definition.DataType)
+		}
+		diffs = append(diffs, propertyDiff{name: name, status: definitionExtra, actual: actual})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].name < diffs[j].name
+	})
+
+	fields := newTwinMakerFrameBuilder(len(diffs))
+	propertyName := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(diffs)), "propertyName")
+	status := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(diffs)), "status")
+	expected := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(diffs)), "expectedType")
+	actual := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(diffs)), "actualType")
+	for i, diff := range diffs {
+		propertyName.Set(i, diff.name)
+		status.Set(i, diff.status)
+		expected.Set(i, diff.expected)
+		actual.Set(i, diff.actual)
+	}
+	frame := fields.ToFrame(query.ComponentName, nil)
+	if len(diffs) == 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("component %s of entity %s matches component type %s", query.ComponentName, query.EntityId, query.ComponentTypeId),
+		})
+	}
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
+
+// dataTypeName is the data type with its nested types, like LIST<DOUBLE>
+func dataTypeName(dt *iottwinmaker.DataType) string {
+	if dt == nil {
+		return ""
+	}
+	name := aws.StringValue(dt.Type)
+	if dt.NestedType != nil {
+		name += "<" + dataTypeName(dt.NestedType) + ">"
+	}
+	return name
+}
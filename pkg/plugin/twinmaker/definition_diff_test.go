@@ -0,0 +1,88 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type definitionDiffMockClient struct {
+	*twinMakerMockClient
+	componentTypeIds []string
+}
+
+func definitionOf(dataType *iottwinmaker.DataType) *iottwinmaker.PropertyDefinitionResponse {
+	return &iottwinmaker.PropertyDefinitionResponse{DataType: dataType}
+}
+
+func (c *definitionDiffMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{Components: map[string]*iottwinmaker.ComponentResponse{
+		"MixerComponent": {
+			ComponentTypeId: aws.String("com.example.mixer"),
+			Properties: map[string]*iottwinmaker.PropertyResponse{
+				"Temperature": {Definition: definitionOf(&iottwinmaker.DataType{Type: aws.String("DOUBLE")})},
+				"RPM":         {Definition: definitionOf(&iottwinmaker.DataType{Type: aws.String("INTEGER")})},
+				"Readings": {Definition: definitionOf(&iottwinmaker.DataType{
+					Type:       aws.String("LIST"),
+					NestedType: &iottwinmaker.DataType{Type: aws.String("INTEGER")},
+				})},
+				"Operator": {Definition: definitionOf(&iottwinmaker.DataType{Type: aws.String("STRING")})},
+				"Secret":   {Definition: definitionOf(&iottwinmaker.DataType{Type: aws.String("STRING")})},
+			},
+		},
+	}}, nil
+}
+
+func (c *definitionDiffMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	c.componentTypeIds = append(c.componentTypeIds, query.ComponentTypeId)
+	return &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"Temperature": definitionOf(&iottwinmaker.DataType{Type: aws.String("DOUBLE")}),
+		"RPM":         definitionOf(&iottwinmaker.DataType{Type: aws.String("DOUBLE")}),
+		"Readings": definitionOf(&iottwinmaker.DataType{
+			Type:       aws.String("LIST"),
+			NestedType: &iottwinmaker.DataType{Type: aws.String("DOUBLE")},
+		}),
+		"Vibration": definitionOf(&iottwinmaker.DataType{Type: aws.String("DOUBLE")}),
+	}}, nil
+}
+
+func TestGetDefinitionDiff(t *testing.T) {
+	client := &definitionDiffMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	redaction := newRedactor([]models.RedactionRule{{Pattern: "Secret", Action: models.RedactionDrop}})
+	query := models.TwinMakerQuery{
+		QueryType:     models.QueryTypeDefinitionDiff,
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+	}
+
+	dr := newTwinMakerHandler(client, redaction).GetDefinitionDiff(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, []string{"com.example.mixer"}, client.componentTypeIds)
+
+	frame := dr.Frames[0]
+	require.Equal(t, 4, frame.Rows())
+	rows := [][]interface{}{}
+	for i := 0; i < frame.Rows(); i++ {
+		rows = append(rows, frame.RowCopy(i))
+	}
+	require.Equal(t, [][]interface{}{
+		{"Operator", definitionExtra, "", "STRING"},
+		{"RPM", definitionTypeMismatch, "DOUBLE", "INTEGER"},
+		{"Readings", definitionTypeMismatch, "LIST<DOUBLE>", "LIST<INTEGER>"},
+		{"Vibration", definitionMissing, "DOUBLE", ""},
+	}, rows)
+
+	// another component type
+	query.ComponentTypeId = "com.example.mixer.v2"
+	dr = newTwinMakerHandler(client, redaction).GetDefinitionDiff(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, "com.example.mixer.v2", client.componentTypeIds[1])
+
+	query.ComponentName = "PumpComponent"
+	dr = newTwinMakerHandler(client, redaction).GetDefinitionDiff(context.Background(), query)
+	require.EqualError(t, dr.Error, "entity Mixer_0 has no component PumpComponent")
+}
@@ -0,0 +1,160 @@
+package twinmaker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// downsampleInterval is the bucket size that keeps the series of a query within its MaxDataPoints,
+// never shorter than the interval of the panel. Zero when the query does not downsample.
+func downsampleInterval(query models.TwinMakerQuery) time.Duration {
+	if query.Downsample == "" || query.MaxDataPoints <= 0 {
+		return 0
+	}
+	span := query.TimeRange.To.Sub(query.TimeRange.From)
+	size := span / time.Duration(query.MaxDataPoints)
+	if size < query.Interval {
+		size = query.Interval
+	}
+	// whole milliseconds, rounded up to stay within MaxDataPoints
+	return (size + time.Millisecond - 1).Truncate(time.Millisecond)
+}
+
+// downsampleHistory combines the rows of the history frames with more than MaxDataPoints rows per
+// bucket of downsampleInterval, before the response is sent instead of in the panel
+func downsampleHistory(dr *backend.DataResponse, query models.TwinMakerQuery) {
+	size := downsampleInterval(query)
+	if size <= 0 || dr.Error != nil {
+		return
+	}
+	before, after := 0, 0
+	for _, frame := range dr.Frames {
+		rows := frame.Rows()
+		if int64(rows) <= query.MaxDataPoints || !downsampleSeries(frame, size, query.Downsample) {
+			continue
+		}
+		before += rows
+		after += frame.Rows()
+	}
+	if before > 0 {
+		dr.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Downsampled %d rows to %d, the %s of every %s", before, after, query.Downsample, size),
+		})
+	}
+}
+
+// downsampleSeries replaces the rows of a frame with one row per bucket, at the bucket start and in
+// the order of the rows. Numeric fields are aggregated, other fields keep the latest value of the
+// bucket like last. False when the frame has no time field.
+func downsampleSeries(frame *data.Frame, size time.Duration, aggregation models.HeatmapAggregation) bool {
+	rows := frame.Rows()
+	timeIndex := -1
+	for i, field := range frame.Fields {
+		if t := field.Type(); t == data.FieldTypeNullableTime || t == data.FieldTypeTime {
+			timeIndex = i
+			break
+		}
+	}
+	if timeIndex < 0 {
+		return false
+	}
+	timeField := frame.Fields[timeIndex]
+
+	// rows of each bucket, the latest one is kept for last
+	type bucket struct {
+		start  time.Time
+		rows   []int
+		latest int
+	}
+	buckets := map[time.Time]*bucket{}
+	times := make([]time.Time, rows)
+	for i := 0; i < rows; i++ {
+		t, ok := timeField.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		times[i] = t.(time.Time)
+		start := times[i].Truncate(size)
+		b, ok := buckets[start]
+		if !ok {
+			b = &bucket{start: start, latest: i}
+			buckets[start] = b
+		}
+		if times[i].After(times[b.latest]) {
+			b.latest = i
+		}
+		b.rows = append(b.rows, i)
+	}
+	ordered := make([]*bucket, 0, len(buckets))
+	for _, b := range buckets {
+		ordered = append(ordered, b)
+	}
+	descending := rows > 1 && times[0].After(times[rows-1])
+	sort.Slice(ordered, func(i, j int) bool {
+		if descending {
+			return ordered[i].start.After(ordered[j].start)
+		}
+		return ordered[i].start.Before(ordered[j].start)
+	})
+
+	for i, field := range frame.Fields {
+		if field.Len() != rows {
+			continue
+		}
+		var sampled *data.Field
+		switch {
+		case i == timeIndex:
+			sampled = data.NewFieldFromFieldType(field.Type(), len(ordered))
+			for j, b := range ordered {
+				if field.Type() == data.FieldTypeNullableTime {
+					start := b.start
+					sampled.Set(j, &start)
+				} else {
+					sampled.Set(j, b.start)
+				}
+			}
+			config := data.FieldConfig{}
+			if field.Config != nil {
+				config = *field.Config
+			}
+			config.Interval = float64(size.Milliseconds())
+			sampled.Config = &config
+		case aggregation == models.HeatmapLast || (!field.Type().Numeric() && aggregation != models.HeatmapCount):
+			sampled = data.NewFieldFromFieldType(field.Type(), len(ordered))
+			for j, b := range ordered {
+				sampled.Set(j, field.CopyAt(b.latest))
+			}
+			sampled.Config = field.Config
+		default:
+			sampled = data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, len(ordered))
+			for j, b := range ordered {
+				values := &heatmapBucket{}
+				for _, row := range b.rows {
+					if aggregation == models.HeatmapCount {
+						if _, ok := field.ConcreteAt(row); ok {
+							values.add(times[row], 0)
+						}
+						continue
+					}
+					if v, err := field.NullableFloatAt(row); err == nil && v != nil {
+						values.add(times[row], *v)
+					}
+				}
+				sampled.Set(j, values.value(aggregation))
+			}
+			if aggregation != models.HeatmapCount {
+				sampled.Config = field.Config
+			}
+		}
+		sampled.Name = field.Name
+		sampled.Labels = field.Labels
+		frame.Fields[i] = sampled
+	}
+	return true
+}
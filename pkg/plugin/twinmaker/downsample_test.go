@@ -0,0 +1,74 @@
+package twinmaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+// minuteFrame has a value every minute from start, the values count up from 0
+func minuteFrame(start time.Time, rows int) *data.Frame {
+	times := make([]*time.Time, rows)
+	values := make([]*float64, rows)
+	states := make([]*string, rows)
+	for i := range times {
+		t := start.Add(time.Duration(i) * time.Minute)
+		v := float64(i)
+		s := "RUNNING"
+		if i%2 == 1 {
+			s = "IDLE"
+		}
+		times[i], values[i], states[i] = &t, &v, &s
+	}
+	value := data.NewField("", data.Labels{"propertyName": "Temperature"}, values)
+	return data.NewFrame("", value, data.NewField("time", nil, times), data.NewField("state", nil, states))
+}
+
+func TestDownsampleHistory(t *testing.T) {
+	start := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		Downsample:    models.HeatmapAvg,
+		MaxDataPoints: 10,
+		Interval:      time.Minute,
+		TimeRange:     backend.TimeRange{From: start, To: start.Add(100 * time.Minute)},
+	}
+	require.Equal(t, 10*time.Minute, downsampleInterval(query))
+
+	dr := backend.DataResponse{Frames: data.Frames{minuteFrame(start, 100)}}
+	downsampleHistory(&dr, query)
+	frame := dr.Frames[0]
+	require.Equal(t, 10, frame.Rows())
+	value := frame.Fields[0]
+	require.Equal(t, "Temperature", value.Labels["propertyName"])
+	require.Equal(t, 4.5, *value.At(0).(*float64))
+	require.Equal(t, 94.5, *value.At(9).(*float64))
+	require.Equal(t, start.Add(10*time.Minute), *frame.Fields[1].At(1).(*time.Time))
+	require.Equal(t, float64((10 * time.Minute).Milliseconds()), frame.Fields[1].Config.Interval)
+	// strings keep the latest value of the bucket
+	require.Equal(t, "IDLE", *frame.Fields[2].At(0).(*string))
+	require.Equal(t, "Downsampled 100 rows to 10, the avg of every 10m0s", frame.Meta.Notices[0].Text)
+
+	// the panel interval is the smallest bucket
+	query.Interval = 20 * time.Minute
+	query.Downsample = models.HeatmapMax
+	dr = backend.DataResponse{Frames: data.Frames{minuteFrame(start, 100)}}
+	downsampleHistory(&dr, query)
+	require.Equal(t, 5, dr.Frames[0].Rows())
+	require.Equal(t, 19.0, *dr.Frames[0].Fields[0].At(0).(*float64))
+
+	// series within MaxDataPoints and queries without downsample are unchanged
+	query.MaxDataPoints = 100
+	dr = backend.DataResponse{Frames: data.Frames{minuteFrame(start, 100)}}
+	downsampleHistory(&dr, query)
+	require.Equal(t, 100, dr.Frames[0].Rows())
+	require.Nil(t, dr.Frames[0].Meta)
+
+	query.MaxDataPoints = 10
+	query.Downsample = ""
+	downsampleHistory(&dr, query)
+	require.Equal(t, 100, dr.Frames[0].Rows())
+}
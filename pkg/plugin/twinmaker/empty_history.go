@@ -0,0 +1,101 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// explainEmptyHistory adds a frame with a notice to a history response without rows, saying which
+// step returned nothing. Previews are not explained, the lookups would slow down the editor.
+func (s *twinMakerHandler) explainEmptyHistory(ctx context.Context, query models.TwinMakerQuery, dr *backend.DataResponse) {
+	if dr.Error != nil || len(dr.Frames) > 0 || isPreview(ctx) {
+		return
+	}
+	frame := data.NewFrame("")
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     s.emptyHistoryReason(ctx, query),
+	})
+	dr.Frames = append(dr.Frames, frame)
+}
+
+// emptyHistoryReason checks the entity or component type, the properties, the redaction rules and
+// the property filter in that order. Lookups that fail are skipped.
+func (s *twinMakerHandler) emptyHistoryReason(ctx context.Context, query models.TwinMakerQuery) string {
+	if query.EntityId != "" {
+		entity, err := s.client.GetEntity(ctx, query)
+		switch {
+		case isResourceNotFound(err):
+			return fmt.Sprintf("entity %s not found in workspace %s", query.EntityId, query.WorkspaceId)
+		case err == nil && entity != nil:
+			component, ok := entity.Components[query.ComponentName]
+			if !ok || component == nil {
+				return fmt.Sprintf("entity %s has no component %s", query.EntityId, query.ComponentName)
+			}
+			for _, p := range aws.StringValueSlice(query.Properties) {
+				if _, ok := component.Properties[p]; !ok {
+					return fmt.Sprintf("component %s of entity %s has no property %s", query.ComponentName, query.EntityId, p)
+				}
+			}
+		}
+	} else if query.ComponentTypeId != "" {
+		if ct, err := s.client.GetComponentType(ctx, query); err == nil && ct != nil {
+			for _, p := range aws.StringValueSlice(query.Properties) {
+				if _, ok := ct.PropertyDefinitions[p]; !ok {
+					return fmt.Sprintf("component type %s has no property %s", query.ComponentTypeId, p)
+				}
+			}
+		}
+		lookup := query
+		lookup.MaxResults = 1
+		lookup.NextToken = ""
+		lookup.ListEntitiesFilter = []models.TwinMakerListEntitiesFilter{{ComponentTypeId: query.ComponentTypeId}}
+		if rsp, err := s.client.ListEntitiesPage(ctx, lookup); err == nil && rsp != nil && len(rsp.EntitySummaries) == 0 {
+			return fmt.Sprintf("no entities use component type %s", query.ComponentTypeId)
+		}
+	}
+
+	if len(query.Properties) > 0 {
+		dropped := 0
+		for _, p := range aws.StringValueSlice(query.Properties) {
+			if s.redaction.action(p) == models.RedactionDrop {
+				dropped++
+			}
+		}
+		if dropped == len(query.Properties) {
+			return "all properties are dropped by the datasource redaction rules"
+		}
+	}
+
+	if len(query.PropertyFilter) > 0 {
+		unfiltered := query
+		unfiltered.PropertyFilter = nil
+		unfiltered.MaxResults = 1
+		unfiltered.NextToken = ""
+		if rsp, err := s.client.GetPropertyValueHistory(ctx, unfiltered); err == nil && historyHasValues(rsp) {
+			return "the property filter removed all rows"
+		}
+	}
+
+	return fmt.Sprintf("no data between %s and %s",
+		query.TimeRange.From.UTC().Format(time.RFC3339), query.TimeRange.To.UTC().Format(time.RFC3339))
+}
+
+func historyHasValues(rsp *iottwinmaker.GetPropertyValueHistoryOutput) bool {
+	if rsp == nil {
+		return false
+	}
+	for _, p := range rsp.PropertyValues {
+		if p != nil && len(p.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+// emptyHistoryMockClient has no history, except for unfiltered queries when filtered is set
+type emptyHistoryMockClient struct {
+	*twinMakerMockClient
+	filtered bool
+}
+
+func (c *emptyHistoryMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	if query.EntityId != "Mixer_0" {
+		return nil, awserr.New(iottwinmaker.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &iottwinmaker.GetEntityOutput{Components: map[string]*iottwinmaker.ComponentResponse{
+		"MixerComponent": {Properties: map[string]*iottwinmaker.PropertyResponse{"RPM": {}}},
+	}}, nil
+}
+
+func (c *emptyHistoryMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	history := &iottwinmaker.PropertyValueHistory{EntityPropertyReference: &iottwinmaker.EntityPropertyReference{PropertyName: aws.String("RPM")}}
+	if c.filtered && len(query.PropertyFilter) == 0 {
+		history.Values = []*iottwinmaker.PropertyValue{{
+			Time:  aws.String("2022-04-27T10:00:00Z"),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(1)},
+		}}
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{history}}, nil
+}
+
+func TestExplainEmptyHistory(t *testing.T) {
+	client := &emptyHistoryMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	query := func(entityId, componentName, property string) models.TwinMakerQuery {
+		return models.TwinMakerQuery{
+			WorkspaceId:   "w",
+			EntityId:      entityId,
+			ComponentName: componentName,
+			Properties:    []*string{aws.String(property)},
+			TimeRange: backend.TimeRange{
+				From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+			},
+		}
+	}
+	reason := func(query models.TwinMakerQuery) string {
+		dr := handler.GetEntityHistory(context.Background(), query)
+		require.NoError(t, dr.Error)
+		require.Len(t, dr.Frames, 1)
+		require.Len(t, dr.Frames[0].Meta.Notices, 1)
+		return dr.Frames[0].Meta.Notices[0].Text
+	}
+
+	require.Equal(t, "entity Mixer_1 not found in workspace w", reason(query("Mixer_1", "MixerComponent", "RPM")))
+	require.Equal(t, "entity Mixer_0 has no component Mixer", reason(query("Mixer_0", "Mixer", "RPM")))
+	require.Equal(t, "component MixerComponent of entity Mixer_0 has no property Speed", reason(query("Mixer_0", "MixerComponent", "Speed")))
+	require.Equal(t, "no data between 2022-04-27T00:00:00Z and 2022-04-28T00:00:00Z", reason(query("Mixer_0", "MixerComponent", "RPM")))
+
+	client.filtered = true
+	filtered := query("Mixer_0", "MixerComponent", "RPM")
+	filtered.PropertyFilter = []models.TwinMakerPropertyFilter{{Name: "RPM", Op: ">", Value: models.TwinMakerFilterValue{DoubleValue: aws.Float64(100)}}}
+	require.Equal(t, "the property filter removed all rows", reason(filtered))
+
+	// previews are not explained
+	client.filtered = false
+	ctx, cancel := withPreview(context.Background())
+	defer cancel()
+	dr := handler.GetEntityHistory(ctx, query("Mixer_0", "MixerComponent", "RPM"))
+	require.NoError(t, dr.Error)
+	require.Empty(t, dr.Frames)
+}
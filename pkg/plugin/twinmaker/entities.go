@@ -0,0 +1,71 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxEntityQueries bounds the entities of a multi-entity query loaded at the same time
+const maxEntityQueries = 4
+
+// forEachEntity runs the query for each of its EntityIds concurrently and returns the frames in
+// the order of the entities. An entity that fails becomes a warning, the query only fails when all
+// of them do. The frames have no nextToken since the pages of the entities cannot be continued
+// together, run has to load the whole time range of an entity.
+func forEachEntity(ctx context.Context, query models.TwinMakerQuery, run func(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse) (dr backend.DataResponse) {
+	responses := make([]backend.DataResponse, len(query.EntityIds))
+	slots := make(chan struct{}, maxEntityQueries)
+	var wg sync.WaitGroup
+	for i, id := range query.EntityIds {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			q := query
+			q.EntityId = id
+			q.EntityIds = nil
+			q.NextToken = ""
+			responses[i] = run(ctx, q)
+		}(i, id)
+	}
+	wg.Wait()
+
+	failures := []data.Notice{}
+	for i, rsp := range responses {
+		if rsp.Error != nil {
+			failures = append(failures, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("entity %s: %s", query.EntityIds[i], rsp.Error.Error()),
+			})
+			continue
+		}
+		for _, frame := range rsp.Frames {
+			if frame.Meta != nil {
+				if meta, ok := frame.Meta.Custom.(models.TwinMakerCustomMeta); ok && meta.NextToken != "" {
+					meta.NextToken = ""
+					frame.Meta.Custom = meta
+					frame.AppendNotices(partialNotice(ctx, false))
+				}
+			}
+			dr.Frames = append(dr.Frames, frame)
+		}
+	}
+	if len(failures) == len(responses) {
+		dr.Error = responses[0].Error
+		dr.Frames = nil
+		return
+	}
+	if len(failures) > 0 {
+		if len(dr.Frames) == 0 {
+			dr.Frames = data.Frames{data.NewFrame("")}
+		}
+		dr.Frames[0].AppendNotices(failures...)
+	}
+	return
+}
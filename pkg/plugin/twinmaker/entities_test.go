@@ -0,0 +1,62 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+// entitiesMockClient pages the history of each entity and fails for missing entities
+type entitiesMockClient struct {
+	propertyGroupMockClient
+}
+
+func (c *entitiesMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	if query.EntityId == "missing" {
+		return nil, fmt.Errorf("entity not found")
+	}
+	rsp, err := c.propertyGroupMockClient.GetPropertyValueHistory(ctx, query)
+	if err == nil && query.NextToken == "" {
+		rsp.NextToken = aws.String("page2")
+	}
+	return rsp, err
+}
+
+func TestEntityHistoryEntityIds(t *testing.T) {
+	client := &entitiesMockClient{propertyGroupMockClient{twinMakerMockClient: &twinMakerMockClient{}}}
+	handler := newTwinMakerHandler(client, nil)
+	query := models.TwinMakerQuery{
+		EntityIds:     []string{"Mixer_1", "missing", "Mixer_0"},
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+	}
+
+	dr := handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	// each entity loads both pages
+	require.Equal(t, 4, client.calls)
+
+	// a frame per entity in the order of the query, the missing entity is a warning
+	require.Len(t, dr.Frames, 2)
+	for i, id := range []string{"Mixer_1", "Mixer_0"} {
+		value, _ := dr.Frames[i].FieldByName("Temperature")
+		require.Equal(t, id, value.Labels["entityId"])
+		require.Empty(t, dr.Frames[i].Meta.Custom.(models.TwinMakerCustomMeta).NextToken)
+		require.Equal(t, 2, value.Len())
+	}
+	require.Equal(t, []data.Notice{{
+		Severity: data.NoticeSeverityWarning,
+		Text:     "entity missing: entity not found",
+	}}, dr.Frames[0].Meta.Notices)
+
+	// the query fails when all entities do
+	query.EntityIds = []string{"missing", "missing"}
+	dr = handler.GetEntityHistory(context.Background(), query)
+	require.EqualError(t, dr.Error, "entity not found")
+}
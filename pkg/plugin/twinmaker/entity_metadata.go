@@ -0,0 +1,69 @@
+package twinmaker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultEntityMetadata are the ListEntities columns when the query selects none
+var defaultEntityMetadata = []models.EntityMetadataColumn{
+	models.EntityMetadataDescription,
+	models.EntityMetadataCreated,
+	models.EntityMetadataArn,
+}
+
+// entityMetadataFields are the selected entity metadata columns, unselected ones are nil
+type entityMetadataFields struct {
+	description *data.Field
+	arn         *data.Field
+	created     *data.Field
+	updated     *data.Field
+}
+
+// newEntityMetadataFields adds the columns in the selected order. The description column is named
+// descriptionName since GetEntity rows already have a component description.
+func newEntityMetadataFields(fields *twinMakerFrameBuilder, columns []models.EntityMetadataColumn, descriptionName string) (entityMetadataFields, error) {
+	m := entityMetadataFields{}
+	for _, column := range columns {
+		switch column {
+		case models.EntityMetadataDescription:
+			if m.description == nil {
+				m.description = fields.Description()
+				m.description.Name = descriptionName
+			}
+		case models.EntityMetadataArn:
+			if m.arn == nil {
+				m.arn = fields.ARN()
+			}
+		case models.EntityMetadataCreated:
+			if m.created == nil {
+				m.created = fields.CreationDate()
+			}
+		case models.EntityMetadataUpdated:
+			if m.updated == nil {
+				m.updated = fields.UpdateDate()
+			}
+		default:
+			return m, fmt.Errorf("unknown entity metadata column %q", column)
+		}
+	}
+	return m, nil
+}
+
+func (m entityMetadataFields) set(i int, description *string, arn *string, created *time.Time, updated *time.Time) {
+	if m.description != nil {
+		m.description.Set(i, description)
+	}
+	if m.arn != nil {
+		m.arn.Set(i, arn)
+	}
+	if m.created != nil && created != nil {
+		m.created.Set(i, *created)
+	}
+	if m.updated != nil {
+		m.updated.Set(i, updated)
+	}
+}
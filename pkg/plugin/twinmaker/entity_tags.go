@@ -0,0 +1,108 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// MaxEntityTagBatch is how many entities a single tag request may read or update
+const MaxEntityTagBatch = 100
+
+// entityArn looks up the ARN the tags of an entity are attached to
+func (r *twinMakerResource) entityArn(ctx context.Context, entityId string) (string, error) {
+	if entityId == "" {
+		return "", fmt.Errorf("missing entityId")
+	}
+	entity, err := r.client.GetEntity(ctx, models.TwinMakerQuery{
+		WorkspaceId: r.workspaceId,
+		EntityId:    entityId,
+	})
+	if err != nil {
+		return "", err
+	}
+	if entity == nil || entity.Arn == nil {
+		return "", fmt.Errorf("missing arn for entity %s", entityId)
+	}
+	return *entity.Arn, nil
+}
+
+func (r *twinMakerResource) GetEntityTags(ctx context.Context, entityIds []string) ([]models.EntityTags, error) {
+	if len(entityIds) > MaxEntityTagBatch {
+		return nil, fmt.Errorf("at most %d entities can be read at once", MaxEntityTagBatch)
+	}
+	results := make([]models.EntityTags, 0, len(entityIds))
+	for _, id := range entityIds {
+		result := models.EntityTags{EntityId: id, Tags: map[string]string{}}
+		arn, err := r.entityArn(ctx, id)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Arn = arn
+
+		rsp, err := r.client.ListTagsForResource(ctx, arn)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			for k, v := range rsp.Tags {
+				result.Tags[k] = aws.StringValue(v)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// UpdateEntityTags applies the updates one entity at a time, a failed entity does not stop the others
+func (r *twinMakerResource) UpdateEntityTags(ctx context.Context, updates []models.EntityTagUpdate) (models.EntityTagReport, error) {
+	report := models.EntityTagReport{Updated: []string{}}
+	if len(updates) > MaxEntityTagBatch {
+		return report, fmt.Errorf("at most %d entities can be updated at once", MaxEntityTagBatch)
+	}
+	for _, update := range updates {
+		if err := r.updateEntityTags(ctx, update); err != nil {
+			report.Failed = append(report.Failed, models.EntityTagFailure{
+				EntityId:     update.EntityId,
+				ErrorMessage: err.Error(),
+			})
+			continue
+		}
+		report.Updated = append(report.Updated, update.EntityId)
+	}
+	return report, nil
+}
+
+func (r *twinMakerResource) updateEntityTags(ctx context.Context, update models.EntityTagUpdate) error {
+	if len(update.Set) == 0 && len(update.Remove) == 0 {
+		return fmt.Errorf("no tags to set or remove")
+	}
+	arn, err := r.entityArn(ctx, update.EntityId)
+	if err != nil {
+		return err
+	}
+
+	if len(update.Set) > 0 {
+		_, err := r.client.TagResource(ctx, &iottwinmaker.TagResourceInput{
+			ResourceARN: aws.String(arn),
+			Tags:        aws.StringMap(update.Set),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(update.Remove) > 0 {
+		_, err := r.client.UntagResource(ctx, &iottwinmaker.UntagResourceInput{
+			ResourceARN: aws.String(arn),
+			TagKeys:     aws.StringSlice(update.Remove),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
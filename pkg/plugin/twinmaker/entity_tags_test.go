@@ -0,0 +1,98 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type entityTagsMockClient struct {
+	*twinMakerMockClient
+	tags    map[string]map[string]*string
+	untagFn func(arn string) error
+}
+
+func (c *entityTagsMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	if query.EntityId == "Missing" {
+		return nil, fmt.Errorf("entity not found")
+	}
+	return &iottwinmaker.GetEntityOutput{
+		EntityId: aws.String(query.EntityId),
+		Arn:      aws.String("arn:aws:iottwinmaker:us-east-1:123456789012:workspace/" + query.WorkspaceId + "/entity/" + query.EntityId),
+	}, nil
+}
+
+func (c *entityTagsMockClient) ListTagsForResource(ctx context.Context, resourceArn string) (*iottwinmaker.ListTagsForResourceOutput, error) {
+	return &iottwinmaker.ListTagsForResourceOutput{Tags: c.tags[resourceArn]}, nil
+}
+
+func (c *entityTagsMockClient) TagResource(ctx context.Context, req *iottwinmaker.TagResourceInput) (*iottwinmaker.TagResourceOutput, error) {
+	arn := *req.ResourceARN
+	if c.tags[arn] == nil {
+		c.tags[arn] = map[string]*string{}
+	}
+	for k, v := range req.Tags {
+		c.tags[arn][k] = v
+	}
+	return &iottwinmaker.TagResourceOutput{}, nil
+}
+
+func (c *entityTagsMockClient) UntagResource(ctx context.Context, req *iottwinmaker.UntagResourceInput) (*iottwinmaker.UntagResourceOutput, error) {
+	if c.untagFn != nil {
+		if err := c.untagFn(*req.ResourceARN); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range req.TagKeys {
+		delete(c.tags[*req.ResourceARN], *k)
+	}
+	return &iottwinmaker.UntagResourceOutput{}, nil
+}
+
+func TestEntityTags(t *testing.T) {
+	mixer0 := "arn:aws:iottwinmaker:us-east-1:123456789012:workspace/w/entity/Mixer_0"
+	client := &entityTagsMockClient{
+		twinMakerMockClient: &twinMakerMockClient{},
+		tags: map[string]map[string]*string{
+			mixer0: {"site": aws.String("plant-1"), "owner": aws.String("ops")},
+		},
+	}
+	res := newTwinMakerResource(client, "w", nil)
+
+	tags, err := res.GetEntityTags(context.Background(), []string{"Mixer_0", "Missing"})
+	require.NoError(t, err)
+	require.Equal(t, []models.EntityTags{
+		{EntityId: "Mixer_0", Arn: mixer0, Tags: map[string]string{"site": "plant-1", "owner": "ops"}},
+		{EntityId: "Missing", Tags: map[string]string{}, Error: "entity not found"},
+	}, tags)
+
+	// removals win over new values, failed entities do not stop the others
+	client.untagFn = func(arn string) error {
+		if arn != mixer0 {
+			return fmt.Errorf("access denied")
+		}
+		return nil
+	}
+	report, err := res.UpdateEntityTags(context.Background(), []models.EntityTagUpdate{
+		{EntityId: "Mixer_0", Set: map[string]string{"site": "plant-2", "line": "3"}, Remove: []string{"owner", "line"}},
+		{EntityId: "Mixer_1", Remove: []string{"owner"}},
+		{EntityId: "Mixer_2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.EntityTagReport{
+		Updated: []string{"Mixer_0"},
+		Failed: []models.EntityTagFailure{
+			{EntityId: "Mixer_1", ErrorMessage: "access denied"},
+			{EntityId: "Mixer_2", ErrorMessage: "no tags to set or remove"},
+		},
+	}, report)
+	require.Equal(t, map[string]*string{"site": aws.String("plant-2")}, client.tags[mixer0])
+
+	_, err = res.UpdateEntityTags(context.Background(), make([]models.EntityTagUpdate, MaxEntityTagBatch+1))
+	require.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// Published list price for TwinMaker unified data access API calls (USD per call, us-east-1).
+// Metadata APIs (list/get entities, component types, workspaces, scenes) and CloudTrail lookups are not charged.
+const dataAccessCallPrice = 1.50 / 1000000
+
+var billableCalls = map[string]bool{
+	"iottwinmaker:GetPropertyValue":        true,
+	"iottwinmaker:GetPropertyValueHistory": true,
+}
+
+// Estimate counts the AWS calls a query will make without running it. History queries read one
+// small page to measure the data density, that probe is included in the estimate.
+func (ds *Datasource) Estimate(ctx context.Context, query models.TwinMakerQuery) (models.QueryEstimate, error) {
+	if query.WorkspaceId == "" {
+		query.WorkspaceId = ds.workspaceFor(ctx)
+	}
+	if query.PageSize == 0 {
+		query.PageSize = ds.Settings.DefaultPageSize
+	}
+
+	estimate := models.QueryEstimate{
+		QueryType: query.QueryType,
+		Calls:     map[string]int{},
+	}
+	add := func(api string, n int) {
+		if n > 0 {
+			estimate.Calls[api] += n
+		}
+	}
+
+	// multi-entity queries run for each entity
+	entities := 1
+	if len(query.EntityIds) > 0 {
+		entities = len(query.EntityIds)
+	}
+
+	switch query.QueryType {
+	case models.QueryTypeListWorkspace:
+		add("iottwinmaker:ListWorkspaces", 1)
+	case models.QueryTypeListScenes:
+		add("iottwinmaker:ListScenes", 1)
+	case models.QueryTypeSceneTags:
+		add("iottwinmaker:GetScene", 1)
+		add("s3:GetObject", 1)
+	case models.QueryTypeListEntities:
+		add("iottwinmaker:ListEntities", 1)
+	case models.QueryTypeGetEntity:
+		add("iottwinmaker:GetEntity", 1)
+	case models.QueryTypeDefinitionDiff:
+		add("iottwinmaker:GetEntity", 1)
+		add("iottwinmaker:GetComponentType", 1)
+	case models.QueryTypeGetPropertyValue:
+		add("iottwinmaker:GetPropertyValue", entities)
+	case models.QueryTypeInterpolated:
+		// one page of interpolated values per maxInterpolatedPageSize intervals of each property
+		samples := int(query.TimeRange.To.Sub(query.TimeRange.From)/interpolationInterval(query)) + 1
+		pages := (samples + maxInterpolatedPageSize - 1) / maxInterpolatedPageSize
+		add("iottwinmaker:GetEntity", 1)
+		add("iotsitewise:DescribeAsset", 1)
+		add("iotsitewise:GetInterpolatedAssetPropertyValues", len(query.Properties)*pages)
+		if len(query.Properties) == 0 {
+			estimate.Notes = append(estimate.Notes, "without selected properties, every SiteWise property of the component adds its pages")
+		}
+	case models.QueryTypeEntityHistory:
+		if query.Aggregate != "" {
+			// one page of aggregates per maxAggregatesPageSize resolution intervals of each property
+			samples := int(query.TimeRange.To.Sub(query.TimeRange.From)/resolutionDuration(query.SiteWiseResolution)) + 1
+			pages := (samples + maxAggregatesPageSize - 1) / maxAggregatesPageSize
+			add("iottwinmaker:GetEntity", entities)
+			add("iotsitewise:DescribeAsset", entities)
+			add("iotsitewise:GetAssetPropertyAggregates", entities*len(query.Properties)*pages)
+			break
+		}
+		// wide queries load each property group, the first group of the first entity is probed
+		// for all of them
+		groups := propertyGroups(query.Properties)
+		probe := query
+		probe.Properties = groups[0]
+		if len(query.EntityIds) > 0 {
+			probe.EntityId = query.EntityIds[0]
+		}
+		pages, _, err := ds.estimateHistoryPages(ctx, probe)
+		if err != nil {
+			return estimate, err
+		}
+		// property hints of the entity component, cached after the first run
+		add("iottwinmaker:GetEntity", entities)
+		add("iottwinmaker:GetPropertyValueHistory", entities*len(groups)*(pages+1))
+	case models.QueryTypeComponentHistory, models.QueryTypePropertyHeatmap:
+		pages, series, err := ds.estimateHistoryPages(ctx, query)
+		if err != nil {
+			return estimate, err
+		}
+		add("iottwinmaker:GetComponentType", 1)
+		add("iottwinmaker:GetPropertyValueHistory", pages+1)
+		// externalId lookups, cached after the first run
+		add("iottwinmaker:ListEntities", series)
+		add("iottwinmaker:GetEntity", series)
+	case models.QueryTypeStateChanges, models.QueryTypePropertyHistogram, models.QueryTypeAlarmAnnotations:
+		pages, series, err := ds.estimateHistoryPages(ctx, query)
+		if err != nil {
+			return estimate, err
+		}
+		add("iottwinmaker:GetPropertyValueHistory", pages+1)
+		// queries without an entity read the component type history
+		if query.EntityId == "" {
+			add("iottwinmaker:GetComponentType", 1)
+			add("iottwinmaker:ListEntities", series)
+			add("iottwinmaker:GetEntity", series)
+		}
+	case models.QueryTypeAlarmVideo:
+		pages, _, err := ds.estimateHistoryPages(ctx, query)
+		if err != nil {
+			return estimate, err
+		}
+		add("iottwinmaker:GetEntity", 1)
+		add("iottwinmaker:GetPropertyValueHistory", pages+1)
+		estimate.Notes = append(estimate.Notes, fmt.Sprintf("each alarm window adds kinesisvideo:ListFragments calls, for up to %d windows", maxVideoWindows))
+	case models.QueryTypeBaselineCompare:
+		pages, _, err := ds.estimateHistoryPages(ctx, query)
+		if err != nil {
+			return estimate, err
+		}
+		// the baseline window is read the same way
+		add("iottwinmaker:GetPropertyValueHistory", 2*(pages+1))
+	case models.QueryTypeDataAvailability:
+		pages, _, err := ds.estimateHistoryPages(ctx, query)
+		if err != nil {
+			return estimate, err
+		}
+		if pages >= maxAvailabilityPages {
+			pages = maxAvailabilityPages - 1
+			estimate.Notes = append(estimate.Notes, "counting stops early, not all values will be counted")
+		}
+		add("iottwinmaker:GetPropertyValueHistory", pages+1)
+	case models.QueryTypeGetAlarms:
+		add("iottwinmaker:ListComponentTypes", 2)
+		estimate.Notes = append(estimate.Notes, "each alarm component type adds a component history query")
+	case models.QueryTypeWorkspaceEvents:
+		add("cloudtrail:LookupEvents", 10)
+		estimate.Notes = append(estimate.Notes, "upper bound, lookups stop at the last page")
+	case models.QueryTypeExecuteQuery:
+		rows := query.MaxResults
+		if rows <= 0 {
+			rows = defaultExecuteQueryRows
+		}
+		add("iottwinmaker:ExecuteQuery", (rows+maxExecuteQueryPage-1)/maxExecuteQueryPage)
+		estimate.Notes = append(estimate.Notes, "upper bound, knowledge graph queries are billed by query units which are not included in the cost")
+	case models.QueryTypeWatchlist, models.QueryTypeAuditLog:
+		estimate.Notes = append(estimate.Notes, "served from datasource memory")
+	}
+
+	billable := 0
+	for api, n := range estimate.Calls {
+		estimate.TotalCalls += n
+		if billableCalls[api] {
+			billable += n
+		}
+	}
+	estimate.EstimatedCost = float64(billable) * dataAccessCallPrice
+	if query.GrafanaLiveEnabled && query.IsStreaming {
+		estimate.Notes = append(estimate.Notes, "streaming repeats the query every interval")
+	}
+	return estimate, nil
+}
+
+// estimateHistoryPages returns the expected number of full history pages after the probe, and
+// the number of series seen in the probe
+func (ds *Datasource) estimateHistoryPages(ctx context.Context, query models.TwinMakerQuery) (int, int, error) {
+	query.MaxResults = minHistoryPageSize
+	probe, err := ds.Client.GetPropertyValueHistory(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	if probe == nil {
+		return 0, 0, nil
+	}
+	series := len(probe.PropertyValues)
+	if probe.NextToken == nil {
+		return 0, series, nil
+	}
+
+	remaining, ok := remainingHistoryValues(probe, query)
+	if !ok {
+		return 1, series, nil
+	}
+	size := maxHistoryPageSize
+	if query.PageSize > 0 {
+		size = pageSize(query, maxHistoryPageSize)
+	}
+	pages := int(math.Ceil(remaining / float64(size)))
+	if pages < 1 {
+		pages = 1
+	}
+	return pages, series, nil
+}
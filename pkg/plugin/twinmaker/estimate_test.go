@@ -0,0 +1,69 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type probeMockClient struct {
+	*twinMakerMockClient
+	from time.Time
+}
+
+// one value per minute for two series
+func (c *probeMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	rsp := &iottwinmaker.GetPropertyValueHistoryOutput{NextToken: aws.String("next")}
+	for _, id := range []string{"Mixer_0", "Mixer_1"} {
+		values := []*iottwinmaker.PropertyValue{}
+		for i := 0; i < query.MaxResults/2; i++ {
+			values = append(values, &iottwinmaker.PropertyValue{
+				Time: getTimeStringFromTimeObject(aws.Time(c.from.Add(time.Duration(i) * time.Minute))),
+			})
+		}
+		rsp.PropertyValues = append(rsp.PropertyValues, &iottwinmaker.PropertyValueHistory{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{EntityId: aws.String(id)},
+			Values:                  values,
+		})
+	}
+	return rsp, nil
+}
+
+func TestEstimate(t *testing.T) {
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, &probeMockClient{
+		twinMakerMockClient: &twinMakerMockClient{},
+		from:                from,
+	})
+
+	t.Run("history pages are extrapolated from the probe", func(t *testing.T) {
+		estimate, err := ds.Estimate(context.Background(), models.TwinMakerQuery{
+			QueryType:       models.QueryTypeComponentHistory,
+			ComponentTypeId: "com.example.cookiefactory.mixer",
+			TimeRange:       backend.TimeRange{From: from, To: from.Add(24 * time.Hour)},
+		})
+		require.NoError(t, err)
+		// 20 values in 9 minutes, so ~3180 values in the remaining range => 13 pages + the probe
+		require.Equal(t, map[string]int{
+			"iottwinmaker:GetComponentType":        1,
+			"iottwinmaker:GetPropertyValueHistory": 14,
+			"iottwinmaker:ListEntities":            2,
+			"iottwinmaker:GetEntity":               2,
+		}, estimate.Calls)
+		require.Equal(t, 19, estimate.TotalCalls)
+		require.InDelta(t, 14*dataAccessCallPrice, estimate.EstimatedCost, 1e-12)
+	})
+
+	t.Run("metadata queries are free", func(t *testing.T) {
+		estimate, err := ds.Estimate(context.Background(), models.TwinMakerQuery{QueryType: models.QueryTypeListEntities})
+		require.NoError(t, err)
+		require.Equal(t, 1, estimate.TotalCalls)
+		require.Zero(t, estimate.EstimatedCost)
+	})
+}
@@ -0,0 +1,479 @@
+package twinmaker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// bounds of query expressions, they are evaluated for every row of a response
+const (
+	maxExpressionLength = 1024
+	maxExpressionDepth  = 32
+)
+
+// exprValue is a number or a string, null when an operand was null or the operation undefined
+type exprValue struct {
+	num   float64
+	str   string
+	isStr bool
+	null  bool
+}
+
+var nullValue = exprValue{null: true}
+
+func numberValue(v float64) exprValue {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nullValue
+	}
+	return exprValue{num: v}
+}
+
+func boolValue(b bool) exprValue {
+	if b {
+		return exprValue{num: 1}
+	}
+	return exprValue{num: 0}
+}
+
+// exprEnv resolves the identifiers of an expression, false for unknown names
+type exprEnv func(name string) (exprValue, bool)
+
+type expr interface {
+	eval(env exprEnv) exprValue
+}
+
+type literalExpr struct{ value exprValue }
+
+type identExpr struct{ name string }
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+func (e literalExpr) eval(env exprEnv) exprValue { return e.value }
+
+func (e identExpr) eval(env exprEnv) exprValue {
+	v, ok := env(e.name)
+	if !ok {
+		return nullValue
+	}
+	return v
+}
+
+func (e unaryExpr) eval(env exprEnv) exprValue {
+	v := e.operand.eval(env)
+	if v.null || v.isStr {
+		return nullValue
+	}
+	if e.op == "!" {
+		return boolValue(v.num == 0)
+	}
+	return numberValue(-v.num)
+}
+
+func (e binaryExpr) eval(env exprEnv) exprValue {
+	l := e.left.eval(env)
+	// the right side of && and || is only needed when the left one does not decide
+	switch e.op {
+	case "&&":
+		if !l.null && !l.isStr && l.num == 0 {
+			return boolValue(false)
+		}
+	case "||":
+		if !l.null && !l.isStr && l.num != 0 {
+			return boolValue(true)
+		}
+	}
+	r := e.right.eval(env)
+	if l.null || r.null {
+		return nullValue
+	}
+	if l.isStr || r.isStr {
+		// strings of fields like alarmStatus are compared, not computed with
+		if l.isStr != r.isStr {
+			return nullValue
+		}
+		switch e.op {
+		case "==":
+			return boolValue(l.str == r.str)
+		case "!=":
+			return boolValue(l.str != r.str)
+		case "<":
+			return boolValue(l.str < r.str)
+		case "<=":
+			return boolValue(l.str <= r.str)
+		case ">":
+			return boolValue(l.str > r.str)
+		case ">=":
+			return boolValue(l.str >= r.str)
+		}
+		return nullValue
+	}
+	switch e.op {
+	case "+":
+		return numberValue(l.num + r.num)
+	case "-":
+		return numberValue(l.num - r.num)
+	case "*":
+		return numberValue(l.num * r.num)
+	case "/":
+		return numberValue(l.num / r.num)
+	case "%":
+		return numberValue(math.Mod(l.num, r.num))
+	case "==":
+		return boolValue(l.num == r.num)
+	case "!=":
+		return boolValue(l.num != r.num)
+	case "<":
+		return boolValue(l.num < r.num)
+	case "<=":
+		return boolValue(l.num <= r.num)
+	case ">":
+		return boolValue(l.num > r.num)
+	case ">=":
+		return boolValue(l.num >= r.num)
+	case "&&", "||":
+		return boolValue(r.num != 0)
+	}
+	return nullValue
+}
+
+// exprFunctions are the functions of expressions with their number of arguments, -1 for any
+var exprFunctions = map[string]int{
+	"abs": 1,
+	"min": -1,
+	"max": -1,
+	"if":  3,
+}
+
+func (e callExpr) eval(env exprEnv) exprValue {
+	if e.name == "if" {
+		c := e.args[0].eval(env)
+		if c.null || c.isStr {
+			return nullValue
+		}
+		if c.num != 0 {
+			return e.args[1].eval(env)
+		}
+		return e.args[2].eval(env)
+	}
+	values := make([]float64, len(e.args))
+	for i, arg := range e.args {
+		v := arg.eval(env)
+		if v.null || v.isStr {
+			return nullValue
+		}
+		values[i] = v.num
+	}
+	switch e.name {
+	case "abs":
+		return numberValue(math.Abs(values[0]))
+	case "min":
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return numberValue(m)
+	case "max":
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return numberValue(m)
+	}
+	return nullValue
+}
+
+// exprIdents are the identifiers an expression reads
+func exprIdents(e expr) []string {
+	switch e := e.(type) {
+	case identExpr:
+		return []string{e.name}
+	case unaryExpr:
+		return exprIdents(e.operand)
+	case binaryExpr:
+		return append(exprIdents(e.left), exprIdents(e.right)...)
+	case callExpr:
+		var idents []string
+		for _, arg := range e.args {
+			idents = append(idents, exprIdents(arg)...)
+		}
+		return idents
+	}
+	return nil
+}
+
+// parseExpression parses an arithmetic expression of numbers like 1.5 or 1e3, 'strings' and identifiers, names
+// with other characters are quoted with backticks. Comparisons and logical operators are 1 or 0.
+func parseExpression(s string) (expr, error) {
+	if len(s) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+	p := &exprParser{input: s}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.tok.text, p.tok.pos)
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	input string
+	pos   int
+	tok   token
+	depth int
+}
+
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", ","}
+
+func (p *exprParser) next() error {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+	c := p.input[p.pos]
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		// an exponent like 1e3 or 2.5E-4 needs digits after the sign
+		if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+			end := p.pos + 1
+			if end < len(p.input) && (p.input[end] == '+' || p.input[end] == '-') {
+				end++
+			}
+			if end < len(p.input) && p.input[end] >= '0' && p.input[end] <= '9' {
+				p.pos = end
+				for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+					p.pos++
+				}
+			}
+		}
+		p.tok = token{kind: tokNumber, text: p.input[start:p.pos], pos: start}
+		return nil
+	case c == '\'' || c == '`':
+		end := strings.IndexByte(p.input[p.pos+1:], c)
+		if end < 0 {
+			return fmt.Errorf("unterminated %c at %d", c, start)
+		}
+		p.pos += end + 2
+		kind := tokString
+		if c == '`' {
+			kind = tokIdent
+		}
+		p.tok = token{kind: kind, text: p.input[start+1 : p.pos-1], pos: start}
+		return nil
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+				break
+			}
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.input[start:p.pos], pos: start}
+		return nil
+	}
+	for _, op := range exprOperators {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			p.tok = token{kind: tokOp, text: op, pos: start}
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected %q at %d", c, start)
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+// binary parses operands of operand joined by the operators, left associative
+func (p *exprParser) binary(operand func() (expr, error), ops ...string) (expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops...) {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) or() (expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExpressionDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxExpressionDepth)
+	}
+	return p.binary(p.and, "||")
+}
+
+func (p *exprParser) and() (expr, error) {
+	return p.binary(p.comparison, "&&")
+}
+
+func (p *exprParser) comparison() (expr, error) {
+	return p.binary(p.sum, "==", "!=", "<=", ">=", "<", ">")
+}
+
+func (p *exprParser) sum() (expr, error) {
+	return p.binary(p.product, "+", "-")
+}
+
+func (p *exprParser) product() (expr, error) {
+	return p.binary(p.unary, "*", "/", "%")
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.isOp("-", "!") {
+		op := p.tok.text
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxExpressionDepth {
+			return nil, fmt.Errorf("expression is nested deeper than %d levels", maxExpressionDepth)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: op, operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", tok.text, tok.pos)
+		}
+		return literalExpr{value: exprValue{num: v}}, p.next()
+	case tokString:
+		return literalExpr{value: exprValue{str: tok.text, isStr: true}}, p.next()
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if !p.isOp("(") {
+			return identExpr{name: tok.text}, nil
+		}
+		arity, ok := exprFunctions[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s at %d", tok.text, tok.pos)
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 || (arity >= 0 && len(args) != arity) {
+			return nil, fmt.Errorf("wrong number of arguments for %s at %d", tok.text, tok.pos)
+		}
+		return callExpr{name: tok.text, args: args}, nil
+	case tokOp:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, fmt.Errorf("missing ) for ( at %d", tok.pos)
+			}
+			return e, p.next()
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+}
+
+// arguments parses the parenthesized arguments of a function call
+func (p *exprParser) arguments() ([]expr, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var args []expr
+	if p.isOp(")") {
+		return args, p.next()
+	}
+	for {
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.isOp(")") {
+			return args, p.next()
+		}
+		if !p.isOp(",") {
+			return nil, fmt.Errorf("missing ) of function call at %d", p.tok.pos)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+}
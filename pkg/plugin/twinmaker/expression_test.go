@@ -0,0 +1,78 @@
+package twinmaker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpression(t *testing.T) {
+	env := func(name string) (exprValue, bool) {
+		switch name {
+		case "magnitude":
+			return exprValue{num: 4}, true
+		case "duration":
+			return exprValue{num: 1800}, true
+		case "alarmStatus":
+			return exprValue{str: "ACTIVE", isStr: true}, true
+		case "Break Time":
+			return exprValue{num: 2}, true
+		case "missing":
+			return nullValue, true
+		}
+		return nullValue, false
+	}
+	for text, expected := range map[string]exprValue{
+		"1 + 2 * 3":                          {num: 7},
+		"(1 + 2) * 3":                        {num: 9},
+		"10 - 4 - 3":                         {num: 3},
+		"-magnitude + 5 % 3":                 {num: -2},
+		"magnitude * duration / 60":          {num: 120},
+		"(alarmStatus == 'ACTIVE') * 10 + 1": {num: 11},
+		"alarmStatus != 'ACTIVE' || !0":      {num: 1},
+		"magnitude > 3 && duration >= 3600":  {num: 0},
+		"max(magnitude, 2, 9) - min(1, 0.5)": {num: 8.5},
+		"abs(2 - magnitude)":                 {num: 2},
+		"if(magnitude > 3, 100, 1/0)":        {num: 100},
+		"`Break Time` * 2":                   {num: 4},
+		"1 / 0":                              nullValue,
+		"missing + 1":                        nullValue,
+		"alarmStatus + 1":                    nullValue,
+		"0 && missing":                       {num: 0},
+		"if(missing, 1, 2)":                  nullValue,
+		"1e3 + 2.5E-1":                       {num: 1000.25},
+		"magnitude * 1e+2":                   {num: 400},
+	} {
+		e, err := parseExpression(text)
+		require.NoError(t, err, text)
+		require.Equal(t, expected, e.eval(env), text)
+	}
+
+	e, err := parseExpression("magnitude * duration + if(alarmStatus == 'ACTIVE', magnitude, 0)")
+	require.NoError(t, err)
+	require.Equal(t, []string{"magnitude", "duration", "alarmStatus", "magnitude"}, exprIdents(e))
+
+	for text, message := range map[string]string{
+		"1 +":           "unexpected end of expression",
+		"(1 + 2":        "missing ) for ( at 0",
+		"1 2":           `unexpected "2" at 2`,
+		"sqrt(4)":       "unknown function sqrt at 0",
+		"abs(1, 2)":     "wrong number of arguments for abs at 0",
+		"'ACTIVE":       "unterminated ' at 0",
+		"magnitude ^ 2": `unexpected '^' at 10`,
+		"1.2.3":         `invalid number "1.2.3" at 0`,
+		"max()":         "wrong number of arguments for max at 0",
+		"2e":            `unexpected "e" at 1`,
+	} {
+		_, err := parseExpression(text)
+		require.EqualError(t, err, message, text)
+	}
+
+	_, err = parseExpression(strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40))
+	require.EqualError(t, err, "expression is nested deeper than 32 levels")
+	_, err = parseExpression(strings.Repeat("-", 40) + "1")
+	require.EqualError(t, err, "expression is nested deeper than 32 levels")
+	_, err = parseExpression(strings.Repeat("1+", 600) + "1")
+	require.EqualError(t, err, "expression is longer than 1024 characters")
+}
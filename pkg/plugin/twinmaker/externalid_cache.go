@@ -0,0 +1,109 @@
+package twinmaker
+
+import (
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/patrickmn/go-cache"
+)
+
+// DefaultExternalIdCacheTTL is how long an externalId resolves to the same entity component when
+// the settings have no expiry
+const DefaultExternalIdCacheTTL = 10 * time.Minute
+
+// externalIdResolution is the entity component an externalId of a component history result
+// belongs to
+type externalIdResolution struct {
+	entityId      string
+	entityName    string
+	componentName string
+}
+
+// externalIdCache keeps the resolved externalIds of component history queries per workspace, so
+// dashboard refreshes only fetch the history. A nil cache resolves every time.
+type externalIdCache struct {
+	entries *cache.Cache
+}
+
+// newExternalIdCache returns nil when ttl is negative, zero uses DefaultExternalIdCacheTTL
+func newExternalIdCache(ttl time.Duration) *externalIdCache {
+	if ttl < 0 {
+		return nil
+	}
+	if ttl == 0 {
+		ttl = DefaultExternalIdCacheTTL
+	}
+	return &externalIdCache{entries: cache.New(ttl, ttl*2)}
+}
+
+func externalIdKey(workspaceId string, componentTypeId string, externalId string) string {
+	return workspaceId + "/" + componentTypeId + "/" + externalId
+}
+
+func (c *externalIdCache) get(workspaceId string, componentTypeId string, externalId string) (externalIdResolution, bool) {
+	if c == nil {
+		return externalIdResolution{}, false
+	}
+	v, ok := c.entries.Get(externalIdKey(workspaceId, componentTypeId, externalId))
+	if !ok {
+		return externalIdResolution{}, false
+	}
+	return v.(externalIdResolution), true
+}
+
+func (c *externalIdCache) set(workspaceId string, componentTypeId string, externalId string, r externalIdResolution) {
+	if c == nil {
+		return
+	}
+	c.entries.SetDefault(externalIdKey(workspaceId, componentTypeId, externalId), r)
+}
+
+// invalidate drops the resolutions of the workspace, or of all workspaces when it is empty, and
+// returns how many were dropped
+func (c *externalIdCache) invalidate(workspaceId string) int {
+	if c == nil {
+		return 0
+	}
+	if workspaceId == "" {
+		count := c.entries.ItemCount()
+		c.entries.Flush()
+		return count
+	}
+	count := 0
+	for key := range c.entries.Items() {
+		if strings.HasPrefix(key, workspaceId+"/") {
+			c.entries.Delete(key)
+			count++
+		}
+	}
+	return count
+}
+
+// InvalidateExternalIds makes the next component history queries of the workspace resolve their
+// externalIds again, e.g. after entities were re-synced. All workspaces when the id is empty.
+func (ds *Datasource) InvalidateExternalIds(workspaceId string) int {
+	handlers := []TwinMakerHandler{ds.Handler, ds.Viewer}
+	ds.rolesMu.Lock()
+	for _, h := range ds.roles {
+		handlers = append(handlers, h)
+	}
+	ds.rolesMu.Unlock()
+
+	count := 0
+	for _, h := range handlers {
+		if handler, ok := h.(*twinMakerHandler); ok {
+			count += handler.externalIds.invalidate(workspaceId)
+		}
+	}
+	return count
+}
+
+// newRoleHandler is the handler of one role of the datasource: the primary, viewer or a query
+// role. Every role resolves externalIds with a cache of its own.
+func newRoleHandler(c TwinMakerClient, settings models.TwinMakerDataSourceSetting, redaction *redactor) *twinMakerHandler {
+	handler := newTwinMakerHandler(c, redaction)
+	handler.externalIds = newExternalIdCache(settings.ExternalIdCacheTTL())
+	handler.links = newLinkPolicy(settings)
+	return handler
+}
@@ -0,0 +1,83 @@
+package twinmaker
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+const maxFavorites = 100
+
+// Favorites are the entity properties each user saved for the query editor. They are kept in the
+// metadata cache file when one is configured, otherwise only in memory until the datasource
+// instance is recreated.
+type Favorites struct {
+	store *metadataStore
+
+	mu    sync.RWMutex
+	users map[string][]models.PropertyFavorite
+}
+
+// newFavorites keeps the favorites in store, which may be nil
+func newFavorites(store *metadataStore) *Favorites {
+	return &Favorites{
+		store: store,
+		users: map[string][]models.PropertyFavorite{},
+	}
+}
+
+func favoritesKey(login string) string {
+	return "favorites/" + login
+}
+
+// Get returns the favorites of the user login
+func (f *Favorites) Get(login string) []models.PropertyFavorite {
+	f.mu.RLock()
+	favorites, ok := f.users[login]
+	f.mu.RUnlock()
+	if ok {
+		return append([]models.PropertyFavorite{}, favorites...)
+	}
+
+	favorites = []models.PropertyFavorite{}
+	if f.store != nil {
+		f.store.read(favoritesKey(login), &favorites, 0)
+	}
+	f.mu.Lock()
+	f.users[login] = favorites
+	f.mu.Unlock()
+	return append([]models.PropertyFavorite{}, favorites...)
+}
+
+// Set replaces the favorites of the user login, duplicates are dropped
+func (f *Favorites) Set(login string, favorites []models.PropertyFavorite) ([]models.PropertyFavorite, error) {
+	if login == "" {
+		return nil, fmt.Errorf("favorites need a signed in user")
+	}
+
+	type property struct{ workspaceId, entityId, componentName, propertyName string }
+	seen := map[property]bool{}
+	unique := []models.PropertyFavorite{}
+	for _, favorite := range favorites {
+		if favorite.EntityId == "" || favorite.ComponentName == "" || favorite.PropertyName == "" {
+			return nil, fmt.Errorf("favorites require entityId, componentName and propertyName")
+		}
+		key := property{favorite.WorkspaceId, favorite.EntityId, favorite.ComponentName, favorite.PropertyName}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, favorite)
+		}
+	}
+	if len(unique) > maxFavorites {
+		return nil, fmt.Errorf("favorites are limited to %d properties", maxFavorites)
+	}
+
+	f.mu.Lock()
+	f.users[login] = unique
+	f.mu.Unlock()
+	if f.store != nil {
+		f.store.put(favoritesKey(login), unique)
+	}
+	return append([]models.PropertyFavorite{}, unique...), nil
+}
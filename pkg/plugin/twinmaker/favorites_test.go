@@ -0,0 +1,43 @@
+package twinmaker
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFavorites(t *testing.T) {
+	settings := models.TwinMakerDataSourceSetting{
+		MetadataCacheFile: filepath.Join(t.TempDir(), "metadata.db"),
+		UID:               "abc",
+	}
+	mixer := models.PropertyFavorite{EntityId: "Mixer_0", ComponentName: "Alarm", PropertyName: "alarm_status"}
+
+	ds := NewDatasourceWithClient(settings, &twinMakerMockClient{})
+	require.Empty(t, ds.Favorites.Get("operator"))
+
+	labeled := mixer
+	labeled.Label = "mixer alarm"
+	favorites, err := ds.Favorites.Set("operator", []models.PropertyFavorite{mixer, labeled})
+	require.NoError(t, err)
+	require.Equal(t, []models.PropertyFavorite{mixer}, favorites)
+	require.Empty(t, ds.Favorites.Get("admin"))
+
+	_, err = ds.Favorites.Set("operator", []models.PropertyFavorite{{EntityId: "Mixer_0"}})
+	require.Error(t, err)
+	_, err = ds.Favorites.Set("", []models.PropertyFavorite{mixer})
+	require.Error(t, err)
+	require.NoError(t, ds.Close())
+
+	// kept across restarts in the metadata cache file
+	restarted := NewDatasourceWithClient(settings, &twinMakerMockClient{})
+	require.Equal(t, []models.PropertyFavorite{mixer}, restarted.Favorites.Get("operator"))
+	require.NoError(t, restarted.Close())
+
+	inMemory := newFavorites(nil)
+	_, err = inMemory.Set("operator", []models.PropertyFavorite{mixer})
+	require.NoError(t, err)
+	require.Equal(t, []models.PropertyFavorite{mixer}, inMemory.Get("operator"))
+}
@@ -40,57 +40,148 @@ func (r *twinMakerFrameBuilder) Time() *data.Field {
 	return r.add(f, data.TimeSeriesTimeFieldName)
 }
 
-func newDataValueField(v *iottwinmaker.DataValue, count int) (*data.Field, func(v *iottwinmaker.DataValue) interface{}) {
-	if val := v.BooleanValue; val != nil {
-		f := data.NewFieldFromFieldType(data.FieldTypeNullableBool, count)
-		c := func(v *iottwinmaker.DataValue) interface{} {
-			return v.BooleanValue
+// dataValueAppender sets the values of a field created for one TwinMaker data type
+type dataValueAppender interface {
+	// Set stores the value at i, a value of another data type is an error and left null
+	Set(i int, v *iottwinmaker.DataValue) error
+}
+
+// typedAppender reads the typed member of the DataValue, so values are not switched on one by one
+type typedAppender[T any] struct {
+	field    *data.Field
+	dataType string
+	get      func(v *iottwinmaker.DataValue) *T
+}
+
+func (a typedAppender[T]) Set(i int, v *iottwinmaker.DataValue) error {
+	if v == nil {
+		return nil
+	}
+	val := a.get(v)
+	if val == nil {
+		if t := dataValueType(v); t != "" {
+			return fmt.Errorf("%s value in a %s field", t, a.dataType)
 		}
-		return f, c
+		return nil
 	}
+	a.field.Set(i, val)
+	return nil
+}
 
-	if val := v.DoubleValue; val != nil {
-		f := data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, count)
-		c := func(v *iottwinmaker.DataValue) interface{} {
-			return v.DoubleValue
-		}
-		return f, c
+func newTypedAppender[T any](dataType string, count int, get func(v *iottwinmaker.DataValue) *T) (*data.Field, dataValueAppender) {
+	f := data.NewField("", nil, make([]*T, count))
+	return f, typedAppender[T]{field: f, dataType: dataType, get: get}
+}
+
+// formattedAppender stores nested lists and maps as their string form, like before fields were typed
+type formattedAppender struct {
+	field *data.Field
+}
+
+func (a formattedAppender) Set(i int, v *iottwinmaker.DataValue) error {
+	if dataValueType(v) == "" {
+		return nil
 	}
+	s := fmt.Sprintf("%v", v)
+	a.field.Set(i, &s)
+	return nil
+}
 
-	if val := v.LongValue; val != nil {
-		f := data.NewFieldFromFieldType(data.FieldTypeNullableInt64, count)
-		c := func(v *iottwinmaker.DataValue) interface{} {
-			return v.LongValue
-		}
-		return f, c
+// newDataValueAppender creates a field of count values for the TwinMaker data type and its appender.
+// Lists and maps are formatted as strings, values without a data type can not be stored.
+func newDataValueAppender(dataType string, count int) (*data.Field, dataValueAppender, error) {
+	switch dataType {
+	case iottwinmaker.TypeBoolean:
+		f, a := newTypedAppender(dataType, count, func(v *iottwinmaker.DataValue) *bool { return v.BooleanValue })
+		return f, a, nil
+	case iottwinmaker.TypeDouble:
+		f, a := newTypedAppender(dataType, count, func(v *iottwinmaker.DataValue) *float64 { return v.DoubleValue })
+		return f, a, nil
+	case iottwinmaker.TypeLong:
+		f, a := newTypedAppender(dataType, count, func(v *iottwinmaker.DataValue) *int64 { return v.LongValue })
+		return f, a, nil
+	case iottwinmaker.TypeInteger:
+		f, a := newTypedAppender(dataType, count, func(v *iottwinmaker.DataValue) *int64 { return v.IntegerValue })
+		return f, a, nil
+	case iottwinmaker.TypeString:
+		f, a := newTypedAppender(dataType, count, func(v *iottwinmaker.DataValue) *string { return v.StringValue })
+		return f, a, nil
+	case iottwinmaker.TypeRelationship:
+		f, a := newTypedAppender(dataType, count, func(v *iottwinmaker.DataValue) *string {
+			if v.RelationshipValue == nil {
+				return nil
+			}
+			s := v.RelationshipValue.String()
+			return &s
+		})
+		return f, a, nil
+	case iottwinmaker.TypeList, iottwinmaker.TypeMap:
+		f := data.NewFieldFromFieldType(data.FieldTypeNullableString, count)
+		return f, formattedAppender{field: f}, nil
+	case "":
+		return nil, nil, fmt.Errorf("value has no data type")
 	}
+	return nil, nil, fmt.Errorf("unsupported data type %s", dataType)
+}
 
-	if val := v.IntegerValue; val != nil {
-		f := data.NewFieldFromFieldType(data.FieldTypeNullableInt64, count)
-		c := func(v *iottwinmaker.DataValue) interface{} {
-			return v.IntegerValue
-		}
-		return f, c
+// dataValueType is the TwinMaker data type of the value, empty when no value is set
+func dataValueType(v *iottwinmaker.DataValue) string {
+	switch {
+	case v == nil:
+		return ""
+	case v.BooleanValue != nil:
+		return iottwinmaker.TypeBoolean
+	case v.DoubleValue != nil:
+		return iottwinmaker.TypeDouble
+	case v.LongValue != nil:
+		return iottwinmaker.TypeLong
+	case v.IntegerValue != nil:
+		return iottwinmaker.TypeInteger
+	case v.StringValue != nil:
+		return iottwinmaker.TypeString
+	case v.RelationshipValue != nil:
+		return iottwinmaker.TypeRelationship
+	case v.ListValue != nil:
+		return iottwinmaker.TypeList
+	case v.MapValue != nil:
+		return iottwinmaker.TypeMap
 	}
+	return ""
+}
 
-	if val := v.StringValue; val != nil {
-		f := data.NewFieldFromFieldType(data.FieldTypeNullableString, count)
-		c := func(v *iottwinmaker.DataValue) interface{} {
-			return v.StringValue
+// newDataValueField creates the field for the data type of the value
+func newDataValueField(v *iottwinmaker.DataValue, count int) (*data.Field, dataValueAppender, error) {
+	return newDataValueAppender(dataValueType(v), count)
+}
+
+// valuesDataType is the declared data type of a property, or the type of the first of its values
+// that is not null when the definition is not known
+func valuesDataType(declared *iottwinmaker.DataType, values ...*iottwinmaker.DataValue) string {
+	if declared != nil && declared.Type != nil {
+		return *declared.Type
+	}
+	for _, v := range values {
+		if t := dataValueType(v); t != "" {
+			return t
 		}
-		return f, c
 	}
+	return ""
+}
 
-	f := data.NewFieldFromFieldType(data.FieldTypeString, count)
-	c := func(v *iottwinmaker.DataValue) interface{} {
-		return fmt.Sprintf("%v", v)
+// dataValueNotice reports a property whose values could not be converted
+func dataValueNotice(propertyName string, err error) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("%s: %s", propertyName, err.Error()),
 	}
-	return f, c
 }
 
-func (r *twinMakerFrameBuilder) Value(v *iottwinmaker.DataValue) (*data.Field, func(v *iottwinmaker.DataValue) interface{}) {
-	f, c := newDataValueField(v, r.len)
-	return r.add(f, data.TimeSeriesValueFieldName), c
+func (r *twinMakerFrameBuilder) Value(dataType string) (*data.Field, dataValueAppender, error) {
+	f, a, err := newDataValueAppender(dataType, r.len)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.add(f, data.TimeSeriesValueFieldName), a, nil
 }
 
 func (r *twinMakerFrameBuilder) ARN() *data.Field {
@@ -103,6 +194,11 @@ func (r *twinMakerFrameBuilder) CreationDate() *data.Field {
 	return r.add(f, "created")
 }
 
+func (r *twinMakerFrameBuilder) UpdateDate() *data.Field {
+	f := data.NewFieldFromFieldType(data.FieldTypeNullableTime, r.len)
+	return r.add(f, "updated")
+}
+
 func (r *twinMakerFrameBuilder) Description() *data.Field {
 	f := data.NewFieldFromFieldType(data.FieldTypeNullableString, r.len)
 	return r.add(f, "description")
@@ -190,6 +286,32 @@ func (r *twinMakerFrameBuilder) AlarmStatus() *data.Field {
 	return r.add(f, "alarmStatus")
 }
 
+// annotation frame fields
+func (r *twinMakerFrameBuilder) Runbook() *data.Field {
+	f := data.NewFieldFromFieldType(data.FieldTypeNullableString, r.len)
+	return r.add(f, "runbook")
+}
+
+func (r *twinMakerFrameBuilder) TimeEnd() *data.Field {
+	f := data.NewFieldFromFieldType(data.FieldTypeNullableTime, r.len)
+	return r.add(f, "timeEnd")
+}
+
+func (r *twinMakerFrameBuilder) Title() *data.Field {
+	f := data.NewFieldFromFieldType(data.FieldTypeNullableString, r.len)
+	return r.add(f, "title")
+}
+
+func (r *twinMakerFrameBuilder) Text() *data.Field {
+	f := data.NewFieldFromFieldType(data.FieldTypeNullableString, r.len)
+	return r.add(f, "text")
+}
+
+func (r *twinMakerFrameBuilder) Tags() *data.Field {
+	f := data.NewFieldFromFieldType(data.FieldTypeNullableString, r.len)
+	return r.add(f, "tags")
+}
+
 // // CreationDate is a required field
 // CreationDate *time.Time `locationName:"creationDate" type:"timestamp" required:"true"`
 
@@ -207,3 +329,74 @@ func (r *twinMakerFrameBuilder) AlarmStatus() *data.Field {
 
 // // WorkspaceId is a required field
 // WorkspaceId *string `locationName:"workspaceId" min:"1" type:"string" required:"true"`
+
+// stateTimelineConfig maps boolean values to discrete states for the State timeline panel
+func stateTimelineConfig(opts models.TwinMakerBooleanDisplay) *data.FieldConfig {
+	state := func(label string, fallback string) string {
+		if label == "" {
+			return fallback
+		}
+		return label
+	}
+	return &data.FieldConfig{
+		Mappings: data.ValueMappings{
+			data.ValueMapper{
+				"true": {
+					Color: state(opts.TrueColor, "green"),
+					Index: 0,
+					Text:  state(opts.TrueLabel, "true"),
+				},
+				"false": {
+					Color: state(opts.FalseColor, "red"),
+					Index: 1,
+					Text:  state(opts.FalseLabel, "false"),
+				},
+			},
+		},
+		Custom: map[string]interface{}{
+			// State timeline panel
+			"fillOpacity": 80,
+		},
+	}
+}
+
+// alarmStatuses are the values of the alarm_status property in mapping order, with their colors
+var alarmStatuses = []struct{ status, color string }{
+	{"NORMAL", "green"},
+	{"ACTIVE", "red"},
+	{"SNOOZE_DISABLED", "orange"},
+	{"ACKNOWLEDGED", "blue"},
+}
+
+func validAlarmStatus(status string) error {
+	if status == "" {
+		return nil
+	}
+	for _, s := range alarmStatuses {
+		if s.status == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown alarm status %s", status)
+}
+
+// alarmStatusConfig colors the alarm statuses in the Table and State timeline panels
+func alarmStatusConfig() *data.FieldConfig {
+	mapper := data.ValueMapper{}
+	for i, s := range alarmStatuses {
+		mapper[s.status] = data.ValueMappingResult{
+			Color: s.color,
+			Index: i,
+			Text:  s.status,
+		}
+	}
+	return &data.FieldConfig{
+		Mappings: data.ValueMappings{mapper},
+		Custom: map[string]interface{}{
+			// Table panel
+			"displayMode": "color-text",
+			// State timeline panel
+			"fillOpacity": 80,
+		},
+	}
+}
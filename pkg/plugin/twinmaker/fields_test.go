@@ -0,0 +1,108 @@
+package twinmaker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataValueAppender(t *testing.T) {
+	cases := []struct {
+		value     *iottwinmaker.DataValue
+		fieldType data.FieldType
+	}{
+		{&iottwinmaker.DataValue{BooleanValue: aws.Bool(true)}, data.FieldTypeNullableBool},
+		{&iottwinmaker.DataValue{DoubleValue: aws.Float64(1.5)}, data.FieldTypeNullableFloat64},
+		{&iottwinmaker.DataValue{LongValue: aws.Int64(2)}, data.FieldTypeNullableInt64},
+		{&iottwinmaker.DataValue{IntegerValue: aws.Int64(3)}, data.FieldTypeNullableInt64},
+		{&iottwinmaker.DataValue{StringValue: aws.String("on")}, data.FieldTypeNullableString},
+		{&iottwinmaker.DataValue{RelationshipValue: &iottwinmaker.RelationshipValue{TargetEntityId: aws.String("Mixer_0")}}, data.FieldTypeNullableString},
+	}
+	for _, c := range cases {
+		f, appender, err := newDataValueField(c.value, 2)
+		require.NoError(t, err)
+		require.Equal(t, c.fieldType, f.Type())
+		require.NoError(t, appender.Set(0, c.value))
+		require.NotNil(t, f.At(0))
+		// empty values are null
+		require.NoError(t, appender.Set(1, &iottwinmaker.DataValue{}))
+		require.Nil(t, f.At(1))
+	}
+
+	// values of another type are reported and left null
+	f, appender, err := newDataValueAppender(iottwinmaker.TypeDouble, 1)
+	require.NoError(t, err)
+	require.EqualError(t, appender.Set(0, &iottwinmaker.DataValue{StringValue: aws.String("x")}), "STRING value in a DOUBLE field")
+	require.Nil(t, f.At(0))
+
+	// nested lists and maps are formatted as strings
+	list := &iottwinmaker.DataValue{ListValue: []*iottwinmaker.DataValue{{LongValue: aws.Int64(1)}}}
+	f, appender, err = newDataValueField(list, 2)
+	require.NoError(t, err)
+	require.Equal(t, data.FieldTypeNullableString, f.Type())
+	require.NoError(t, appender.Set(0, list))
+	require.Equal(t, fmt.Sprintf("%v", list), *f.At(0).(*string))
+	require.NoError(t, appender.Set(1, &iottwinmaker.DataValue{}))
+	require.Nil(t, f.At(1))
+
+	_, _, err = newDataValueField(&iottwinmaker.DataValue{}, 1)
+	require.EqualError(t, err, "value has no data type")
+}
+
+func TestValuesDataType(t *testing.T) {
+	values := []*iottwinmaker.DataValue{nil, {}, {DoubleValue: aws.Float64(1)}}
+	// the first value with a type when the definition is not known
+	require.Equal(t, iottwinmaker.TypeDouble, valuesDataType(nil, values...))
+	require.Equal(t, iottwinmaker.TypeLong, valuesDataType(&iottwinmaker.DataType{Type: aws.String(iottwinmaker.TypeLong)}, values...))
+	require.Equal(t, "", valuesDataType(nil, nil, &iottwinmaker.DataValue{}))
+}
+
+func TestProcessHistoryDeclaredType(t *testing.T) {
+	history := &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String("Mixer_0"),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Temperature"),
+			},
+			Values: []*iottwinmaker.PropertyValue{
+				{Time: aws.String("2022-04-27T00:00:00Z"), Value: &iottwinmaker.DataValue{}},
+				{Time: aws.String("2022-04-27T00:01:00Z"), Value: &iottwinmaker.DataValue{LongValue: aws.Int64(2)}},
+			},
+		}, {
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String("Mixer_0"),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Positions"),
+			},
+			Values: []*iottwinmaker.PropertyValue{
+				{Time: aws.String("2022-04-27T00:00:00Z"), Value: &iottwinmaker.DataValue{ListValue: []*iottwinmaker.DataValue{
+					{ListValue: []*iottwinmaker.DataValue{{DoubleValue: aws.Float64(1)}}},
+				}}},
+			},
+		}},
+	}
+	hints := map[string]propertyHints{
+		"Temperature": {dataType: &iottwinmaker.DataType{Type: aws.String(iottwinmaker.TypeLong)}},
+	}
+
+	dr := newTwinMakerHandler(&twinMakerMockClient{}, nil).processHistory(history, nil, nil, models.TwinMakerQuery{}, hints)
+	require.NoError(t, dr.Error)
+	require.Len(t, dr.Frames, 2)
+
+	// a null first value keeps the series
+	temperature := dr.Frames[0].Fields[0]
+	require.Equal(t, data.FieldTypeNullableInt64, temperature.Type())
+	require.Nil(t, temperature.At(0))
+	require.Equal(t, int64(2), *temperature.At(1).(*int64))
+
+	// lists of lists are kept as strings
+	positions := dr.Frames[1].Fields[0]
+	require.Equal(t, data.FieldTypeNullableString, positions.Type())
+	require.Contains(t, *positions.At(0).(*string), "DoubleValue: 1")
+}
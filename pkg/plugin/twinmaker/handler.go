@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"sort"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/patrickmn/go-cache"
 )
 
 // TwinMakerHandler uses a client to create grafana response objects
@@ -23,6 +25,8 @@ type TwinMakerHandler interface {
 	GetWriteSessionToken(ctx context.Context, duration time.Duration, workspaceId string) (models.TokenInfo, error)
 	ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
 	ListScenes(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetSceneTags(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetDefinitionDiff(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
 	ListEntities(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
 	ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
 	GetEntity(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
@@ -32,15 +36,43 @@ type TwinMakerHandler interface {
 	GetComponentHistory(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
 	GetEntityHistory(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
 	GetAlarms(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetWorkspaceEvents(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetAlarmAnnotations(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetAlarmVideo(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetPropertyHeatmap(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetDataAvailability(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetStateChanges(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetPropertyHistogram(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetBaselineCompare(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	GetInterpolatedHistory(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+	ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse
+
+	// ExportAlarmHistory writes the alarm history of the query time range as CSV
+	ExportAlarmHistory(ctx context.Context, query models.TwinMakerQuery, w io.Writer) error
 }
 
 type twinMakerHandler struct {
-	client TwinMakerClient
+	client    TwinMakerClient
+	redaction *redactor
+	// sample intervals detected for AlignToResolution queries
+	intervals *cache.Cache
+	// entity components of the externalIds in component history results, nil when off
+	externalIds *externalIdCache
+	// schemes of the URL values that get data links
+	links *linkPolicy
 }
 
 func NewTwinMakerHandler(client TwinMakerClient) TwinMakerHandler {
+	return newTwinMakerHandler(client, nil)
+}
+
+func newTwinMakerHandler(client TwinMakerClient, redaction *redactor) *twinMakerHandler {
 	return &twinMakerHandler{
-		client: client,
+		client:    client,
+		redaction: redaction,
+		intervals: newIntervalCache(),
+
+		externalIds: newExternalIdCache(DefaultExternalIdCacheTTL),
 	}
 }
 
@@ -122,16 +154,20 @@ func (s *twinMakerHandler) ListEntities(ctx context.Context, query models.TwinMa
 
 	entityId := fields.EntityID()
 	entityName := fields.Name()
-	description := fields.Description()
-	created := fields.CreationDate()
-	arn := fields.ARN()
+	columns := query.EntityMetadata
+	if len(columns) == 0 {
+		columns = defaultEntityMetadata
+	}
+	metadata, err := newEntityMetadataFields(&fields, columns, "description")
+	if err != nil {
+		dr.Error = err
+		return
+	}
 
 	for i, summary := range results.EntitySummaries {
-		arn.Set(i, summary.Arn)
-		created.Set(i, *summary.CreationDateTime)
 		entityId.Set(i, summary.EntityId)
 		entityName.Set(i, summary.EntityName)
-		description.Set(i, summary.Description)
+		metadata.set(i, summary.Description, summary.Arn, summary.CreationDateTime, summary.UpdateDateTime)
 	}
 
 	frame := fields.ToFrame("", results.NextToken)
@@ -196,6 +232,12 @@ func (s *twinMakerHandler) GetEntity(ctx context.Context, query models.TwinMaker
 	propertyField := fields.PropertiesInfo()
 	timeSeriesField := fields.PropertiesInfo()
 	timeSeriesField.Name = "timeSeries"
+	// the entity columns repeat on every component row
+	metadata, err := newEntityMetadataFields(&fields, query.EntityMetadata, "entityDescription")
+	if err != nil {
+		dr.Error = err
+		return
+	}
 
 	sort.Strings(components)
 	for i, c := range components {
@@ -224,9 +266,12 @@ func (s *twinMakerHandler) GetEntity(ctx context.Context, query models.TwinMaker
 		description.Set(i, component.Description)
 		propertyField.Set(i, string(pInfo))
 		timeSeriesField.Set(i, string(tInfo))
+		metadata.set(i, result.Description, result.Arn, result.CreationDateTime, result.UpdateDateTime)
 	}
 	frame := fields.ToFrame("", nil)
-	if result.EntityName != nil {
+	if query.FieldNaming == models.FieldNamingStableV1 {
+		frame.Name = aws.StringValue(result.EntityId)
+	} else if result.EntityName != nil {
 		frame.Name = *result.EntityName
 	}
 
@@ -235,6 +280,9 @@ func (s *twinMakerHandler) GetEntity(ctx context.Context, query models.TwinMaker
 }
 
 func (s *twinMakerHandler) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if len(query.EntityIds) > 0 {
+		return forEachEntity(ctx, query, s.GetPropertyValue)
+	}
 	results, err := s.client.GetPropertyValue(ctx, query)
 	dr.Error = err
 	if err != nil {
@@ -248,6 +296,23 @@ func (s *twinMakerHandler) GetPropertyValue(ctx context.Context, query models.Tw
 
 	frame := data.NewFrame("")
 
+	// the definitions are only read when a value does not tell its type
+	var definitions map[string]*iottwinmaker.PropertyDefinitionResponse
+	declared := func(name string) *iottwinmaker.DataType {
+		if s.redaction.action(name) == models.RedactionMask {
+			return nil
+		}
+		if definitions == nil {
+			if definitions = s.propertyDefinitions(ctx, query); definitions == nil {
+				definitions = map[string]*iottwinmaker.PropertyDefinitionResponse{}
+			}
+		}
+		if def := definitions[name]; def != nil {
+			return def.DataType
+		}
+		return nil
+	}
+
 	if len(results.PropertyValues) > 0 {
 		propValues := make([]string, 0, len(results.PropertyValues))
 		for k := range results.PropertyValues {
@@ -257,25 +322,45 @@ func (s *twinMakerHandler) GetPropertyValue(ctx context.Context, query models.Tw
 
 		for _, propVal := range propValues {
 			prop := results.PropertyValues[propVal]
-			if v := prop.PropertyValue.ListValue; v != nil {
-				fr := s.processListValue(v, propVal)
+			value, ok := s.redaction.value(propVal, prop.PropertyValue)
+			if !ok {
+				continue
+			}
+			if v := value.ListValue; v != nil {
+				fr, err := s.processListValue(v, propVal, nestedType(declared(propVal)))
+				if err != nil {
+					frame.AppendNotices(dataValueNotice(propVal, err))
+					continue
+				}
 				frame.Fields = append(frame.Fields, fr.Fields...)
 				continue
 			}
-			if v := prop.PropertyValue.MapValue; v != nil {
-				fr := s.processMapValue(v)
+			if v := value.MapValue; v != nil {
+				fr, err := s.processMapValue(v, nestedType(declared(propVal)))
+				if err != nil {
+					frame.AppendNotices(dataValueNotice(propVal, err))
+					continue
+				}
 				frame.Fields = append(frame.Fields, fr.Fields...)
 				continue
 			}
-			f, converter := newDataValueField(prop.PropertyValue, 1)
-			f.Set(0, converter(prop.PropertyValue))
+			dataType := dataValueType(value)
+			if dataType == "" {
+				dataType = valuesDataType(declared(propVal))
+			}
+			f, appender, err := newDataValueAppender(dataType, 1)
+			if err != nil {
+				frame.AppendNotices(dataValueNotice(propVal, err))
+				continue
+			}
+			_ = appender.Set(0, value)
 
 			if prop.PropertyReference.PropertyName != nil {
-				f.Name = *prop.PropertyReference.PropertyName
-				var name, ok = query.PropertyDisplayNames[*prop.PropertyReference.PropertyName]
-				if ok {
-					f.Name = name
+				name := *prop.PropertyReference.PropertyName
+				if display, ok := query.PropertyDisplayNames[name]; ok {
+					name = display
 				}
+				nameSeries(f, query, prop.PropertyReference, name)
 			}
 
 			f.Labels = data.Labels{
@@ -287,32 +372,61 @@ func (s *twinMakerHandler) GetPropertyValue(ctx context.Context, query models.Tw
 		}
 	} else if len(results.TabularPropertyValues) > 0 && len(results.TabularPropertyValues[0]) > 0 {
 		tabularValuesList := results.TabularPropertyValues[0]
-		fieldsList := make([]*data.Field, 0, len(tabularValuesList[0]))
-		converterList := make([]func(v *iottwinmaker.DataValue) interface{}, 0, len(tabularValuesList[0]))
+		appenders := make(map[string]dataValueAppender, len(tabularValuesList[0]))
+		failed := map[string]bool{}
+
+		// columns outside the projection are not converted
+		var projection map[string]bool
+		if len(query.SelectedProperties) > 0 {
+			projection = make(map[string]bool, len(query.SelectedProperties))
+			for _, p := range query.SelectedProperties {
+				projection[p] = true
+			}
+		}
 
 		for valIdx, propList := range tabularValuesList {
 			keys := make([]string, 0, len(propList))
 			for k := range propList {
-				keys = append(keys, k)
+				if projection != nil && !projection[k] {
+					continue
+				}
+				if s.redaction.action(k) != models.RedactionDrop {
+					keys = append(keys, k)
+				}
 			}
 			sort.Strings(keys)
-			for propIdx, propName := range keys {
-				propVal := propList[propName]
+			for _, propName := range keys {
+				propVal, _ := s.redaction.value(propName, propList[propName])
 				// First iteration initialize the fields
 				if valIdx == 0 {
-					f, converter := newDataValueField(propVal, len(tabularValuesList))
+					column := make([]*iottwinmaker.DataValue, len(tabularValuesList))
+					for i, row := range tabularValuesList {
+						column[i], _ = s.redaction.value(propName, row[propName])
+					}
+					f, appender, err := newDataValueAppender(valuesDataType(declared(propName), column...), len(tabularValuesList))
+					if err != nil {
+						frame.AppendNotices(dataValueNotice(propName, err))
+						failed[propName] = true
+						continue
+					}
 					f.Name = propName
 					f.Labels = data.Labels{
 						"entityId":      query.EntityId,
 						"componentName": query.ComponentName,
 						"propertyName": propName,
 					}
-					fieldsList = append(fieldsList, f)
-					converterList = append(converterList, converter)
+					appenders[propName] = appender
 					frame.Fields = append(frame.Fields, f)
 				}
+				appender, ok := appenders[propName]
+				if !ok {
+					continue
+				}
 				// Save the property value in the respective field
-				fieldsList[propIdx].Set(valIdx, converterList[propIdx](propVal))
+				if err := appender.Set(valIdx, propVal); err != nil && !failed[propName] {
+					frame.AppendNotices(dataValueNotice(propName, err))
+					failed[propName] = true
+				}
 			}
 		}
 	}
@@ -321,60 +435,78 @@ func (s *twinMakerHandler) GetPropertyValue(ctx context.Context, query models.Tw
 	return
 }
 
-func (s *twinMakerHandler) processListValue(v []*iottwinmaker.DataValue, propVal string) *data.Frame {
+// nestedType is the declared type of the values of a list or map, nil when it is not known
+func nestedType(declared *iottwinmaker.DataType) *iottwinmaker.DataType {
+	if declared == nil {
+		return nil
+	}
+	return declared.NestedType
+}
+
+// processListValue converts the values of a list property, declared is the type of the values
+// when the definition was read
+func (s *twinMakerHandler) processListValue(v []*iottwinmaker.DataValue, propVal string, declared *iottwinmaker.DataType) (*data.Frame, error) {
 	fields := newTwinMakerFrameBuilder(len(v))
 
-	valField, valConvertor := fields.Value(v[0])
+	valField, appender, err := fields.Value(valuesDataType(declared, v...))
+	if err != nil {
+		return nil, err
+	}
 	valField.Name = propVal
 
-	isUrl := false
 	for i, value := range v {
-		valField.Set(i, valConvertor(value))
-		if !isUrl {
-			isUrl = checkForUrl(value, valConvertor)
+		if err := appender.Set(i, value); err != nil {
+			return nil, err
 		}
 	}
 
-	if isUrl {
+	if s.links.linkable(v) {
 		setUrlDatalink(valField)
 	}
 
 	frame := fields.ToFrame("", nil)
-	return frame
+	return frame, nil
 }
 
-func (s *twinMakerHandler) processMapValue(v map[string]*iottwinmaker.DataValue) *data.Frame {
+func (s *twinMakerHandler) processMapValue(v map[string]*iottwinmaker.DataValue, declared *iottwinmaker.DataType) (*data.Frame, error) {
 	keys := make([]string, 0, len(v))
 	for k := range v {
 		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	values := make([]*iottwinmaker.DataValue, len(keys))
+	for i, k := range keys {
+		values[i] = v[k]
+	}
 
 	fields := newTwinMakerFrameBuilder(len(v))
 
 	keyField := fields.Name()
 	keyField.Name = "Key"
-	valField, valConvertor := fields.Value(v[keys[0]])
+	valField, appender, err := fields.Value(valuesDataType(declared, values...))
+	if err != nil {
+		return nil, err
+	}
 	valField.Name = "Value"
 
-	isUrl := false
-	sort.Strings(keys)
 	for i, k := range keys {
 		keyField.Set(i, &keys[i])
-		valField.Set(i, valConvertor(v[k]))
-		if !isUrl {
-			isUrl = checkForUrl(v[k], valConvertor)
+		if err := appender.Set(i, v[k]); err != nil {
+			return nil, err
 		}
 	}
 
-	if isUrl {
+	if s.links.linkable(values) {
 		setUrlDatalink(valField)
 	}
 
 	frame := fields.ToFrame("", nil)
-	return frame
+	return frame, nil
 }
 
-func (s *twinMakerHandler) processHistory(results *iottwinmaker.GetPropertyValueHistoryOutput, err error, failures []data.Notice, query models.TwinMakerQuery) (dr backend.DataResponse) {
+// processHistory converts the property histories to frames, the value fields have the data type of
+// the property hints, or of the first value that is not null
+func (s *twinMakerHandler) processHistory(results *iottwinmaker.GetPropertyValueHistoryOutput, err error, failures []data.Notice, query models.TwinMakerQuery, hints map[string]propertyHints) (dr backend.DataResponse) {
 	dr.Error = err
 	if err != nil {
 		return
@@ -385,24 +517,71 @@ func (s *twinMakerHandler) processHistory(results *iottwinmaker.GetPropertyValue
 		return
 	}
 
+	var conversion []data.Notice
+	var interval time.Duration
 	for _, prop := range results.PropertyValues {
 		if len(prop.Values) == 0 {
 			continue
 		}
+		redaction := ""
+		if name := prop.EntityPropertyReference.PropertyName; name != nil {
+			redaction = s.redaction.action(*name)
+		}
+		if redaction == models.RedactionDrop {
+			continue
+		}
+		value := func(v *iottwinmaker.DataValue) *iottwinmaker.DataValue {
+			if redaction == models.RedactionMask {
+				return maskedDataValue()
+			}
+			return v
+		}
 		fields := newTwinMakerFrameBuilder(len(prop.Values))
 		// Must return value field first so its labels can be used for the Time field
-		v, conv := fields.Value(prop.Values[0].Value) // cspell:disable-line
+		propertyName := aws.StringValue(prop.EntityPropertyReference.PropertyName)
+		var declared *iottwinmaker.DataType
+		if redaction != models.RedactionMask {
+			declared = hints[propertyName].dataType
+		}
+		values := make([]*iottwinmaker.DataValue, len(prop.Values))
+		for i, history := range prop.Values {
+			values[i] = value(history.Value)
+		}
+		v, appender, err := fields.Value(valuesDataType(declared, values...))
+		if err != nil {
+			conversion = append(conversion, dataValueNotice(propertyName, err))
+			continue
+		}
 		t := fields.Time()
 		v.Name = "" // filled in with value below
+		var seriesNotices []data.Notice
 		for i, history := range prop.Values {
 			if timeValue, err := getTimeObjectFromStringTime(history.Time); err == nil {
 				t.Set(i, timeValue)
-				v.Set(i, conv(history.Value)) // cspell:disable-line
+				if err := appender.Set(i, values[i]); err != nil && seriesNotices == nil {
+					seriesNotices = append(seriesNotices, dataValueNotice(propertyName, err))
+				}
 			} else {
 				dr.Error = fmt.Errorf("error parsing timestamp while loading propertyValueHistory")
 			}
 		}
 
+		if query.AlignToResolution {
+			if d := sampleInterval(prop.Values); d > 0 {
+				t.Config = &data.FieldConfig{Interval: float64(d.Milliseconds())}
+				if d > interval {
+					interval = d
+				}
+			}
+		}
+
+		if opts := query.BooleanDisplay; opts != nil && opts.StateTimeline && v.Type() == data.FieldTypeNullableBool {
+			v.Config = stateTimelineConfig(*opts)
+		}
+		if propertyName == alarmStatusProperty && v.Type() == data.FieldTypeNullableString {
+			v.Config = alarmStatusConfig()
+		}
+
 		ref := prop.EntityPropertyReference
 		v.Labels = data.Labels{}
 		if ref.ComponentName != nil {
@@ -412,15 +591,12 @@ func (s *twinMakerHandler) processHistory(results *iottwinmaker.GetPropertyValue
 			v.Labels["entityId"] = *ref.EntityId
 		}
 		if ref.PropertyName != nil {
-			v.Name = *ref.PropertyName
 			v.Labels["propertyName"] = *ref.PropertyName
-			if ref.PropertyName != nil {
-				v.Name = *ref.PropertyName
-				var name, ok = query.PropertyDisplayNames[*ref.PropertyName]
-				if ok {
-					v.Name = name
-				}
+			name := *ref.PropertyName
+			if display, ok := query.PropertyDisplayNames[name]; ok {
+				name = display
 			}
+			nameSeries(v, query, ref, name)
 		}
 		if ref.ComponentName == nil || ref.EntityId == nil {
 			v.Labels["componentTypeId"] = query.ComponentTypeId
@@ -433,9 +609,27 @@ func (s *twinMakerHandler) processHistory(results *iottwinmaker.GetPropertyValue
 		}
 
 		frame := fields.ToFrame("", results.NextToken)
+		if ttl := cacheTTLHint(prop.Values); ttl > 0 {
+			meta := frame.Meta.Custom.(models.TwinMakerCustomMeta)
+			meta.CacheTTL = int(ttl.Seconds())
+			frame.Meta.Custom = meta
+		}
 		frame.AppendNotices(failures...)
+		frame.AppendNotices(seriesNotices...)
 		dr.Frames = append(dr.Frames, frame)
 	}
+	if query.AlignToResolution {
+		s.rememberInterval(query, interval)
+	}
+	// series that could not be converted are reported, or are the error when nothing converted
+	if len(conversion) > 0 {
+		if len(dr.Frames) == 0 {
+			dr.Error = fmt.Errorf("%s", conversion[0].Text)
+		} else {
+			dr.Frames[0].AppendNotices(conversion...)
+		}
+	}
+	downsampleHistory(&dr, query)
 	return
 }
 
@@ -446,11 +640,16 @@ func (s *twinMakerHandler) GetComponentHistory(ctx context.Context, query models
 		}
 	}
 
-	propertyReferences, failures, err := s.GetComponentHistoryWithLookup(ctx, query)
+	hints := s.propertyHints(ctx, query)
+	s.alignStart(&query, maxHintInterval(hints))
+	propertyReferences, nextToken, failures, err := s.GetComponentHistoryWithLookup(ctx, query)
 	result := &iottwinmaker.GetPropertyValueHistoryOutput{
-		NextToken:      nil,
+		NextToken:      nextToken,
 		PropertyValues: []*iottwinmaker.PropertyValueHistory{},
 	}
+	if nextToken != nil {
+		failures = append(failures, partialNotice(ctx, true))
+	}
 
 	for _, p := range propertyReferences {
 		propertyValue := iottwinmaker.PropertyValueHistory{
@@ -461,27 +660,142 @@ func (s *twinMakerHandler) GetComponentHistory(ctx context.Context, query models
 	}
 
 	// Return dataFrame with the history results and entityId and componentName
-	return s.processHistory(result, err, failures, query)
+	dr = s.processHistory(result, err, failures, query, hints)
+	applyFieldHints(&dr, hints)
+	s.explainEmptyHistory(ctx, query, &dr)
+	return dr
 }
 
 func (s *twinMakerHandler) GetEntityHistory(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
+	if len(query.EntityIds) > 0 {
+		return forEachEntity(ctx, query, func(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse {
+			return s.getEntityHistory(ctx, query, true)
+		})
+	}
+	return s.getEntityHistory(ctx, query, false)
+}
+
+// getEntityHistory loads the history of one entity, paging until the query deadline. Paged loads
+// the entities of multi-entity queries by property group, without a nextToken to continue from.
+func (s *twinMakerHandler) getEntityHistory(ctx context.Context, query models.TwinMakerQuery, paged bool) backend.DataResponse {
 	if query.EntityId == "" {
 		return backend.DataResponse{
 			Error: fmt.Errorf("missing entity parameter"),
 		}
 	}
-	result, err := s.client.GetPropertyValueHistory(ctx, query)
+	if query.Aggregate != "" {
+		// SiteWise aggregates are read from the asset of the component, they can not be filtered
+		// by property values or include deleted entities like the TwinMaker history
+		if len(query.PropertyFilter) > 0 {
+			return backend.DataResponse{
+				Error: fmt.Errorf("aggregate can not be combined with property filters"),
+			}
+		}
+		if query.IncludeDeletedEntities {
+			return backend.DataResponse{
+				Error: fmt.Errorf("aggregate can not be combined with includeDeletedEntities"),
+			}
+		}
+		return s.getAggregatedHistory(ctx, query)
+	}
+	// with deleted entities the component type is only used as the fallback lookup
+	componentTypeId := ""
+	if query.IncludeDeletedEntities {
+		componentTypeId = query.ComponentTypeId
+		query.ComponentTypeId = ""
+	}
+
+	hints := s.propertyHints(ctx, query)
+	s.alignStart(&query, maxHintInterval(hints))
+	var result *iottwinmaker.GetPropertyValueHistoryOutput
+	var err error
 	failures := []data.Notice{}
-	return s.processHistory(result, err, failures, query)
+	if groups := propertyGroups(query.Properties); (paged || query.Limit > 0 || len(groups) > 1) && query.NextToken == "" {
+		result, failures, err = s.getPropertyGroupHistory(ctx, query, groups)
+	} else {
+		// covers the time range unless the deadline or the call budget stop it, the nextToken
+		// continues from there
+		result, err = s.GetPropertyValueHistoryPaginated(ctx, query, nil)
+		if err == nil && result.NextToken != nil && !isPreview(ctx) {
+			failures = append(failures, partialNotice(ctx, true))
+		}
+	}
+	if query.IncludeDeletedEntities && isResourceNotFound(err) {
+		if componentTypeId == "" {
+			err = fmt.Errorf("entity %s not found, componentTypeId is required to load the history of a deleted entity", query.EntityId)
+		} else {
+			result, err = s.getDeletedEntityHistory(ctx, query, componentTypeId)
+			failures = append(failures, data.Notice{
+				Severity: data.NoticeSeverityInfo,
+				Text:     fmt.Sprintf("entity %s no longer exists, history loaded by componentTypeId %s", query.EntityId, componentTypeId),
+			})
+			if err == nil && result.NextToken != nil && !isPreview(ctx) {
+				failures = append(failures, partialNotice(ctx, true))
+			}
+		}
+	}
+	dr := s.processHistory(result, err, failures, query, hints)
+	applyFieldHints(&dr, hints)
+	if componentTypeId == "" {
+		s.explainEmptyHistory(ctx, query, &dr)
+	}
+	return dr
+}
+
+// TwinMaker keeps the time series of deleted entities, but they can only be read by component type.
+// This loads all pages of the component type history and keeps the values that belonged to the
+// entity. Component type histories have no entityId, their values are matched on the externalIds of
+// the query instead.
+func (s *twinMakerHandler) getDeletedEntityHistory(ctx context.Context, query models.TwinMakerQuery, componentTypeId string) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	if len(query.ExternalIds) == 0 {
+		return nil, fmt.Errorf("entity %s not found, externalIds are required to load the history of a deleted entity", query.EntityId)
+	}
+	entityId := query.EntityId
+	query.ComponentTypeId = componentTypeId
+	// pages are merged by externalIds, not only by property name
+	definitions := map[string]*iottwinmaker.PropertyDefinitionResponse{}
+	for key := range query.ExternalIds {
+		definitions[key] = &iottwinmaker.PropertyDefinitionResponse{IsExternalId: aws.Bool(true)}
+	}
+	result, err := s.GetPropertyValueHistoryPaginated(ctx, query, definitions)
+	if err != nil || result == nil {
+		return result, err
+	}
+
+	values := []*iottwinmaker.PropertyValueHistory{}
+	for _, v := range result.PropertyValues {
+		ref := v.EntityPropertyReference
+		if ref == nil || !matchesDeletedEntity(ref, entityId, query.ExternalIds) {
+			continue
+		}
+		if query.ComponentName != "" && ref.ComponentName != nil && *ref.ComponentName != query.ComponentName {
+			continue
+		}
+		// labeled like the values of the entity when it existed
+		ref.EntityId = aws.String(entityId)
+		values = append(values, v)
+	}
+	result.PropertyValues = values
+	return result, nil
+}
+
+// matchesDeletedEntity checks that a component type history reference has all externalIds, the
+// entityId is compared too in case the API returns it
+func matchesDeletedEntity(ref *iottwinmaker.EntityPropertyReference, entityId string, externalIds map[string]string) bool {
+	if ref.EntityId != nil && *ref.EntityId != entityId {
+		return false
+	}
+	for key, val := range externalIds {
+		if v, ok := ref.ExternalIdProperty[key]; !ok || v == nil || *v != val {
+			return false
+		}
+	}
+	return true
 }
 
 // Variation of GetComponentHistory for all alarm components that extend from the basic componentType
 func (s *twinMakerHandler) GetAlarms(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
 	failures := []data.Notice{}
-	alarmComponentType := "com.amazon.iottwinmaker.alarm.basic"
-	sitewiseAlarmComponentType := "com.amazon.iotsitewise.alarm"
-	externalIdKey := "alarm_key"
-	alarmProperty := "alarm_status"
 	isFiltered := len(query.PropertyFilter) > 0
 	var maxNoOfAlarms int
 	isLimited := false
@@ -490,71 +804,67 @@ func (s *twinMakerHandler) GetAlarms(ctx context.Context, query models.TwinMaker
 		maxNoOfAlarms = query.MaxResults
 	}
 
+	if err := validAlarmStatus(query.AlarmStatus); err != nil {
+		dr.Error = err
+		return
+	}
 	var filter []models.TwinMakerPropertyFilter
 	if isFiltered {
 		filter = query.PropertyFilter
 		query.PropertyFilter = nil
 	}
-
-	// Get all componentTypes that extend from the base alarm type
-	query.ComponentTypeId = alarmComponentType
-	basicComponentTypes, err := s.client.ListComponentTypes(ctx, query)
-	dr.Error = err
-	if err != nil {
-		return
-	}
-	if basicComponentTypes == nil {
-		dr.Error = fmt.Errorf("error loading componentTypes for GetAlarms query")
-		return
+	if query.AlarmStatus != "" {
+		// pushed down with the other filters of the alarm_status history
+		isFiltered = true
+		filter = append(filter, models.TwinMakerPropertyFilter{
+			Name:  alarmStatusProperty,
+			Op:    "=",
+			Value: models.TwinMakerFilterValue{StringValue: aws.String(query.AlarmStatus)},
+		})
 	}
 
-	// Get all componentTypes that extend from the sitewise alarm type
-	// list-component-types only support direct child extend checks currently
-	query.ComponentTypeId = sitewiseAlarmComponentType
-	sitewiseComponentTypes, err := s.client.ListComponentTypes(ctx, query)
+	// Get all componentTypes that extend from the base alarm type
+	componentTypeSummaryResults, err := s.alarmComponentTypes(ctx, query)
 	dr.Error = err
 	if err != nil {
 		return
 	}
-	if sitewiseComponentTypes == nil {
-		dr.Error = fmt.Errorf("error loading componentTypes for GetAlarms query")
-		return
-	}
-
-	componentTypeSummaryResults := basicComponentTypes.ComponentTypeSummaries
-	//remove sitewise alarm as it has no data to be fetched
-	index := 0
-	for _, summary := range componentTypeSummaryResults {
-		if *summary.ComponentTypeId != sitewiseAlarmComponentType {
-			componentTypeSummaryResults[index] = summary
-			index++
-		}
-	}
-	//slice off the last element now
-	componentTypeSummaryResults = componentTypeSummaryResults[:index]
-
-	componentTypeSummaryResults = append(componentTypeSummaryResults, sitewiseComponentTypes.ComponentTypeSummaries...)
 
 	// Get the propertyValueHistory associated with all componentTypes from above
 	var pValues []PropertyReference
+	// runbook of the component type of each alarm
+	var runbooks []string
+	hasRunbook := false
 
 	for _, componentTypeSummary := range componentTypeSummaryResults {
 		// Set mapping of alarm component types for quick lookup later
 		query.EntityId = ""
-		query.Properties = []*string{aws.String(alarmProperty)}
+		query.Properties = []*string{aws.String(alarmStatusProperty)}
 		query.ComponentTypeId = *componentTypeSummary.ComponentTypeId
 		query.Order = models.ResultOrderDesc
 		if isFiltered {
 			query.PropertyFilter = filter
 		}
 
-		propertyReferences, newFailures, err := s.GetLatestComponentHistoryWithLookup(ctx, query)
+		propertyReferences, nextToken, newFailures, err := s.GetLatestComponentHistoryWithLookup(ctx, query)
 		dr.Error = err
 		if err != nil {
 			return
 		}
 		failures = append(failures, newFailures...)
 		pValues = append(pValues, propertyReferences...)
+		if len(propertyReferences) > 0 {
+			runbook := s.alarmRunbook(ctx, query)
+			hasRunbook = hasRunbook || runbook != ""
+			for range propertyReferences {
+				runbooks = append(runbooks, runbook)
+			}
+		}
+		if nextToken != nil {
+			// the deadline is near, the remaining component types would not load in time
+			failures = append(failures, partialNotice(ctx, false))
+			break
+		}
 		if isLimited {
 			// update the queries' maxResults so we ask for less on the next iteration
 			query.MaxResults = maxNoOfAlarms - len(pValues)
@@ -572,37 +882,8 @@ func (s *twinMakerHandler) GetAlarms(ctx context.Context, query models.TwinMaker
 	eName := fields.Name()
 	eName.Name = "entityName"
 	status := fields.AlarmStatus()
-	status.Config = &data.FieldConfig{
-		DisplayName: "Status",
-		Custom: map[string]interface{}{
-			// Table panel
-			"displayMode": "color-text",
-		},
-		Mappings: data.ValueMappings{
-			data.ValueMapper{
-				"NORMAL": {
-					Color: "green",
-					Index: 0,
-					Text:  "NORMAL",
-				},
-				"ACTIVE": data.ValueMappingResult{
-					Color: "red",
-					Index: 1,
-					Text:  "ACTIVE",
-				},
-				"SNOOZE_DISABLED": {
-					Color: "orange",
-					Index: 2,
-					Text:  "SNOOZE_DISABLED",
-				},
-				"ACKNOWLEDGED": {
-					Color: "blue",
-					Index: 3,
-					Text:  "ACKNOWLEDGED",
-				},
-			},
-		},
-	}
+	status.Config = alarmStatusConfig()
+	status.Config.DisplayName = "Status"
 	t := fields.Time()
 
 	for i, propertyReference := range pValues {
@@ -616,10 +897,19 @@ func (s *twinMakerHandler) GetAlarms(ctx context.Context, query models.TwinMaker
 			status.Set(i, propertyReference.values[0].Value.StringValue)
 		}
 		name.Set(i, propertyReference.entityPropertyReference.ComponentName)
-		id.Set(i, propertyReference.entityPropertyReference.ExternalIdProperty[externalIdKey])
+		id.Set(i, propertyReference.entityPropertyReference.ExternalIdProperty[alarmExternalIdKey])
 		eId.Set(i, propertyReference.entityPropertyReference.EntityId)
 		eName.Set(i, propertyReference.entityName)
 	}
+	if hasRunbook {
+		runbook := fields.Runbook()
+		for i, url := range runbooks {
+			if url != "" {
+				runbook.Set(i, aws.String(url))
+			}
+		}
+		setRunbookLink(name)
+	}
 	frame := fields.ToFrame("", nil)
 	frame.AppendNotices(failures...)
 	dr.Frames = append(dr.Frames, frame)
@@ -627,6 +917,62 @@ func (s *twinMakerHandler) GetAlarms(ctx context.Context, query models.TwinMaker
 	return
 }
 
+// Mutation events (CreateEntity, UpdateComponentType etc) for the workspace as annotations
+func (s *twinMakerHandler) GetWorkspaceEvents(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	results, err := s.client.LookupWorkspaceEvents(ctx, query)
+	dr.Error = err
+	if err != nil {
+		return
+	}
+
+	if results == nil {
+		dr.Error = fmt.Errorf("error loading workspace events")
+		return
+	}
+
+	events := make([]*workspaceEvent, 0, len(results.Events))
+	for _, e := range results.Events {
+		if e.EventName == nil || e.EventTime == nil || e.CloudTrailEvent == nil {
+			continue
+		}
+		if !isMutationEvent(*e.EventName) {
+			continue
+		}
+		detail := workspaceEvent{}
+		if err := json.Unmarshal([]byte(*e.CloudTrailEvent), &detail); err != nil {
+			continue
+		}
+		if detail.RequestParameters.WorkspaceId != query.WorkspaceId {
+			continue
+		}
+		detail.event = e
+		events = append(events, &detail)
+	}
+
+	fields := newTwinMakerFrameBuilder(len(events))
+	t := fields.Time()
+	title := fields.Title()
+	text := fields.Text()
+	tags := fields.Tags()
+
+	for i, e := range events {
+		t.Set(i, e.event.EventTime)
+		title.Set(i, e.event.EventName)
+		text.Set(i, aws.String(e.describe()))
+		tags.Set(i, aws.String("twinmaker,"+*e.event.EventName))
+	}
+
+	frame := fields.ToFrame("", nil)
+	if results.NextToken != nil {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "Too many workspace events in the time range, only the most recent are shown",
+		})
+	}
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
+
 func (s *twinMakerHandler) GetSessionToken(ctx context.Context, duration time.Duration, workspaceId string) (models.TokenInfo, error) {
 	info := models.TokenInfo{}
 	credentials, err := s.client.GetSessionToken(ctx, duration, workspaceId)
@@ -645,6 +991,13 @@ func (s *twinMakerHandler) GetWriteSessionToken(ctx context.Context, duration ti
 	return SetInfo(credentials, info), err
 }
 
+func isResourceNotFound(err error) bool {
+	if aErr, ok := err.(awserr.Error); ok {
+		return aErr.Code() == iottwinmaker.ErrCodeResourceNotFoundException
+	}
+	return false
+}
+
 func HandleGetTokenError(err error) error {
 	if aErr, ok := err.(awserr.Error); ok {
 		log.DefaultLogger.Error("error getting session token", "code", aErr.Code(), "error", aErr)
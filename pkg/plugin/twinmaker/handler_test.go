@@ -2,10 +2,16 @@ package twinmaker
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -45,6 +51,22 @@ func TestHandleAWSData(t *testing.T) {
 		_ = runTest(t, client.path, &resp)
 	})
 
+	t.Run("run ListEntities handler with selected metadata", func(t *testing.T) {
+		client.path = "list-entities"
+		resp := handler.ListEntities(context.Background(), models.TwinMakerQuery{
+			EntityMetadata: []models.EntityMetadataColumn{models.EntityMetadataUpdated, models.EntityMetadataArn},
+		})
+		require.NoError(t, resp.Error)
+		names := []string{}
+		for _, f := range resp.Frames[0].Fields {
+			names = append(names, f.Name)
+		}
+		require.Equal(t, []string{"entityId", "name", "updated", "arn"}, names)
+
+		resp = handler.ListEntities(context.Background(), models.TwinMakerQuery{EntityMetadata: []models.EntityMetadataColumn{"owner"}})
+		require.Error(t, resp.Error)
+	})
+
 	t.Run("run ListComponentTypes handler", func(t *testing.T) {
 		client.path = "list-component-types"
 		resp := handler.ListComponentTypes(context.Background(), models.TwinMakerQuery{})
@@ -57,6 +79,20 @@ func TestHandleAWSData(t *testing.T) {
 		_ = runTest(t, client.path, &resp)
 	})
 
+	t.Run("run GetEntity handler with selected metadata", func(t *testing.T) {
+		client.path = "get-entity"
+		resp := handler.GetEntity(context.Background(), models.TwinMakerQuery{
+			EntityMetadata: []models.EntityMetadataColumn{models.EntityMetadataDescription, models.EntityMetadataCreated},
+		})
+		require.NoError(t, resp.Error)
+		frame := resp.Frames[0]
+		description, _ := frame.FieldByName("entityDescription")
+		require.NotNil(t, description)
+		created, _ := frame.FieldByName("created")
+		require.NotNil(t, created)
+		require.False(t, created.At(0).(time.Time).IsZero())
+	})
+
 	t.Run("run GetPropertyValue handler", func(t *testing.T) {
 		client.path = "get-property-value"
 		resp := handler.GetPropertyValue(context.Background(), models.TwinMakerQuery{})
@@ -111,6 +147,21 @@ func TestHandleAWSData(t *testing.T) {
 		require.Equal(t, labels, dr.Frames[0].Fields[0].Labels)
 	})
 
+	t.Run("run GetPropertyValue handler for athena connector with selected properties", func(t *testing.T) {
+		client.path = "get-property-value-athena"
+		resp := handler.GetPropertyValue(context.Background(), models.TwinMakerQuery{
+			WorkspaceId:        "tabular-test-1",
+			EntityId:           "1b480741-1ac9-4c28-ac0e-f815b4bb3347",
+			ComponentName:      "TabularComponent",
+			PropertyGroupName:  "tabularPropertyGroup",
+			SelectedProperties: []string{"floc", "crit"},
+		})
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames[0].Fields, 2)
+		require.Equal(t, "crit", resp.Frames[0].Fields[0].Name)
+		require.Equal(t, "floc", resp.Frames[0].Fields[1].Name)
+	})
+
 	t.Run("run GetEntityHistory handler", func(t *testing.T) {
 		client.path = "get-property-history-alarms"
 		resp := handler.GetEntityHistory(context.Background(), models.TwinMakerQuery{
@@ -126,6 +177,16 @@ func TestHandleAWSData(t *testing.T) {
 		require.Equal(t, labels, dr.Frames[0].Fields[0].Labels)
 	})
 
+	t.Run("run GetWorkspaceEvents handler", func(t *testing.T) {
+		client.path = "workspace-events"
+		resp := handler.GetWorkspaceEvents(context.Background(), models.TwinMakerQuery{
+			WorkspaceId: "AlarmWorkspace",
+		})
+		dr := runTest(t, client.path, &resp)
+		// read-only and other workspace events are dropped
+		require.Equal(t, 2, dr.Frames[0].Rows())
+	})
+
 	t.Run("run GetComponentHistory handler w id", func(t *testing.T) {
 		t.Skip()
 		// cannot use the mock client here since this uses different API calls
@@ -135,7 +196,7 @@ func TestHandleAWSData(t *testing.T) {
 				AuthType: awsds.AuthTypeDefault,
 				Region:   "us-east-1",
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 		handler := NewTwinMakerHandler(c)
 
@@ -182,7 +243,7 @@ func TestHandleAWSData(t *testing.T) {
 				AuthType: awsds.AuthTypeDefault,
 				Region:   "us-east-1",
 			},
-		})
+		}, nil)
 		require.NoError(t, err)
 		handler := NewTwinMakerHandler(c)
 
@@ -207,8 +268,325 @@ func TestHandleAWSData(t *testing.T) {
 	})
 }
 
+// lookupMockClient serves the componentType history calls from different saved responses
+type lookupMockClient struct {
+	*twinMakerMockClient
+	entities *iottwinmaker.ListEntitiesOutput
+}
+
+func (c *lookupMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	r := &iottwinmaker.GetComponentTypeOutput{}
+	c.path = "get-component-type"
+	_, err := c.loadSavedResponse(r)
+	return r, err
+}
+
+func (c *lookupMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	r := &iottwinmaker.GetPropertyValueHistoryOutput{}
+	c.path = "get-property-history-alarms-w-id"
+	_, err := c.loadSavedResponse(r)
+	r.NextToken = nil
+	return r, err
+}
+
+func (c *lookupMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return c.entities, nil
+}
+
+func TestComponentHistoryLookup(t *testing.T) {
+	mock, err := NewTwinMakerMockClient("")
+	require.NoError(t, err)
+
+	t.Run("unresolved externalIds are labeled by externalId", func(t *testing.T) {
+		client := &lookupMockClient{twinMakerMockClient: mock, entities: &iottwinmaker.ListEntitiesOutput{}}
+		handler := NewTwinMakerHandler(client)
+		resp := handler.GetComponentHistory(context.Background(), models.TwinMakerQuery{
+			WorkspaceId:     "AlarmWorkspace",
+			ComponentTypeId: "com.example.cookiefactory.alarm",
+			Properties:      []*string{aws.String("alarm_status")},
+		})
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 4)
+
+		v := resp.Frames[0].Fields[0]
+		require.Equal(t, "com.example.cookiefactory.alarm", v.Labels["componentTypeId"])
+		require.Equal(t, "Mixer_10_54205d7c-1407-4f17-8b38-188e437e8d9d", v.Labels["alarm_key"])
+		require.Equal(t, data.NoticeSeverityInfo, resp.Frames[0].Meta.Notices[0].Severity)
+	})
+
+	t.Run("lookups run concurrently in the order of the results", func(t *testing.T) {
+		client := &concurrentLookupMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+		handler := newTwinMakerHandler(client, nil)
+
+		refs, _, notices, err := handler.GetComponentHistoryWithLookup(context.Background(), models.TwinMakerQuery{
+			WorkspaceId:     "AlarmWorkspace",
+			ComponentTypeId: "com.example.alarm",
+			Properties:      []*string{aws.String("alarm_status")},
+		})
+		require.NoError(t, err)
+		require.Greater(t, client.maxInflight, 1)
+		require.LessOrEqual(t, client.maxInflight, maxEntityLookups)
+
+		// in the order of the history results
+		require.Len(t, refs, 2*maxEntityLookups)
+		for i, ref := range refs {
+			if i == 3 {
+				require.Nil(t, ref.entityPropertyReference.EntityId)
+				continue
+			}
+			require.Equal(t, fmt.Sprintf("entity-a%d", i), *ref.entityPropertyReference.EntityId)
+			require.Equal(t, "Alarm", *ref.entityPropertyReference.ComponentName)
+		}
+		require.Len(t, notices, 1)
+		require.Contains(t, notices[0].Text, "externalId a3")
+	})
+
+	t.Run("resolved externalIds are reused until invalidated", func(t *testing.T) {
+		client := &concurrentLookupMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+		handler := newTwinMakerHandler(client, nil)
+		query := models.TwinMakerQuery{
+			WorkspaceId:     "AlarmWorkspace",
+			ComponentTypeId: "com.example.alarm",
+			Properties:      []*string{aws.String("alarm_status")},
+		}
+
+		_, _, _, err := handler.GetComponentHistoryWithLookup(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, 2*maxEntityLookups, client.lookups)
+
+		// only the unresolved a3 is looked up again
+		refs, _, _, err := handler.GetComponentHistoryWithLookup(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, 2*maxEntityLookups+1, client.lookups)
+		require.Equal(t, "entity-a0", *refs[0].entityPropertyReference.EntityId)
+		require.Equal(t, "Alarm", *refs[0].entityPropertyReference.ComponentName)
+		require.Equal(t, "Entity a0", *refs[0].entityName)
+
+		require.Equal(t, 0, handler.externalIds.invalidate("OtherWorkspace"))
+		require.Equal(t, 2*maxEntityLookups-1, handler.externalIds.invalidate("AlarmWorkspace"))
+		_, _, _, err = handler.GetComponentHistoryWithLookup(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, 4*maxEntityLookups+1, client.lookups)
+	})
+
+	t.Run("invalidated externalIds are not resolved from the metadata cache", func(t *testing.T) {
+		client := &concurrentLookupMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+		handler := newTwinMakerHandler(NewCachingClient(client, time.Hour), nil)
+		query := models.TwinMakerQuery{
+			WorkspaceId:     "AlarmWorkspace",
+			ComponentTypeId: "com.example.alarm",
+			Properties:      []*string{aws.String("alarm_status")},
+		}
+
+		_, _, _, err := handler.GetComponentHistoryWithLookup(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, 2*maxEntityLookups, client.lookups)
+
+		handler.externalIds.invalidate("AlarmWorkspace")
+		_, _, _, err = handler.GetComponentHistoryWithLookup(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, 4*maxEntityLookups, client.lookups)
+	})
+}
+
+// concurrentLookupMockClient resolves many externalIds slowly and records the concurrent lookups
+type concurrentLookupMockClient struct {
+	*twinMakerMockClient
+	mu          sync.Mutex
+	inflight    int
+	maxInflight int
+	lookups     int
+}
+
+func (c *concurrentLookupMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_id": {IsExternalId: aws.Bool(true)},
+	}}, nil
+}
+
+func (c *concurrentLookupMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	rsp := &iottwinmaker.GetPropertyValueHistoryOutput{}
+	for i := 0; i < 2*maxEntityLookups; i++ {
+		rsp.PropertyValues = append(rsp.PropertyValues, &iottwinmaker.PropertyValueHistory{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				PropertyName:       aws.String("alarm_status"),
+				ExternalIdProperty: map[string]*string{"alarm_id": aws.String(fmt.Sprintf("a%d", i))},
+			},
+			Values: []*iottwinmaker.PropertyValue{{
+				Time:  aws.String("2022-04-27T10:00:00Z"),
+				Value: &iottwinmaker.DataValue{StringValue: aws.String("ACTIVE")},
+			}},
+		})
+	}
+	return rsp, nil
+}
+
+func (c *concurrentLookupMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	c.mu.Lock()
+	c.lookups++
+	c.inflight++
+	if c.inflight > c.maxInflight {
+		c.maxInflight = c.inflight
+	}
+	c.mu.Unlock()
+	// later lookups finish first
+	externalId := query.ListEntitiesFilter[0].ExternalId
+	n, _ := strconv.Atoi(strings.TrimPrefix(externalId, "a"))
+	time.Sleep(time.Duration(2*maxEntityLookups-n) * time.Millisecond)
+	c.mu.Lock()
+	c.inflight--
+	c.mu.Unlock()
+
+	if externalId == "a3" {
+		return &iottwinmaker.ListEntitiesOutput{}, nil
+	}
+	return &iottwinmaker.ListEntitiesOutput{EntitySummaries: []*iottwinmaker.EntitySummary{{
+		EntityId:   aws.String("entity-" + externalId),
+		EntityName: aws.String("Entity " + externalId),
+	}}}, nil
+}
+
+func (c *concurrentLookupMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{Components: map[string]*iottwinmaker.ComponentResponse{
+		"Alarm": {
+			ComponentName:   aws.String("Alarm"),
+			ComponentTypeId: aws.String("com.example.alarm"),
+			Properties: map[string]*iottwinmaker.PropertyResponse{
+				"alarm_id": {
+					Definition: &iottwinmaker.PropertyDefinitionResponse{IsExternalId: aws.Bool(true)},
+					Value:      &iottwinmaker.DataValue{StringValue: aws.String(strings.TrimPrefix(query.EntityId, "entity-"))},
+				},
+			},
+		},
+	}}, nil
+}
+
 func runTest(t *testing.T, name string, dr *backend.DataResponse) *backend.DataResponse {
 	experimental.CheckGoldenJSONResponse(t, "./testdata", name+".golden", dr, true)
 
 	return dr
 }
+
+type deletedEntityMockClient struct {
+	*twinMakerMockClient
+}
+
+// GetPropertyValueHistory answers component type queries like the API: the references have no
+// entityId, only the externalIds of the components, and the values come in two pages
+func (c *deletedEntityMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	if query.ComponentTypeId == "" {
+		return nil, awserr.New(iottwinmaker.ErrCodeResourceNotFoundException, "entity not found", nil)
+	}
+	history := func(assetId string, time string, value float64) *iottwinmaker.PropertyValueHistory {
+		return &iottwinmaker.PropertyValueHistory{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				ExternalIdProperty: map[string]*string{"sitewiseAssetId": aws.String(assetId)},
+				PropertyName:       aws.String("Temperature"),
+			},
+			Values: []*iottwinmaker.PropertyValue{{
+				Time:  aws.String(time),
+				Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(value)},
+			}},
+		}
+	}
+	if query.NextToken == "" {
+		return &iottwinmaker.GetPropertyValueHistoryOutput{
+			PropertyValues: []*iottwinmaker.PropertyValueHistory{history("asset-0", "2022-04-27T00:00:00Z", 20), history("asset-1", "2022-04-27T00:00:00Z", 21)},
+			NextToken:      aws.String("page-2"),
+		}, nil
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{history("asset-1", "2022-04-27T00:01:00Z", 22), history("asset-0", "2022-04-27T00:01:00Z", 23)},
+	}, nil
+}
+
+func TestDeletedEntityHistory(t *testing.T) {
+	handler := NewTwinMakerHandler(&deletedEntityMockClient{twinMakerMockClient: &twinMakerMockClient{}})
+	query := models.TwinMakerQuery{
+		EntityId:        "Mixer_1",
+		ComponentName:   "MixerComponent",
+		ComponentTypeId: "com.example.cookiefactory.mixer",
+		Properties:      []*string{aws.String("Temperature")},
+	}
+
+	t.Run("fails without the option", func(t *testing.T) {
+		q := query
+		q.ComponentTypeId = ""
+		dr := handler.GetEntityHistory(context.Background(), q)
+		require.Error(t, dr.Error)
+	})
+
+	t.Run("loads the history by component type", func(t *testing.T) {
+		q := query
+		q.IncludeDeletedEntities = true
+		q.ExternalIds = map[string]string{"sitewiseAssetId": "asset-1"}
+		dr := handler.GetEntityHistory(context.Background(), q)
+		require.NoError(t, dr.Error)
+		require.Len(t, dr.Frames, 1)
+		require.Equal(t, 2, dr.Frames[0].Rows())
+		value := dr.Frames[0].Fields[0]
+		require.Equal(t, "Mixer_1", value.Labels["entityId"])
+		require.Equal(t, 21.0, *value.At(0).(*float64))
+		require.Equal(t, 22.0, *value.At(1).(*float64))
+		require.Len(t, dr.Frames[0].Meta.Notices, 1)
+	})
+
+	t.Run("requires the externalIds", func(t *testing.T) {
+		q := query
+		q.IncludeDeletedEntities = true
+		dr := handler.GetEntityHistory(context.Background(), q)
+		require.ErrorContains(t, dr.Error, "externalIds are required")
+	})
+
+	t.Run("requires a component type", func(t *testing.T) {
+		q := query
+		q.IncludeDeletedEntities = true
+		q.ComponentTypeId = ""
+		dr := handler.GetEntityHistory(context.Background(), q)
+		require.ErrorContains(t, dr.Error, "componentTypeId is required")
+	})
+}
+
+type booleanHistoryMockClient struct {
+	*twinMakerMockClient
+}
+
+func (c *booleanHistoryMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(query.EntityId),
+				ComponentName: aws.String(query.ComponentName),
+				PropertyName:  aws.String("Running"),
+			},
+			Values: []*iottwinmaker.PropertyValue{
+				{Time: aws.String("2022-04-27T00:00:00Z"), Value: &iottwinmaker.DataValue{BooleanValue: aws.Bool(true)}},
+				{Time: aws.String("2022-04-27T00:01:00Z"), Value: &iottwinmaker.DataValue{BooleanValue: aws.Bool(false)}},
+			},
+		}},
+	}, nil
+}
+
+func TestBooleanStateTimeline(t *testing.T) {
+	handler := NewTwinMakerHandler(&booleanHistoryMockClient{twinMakerMockClient: &twinMakerMockClient{}})
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Running")},
+	}
+
+	dr := handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Nil(t, dr.Frames[0].Fields[0].Config)
+
+	query.BooleanDisplay = &models.TwinMakerBooleanDisplay{StateTimeline: true, TrueLabel: "Running", FalseColor: "gray"}
+	dr = handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	config := dr.Frames[0].Fields[0].Config
+	require.NotNil(t, config)
+	mapper := config.Mappings[0].(data.ValueMapper)
+	require.Equal(t, "Running", mapper["true"].Text)
+	require.Equal(t, "green", mapper["true"].Color)
+	require.Equal(t, "false", mapper["false"].Text)
+	require.Equal(t, "gray", mapper["false"].Color)
+}
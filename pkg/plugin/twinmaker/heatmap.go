@@ -0,0 +1,221 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultHeatmapBuckets = 60
+	maxHeatmapBuckets     = 1000
+)
+
+// heatmapBucketSize is the requested bucket size, widened so the range has at most maxHeatmapBuckets
+func heatmapBucketSize(query models.TwinMakerQuery, expected time.Duration) time.Duration {
+	span := query.TimeRange.To.Sub(query.TimeRange.From)
+	size := time.Duration(query.BucketSeconds) * time.Second
+	if size <= 0 {
+		size = span / defaultHeatmapBuckets
+		// default buckets are never shorter than the expected interval of the values
+		if size < expected {
+			size = expected
+		}
+	}
+	if minSize := span / maxHeatmapBuckets; size < minSize {
+		size = minSize
+	}
+	if size < time.Second {
+		size = time.Second
+	}
+	// whole seconds, rounded up to stay within maxHeatmapBuckets
+	return (size + time.Second - 1).Truncate(time.Second)
+}
+
+// heatmapValue is the numeric value of a data value, booleans are 1 or 0
+func heatmapValue(v *iottwinmaker.DataValue) (float64, bool) {
+	switch {
+	case v == nil:
+		return 0, false
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, true
+	case v.LongValue != nil:
+		return float64(*v.LongValue), true
+	case v.IntegerValue != nil:
+		return float64(*v.IntegerValue), true
+	case v.BooleanValue != nil:
+		if *v.BooleanValue {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type heatmapBucket struct {
+	count    int
+	sum      float64
+	min, max float64
+	last     float64
+	lastTime time.Time
+}
+
+func (b *heatmapBucket) add(t time.Time, v float64) {
+	if b.count == 0 || v < b.min {
+		b.min = v
+	}
+	if b.count == 0 || v > b.max {
+		b.max = v
+	}
+	if b.count == 0 || !t.Before(b.lastTime) {
+		b.last = v
+		b.lastTime = t
+	}
+	b.count++
+	b.sum += v
+}
+
+func (b *heatmapBucket) value(aggregation models.HeatmapAggregation) *float64 {
+	if b == nil || b.count == 0 {
+		return nil
+	}
+	v := b.sum / float64(b.count)
+	switch aggregation {
+	case models.HeatmapMin:
+		v = b.min
+	case models.HeatmapMax:
+		v = b.max
+	case models.HeatmapLast:
+		v = b.last
+	case models.HeatmapCount:
+		v = float64(b.count)
+	}
+	return &v
+}
+
+// GetPropertyHeatmap buckets a single property of all entities with the component type into
+// a wide frame: the bucket start times and one field per entity
+func (s *twinMakerHandler) GetPropertyHeatmap(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.ComponentTypeId == "" {
+		dr.Error = fmt.Errorf("missing component parameter")
+		return
+	}
+	if len(query.Properties) != 1 || query.Properties[0] == nil {
+		dr.Error = fmt.Errorf("heatmap queries need exactly one property")
+		return
+	}
+	property := *query.Properties[0]
+	if s.redaction.action(property) != "" {
+		dr.Error = fmt.Errorf("property %s is redacted", property)
+		return
+	}
+	hints := s.propertyHints(ctx, query)
+	if query.Aggregation == "" {
+		query.Aggregation = hints[property].aggregation
+	}
+	switch query.Aggregation {
+	case "", models.HeatmapAvg, models.HeatmapMin, models.HeatmapMax, models.HeatmapLast, models.HeatmapCount:
+	default:
+		dr.Error = fmt.Errorf("unknown aggregation %q", query.Aggregation)
+		return
+	}
+
+	propertyReferences, nextToken, failures, err := s.GetComponentHistoryWithLookup(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+	if nextToken != nil {
+		failures = append(failures, partialNotice(ctx, false))
+	}
+
+	size := heatmapBucketSize(query, hints[property].interval)
+	from := query.TimeRange.From.Truncate(size)
+	count := int(math.Ceil(float64(query.TimeRange.To.Sub(from)) / float64(size)))
+	if count < 1 {
+		count = 1
+	}
+
+	type series struct {
+		name    string
+		labels  data.Labels
+		buckets []*heatmapBucket
+	}
+	all := make([]*series, 0, len(propertyReferences))
+	skipped := 0
+	for _, p := range propertyReferences {
+		ref := p.entityPropertyReference
+		row := &series{labels: data.Labels{"propertyName": property}, buckets: make([]*heatmapBucket, count)}
+		if ref.EntityId != nil {
+			row.name = *ref.EntityId
+			row.labels["entityId"] = *ref.EntityId
+		}
+		if query.FieldNaming == models.FieldNamingStableV1 {
+			row.name = stableSeriesName(ref)
+		} else if p.entityName != nil {
+			row.name = *p.entityName
+		}
+		if ref.ComponentName != nil {
+			row.labels["componentName"] = *ref.ComponentName
+		}
+
+		for _, value := range p.values {
+			t, err := getTimeObjectFromStringTime(value.Time)
+			if err != nil {
+				continue
+			}
+			v, ok := heatmapValue(value.Value)
+			if !ok {
+				skipped++
+				continue
+			}
+			i := int(t.Sub(from) / size)
+			if i < 0 || i >= count {
+				continue
+			}
+			if row.buckets[i] == nil {
+				row.buckets[i] = &heatmapBucket{}
+			}
+			row.buckets[i].add(*t, v)
+		}
+		all = append(all, row)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].name < all[j].name
+	})
+
+	fields := newTwinMakerFrameBuilder(count)
+	t := fields.Time()
+	for i := 0; i < count; i++ {
+		bucket := from.Add(time.Duration(i) * size)
+		t.Set(i, &bucket)
+	}
+	for _, row := range all {
+		f := data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, count)
+		for i, b := range row.buckets {
+			f.Set(i, b.value(query.Aggregation))
+		}
+		f.Labels = row.labels
+		fields.add(f, row.name)
+	}
+
+	if skipped > 0 {
+		failures = append(failures, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%d non numeric values were skipped", skipped),
+		})
+	}
+	t.Config = &data.FieldConfig{Interval: float64(size.Milliseconds())}
+	frame := fields.ToFrame("heatmap", nil)
+	frame.AppendNotices(failures...)
+	dr.Frames = append(dr.Frames, frame)
+	applyFieldHints(&dr, hints)
+	return
+}
@@ -0,0 +1,107 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type heatmapMockClient struct {
+	*twinMakerMockClient
+}
+
+func (c *heatmapMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"mixer_id": {IsExternalId: aws.Bool(true)},
+	}}, nil
+}
+
+func (c *heatmapMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return &iottwinmaker.ListEntitiesOutput{}, nil
+}
+
+func (c *heatmapMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	history := func(entityId string, values ...float64) *iottwinmaker.PropertyValueHistory {
+		h := &iottwinmaker.PropertyValueHistory{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(entityId),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Temperature"),
+			},
+		}
+		// one value every 20 seconds from 00:00
+		for i, v := range values {
+			h.Values = append(h.Values, &iottwinmaker.PropertyValue{
+				Time:  aws.String(time.Date(2022, 4, 27, 0, 0, 20*i, 0, time.UTC).Format(time.RFC3339)),
+				Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(v)},
+			})
+		}
+		return h
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{
+			history("Mixer_1", 10, 20, 30, 40),
+			history("Mixer_0", 1, 2, 3),
+		},
+	}, nil
+}
+
+func TestPropertyHeatmap(t *testing.T) {
+	handler := NewTwinMakerHandler(&heatmapMockClient{twinMakerMockClient: &twinMakerMockClient{}})
+	query := models.TwinMakerQuery{
+		ComponentTypeId: "com.example.mixer",
+		Properties:      []*string{aws.String("Temperature")},
+		BucketSeconds:   60,
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 27, 0, 3, 0, 0, time.UTC),
+		},
+	}
+
+	dr := handler.GetPropertyHeatmap(context.Background(), query)
+	require.NoError(t, dr.Error)
+	frame := dr.Frames[0]
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, 3, frame.Rows())
+	require.Equal(t, time.Date(2022, 4, 27, 0, 1, 0, 0, time.UTC), *frame.Fields[0].At(1).(*time.Time))
+
+	// sorted by entity
+	mixer0, mixer1 := frame.Fields[1], frame.Fields[2]
+	require.Equal(t, "Mixer_0", mixer0.Name)
+	require.Equal(t, "Mixer_0", mixer0.Labels["entityId"])
+	require.Equal(t, 2.0, *mixer0.At(0).(*float64))
+	require.Nil(t, mixer0.At(1))
+	require.Equal(t, 20.0, *mixer1.At(0).(*float64))
+	require.Equal(t, 40.0, *mixer1.At(1).(*float64))
+	require.Nil(t, mixer1.At(2))
+
+	query.Aggregation = models.HeatmapCount
+	dr = handler.GetPropertyHeatmap(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, 3.0, *dr.Frames[0].Fields[2].At(0).(*float64))
+
+	query.Aggregation = "median"
+	require.Error(t, handler.GetPropertyHeatmap(context.Background(), query).Error)
+
+	query.Aggregation = ""
+	query.Properties = nil
+	require.Error(t, handler.GetPropertyHeatmap(context.Background(), query).Error)
+}
+
+func TestHeatmapBucketSize(t *testing.T) {
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)}}
+	require.Equal(t, time.Minute, heatmapBucketSize(query, 0))
+
+	query.BucketSeconds = 1
+	require.Equal(t, 4*time.Second, heatmapBucketSize(query, 0)) // 3.6s for 1000 buckets
+
+	query.BucketSeconds = 300
+	require.Equal(t, 5*time.Minute, heatmapBucketSize(query, 0))
+}
@@ -0,0 +1,138 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultHistogramBuckets = 20
+	maxHistogramBuckets     = 500
+)
+
+// histogramBuckets is the requested number of buckets within the limits
+func histogramBuckets(query models.TwinMakerQuery) int {
+	switch n := query.HistogramBuckets; {
+	case n <= 0:
+		return defaultHistogramBuckets
+	case n > maxHistogramBuckets:
+		return maxHistogramBuckets
+	default:
+		return n
+	}
+}
+
+// GetPropertyHistogram bins the values of a single property over the time range into equal width
+// buckets between the smallest and the largest value. The frame has the xMin and xMax bucket
+// bounds the histogram panel reads and one count field per series.
+func (s *twinMakerHandler) GetPropertyHistogram(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" && query.ComponentTypeId == "" {
+		dr.Error = fmt.Errorf("missing entity or component type parameter")
+		return
+	}
+	if len(query.Properties) != 1 || query.Properties[0] == nil {
+		dr.Error = fmt.Errorf("histogram queries need exactly one property")
+		return
+	}
+	property := *query.Properties[0]
+	if s.redaction.action(property) != "" {
+		dr.Error = fmt.Errorf("property %s is redacted", property)
+		return
+	}
+
+	propertyReferences, failures, err := s.getHistoryReferences(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	type series struct {
+		name   string
+		labels data.Labels
+		values []float64
+	}
+	all := make([]*series, 0, len(propertyReferences))
+	min, max := math.Inf(1), math.Inf(-1)
+	skipped := 0
+	for _, p := range propertyReferences {
+		ref := p.entityPropertyReference
+		row := &series{name: property, labels: data.Labels{"propertyName": property}}
+		if ref.EntityId != nil {
+			row.name = *ref.EntityId
+			row.labels["entityId"] = *ref.EntityId
+		}
+		if query.FieldNaming == models.FieldNamingStableV1 {
+			row.name = stableSeriesName(ref)
+		} else if p.entityName != nil {
+			row.name = *p.entityName
+		}
+		if ref.ComponentName != nil {
+			row.labels["componentName"] = *ref.ComponentName
+		}
+		for _, value := range p.values {
+			v, ok := heatmapValue(value.Value)
+			if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+				skipped++
+				continue
+			}
+			row.values = append(row.values, v)
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+		if len(row.values) > 0 {
+			all = append(all, row)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].name < all[j].name
+	})
+
+	count := histogramBuckets(query)
+	width := (max - min) / float64(count)
+	if len(all) == 0 {
+		count = 0
+	} else if width == 0 {
+		// all values are equal
+		count, width = 1, 1
+	}
+
+	fields := newTwinMakerFrameBuilder(count)
+	xMin := fields.add(data.NewFieldFromFieldType(data.FieldTypeFloat64, count), "xMin")
+	xMax := fields.add(data.NewFieldFromFieldType(data.FieldTypeFloat64, count), "xMax")
+	for i := 0; i < count; i++ {
+		xMin.Set(i, min+float64(i)*width)
+		xMax.Set(i, min+float64(i+1)*width)
+	}
+	if count > 0 {
+		// the last bucket ends exactly at the largest value
+		xMax.Set(count-1, math.Max(max, min+width))
+	}
+	for _, row := range all {
+		counts := make([]float64, count)
+		for _, v := range row.values {
+			i := int((v - min) / width)
+			if i >= count {
+				i = count - 1
+			}
+			counts[i]++
+		}
+		fields.add(data.NewField(row.name, row.labels, counts), row.name)
+	}
+
+	if skipped > 0 {
+		failures = append(failures, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%d non numeric values were skipped", skipped),
+		})
+	}
+	frame := fields.ToFrame("histogram", nil)
+	frame.AppendNotices(failures...)
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
@@ -0,0 +1,79 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type histogramMockClient struct {
+	*twinMakerMockClient
+	values []float64
+}
+
+func (c *histogramMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	start := time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)
+	values := []*iottwinmaker.PropertyValue{}
+	for i, v := range c.values {
+		values = append(values, &iottwinmaker.PropertyValue{
+			Time:  aws.String(start.Add(time.Duration(i) * time.Second).Format(time.RFC3339)),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(v)},
+		})
+	}
+	values = append(values, &iottwinmaker.PropertyValue{
+		Time:  aws.String(start.Format(time.RFC3339)),
+		Value: &iottwinmaker.DataValue{StringValue: aws.String("n/a")},
+	})
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String("Mixer_0"),
+				ComponentName: aws.String("MixerComponent"),
+				PropertyName:  aws.String("Vibration"),
+			},
+			Values: values,
+		}},
+	}, nil
+}
+
+func TestGetPropertyHistogram(t *testing.T) {
+	client := &histogramMockClient{twinMakerMockClient: &twinMakerMockClient{}, values: []float64{0, 1, 2.5, 4, 9.9, 10}}
+	handler := newTwinMakerHandler(client, nil)
+	query := models.TwinMakerQuery{
+		EntityId:         "Mixer_0",
+		Properties:       []*string{aws.String("Vibration")},
+		HistogramBuckets: 4,
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	dr := handler.GetPropertyHistogram(context.Background(), query)
+	require.NoError(t, dr.Error)
+	frame := dr.Frames[0]
+	require.Equal(t, 4, frame.Rows())
+	xMin, _ := frame.FieldByName("xMin")
+	xMax, _ := frame.FieldByName("xMax")
+	counts, _ := frame.FieldByName("Mixer_0")
+	require.Equal(t, []float64{0, 2.5, 5, 7.5}, []float64{xMin.At(0).(float64), xMin.At(1).(float64), xMin.At(2).(float64), xMin.At(3).(float64)})
+	require.Equal(t, float64(10), xMax.At(3))
+	// the largest value is in the last bucket, strings are skipped
+	require.Equal(t, []float64{2, 2, 0, 2}, []float64{counts.At(0).(float64), counts.At(1).(float64), counts.At(2).(float64), counts.At(3).(float64)})
+	require.Equal(t, "1 non numeric values were skipped", frame.Meta.Notices[0].Text)
+
+	// equal values are one bucket
+	client.values = []float64{3, 3}
+	dr = handler.GetPropertyHistogram(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, 1, dr.Frames[0].Rows())
+
+	query.Properties = nil
+	require.Error(t, handler.GetPropertyHistogram(context.Background(), query).Error)
+}
@@ -0,0 +1,110 @@
+package twinmaker
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxInterpolatedPageSize is the most values GetInterpolatedAssetPropertyValues returns per page
+const maxInterpolatedPageSize = 250
+
+// interpolationInterval is the spacing of the interpolated values, the interval of the panel or
+// the one that keeps the time range within MaxDataPoints, in whole seconds of at least one
+func interpolationInterval(query models.TwinMakerQuery) time.Duration {
+	size := query.Interval
+	if size <= 0 && query.MaxDataPoints > 0 {
+		size = query.TimeRange.To.Sub(query.TimeRange.From) / time.Duration(query.MaxDataPoints)
+	}
+	size = (size + time.Second - 1).Truncate(time.Second)
+	if size < time.Second {
+		size = time.Second
+	}
+	return size
+}
+
+// interpolationType interpolates numbers linearly, other values carry the last value forward
+func interpolationType(dataType string) string {
+	switch dataType {
+	case iotsitewise.PropertyDataTypeDouble, iotsitewise.PropertyDataTypeInteger:
+		return "LINEAR_INTERPOLATION"
+	}
+	return "LOCF_INTERPOLATION"
+}
+
+// GetInterpolatedHistory loads values of the properties of a component of the SiteWise connector at
+// every interpolationInterval of the time range. Paging stops at the query deadline or the history
+// call budget like the TwinMaker history.
+func (s *twinMakerHandler) GetInterpolatedHistory(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	interval := interpolationInterval(query)
+	results, failures, err := s.sitewiseHistory(ctx, query, func(assetId string, property *iotsitewise.AssetProperty) ([]*iottwinmaker.PropertyValue, bool, error) {
+		return s.interpolatedValues(ctx, query, assetId, property, interval)
+	})
+	dr = s.processHistory(results, err, failures, query, nil)
+	for _, frame := range dr.Frames {
+		for _, field := range frame.Fields {
+			if field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime {
+				field.Config = &data.FieldConfig{Interval: float64(interval.Milliseconds())}
+			}
+		}
+	}
+	return dr
+}
+
+// interpolatedValues pages through the interpolated values of an asset property as TwinMaker
+// history values in the query order, stopped is true when paging ended before the last page
+func (s *twinMakerHandler) interpolatedValues(ctx context.Context, query models.TwinMakerQuery, assetId string, property *iotsitewise.AssetProperty, interval time.Duration) (values []*iottwinmaker.PropertyValue, stopped bool, err error) {
+	req := &iotsitewise.GetInterpolatedAssetPropertyValuesInput{
+		AssetId:            aws.String(assetId),
+		PropertyId:         property.Id,
+		StartTimeInSeconds: aws.Int64(query.TimeRange.From.Unix()),
+		EndTimeInSeconds:   aws.Int64(int64(math.Ceil(float64(query.TimeRange.To.UnixMilli()) / 1000))),
+		IntervalInSeconds:  aws.Int64(int64(interval / time.Second)),
+		Quality:            aws.String(iotsitewise.QualityGood),
+		Type:               aws.String(interpolationType(aws.StringValue(property.DataType))),
+		MaxResults:         aws.Int64(maxInterpolatedPageSize),
+	}
+	countHistoryCall(ctx)
+	calls := 0
+	for {
+		start := time.Now()
+		page, err := s.client.GetInterpolatedAssetPropertyValues(ctx, req)
+		if err != nil {
+			return nil, false, err
+		}
+		lastPage := time.Since(start)
+		calls++
+		for _, v := range page.InterpolatedAssetPropertyValues {
+			if v == nil || v.Timestamp == nil || v.Value == nil {
+				continue
+			}
+			t := time.Unix(aws.Int64Value(v.Timestamp.TimeInSeconds), aws.Int64Value(v.Timestamp.OffsetInNanos)).UTC()
+			values = append(values, &iottwinmaker.PropertyValue{
+				Time:  getTimeStringFromTimeObject(&t),
+				Value: variantDataValue(v.Value),
+			})
+		}
+		if page.NextToken == nil {
+			break
+		}
+		if deadlineNear(ctx, lastPage) || previewPageLoaded(ctx, calls) || callBudgetReached(ctx) {
+			stopped = true
+			break
+		}
+		req.NextToken = page.NextToken
+	}
+	// SiteWise returns the values in ascending order
+	if query.Order == models.ResultOrderDesc {
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	return values, stopped, nil
+}
@@ -0,0 +1,139 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+// interpolatedMockClient serves two pages of values every interval for each asset property
+type interpolatedMockClient struct {
+	*twinMakerMockClient
+	requests []*iotsitewise.GetInterpolatedAssetPropertyValuesInput
+}
+
+func (c *interpolatedMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{Components: map[string]*iottwinmaker.ComponentResponse{
+		"MixerComponent": {
+			Properties: map[string]*iottwinmaker.PropertyResponse{
+				sitewiseAssetIdProperty: {Value: &iottwinmaker.DataValue{StringValue: aws.String("asset-0")}},
+				"Temperature":           {},
+				"State":                 {},
+			},
+		},
+		"CookieLine": {},
+	}}, nil
+}
+
+func (c *interpolatedMockClient) GetAssetProperties(ctx context.Context, assetId string) ([]*iotsitewise.AssetProperty, error) {
+	return []*iotsitewise.AssetProperty{
+		{Id: aws.String("temperature-id"), Name: aws.String("Temperature"), DataType: aws.String(iotsitewise.PropertyDataTypeDouble)},
+		{Id: aws.String("state-id"), Name: aws.String("State"), DataType: aws.String(iotsitewise.PropertyDataTypeString)},
+	}, nil
+}
+
+func (c *interpolatedMockClient) GetInterpolatedAssetPropertyValues(ctx context.Context, req *iotsitewise.GetInterpolatedAssetPropertyValuesInput) (*iotsitewise.GetInterpolatedAssetPropertyValuesOutput, error) {
+	in := *req
+	c.requests = append(c.requests, &in)
+	first := int64(0)
+	if req.NextToken != nil {
+		first = 2
+	}
+	rsp := &iotsitewise.GetInterpolatedAssetPropertyValuesOutput{}
+	for i := first; i < first+2; i++ {
+		value := &iotsitewise.Variant{DoubleValue: aws.Float64(float64(i))}
+		if *req.PropertyId == "state-id" {
+			value = &iotsitewise.Variant{StringValue: aws.String("RUNNING")}
+		}
+		rsp.InterpolatedAssetPropertyValues = append(rsp.InterpolatedAssetPropertyValues, &iotsitewise.InterpolatedAssetPropertyValue{
+			Timestamp: &iotsitewise.TimeInNanos{TimeInSeconds: aws.Int64(*req.StartTimeInSeconds + i**req.IntervalInSeconds)},
+			Value:     value,
+		})
+	}
+	if req.NextToken == nil {
+		rsp.NextToken = aws.String("next")
+	}
+	return rsp, nil
+}
+
+func TestInterpolationInterval(t *testing.T) {
+	start := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		Interval:  1500 * time.Millisecond,
+		TimeRange: backend.TimeRange{From: start, To: start.Add(time.Hour)},
+	}
+	require.Equal(t, 2*time.Second, interpolationInterval(query))
+
+	query.Interval = 0
+	query.MaxDataPoints = 60
+	require.Equal(t, time.Minute, interpolationInterval(query))
+
+	query.MaxDataPoints = 0
+	require.Equal(t, time.Second, interpolationInterval(query))
+}
+
+func TestGetInterpolatedHistory(t *testing.T) {
+	client := &interpolatedMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	start := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		QueryType:     models.QueryTypeInterpolated,
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature"), aws.String("RPM")},
+		Interval:      time.Minute,
+		TimeRange:     backend.TimeRange{From: start, To: start.Add(time.Hour)},
+	}
+
+	dr := newTwinMakerHandler(client, nil).GetInterpolatedHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, client.requests, 2)
+	req := client.requests[0]
+	require.Equal(t, "asset-0", *req.AssetId)
+	require.Equal(t, "temperature-id", *req.PropertyId)
+	require.Equal(t, int64(60), *req.IntervalInSeconds)
+	require.Equal(t, "LINEAR_INTERPOLATION", *req.Type)
+	require.Equal(t, start.Add(time.Hour).Unix(), *req.EndTimeInSeconds)
+
+	require.Len(t, dr.Frames, 1)
+	frame := dr.Frames[0]
+	require.Equal(t, 4, frame.Rows())
+	value, _ := frame.FieldByName("Temperature")
+	require.Equal(t, "Mixer_0", value.Labels["entityId"])
+	require.Equal(t, 3.0, *value.At(3).(*float64))
+	timeField := frame.Fields[1]
+	require.Equal(t, start.Add(3*time.Minute), *timeField.At(3).(*time.Time))
+	require.Equal(t, float64(time.Minute.Milliseconds()), timeField.Config.Interval)
+	require.Equal(t, "property RPM is not a property of SiteWise asset asset-0", frame.Meta.Notices[0].Text)
+
+	// all SiteWise properties of the component, carried forward unless numeric, in the query order
+	client.requests = nil
+	query.Properties = nil
+	query.Order = models.ResultOrderDesc
+	dr = newTwinMakerHandler(client, nil).GetInterpolatedHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, dr.Frames, 2)
+	require.Equal(t, "LOCF_INTERPOLATION", *client.requests[0].Type)
+	state, _ := dr.Frames[0].FieldByName("State")
+	require.Equal(t, "RUNNING", *state.At(0).(*string))
+	require.Equal(t, start.Add(3*time.Minute), *dr.Frames[1].Fields[1].At(0).(*time.Time))
+
+	// pages stop at the history call budget
+	client.requests = nil
+	query.Properties = []*string{aws.String("Temperature")}
+	dr = newTwinMakerHandler(client, nil).GetInterpolatedHistory(withHistoryCalls(context.Background(), 1), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, client.requests, 1)
+	require.Equal(t, 2, dr.Frames[0].Rows())
+	require.Contains(t, dr.Frames[0].Meta.Notices[0].Text, "history call budget")
+
+	query.ComponentName = "CookieLine"
+	dr = newTwinMakerHandler(client, nil).GetInterpolatedHistory(context.Background(), query)
+	require.EqualError(t, dr.Error, "component CookieLine of entity Mixer_0 is not connected to a SiteWise asset")
+}
@@ -0,0 +1,61 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// bucketKey is the KMS key of a bucket with SSE-KMS default encryption, empty when the bucket
+// uses S3 managed keys or the encryption configuration cannot be read
+func bucketKey(ctx context.Context, client *s3.S3, bucket string) string {
+	out, err := client.GetBucketEncryptionWithContext(ctx, &s3.GetBucketEncryptionInput{Bucket: &bucket})
+	if err != nil {
+		loggerFromContext(ctx).Debug("could not read the bucket encryption", "bucket", bucket, "error", err)
+		return ""
+	}
+	if out.ServerSideEncryptionConfiguration == nil {
+		return ""
+	}
+	for _, rule := range out.ServerSideEncryptionConfiguration.Rules {
+		sse := rule.ApplyServerSideEncryptionByDefault
+		if sse == nil || sse.SSEAlgorithm == nil || sse.KMSMasterKeyID == nil {
+			continue
+		}
+		if strings.HasPrefix(*sse.SSEAlgorithm, s3.ServerSideEncryptionAwsKms) {
+			return *sse.KMSMasterKeyID
+		}
+	}
+	return ""
+}
+
+// bucketKeyArn is the ARN of a bucket key given as key id, ARN or alias. IAM policies match
+// keys by key ARN, so an alias allows the keys of the workspace account and region.
+func bucketKeyArn(workspace *iottwinmaker.GetWorkspaceOutput, key string, partition string) string {
+	if strings.HasPrefix(key, "arn:") && !strings.Contains(key, ":alias/") {
+		return key
+	}
+	region, account := "*", "*"
+	if workspace.Arn != nil {
+		if a, err := arn.Parse(*workspace.Arn); err == nil {
+			region, account = a.Region, a.AccountID
+		}
+	}
+	if strings.HasPrefix(key, "alias/") || strings.Contains(key, ":alias/") {
+		key = "*"
+	}
+	return fmt.Sprintf("arn:%s:kms:%s:%s:key/%s", partition, region, account, key)
+}
+
+// kmsError explains S3 requests refused because the role may not use the bucket key
+func kmsError(err error, bucket string) error {
+	if aErr, ok := err.(awserr.Error); ok && aErr.Code() == "AccessDenied" && strings.Contains(aErr.Message(), "kms:") {
+		return fmt.Errorf("bucket %s is encrypted with a KMS key the role is not allowed to use, allow kms:Decrypt and kms:GenerateDataKey on the key: %w", bucket, err)
+	}
+	return err
+}
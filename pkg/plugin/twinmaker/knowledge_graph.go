@@ -0,0 +1,222 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// rows of an ExecuteQuery query without maxResults
+	defaultExecuteQueryRows = 1000
+	// the largest page ExecuteQuery returns
+	maxExecuteQueryPage = 250
+)
+
+// ExecuteQueryOutput is iottwinmaker.ExecuteQueryOutput with the row data. The SDK has no type for
+// the row documents, so its rows are always empty.
+type ExecuteQueryOutput struct {
+	ColumnDescriptions []*iottwinmaker.ColumnDescription `json:"columnDescriptions"`
+	Rows               []ExecuteQueryRow                 `json:"rows"`
+	NextToken          *string                           `json:"nextToken,omitempty"`
+}
+
+// ExecuteQueryRow holds one value per column: nodes and edges are objects, values any JSON type
+type ExecuteQueryRow struct {
+	RowData []interface{} `json:"rowData"`
+}
+
+// executeQueryPage sends one ExecuteQuery request and decodes the response body itself, one row at
+// a time
+func executeQueryPage(ctx context.Context, client *iottwinmaker.IoTTwinMaker, params *iottwinmaker.ExecuteQueryInput) (*ExecuteQueryOutput, error) {
+	req, _ := client.ExecuteQueryRequest(params)
+	req.SetContext(ctx)
+
+	page := &ExecuteQueryOutput{}
+	streamResponse(req, func(d *json.Decoder, key string) (bool, error) {
+		switch key {
+		case "columnDescriptions":
+			return true, d.Decode(&page.ColumnDescriptions)
+		case "nextToken":
+			return true, d.Decode(&page.NextToken)
+		case "rows":
+			return true, decodeElements(d, func() error {
+				row := ExecuteQueryRow{}
+				err := d.Decode(&row)
+				page.Rows = append(page.Rows, row)
+				return err
+			})
+		}
+		return false, nil
+	})
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// ExecuteQuery runs a PartiQL statement on the knowledge graph. Results with relationships are
+// the nodes and edges frames of the Node Graph panel. Otherwise every column becomes a field: node
+// columns hold the JSON documents, value columns get the type all their values share.
+func (s *twinMakerHandler) ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.QueryStatement == "" {
+		dr.Error = fmt.Errorf("missing query statement")
+		return
+	}
+	result, err := s.client.ExecuteQuery(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	if hasRelationshipColumns(result) {
+		dr.Frames = nodeGraphFrames(result, query, s.redaction)
+	} else {
+		dr.Frames = data.Frames{executeQueryFrame(result, s.redaction)}
+	}
+	if result.NextToken != nil {
+		dr.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Only the first %d rows are shown, the nextToken in the frame meta continues from the next page", len(result.Rows)),
+		})
+	}
+	return
+}
+
+// executeQueryFrame converts the rows, the property values of the documents are redacted before
+// they are kept as JSON
+func executeQueryFrame(result *ExecuteQueryOutput, redaction *redactor) *data.Frame {
+	count := len(result.Rows)
+	fields := newTwinMakerFrameBuilder(count)
+	for col, column := range result.ColumnDescriptions {
+		values := make([]interface{}, count)
+		for i, row := range result.Rows {
+			if col < len(row.RowData) {
+				values[i] = redactedDocument(row.RowData[col], redaction)
+			}
+		}
+		name := fmt.Sprintf("column%d", col+1)
+		if column.Name != nil {
+			name = *column.Name
+		}
+
+		columnType := ""
+		if column.Type != nil {
+			columnType = *column.Type
+		}
+		fields.add(executeQueryField(columnType, values), name)
+	}
+	return fields.ToFrame("", result.NextToken)
+}
+
+// executeQueryField infers the field type of a column from its values, columns of mixed types and
+// objects are kept as JSON
+func executeQueryField(columnType string, values []interface{}) *data.Field {
+	fieldType := data.FieldTypeNullableJSON
+	if columnType == iottwinmaker.ColumnTypeValue {
+		fieldType = inferValueType(values)
+	}
+
+	f := data.NewFieldFromFieldType(fieldType, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		switch fieldType {
+		case data.FieldTypeNullableBool:
+			b := v.(bool)
+			f.Set(i, &b)
+		case data.FieldTypeNullableFloat64:
+			n := v.(float64)
+			f.Set(i, &n)
+		case data.FieldTypeNullableTime:
+			t, _ := time.Parse(time.RFC3339Nano, v.(string))
+			f.Set(i, &t)
+		case data.FieldTypeNullableString:
+			str := v.(string)
+			f.Set(i, &str)
+		default:
+			if bs, err := json.Marshal(v); err == nil {
+				raw := json.RawMessage(bs)
+				f.Set(i, &raw)
+			}
+		}
+	}
+	return f
+}
+
+// redactedDocument is a copy of a row value in which the properties of entity and relationship
+// documents ({"propertyName": "x", "propertyValue": ...}) are redacted, dropped ones are removed
+func redactedDocument(v interface{}, redaction *redactor) interface{} {
+	if redaction == nil {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		doc := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			doc[k] = redactedDocument(item, redaction)
+		}
+		if name, ok := val["propertyName"].(string); ok && redaction.action(name) == models.RedactionMask {
+			if _, ok := doc["propertyValue"]; ok {
+				doc["propertyValue"] = redactedValue
+			}
+		}
+		return doc
+	case []interface{}:
+		items := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			if prop, ok := item.(map[string]interface{}); ok {
+				if name, ok := prop["propertyName"].(string); ok && redaction.action(name) == models.RedactionDrop {
+					continue
+				}
+			}
+			items = append(items, redactedDocument(item, redaction))
+		}
+		return items
+	}
+	return v
+}
+
+func inferValueType(values []interface{}) data.FieldType {
+	fieldType := data.FieldTypeUnknown
+	for _, v := range values {
+		var t data.FieldType
+		switch val := v.(type) {
+		case nil:
+			continue
+		case bool:
+			t = data.FieldTypeNullableBool
+		case float64:
+			t = data.FieldTypeNullableFloat64
+		case string:
+			t = data.FieldTypeNullableString
+			if _, err := time.Parse(time.RFC3339Nano, val); err == nil {
+				t = data.FieldTypeNullableTime
+			}
+		default:
+			return data.FieldTypeNullableJSON
+		}
+		switch {
+		case fieldType == data.FieldTypeUnknown:
+			fieldType = t
+		case fieldType == t:
+		case fieldType == data.FieldTypeNullableTime && t == data.FieldTypeNullableString,
+			fieldType == data.FieldTypeNullableString && t == data.FieldTypeNullableTime:
+			// some strings are not timestamps
+			fieldType = data.FieldTypeNullableString
+		default:
+			return data.FieldTypeNullableJSON
+		}
+	}
+	if fieldType == data.FieldTypeUnknown {
+		return data.FieldTypeNullableString
+	}
+	return fieldType
+}
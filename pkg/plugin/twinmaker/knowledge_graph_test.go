@@ -0,0 +1,126 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteQuery(t *testing.T) {
+	client, err := NewTwinMakerMockClient("execute-query")
+	require.NoError(t, err)
+	handler := newTwinMakerHandler(client, nil)
+
+	t.Run("converts the rows", func(t *testing.T) {
+		dr := handler.ExecuteQuery(context.Background(), models.TwinMakerQuery{
+			WorkspaceId:    "AlarmWorkspace",
+			QueryStatement: "SELECT e, e.entityName FROM EntityGraph MATCH (e)",
+		})
+		require.NoError(t, dr.Error)
+		require.Len(t, dr.Frames, 1)
+		frame := dr.Frames[0]
+		require.Equal(t, 2, frame.Rows())
+
+		types := map[string]data.FieldType{}
+		for _, f := range frame.Fields {
+			types[f.Name] = f.Type()
+		}
+		require.Equal(t, map[string]data.FieldType{
+			"e":           data.FieldTypeNullableJSON,
+			"entityName":  data.FieldTypeNullableString,
+			"temperature": data.FieldTypeNullableFloat64,
+			"active":      data.FieldTypeNullableBool,
+			"updated":     data.FieldTypeNullableTime,
+			"mixed":       data.FieldTypeNullableJSON,
+		}, types)
+
+		node := frame.Fields[0].At(0).(*json.RawMessage)
+		require.Contains(t, string(*node), `"entityId":"mixer_0"`)
+		require.Equal(t, "Mixer_1", *frame.Fields[1].At(1).(*string))
+		require.Nil(t, frame.Fields[2].At(1))
+		require.Equal(t, time.Date(2022, 4, 27, 11, 0, 0, 0, time.UTC), *frame.Fields[4].At(1).(*time.Time))
+
+		meta := frame.Meta.Custom.(models.TwinMakerCustomMeta)
+		require.Equal(t, "page-2", meta.NextToken)
+		require.Len(t, frame.Meta.Notices, 1)
+	})
+
+	t.Run("requires a statement", func(t *testing.T) {
+		dr := handler.ExecuteQuery(context.Background(), models.TwinMakerQuery{WorkspaceId: "AlarmWorkspace"})
+		require.EqualError(t, dr.Error, "missing query statement")
+	})
+}
+
+type executeQueryMockClient struct {
+	*twinMakerMockClient
+	result *ExecuteQueryOutput
+}
+
+func (c *executeQueryMockClient) ExecuteQuery(ctx context.Context, query models.TwinMakerQuery) (*ExecuteQueryOutput, error) {
+	return c.result, nil
+}
+
+func TestExecuteQueryRedaction(t *testing.T) {
+	entity := map[string]interface{}{
+		"entityId": "mixer_0",
+		"components": []interface{}{map[string]interface{}{
+			"componentName": "MixerComponent",
+			"properties": []interface{}{
+				map[string]interface{}{"propertyName": "serial", "propertyValue": "SN-123"},
+				map[string]interface{}{"propertyName": "secret", "propertyValue": "hunter2"},
+				map[string]interface{}{"propertyName": "temperature", "propertyValue": 21.5},
+			},
+		}},
+	}
+	client := &executeQueryMockClient{twinMakerMockClient: &twinMakerMockClient{}, result: &ExecuteQueryOutput{
+		ColumnDescriptions: []*iottwinmaker.ColumnDescription{{Name: aws.String("e"), Type: aws.String(iottwinmaker.ColumnTypeNode)}},
+		Rows:               []ExecuteQueryRow{{RowData: []interface{}{entity}}},
+	}}
+	redaction := newRedactor([]models.RedactionRule{
+		{Pattern: "serial", Action: models.RedactionMask},
+		{Pattern: "secret", Action: models.RedactionDrop},
+	})
+
+	dr := newTwinMakerHandler(client, redaction).ExecuteQuery(context.Background(), models.TwinMakerQuery{
+		QueryStatement: "SELECT e FROM EntityGraph MATCH (e)",
+	})
+	require.NoError(t, dr.Error)
+	doc := string(*dr.Frames[0].Fields[0].At(0).(*json.RawMessage))
+	require.Contains(t, doc, `{"propertyName":"serial","propertyValue":"***"}`)
+	require.NotContains(t, doc, "SN-123")
+	require.NotContains(t, doc, "secret")
+	require.Contains(t, doc, `"propertyValue":21.5`)
+	// the response of the client is left as it is
+	require.Equal(t, "SN-123", entity["components"].([]interface{})[0].(map[string]interface{})["properties"].([]interface{})[0].(map[string]interface{})["propertyValue"])
+}
+
+func TestExecuteQueryPage(t *testing.T) {
+	var input map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/queries/execution", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"columnDescriptions":[{"name":"e","type":"NODE"}],"rows":[{"rowData":[{"entityId":"mixer_0"}]}]}`))
+	}))
+	defer server.Close()
+
+	page, err := executeQueryPage(context.Background(), newTestTwinMakerService(t, server), &iottwinmaker.ExecuteQueryInput{
+		QueryStatement: aws.String("SELECT e FROM EntityGraph MATCH (e)"),
+		WorkspaceId:    aws.String("AlarmWorkspace"),
+		MaxResults:     aws.Int64(maxExecuteQueryPage),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "SELECT e FROM EntityGraph MATCH (e)", input["queryStatement"])
+	require.Equal(t, "e", *page.ColumnDescriptions[0].Name)
+	require.Equal(t, iottwinmaker.ColumnTypeNode, *page.ColumnDescriptions[0].Type)
+	require.Equal(t, []ExecuteQueryRow{{RowData: []interface{}{map[string]interface{}{"entityId": "mixer_0"}}}}, page.Rows)
+}
@@ -0,0 +1,126 @@
+package twinmaker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// linkPolicy decides which fields of URL values get data links, a nil policy links the
+// DefaultLinkSchemes
+type linkPolicy struct {
+	schemes  map[string]bool
+	disabled bool
+}
+
+func newLinkPolicy(settings models.TwinMakerDataSourceSetting) *linkPolicy {
+	schemes := settings.LinkSchemes
+	if len(schemes) == 0 {
+		schemes = models.DefaultLinkSchemes
+	}
+	p := &linkPolicy{schemes: map[string]bool{}, disabled: settings.DisableUrlLinks}
+	for _, scheme := range schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+	return p
+}
+
+// linkable is true when the values have URLs and all of them use an allowed scheme, the link of a
+// field applies to every one of its values
+func (p *linkPolicy) linkable(values []*iottwinmaker.DataValue) bool {
+	if p == nil {
+		p = newLinkPolicy(models.TwinMakerDataSourceSetting{})
+	}
+	if p.disabled {
+		return false
+	}
+	urls := false
+	for _, v := range values {
+		if !checkForUrl(v) {
+			continue
+		}
+		if !p.schemes[uriScheme(*v.StringValue)] {
+			return false
+		}
+		urls = true
+	}
+	return urls
+}
+
+// uriScheme is the lower case scheme of a URI as browsers read it, ignoring surrounding spaces and
+// control characters and tabs or newlines within. Empty when the value has no scheme.
+func uriScheme(value string) string {
+	value = strings.TrimFunc(value, func(r rune) bool {
+		return r <= ' '
+	})
+	value = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(value)
+	i := strings.IndexByte(value, ':')
+	if i <= 0 {
+		return ""
+	}
+	scheme := value[:i]
+	for j, c := range scheme {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case j > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return ""
+		}
+	}
+	return strings.ToLower(scheme)
+}
+
+// unsafeURI is true for values with one of the UnsafeLinkSchemes
+func unsafeURI(value string) bool {
+	scheme := uriScheme(value)
+	for _, unsafe := range models.UnsafeLinkSchemes {
+		if scheme == unsafe {
+			return true
+		}
+	}
+	return false
+}
+
+// stripUnsafeURIs removes the string values with script or embedded content URIs from the frames,
+// values from field devices must not run in dashboards that render or link them. Nullable values
+// become null, others empty.
+func stripUnsafeURIs(dr *backend.DataResponse) {
+	for _, frame := range dr.Frames {
+		stripFrameURIs(frame)
+	}
+}
+
+// stripFrameURIs is stripUnsafeURIs of one frame, e.g. of a stream
+func stripFrameURIs(frame *data.Frame) {
+	var stripped []string
+	for _, field := range frame.Fields {
+		n := 0
+		for i := 0; i < field.Len(); i++ {
+			switch v := field.At(i).(type) {
+			case *string:
+				if v != nil && unsafeURI(*v) {
+					field.Set(i, (*string)(nil))
+					n++
+				}
+			case string:
+				if unsafeURI(v) {
+					field.Set(i, "")
+					n++
+				}
+			}
+		}
+		if n > 0 {
+			stripped = append(stripped, fmt.Sprintf("%d of %s", n, field.Name))
+		}
+	}
+	if len(stripped) > 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Removed unsafe URI values: %s", strings.Join(stripped, ", ")),
+		})
+	}
+}
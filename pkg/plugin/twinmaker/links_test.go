@@ -0,0 +1,65 @@
+package twinmaker
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUriScheme(t *testing.T) {
+	require.Equal(t, "https", uriScheme("HTTPS://example.com"))
+	require.Equal(t, "javascript", uriScheme(" java\tscript:alert(1)"))
+	require.Equal(t, "data", uriScheme("\x00data:text/html;base64,PHNjcmlwdD4="))
+	require.Equal(t, "", uriScheme("Temperature 20: high"))
+	require.Equal(t, "", uriScheme("12:30"))
+	require.True(t, unsafeURI("vbscript:msgbox"))
+	require.False(t, unsafeURI("s3://bucket/key"))
+}
+
+func TestLinkPolicy(t *testing.T) {
+	values := func(v ...string) []*iottwinmaker.DataValue {
+		values := []*iottwinmaker.DataValue{{DoubleValue: aws.Float64(1)}}
+		for i := range v {
+			values = append(values, &iottwinmaker.DataValue{StringValue: &v[i]})
+		}
+		return values
+	}
+	handler := newTwinMakerHandler(&twinMakerMockClient{}, nil)
+	// nil policy links the default schemes
+	require.True(t, handler.links.linkable(values("https://example.com", "s3://bucket/key")))
+	require.False(t, handler.links.linkable(values("idle")))
+
+	frame, err := handler.processListValue(values("https://example.com", "http://example.com")[1:], "manuals", nil)
+	require.NoError(t, err)
+	require.Nil(t, frame.Fields[0].Config)
+
+	handler.links = newLinkPolicy(models.TwinMakerDataSourceSetting{LinkSchemes: []string{"HTTP", "https"}})
+	frame, err = handler.processListValue(values("https://example.com", "http://example.com")[1:], "manuals", nil)
+	require.NoError(t, err)
+	require.Equal(t, "${__value.text}", frame.Fields[0].Config.Links[0].URL)
+	require.False(t, handler.links.linkable(values("s3://bucket/key")))
+
+	handler.links = newLinkPolicy(models.TwinMakerDataSourceSetting{DisableUrlLinks: true})
+	require.False(t, handler.links.linkable(values("https://example.com")))
+}
+
+func TestStripUnsafeURIs(t *testing.T) {
+	link := "javascript:alert(document.cookie)"
+	label := "https://example.com"
+	frame := data.NewFrame("",
+		data.NewField("url", nil, []*string{&link, &label, nil}),
+		data.NewField("name", nil, []string{"Mixer_0", " DATA:text/html,<script>"}),
+		data.NewField("value", nil, []float64{1, 2}),
+	)
+	dr := backend.DataResponse{Frames: data.Frames{frame}}
+	stripUnsafeURIs(&dr)
+	require.Nil(t, frame.Fields[0].At(0))
+	require.Equal(t, label, *frame.Fields[0].At(1).(*string))
+	require.Equal(t, "", frame.Fields[1].At(1))
+	require.Equal(t, "Removed unsafe URI values: 1 of url, 1 of name", frame.Meta.Notices[0].Text)
+}
@@ -0,0 +1,61 @@
+package twinmaker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func validFieldNaming(naming models.FieldNaming) error {
+	switch naming {
+	case "", models.FieldNamingStableV1:
+		return nil
+	}
+	return fmt.Errorf("unknown field naming scheme %s", naming)
+}
+
+// stableSeriesName is the models.FieldNamingStableV1 name of a property series, parts the
+// reference does not have are left empty
+func stableSeriesName(ref *iottwinmaker.EntityPropertyReference) string {
+	if ref == nil {
+		return ""
+	}
+	return strings.Join([]string{
+		aws.StringValue(ref.EntityId),
+		aws.StringValue(ref.ComponentName),
+		aws.StringValue(ref.PropertyName),
+	}, "/")
+}
+
+// nameSeries names the field of a property series. With the stable scheme the display name only
+// shows in the field config, otherwise it replaces the name.
+func nameSeries(f *data.Field, query models.TwinMakerQuery, ref *iottwinmaker.EntityPropertyReference, display string) {
+	if query.FieldNaming != models.FieldNamingStableV1 {
+		f.Name = display
+		return
+	}
+	f.Name = stableSeriesName(ref)
+	if display != "" && display != f.Name {
+		if f.Config == nil {
+			f.Config = &data.FieldConfig{}
+		}
+		f.Config.DisplayName = display
+	}
+}
+
+// setFieldNaming echoes the naming scheme in the frame meta
+func setFieldNaming(res *backend.DataResponse, naming models.FieldNaming) {
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		meta, _ := frame.Meta.Custom.(models.TwinMakerCustomMeta)
+		meta.FieldNaming = naming
+		frame.Meta.Custom = meta
+	}
+}
@@ -0,0 +1,66 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStableSeriesName(t *testing.T) {
+	t.Run("entity property", func(t *testing.T) {
+		ref := &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String("mixer-1"),
+			ComponentName: aws.String("Telemetry"),
+			PropertyName:  aws.String("temperature"),
+		}
+		require.Equal(t, "mixer-1/Telemetry/temperature", stableSeriesName(ref))
+	})
+
+	t.Run("unresolved component type property", func(t *testing.T) {
+		ref := &iottwinmaker.EntityPropertyReference{
+			PropertyName: aws.String("alarm_status"),
+			ExternalIdProperty: map[string]*string{
+				"alarmId":      aws.String("a-1"),
+				"alarmGroup":   aws.String("g-1"),
+				"propertyName": aws.String("alarm_status"),
+			},
+		}
+		// externalIds are labels, the name only has the ids of the reference
+		require.Equal(t, "//alarm_status", stableSeriesName(ref))
+	})
+}
+
+func TestFieldNaming(t *testing.T) {
+	client, err := NewTwinMakerMockClient("get-property-value")
+	require.NoError(t, err)
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+	query := models.TwinMakerQuery{
+		QueryType:            models.QueryTypeGetPropertyValue,
+		EntityId:             "Mixer_1_4b57cbee-c391-4de6-b882-622c633a697e",
+		ComponentName:        "AlarmComponent",
+		Properties:           []*string{aws.String("alarm_key")},
+		PropertyDisplayNames: map[string]string{"alarm_key": "Alarm key"},
+	}
+
+	res := ds.Query(context.Background(), query)
+	require.NoError(t, res.Error)
+	field, _ := res.Frames[0].FieldByName("Alarm key")
+	require.NotNil(t, field)
+
+	query.FieldNaming = models.FieldNamingStableV1
+	res = ds.Query(context.Background(), query)
+	require.NoError(t, res.Error)
+	field, _ = res.Frames[0].FieldByName("Mixer_1_4b57cbee-c391-4de6-b882-622c633a697e/AlarmComponent/alarm_key")
+	require.NotNil(t, field)
+	require.Equal(t, "Alarm key", field.Config.DisplayName)
+	meta := res.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta)
+	require.Equal(t, models.FieldNamingStableV1, meta.FieldNaming)
+
+	query.FieldNaming = "stable-v0"
+	res = ds.Query(context.Background(), query)
+	require.EqualError(t, res.Error, "unknown field naming scheme stable-v0")
+}
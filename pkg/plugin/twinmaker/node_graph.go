@@ -0,0 +1,211 @@
+package twinmaker
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type graphNode struct {
+	id       string
+	title    string
+	subTitle string
+	arn      string
+	stats    []*string
+}
+
+type graphEdge struct {
+	id     string
+	source string
+	target string
+	name   string
+}
+
+// hasRelationshipColumns is true when the knowledge graph result has edges to draw
+func hasRelationshipColumns(result *ExecuteQueryOutput) bool {
+	for _, column := range result.ColumnDescriptions {
+		if column.Type != nil && *column.Type == iottwinmaker.ColumnTypeEdge {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeGraphFrames converts the entity and relationship rows to the nodes and edges frames of the
+// Node Graph panel. The first two query properties found on an entity are its main and secondary
+// stat, redaction rules apply to them. Entities only referenced by a relationship are nodes with
+// the id as title.
+func nodeGraphFrames(result *ExecuteQueryOutput, query models.TwinMakerQuery, redaction *redactor) data.Frames {
+	nodes := []*graphNode{}
+	nodeById := map[string]*graphNode{}
+	addNode := func(n *graphNode) {
+		if existing, ok := nodeById[n.id]; ok {
+			// a full entity replaces a node known from a relationship only
+			if existing.arn == "" && n.arn != "" {
+				*existing = *n
+			}
+			return
+		}
+		nodeById[n.id] = n
+		nodes = append(nodes, n)
+	}
+	edges := []*graphEdge{}
+	edgeIds := map[string]bool{}
+
+	for _, row := range result.Rows {
+		for col, column := range result.ColumnDescriptions {
+			if col >= len(row.RowData) || column.Type == nil {
+				continue
+			}
+			doc, ok := row.RowData[col].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch *column.Type {
+			case iottwinmaker.ColumnTypeNode:
+				if n := entityNode(doc, query.Properties, redaction); n != nil {
+					addNode(n)
+				}
+			case iottwinmaker.ColumnTypeEdge:
+				e := relationshipEdge(doc)
+				if e == nil || edgeIds[e.id] {
+					continue
+				}
+				edgeIds[e.id] = true
+				edges = append(edges, e)
+				addNode(&graphNode{id: e.source, title: e.source})
+				addNode(&graphNode{id: e.target, title: e.target})
+			}
+		}
+	}
+
+	nodeFields := newTwinMakerFrameBuilder(len(nodes))
+	id := nodeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(nodes)), "id")
+	title := nodeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(nodes)), "title")
+	subTitle := nodeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(nodes)), "subTitle")
+	var stats []*data.Field
+	for i, name := range []string{"mainStat", "secondaryStat"} {
+		if i < len(query.Properties) && query.Properties[i] != nil {
+			f := nodeFields.add(data.NewFieldFromFieldType(data.FieldTypeNullableString, len(nodes)), name)
+			f.Config = &data.FieldConfig{DisplayName: *query.Properties[i]}
+			stats = append(stats, f)
+		}
+	}
+	arn := nodeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(nodes)), "detail__arn")
+	for i, n := range nodes {
+		id.Set(i, n.id)
+		title.Set(i, n.title)
+		subTitle.Set(i, n.subTitle)
+		arn.Set(i, n.arn)
+		for s, f := range stats {
+			if s < len(n.stats) {
+				f.Set(i, n.stats[s])
+			}
+		}
+	}
+
+	edgeFields := newTwinMakerFrameBuilder(len(edges))
+	edgeId := edgeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(edges)), "id")
+	source := edgeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(edges)), "source")
+	target := edgeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(edges)), "target")
+	relationship := edgeFields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(edges)), "mainStat")
+	for i, e := range edges {
+		edgeId.Set(i, e.id)
+		source.Set(i, e.source)
+		target.Set(i, e.target)
+		relationship.Set(i, e.name)
+	}
+
+	frames := data.Frames{nodeFields.ToFrame("nodes", result.NextToken), edgeFields.ToFrame("edges", nil)}
+	for _, frame := range frames {
+		frame.Meta.PreferredVisualization = data.VisTypeNodeGraph
+	}
+	return frames
+}
+
+// entityNode reads an entity document of a NODE column
+func entityNode(doc map[string]interface{}, properties []*string, redaction *redactor) *graphNode {
+	id, _ := doc["entityId"].(string)
+	if id == "" {
+		return nil
+	}
+	n := &graphNode{id: id, title: id}
+	if name, ok := doc["entityName"].(string); ok && name != "" {
+		n.title = name
+	}
+	n.arn, _ = doc["arn"].(string)
+
+	values := map[string]*string{}
+	components, _ := doc["components"].([]interface{})
+	for _, c := range components {
+		component, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if typeId, ok := component["componentTypeId"].(string); ok && n.subTitle == "" {
+			n.subTitle = typeId
+		}
+		props, _ := component["properties"].([]interface{})
+		for _, p := range props {
+			prop, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := prop["propertyName"].(string)
+			if _, seen := values[name]; seen || name == "" || redaction.action(name) == models.RedactionDrop {
+				continue
+			}
+			if redaction.action(name) == models.RedactionMask {
+				masked := redactedValue
+				values[name] = &masked
+			} else if v, ok := graphStatValue(prop["propertyValue"]); ok {
+				values[name] = &v
+			}
+		}
+	}
+	for _, p := range properties {
+		if p == nil || len(n.stats) == 2 {
+			break
+		}
+		n.stats = append(n.stats, values[*p])
+	}
+	return n
+}
+
+// relationshipEdge reads a relationship document of an EDGE column
+func relationshipEdge(doc map[string]interface{}) *graphEdge {
+	source, _ := doc["sourceEntityId"].(string)
+	target, _ := doc["targetEntityId"].(string)
+	if source == "" || target == "" {
+		return nil
+	}
+	name, _ := doc["relationshipName"].(string)
+	return &graphEdge{
+		id:     source + "/" + name + "/" + target,
+		source: source,
+		target: target,
+		name:   name,
+	}
+}
+
+// graphStatValue formats a scalar property value, values are either plain JSON values or typed like
+// a DataValue ({"doubleValue": 1.5})
+func graphStatValue(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case bool:
+		return strconv.FormatBool(val), true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case map[string]interface{}:
+		for _, key := range []string{"stringValue", "doubleValue", "integerValue", "longValue", "booleanValue"} {
+			if typed, ok := val[key]; ok && typed != nil {
+				return graphStatValue(typed)
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,67 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeGraphFrames(t *testing.T) {
+	client, err := NewTwinMakerMockClient("execute-query-graph")
+	require.NoError(t, err)
+	redaction := newRedactor([]models.RedactionRule{{Pattern: "serial"}})
+	handler := newTwinMakerHandler(client, redaction)
+
+	dr := handler.ExecuteQuery(context.Background(), models.TwinMakerQuery{
+		WorkspaceId:    "AlarmWorkspace",
+		QueryStatement: "SELECT e1, r, e2 FROM EntityGraph MATCH (e1)-[r]->(e2)",
+		Properties:     []*string{aws.String("rpm"), aws.String("serial")},
+	})
+	require.NoError(t, dr.Error)
+	require.Len(t, dr.Frames, 2)
+	nodes, edges := dr.Frames[0], dr.Frames[1]
+	require.Equal(t, "nodes", nodes.Name)
+	require.Equal(t, "edges", edges.Name)
+	require.Equal(t, data.VisTypeNodeGraph, string(nodes.Meta.PreferredVisualization))
+
+	// nodes are distinct, the stub of line_1 from the relationship is replaced by the entity
+	require.Equal(t, 3, nodes.Rows())
+	row := func(f *data.Frame, i int) map[string]interface{} {
+		values := map[string]interface{}{}
+		for _, field := range f.Fields {
+			v := field.At(i)
+			if s, ok := v.(*string); ok {
+				if s == nil {
+					v = nil
+				} else {
+					v = *s
+				}
+			}
+			values[field.Name] = v
+		}
+		return values
+	}
+	require.Equal(t, map[string]interface{}{
+		"id":            "mixer_0",
+		"title":         "Mixer_0",
+		"subTitle":      "com.example.mixer",
+		"mainStat":      "1200.5",
+		"secondaryStat": "***",
+		"detail__arn":   "arn:aws:iottwinmaker:us-east-1:123456789012:workspace/AlarmWorkspace/entity/mixer_0",
+	}, row(nodes, 0))
+	require.Equal(t, "line_1", row(nodes, 1)["id"])
+	require.Equal(t, "Line_1", row(nodes, 1)["title"])
+	require.Nil(t, row(nodes, 2)["mainStat"])
+
+	require.Equal(t, 2, edges.Rows())
+	require.Equal(t, map[string]interface{}{
+		"id":       "mixer_0/isChildOf/line_1",
+		"source":   "mixer_0",
+		"target":   "line_1",
+		"mainStat": "isChildOf",
+	}, row(edges, 0))
+}
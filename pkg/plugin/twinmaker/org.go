@@ -0,0 +1,87 @@
+package twinmaker
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+type orgKey struct{}
+
+// WithOrg sets the Grafana org of the request. Its queries and resource calls use the workspace the
+// settings bind the org to, see models.TwinMakerDataSourceSetting.OrgWorkspaces.
+func WithOrg(ctx context.Context, orgID int64) context.Context {
+	return context.WithValue(ctx, orgKey{}, orgID)
+}
+
+// OrgFrom is the Grafana org of the request, 0 when it was not set
+func OrgFrom(ctx context.Context) int64 {
+	v, _ := ctx.Value(orgKey{}).(int64)
+	return v
+}
+
+// workspaceFor is the default workspace of the request org
+func (ds *Datasource) workspaceFor(ctx context.Context) string {
+	return ds.Settings.WorkspaceForOrg(OrgFrom(ctx))
+}
+
+// orgWorkspaces keeps only the workspace of a bound request org in the frames of a ListWorkspace
+// response, the other workspaces of the account belong to other orgs
+func (ds *Datasource) orgWorkspaces(ctx context.Context, dr backend.DataResponse) backend.DataResponse {
+	bound, ok := ds.Settings.OrgWorkspaces[OrgFrom(ctx)]
+	if !ok || dr.Error != nil {
+		return dr
+	}
+	for i, frame := range dr.Frames {
+		_, idx := frame.FieldByName("workspaceId")
+		if idx < 0 {
+			continue
+		}
+		filtered, err := frame.FilterRowsByField(idx, func(v interface{}) (bool, error) {
+			id, _ := v.(*string)
+			return id != nil && *id == bound, nil
+		})
+		if err != nil {
+			dr.Error = err
+			return dr
+		}
+		dr.Frames[i] = filtered
+	}
+	return dr
+}
+
+// orgResourcesKey is the workspace and role of resources other than Resources
+type orgResourcesKey struct {
+	workspaceId string
+	viewer      bool
+}
+
+// ResourcesFor serves the resource calls of the request org and role. Orgs bound to another
+// workspace than the datasource workspace, and anonymous requests when a viewer role is
+// configured, get resources of their own, with their own cache, on first use.
+func (ds *Datasource) ResourcesFor(ctx context.Context) TwinMakerResources {
+	key := orgResourcesKey{
+		workspaceId: ds.workspaceFor(ctx),
+		viewer:      ds.viewerClient != nil && usesViewerRole(ctx),
+	}
+	if key.workspaceId == ds.Settings.WorkspaceID && !key.viewer {
+		return ds.Resources
+	}
+
+	ds.orgMu.Lock()
+	defer ds.orgMu.Unlock()
+	if r, ok := ds.orgResources[key]; ok {
+		return r
+	}
+	client := ds.Client
+	if key.viewer {
+		client = ds.viewerClient
+	}
+	resources := newTwinMakerResource(client, key.workspaceId, ds.redaction)
+	resources.alarmModelSync = ds.Settings.AlarmModelSync
+	if ds.orgResources == nil {
+		ds.orgResources = map[orgResourcesKey]TwinMakerResources{}
+	}
+	ds.orgResources[key] = NewCachingResource(resources, DefaultCacheTTL)
+	return ds.orgResources[key]
+}
@@ -0,0 +1,86 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type orgMockClient struct {
+	*twinMakerMockClient
+	workspaces []string
+}
+
+func (c *orgMockClient) ListScenes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListScenesOutput, error) {
+	c.workspaces = append(c.workspaces, query.WorkspaceId)
+	return &iottwinmaker.ListScenesOutput{}, nil
+}
+
+func (c *orgMockClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	rsp := &iottwinmaker.ListWorkspacesOutput{}
+	for _, id := range []string{"main", "tenant-a", "tenant-b"} {
+		rsp.WorkspaceSummaries = append(rsp.WorkspaceSummaries, &iottwinmaker.WorkspaceSummary{
+			WorkspaceId:      aws.String(id),
+			CreationDateTime: aws.Time(time.Unix(0, 0)),
+		})
+	}
+	return rsp, nil
+}
+
+func TestOrgWorkspaceRouting(t *testing.T) {
+	client := &orgMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{
+		WorkspaceID:   "main",
+		OrgWorkspaces: map[int64]string{2: "tenant-a"},
+	}, client)
+	query := models.TwinMakerQuery{QueryType: models.QueryTypeListScenes}
+
+	require.NoError(t, ds.Query(WithOrg(context.Background(), 2), query).Error)
+	require.NoError(t, ds.Query(WithOrg(context.Background(), 1), query).Error)
+	require.Equal(t, []string{"tenant-a", "main"}, client.workspaces)
+
+	// the orgs cannot read the workspaces of each other
+	query.WorkspaceId = "main"
+	require.EqualError(t, ds.Query(WithOrg(context.Background(), 2), query).Error, "workspace main is not allowed in datasource configuration")
+	query.WorkspaceId = "tenant-a"
+	require.EqualError(t, ds.Query(WithOrg(context.Background(), 1), query).Error, "workspace tenant-a is not allowed in datasource configuration")
+
+	// bound orgs do not see the watchlist and audit log of the datasource workspace
+	query = models.TwinMakerQuery{QueryType: models.QueryTypeWatchlist}
+	require.Error(t, ds.Query(WithOrg(context.Background(), 2), query).Error)
+	query.QueryType = models.QueryTypeAuditLog
+	require.Error(t, ds.Query(WithOrg(context.Background(), 2), query).Error)
+
+	// bound orgs only list their own workspace
+	query = models.TwinMakerQuery{QueryType: models.QueryTypeListWorkspace}
+	dr := ds.Query(WithOrg(context.Background(), 2), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, 1, dr.Frames[0].Rows())
+	id, _ := dr.Frames[0].FieldByName("workspaceId")
+	require.Equal(t, "tenant-a", *id.At(0).(*string))
+	dr = ds.Query(WithOrg(context.Background(), 1), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, 3, dr.Frames[0].Rows())
+
+	// resource calls use resources of the org workspace
+	require.Same(t, ds.Resources, ds.ResourcesFor(WithOrg(context.Background(), 1)))
+	tenant := ds.ResourcesFor(WithOrg(context.Background(), 2))
+	require.NotSame(t, ds.Resources, tenant)
+	require.Same(t, tenant, ds.ResourcesFor(WithOrg(context.Background(), 2)))
+
+	// anonymous requests only get resources of their own with a viewer role
+	require.Same(t, ds.Resources, ds.ResourcesFor(WithViewerRole(context.Background())))
+	viewer := &orgMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds.SetViewerClient(viewer)
+	anonymous := ds.ResourcesFor(WithViewerRole(WithOrg(context.Background(), 2)))
+	require.NotSame(t, tenant, anonymous)
+	_, err := anonymous.ListScenes(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"tenant-a"}, viewer.workspaces)
+	require.NotSame(t, ds.Resources, ds.ResourcesFor(WithViewerRole(context.Background())))
+}
@@ -0,0 +1,85 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// deadlineMargin is kept for converting and returning the pages loaded so far
+const deadlineMargin = time.Second
+
+// deadlineNear is true when another page, taking about as long as the last one, would not finish
+// before the query deadline
+func deadlineNear(ctx context.Context, lastPage time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < lastPage+deadlineMargin
+}
+
+// previewPageLoaded is true when a request of a preview made its call, previews load one page
+func previewPageLoaded(ctx context.Context, calls int) bool {
+	return isPreview(ctx) && calls > 0
+}
+
+type historyCallsKey struct{}
+
+// historyCalls is the budget of GetPropertyValueHistory calls of the paged history requests of a
+// query, shared by the requests running concurrently. reached is set once a request stopped at it.
+type historyCalls struct {
+	budget    int
+	remaining atomic.Int64
+	reached   atomic.Bool
+}
+
+// withHistoryCalls limits the pages of all history requests of the query to budget calls,
+// unlimited when 0
+func withHistoryCalls(ctx context.Context, budget int) context.Context {
+	if budget <= 0 {
+		return ctx
+	}
+	b := &historyCalls{budget: budget}
+	b.remaining.Store(int64(budget))
+	return context.WithValue(ctx, historyCallsKey{}, b)
+}
+
+// countHistoryCall charges the first page of a history request to the budget of the query, it is
+// loaded even when the budget is used up
+func countHistoryCall(ctx context.Context) {
+	if b, ok := ctx.Value(historyCallsKey{}).(*historyCalls); ok {
+		b.remaining.Add(-1)
+	}
+}
+
+// callBudgetReached is true when the budget of the query has no call left for another page of a
+// history request, otherwise the call is taken from it
+func callBudgetReached(ctx context.Context) bool {
+	b, ok := ctx.Value(historyCallsKey{}).(*historyCalls)
+	if !ok || b.remaining.Add(-1) >= 0 {
+		return false
+	}
+	b.reached.Store(true)
+	return true
+}
+
+// partialNotice marks a response that stopped paging before the query deadline, at the call
+// budget of the query or after the first page of a preview
+func partialNotice(ctx context.Context, continued bool) data.Notice {
+	severity := data.NoticeSeverityWarning
+	text := "Partial due to timeout, only the pages loaded before the query deadline are shown"
+	if b, ok := ctx.Value(historyCallsKey{}).(*historyCalls); ok && b.reached.Load() {
+		text = fmt.Sprintf("Partial, the history call budget of %d calls of the query is used up", b.budget)
+	} else if isPreview(ctx) {
+		severity = data.NoticeSeverityInfo
+		text = "Preview, only the first page of each request is loaded"
+	}
+	if continued {
+		text += ", the nextToken in the frame meta continues from the next page"
+	}
+	return data.Notice{
+		Severity: severity,
+		Text:     text,
+	}
+}
@@ -0,0 +1,150 @@
+package twinmaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+type partialMockClient struct {
+	*availabilityMockClient
+}
+
+func (c *partialMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"mixer_id": {IsExternalId: aws.Bool(true)},
+	}}, nil
+}
+
+func (c *partialMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return &iottwinmaker.ListEntitiesOutput{}, nil
+}
+
+func TestPartialComponentHistory(t *testing.T) {
+	query := models.TwinMakerQuery{
+		ComponentTypeId: "com.example.mixer",
+		Properties:      []*string{aws.String("Temperature")},
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 27, 4, 0, 0, 0, time.UTC),
+		},
+	}
+
+	t.Run("all pages before the deadline", func(t *testing.T) {
+		client := &partialMockClient{&availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: 3}}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		dr := NewTwinMakerHandler(client).GetComponentHistory(ctx, query)
+		require.NoError(t, dr.Error)
+		require.Equal(t, 3, client.calls)
+		require.Equal(t, 3, dr.Frames[0].Rows())
+		require.Nil(t, models.LoadMetaFromResponse(dr))
+	})
+
+	t.Run("deadline near", func(t *testing.T) {
+		client := &partialMockClient{&availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: 3}}
+		ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin/2)
+		defer cancel()
+
+		dr := NewTwinMakerHandler(client).GetComponentHistory(ctx, query)
+		require.NoError(t, dr.Error)
+		require.Equal(t, 1, client.calls)
+
+		// the first page is returned with a continuation token
+		frame := dr.Frames[0]
+		require.Equal(t, 1, frame.Rows())
+		require.Equal(t, "1", models.LoadMetaFromResponse(dr).NextToken)
+		require.Contains(t, frame.Meta.Notices[len(frame.Meta.Notices)-1].Text, "Partial due to timeout")
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		client := &partialMockClient{&availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: 3}}
+		ctx, cancel := withPreview(context.Background())
+		defer cancel()
+
+		dr := NewTwinMakerHandler(client).GetComponentHistory(ctx, query)
+		require.NoError(t, dr.Error)
+		require.Equal(t, 1, client.calls)
+
+		// the notice tells the preview stopped, not a timeout
+		notice := dr.Frames[0].Meta.Notices[len(dr.Frames[0].Meta.Notices)-1]
+		require.Equal(t, data.NoticeSeverityInfo, notice.Severity)
+		require.Contains(t, notice.Text, "Preview, only the first page of each request is loaded")
+		require.NotContains(t, notice.Text, "timeout")
+	})
+}
+
+func TestEntityHistoryCallBudget(t *testing.T) {
+	query := models.TwinMakerQuery{
+		QueryType:     models.QueryTypeEntityHistory,
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 4, 27, 4, 0, 0, 0, time.UTC),
+		},
+	}
+
+	t.Run("covers the time range", func(t *testing.T) {
+		client := &availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: 3}
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, client)
+		dr := ds.Query(context.Background(), query)
+		require.NoError(t, dr.Error)
+		require.Equal(t, 3, client.calls)
+		require.Equal(t, 3, dr.Frames[0].Rows())
+		require.Nil(t, models.LoadMetaFromResponse(dr))
+		require.Empty(t, dr.Frames[0].Meta.Notices)
+	})
+
+	t.Run("budget reached", func(t *testing.T) {
+		client := &availabilityMockClient{twinMakerMockClient: &twinMakerMockClient{}, pages: 5}
+		ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w", MaxHistoryCalls: 2}, client)
+		dr := ds.Query(context.Background(), query)
+		require.NoError(t, dr.Error)
+		require.Equal(t, 2, client.calls)
+
+		frame := dr.Frames[0]
+		require.Equal(t, 2, frame.Rows())
+		require.Equal(t, "2", models.LoadMetaFromResponse(dr).NextToken)
+		require.Contains(t, frame.Meta.Notices[0].Text, "Partial, the history call budget of 2 calls of the query is used up")
+	})
+}
+
+func TestHistoryCallBudgetSharedByQuery(t *testing.T) {
+	ctx := withHistoryCalls(context.Background(), 10)
+
+	// the first pages of two requests are charged, the concurrent requests share the rest
+	countHistoryCall(ctx)
+	countHistoryCall(ctx)
+	var pages atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if !callBudgetReached(ctx) {
+					pages.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(8), pages.Load())
+	require.True(t, callBudgetReached(ctx))
+	require.Contains(t, partialNotice(ctx, false).Text, "history call budget of 10 calls of the query")
+
+	// no budget
+	require.False(t, callBudgetReached(context.Background()))
+}
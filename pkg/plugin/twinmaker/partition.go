@@ -0,0 +1,36 @@
+package twinmaker
+
+import (
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// policyPartition is the partition of the ARNs a session policy constructs. The workspace and
+// bucket ARNs returned by TwinMaker are authoritative, the configured region's partition is only
+// used when neither can be parsed.
+func policyPartition(workspace *iottwinmaker.GetWorkspaceOutput, region string) string {
+	for _, s := range []*string{workspace.Arn, workspace.S3Location} {
+		if s == nil {
+			continue
+		}
+		if a, err := arn.Parse(*s); err == nil && a.Partition != "" {
+			return a.Partition
+		}
+	}
+	return models.RegionPartition(region)
+}
+
+// s3BucketArn is the bucket ARN of a workspace S3 location given as ARN, s3:// url or bucket name
+func s3BucketArn(location string, partition string) string {
+	if a, err := arn.Parse(location); err == nil && a.Service == "s3" {
+		return location
+	}
+	return arn.ARN{Partition: partition, Service: "s3", Resource: sceneAssetBucket(location)}.String()
+}
+
+// isServiceArn is true for ARNs of the service in any partition, e.g. arn:aws-cn:iam::...
+func isServiceArn(s string, service string) bool {
+	a, err := arn.Parse(s)
+	return err == nil && a.Service == service && a.Resource != ""
+}
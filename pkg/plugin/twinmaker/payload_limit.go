@@ -0,0 +1,93 @@
+package twinmaker
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// responseSize is the size of the frames as JSON, the format the browser receives them in
+func responseSize(frames data.Frames) int {
+	size := 0
+	for _, frame := range frames {
+		b, err := data.FrameToJSON(frame, data.IncludeAll)
+		if err == nil {
+			size += len(b)
+		}
+	}
+	return size
+}
+
+// limitResponseSize downsamples the frames until the response is at most limit bytes. Every frame
+// keeps the same share of evenly spaced rows including its first and last row, so the result does
+// not depend on timing and the time range stays covered. The limit is per query, a request of
+// several queries can be larger, and it applies before the data summary is added.
+func limitResponseSize(res *backend.DataResponse, limit int) {
+	if limit <= 0 || len(res.Frames) == 0 {
+		return
+	}
+	size := responseSize(res.Frames)
+	if size <= limit {
+		return
+	}
+
+	before := 0
+	for _, frame := range res.Frames {
+		before += frame.Rows()
+	}
+	// the notice counts towards the limit, the final row count is never longer than the first
+	text := func(after int) string {
+		return fmt.Sprintf("Response exceeded the limit of %d bytes, %d of %d rows are shown evenly spaced", limit, after, before)
+	}
+	res.Frames[0].AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: text(before)})
+	notices := res.Frames[0].Meta.Notices
+	size = responseSize(res.Frames)
+
+	after := before
+	for size > limit && after > 0 {
+		ratio := float64(limit) / float64(size)
+		after = 0
+		for _, frame := range res.Frames {
+			downsampleFrame(frame, ratio)
+			after += frame.Rows()
+		}
+		size = responseSize(res.Frames)
+	}
+	notices[len(notices)-1].Text = text(after)
+}
+
+// downsampleFrame keeps ratio of the rows of the frame, at least one row less than before
+func downsampleFrame(frame *data.Frame, ratio float64) {
+	rows := frame.Rows()
+	if rows == 0 {
+		return
+	}
+	keep := int(float64(rows) * ratio)
+	if keep >= rows {
+		keep = rows - 1
+	}
+	if keep < 0 {
+		keep = 0
+	}
+
+	index := func(j int) int {
+		if keep == 1 {
+			return 0
+		}
+		return j * (rows - 1) / (keep - 1)
+	}
+	for i, field := range frame.Fields {
+		if field.Len() != rows {
+			continue
+		}
+		sampled := data.NewFieldFromFieldType(field.Type(), keep)
+		sampled.Name = field.Name
+		sampled.Labels = field.Labels
+		sampled.Config = field.Config
+		for j := 0; j < keep; j++ {
+			sampled.Set(j, field.CopyAt(index(j)))
+		}
+		frame.Fields[i] = sampled
+	}
+}
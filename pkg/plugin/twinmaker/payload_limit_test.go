@@ -0,0 +1,95 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitResponseSize(t *testing.T) {
+	response := func() backend.DataResponse {
+		start := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+		times := make([]time.Time, 1000)
+		values := make([]float64, 1000)
+		for i := range times {
+			times[i] = start.Add(time.Duration(i) * time.Second)
+			values[i] = float64(i)
+		}
+		return backend.DataResponse{Frames: data.Frames{
+			data.NewFrame("Temperature", data.NewField("time", nil, times), data.NewField("Temperature", nil, values)),
+		}}
+	}
+
+	res := response()
+	size := responseSize(res.Frames)
+	limitResponseSize(&res, size)
+	require.Equal(t, 1000, res.Frames[0].Rows())
+	require.Nil(t, res.Frames[0].Meta)
+
+	res = response()
+	limitResponseSize(&res, size/4)
+	frame := res.Frames[0]
+	require.LessOrEqual(t, responseSize(res.Frames), size/4)
+	require.Less(t, frame.Rows(), 1000)
+	require.Greater(t, frame.Rows(), 100)
+	require.Equal(t, 0.0, frame.Fields[1].At(0))
+	require.Equal(t, 999.0, frame.Fields[1].At(frame.Rows()-1))
+	require.Len(t, frame.Meta.Notices, 1)
+
+	// the same rows are kept every time
+	again := response()
+	limitResponseSize(&again, size/4)
+	require.Equal(t, res.Frames[0].Fields[1], again.Frames[0].Fields[1])
+}
+
+// longHistoryMockClient returns one page of 1000 values a second apart
+type longHistoryMockClient struct {
+	*twinMakerMockClient
+}
+
+func (c *longHistoryMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	start := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	values := make([]*iottwinmaker.PropertyValue, 1000)
+	for i := range values {
+		values[i] = &iottwinmaker.PropertyValue{
+			Time:  aws.String(start.Add(time.Duration(i) * time.Second).Format(time.RFC3339)),
+			Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(float64(i))},
+		}
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String("Mixer_0"),
+			ComponentName: aws.String("MixerComponent"),
+			PropertyName:  aws.String("Temperature"),
+		},
+		Values: values,
+	}}}, nil
+}
+
+func TestLimitResponseSizeDataSummary(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w", MaxResponseBytes: 4000}, &longHistoryMockClient{&twinMakerMockClient{}})
+	res := ds.Query(context.Background(), models.TwinMakerQuery{
+		QueryType:     models.QueryTypeEntityHistory,
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+		DataSummary:   true,
+	})
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+
+	// the summary counts the rows that are shown, not the rows before the limit
+	rows := res.Frames[0].Rows()
+	require.Less(t, rows, 1000)
+	summary := res.Frames[1]
+	require.Equal(t, "summary", summary.Name)
+	count, _ := summary.FieldByName("count")
+	require.Equal(t, int64(rows), count.At(0))
+}
@@ -0,0 +1,56 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Preview queries are sent by the query editor while a query is edited. They load at most one
+// page and previewMaxRows rows, and give up after previewTimeout.
+const (
+	previewMaxRows = 100
+	previewTimeout = 5 * time.Second
+)
+
+type previewKey struct{}
+
+// withPreview returns a context with the preview timeout in which paging stops after the first page
+func withPreview(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, previewKey{}, true)
+	return context.WithTimeout(ctx, previewTimeout)
+}
+
+func isPreview(ctx context.Context) bool {
+	v, _ := ctx.Value(previewKey{}).(bool)
+	return v
+}
+
+// previewQuery caps the page size of the query to the preview rows
+func previewQuery(query models.TwinMakerQuery) models.TwinMakerQuery {
+	if query.MaxResults == 0 || query.MaxResults > previewMaxRows {
+		query.MaxResults = previewMaxRows
+	}
+	return query
+}
+
+// truncatePreview cuts the frames to the preview rows and marks the response as a preview
+func truncatePreview(res *backend.DataResponse) {
+	for _, frame := range res.Frames {
+		for _, field := range frame.Fields {
+			for field.Len() > previewMaxRows {
+				field.Delete(field.Len() - 1)
+			}
+		}
+	}
+	if len(res.Frames) > 0 {
+		res.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Preview, only the first page and at most %d rows are loaded", previewMaxRows),
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package twinmaker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// GetPropertyValueHistory limit of selected properties per request
+	maxSelectedProperties = 10
+	// maxPropertyGroupCalls bounds the property groups of a query loaded at the same time
+	maxPropertyGroupCalls = 4
+)
+
+// propertyGroups splits the properties of a query into groups of one request each
+func propertyGroups(properties []*string) [][]*string {
+	groups := [][]*string{}
+	for len(properties) > maxSelectedProperties {
+		groups = append(groups, properties[:maxSelectedProperties])
+		properties = properties[maxSelectedProperties:]
+	}
+	return append(groups, properties)
+}
+
+// getPropertyGroupHistory loads the history of wide queries with a request per property group,
+// the groups run concurrently. Each group pages until the query deadline on its own since the
+// nextTokens of the groups cannot be continued together, the result has no NextToken and a
+// partial notice when a group stopped early. Queries with a limit stop at it.
+func (s *twinMakerHandler) getPropertyGroupHistory(ctx context.Context, query models.TwinMakerQuery, groups [][]*string) (*iottwinmaker.GetPropertyValueHistoryOutput, []data.Notice, error) {
+	load := func(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+		return s.GetPropertyValueHistoryPaginated(ctx, query, nil)
+	}
+	if query.Limit > 0 {
+		load = s.GetPropertyValueHistoryLimited
+	}
+
+	results := make([]*iottwinmaker.GetPropertyValueHistoryOutput, len(groups))
+	errs := make([]error, len(groups))
+	slots := make(chan struct{}, maxPropertyGroupCalls)
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func(i int, group []*string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			q := query
+			q.Properties = group
+			results[i], errs[i] = load(ctx, q)
+		}(i, group)
+	}
+	wg.Wait()
+
+	// in the order of the query properties
+	merged := &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{}}
+	partial := false
+	for i, result := range results {
+		if errs[i] != nil {
+			return nil, nil, errs[i]
+		}
+		if result == nil {
+			continue
+		}
+		merged.PropertyValues = append(merged.PropertyValues, result.PropertyValues...)
+		partial = partial || result.NextToken != nil
+	}
+	if partial {
+		return merged, []data.Notice{partialNotice(ctx, false)}, nil
+	}
+	return merged, []data.Notice{}, nil
+}
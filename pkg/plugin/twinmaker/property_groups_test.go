@@ -0,0 +1,104 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropertyGroups(t *testing.T) {
+	properties := func(n int) []*string {
+		p := make([]*string, n)
+		for i := range p {
+			p[i] = aws.String(fmt.Sprintf("p%d", i))
+		}
+		return p
+	}
+	require.Len(t, propertyGroups(properties(3)), 1)
+	require.Len(t, propertyGroups(properties(maxSelectedProperties)), 1)
+
+	groups := propertyGroups(properties(25))
+	require.Len(t, groups, 3)
+	require.Len(t, groups[0], 10)
+	require.Len(t, groups[2], 5)
+	require.Equal(t, "p20", *groups[2][0])
+}
+
+// propertyGroupMockClient returns a value for each selected property and records the calls
+type propertyGroupMockClient struct {
+	*twinMakerMockClient
+	mu          sync.Mutex
+	calls       int
+	inflight    int
+	maxInflight int
+}
+
+func (c *propertyGroupMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{}, nil
+}
+
+func (c *propertyGroupMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	if len(query.Properties) > maxSelectedProperties {
+		return nil, fmt.Errorf("too many selected properties")
+	}
+	c.mu.Lock()
+	c.calls++
+	c.inflight++
+	if c.inflight > c.maxInflight {
+		c.maxInflight = c.inflight
+	}
+	c.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	c.inflight--
+	c.mu.Unlock()
+
+	rsp := &iottwinmaker.GetPropertyValueHistoryOutput{}
+	for _, p := range query.Properties {
+		rsp.PropertyValues = append(rsp.PropertyValues, &iottwinmaker.PropertyValueHistory{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(query.EntityId),
+				ComponentName: aws.String(query.ComponentName),
+				PropertyName:  p,
+			},
+			Values: []*iottwinmaker.PropertyValue{{
+				Time:  aws.String("2022-04-27T10:00:00Z"),
+				Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(1)},
+			}},
+		})
+	}
+	return rsp, nil
+}
+
+func TestEntityHistoryPropertyGroups(t *testing.T) {
+	client := &propertyGroupMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+	}
+	for i := 0; i < 45; i++ {
+		query.Properties = append(query.Properties, aws.String(fmt.Sprintf("p%d", i)))
+	}
+
+	dr := handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, 5, client.calls)
+	require.LessOrEqual(t, client.maxInflight, maxPropertyGroupCalls)
+	require.Greater(t, client.maxInflight, 1)
+
+	// one frame per property in the order of the query
+	require.Len(t, dr.Frames, 45)
+	for i, frame := range dr.Frames {
+		value, _ := frame.FieldByName(fmt.Sprintf("p%d", i))
+		require.NotNil(t, value)
+		require.Empty(t, frame.Meta.Notices)
+	}
+}
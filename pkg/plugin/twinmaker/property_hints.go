@@ -0,0 +1,142 @@
+package twinmaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Configuration keys of property definitions the plugin reads, so the twin model can govern how
+// dashboards show a property. They are set on the component type and inherited by the entities.
+const (
+	hintAggregation = "grafana_aggregation" // avg, min, max, last or count, the PropertyHeatmap default
+	hintInterval    = "grafana_interval"    // expected time between values as a Go duration, e.g. 1m
+	hintUnit        = "grafana_unit"        // Grafana unit of the value field, e.g. celsius
+)
+
+type propertyHints struct {
+	aggregation models.HeatmapAggregation
+	interval    time.Duration
+	unit        string
+	// declared type of the values, so the value field does not depend on the first value
+	dataType *iottwinmaker.DataType
+}
+
+// definitionHints reads the hints of a property definition, invalid values are ignored
+func definitionHints(def *iottwinmaker.PropertyDefinitionResponse) (hints propertyHints) {
+	if def == nil {
+		return
+	}
+	hints.dataType = def.DataType
+	if def.Configuration == nil {
+		return
+	}
+	switch a := aws.StringValue(def.Configuration[hintAggregation]); a {
+	case models.HeatmapAvg, models.HeatmapMin, models.HeatmapMax, models.HeatmapLast, models.HeatmapCount:
+		hints.aggregation = a
+	}
+	if d, err := time.ParseDuration(aws.StringValue(def.Configuration[hintInterval])); err == nil && d > 0 {
+		hints.interval = d
+	}
+	hints.unit = aws.StringValue(def.Configuration[hintUnit])
+	return
+}
+
+// propertyHints are the hints of the queried properties by name. They come from the component
+// type of component history queries, otherwise from the entity component. Both are metadata
+// calls of the cached client, and queries run without hints when the definitions cannot be read.
+func (s *twinMakerHandler) propertyHints(ctx context.Context, query models.TwinMakerQuery) map[string]propertyHints {
+	definitions := s.propertyDefinitions(ctx, query)
+	if definitions == nil {
+		return nil
+	}
+
+	hints := map[string]propertyHints{}
+	for _, p := range query.Properties {
+		if p == nil {
+			continue
+		}
+		if h := definitionHints(definitions[*p]); h != (propertyHints{}) {
+			hints[*p] = h
+		}
+	}
+	return hints
+}
+
+// propertyDefinitions are the property definitions of the component type of the query, otherwise
+// of the entity component, nil when they cannot be read
+func (s *twinMakerHandler) propertyDefinitions(ctx context.Context, query models.TwinMakerQuery) map[string]*iottwinmaker.PropertyDefinitionResponse {
+	definitions := map[string]*iottwinmaker.PropertyDefinitionResponse{}
+	if query.ComponentTypeId != "" {
+		ct, err := s.client.GetComponentType(ctx, query)
+		if err != nil || ct == nil {
+			return nil
+		}
+		definitions = ct.PropertyDefinitions
+	} else if query.EntityId != "" && query.ComponentName != "" {
+		entity, err := s.client.GetEntity(ctx, query)
+		if err != nil || entity == nil || entity.Components[query.ComponentName] == nil {
+			return nil
+		}
+		for name, p := range entity.Components[query.ComponentName].Properties {
+			if p != nil {
+				definitions[name] = p.Definition
+			}
+		}
+	}
+	return definitions
+}
+
+// maxHintInterval is the largest expected interval of the queried properties
+func maxHintInterval(hints map[string]propertyHints) (interval time.Duration) {
+	for _, h := range hints {
+		if h.interval > interval {
+			interval = h.interval
+		}
+	}
+	return
+}
+
+// applyFieldHints sets the unit of the value fields and the interval of their time fields,
+// unless the query already set a config for them (e.g. the detected AlignToResolution interval)
+func applyFieldHints(dr *backend.DataResponse, hints map[string]propertyHints) {
+	for _, frame := range dr.Frames {
+		var interval time.Duration
+		for _, f := range frame.Fields {
+			h, ok := hints[f.Labels["propertyName"]]
+			if !ok {
+				continue
+			}
+			if h.unit != "" {
+				if f.Config == nil {
+					f.Config = &data.FieldConfig{}
+				}
+				if f.Config.Unit == "" {
+					f.Config.Unit = h.unit
+				}
+			}
+			if h.interval > interval {
+				interval = h.interval
+			}
+		}
+		if interval == 0 {
+			continue
+		}
+		for _, f := range frame.Fields {
+			if f.Type() != data.FieldTypeTime && f.Type() != data.FieldTypeNullableTime {
+				continue
+			}
+			if f.Config == nil {
+				f.Config = &data.FieldConfig{}
+			}
+			if f.Config.Interval == 0 {
+				f.Config.Interval = float64(interval.Milliseconds())
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefinitionHints(t *testing.T) {
+	require.Equal(t, propertyHints{}, definitionHints(nil))
+	require.Equal(t, propertyHints{aggregation: models.HeatmapMax, interval: time.Minute, unit: "celsius"}, definitionHints(&iottwinmaker.PropertyDefinitionResponse{
+		Configuration: map[string]*string{
+			hintAggregation: aws.String("max"),
+			hintInterval:    aws.String("1m"),
+			hintUnit:        aws.String("celsius"),
+		},
+	}))
+	// invalid values are ignored
+	require.Equal(t, propertyHints{}, definitionHints(&iottwinmaker.PropertyDefinitionResponse{
+		Configuration: map[string]*string{
+			hintAggregation: aws.String("median"),
+			hintInterval:    aws.String("often"),
+		},
+	}))
+}
+
+type propertyHintsMockClient struct {
+	alignmentMockClient
+}
+
+func (c *propertyHintsMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{
+		EntityId: aws.String("Mixer_0"),
+		Components: map[string]*iottwinmaker.ComponentResponse{
+			"MixerComponent": {
+				Properties: map[string]*iottwinmaker.PropertyResponse{
+					"Temperature": {Definition: &iottwinmaker.PropertyDefinitionResponse{
+						Configuration: map[string]*string{
+							hintInterval: aws.String("15m"),
+							hintUnit:     aws.String("celsius"),
+						},
+					}},
+				},
+			},
+		},
+	}, nil
+}
+
+func TestEntityHistoryPropertyHints(t *testing.T) {
+	client := &propertyHintsMockClient{alignmentMockClient{twinMakerMockClient: &twinMakerMockClient{}}}
+	handler := newTwinMakerHandler(client, nil)
+	from := time.Date(2022, 4, 27, 10, 2, 30, 0, time.UTC)
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+		TimeRange:     backend.TimeRange{From: from, To: from.Add(time.Hour)},
+	}
+
+	dr := handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	timeField, _ := dr.Frames[0].FieldByName(data.TimeSeriesTimeFieldName)
+	require.Equal(t, float64(15*time.Minute/time.Millisecond), timeField.Config.Interval)
+	value, _ := dr.Frames[0].FieldByName("Temperature")
+	require.Equal(t, "celsius", value.Config.Unit)
+	require.Equal(t, []time.Time{from}, client.from)
+
+	// aligned queries start on the expected interval until one is detected, which then wins
+	query.AlignToResolution = true
+	dr = handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Equal(t, time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC), client.from[1])
+	timeField, _ = dr.Frames[0].FieldByName(data.TimeSeriesTimeFieldName)
+	require.Equal(t, float64(5*time.Minute/time.Millisecond), timeField.Config.Interval)
+}
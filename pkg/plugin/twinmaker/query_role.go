@@ -0,0 +1,47 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// SetQueryRoleClients sets how the clients of the query roles are created. Every role gets its
+// own client and cache on first use, so results of different roles are not mixed.
+func (ds *Datasource) SetQueryRoleClients(newClient func(roleArn string) (TwinMakerClient, error)) {
+	ds.rolesMu.Lock()
+	defer ds.rolesMu.Unlock()
+	ds.newRoleClient = newClient
+	ds.roles = map[string]TwinMakerHandler{}
+}
+
+// queryHandler is the handler of the query role, the viewer role of anonymous requests takes
+// precedence so kiosk displays can not widen their permissions. Debug bundles record with their own.
+func (ds *Datasource) queryHandler(ctx context.Context, query models.TwinMakerQuery) (TwinMakerHandler, error) {
+	if handler := debugHandlerFrom(ctx); handler != nil {
+		return handler, nil
+	}
+	if query.RoleArn == "" || (ds.Viewer != nil && usesViewerRole(ctx)) {
+		return ds.HandlerFor(ctx), nil
+	}
+	if !ds.Settings.QueryRoleAllowed(query.RoleArn) {
+		return nil, fmt.Errorf("role %s is not allowed in datasource configuration", query.RoleArn)
+	}
+
+	ds.rolesMu.Lock()
+	defer ds.rolesMu.Unlock()
+	if handler, ok := ds.roles[query.RoleArn]; ok {
+		return handler, nil
+	}
+	if ds.newRoleClient == nil {
+		return nil, fmt.Errorf("query roles are not available")
+	}
+	c, err := ds.newRoleClient(query.RoleArn)
+	if err != nil {
+		return nil, err
+	}
+	handler := newRoleHandler(NewCachingClient(c, DefaultCacheTTL), ds.Settings, ds.redaction)
+	ds.roles[query.RoleArn] = handler
+	return handler, nil
+}
@@ -0,0 +1,83 @@
+package twinmaker
+
+import (
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// redactedValue replaces the values of masked properties
+const redactedValue = "***"
+
+// redactor applies the datasource redaction rules while values are converted to frames.
+// A nil redactor does not redact anything.
+type redactor struct {
+	rules []models.RedactionRule
+}
+
+func newRedactor(rules []models.RedactionRule) *redactor {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &redactor{rules: rules}
+}
+
+// action returns how the property is redacted, empty when no rule matches. The first matching
+// rule wins.
+func (r *redactor) action(propertyName string) models.RedactionAction {
+	if r == nil {
+		return ""
+	}
+	for _, rule := range r.rules {
+		if ok, _ := path.Match(rule.Pattern, propertyName); ok {
+			if rule.Action == "" {
+				return models.RedactionMask
+			}
+			return rule.Action
+		}
+	}
+	return ""
+}
+
+// value returns the value to show for the property, false when it should be dropped
+func (r *redactor) value(propertyName string, v *iottwinmaker.DataValue) (*iottwinmaker.DataValue, bool) {
+	switch r.action(propertyName) {
+	case models.RedactionDrop:
+		return nil, false
+	case models.RedactionMask:
+		return maskedDataValue(), true
+	}
+	return v, true
+}
+
+// valueString is value for values that are already strings, dropped values are empty
+func (r *redactor) valueString(propertyName string, v string) string {
+	switch r.action(propertyName) {
+	case models.RedactionDrop:
+		return ""
+	case models.RedactionMask:
+		return redactedValue
+	}
+	return v
+}
+
+// entity removes or masks the property values of the entity in place, the definitions are kept
+func (r *redactor) entity(entity *iottwinmaker.GetEntityOutput) {
+	if r == nil || entity == nil {
+		return
+	}
+	for _, component := range entity.Components {
+		for name, prop := range component.Properties {
+			if prop == nil || prop.Value == nil {
+				continue
+			}
+			prop.Value, _ = r.value(name, prop.Value)
+		}
+	}
+}
+
+func maskedDataValue() *iottwinmaker.DataValue {
+	return &iottwinmaker.DataValue{StringValue: aws.String(redactedValue)}
+}
@@ -0,0 +1,64 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedaction(t *testing.T) {
+	redaction := newRedactor([]models.RedactionRule{
+		{Pattern: "Operator*"},
+		{Pattern: "Temp*", Action: models.RedactionDrop},
+	})
+	require.Nil(t, newRedactor(nil))
+	require.Equal(t, models.RedactionMask, redaction.action("OperatorName"))
+	require.Equal(t, models.RedactionDrop, redaction.action("Temperature"))
+	require.Equal(t, "", redaction.action("RPM"))
+
+	t.Run("property values", func(t *testing.T) {
+		handler := newTwinMakerHandler(&propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}}, redaction)
+		dr := handler.GetPropertyValue(context.Background(), models.TwinMakerQuery{
+			EntityId:      "Mixer_0",
+			ComponentName: "MixerComponent",
+			Properties:    []*string{aws.String("OperatorName"), aws.String("RPM"), aws.String("Temperature")},
+		})
+		require.NoError(t, dr.Error)
+		fields := dr.Frames[0].Fields
+		require.Len(t, fields, 2)
+		require.Equal(t, "OperatorName", fields[0].Name)
+		require.Equal(t, redactedValue, *fields[0].At(0).(*string))
+		require.Equal(t, "RPM", fields[1].Name)
+		require.Equal(t, 3.0, *fields[1].At(0).(*float64))
+	})
+
+	t.Run("history", func(t *testing.T) {
+		handler := newTwinMakerHandler(&booleanHistoryMockClient{twinMakerMockClient: &twinMakerMockClient{}}, newRedactor([]models.RedactionRule{{Pattern: "Running"}}))
+		dr := handler.GetEntityHistory(context.Background(), models.TwinMakerQuery{
+			EntityId:      "Mixer_0",
+			ComponentName: "MixerComponent",
+			Properties:    []*string{aws.String("Running")},
+		})
+		require.NoError(t, dr.Error)
+		v := dr.Frames[0].Fields[0]
+		require.Equal(t, 2, v.Len())
+		require.Equal(t, redactedValue, *v.At(1).(*string))
+	})
+
+	t.Run("watchlist", func(t *testing.T) {
+		w := NewWatchlist(&propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}}, "AlarmWorkspace", 0)
+		w.redaction = redaction
+		require.NoError(t, w.SetItems([]models.WatchlistItem{
+			{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "Temperature"},
+			{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "RPM"},
+		}))
+		dr := w.Query(context.Background())
+		require.NoError(t, dr.Error)
+		fields := dr.Frames[0].Fields
+		require.Len(t, fields, 2) // time + RPM
+		require.Equal(t, "RPM", fields[1].Name)
+	})
+}
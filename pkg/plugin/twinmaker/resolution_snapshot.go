@@ -0,0 +1,238 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/patrickmn/go-cache"
+)
+
+// ResolutionSnapshotTTL is how long a resolution snapshot is kept after it was last used. With a
+// metadata cache file the snapshots are kept in it too, they survive a restart of the plugin until
+// ResolutionSnapshotTTL after their last change.
+const ResolutionSnapshotTTL = 7 * 24 * time.Hour
+
+// maxResolutionSnapshots bounds the snapshots kept in memory, the least recently used one is
+// dropped for a new one
+const maxResolutionSnapshots = 1000
+
+// resolutionSnapshot pins the entity components of the externalIds a pinned query resolved. The
+// first resolution of an externalId is kept, later queries of the snapshot reuse it even when
+// the externalId moved to another entity since.
+type resolutionSnapshot struct {
+	time    time.Time
+	key     string
+	mu      sync.Mutex
+	entries map[string]externalIdResolution
+	// resolutions were added since the snapshot was stored
+	changed bool
+}
+
+// storedResolutionSnapshot is a snapshot in the metadata cache file
+type storedResolutionSnapshot struct {
+	Time    time.Time                   `json:"time"`
+	Entries map[string]storedResolution `json:"entries"`
+}
+
+type storedResolution struct {
+	EntityId      string `json:"entityId"`
+	EntityName    string `json:"entityName"`
+	ComponentName string `json:"componentName"`
+}
+
+func newResolutionSnapshotCache() *cache.Cache {
+	return cache.New(ResolutionSnapshotTTL, time.Hour)
+}
+
+// the keys of snapshots and of the snapshot of each pinned query in the metadata cache file
+func resolutionSnapshotStoreKey(key string) string {
+	return "resolution-snapshot/" + key
+}
+
+func resolutionPinStoreKey(key string) string {
+	return "resolution-pin/" + key
+}
+
+// get is safe on a nil snapshot, queries without one resolve from the twin model
+func (r *resolutionSnapshot) get(key string) (externalIdResolution, bool) {
+	if r == nil {
+		return externalIdResolution{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.entries[key]
+	return res, ok
+}
+
+func (r *resolutionSnapshot) set(key string, res externalIdResolution) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[key]; !ok {
+		r.entries[key] = res
+		r.changed = true
+	}
+}
+
+// save stores the snapshot in the metadata cache file when resolutions were added to it
+func (r *resolutionSnapshot) save(store *metadataStore) {
+	if r == nil || store == nil {
+		return
+	}
+	r.mu.Lock()
+	if !r.changed {
+		r.mu.Unlock()
+		return
+	}
+	stored := storedResolutionSnapshot{Time: r.time, Entries: make(map[string]storedResolution, len(r.entries))}
+	for key, res := range r.entries {
+		stored.Entries[key] = storedResolution{EntityId: res.entityId, EntityName: res.entityName, ComponentName: res.componentName}
+	}
+	r.changed = false
+	r.mu.Unlock()
+	store.put(resolutionSnapshotStoreKey(r.key), stored)
+}
+
+type resolutionSnapshotKey struct{}
+
+func withResolutionSnapshot(ctx context.Context, snapshot *resolutionSnapshot) context.Context {
+	if snapshot == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, resolutionSnapshotKey{}, snapshot)
+}
+
+func resolutionSnapshotFrom(ctx context.Context) *resolutionSnapshot {
+	snapshot, _ := ctx.Value(resolutionSnapshotKey{}).(*resolutionSnapshot)
+	return snapshot
+}
+
+// resolutionSnapshot returns the snapshot of a query, nil unless it pins its resolution. Snapshots
+// belong to a workspace and role since the roles may resolve different entities. A pinned query
+// keeps the snapshot it took first, so re-running it does not take another one. A snapshot that is
+// no longer kept is replaced by a new one with a warning.
+func (ds *Datasource) resolutionSnapshot(ctx context.Context, query models.TwinMakerQuery) (*resolutionSnapshot, []data.Notice) {
+	if !query.PinResolution && query.ResolutionSnapshot == nil {
+		return nil, nil
+	}
+	workspaceId := query.WorkspaceId
+	if workspaceId == "" {
+		workspaceId = ds.workspaceFor(ctx)
+	}
+	scope := fmt.Sprintf("%s/%s/%t@", workspaceId, query.RoleArn, ds.Viewer != nil && usesViewerRole(ctx))
+
+	// the snapshot a pinned query took before, by the query without its paging and snapshot
+	pin := ""
+	if query.ResolutionSnapshot == nil {
+		if key := query.CacheKey(string(query.QueryType)); key != "" {
+			pin = scope + key
+			if t := ds.resolutionPin(pin); !t.IsZero() {
+				if snapshot := ds.loadResolutionSnapshot(scope + t.UTC().Format(time.RFC3339)); snapshot != nil {
+					return snapshot, nil
+				}
+			}
+		}
+	}
+
+	var notices []data.Notice
+	if t := query.ResolutionSnapshot; t != nil {
+		if snapshot := ds.loadResolutionSnapshot(scope + t.UTC().Format(time.RFC3339)); snapshot != nil {
+			return snapshot, nil
+		}
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("resolution snapshot %s is no longer available, entities were resolved from the current twin model", t.UTC().Format(time.RFC3339)),
+		})
+	}
+
+	// queries pinned in the same second share the snapshot
+	now := time.Now().UTC().Truncate(time.Second)
+	key := scope + now.Format(time.RFC3339)
+	snapshot := &resolutionSnapshot{time: now, key: key, entries: map[string]externalIdResolution{}}
+	ds.evictResolutionSnapshot()
+	if err := ds.resolutionSnapshots.Add(key, snapshot, cache.DefaultExpiration); err != nil {
+		if v, ok := ds.resolutionSnapshots.Get(key); ok {
+			snapshot = v.(*resolutionSnapshot)
+		}
+	}
+	if pin != "" {
+		ds.resolutionPins.SetDefault(pin, now)
+		if ds.store != nil {
+			ds.store.put(resolutionPinStoreKey(pin), now)
+		}
+	}
+	return snapshot, notices
+}
+
+// resolutionPin is the time of the snapshot a pinned query took, zero when it has none
+func (ds *Datasource) resolutionPin(pin string) time.Time {
+	if v, ok := ds.resolutionPins.Get(pin); ok {
+		ds.resolutionPins.SetDefault(pin, v)
+		return v.(time.Time)
+	}
+	var t time.Time
+	if ds.store != nil && ds.store.read(resolutionPinStoreKey(pin), &t, ResolutionSnapshotTTL) {
+		ds.resolutionPins.SetDefault(pin, t)
+	}
+	return t
+}
+
+// loadResolutionSnapshot is the kept snapshot of the key, from the metadata cache file after a
+// restart, nil when it is no longer kept
+func (ds *Datasource) loadResolutionSnapshot(key string) *resolutionSnapshot {
+	if v, ok := ds.resolutionSnapshots.Get(key); ok {
+		// keep snapshots that are still used
+		ds.resolutionSnapshots.SetDefault(key, v)
+		return v.(*resolutionSnapshot)
+	}
+	stored := storedResolutionSnapshot{}
+	if ds.store == nil || !ds.store.read(resolutionSnapshotStoreKey(key), &stored, ResolutionSnapshotTTL) {
+		return nil
+	}
+	snapshot := &resolutionSnapshot{time: stored.Time, key: key, entries: make(map[string]externalIdResolution, len(stored.Entries))}
+	for k, res := range stored.Entries {
+		snapshot.entries[k] = externalIdResolution{entityId: res.EntityId, entityName: res.EntityName, componentName: res.ComponentName}
+	}
+	ds.evictResolutionSnapshot()
+	ds.resolutionSnapshots.SetDefault(key, snapshot)
+	return snapshot
+}
+
+// evictResolutionSnapshot drops the least recently used snapshot when maxResolutionSnapshots are
+// kept, the one expiring first
+func (ds *Datasource) evictResolutionSnapshot() {
+	items := ds.resolutionSnapshots.Items()
+	if len(items) < maxResolutionSnapshots {
+		return
+	}
+	oldest := ""
+	var expiration int64
+	for key, item := range items {
+		if oldest == "" || item.Expiration < expiration {
+			oldest, expiration = key, item.Expiration
+		}
+	}
+	ds.resolutionSnapshots.Delete(oldest)
+}
+
+// setResolutionSnapshot echoes the snapshot time in the frame meta
+func setResolutionSnapshot(res *backend.DataResponse, snapshot *resolutionSnapshot, notices []data.Notice) {
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		meta, _ := frame.Meta.Custom.(models.TwinMakerCustomMeta)
+		meta.ResolutionSnapshot = snapshot.time.Format(time.RFC3339)
+		frame.Meta.Custom = meta
+	}
+	if len(res.Frames) > 0 {
+		res.Frames[0].AppendNotices(notices...)
+	}
+}
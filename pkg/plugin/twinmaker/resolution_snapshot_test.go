@@ -0,0 +1,184 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+// movedEntityMockClient resolves the alarm externalId to the entity set in the test
+type movedEntityMockClient struct {
+	*twinMakerMockClient
+	entityId string
+}
+
+func (c *movedEntityMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	return &iottwinmaker.GetComponentTypeOutput{PropertyDefinitions: map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_id": {IsExternalId: aws.Bool(true)},
+	}}, nil
+}
+
+func (c *movedEntityMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	return &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			PropertyName:       aws.String("alarm_status"),
+			ExternalIdProperty: map[string]*string{"alarm_id": aws.String("a1")},
+		},
+		Values: []*iottwinmaker.PropertyValue{{
+			Time:  aws.String("2022-04-27T10:00:00Z"),
+			Value: &iottwinmaker.DataValue{StringValue: aws.String("ACTIVE")},
+		}},
+	}}}, nil
+}
+
+func (c *movedEntityMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return &iottwinmaker.ListEntitiesOutput{EntitySummaries: []*iottwinmaker.EntitySummary{{
+		EntityId:   aws.String(c.entityId),
+		EntityName: aws.String(c.entityId),
+	}}}, nil
+}
+
+func (c *movedEntityMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	return &iottwinmaker.GetEntityOutput{Components: map[string]*iottwinmaker.ComponentResponse{
+		"Alarm": {
+			ComponentName:   aws.String("Alarm"),
+			ComponentTypeId: aws.String("com.example.alarm"),
+			Properties: map[string]*iottwinmaker.PropertyResponse{
+				"alarm_id": {
+					Definition: &iottwinmaker.PropertyDefinitionResponse{IsExternalId: aws.Bool(true)},
+					Value:      &iottwinmaker.DataValue{StringValue: aws.String("a1")},
+				},
+			},
+		},
+	}}, nil
+}
+
+func TestResolutionSnapshotLookup(t *testing.T) {
+	client := &movedEntityMockClient{twinMakerMockClient: &twinMakerMockClient{}, entityId: "Mixer_0"}
+	handler := newTwinMakerHandler(client, nil)
+	handler.externalIds = nil
+	query := models.TwinMakerQuery{
+		WorkspaceId:     "w",
+		ComponentTypeId: "com.example.alarm",
+		Properties:      []*string{aws.String("alarm_status")},
+	}
+	entityId := func(ctx context.Context) string {
+		refs, _, _, err := handler.GetComponentHistoryWithLookup(ctx, query)
+		require.NoError(t, err)
+		require.Len(t, refs, 1)
+		return aws.StringValue(refs[0].entityPropertyReference.EntityId)
+	}
+
+	snapshot := &resolutionSnapshot{entries: map[string]externalIdResolution{}}
+	pinned := withResolutionSnapshot(context.Background(), snapshot)
+	require.Equal(t, "Mixer_0", entityId(pinned))
+
+	// the alarm moved to another entity, only the pinned query keeps the old one
+	client.entityId = "Mixer_1"
+	require.Equal(t, "Mixer_1", entityId(context.Background()))
+	require.Equal(t, "Mixer_0", entityId(pinned))
+}
+
+func TestResolutionSnapshot(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, &twinMakerMockClient{})
+	ctx := context.Background()
+
+	snapshot, notices := ds.resolutionSnapshot(ctx, models.TwinMakerQuery{})
+	require.Nil(t, snapshot)
+	require.Empty(t, notices)
+
+	// a pinned query takes a new snapshot, it is reused by its time
+	snapshot, notices = ds.resolutionSnapshot(ctx, models.TwinMakerQuery{PinResolution: true})
+	require.NotNil(t, snapshot)
+	require.Empty(t, notices)
+	reused, notices := ds.resolutionSnapshot(ctx, models.TwinMakerQuery{ResolutionSnapshot: &models.QueryTime{Time: snapshot.time}})
+	require.Same(t, snapshot, reused)
+	require.Empty(t, notices)
+
+	// snapshots belong to a workspace
+	other, _ := ds.resolutionSnapshot(ctx, models.TwinMakerQuery{WorkspaceId: "w2", ResolutionSnapshot: &models.QueryTime{Time: snapshot.time}})
+	require.NotSame(t, snapshot, other)
+
+	// an unknown snapshot is replaced with a warning
+	old := time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)
+	replaced, notices := ds.resolutionSnapshot(ctx, models.TwinMakerQuery{ResolutionSnapshot: &models.QueryTime{Time: old}})
+	require.NotEqual(t, old, replaced.time)
+	require.Len(t, notices, 1)
+	require.Equal(t, data.NoticeSeverityWarning, notices[0].Severity)
+
+	res := backend.DataResponse{Frames: data.Frames{data.NewFrame(""), data.NewFrame("")}}
+	setResolutionSnapshot(&res, replaced, notices)
+	for _, frame := range res.Frames {
+		require.Equal(t, replaced.time.Format(time.RFC3339), frame.Meta.Custom.(models.TwinMakerCustomMeta).ResolutionSnapshot)
+	}
+	require.Len(t, res.Frames[0].Meta.Notices, 1)
+}
+
+func TestResolutionSnapshotPerQuery(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, &twinMakerMockClient{})
+	ctx := context.Background()
+	query := models.TwinMakerQuery{QueryType: models.QueryTypeComponentHistory, ComponentTypeId: "com.example.alarm", PinResolution: true}
+
+	// re-running a pinned query keeps the snapshot it took first
+	old := time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC)
+	taken := &resolutionSnapshot{time: old, entries: map[string]externalIdResolution{}}
+	ds.resolutionSnapshots.SetDefault("w//false@"+old.Format(time.RFC3339), taken)
+	ds.resolutionPins.SetDefault("w//false@"+query.CacheKey(string(query.QueryType)), old)
+	snapshot, notices := ds.resolutionSnapshot(ctx, query)
+	require.Same(t, taken, snapshot)
+	require.Empty(t, notices)
+
+	// other queries take their own
+	query.ComponentTypeId = "com.example.pump"
+	other, _ := ds.resolutionSnapshot(ctx, query)
+	require.NotSame(t, taken, other)
+	again, _ := ds.resolutionSnapshot(ctx, query)
+	require.Same(t, other, again)
+}
+
+func TestResolutionSnapshotLimit(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, &twinMakerMockClient{})
+	for i := 0; i < maxResolutionSnapshots; i++ {
+		ds.resolutionSnapshots.SetDefault(fmt.Sprintf("w//false@%d", i), &resolutionSnapshot{})
+	}
+	snapshot, _ := ds.resolutionSnapshot(context.Background(), models.TwinMakerQuery{PinResolution: true})
+	require.NotNil(t, snapshot)
+	require.Equal(t, maxResolutionSnapshots, ds.resolutionSnapshots.ItemCount())
+}
+
+func TestResolutionSnapshotStored(t *testing.T) {
+	settings := models.TwinMakerDataSourceSetting{
+		WorkspaceID:       "w",
+		MetadataCacheFile: filepath.Join(t.TempDir(), "metadata.db"),
+		UID:               "abc",
+	}
+	query := models.TwinMakerQuery{QueryType: models.QueryTypeComponentHistory, ComponentTypeId: "com.example.alarm", PinResolution: true}
+	resolution := externalIdResolution{entityId: "Mixer_0", entityName: "Mixer 0", componentName: "Alarm"}
+
+	ds := NewDatasourceWithClient(settings, &twinMakerMockClient{})
+	snapshot, _ := ds.resolutionSnapshot(context.Background(), query)
+	snapshot.set("a1", resolution)
+	snapshot.save(ds.store)
+	require.NoError(t, ds.Close())
+
+	// a restarted plugin resolves from the stored snapshot, by the query and by its time
+	for _, q := range []models.TwinMakerQuery{query, {ResolutionSnapshot: &models.QueryTime{Time: snapshot.time}}} {
+		restarted := NewDatasourceWithClient(settings, &twinMakerMockClient{})
+		stored, notices := restarted.resolutionSnapshot(context.Background(), q)
+		require.Empty(t, notices)
+		require.Equal(t, snapshot.time, stored.time)
+		r, ok := stored.get("a1")
+		require.True(t, ok)
+		require.Equal(t, resolution, r)
+		require.NoError(t, restarted.Close())
+	}
+}
@@ -3,9 +3,12 @@ package twinmaker
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
 // Resource requests
@@ -14,23 +17,58 @@ type TwinMakerResources interface {
 	GetEntity(ctx context.Context, id string) (*iottwinmaker.GetEntityOutput, error)
 
 	BatchPutPropertyValues(context.Context, []*iottwinmaker.PropertyValueEntry) (*iottwinmaker.BatchPutPropertyValuesOutput, error)
+	AcknowledgeAlarms(ctx context.Context, alarms []models.AlarmReference) (models.AlarmAckReport, error)
+	// Snoozes alarms of SiteWise alarm models in IoT Events, uses the writer role
+	SnoozeAlarms(ctx context.Context, alarms []models.AlarmReference, duration time.Duration) (models.AlarmSnoozeReport, error)
+	// Writes a Grafana annotation to the note property of an entity component
+	WriteAnnotation(ctx context.Context, propertyName string, note models.AnnotationNote) (models.AnnotationNoteResult, error)
+	// Reads and changes the resource tags of entities, changes use the writer role
+	GetEntityTags(ctx context.Context, entityIds []string) ([]models.EntityTags, error)
+	UpdateEntityTags(ctx context.Context, updates []models.EntityTagUpdate) (models.EntityTagReport, error)
 
 	// Selectable values
 	ListWorkspaces(ctx context.Context) ([]models.SelectableString, error)
 	ListScenes(ctx context.Context) ([]models.SelectableString, error)
 	ListOptions(ctx context.Context) (models.OptionsInfo, error)
 	ListEntity(ctx context.Context, id string) ([]models.SelectableProps, error)
+	// Values of chained template variables, narrowed down by the parent variables
+	ListVariableOptions(ctx context.Context, kind models.VariableKind, parents models.VariableParents) ([]models.VariableOption, error)
+
+	// Evaluates the tag rules of a scene against the latest property values
+	EvaluateSceneRules(ctx context.Context, sceneId string) ([]models.SceneTagState, error)
+	// The distinct properties bound in a scene and their latest values, used by the scene stream
+	SceneBindings(ctx context.Context, sceneId string) ([]models.SceneDataBinding, error)
+	SceneBindingValues(ctx context.Context, bindings []models.SceneDataBinding) (*data.Frame, error)
+	// Uploads a glb/gltf model to the workspace bucket
+	UploadSceneAsset(ctx context.Context, name string, body []byte) (models.SceneAsset, error)
+
+	// Getting started workspace, created with the writer role
+	PlanDemoWorkspace(req models.DemoWorkspaceRequest) (models.DemoWorkspaceReport, error)
+	CreateDemoWorkspace(ctx context.Context, req models.DemoWorkspaceRequest) (models.DemoWorkspaceReport, error)
+
+	// Paginated listings
+	ListEntitiesPage(ctx context.Context, cursor string, maxResults int) (models.ResourcePage, error)
+	ListComponentTypesPage(ctx context.Context, cursor string, maxResults int) (models.ResourcePage, error)
 }
 
 type twinMakerResource struct {
 	workspaceId string
 	client      TwinMakerClient
+	redaction   *redactor
+
+	// alarms of SiteWise alarm models are also acknowledged in IoT Events
+	alarmModelSync bool
 }
 
 func NewTwinMakerResource(client TwinMakerClient, workspaceId string) TwinMakerResources {
+	return newTwinMakerResource(client, workspaceId, nil)
+}
+
+func newTwinMakerResource(client TwinMakerClient, workspaceId string, redaction *redactor) *twinMakerResource {
 	return &twinMakerResource{
 		client:      client,
 		workspaceId: workspaceId,
+		redaction:   redaction,
 	}
 }
 
@@ -44,7 +82,9 @@ func (r *twinMakerResource) GetEntity(ctx context.Context, entityId string) (*io
 		EntityId:    entityId,
 	}
 
-	return r.client.GetEntity(ctx, query)
+	rsp, err := r.client.GetEntity(ctx, query)
+	r.redaction.entity(rsp)
+	return rsp, err
 }
 
 func (r *twinMakerResource) ListWorkspaces(ctx context.Context) ([]models.SelectableString, error) {
@@ -259,6 +299,50 @@ func (r *twinMakerResource) ListEntity(ctx context.Context, entityId string) ([]
 	return results, err
 }
 
+func (r *twinMakerResource) ListEntitiesPage(ctx context.Context, cursor string, maxResults int) (models.ResourcePage, error) {
+	page := models.ResourcePage{}
+	nextToken, err := DecodeCursor(cursor)
+	if err != nil {
+		return page, err
+	}
+
+	query := models.TwinMakerQuery{
+		WorkspaceId: r.workspaceId,
+		NextToken:   nextToken,
+		MaxResults:  maxResults,
+	}
+	rsp, err := r.client.ListEntitiesPage(ctx, query)
+	if err != nil {
+		return page, err
+	}
+
+	page.Items = rsp.EntitySummaries
+	page.NextToken = EncodeCursor(rsp.NextToken)
+	return page, nil
+}
+
+func (r *twinMakerResource) ListComponentTypesPage(ctx context.Context, cursor string, maxResults int) (models.ResourcePage, error) {
+	page := models.ResourcePage{}
+	nextToken, err := DecodeCursor(cursor)
+	if err != nil {
+		return page, err
+	}
+
+	query := models.TwinMakerQuery{
+		WorkspaceId: r.workspaceId,
+		NextToken:   nextToken,
+		MaxResults:  maxResults,
+	}
+	rsp, err := r.client.ListComponentTypesPage(ctx, query)
+	if err != nil {
+		return page, err
+	}
+
+	page.Items = rsp.ComponentTypeSummaries
+	page.NextToken = EncodeCursor(rsp.NextToken)
+	return page, nil
+}
+
 func (r *twinMakerResource) BatchPutPropertyValues(ctx context.Context, entries []*iottwinmaker.PropertyValueEntry) (*iottwinmaker.BatchPutPropertyValuesOutput, error) {
 	input := &iottwinmaker.BatchPutPropertyValuesInput{
 		WorkspaceId: &r.workspaceId,
@@ -267,6 +351,230 @@ func (r *twinMakerResource) BatchPutPropertyValues(ctx context.Context, entries
 	return r.client.BatchPutPropertyValues(ctx, input)
 }
 
+// BatchPutPropertyValues accepts at most 10 entries per call
+const maxBatchPutEntries = 10
+
+// AcknowledgeAlarms sets alarm_status to ACKNOWLEDGED on every referenced alarm component.
+// Writes are sent in batches, and failed entries are reported instead of failing the whole request.
+// With alarm model sync, alarms of SiteWise alarm models are acknowledged in IoT Events as well.
+func (r *twinMakerResource) AcknowledgeAlarms(ctx context.Context, alarms []models.AlarmReference) (models.AlarmAckReport, error) {
+	report := models.AlarmAckReport{
+		Acknowledged: []models.AlarmReference{},
+	}
+	if len(alarms) == 0 {
+		return report, fmt.Errorf("missing alarms")
+	}
+
+	now := aws.String(time.Now().UTC().Format(time.RFC3339Nano))
+	entries := []*iottwinmaker.PropertyValueEntry{}
+	for _, alarm := range alarms {
+		if alarm.EntityId == "" || alarm.ComponentName == "" {
+			report.Failed = append(report.Failed, models.AlarmAckFailure{
+				AlarmReference: alarm,
+				ErrorMessage:   "missing entityId or componentName",
+			})
+			continue
+		}
+		entries = append(entries, &iottwinmaker.PropertyValueEntry{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(alarm.EntityId),
+				ComponentName: aws.String(alarm.ComponentName),
+				PropertyName:  aws.String("alarm_status"),
+			},
+			PropertyValues: []*iottwinmaker.PropertyValue{{
+				Time:  now,
+				Value: &iottwinmaker.DataValue{StringValue: aws.String("ACKNOWLEDGED")},
+			}},
+		})
+	}
+
+	for start := 0; start < len(entries); start += maxBatchPutEntries {
+		end := start + maxBatchPutEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+
+		rsp, err := r.BatchPutPropertyValues(ctx, batch)
+		if err != nil {
+			for _, entry := range batch {
+				report.Failed = append(report.Failed, models.AlarmAckFailure{
+					AlarmReference: toAlarmReference(entry.EntityPropertyReference),
+					ErrorMessage:   err.Error(),
+				})
+			}
+			continue
+		}
+
+		failed := map[models.AlarmReference]bool{}
+		if rsp != nil {
+			for _, errorEntry := range rsp.ErrorEntries {
+				for _, e := range errorEntry.Errors {
+					if e.Entry == nil {
+						continue
+					}
+					alarm := toAlarmReference(e.Entry.EntityPropertyReference)
+					failed[alarm] = true
+					report.Failed = append(report.Failed, models.AlarmAckFailure{
+						AlarmReference: alarm,
+						ErrorCode:      aws.StringValue(e.ErrorCode),
+						ErrorMessage:   aws.StringValue(e.ErrorMessage),
+					})
+				}
+			}
+		}
+		for _, entry := range batch {
+			alarm := toAlarmReference(entry.EntityPropertyReference)
+			if !failed[alarm] {
+				report.Acknowledged = append(report.Acknowledged, alarm)
+			}
+		}
+	}
+
+	if r.alarmModelSync && len(report.Acknowledged) > 0 {
+		// alarms IoT Events rejects are failed, each alarm is either acknowledged or failed
+		rejected := r.acknowledgeAlarmModels(ctx, report.Acknowledged)
+		report.Failed = append(report.Failed, rejected...)
+		failed := make(map[models.AlarmReference]bool, len(rejected))
+		for _, f := range rejected {
+			failed[f.AlarmReference] = true
+		}
+		acknowledged := []models.AlarmReference{}
+		for _, alarm := range report.Acknowledged {
+			if !failed[alarm] {
+				acknowledged = append(acknowledged, alarm)
+			}
+		}
+		report.Acknowledged = acknowledged
+	}
+	return report, nil
+}
+
+// loadSceneDocument reads the scene content from its s3:// location
+func (r *twinMakerResource) loadSceneDocument(ctx context.Context, sceneId string) (*sceneDocument, error) {
+	return loadSceneDocument(ctx, r.client, r.workspaceId, sceneId)
+}
+
+func (r *twinMakerResource) EvaluateSceneRules(ctx context.Context, sceneId string) ([]models.SceneTagState, error) {
+	doc, err := r.loadSceneDocument(ctx, sceneId)
+	if err != nil {
+		return nil, err
+	}
+
+	type component struct {
+		entityId      string
+		componentName string
+	}
+	states := []models.SceneTagState{}
+	// properties referenced by the rules, per bound entity component
+	properties := map[component][]string{}
+	for _, node := range doc.Nodes {
+		for _, c := range node.Components {
+			if c.Type != "Tag" || c.RuleBasedMapId == "" || c.ValueDataBinding == nil {
+				continue
+			}
+			binding := c.ValueDataBinding.DataBindingContext
+			state := models.SceneTagState{
+				NodeName:      node.Name,
+				Ref:           c.Ref,
+				RuleId:        c.RuleBasedMapId,
+				EntityId:      binding.EntityId,
+				ComponentName: binding.ComponentName,
+				PropertyName:  binding.PropertyName,
+			}
+
+			rule, ok := doc.RuleMap[c.RuleBasedMapId]
+			names, err := ruleIdentifiers(rule)
+			switch {
+			case !ok:
+				state.Error = fmt.Sprintf("rule %s not found", c.RuleBasedMapId)
+			case binding.EntityId == "" || binding.ComponentName == "":
+				state.Error = "tag is not bound to an entity component"
+			case err != nil:
+				state.Error = err.Error()
+			default:
+				key := component{binding.EntityId, binding.ComponentName}
+				properties[key] = appendUnique(properties[key], names...)
+			}
+			states = append(states, state)
+		}
+	}
+
+	values := map[component]map[string]interface{}{}
+	failures := map[component]string{}
+	for key, names := range properties {
+		if len(names) == 0 {
+			continue
+		}
+		query := models.TwinMakerQuery{
+			WorkspaceId:   r.workspaceId,
+			EntityId:      key.entityId,
+			ComponentName: key.componentName,
+		}
+		for _, name := range names {
+			query.Properties = append(query.Properties, aws.String(name))
+		}
+		rsp, err := r.client.GetPropertyValue(ctx, query)
+		if err != nil {
+			failures[key] = err.Error()
+			continue
+		}
+		values[key] = map[string]interface{}{}
+		for name, v := range rsp.PropertyValues {
+			if v == nil {
+				continue
+			}
+			if value, ok := ruleValue(v.PropertyValue); ok {
+				values[key][name] = value
+			}
+		}
+	}
+
+	for i, state := range states {
+		if state.Error != "" {
+			continue
+		}
+		key := component{state.EntityId, state.ComponentName}
+		if msg, ok := failures[key]; ok {
+			states[i].Error = msg
+			continue
+		}
+		target, err := evaluateRule(doc.RuleMap[state.RuleId], values[key])
+		if err != nil {
+			states[i].Error = err.Error()
+			continue
+		}
+		states[i].Target = target
+	}
+	return states, nil
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, v := range list {
+			if v == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func toAlarmReference(ref *iottwinmaker.EntityPropertyReference) models.AlarmReference {
+	if ref == nil {
+		return models.AlarmReference{}
+	}
+	return models.AlarmReference{
+		EntityId:      aws.StringValue(ref.EntityId),
+		ComponentName: aws.StringValue(ref.ComponentName),
+	}
+}
+
 func toPropertiesSelectableValues(def map[string]*iottwinmaker.PropertyDefinitionResponse, reg map[string]models.SelectableString) (timeseries []models.SelectableString, props []models.SelectableString) {
 	for key, element := range def {
 		if element.DataType == nil {
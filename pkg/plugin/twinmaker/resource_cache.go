@@ -6,6 +6,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/patrickmn/go-cache"
 )
 
@@ -32,7 +33,7 @@ func (s *cachingResource) GetEntity(ctx context.Context, id string) (*iottwinmak
 	}
 
 	v, err := s.res.GetEntity(ctx, id)
-	if err != nil {
+	if err == nil {
 		s.stash.Set(key, v, 0)
 	}
 	return v, err
@@ -49,7 +50,7 @@ func (s *cachingResource) ListWorkspaces(ctx context.Context) ([]models.Selectab
 	}
 
 	v, err := s.res.ListWorkspaces(ctx)
-	if err != nil {
+	if err == nil {
 		s.stash.Set(key, v, 0)
 	}
 	return v, err
@@ -66,7 +67,7 @@ func (s *cachingResource) ListScenes(ctx context.Context) ([]models.SelectableSt
 	}
 
 	v, err := s.res.ListScenes(ctx)
-	if err != nil {
+	if err == nil {
 		s.stash.Set(key, v, 0)
 	}
 	return v, err
@@ -83,7 +84,7 @@ func (s *cachingResource) ListOptions(ctx context.Context) (models.OptionsInfo,
 	}
 
 	v, err := s.res.ListOptions(ctx)
-	if err != nil {
+	if err == nil {
 		s.stash.Set(key, v, 0)
 	}
 	return v, err
@@ -100,12 +101,86 @@ func (s *cachingResource) ListEntity(ctx context.Context, id string) ([]models.S
 	}
 
 	v, err := s.res.ListEntity(ctx, id)
-	if err != nil {
+	if err == nil {
 		s.stash.Set(key, v, 0)
 	}
 	return v, err
 }
 
+func (s *cachingResource) ListVariableOptions(ctx context.Context, kind models.VariableKind, parents models.VariableParents) ([]models.VariableOption, error) {
+	key := "ListVariableOptions/" + kind + "/" + parents.WorkspaceId + "/" + parents.EntityId + "/" + parents.ComponentName + "/" + parents.ComponentTypeId
+	val, ok := s.stash.Get(key)
+	if ok {
+		v, ok := val.([]models.VariableOption)
+		if ok {
+			return v, nil
+		}
+	}
+
+	v, err := s.res.ListVariableOptions(ctx, kind, parents)
+	if err == nil {
+		s.stash.Set(key, v, 0)
+	}
+	return v, err
+}
+
+func (s *cachingResource) ListEntitiesPage(ctx context.Context, cursor string, maxResults int) (models.ResourcePage, error) {
+	// pages are used for incremental syncs, so they are not cached
+	return s.res.ListEntitiesPage(ctx, cursor, maxResults)
+}
+
+func (s *cachingResource) ListComponentTypesPage(ctx context.Context, cursor string, maxResults int) (models.ResourcePage, error) {
+	return s.res.ListComponentTypesPage(ctx, cursor, maxResults)
+}
+
 func (s *cachingResource) BatchPutPropertyValues(ctx context.Context, entries []*iottwinmaker.PropertyValueEntry) (*iottwinmaker.BatchPutPropertyValuesOutput, error) {
 	return s.res.BatchPutPropertyValues(ctx, entries)
 }
+
+func (s *cachingResource) AcknowledgeAlarms(ctx context.Context, alarms []models.AlarmReference) (models.AlarmAckReport, error) {
+	return s.res.AcknowledgeAlarms(ctx, alarms)
+}
+
+func (s *cachingResource) SnoozeAlarms(ctx context.Context, alarms []models.AlarmReference, duration time.Duration) (models.AlarmSnoozeReport, error) {
+	return s.res.SnoozeAlarms(ctx, alarms, duration)
+}
+
+func (s *cachingResource) WriteAnnotation(ctx context.Context, propertyName string, note models.AnnotationNote) (models.AnnotationNoteResult, error) {
+	return s.res.WriteAnnotation(ctx, propertyName, note)
+}
+
+func (s *cachingResource) GetEntityTags(ctx context.Context, entityIds []string) ([]models.EntityTags, error) {
+	// read right before the tags are edited, so not cached
+	return s.res.GetEntityTags(ctx, entityIds)
+}
+
+func (s *cachingResource) UpdateEntityTags(ctx context.Context, updates []models.EntityTagUpdate) (models.EntityTagReport, error) {
+	return s.res.UpdateEntityTags(ctx, updates)
+}
+
+func (s *cachingResource) EvaluateSceneRules(ctx context.Context, sceneId string) ([]models.SceneTagState, error) {
+	// evaluated against the latest values, so not cached
+	return s.res.EvaluateSceneRules(ctx, sceneId)
+}
+
+func (s *cachingResource) SceneBindings(ctx context.Context, sceneId string) ([]models.SceneDataBinding, error) {
+	// read once per stream
+	return s.res.SceneBindings(ctx, sceneId)
+}
+
+func (s *cachingResource) SceneBindingValues(ctx context.Context, bindings []models.SceneDataBinding) (*data.Frame, error) {
+	// the latest values, so not cached
+	return s.res.SceneBindingValues(ctx, bindings)
+}
+
+func (s *cachingResource) UploadSceneAsset(ctx context.Context, name string, body []byte) (models.SceneAsset, error) {
+	return s.res.UploadSceneAsset(ctx, name, body)
+}
+
+func (s *cachingResource) PlanDemoWorkspace(req models.DemoWorkspaceRequest) (models.DemoWorkspaceReport, error) {
+	return s.res.PlanDemoWorkspace(req)
+}
+
+func (s *cachingResource) CreateDemoWorkspace(ctx context.Context, req models.DemoWorkspaceRequest) (models.DemoWorkspaceReport, error) {
+	return s.res.CreateDemoWorkspace(ctx, req)
+}
@@ -0,0 +1,76 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type batchPutMockClient struct {
+	*twinMakerMockClient
+	batches [][]*iottwinmaker.PropertyValueEntry
+	reject  map[string]bool
+}
+
+func (c *batchPutMockClient) BatchPutPropertyValues(ctx context.Context, req *iottwinmaker.BatchPutPropertyValuesInput) (*iottwinmaker.BatchPutPropertyValuesOutput, error) {
+	c.batches = append(c.batches, req.Entries)
+	rsp := &iottwinmaker.BatchPutPropertyValuesOutput{}
+	for _, entry := range req.Entries {
+		if c.reject[*entry.EntityPropertyReference.EntityId] {
+			rsp.ErrorEntries = append(rsp.ErrorEntries, &iottwinmaker.BatchPutPropertyErrorEntry{
+				Errors: []*iottwinmaker.BatchPutPropertyError{{
+					Entry:        entry,
+					ErrorCode:    aws.String("AccessDenied"),
+					ErrorMessage: aws.String("not allowed"),
+				}},
+			})
+		}
+	}
+	return rsp, nil
+}
+
+func TestAcknowledgeAlarms(t *testing.T) {
+	client := &batchPutMockClient{
+		twinMakerMockClient: &twinMakerMockClient{},
+		reject:              map[string]bool{"Mixer_3": true},
+	}
+	res := NewTwinMakerResource(client, "AlarmWorkspace")
+
+	alarms := []models.AlarmReference{}
+	for i := 0; i < 12; i++ {
+		alarms = append(alarms, models.AlarmReference{
+			EntityId:      fmt.Sprintf("Mixer_%d", i),
+			ComponentName: "AlarmComponent",
+		})
+	}
+	alarms = append(alarms, models.AlarmReference{EntityId: "Mixer_12"})
+
+	report, err := res.AcknowledgeAlarms(context.Background(), alarms)
+	require.NoError(t, err)
+
+	require.Len(t, client.batches, 2)
+	require.Len(t, client.batches[0], maxBatchPutEntries)
+	require.Len(t, client.batches[1], 2)
+	require.Equal(t, "ACKNOWLEDGED", *client.batches[0][0].PropertyValues[0].Value.StringValue)
+
+	require.Len(t, report.Acknowledged, 11)
+	require.Equal(t, []models.AlarmAckFailure{
+		{
+			AlarmReference: models.AlarmReference{EntityId: "Mixer_12"},
+			ErrorMessage:   "missing entityId or componentName",
+		},
+		{
+			AlarmReference: models.AlarmReference{EntityId: "Mixer_3", ComponentName: "AlarmComponent"},
+			ErrorCode:      "AccessDenied",
+			ErrorMessage:   "not allowed",
+		},
+	}, report.Failed)
+
+	_, err = res.AcknowledgeAlarms(context.Background(), nil)
+	require.Error(t, err)
+}
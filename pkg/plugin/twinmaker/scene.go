@@ -0,0 +1,394 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+)
+
+// The subset of the scene composer document needed to evaluate tag rules
+type sceneDocument struct {
+	Nodes   []sceneNode             `json:"nodes"`
+	RuleMap map[string]sceneRuleMap `json:"ruleMap"`
+}
+
+type sceneNode struct {
+	Name       string           `json:"name"`
+	Components []sceneComponent `json:"components"`
+}
+
+type sceneComponent struct {
+	Type             string            `json:"type"`
+	Ref              string            `json:"ref"`
+	RuleBasedMapId   string            `json:"ruleBasedMapId"`
+	ValueDataBinding *sceneDataBinding `json:"valueDataBinding"`
+}
+
+type sceneDataBinding struct {
+	DataBindingContext struct {
+		EntityId      string `json:"entityId"`
+		ComponentName string `json:"componentName"`
+		PropertyName  string `json:"propertyName"`
+	} `json:"dataBindingContext"`
+}
+
+type sceneRuleMap struct {
+	Statements []sceneRuleStatement `json:"statements"`
+}
+
+type sceneRuleStatement struct {
+	Expression string `json:"expression"`
+	Target     string `json:"target"`
+}
+
+func loadSceneDocument(ctx context.Context, client TwinMakerClient, workspaceId string, sceneId string) (*sceneDocument, error) {
+	scene, err := client.GetScene(ctx, workspaceId, sceneId)
+	if err != nil {
+		return nil, err
+	}
+	if scene == nil || scene.ContentLocation == nil {
+		return nil, fmt.Errorf("missing content location for scene %s", sceneId)
+	}
+	content, err := client.GetSceneContent(ctx, *scene.ContentLocation)
+	if err != nil {
+		return nil, err
+	}
+	return parseSceneDocument(content)
+}
+
+func parseSceneDocument(content []byte) (*sceneDocument, error) {
+	doc := &sceneDocument{}
+	if err := json.Unmarshal(content, doc); err != nil {
+		return nil, fmt.Errorf("unable to parse scene content: %w", err)
+	}
+	return doc, nil
+}
+
+// ruleIdentifiers returns the property names referenced by the statements of a rule
+func ruleIdentifiers(rule sceneRuleMap) ([]string, error) {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, statement := range rule.Statements {
+		tokens, err := tokenizeRuleExpression(statement.Expression)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tokens {
+			if t.kind == ruleTokenIdent && !seen[t.text] {
+				seen[t.text] = true
+				names = append(names, t.text)
+			}
+		}
+	}
+	return names, nil
+}
+
+// evaluateRule returns the target of the first statement that evaluates to true
+func evaluateRule(rule sceneRuleMap, values map[string]interface{}) (string, error) {
+	for _, statement := range rule.Statements {
+		ok, err := evaluateRuleExpression(statement.Expression, values)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return statement.Target, nil
+		}
+	}
+	return "", nil
+}
+
+// ruleValue converts a property value to the types used by rule expressions
+func ruleValue(v *iottwinmaker.DataValue) (interface{}, bool) {
+	switch {
+	case v == nil:
+		return nil, false
+	case v.BooleanValue != nil:
+		return *v.BooleanValue, true
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, true
+	case v.LongValue != nil:
+		return float64(*v.LongValue), true
+	case v.IntegerValue != nil:
+		return float64(*v.IntegerValue), true
+	case v.StringValue != nil:
+		return *v.StringValue, true
+	}
+	return nil, false
+}
+
+type ruleTokenKind int
+
+const (
+	ruleTokenIdent ruleTokenKind = iota
+	ruleTokenNumber
+	ruleTokenString
+	ruleTokenOp
+	ruleTokenParen
+)
+
+type ruleToken struct {
+	kind ruleTokenKind
+	text string
+}
+
+func tokenizeRuleExpression(expr string) ([]ruleToken, error) {
+	tokens := []ruleToken{}
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, ruleToken{ruleTokenParen, string(r)})
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string in rule expression: %s", expr)
+			}
+			tokens = append(tokens, ruleToken{ruleTokenString, string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, ruleToken{ruleTokenNumber, string(runes[i:end])})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, ruleToken{ruleTokenIdent, string(runes[i:end])})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q in rule expression: %s", r, expr)
+			}
+			tokens = append(tokens, ruleToken{ruleTokenOp, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// ruleParser evaluates the rule expressions used by the scene composer, e.g.
+// "alarm_status == 'ACTIVE' || temperature > 100". true/false are literals, other identifiers are property names.
+type ruleParser struct {
+	expr   string
+	tokens []ruleToken
+	pos    int
+	values map[string]interface{}
+}
+
+func evaluateRuleExpression(expr string, values map[string]interface{}) (bool, error) {
+	tokens, err := tokenizeRuleExpression(expr)
+	if err != nil {
+		return false, err
+	}
+	p := &ruleParser{expr: expr, tokens: tokens, values: values}
+	v, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected %q in rule expression: %s", p.tokens[p.pos].text, expr)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("rule expression is not a condition: %s", expr)
+	}
+	return b, nil
+}
+
+func (p *ruleParser) accept(kind ruleTokenKind, text string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind && p.tokens[p.pos].text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) parseOr() (interface{}, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(ruleTokenOp, "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, lok := left.(bool)
+		r, rok := right.(bool)
+		if !lok || !rok {
+			return nil, fmt.Errorf("|| requires conditions in rule expression: %s", p.expr)
+		}
+		left = l || r
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (interface{}, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(ruleTokenOp, "&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		l, lok := left.(bool)
+		r, rok := right.(bool)
+		if !lok || !rok {
+			return nil, fmt.Errorf("&& requires conditions in rule expression: %s", p.expr)
+		}
+		left = l && r
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseComparison() (interface{}, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != ruleTokenOp {
+		return left, nil
+	}
+	op := p.tokens[p.pos].text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+	default:
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compareRuleValues(op, left, right)
+}
+
+func (p *ruleParser) parseOperand() (interface{}, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("incomplete rule expression: %s", p.expr)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case ruleTokenNumber:
+		return strconv.ParseFloat(t.text, 64)
+	case ruleTokenString:
+		return t.text, nil
+	case ruleTokenIdent:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		v, ok := p.values[t.text]
+		if !ok {
+			return nil, fmt.Errorf("no value for %s", t.text)
+		}
+		return v, nil
+	case ruleTokenOp:
+		if t.text == "!" {
+			v, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("! requires a condition in rule expression: %s", p.expr)
+			}
+			return !b, nil
+		}
+	case ruleTokenParen:
+		if t.text == "(" {
+			v, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(ruleTokenParen, ")") {
+				return nil, fmt.Errorf("missing ) in rule expression: %s", p.expr)
+			}
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q in rule expression: %s", t.text, p.expr)
+}
+
+func compareRuleValues(op string, left interface{}, right interface{}) (bool, error) {
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		switch op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			break
+		}
+		switch op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+	}
+	return false, fmt.Errorf("cannot compare %v %s %v", left, op, right)
+}
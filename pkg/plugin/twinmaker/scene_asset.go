@@ -0,0 +1,90 @@
+package twinmaker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// MaxSceneAssetSize keeps uploads below the 16MB gRPC message limit of resource calls
+const MaxSceneAssetSize = 15 * 1024 * 1024
+
+var sceneAssetTypes = map[string]string{
+	".glb":  "model/gltf-binary",
+	".gltf": "model/gltf+json",
+}
+
+var sceneAssetName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// sceneAssetContentType checks the name and content of a model, and returns its content type
+func sceneAssetContentType(name string, body []byte) (string, error) {
+	if !sceneAssetName.MatchString(name) || len(name) > 255 {
+		return "", fmt.Errorf("invalid asset name %q", name)
+	}
+	ext := strings.ToLower(path.Ext(name))
+	contentType, ok := sceneAssetTypes[ext]
+	if !ok {
+		return "", fmt.Errorf("unsupported asset type %q, expected .glb or .gltf", ext)
+	}
+	if len(body) == 0 {
+		return "", fmt.Errorf("empty asset")
+	}
+	if len(body) > MaxSceneAssetSize {
+		return "", fmt.Errorf("asset is larger than %d bytes", MaxSceneAssetSize)
+	}
+	switch ext {
+	case ".glb":
+		if !bytes.HasPrefix(body, []byte("glTF")) {
+			return "", fmt.Errorf("%s is not a binary glTF file", name)
+		}
+	case ".gltf":
+		if !json.Valid(body) {
+			return "", fmt.Errorf("%s is not a glTF JSON file", name)
+		}
+	}
+	return contentType, nil
+}
+
+// sceneAssetBucket is the bucket name of the workspace S3 location, an ARN or s3:// url
+func sceneAssetBucket(location string) string {
+	bucket := location
+	if a, err := arn.Parse(location); err == nil {
+		bucket = a.Resource
+	}
+	bucket = strings.TrimPrefix(bucket, "s3://")
+	bucket, _, _ = strings.Cut(bucket, "/")
+	return bucket
+}
+
+// UploadSceneAsset writes a glb/gltf model next to the scenes in the workspace bucket
+func (r *twinMakerResource) UploadSceneAsset(ctx context.Context, name string, body []byte) (models.SceneAsset, error) {
+	asset := models.SceneAsset{Size: len(body)}
+	contentType, err := sceneAssetContentType(name, body)
+	if err != nil {
+		return asset, err
+	}
+	asset.ContentType = contentType
+
+	workspace, err := r.client.GetWorkspace(ctx, models.TwinMakerQuery{WorkspaceId: r.workspaceId})
+	if err != nil {
+		return asset, err
+	}
+	bucket := ""
+	if workspace != nil && workspace.S3Location != nil {
+		bucket = sceneAssetBucket(*workspace.S3Location)
+	}
+	if bucket == "" {
+		return asset, fmt.Errorf("missing S3 location for workspace %s", r.workspaceId)
+	}
+
+	asset.Location = "s3://" + bucket + "/" + name
+	err = r.client.PutSceneAsset(ctx, asset.Location, contentType, body)
+	return asset, err
+}
@@ -0,0 +1,77 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type sceneAssetMockClient struct {
+	*twinMakerMockClient
+	location    string
+	contentType string
+	body        []byte
+}
+
+func (c *sceneAssetMockClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
+	return &iottwinmaker.GetWorkspaceOutput{
+		WorkspaceId: aws.String(query.WorkspaceId),
+		S3Location:  aws.String("arn:aws:s3:::twinmaker-workspace-bucket"),
+	}, nil
+}
+
+func (c *sceneAssetMockClient) PutSceneAsset(ctx context.Context, location string, contentType string, body []byte) error {
+	c.location = location
+	c.contentType = contentType
+	c.body = body
+	return nil
+}
+
+func TestSceneAssetContentType(t *testing.T) {
+	contentType, err := sceneAssetContentType("Mixer.GLB", []byte("glTF\x02\x00\x00\x00"))
+	require.NoError(t, err)
+	require.Equal(t, "model/gltf-binary", contentType)
+
+	contentType, err = sceneAssetContentType("mixer.gltf", []byte(`{"asset": {"version": "2.0"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "model/gltf+json", contentType)
+
+	_, err = sceneAssetContentType("../mixer.glb", []byte("glTF"))
+	require.Error(t, err)
+	_, err = sceneAssetContentType("mixer.obj", []byte("o"))
+	require.Error(t, err)
+	_, err = sceneAssetContentType("mixer.glb", []byte(`{"asset": {}}`))
+	require.Error(t, err)
+	_, err = sceneAssetContentType("mixer.gltf", []byte("glTF"))
+	require.Error(t, err)
+	_, err = sceneAssetContentType("mixer.glb", nil)
+	require.Error(t, err)
+	_, err = sceneAssetContentType("mixer.glb", make([]byte, MaxSceneAssetSize+1))
+	require.Error(t, err)
+}
+
+func TestSceneAssetBucket(t *testing.T) {
+	require.Equal(t, "bucket", sceneAssetBucket("arn:aws:s3:::bucket"))
+	require.Equal(t, "bucket", sceneAssetBucket("arn:aws-cn:s3:::bucket"))
+	require.Equal(t, "bucket", sceneAssetBucket("s3://bucket/scenes"))
+}
+
+func TestUploadSceneAsset(t *testing.T) {
+	client := &sceneAssetMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	res := NewTwinMakerResource(client, "CookieFactory")
+
+	asset, err := res.UploadSceneAsset(context.Background(), "mixer.glb", []byte("glTF"))
+	require.NoError(t, err)
+	require.Equal(t, models.SceneAsset{
+		Location:    "s3://twinmaker-workspace-bucket/mixer.glb",
+		ContentType: "model/gltf-binary",
+		Size:        4,
+	}, asset)
+	require.Equal(t, asset.Location, client.location)
+	require.Equal(t, "model/gltf-binary", client.contentType)
+	require.Equal(t, []byte("glTF"), client.body)
+}
@@ -0,0 +1,129 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// sceneDataBindings returns the distinct properties bound to the components of the scene nodes,
+// sorted by entity, component and property
+func sceneDataBindings(doc *sceneDocument) []models.SceneDataBinding {
+	seen := map[models.SceneDataBinding]bool{}
+	bindings := []models.SceneDataBinding{}
+	for _, node := range doc.Nodes {
+		for _, c := range node.Components {
+			if c.ValueDataBinding == nil {
+				continue
+			}
+			ctx := c.ValueDataBinding.DataBindingContext
+			b := models.SceneDataBinding{EntityId: ctx.EntityId, ComponentName: ctx.ComponentName, PropertyName: ctx.PropertyName}
+			if b.EntityId == "" || b.ComponentName == "" || b.PropertyName == "" || seen[b] {
+				continue
+			}
+			seen[b] = true
+			bindings = append(bindings, b)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		a, b := bindings[i], bindings[j]
+		if a.EntityId != b.EntityId {
+			return a.EntityId < b.EntityId
+		}
+		if a.ComponentName != b.ComponentName {
+			return a.ComponentName < b.ComponentName
+		}
+		return a.PropertyName < b.PropertyName
+	})
+	return bindings
+}
+
+func (r *twinMakerResource) SceneBindings(ctx context.Context, sceneId string) ([]models.SceneDataBinding, error) {
+	doc, err := r.loadSceneDocument(ctx, sceneId)
+	if err != nil {
+		return nil, err
+	}
+	bindings := sceneDataBindings(doc)
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("scene %s has no data bindings", sceneId)
+	}
+	return bindings, nil
+}
+
+// SceneBindingValues reads the latest values with one GetPropertyValue call per entity component.
+// The frame has a single row, with one field per binding labeled with the bound property.
+// Bindings without a value are skipped with a notice, values with unsafe URIs are removed.
+func (r *twinMakerResource) SceneBindingValues(ctx context.Context, bindings []models.SceneDataBinding) (*data.Frame, error) {
+	type component struct {
+		entityId      string
+		componentName string
+	}
+	properties := map[component][]string{}
+	components := []component{}
+	for _, b := range bindings {
+		key := component{b.EntityId, b.ComponentName}
+		if _, ok := properties[key]; !ok {
+			components = append(components, key)
+		}
+		properties[key] = appendUnique(properties[key], b.PropertyName)
+	}
+
+	now := time.Now()
+	frame := data.NewFrame("", data.NewField(data.TimeSeriesTimeFieldName, nil, []time.Time{now}))
+	for _, key := range components {
+		query := models.TwinMakerQuery{
+			WorkspaceId:   r.workspaceId,
+			EntityId:      key.entityId,
+			ComponentName: key.componentName,
+		}
+		for _, name := range properties[key] {
+			query.Properties = append(query.Properties, aws.String(name))
+		}
+		rsp, err := r.client.GetPropertyValue(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("%s/%s: %s", key.entityId, key.componentName, err.Error()),
+			})
+			continue
+		}
+
+		for _, name := range properties[key] {
+			prop, ok := rsp.PropertyValues[name]
+			if !ok || prop == nil || prop.PropertyValue == nil {
+				frame.AppendNotices(data.Notice{
+					Severity: data.NoticeSeverityInfo,
+					Text:     fmt.Sprintf("%s/%s: no value for %s", key.entityId, key.componentName, name),
+				})
+				continue
+			}
+			value, ok := r.redaction.value(name, prop.PropertyValue)
+			if !ok {
+				continue
+			}
+			f, appender, err := newDataValueField(value, 1)
+			if err != nil {
+				frame.AppendNotices(dataValueNotice(name, err))
+				continue
+			}
+			_ = appender.Set(0, value)
+			f.Name = name
+			f.Labels = data.Labels{
+				"entityId":      key.entityId,
+				"componentName": key.componentName,
+				"propertyName":  name,
+			}
+			frame.Fields = append(frame.Fields, f)
+		}
+	}
+	stripFrameURIs(frame)
+	return frame, nil
+}
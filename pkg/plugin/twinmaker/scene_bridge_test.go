@@ -0,0 +1,94 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSceneBindingValues(t *testing.T) {
+	client := &sceneMockClient{
+		propertyValueMockClient: &propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}},
+		content: `{
+			"nodes": [
+				{"name": "Mixer_1", "components": [
+					{"type": "Tag", "ref": "tag-1", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_1", "componentName": "MixerComponent", "propertyName": "RPM"
+					}}},
+					{"type": "ModelShader", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_1", "componentName": "MixerComponent", "propertyName": "Temperature"
+					}}}
+				]},
+				{"name": "Mixer_0", "components": [
+					{"type": "Tag", "ref": "tag-0", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_0", "componentName": "MixerComponent", "propertyName": "RPM"
+					}}},
+					{"type": "Tag", "ref": "tag-2", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_0", "componentName": "MixerComponent", "propertyName": "RPM"
+					}}},
+					{"type": "Tag", "ref": "tag-3", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Missing", "componentName": "MixerComponent", "propertyName": "RPM"
+					}}},
+					{"type": "ModelRef", "ref": "model-0"}
+				]}
+			]
+		}`,
+	}
+	res := newTwinMakerResource(client, "AlarmWorkspace", newRedactor([]models.RedactionRule{{Pattern: "Temp*", Action: models.RedactionDrop}}))
+
+	// duplicate bindings are read once
+	bindings, err := res.SceneBindings(context.Background(), "CookieFactory")
+	require.NoError(t, err)
+	require.Equal(t, []models.SceneDataBinding{
+		{EntityId: "Missing", ComponentName: "MixerComponent", PropertyName: "RPM"},
+		{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "RPM"},
+		{EntityId: "Mixer_1", ComponentName: "MixerComponent", PropertyName: "RPM"},
+		{EntityId: "Mixer_1", ComponentName: "MixerComponent", PropertyName: "Temperature"},
+	}, bindings)
+
+	// one call per entity component, failures and redacted properties are not in the frame
+	frame, err := res.SceneBindingValues(context.Background(), bindings)
+	require.NoError(t, err)
+	require.Equal(t, 3, client.calls)
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, 1, frame.Rows())
+	require.Equal(t, "Mixer_0", frame.Fields[1].Labels["entityId"])
+	require.Equal(t, "Mixer_1", frame.Fields[2].Labels["entityId"])
+	require.Equal(t, float64(3), *frame.Fields[2].At(0).(*float64))
+	require.Len(t, frame.Meta.Notices, 1)
+	require.Equal(t, "Missing/MixerComponent: entity not found", frame.Meta.Notices[0].Text)
+
+	client.content = `{"nodes": [{"name": "Mixer_0", "components": [{"type": "ModelRef"}]}]}`
+	_, err = res.SceneBindings(context.Background(), "Empty")
+	require.EqualError(t, err, "scene Empty has no data bindings")
+}
+
+// linkValueMockClient reads a script URI for every property
+type linkValueMockClient struct {
+	*twinMakerMockClient
+}
+
+func (c *linkValueMockClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	rsp := &iottwinmaker.GetPropertyValueOutput{PropertyValues: map[string]*iottwinmaker.PropertyLatestValue{}}
+	for _, name := range query.Properties {
+		rsp.PropertyValues[*name] = &iottwinmaker.PropertyLatestValue{
+			PropertyValue: &iottwinmaker.DataValue{StringValue: aws.String("javascript:alert(document.cookie)")},
+		}
+	}
+	return rsp, nil
+}
+
+func TestSceneBindingValuesUnsafeURIs(t *testing.T) {
+	res := newTwinMakerResource(&linkValueMockClient{twinMakerMockClient: &twinMakerMockClient{}}, "AlarmWorkspace", nil)
+	frame, err := res.SceneBindingValues(context.Background(), []models.SceneDataBinding{
+		{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "Manual"},
+	})
+	require.NoError(t, err)
+	require.Len(t, frame.Fields, 2)
+	require.Nil(t, frame.Fields[1].At(0))
+	require.Equal(t, "Removed unsafe URI values: 1 of Manual", frame.Meta.Notices[0].Text)
+}
@@ -0,0 +1,63 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// GetSceneTags lists the data bound tags of a scene for template variables. The text field is the
+// tag name and the value field the bound entity, followed by the bound component and property.
+func (s *twinMakerHandler) GetSceneTags(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.SceneId == "" {
+		dr.Error = fmt.Errorf("missing scene parameter")
+		return
+	}
+	doc, err := loadSceneDocument(ctx, s.client, query.WorkspaceId, query.SceneId)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	type sceneTag struct {
+		name    string
+		binding models.SceneDataBinding
+	}
+	tags := []sceneTag{}
+	for _, node := range doc.Nodes {
+		for _, c := range node.Components {
+			if c.Type != "Tag" || c.ValueDataBinding == nil {
+				continue
+			}
+			binding := c.ValueDataBinding.DataBindingContext
+			if binding.EntityId == "" || binding.PropertyName == "" {
+				continue
+			}
+			tags = append(tags, sceneTag{
+				name:    node.Name,
+				binding: models.SceneDataBinding{EntityId: binding.EntityId, ComponentName: binding.ComponentName, PropertyName: binding.PropertyName},
+			})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].name < tags[j].name
+	})
+
+	fields := newTwinMakerFrameBuilder(len(tags))
+	text := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(tags)), "text")
+	value := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(tags)), "value")
+	componentName := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(tags)), "componentName")
+	propertyName := fields.add(data.NewFieldFromFieldType(data.FieldTypeString, len(tags)), "propertyName")
+	for i, tag := range tags {
+		text.Set(i, tag.name)
+		value.Set(i, tag.binding.EntityId)
+		componentName.Set(i, tag.binding.ComponentName)
+		propertyName.Set(i, tag.binding.PropertyName)
+	}
+	dr.Frames = append(dr.Frames, fields.ToFrame(query.SceneId, nil))
+	return
+}
@@ -0,0 +1,58 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSceneTags(t *testing.T) {
+	client := &sceneMockClient{
+		propertyValueMockClient: &propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}},
+		content: `{
+			"nodes": [
+				{"name": "Mixer_1", "components": [
+					{"type": "Tag", "ref": "tag-1", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_1", "componentName": "MixerComponent", "propertyName": "RPM"
+					}}}
+				]},
+				{"name": "Mixer_0", "components": [
+					{"type": "Tag", "ref": "tag-0", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_0", "componentName": "MixerComponent", "propertyName": "Temperature"
+					}}},
+					{"type": "ModelRef", "ref": "model-0"}
+				]},
+				{"name": "Unbound", "components": [
+					{"type": "Tag", "ref": "tag-2", "valueDataBinding": {"dataBindingContext": {"entityId": "Mixer_1"}}}
+				]}
+			]
+		}`,
+	}
+	handler := NewTwinMakerHandler(client)
+
+	t.Run("missing scene", func(t *testing.T) {
+		dr := handler.GetSceneTags(context.Background(), models.TwinMakerQuery{WorkspaceId: "AlarmWorkspace"})
+		require.Error(t, dr.Error)
+	})
+
+	t.Run("bound tags sorted by name", func(t *testing.T) {
+		dr := handler.GetSceneTags(context.Background(), models.TwinMakerQuery{WorkspaceId: "AlarmWorkspace", SceneId: "CookieFactory"})
+		require.NoError(t, dr.Error)
+		require.Len(t, dr.Frames, 1)
+		frame := dr.Frames[0]
+		require.Equal(t, 2, frame.Rows())
+
+		names := []string{}
+		for _, f := range frame.Fields {
+			names = append(names, f.Name)
+		}
+		require.Equal(t, []string{"text", "value", "componentName", "propertyName"}, names)
+		require.Equal(t, "Mixer_0", frame.Fields[0].At(0))
+		require.Equal(t, "Mixer_0", frame.Fields[1].At(0))
+		require.Equal(t, "Temperature", frame.Fields[3].At(0))
+		require.Equal(t, "Mixer_1", frame.Fields[0].At(1))
+		require.Equal(t, "RPM", frame.Fields[3].At(1))
+	})
+}
@@ -0,0 +1,119 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateRuleExpression(t *testing.T) {
+	values := map[string]interface{}{
+		"alarm_status": "ACTIVE",
+		"temperature":  101.5,
+		"running":      true,
+	}
+
+	for expr, expected := range map[string]bool{
+		"alarm_status == 'ACTIVE'":                       true,
+		`alarm_status != "ACTIVE"`:                       false,
+		"temperature > 100":                              true,
+		"temperature <= -1":                              false,
+		"running":                                        true,
+		"!running || temperature >= 101.5":               true,
+		"(alarm_status == 'NORMAL' || running) && false": false,
+		"running == true && temperature < 200":           true,
+	} {
+		actual, err := evaluateRuleExpression(expr, values)
+		require.NoError(t, err, expr)
+		require.Equal(t, expected, actual, expr)
+	}
+
+	for _, expr := range []string{
+		"pressure > 1",
+		"temperature == 'hot'",
+		"alarm_status == 'ACTIVE",
+		"temperature >",
+		"temperature",
+		"(running",
+	} {
+		_, err := evaluateRuleExpression(expr, values)
+		require.Error(t, err, expr)
+	}
+}
+
+type sceneMockClient struct {
+	*propertyValueMockClient
+	content string
+}
+
+func (c *sceneMockClient) GetScene(ctx context.Context, workspaceId string, sceneId string) (*iottwinmaker.GetSceneOutput, error) {
+	return &iottwinmaker.GetSceneOutput{
+		SceneId:         aws.String(sceneId),
+		ContentLocation: aws.String("s3://bucket/" + sceneId + ".json"),
+	}, nil
+}
+
+func (c *sceneMockClient) GetSceneContent(ctx context.Context, contentLocation string) ([]byte, error) {
+	return []byte(c.content), nil
+}
+
+func TestEvaluateSceneRules(t *testing.T) {
+	client := &sceneMockClient{
+		propertyValueMockClient: &propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}},
+		content: `{
+			"nodes": [
+				{"name": "Mixer_0", "components": [
+					{"type": "Tag", "ref": "tag-0", "ruleBasedMapId": "temperatureRule", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_0", "componentName": "MixerComponent", "propertyName": "Temperature"
+					}}},
+					{"type": "ModelRef", "ref": "model-0"}
+				]},
+				{"name": "Mixer_1", "components": [
+					{"type": "Tag", "ref": "tag-1", "ruleBasedMapId": "rpmRule", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_1", "componentName": "MixerComponent", "propertyName": "RPM"
+					}}}
+				]},
+				{"name": "Missing", "components": [
+					{"type": "Tag", "ref": "tag-2", "ruleBasedMapId": "unknownRule", "valueDataBinding": {"dataBindingContext": {
+						"entityId": "Mixer_1", "componentName": "MixerComponent"
+					}}}
+				]}
+			],
+			"ruleMap": {
+				"temperatureRule": {"statements": [
+					{"expression": "Temperature > 20", "target": "iottwinmaker.common.icon:Error"},
+					{"expression": "Temperature > 10", "target": "iottwinmaker.common.icon:Warning"}
+				]},
+				"rpmRule": {"statements": [
+					{"expression": "RPM > 3", "target": "iottwinmaker.common.icon:Error"}
+				]}
+			}
+		}`,
+	}
+	res := NewTwinMakerResource(client, "AlarmWorkspace")
+
+	// the mock client returns the length of the property name as its value
+	states, err := res.EvaluateSceneRules(context.Background(), "CookieFactory")
+	require.NoError(t, err)
+	require.Equal(t, []models.SceneTagState{
+		{
+			NodeName: "Mixer_0", Ref: "tag-0", RuleId: "temperatureRule",
+			EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "Temperature",
+			Target: "iottwinmaker.common.icon:Warning",
+		},
+		{
+			NodeName: "Mixer_1", Ref: "tag-1", RuleId: "rpmRule",
+			EntityId: "Mixer_1", ComponentName: "MixerComponent", PropertyName: "RPM",
+		},
+		{
+			NodeName: "Missing", Ref: "tag-2", RuleId: "unknownRule",
+			EntityId: "Mixer_1", ComponentName: "MixerComponent",
+			Error: "rule unknownRule not found",
+		},
+	}, states)
+	require.Equal(t, 2, client.calls)
+}
@@ -0,0 +1,125 @@
+package twinmaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// severityField is the field of the severity expression, after the fields it is computed from
+const severityField = "severity"
+
+// severityNow is the identifier of the query time, in seconds since the epoch like time fields
+const severityNow = "now"
+
+// addSeverity evaluates the severity expression for every row of the frames and adds the results
+// as a severity field. Identifiers are field names or the propertyName label of value fields, the
+// first field of a property when several have it. Frames without a field of the expression, or
+// with a severity field already, are left as they are with a notice.
+func addSeverity(res *backend.DataResponse, severity expr, now time.Time) {
+	idents := exprIdents(severity)
+	for _, frame := range res.Frames {
+		if existing, _ := frame.FieldByName(severityField); existing != nil {
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("severity not computed, the frame already has a %s field", severityField),
+			})
+			continue
+		}
+
+		fields := map[string]*data.Field{}
+		properties := map[string]int{}
+		for _, field := range frame.Fields {
+			if name := field.Labels["propertyName"]; name != "" {
+				if _, ok := fields[name]; !ok {
+					fields[name] = field
+				}
+				properties[name]++
+			}
+		}
+		// names take precedence over property names
+		names := map[string]bool{}
+		for _, field := range frame.Fields {
+			fields[field.Name] = field
+			names[field.Name] = true
+		}
+
+		var missing, ambiguous []string
+		seen := map[string]bool{}
+		for _, name := range idents {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if _, ok := fields[name]; !ok && name != severityNow {
+				missing = append(missing, name)
+			} else if !names[name] && properties[name] > 1 {
+				ambiguous = append(ambiguous, fmt.Sprintf("%s of %d", name, properties[name]))
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("severity not computed, the frame has no field %s", strings.Join(missing, ", ")),
+			})
+			continue
+		}
+		if len(ambiguous) > 0 {
+			sort.Strings(ambiguous)
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityInfo,
+				Text:     fmt.Sprintf("severity uses the first field of properties with several fields: %s", strings.Join(ambiguous, ", ")),
+			})
+		}
+
+		rows := frame.Rows()
+		result := data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, rows)
+		result.Name = severityField
+		for i := 0; i < rows; i++ {
+			env := func(name string) (exprValue, bool) {
+				field, ok := fields[name]
+				if !ok {
+					if name == severityNow {
+						return numberValue(float64(now.UnixMilli()) / 1000), true
+					}
+					return nullValue, false
+				}
+				return fieldValue(field, i), true
+			}
+			if v := severity.eval(env); !v.null && !v.isStr {
+				n := v.num
+				result.Set(i, &n)
+			}
+		}
+		frame.Fields = append(frame.Fields, result)
+	}
+}
+
+// fieldValue is the expression value of a row of a field, times are seconds since the epoch and
+// booleans 1 or 0
+func fieldValue(field *data.Field, i int) exprValue {
+	if i >= field.Len() {
+		return nullValue
+	}
+	v, ok := field.ConcreteAt(i)
+	if !ok {
+		return nullValue
+	}
+	switch v := v.(type) {
+	case string:
+		return exprValue{str: v, isStr: true}
+	case bool:
+		return boolValue(v)
+	case time.Time:
+		return numberValue(float64(v.UnixMilli()) / 1000)
+	}
+	if f, err := field.NullableFloatAt(i); err == nil && f != nil {
+		return numberValue(*f)
+	}
+	return nullValue
+}
@@ -0,0 +1,71 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddSeverity(t *testing.T) {
+	now := time.Date(2022, 4, 27, 12, 0, 0, 0, time.UTC)
+	active, normal := "ACTIVE", "NORMAL"
+	first, second := now.Add(-2*time.Hour), now.Add(-30*time.Minute)
+	since := []*time.Time{&first, &second, nil}
+	alarms := data.NewFrame("",
+		data.NewField("alarmName", nil, []string{"TemperatureAlarm", "RPMAlarm", "FlowAlarm"}),
+		data.NewField("alarmStatus", nil, []*string{&active, &active, &normal}),
+		data.NewField("Time", nil, since),
+	)
+	magnitude := data.NewField("Magnitude", data.Labels{"propertyName": "breachMagnitude"}, []*float64{aws.Float64(2.5)})
+	values := data.NewFrame("", magnitude)
+
+	e, err := parseExpression("(alarmStatus == 'ACTIVE') * 10 + (now - Time) / 3600")
+	require.NoError(t, err)
+	res := backend.DataResponse{Frames: data.Frames{alarms, values}}
+	addSeverity(&res, e, now)
+
+	severity, _ := alarms.FieldByName(severityField)
+	require.Equal(t, 12.0, *severity.At(0).(*float64))
+	require.Equal(t, 10.5, *severity.At(1).(*float64))
+	// no time, no severity
+	require.Nil(t, severity.At(2))
+	require.Equal(t, "severity not computed, the frame has no field Time, alarmStatus", values.Meta.Notices[0].Text)
+	require.Len(t, values.Fields, 1)
+
+	// value fields are found by their property name too
+	e, err = parseExpression("breachMagnitude * 4")
+	require.NoError(t, err)
+	res = backend.DataResponse{Frames: data.Frames{values}}
+	addSeverity(&res, e, now)
+	require.Equal(t, 10.0, *values.Fields[1].At(0).(*float64))
+
+	// a severity field is not replaced
+	addSeverity(&res, e, now)
+	require.Len(t, values.Fields, 2)
+	require.Equal(t, "severity not computed, the frame already has a severity field", values.Meta.Notices[1].Text)
+
+	// the first of several fields of a property is used
+	entities := data.NewFrame("",
+		data.NewField("Mixer_0", data.Labels{"propertyName": "breachMagnitude"}, []*float64{aws.Float64(1)}),
+		data.NewField("Mixer_1", data.Labels{"propertyName": "breachMagnitude"}, []*float64{aws.Float64(2)}),
+	)
+	res = backend.DataResponse{Frames: data.Frames{entities}}
+	addSeverity(&res, e, now)
+	require.Equal(t, 4.0, *entities.Fields[2].At(0).(*float64))
+	require.Equal(t, "severity uses the first field of properties with several fields: breachMagnitude of 2", entities.Meta.Notices[0].Text)
+}
+
+func TestSeverityExpressionQuery(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, &twinMakerMockClient{})
+	dr := ds.Query(context.Background(), models.TwinMakerQuery{
+		QueryType:          models.QueryTypeGetAlarms,
+		SeverityExpression: "magnitude *",
+	})
+	require.EqualError(t, dr.Error, "invalid severity expression: unexpected end of expression")
+}
@@ -0,0 +1,101 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type dataPlaneLimiterKey struct{}
+
+// withDataPlaneLimiter makes the data plane calls of the context wait for the limiter of a shard
+// instead of the one of the client
+func withDataPlaneLimiter(ctx context.Context, l *adaptiveLimiter) context.Context {
+	return context.WithValue(ctx, dataPlaneLimiterKey{}, l)
+}
+
+func dataPlaneLimiterFrom(ctx context.Context, fallback *adaptiveLimiter) *adaptiveLimiter {
+	if l, ok := ctx.Value(dataPlaneLimiterKey{}).(*adaptiveLimiter); ok {
+		return l
+	}
+	return fallback
+}
+
+func newShardLimiters(shards int) []*adaptiveLimiter {
+	if shards < 2 {
+		return nil
+	}
+	limiters := make([]*adaptiveLimiter, shards)
+	for i := range limiters {
+		limiters[i] = newAdaptiveLimiter()
+	}
+	return limiters
+}
+
+// entityShards splits the entities into consecutive shards for the limiters, with at least
+// maxEntityQueries entities each so every worker of a shard has one. Nil when the query is too
+// small to shard.
+func entityShards(ids []string, limiters int) [][]string {
+	n := len(ids) / maxEntityQueries
+	if n > limiters {
+		n = limiters
+	}
+	if n < 2 {
+		return nil
+	}
+	shards := make([][]string, 0, n)
+	size := (len(ids) + n - 1) / n
+	for len(ids) > size {
+		shards = append(shards, ids[:size])
+		ids = ids[size:]
+	}
+	return append(shards, ids)
+}
+
+// shardEntities runs a multi-entity query in the shards of entityShards, within this plugin
+// instance. Each shard runs its entities with the workers of forEachEntity and its own data plane
+// limiter, so the in-flight calls adapt per shard. The throttling backoff is shared by the client
+// and the request rate by the datasource. The frames keep the order of the entities, a shard that fails becomes a
+// warning and the query only fails when all of them do.
+func (ds *Datasource) shardEntities(ctx context.Context, query models.TwinMakerQuery, shards [][]string, run func(ctx context.Context, query models.TwinMakerQuery) backend.DataResponse) (dr backend.DataResponse) {
+	responses := make([]backend.DataResponse, len(shards))
+	var wg sync.WaitGroup
+	for i, ids := range shards {
+		wg.Add(1)
+		go func(i int, ids []string) {
+			defer wg.Done()
+			q := query
+			q.EntityIds = ids
+			responses[i] = run(withDataPlaneLimiter(ctx, ds.shardLimiters[i]), q)
+		}(i, ids)
+	}
+	wg.Wait()
+
+	failures := []data.Notice{}
+	for i, rsp := range responses {
+		if rsp.Error != nil {
+			failures = append(failures, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("%d entities from %s: %s", len(shards[i]), shards[i][0], rsp.Error.Error()),
+			})
+			continue
+		}
+		dr.Frames = append(dr.Frames, rsp.Frames...)
+	}
+	if len(failures) == len(responses) {
+		dr.Error = responses[0].Error
+		dr.Frames = nil
+		return
+	}
+	if len(failures) > 0 {
+		if len(dr.Frames) == 0 {
+			dr.Frames = data.Frames{data.NewFrame("")}
+		}
+		dr.Frames[0].AppendNotices(failures...)
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntityShards(t *testing.T) {
+	ids := func(n int) []string {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("Mixer_%d", i)
+		}
+		return ids
+	}
+	require.Nil(t, entityShards(ids(100), 0))
+	// too few entities for two shards
+	require.Nil(t, entityShards(ids(maxEntityQueries+3), 4))
+
+	shards := entityShards(ids(10), 4)
+	require.Len(t, shards, 2)
+	require.Equal(t, ids(10)[:5], shards[0])
+
+	shards = entityShards(ids(50), 4)
+	require.Len(t, shards, 4)
+	require.Len(t, shards[0], 13)
+	require.Len(t, shards[3], 11)
+	require.Equal(t, "Mixer_49", shards[3][10])
+}
+
+// shardMockClient records the data plane limiter each entity was loaded with
+type shardMockClient struct {
+	*entitiesMockClient
+	mu       sync.Mutex
+	limiters map[string]*adaptiveLimiter
+}
+
+func (c *shardMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	c.mu.Lock()
+	c.limiters[query.EntityId] = dataPlaneLimiterFrom(ctx, nil)
+	c.mu.Unlock()
+	return c.entitiesMockClient.GetPropertyValueHistory(ctx, query)
+}
+
+func TestShardedEntityHistory(t *testing.T) {
+	client := &shardMockClient{
+		entitiesMockClient: &entitiesMockClient{propertyGroupMockClient{twinMakerMockClient: &twinMakerMockClient{}}},
+		limiters:           map[string]*adaptiveLimiter{},
+	}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w", EntityShards: 3}, client)
+	entityIds := []string{}
+	for i := 0; i < 12; i++ {
+		entityIds = append(entityIds, fmt.Sprintf("Mixer_%d", i))
+	}
+	entityIds[5] = "missing"
+	query := models.TwinMakerQuery{
+		QueryType:     models.QueryTypeEntityHistory,
+		EntityIds:     entityIds,
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("Temperature")},
+	}
+
+	dr := ds.Query(context.Background(), query)
+	require.NoError(t, dr.Error)
+	// the frames keep the order of the entities across the shards
+	require.Len(t, dr.Frames, 11)
+	i := 0
+	for _, id := range entityIds {
+		if id == "missing" {
+			continue
+		}
+		value, _ := dr.Frames[i].FieldByName("Temperature")
+		require.Equal(t, id, value.Labels["entityId"])
+		i++
+	}
+	require.Equal(t, "entity missing: entity not found", dr.Frames[4].Meta.Notices[0].Text)
+
+	// each shard has its own limiter
+	require.Same(t, ds.shardLimiters[0], client.limiters["Mixer_0"])
+	require.Same(t, ds.shardLimiters[1], client.limiters["Mixer_4"])
+	require.Same(t, ds.shardLimiters[2], client.limiters["Mixer_11"])
+}
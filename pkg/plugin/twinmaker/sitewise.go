@@ -0,0 +1,111 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iotsitewise"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// sitewiseAssetIdProperty is the property of the SiteWise connector with the asset of a component
+const sitewiseAssetIdProperty = "sitewiseAssetId"
+
+// sitewiseValues loads the values of one asset property, stopped is true when paging ended
+// before the last page
+type sitewiseValues func(assetId string, property *iotsitewise.AssetProperty) (values []*iottwinmaker.PropertyValue, stopped bool, err error)
+
+// sitewiseHistory reads the properties of a component of the SiteWise connector from the SiteWise
+// asset of the component with load, as the TwinMaker history of the component. The component
+// properties are the asset properties of the same name, all of them unless the query selects some.
+func (s *twinMakerHandler) sitewiseHistory(ctx context.Context, query models.TwinMakerQuery, load sitewiseValues) (*iottwinmaker.GetPropertyValueHistoryOutput, []data.Notice, error) {
+	if query.EntityId == "" || query.ComponentName == "" {
+		return nil, nil, fmt.Errorf("missing entity or component parameter")
+	}
+	entity, err := s.client.GetEntity(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	component, ok := entity.Components[query.ComponentName]
+	if !ok || component == nil {
+		return nil, nil, fmt.Errorf("entity %s has no component %s", query.EntityId, query.ComponentName)
+	}
+	assetId := ""
+	if p := component.Properties[sitewiseAssetIdProperty]; p != nil && p.Value != nil {
+		assetId = aws.StringValue(p.Value.StringValue)
+	}
+	if assetId == "" {
+		return nil, nil, fmt.Errorf("component %s of entity %s is not connected to a SiteWise asset", query.ComponentName, query.EntityId)
+	}
+	assetProperties, err := s.client.GetAssetProperties(ctx, assetId)
+	if err != nil {
+		return nil, nil, err
+	}
+	byName := map[string]*iotsitewise.AssetProperty{}
+	for _, p := range assetProperties {
+		if p != nil && p.Name != nil {
+			byName[*p.Name] = p
+		}
+	}
+
+	// without selected properties, all component properties of the asset
+	if len(query.Properties) == 0 {
+		for name := range component.Properties {
+			if _, ok := byName[name]; ok {
+				query.Properties = append(query.Properties, aws.String(name))
+			}
+		}
+		sort.Slice(query.Properties, func(i, j int) bool {
+			return *query.Properties[i] < *query.Properties[j]
+		})
+	}
+
+	results := &iottwinmaker.GetPropertyValueHistoryOutput{}
+	var failures []data.Notice
+	partial := false
+	for _, name := range query.Properties {
+		propertyName := aws.StringValue(name)
+		property, ok := byName[propertyName]
+		if !ok {
+			failures = append(failures, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("property %s is not a property of SiteWise asset %s", propertyName, assetId),
+			})
+			continue
+		}
+		values, stopped, err := load(assetId, property)
+		if err != nil {
+			return nil, nil, err
+		}
+		partial = partial || stopped
+		results.PropertyValues = append(results.PropertyValues, &iottwinmaker.PropertyValueHistory{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(query.EntityId),
+				ComponentName: aws.String(query.ComponentName),
+				PropertyName:  aws.String(propertyName),
+			},
+			Values: values,
+		})
+	}
+	if partial {
+		failures = append(failures, partialNotice(ctx, false))
+	}
+	return results, failures, nil
+}
+
+// variantDataValue is the TwinMaker data value of a SiteWise value
+func variantDataValue(v *iotsitewise.Variant) *iottwinmaker.DataValue {
+	switch {
+	case v.DoubleValue != nil:
+		return &iottwinmaker.DataValue{DoubleValue: v.DoubleValue}
+	case v.IntegerValue != nil:
+		return &iottwinmaker.DataValue{IntegerValue: v.IntegerValue}
+	case v.BooleanValue != nil:
+		return &iottwinmaker.DataValue{BooleanValue: v.BooleanValue}
+	}
+	return &iottwinmaker.DataValue{StringValue: v.StringValue}
+}
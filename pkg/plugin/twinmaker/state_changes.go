@@ -0,0 +1,201 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// stateValue is the state a data value represents, numbers and booleans are formatted
+func stateValue(v *iottwinmaker.DataValue) (string, bool) {
+	switch {
+	case v == nil:
+		return "", false
+	case v.StringValue != nil:
+		return *v.StringValue, true
+	case v.BooleanValue != nil:
+		return strconv.FormatBool(*v.BooleanValue), true
+	case v.IntegerValue != nil:
+		return strconv.FormatInt(*v.IntegerValue, 10), true
+	case v.LongValue != nil:
+		return strconv.FormatInt(*v.LongValue, 10), true
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+type stateChange struct {
+	time     time.Time
+	from     *string
+	to       string
+	duration *int64 // milliseconds in the previous state
+}
+
+// stateChanges keeps the samples where the state differs from the previous one. The first sample
+// is a change from an unknown state.
+func stateChanges(values []*iottwinmaker.PropertyValue) []stateChange {
+	type sample struct {
+		time  time.Time
+		state string
+	}
+	samples := make([]sample, 0, len(values))
+	for _, v := range values {
+		t, err := getTimeObjectFromStringTime(v.Time)
+		if err != nil {
+			continue
+		}
+		if state, ok := stateValue(v.Value); ok {
+			samples = append(samples, sample{time: *t, state: state})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].time.Before(samples[j].time)
+	})
+
+	changes := []stateChange{}
+	for i, s := range samples {
+		if i == 0 {
+			changes = append(changes, stateChange{time: s.time, to: s.state})
+			continue
+		}
+		prev := changes[len(changes)-1]
+		if s.state == prev.to {
+			continue
+		}
+		from := prev.to
+		duration := s.time.Sub(prev.time).Milliseconds()
+		changes = append(changes, stateChange{time: s.time, from: &from, to: s.state, duration: &duration})
+	}
+	return changes
+}
+
+// getHistoryReferences loads all pages of the entity history, or of the component type history
+// when no entity is set. A partial result is marked with a notice.
+func (s *twinMakerHandler) getHistoryReferences(ctx context.Context, query models.TwinMakerQuery) ([]PropertyReference, []data.Notice, error) {
+	query.NextToken = ""
+
+	var propertyReferences []PropertyReference
+	var nextToken *string
+	failures := []data.Notice{}
+	if query.EntityId != "" {
+		result, err := s.GetPropertyValueHistoryPaginated(ctx, query, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		nextToken = result.NextToken
+		for _, prop := range result.PropertyValues {
+			propertyReferences = append(propertyReferences, PropertyReference{
+				values:                  prop.Values,
+				entityPropertyReference: prop.EntityPropertyReference,
+			})
+		}
+	} else {
+		var err error
+		propertyReferences, nextToken, failures, err = s.GetComponentHistoryWithLookup(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if nextToken != nil {
+		failures = append(failures, partialNotice(ctx, false))
+	}
+	return propertyReferences, failures, nil
+}
+
+// GetStateChanges returns only the transitions of state properties: the time, the previous and
+// the new state and how long the previous state lasted. Entity queries read the entity history,
+// otherwise the component type history is used.
+func (s *twinMakerHandler) GetStateChanges(ctx context.Context, query models.TwinMakerQuery) (dr backend.DataResponse) {
+	if query.EntityId == "" && query.ComponentTypeId == "" {
+		dr.Error = fmt.Errorf("missing entity or component type parameter")
+		return
+	}
+	// history is paged oldest first, a partial result then misses the latest changes only
+	query.Order = models.ResultOrderAsc
+	propertyReferences, failures, err := s.getHistoryReferences(ctx, query)
+	if err != nil {
+		dr.Error = err
+		return
+	}
+
+	skipped := 0
+	for _, p := range propertyReferences {
+		ref := p.entityPropertyReference
+		if ref == nil || ref.PropertyName == nil {
+			continue
+		}
+		// masked values would still show when the state changed
+		if s.redaction.action(*ref.PropertyName) != "" {
+			skipped++
+			continue
+		}
+		changes := stateChanges(p.values)
+		if len(changes) == 0 {
+			continue
+		}
+
+		labels := data.Labels{"propertyName": *ref.PropertyName}
+		if ref.EntityId != nil {
+			labels["entityId"] = *ref.EntityId
+		}
+		if ref.ComponentName != nil {
+			labels["componentName"] = *ref.ComponentName
+		}
+		if ref.ComponentName == nil || ref.EntityId == nil {
+			labels["componentTypeId"] = query.ComponentTypeId
+			for key, val := range ref.ExternalIdProperty {
+				if key != "propertyName" && val != nil {
+					labels[key] = *val
+				}
+			}
+		}
+		name := *ref.PropertyName
+		if query.FieldNaming == models.FieldNamingStableV1 {
+			name = stableSeriesName(ref)
+		} else if display, ok := query.PropertyDisplayNames[name]; ok {
+			name = display
+		}
+
+		fields := newTwinMakerFrameBuilder(len(changes))
+		t := fields.Time()
+		from := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableString, len(changes)), "from")
+		to := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableString, len(changes)), "to")
+		duration := fields.add(data.NewFieldFromFieldType(data.FieldTypeNullableInt64, len(changes)), "duration")
+		duration.Config = &data.FieldConfig{Unit: "ms"}
+		for i, c := range changes {
+			c := c
+			t.Set(i, &c.time)
+			from.Set(i, c.from)
+			to.Set(i, &c.to)
+			duration.Set(i, c.duration)
+		}
+		for _, f := range []*data.Field{from, to, duration} {
+			f.Labels = labels
+		}
+
+		frame := fields.ToFrame(name, nil)
+		frame.AppendNotices(failures...)
+		dr.Frames = append(dr.Frames, frame)
+	}
+
+	if len(dr.Frames) == 0 {
+		frame := data.NewFrame("")
+		frame.AppendNotices(failures...)
+		dr.Frames = append(dr.Frames, frame)
+	}
+	if skipped > 0 {
+		dr.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%d redacted properties were skipped", skipped),
+		})
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type stateMockClient struct {
+	*twinMakerMockClient
+	states []string
+}
+
+func (c *stateMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	values := []*iottwinmaker.PropertyValue{}
+	for i, state := range c.states {
+		values = append(values, &iottwinmaker.PropertyValue{
+			Time:  aws.String(time.Date(2022, 4, 27, 0, i, 0, 0, time.UTC).Format(time.RFC3339)),
+			Value: &iottwinmaker.DataValue{StringValue: aws.String(state)},
+		})
+	}
+	return &iottwinmaker.GetPropertyValueHistoryOutput{
+		PropertyValues: []*iottwinmaker.PropertyValueHistory{{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(query.EntityId),
+				ComponentName: aws.String(query.ComponentName),
+				PropertyName:  aws.String("alarm_status"),
+			},
+			Values: values,
+		}},
+	}, nil
+}
+
+func TestGetStateChanges(t *testing.T) {
+	client := &stateMockClient{
+		twinMakerMockClient: &twinMakerMockClient{},
+		states:              []string{"NORMAL", "NORMAL", "ACTIVE", "ACTIVE", "ACTIVE", "ACKNOWLEDGED", "NORMAL"},
+	}
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "AlarmComponent",
+		Properties:    []*string{aws.String("alarm_status")},
+	}
+
+	dr := newTwinMakerHandler(client, nil).GetStateChanges(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, dr.Frames, 1)
+	frame := dr.Frames[0]
+	require.Equal(t, 4, frame.Rows())
+
+	from, _ := frame.FieldByName("from")
+	to, _ := frame.FieldByName("to")
+	duration, _ := frame.FieldByName("duration")
+	require.Nil(t, from.At(0))
+	require.Equal(t, "NORMAL", *to.At(0).(*string))
+	require.Nil(t, duration.At(0))
+
+	require.Equal(t, "NORMAL", *from.At(1).(*string))
+	require.Equal(t, "ACTIVE", *to.At(1).(*string))
+	require.Equal(t, int64(2*time.Minute/time.Millisecond), *duration.At(1).(*int64))
+
+	require.Equal(t, "ACTIVE", *from.At(2).(*string))
+	require.Equal(t, int64(3*time.Minute/time.Millisecond), *duration.At(2).(*int64))
+	require.Equal(t, "NORMAL", *to.At(3).(*string))
+	require.Equal(t, "Mixer_0", to.Labels["entityId"])
+
+	t.Run("redacted", func(t *testing.T) {
+		redaction := newRedactor([]models.RedactionRule{{Pattern: "alarm_*"}})
+		dr := newTwinMakerHandler(client, redaction).GetStateChanges(context.Background(), query)
+		require.NoError(t, dr.Error)
+		require.Len(t, dr.Frames, 1)
+		require.Equal(t, 0, dr.Frames[0].Rows())
+		require.Contains(t, dr.Frames[0].Meta.Notices[0].Text, "1 redacted properties")
+	})
+
+	t.Run("missing parameters", func(t *testing.T) {
+		dr := newTwinMakerHandler(client, nil).GetStateChanges(context.Background(), models.TwinMakerQuery{})
+		require.Error(t, dr.Error)
+	})
+}
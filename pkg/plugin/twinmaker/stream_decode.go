@@ -0,0 +1,132 @@
+package twinmaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+)
+
+// streamResponse replaces the SDK unmarshaling of the response body. The SDK buffers the whole body
+// and decodes it into a generic copy before filling the output struct, instead the members of the
+// response object are read one at a time. member decodes the value of the members it knows and
+// returns false for the others, which are skipped.
+func streamResponse(req *request.Request, member func(d *json.Decoder, key string) (bool, error)) {
+	req.Handlers.Unmarshal.Clear()
+	req.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+		defer r.HTTPResponse.Body.Close()
+		if err := decodeObject(json.NewDecoder(r.HTTPResponse.Body), member); err != nil {
+			r.Error = awserr.New(request.ErrCodeSerialization, fmt.Sprintf("failed to decode %s response", r.Operation.Name), err)
+		}
+	})
+}
+
+func decodeObject(d *json.Decoder, member func(d *json.Decoder, key string) (bool, error)) error {
+	if err := expectDelim(d, '{'); err != nil {
+		return err
+	}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := t.(string)
+		handled, err := member(d, key)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			var skip json.RawMessage
+			if err := d.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := d.Token()
+	return err
+}
+
+// decodeElements calls each for every element of the array at the decoder position, so only one
+// element is held at a time. null is an empty array.
+func decodeElements(d *json.Decoder, each func() error) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected an array, got %v", t)
+	}
+	for d.More() {
+		if err := each(); err != nil {
+			return err
+		}
+	}
+	_, err = d.Token()
+	return err
+}
+
+func expectDelim(d *json.Decoder, delim json.Delim) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %v, got %v", delim, t)
+	}
+	return nil
+}
+
+// streamedPropertyValue is iottwinmaker.PropertyValue without the deprecated epoch timestamp, the
+// SDK type does not decode it with encoding/json
+type streamedPropertyValue struct {
+	Time  *string                 `json:"time"`
+	Value *iottwinmaker.DataValue `json:"value"`
+}
+
+// propertyValueHistoryPage sends one GetPropertyValueHistory request and decodes the property
+// values of the response one entity property at a time
+func propertyValueHistoryPage(ctx context.Context, client *iottwinmaker.IoTTwinMaker, params *iottwinmaker.GetPropertyValueHistoryInput) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	req, out := client.GetPropertyValueHistoryRequest(params)
+	req.SetContext(ctx)
+
+	streamResponse(req, func(d *json.Decoder, key string) (bool, error) {
+		switch key {
+		case "nextToken":
+			return true, d.Decode(&out.NextToken)
+		case "propertyValues":
+			out.PropertyValues = []*iottwinmaker.PropertyValueHistory{}
+			return true, decodeElements(d, func() error {
+				history := &iottwinmaker.PropertyValueHistory{}
+				err := decodeObject(d, func(d *json.Decoder, key string) (bool, error) {
+					switch key {
+					case "entityPropertyReference":
+						return true, d.Decode(&history.EntityPropertyReference)
+					case "values":
+						return true, decodeElements(d, func() error {
+							v := streamedPropertyValue{}
+							if err := d.Decode(&v); err != nil {
+								return err
+							}
+							history.Values = append(history.Values, &iottwinmaker.PropertyValue{Time: v.Time, Value: v.Value})
+							return nil
+						})
+					}
+					return false, nil
+				})
+				out.PropertyValues = append(out.PropertyValues, history)
+				return err
+			})
+		}
+		return false, nil
+	})
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
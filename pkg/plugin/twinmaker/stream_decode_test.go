@@ -0,0 +1,87 @@
+package twinmaker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTwinMakerService is an SDK client sending its requests to the test server
+func newTestTwinMakerService(t *testing.T, server *httptest.Server) *iottwinmaker.IoTTwinMaker {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+
+		DisableEndpointHostPrefix: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	return iottwinmaker.New(sess)
+}
+
+const propertyValueHistoryBody = `{
+  "nextToken": "page-2",
+  "unknownMember": {"ignored": [1, 2, 3]},
+  "propertyValues": [
+    {
+      "entityPropertyReference": {"entityId": "mixer_0", "componentName": "Alarm", "propertyName": "alarm_status"},
+      "values": [
+        {"time": "2022-04-27T10:00:00Z", "timestamp": 1651053600, "value": {"stringValue": "ACTIVE"}},
+        {"time": "2022-04-27T11:00:00.5Z", "value": {"doubleValue": 1.5}}
+      ]
+    },
+    {
+      "entityPropertyReference": {"externalIdProperty": {"alarm_key": "mixer_1"}, "propertyName": "alarm_status"},
+      "values": [
+        {"time": "2022-04-27T10:00:00Z", "value": {"listValue": [{"integerValue": 1}, {"booleanValue": true}], "mapValue": {"a": {"longValue": 2}}}},
+        {"time": "2022-04-27T10:30:00Z", "value": {"relationshipValue": {"targetEntityId": "line_1", "targetComponentName": "Line"}}}
+      ]
+    }
+  ]
+}`
+
+func TestPropertyValueHistoryPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(propertyValueHistoryBody))
+	}))
+	defer server.Close()
+	client := newTestTwinMakerService(t, server)
+	params := &iottwinmaker.GetPropertyValueHistoryInput{
+		WorkspaceId:        aws.String("AlarmWorkspace"),
+		ComponentTypeId:    aws.String("com.example.alarm"),
+		SelectedProperties: []*string{aws.String("alarm_status")},
+	}
+
+	streamed, err := propertyValueHistoryPage(context.Background(), client, params)
+	require.NoError(t, err)
+
+	// the same output as the SDK decoding, without the deprecated timestamps
+	expected, err := client.GetPropertyValueHistoryWithContext(context.Background(), params)
+	require.NoError(t, err)
+	for _, history := range expected.PropertyValues {
+		for _, v := range history.Values {
+			v.Timestamp = nil
+		}
+	}
+	require.Equal(t, expected, streamed)
+	require.Len(t, streamed.PropertyValues, 2)
+	require.Equal(t, "page-2", *streamed.NextToken)
+
+	t.Run("reports malformed responses", func(t *testing.T) {
+		broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"propertyValues": [{"values": [`))
+		}))
+		defer broken.Close()
+		_, err := propertyValueHistoryPage(context.Background(), newTestTwinMakerService(t, broken), params)
+		require.ErrorContains(t, err, "failed to decode GetPropertyValueHistory response")
+	})
+}
@@ -0,0 +1,45 @@
+package twinmaker
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// isoTimeSuffix names the ISO8601 string column after the time field it is formatted from
+const isoTimeSuffix = "_iso"
+
+// formatTimeFields renames the time field of every frame and, with isoString, adds a column of the
+// times as ISO8601 strings in UTC right after it. Exports to tools without a time type then keep
+// the exact timestamps.
+func formatTimeFields(res *backend.DataResponse, name string, isoString bool) {
+	for _, frame := range res.Frames {
+		fields := make([]*data.Field, 0, len(frame.Fields)+1)
+		for _, field := range frame.Fields {
+			fields = append(fields, field)
+			if t := field.Type(); field.Name != data.TimeSeriesTimeFieldName || (t != data.FieldTypeTime && t != data.FieldTypeNullableTime) {
+				continue
+			}
+			if name != "" {
+				field.Name = name
+			}
+			if isoString {
+				fields = append(fields, isoTimeField(field))
+			}
+		}
+		frame.Fields = fields
+	}
+}
+
+func isoTimeField(field *data.Field) *data.Field {
+	iso := data.NewFieldFromFieldType(data.FieldTypeNullableString, field.Len())
+	iso.Name = field.Name + isoTimeSuffix
+	for i := 0; i < field.Len(); i++ {
+		if v, ok := field.ConcreteAt(i); ok {
+			s := v.(time.Time).UTC().Format(time.RFC3339Nano)
+			iso.Set(i, &s)
+		}
+	}
+	return iso
+}
@@ -0,0 +1,46 @@
+package twinmaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatTimeFields(t *testing.T) {
+	newResponse := func() backend.DataResponse {
+		t0 := time.Date(2022, 4, 6, 10, 0, 0, 500000000, time.FixedZone("CEST", 2*60*60))
+		return backend.DataResponse{Frames: data.Frames{data.NewFrame("Temperature",
+			data.NewField(data.TimeSeriesTimeFieldName, nil, []*time.Time{&t0, nil}),
+			data.NewField("Temperature", nil, []*float64{aws.Float64(20), aws.Float64(21)}),
+		)}}
+	}
+
+	t.Run("rename", func(t *testing.T) {
+		res := newResponse()
+		formatTimeFields(&res, "timestamp", false)
+		require.Len(t, res.Frames[0].Fields, 2)
+		require.Equal(t, "timestamp", res.Frames[0].Fields[0].Name)
+	})
+
+	t.Run("iso string column after the time field", func(t *testing.T) {
+		res := newResponse()
+		formatTimeFields(&res, "timestamp", true)
+		fields := res.Frames[0].Fields
+		require.Len(t, fields, 3)
+		require.Equal(t, "timestamp_iso", fields[1].Name)
+		require.Equal(t, "2022-04-06T08:00:00.5Z", *fields[1].At(0).(*string))
+		require.Nil(t, fields[1].At(1))
+		require.Equal(t, "Temperature", fields[2].Name)
+	})
+
+	t.Run("iso string with the default name", func(t *testing.T) {
+		res := newResponse()
+		formatTimeFields(&res, "", true)
+		require.Equal(t, "Time", res.Frames[0].Fields[0].Name)
+		require.Equal(t, "Time_iso", res.Frames[0].Fields[1].Name)
+	})
+}
@@ -0,0 +1,84 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/grafana/grafana-plugin-sdk-go/build"
+)
+
+const pluginAgentName = "grafana-iot-twinmaker-app"
+
+type featureKey struct{}
+
+// WithFeature returns a context whose AWS calls are tagged with the feature, e.g. the query type
+// or the resource path they are made for
+func WithFeature(ctx context.Context, feature string) context.Context {
+	if feature == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, featureKey{}, feature)
+}
+
+func featureFromContext(ctx context.Context) string {
+	feature, _ := ctx.Value(featureKey{}).(string)
+	return feature
+}
+
+// UserAgent is the user agent of all AWS calls without the feature tag:
+// aws-sdk-go/<version> (<go version>; <os>;) grafana-iot-twinmaker-app/<version>-<hash> Grafana/<version>
+func UserAgent() string {
+	return userAgentString(pluginAgentName)
+}
+
+// TODO, move to https://github.com/grafana/grafana-plugin-sdk-go
+func userAgentString(name string) string {
+	buildInfo, err := build.GetBuildInfo()
+	if err != nil {
+		buildInfo.Version = "dev"
+		buildInfo.Hash = "?"
+	}
+
+	if len(buildInfo.Hash) > 8 {
+		buildInfo.Hash = buildInfo.Hash[0:8]
+	}
+
+	return fmt.Sprintf("%s/%s (%s; %s;) %s/%s-%s Grafana/%s",
+		aws.SDKName,
+		aws.SDKVersion,
+		runtime.Version(),
+		runtime.GOOS,
+		name,
+		buildInfo.Version,
+		buildInfo.Hash,
+		os.Getenv("GF_VERSION"))
+}
+
+// featureUserAgent appends the feature of the context as a feature/<name> product token,
+// characters that are not allowed in a token are replaced with dots
+func featureUserAgent(ctx context.Context, agent string) string {
+	feature := featureFromContext(ctx)
+	if feature == "" {
+		return agent
+	}
+	feature = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '.'
+	}, strings.Trim(feature, "/"))
+	return agent + " feature/" + feature
+}
+
+// setUserAgent is the send handler that sets the user agent with the feature of the request context
+func setUserAgent(agent string) func(r *request.Request) {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("User-Agent", featureUserAgent(r.Context(), agent))
+	}
+}
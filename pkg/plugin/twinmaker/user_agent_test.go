@@ -0,0 +1,25 @@
+package twinmaker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureUserAgent(t *testing.T) {
+	agent := UserAgent()
+	require.Contains(t, agent, pluginAgentName+"/")
+
+	ctx := context.Background()
+	require.Equal(t, agent, featureUserAgent(ctx, agent))
+	require.Equal(t, agent+" feature/GetAlarms", featureUserAgent(WithFeature(ctx, "GetAlarms"), agent))
+	require.Equal(t, agent+" feature/resource.alarms.export", featureUserAgent(WithFeature(ctx, "resource/alarms/export"), agent))
+
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	r.SetContext(WithFeature(ctx, "PropertyHistogram"))
+	setUserAgent(agent)(r)
+	require.Equal(t, agent+" feature/PropertyHistogram", r.HTTPRequest.Header.Get("User-Agent"))
+}
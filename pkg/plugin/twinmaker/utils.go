@@ -3,12 +3,17 @@ package twinmaker
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
 	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -26,11 +31,32 @@ type IAMPolicy struct {
 	Statement []PolicyStatement `json:"Statement"`
 }
 
-func LoadPolicy(workspace *iottwinmaker.GetWorkspaceOutput) (string, error) {
+// LoadPolicy is the inline session policy of the dashboard token. When the bucket is SSE-KMS
+// encrypted, bucketKey is its key and the token may use it for objects of the bucket. The token
+// only reads the bucket, scene assets are uploaded and alarm models acknowledged or snoozed by the
+// backend with the writer role.
+func LoadPolicy(workspace *iottwinmaker.GetWorkspaceOutput, bucketKey string) (string, error) {
+	return loadPolicy(workspace, "", bucketKey, false)
+}
+
+// LoadViewerPolicy is the narrower session policy of viewer role tokens, anonymous displays can
+// read the workspace, its bucket and video streams but write nothing
+func LoadViewerPolicy(workspace *iottwinmaker.GetWorkspaceOutput, bucketKey string) (string, error) {
+	return loadPolicy(workspace, "", bucketKey, true)
+}
+
+// loadPolicy constructs the ARNs in the partition of the workspace, see policyPartition, region
+// is the configured region of the client
+func loadPolicy(workspace *iottwinmaker.GetWorkspaceOutput, region string, bucketKey string, viewer bool) (string, error) {
+	partition := policyPartition(workspace, region)
 	data := map[string]interface{}{
-		"S3BucketArn":  workspace.S3Location,
+		"S3BucketArn":  s3BucketArn(aws.StringValue(workspace.S3Location), partition),
 		"WorkspaceArn": workspace.Arn,
 		"WorkspaceId":  workspace.WorkspaceId,
+		"Viewer":       viewer,
+	}
+	if bucketKey != "" {
+		data["KMSKeyArn"] = bucketKeyArn(workspace, bucketKey, partition)
 	}
 
 	policyTemplate := `{
@@ -73,7 +99,7 @@ func LoadPolicy(workspace *iottwinmaker.GetWorkspaceOutput) (string, error) {
 				],
 				"Resource": "*"
 			},
-			{
+			{{if not .Viewer}}{
 				 "Effect": "Allow",
 				 "Action": [
 				  "iotsitewise:BatchPutAssetPropertyValue"
@@ -84,7 +110,19 @@ func LoadPolicy(workspace *iottwinmaker.GetWorkspaceOutput) (string, error) {
 					"aws:ResourceTag/EdgeConnectorForKVS": "*{{.WorkspaceId}}*"
 				  } 
 				}
-			},
+			},{{end}}
+			{{if .KMSKeyArn}}{
+				"Effect": "Allow",
+				"Action": ["kms:Decrypt"],
+				"Resource": [
+					"{{.KMSKeyArn}}"
+				],
+				"Condition": {
+					"StringLike": {
+						"kms:EncryptionContext:aws:s3:arn": ["{{.S3BucketArn}}", "{{.S3BucketArn}}/*"]
+					}
+				}
+			},{{end}}
 			{
 				"Effect": "Allow",
 				"Action": ["s3:GetObject"],
@@ -96,36 +134,26 @@ func LoadPolicy(workspace *iottwinmaker.GetWorkspaceOutput) (string, error) {
 		]
 	}`
 
-	buffer := new(bytes.Buffer)
-	err := json.Compact(buffer, []byte(policyTemplate))
+	// compacted after executing, the action lists are not valid JSON before
+	t := template.Must(template.New("policy").Parse(policyTemplate))
+	builder := &strings.Builder{}
+
+	err := t.Execute(builder, data)
 	if err != nil {
 		return "", err
 	}
-	policyTemplate = buffer.String()
 
-	t := template.Must(template.New("policy").Parse(policyTemplate))
-	builder := &strings.Builder{}
-
-	err = t.Execute(builder, data)
+	buffer := new(bytes.Buffer)
+	err = json.Compact(buffer, []byte(builder.String()))
 	if err != nil {
 		return "", err
 	}
 
-	return builder.String(), err
+	return buffer.String(), err
 }
 
-func checkForUrl(v *iottwinmaker.DataValue, convertor func(v *iottwinmaker.DataValue) interface{}) bool {
-	val := convertor(v)
-	switch val.(type) {
-	case *string:
-		val = *v.StringValue
-		if strings.Contains(val.(string), "://") {
-			return true
-		}
-	default:
-		break
-	}
-	return false
+func checkForUrl(v *iottwinmaker.DataValue) bool {
+	return v != nil && v.StringValue != nil && strings.Contains(*v.StringValue, "://")
 }
 
 func setUrlDatalink(field *data.Field) {
@@ -142,35 +170,95 @@ type PropertyReference struct {
 	entityName              *string
 }
 
-func GetEntityPropertyReferenceKey(entityPropertyReference *iottwinmaker.EntityPropertyReference, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (s string) {
-	externalId := ""
-	for key, val := range entityPropertyReference.ExternalIdProperty {
+// externalIdPairs are the externalId key/value pairs of a reference sorted by key, flattened as
+// key, value, ... Components can map several externalId properties, each is part of the identity.
+func externalIdPairs(ref *iottwinmaker.EntityPropertyReference, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) []string {
+	keys := make([]string, 0, len(ref.ExternalIdProperty))
+	for key, val := range ref.ExternalIdProperty {
 		// Check that the property is an externalId property
-		if property, ok := propertyDefinitions[key]; ok && *property.IsExternalId {
-			externalId = *val
-			break
+		if property, ok := propertyDefinitions[key]; ok && property != nil && aws.BoolValue(property.IsExternalId) && val != nil {
+			keys = append(keys, key)
 		}
 	}
-	// Key is the combination of the unique entityId_componentName_externalId_propertyId
-	refKey := ""
-	if entityPropertyReference.EntityId != nil {
-		refKey = refKey + *entityPropertyReference.EntityId + "_"
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key, *ref.ExternalIdProperty[key])
+	}
+	return pairs
+}
+
+// GetEntityPropertyReferenceKey returns the canonical identity of a reference. The parts are JSON encoded
+// so ids containing the separator (e.g. "Mixer_1" + "_" + "Alarm" vs "Mixer" + "_" + "1_Alarm") cannot collide
+func GetEntityPropertyReferenceKey(entityPropertyReference *iottwinmaker.EntityPropertyReference, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (s string) {
+	externalIds := externalIdPairs(entityPropertyReference, propertyDefinitions)
+
+	// Key is the combination of the unique entityId, componentName, propertyName and all externalId pairs
+	refKey, _ := json.Marshal(append([]string{
+		aws.StringValue(entityPropertyReference.EntityId),
+		aws.StringValue(entityPropertyReference.ComponentName),
+		aws.StringValue(entityPropertyReference.PropertyName),
+	}, externalIds...))
+	return string(refKey)
+}
+
+// mergePropertyReferences combines references that resolve to the same entity property, e.g. when
+// several externalId pages or lookups point at the same component, so each one becomes a single field
+func mergePropertyReferences(propertyReferences []PropertyReference, order models.TwinMakerResultOrder) []PropertyReference {
+	merged := make([]PropertyReference, 0, len(propertyReferences))
+	index := map[string]int{}
+	sortNeeded := map[int]bool{}
+	for _, pr := range propertyReferences {
+		key := GetEntityPropertyReferenceKey(pr.entityPropertyReference, nil)
+		if aws.StringValue(pr.entityPropertyReference.ComponentName) == "" {
+			// unresolved components are only the same reference if the externalIds match
+			ids, _ := json.Marshal(pr.entityPropertyReference.ExternalIdProperty)
+			key += string(ids)
+		}
+		if i, ok := index[key]; ok {
+			merged[i].values = append(merged[i].values, pr.values...)
+			sortNeeded[i] = true
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, pr)
 	}
-	if entityPropertyReference.ComponentName != nil {
-		refKey = refKey + *entityPropertyReference.ComponentName + "_"
+
+	for i := range sortNeeded {
+		sortPropertyValues(merged[i].values, order)
 	}
-	refKey = refKey + externalId + "_"
-	if entityPropertyReference.PropertyName != nil {
-		refKey = refKey + *entityPropertyReference.PropertyName
+	return merged
+}
+
+// sortPropertyValues keeps merged values in query order, values without a valid time go last
+func sortPropertyValues(values []*iottwinmaker.PropertyValue, order models.TwinMakerResultOrder) {
+	times := make(map[*iottwinmaker.PropertyValue]*time.Time, len(values))
+	for _, v := range values {
+		if t, err := getTimeObjectFromStringTime(v.Time); err == nil {
+			times[v] = t
+		}
 	}
-	return refKey
+	sort.SliceStable(values, func(i, j int) bool {
+		ti, tj := times[values[i]], times[values[j]]
+		if ti == nil || tj == nil {
+			return ti != nil
+		}
+		if order == models.ResultOrderDesc {
+			return ti.After(*tj)
+		}
+		return ti.Before(*tj)
+	})
 }
 
 /*
 * This function returns the latest value for each entity property.
 * Assumes that the Roci Api query returns data from latest to oldest.
  */
+// GetLatestPropertyValueHistoryPaginated keeps the first value of each property. The NextToken of the
+// result is only set when paging stopped before the query deadline or at the history call budget.
 func (s *twinMakerHandler) GetLatestPropertyValueHistoryPaginated(ctx context.Context, query models.TwinMakerQuery, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	start := time.Now()
 	var maxPropertyValues int
 	isLimited := false
 	if query.MaxResults > 0 {
@@ -179,6 +267,7 @@ func (s *twinMakerHandler) GetLatestPropertyValueHistoryPaginated(ctx context.Co
 		query.MaxResults = 0
 	}
 
+	countHistoryCall(ctx)
 	propertyValueHistories, err := s.client.GetPropertyValueHistory(ctx, query)
 	if err != nil {
 		return nil, err
@@ -199,17 +288,26 @@ func (s *twinMakerHandler) GetLatestPropertyValueHistoryPaginated(ctx context.Co
 			if len(propertyValueHistories.PropertyValues) > maxPropertyValues {
 				propertyValueHistories.PropertyValues = propertyValueHistories.PropertyValues[:maxPropertyValues]
 			}
+			propertyValueHistories.NextToken = nil
 			return propertyValueHistories, nil
 		}
 	}
 
+	lastPage := time.Since(start)
+	calls := 1
 	cPropertyValuesHistories := propertyValueHistories
 	for cPropertyValuesHistories.NextToken != nil {
+		if deadlineNear(ctx, lastPage) || previewPageLoaded(ctx, calls) || callBudgetReached(ctx) {
+			break
+		}
 		query.NextToken = *cPropertyValuesHistories.NextToken
+		start := time.Now()
 		cPropertyValuesHistories, err := s.client.GetPropertyValueHistory(ctx, query)
 		if err != nil {
 			return nil, err
 		}
+		lastPage = time.Since(start)
+		calls++
 
 		for _, propertyValue := range cPropertyValuesHistories.PropertyValues {
 			refKey := GetEntityPropertyReferenceKey(propertyValue.EntityPropertyReference, propertyDefinitions)
@@ -223,6 +321,7 @@ func (s *twinMakerHandler) GetLatestPropertyValueHistoryPaginated(ctx context.Co
 
 				// if we have max results, return
 				if isLimited && len(entityPropertyReferenceMapping) >= maxPropertyValues {
+					propertyValueHistories.NextToken = nil
 					return propertyValueHistories, nil
 				}
 			}
@@ -234,11 +333,129 @@ func (s *twinMakerHandler) GetLatestPropertyValueHistoryPaginated(ctx context.Co
 	return propertyValueHistories, nil
 }
 
+const (
+	minHistoryPageSize = 20
+	maxHistoryPageSize = 250 // GetPropertyValueHistory limit
+	// ListEntities limit
+	maxListEntitiesPageSize = 200
+)
+
+// pageSize is the page size a query sets, at most max, 0 when it sets none
+func pageSize(query models.TwinMakerQuery, max int) int {
+	if query.PageSize > max {
+		return max
+	}
+	return query.PageSize
+}
+
+// nextHistoryPageSize estimates how many values are left in the query time range from the
+// density of the last page, so sparse properties finish in one small page and dense ones use full pages
+func nextHistoryPageSize(page *iottwinmaker.GetPropertyValueHistoryOutput, query models.TwinMakerQuery) int {
+	remaining, ok := remainingHistoryValues(page, query)
+	if !ok {
+		return maxHistoryPageSize
+	}
+	// leave some headroom so a slightly denser tail still fits in the page
+	size := int(remaining*1.25) + 1
+
+	if size < minHistoryPageSize {
+		return minHistoryPageSize
+	}
+	if size > maxHistoryPageSize {
+		return maxHistoryPageSize
+	}
+	return size
+}
+
+const (
+	minCacheTTL = 10 * time.Second
+	maxCacheTTL = time.Hour
+)
+
+// cacheTTLHint derives a query cache TTL from the ingestion cadence of a property, a fifth of the
+// median interval between values (data updated every 5 min => 60s). Returns 0 when unknown.
+func cacheTTLHint(values []*iottwinmaker.PropertyValue) time.Duration {
+	times := make([]time.Time, 0, len(values))
+	for _, v := range values {
+		if t, err := getTimeObjectFromStringTime(v.Time); err == nil {
+			times = append(times, *t)
+		}
+	}
+	if len(times) < 2 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	intervals := make([]time.Duration, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d > 0 {
+			intervals = append(intervals, d)
+		}
+	}
+	if len(intervals) == 0 {
+		return 0
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+
+	ttl := (intervals[len(intervals)/2] / 5).Truncate(time.Second)
+	if ttl < minCacheTTL {
+		return minCacheTTL
+	}
+	if ttl > maxCacheTTL {
+		return maxCacheTTL
+	}
+	return ttl
+}
+
+// remainingHistoryValues extrapolates the density of a page to the part of the query time range
+// after it (before it for descending order). ok is false when the density is unknown.
+func remainingHistoryValues(page *iottwinmaker.GetPropertyValueHistoryOutput, query models.TwinMakerQuery) (float64, bool) {
+	count := 0
+	var first, last *time.Time
+	for _, p := range page.PropertyValues {
+		for _, v := range p.Values {
+			t, err := getTimeObjectFromStringTime(v.Time)
+			if err != nil {
+				continue
+			}
+			count++
+			if first == nil || t.Before(*first) {
+				first = t
+			}
+			if last == nil || t.After(*last) {
+				last = t
+			}
+		}
+	}
+	if count < 2 || !last.After(*first) {
+		return 0, false
+	}
+
+	remaining := query.TimeRange.To.Sub(*last)
+	if query.Order == models.ResultOrderDesc {
+		remaining = first.Sub(query.TimeRange.From)
+	}
+	density := float64(count) / float64(last.Sub(*first))
+	return density * float64(remaining), true
+}
+
+// GetPropertyValueHistoryPaginated loads all pages of the query. The NextToken of the result is only
+// set when paging stopped before the query deadline or at the history call budget, it continues
+// from the next page.
 func (s *twinMakerHandler) GetPropertyValueHistoryPaginated(ctx context.Context, query models.TwinMakerQuery, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	// tune the page size to the observed density unless the query sets one
+	adaptive := query.MaxResults == 0 && query.PageSize == 0
+
+	start := time.Now()
+	countHistoryCall(ctx)
 	propertyValueHistories, err := s.client.GetPropertyValueHistory(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	lastPage := time.Since(start)
+	if adaptive {
+		query.MaxResults = nextHistoryPageSize(propertyValueHistories, query)
+	}
 
 	// Keep mapping of entityPropertyReferences to its index in the result's propertyValues
 	entityPropertyReferenceMapping := map[string]int{}
@@ -247,13 +464,20 @@ func (s *twinMakerHandler) GetPropertyValueHistoryPaginated(ctx context.Context,
 		entityPropertyReferenceMapping[refKey] = i
 	}
 
+	calls := 1
 	cPropertyValuesHistories := propertyValueHistories
 	for cPropertyValuesHistories.NextToken != nil {
+		if deadlineNear(ctx, lastPage) || previewPageLoaded(ctx, calls) || callBudgetReached(ctx) {
+			break
+		}
 		query.NextToken = *cPropertyValuesHistories.NextToken
+		start := time.Now()
 		cPropertyValuesHistories, err := s.client.GetPropertyValueHistory(ctx, query)
 		if err != nil {
 			return nil, err
 		}
+		lastPage = time.Since(start)
+		calls++
 
 		for _, propertyValue := range cPropertyValuesHistories.PropertyValues {
 			refKey := GetEntityPropertyReferenceKey(propertyValue.EntityPropertyReference, propertyDefinitions)
@@ -265,6 +489,9 @@ func (s *twinMakerHandler) GetPropertyValueHistoryPaginated(ctx context.Context,
 				propertyValueHistories.PropertyValues = append(propertyValueHistories.PropertyValues, propertyValue)
 			}
 		}
+		if adaptive {
+			query.MaxResults = nextHistoryPageSize(cPropertyValuesHistories, query)
+		}
 
 		propertyValueHistories.NextToken = cPropertyValuesHistories.NextToken
 	}
@@ -272,117 +499,316 @@ func (s *twinMakerHandler) GetPropertyValueHistoryPaginated(ctx context.Context,
 	return propertyValueHistories, nil
 }
 
-func (s *twinMakerHandler) GetComponentHistoryWithLookupHelper(ctx context.Context, query models.TwinMakerQuery, historyFunction func(ctx context.Context, query models.TwinMakerQuery, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (*iottwinmaker.GetPropertyValueHistoryOutput, error)) (p []PropertyReference, n []data.Notice, err error) {
+// GetPropertyValueHistoryLimited loads the first query.Limit values of each property, in the query
+// order. Paging stops when all queried properties have them, there are no more pages or the query
+// deadline or the history call budget is near. Only the last case keeps the NextToken.
+func (s *twinMakerHandler) GetPropertyValueHistoryLimited(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	limit := query.Limit
+	if query.MaxResults == 0 && query.PageSize == 0 {
+		query.MaxResults = limit * len(query.Properties)
+		if query.MaxResults > maxHistoryPageSize {
+			query.MaxResults = maxHistoryPageSize
+		}
+	}
+
+	result := &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{}}
+	index := map[string]int{}
+	countHistoryCall(ctx)
+	for calls := 1; ; calls++ {
+		start := time.Now()
+		page, err := s.client.GetPropertyValueHistory(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		lastPage := time.Since(start)
+
+		for _, propertyValue := range page.PropertyValues {
+			refKey := GetEntityPropertyReferenceKey(propertyValue.EntityPropertyReference, nil)
+			i, ok := index[refKey]
+			if !ok {
+				i = len(result.PropertyValues)
+				index[refKey] = i
+				result.PropertyValues = append(result.PropertyValues, &iottwinmaker.PropertyValueHistory{
+					EntityPropertyReference: propertyValue.EntityPropertyReference,
+				})
+			}
+			merged := result.PropertyValues[i]
+			values := propertyValue.Values
+			if room := limit - len(merged.Values); len(values) > room {
+				values = values[:room]
+			}
+			merged.Values = append(merged.Values, values...)
+		}
+
+		if page.NextToken == nil || limitReached(result, len(query.Properties), limit) {
+			return result, nil
+		}
+		if deadlineNear(ctx, lastPage) || previewPageLoaded(ctx, calls) || callBudgetReached(ctx) {
+			result.NextToken = page.NextToken
+			return result, nil
+		}
+		query.NextToken = *page.NextToken
+	}
+}
+
+// limitReached checks that each of the properties has limit values
+func limitReached(result *iottwinmaker.GetPropertyValueHistoryOutput, properties int, limit int) bool {
+	if len(result.PropertyValues) < properties {
+		return false
+	}
+	for _, p := range result.PropertyValues {
+		if len(p.Values) < limit {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *twinMakerHandler) GetComponentHistoryWithLookupHelper(ctx context.Context, query models.TwinMakerQuery, historyFunction func(ctx context.Context, query models.TwinMakerQuery, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (*iottwinmaker.GetPropertyValueHistoryOutput, error)) (p []PropertyReference, nextToken *string, n []data.Notice, err error) {
 	propertyReferences := []PropertyReference{}
 	failures := []data.Notice{}
-	componentTypeId := query.ComponentTypeId
 
 	// Step 1: Call GetComponentType to get the property list for externalId validation
 	ct, err := s.client.GetComponentType(ctx, query)
 	if err != nil {
-		return propertyReferences, failures, err
+		return propertyReferences, nil, failures, err
 	}
 
+	if err := validateComponentTypeHistory(ct, query); err != nil {
+		return propertyReferences, nil, failures, err
+	}
 	propertyDefinitions := ct.PropertyDefinitions
 
 	// Step 2: Call GetPropertyValueHistory and get the externalId from the response
 	result, err := historyFunction(ctx, query, propertyDefinitions)
 	if err != nil {
-		return propertyReferences, failures, err
-	}
-
-	if len(result.PropertyValues) > 0 {
-		// Loop through all propertyValues if there are multiple components of the same type on the entity
-		for _, propertyValue := range result.PropertyValues {
-			externalId := ""
-			for key, val := range propertyValue.EntityPropertyReference.ExternalIdProperty {
-				// Check that the property is an externalId property
-				if property, ok := propertyDefinitions[key]; ok && *property.IsExternalId {
-					externalId = *val
-					break
-				}
-			}
+		return propertyReferences, nil, failures, err
+	}
+	if err := checkComponentHistoryFanOut(result, query); err != nil {
+		return propertyReferences, nil, failures, err
+	}
 
-			// Step 3: Call ListEntities with a filter for the externalId
-			query.EntityId = ""
-			query.Properties = nil
-			query.ComponentTypeId = ""
+	// Steps 3 and 4 for each of the components of the same type, the lookups run concurrently
+	lookups := make([]entityLookup, len(result.PropertyValues))
+	slots := make(chan struct{}, maxEntityLookups)
+	var wg sync.WaitGroup
+	for i, propertyValue := range result.PropertyValues {
+		wg.Add(1)
+		go func(i int, propertyValue *iottwinmaker.PropertyValueHistory) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			lookups[i] = s.lookupEntity(ctx, query, propertyValue, propertyDefinitions)
+		}(i, propertyValue)
+	}
+	wg.Wait()
 
-			query.ListEntitiesFilter = []models.TwinMakerListEntitiesFilter{
-				{
-					ExternalId: externalId,
-				},
-			}
-			le, err := s.client.ListEntities(ctx, query)
+	// in the order of the history results
+	for _, lookup := range lookups {
+		failures = append(failures, lookup.notices...)
+		if lookup.err != nil {
+			return propertyReferences, nil, failures, lookup.err
+		}
+		propertyReferences = append(propertyReferences, lookup.reference)
+	}
 
-			if err != nil {
-				notice := data.Notice{
-					Severity: data.NoticeSeverityWarning,
-					Text:     err.Error(),
-				}
-				failures = append(failures, notice)
-			}
-			if le == nil {
-				return propertyReferences, failures, fmt.Errorf("error loading entities for GetAlarms query")
-			}
+	return mergePropertyReferences(propertyReferences, query.Order), result.NextToken, failures, nil
+}
 
-			// Step 4: Call GetEntity to get the componentName of the externalId
-			if len(le.EntitySummaries) > 0 {
-				entityId := le.EntitySummaries[0].EntityId
-				entityName := le.EntitySummaries[0].EntityName
-				query.EntityId = *entityId
-				e, err := s.client.GetEntity(ctx, query)
-				if err != nil {
-					notice := data.Notice{
-						Severity: data.NoticeSeverityWarning,
-						Text:     err.Error(),
-					}
-					failures = append(failures, notice)
-				} else if e == nil {
-					return propertyReferences, failures, fmt.Errorf("error loading entity for GetAlarms query")
-				}
+// maxEntityLookups bounds the externalId lookups of a component history query at the same time
+const maxEntityLookups = 8
 
-				componentName := ""
-				for _, component := range e.Components {
-					// If the componentTypeId and externalId match then we found the component
-					if *component.ComponentTypeId == componentTypeId {
-						for _, property := range component.Properties {
-							if *property.Definition.IsExternalId {
-								if *property.Value.StringValue == externalId {
-									componentName = *component.ComponentName
-									break
-								}
-							}
-						}
-					}
-				}
+// entityLookup is the resolved series of a component history result and the notices of the lookup
+type entityLookup struct {
+	reference PropertyReference
+	notices   []data.Notice
+	err       error
+}
 
-				pr := PropertyReference{
-					values: propertyValue.Values,
-					entityPropertyReference: &iottwinmaker.EntityPropertyReference{
-						EntityId:           entityId,
-						ComponentName:      &componentName,
-						ExternalIdProperty: propertyValue.EntityPropertyReference.ExternalIdProperty,
-						PropertyName:       propertyValue.EntityPropertyReference.PropertyName,
-					},
-					entityName: entityName,
-				}
-				propertyReferences = append(propertyReferences, pr)
-			}
+// lookupEntity finds the entity and component of the externalId of a component history result
+func (s *twinMakerHandler) lookupEntity(ctx context.Context, query models.TwinMakerQuery, propertyValue *iottwinmaker.PropertyValueHistory, propertyDefinitions map[string]*iottwinmaker.PropertyDefinitionResponse) (lookup entityLookup) {
+	componentTypeId := query.ComponentTypeId
+	pairs := externalIdPairs(propertyValue.EntityPropertyReference, propertyDefinitions)
+	// components with several externalId properties resolve by all of them, the entities are
+	// listed by the first one
+	filterId := ""
+	externalId := ""
+	if len(pairs) > 0 {
+		filterId = pairs[1]
+		externalId = filterId
+	}
+	if len(pairs) > 2 {
+		ids, _ := json.Marshal(pairs)
+		externalId = string(ids)
+	}
+
+	// pinned queries resolve from their snapshot first, new externalIds are added to it
+	snapshot := resolutionSnapshotFrom(ctx)
+	snapshotKey := externalIdKey(query.WorkspaceId, componentTypeId, externalId)
+	if r, ok := snapshot.get(snapshotKey); ok {
+		lookup.reference = externalIdReference(propertyValue, r)
+		return
+	}
+	if r, ok := s.externalIds.get(query.WorkspaceId, componentTypeId, externalId); ok {
+		snapshot.set(snapshotKey, r)
+		lookup.reference = externalIdReference(propertyValue, r)
+		return
+	}
+
+	// Step 3: Call ListEntities with a filter for the externalId. The entity metadata is cached
+	// longer than the resolutions, so it is loaded again instead of resolving to a stale entity.
+	ctx = withRefresh(ctx)
+	query.EntityId = ""
+	query.Properties = nil
+	query.ComponentTypeId = ""
+
+	query.ListEntitiesFilter = []models.TwinMakerListEntitiesFilter{
+		{
+			ExternalId: filterId,
+		},
+	}
+	le, err := s.client.ListEntities(ctx, query)
+
+	// Keep the series labeled by externalId when the entity can not be resolved (e.g. not synced yet)
+	if err != nil || le == nil || len(le.EntitySummaries) == 0 {
+		reason := "no matching entity"
+		if err != nil {
+			reason = err.Error()
+		}
+		lookup.notices = append(lookup.notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("could not resolve entity for externalId %s: %s", externalId, reason),
+		})
+		lookup.reference = PropertyReference{
+			values:                  propertyValue.Values,
+			entityPropertyReference: propertyValue.EntityPropertyReference,
 		}
+		return
 	}
 
-	return propertyReferences, failures, nil
+	// Step 4: Call GetEntity to get the componentName of the externalId
+	entityId := le.EntitySummaries[0].EntityId
+	entityName := le.EntitySummaries[0].EntityName
+	query.EntityId = *entityId
+	e, err := s.client.GetEntity(ctx, query)
+	if err != nil {
+		notice := data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     err.Error(),
+		}
+		lookup.notices = append(lookup.notices, notice)
+	} else if e == nil {
+		lookup.err = fmt.Errorf("error loading entity for GetAlarms query")
+		return
+	}
+
+	componentName := ""
+	if e == nil {
+		e = &iottwinmaker.GetEntityOutput{}
+	}
+	for _, component := range e.Components {
+		// If the componentTypeId and externalId match then we found the component
+		if *component.ComponentTypeId == componentTypeId && matchesExternalIds(component, pairs) {
+			componentName = *component.ComponentName
+			break
+		}
+	}
+
+	r := externalIdResolution{
+		entityId:      *entityId,
+		entityName:    aws.StringValue(entityName),
+		componentName: componentName,
+	}
+	// only complete resolutions are reused, a failed GetEntity is retried on the next query
+	if len(lookup.notices) == 0 && componentName != "" {
+		s.externalIds.set(query.WorkspaceId, componentTypeId, externalId, r)
+		snapshot.set(snapshotKey, r)
+	}
+	lookup.reference = externalIdReference(propertyValue, r)
+	return
 }
 
-func (s *twinMakerHandler) GetLatestComponentHistoryWithLookup(ctx context.Context, query models.TwinMakerQuery) (p []PropertyReference, n []data.Notice, err error) {
+// matchesExternalIds checks the externalId properties of a component against the pairs of a
+// result, all of them have to match
+func matchesExternalIds(component *iottwinmaker.ComponentResponse, pairs []string) bool {
+	if len(pairs) == 0 {
+		return false
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		property, ok := component.Properties[pairs[i]]
+		if !ok || property.Value == nil || aws.StringValue(property.Value.StringValue) != pairs[i+1] {
+			return false
+		}
+	}
+	return true
+}
+
+func externalIdReference(propertyValue *iottwinmaker.PropertyValueHistory, r externalIdResolution) PropertyReference {
+	return PropertyReference{
+		values: propertyValue.Values,
+		entityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:           aws.String(r.entityId),
+			ComponentName:      aws.String(r.componentName),
+			ExternalIdProperty: propertyValue.EntityPropertyReference.ExternalIdProperty,
+			PropertyName:       propertyValue.EntityPropertyReference.PropertyName,
+		},
+		entityName: aws.String(r.entityName),
+	}
+}
+
+func (s *twinMakerHandler) GetLatestComponentHistoryWithLookup(ctx context.Context, query models.TwinMakerQuery) (p []PropertyReference, nextToken *string, n []data.Notice, err error) {
 	return s.GetComponentHistoryWithLookupHelper(ctx, query, s.GetLatestPropertyValueHistoryPaginated)
 }
 
-func (s *twinMakerHandler) GetComponentHistoryWithLookup(ctx context.Context, query models.TwinMakerQuery) (p []PropertyReference, n []data.Notice, err error) {
+func (s *twinMakerHandler) GetComponentHistoryWithLookup(ctx context.Context, query models.TwinMakerQuery) (p []PropertyReference, nextToken *string, n []data.Notice, err error) {
 	return s.GetComponentHistoryWithLookupHelper(ctx, query, s.GetPropertyValueHistoryPaginated)
 }
 
+// workspaceEvent is the subset of a CloudTrail event record needed for annotations
+type workspaceEvent struct {
+	UserIdentity struct {
+		Arn string `json:"arn"`
+	} `json:"userIdentity"`
+	RequestParameters struct {
+		WorkspaceId     string `json:"workspaceId"`
+		EntityId        string `json:"entityId"`
+		ComponentTypeId string `json:"componentTypeId"`
+		SceneId         string `json:"sceneId"`
+	} `json:"requestParameters"`
+	ErrorCode string `json:"errorCode"`
+
+	event *cloudtrail.Event
+}
+
+func (e *workspaceEvent) describe() string {
+	target := ""
+	switch p := e.RequestParameters; {
+	case p.EntityId != "":
+		target = "entity " + p.EntityId
+	case p.ComponentTypeId != "":
+		target = "component type " + p.ComponentTypeId
+	case p.SceneId != "":
+		target = "scene " + p.SceneId
+	default:
+		target = "workspace " + p.WorkspaceId
+	}
+
+	text := fmt.Sprintf("%s on %s", *e.event.EventName, target)
+	if e.UserIdentity.Arn != "" {
+		text += " by " + e.UserIdentity.Arn
+	} else if e.event.Username != nil {
+		text += " by " + *e.event.Username
+	}
+	if e.ErrorCode != "" {
+		text += " (failed: " + e.ErrorCode + ")"
+	}
+	return text
+}
+
+func isMutationEvent(name string) bool {
+	return strings.HasPrefix(name, "Create") || strings.HasPrefix(name, "Update") || strings.HasPrefix(name, "Delete")
+}
+
 func getTimeObjectFromStringTime(timeString *string) (*time.Time, error) {
 	if timeString == nil {
 		return nil, fmt.Errorf("no time string")
@@ -405,3 +831,23 @@ func getTimeStringFromTimeObject(timeObject *time.Time) *string {
 	timeString := timeObject.Format(time.RFC3339)
 	return &timeString
 }
+
+// EncodeCursor wraps an AWS nextToken in an opaque url-safe cursor for resource API consumers
+func EncodeCursor(nextToken *string) string {
+	if nextToken == nil || *nextToken == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(*nextToken))
+}
+
+// DecodeCursor returns the AWS nextToken wrapped by EncodeCursor
+func DecodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	token, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid nextToken")
+	}
+	return string(token), nil
+}
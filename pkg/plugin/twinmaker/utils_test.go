@@ -1,11 +1,16 @@
 package twinmaker
 
 import (
+	"context"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,9 +21,85 @@ func TestLoadPolicy(t *testing.T) {
 		WorkspaceId: aws.String("dummyWorkspaceId"),
 	}
 
-	policy, err := LoadPolicy(workspace)
+	policy, err := LoadPolicy(workspace, "")
 	require.NoError(t, err)
 	require.NotEmpty(t, policy)
+	// uploads and alarm actions go through the backend writer, never the dashboard token
+	require.Contains(t, policy, `"Action":["s3:GetObject"]`)
+	require.NotContains(t, policy, "s3:PutObject")
+	require.NotContains(t, policy, "kms:")
+	require.NotContains(t, policy, "iotevents:")
+
+	t.Run("KMS encrypted bucket", func(t *testing.T) {
+		workspace := &iottwinmaker.GetWorkspaceOutput{
+			S3Location:  aws.String("arn:aws:s3:::bucket"),
+			Arn:         aws.String("arn:aws:iottwinmaker:us-east-1:123456789012:workspace/w"),
+			WorkspaceId: aws.String("w"),
+		}
+		policy, err := LoadPolicy(workspace, "1234abcd-12ab-34cd-56ef-1234567890ab")
+		require.NoError(t, err)
+		require.Contains(t, policy, `"Action":["kms:Decrypt"],"Resource":["arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"]`)
+		require.Contains(t, policy, `"kms:EncryptionContext:aws:s3:arn":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"]`)
+
+		policy, err = LoadPolicy(workspace, "alias/workspace")
+		require.NoError(t, err)
+		require.Contains(t, policy, `"Action":["kms:Decrypt"],"Resource":["arn:aws:kms:us-east-1:123456789012:key/*"]`)
+	})
+}
+
+func TestLoadPolicyPartition(t *testing.T) {
+	workspace := &iottwinmaker.GetWorkspaceOutput{
+		S3Location:  aws.String("arn:aws-us-gov:s3:::bucket"),
+		Arn:         aws.String("arn:aws-us-gov:iottwinmaker:us-gov-west-1:123456789012:workspace/w"),
+		WorkspaceId: aws.String("w"),
+	}
+	policy, err := LoadPolicy(workspace, "1234abcd-12ab-34cd-56ef-1234567890ab")
+	require.NoError(t, err)
+	require.Contains(t, policy, `"Resource":["arn:aws-us-gov:kms:us-gov-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"]`)
+	require.Contains(t, policy, `"Resource":["arn:aws-us-gov:s3:::bucket","arn:aws-us-gov:s3:::bucket/*"]`)
+	require.NotContains(t, policy, "arn:aws:")
+
+	// without ARNs the partition of the configured region is used
+	workspace = &iottwinmaker.GetWorkspaceOutput{
+		S3Location:  aws.String("s3://bucket"),
+		WorkspaceId: aws.String("w"),
+	}
+	policy, err = loadPolicy(workspace, "cn-north-1", "alias/workspace", false)
+	require.NoError(t, err)
+	require.Contains(t, policy, `"Resource":["arn:aws-cn:kms:*:*:key/*"]`)
+	require.Contains(t, policy, `"Resource":["arn:aws-cn:s3:::bucket","arn:aws-cn:s3:::bucket/*"]`)
+
+	policy, err = LoadPolicy(workspace, "")
+	require.NoError(t, err)
+	require.Contains(t, policy, `"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"]`)
+}
+
+func TestLoadViewerPolicy(t *testing.T) {
+	workspace := &iottwinmaker.GetWorkspaceOutput{
+		S3Location:  aws.String("arn:aws:s3:::bucket"),
+		Arn:         aws.String("arn:aws:iottwinmaker:us-east-1:123456789012:workspace/w"),
+		WorkspaceId: aws.String("w"),
+	}
+	policy, err := LoadViewerPolicy(workspace, "")
+	require.NoError(t, err)
+	require.Contains(t, policy, `"Action":["s3:GetObject"]`)
+	require.Contains(t, policy, "iottwinmaker:Get*")
+	require.NotContains(t, policy, "iotsitewise:BatchPutAssetPropertyValue")
+
+	primary, err := LoadPolicy(workspace, "")
+	require.NoError(t, err)
+	require.Contains(t, primary, "iotsitewise:BatchPutAssetPropertyValue")
+}
+
+func TestKMSError(t *testing.T) {
+	denied := awserr.New("AccessDenied", "User: arn:aws:sts::123456789012:assumed-role/r/grafana is not authorized to perform: kms:Decrypt", nil)
+	err := kmsError(denied, "bucket")
+	require.ErrorIs(t, err, denied)
+	require.Contains(t, err.Error(), "bucket bucket is encrypted with a KMS key")
+
+	other := awserr.New("AccessDenied", "Access Denied", nil)
+	require.Equal(t, other, kmsError(other, "bucket"))
+	require.NoError(t, kmsError(nil, "bucket"))
 }
 
 func TestGetTimeObjectFromStringTime(t *testing.T) {
@@ -93,3 +174,296 @@ func TestGetTimeStringFromTimeObject(t *testing.T) {
 		require.Equal(t, "2022-04-27T00:00:00Z", *getTimeStringFromTimeObject(&timeObject))
 	})
 }
+
+func TestCursor(t *testing.T) {
+	t.Run("round trips an AWS nextToken", func(t *testing.T) {
+		token := "AYADeH+/4ZK3fZ==/x?&"
+		cursor := EncodeCursor(&token)
+		require.NotContains(t, cursor, "/")
+		require.NotContains(t, cursor, "+")
+		decoded, err := DecodeCursor(cursor)
+		require.NoError(t, err)
+		require.Equal(t, token, decoded)
+	})
+
+	t.Run("empty token means no more pages", func(t *testing.T) {
+		require.Equal(t, "", EncodeCursor(nil))
+		decoded, err := DecodeCursor("")
+		require.NoError(t, err)
+		require.Equal(t, "", decoded)
+	})
+
+	t.Run("rejects invalid cursors", func(t *testing.T) {
+		_, err := DecodeCursor("not a cursor!")
+		require.Error(t, err)
+	})
+}
+
+func TestNextHistoryPageSize(t *testing.T) {
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	page := func(start time.Time, step time.Duration, n int) *iottwinmaker.GetPropertyValueHistoryOutput {
+		values := make([]*iottwinmaker.PropertyValue, n)
+		for i := range values {
+			values[i] = &iottwinmaker.PropertyValue{
+				Time: getTimeStringFromTimeObject(aws.Time(start.Add(time.Duration(i) * step))),
+			}
+		}
+		return &iottwinmaker.GetPropertyValueHistoryOutput{
+			PropertyValues: []*iottwinmaker.PropertyValueHistory{{Values: values}},
+		}
+	}
+	query := models.TwinMakerQuery{
+		TimeRange: backend.TimeRange{From: from, To: from.Add(24 * time.Hour)},
+	}
+
+	t.Run("dense data uses full pages", func(t *testing.T) {
+		require.Equal(t, maxHistoryPageSize, nextHistoryPageSize(page(from, time.Second, 100), query))
+	})
+
+	t.Run("sparse data shrinks the page to the remaining range", func(t *testing.T) {
+		// 20 hourly values cover 19h, leaving ~5 values in the range
+		require.Equal(t, minHistoryPageSize, nextHistoryPageSize(page(from, time.Hour, 20), query))
+	})
+
+	t.Run("medium density is proportional", func(t *testing.T) {
+		// one value per minute, 2h covered leaves 22h => ~1320 values, capped
+		require.Equal(t, maxHistoryPageSize, nextHistoryPageSize(page(from, time.Minute, 121), query))
+		// one value per 10 minutes, 20h covered leaves 4h => 24 values + headroom
+		require.Equal(t, 31, nextHistoryPageSize(page(from, 10*time.Minute, 121), query))
+	})
+
+	t.Run("descending pages estimate towards the range start", func(t *testing.T) {
+		q := query
+		q.Order = models.ResultOrderDesc
+		require.Equal(t, 31, nextHistoryPageSize(page(from.Add(4*time.Hour), 10*time.Minute, 121), q))
+	})
+
+	t.Run("unknown density falls back to full pages", func(t *testing.T) {
+		require.Equal(t, maxHistoryPageSize, nextHistoryPageSize(page(from, time.Second, 1), query))
+	})
+}
+
+func TestGetEntityPropertyReferenceKey(t *testing.T) {
+	defs := map[string]*iottwinmaker.PropertyDefinitionResponse{
+		"alarm_key": {IsExternalId: aws.Bool(true)},
+		"other":     {IsExternalId: aws.Bool(false)},
+	}
+
+	t.Run("ids containing the separator do not collide", func(t *testing.T) {
+		a := GetEntityPropertyReferenceKey(&iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String("Mixer_1"),
+			ComponentName: aws.String("Alarm"),
+			PropertyName:  aws.String("alarm_status"),
+		}, defs)
+		b := GetEntityPropertyReferenceKey(&iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String("Mixer"),
+			ComponentName: aws.String("1_Alarm"),
+			PropertyName:  aws.String("alarm_status"),
+		}, defs)
+		require.NotEqual(t, a, b)
+	})
+
+	t.Run("only externalId properties are part of the key", func(t *testing.T) {
+		a := GetEntityPropertyReferenceKey(&iottwinmaker.EntityPropertyReference{
+			ExternalIdProperty: map[string]*string{"alarm_key": aws.String("a1"), "other": aws.String("x")},
+			PropertyName:       aws.String("alarm_status"),
+		}, defs)
+		b := GetEntityPropertyReferenceKey(&iottwinmaker.EntityPropertyReference{
+			ExternalIdProperty: map[string]*string{"alarm_key": aws.String("a1"), "other": aws.String("y")},
+			PropertyName:       aws.String("alarm_status"),
+		}, defs)
+		require.Equal(t, a, b)
+	})
+
+	t.Run("all externalId properties are part of the key", func(t *testing.T) {
+		defs := map[string]*iottwinmaker.PropertyDefinitionResponse{
+			"site": {IsExternalId: aws.Bool(true)},
+			"tag":  {IsExternalId: aws.Bool(true)},
+		}
+		key := func(site string, tag string) string {
+			return GetEntityPropertyReferenceKey(&iottwinmaker.EntityPropertyReference{
+				ExternalIdProperty: map[string]*string{"site": aws.String(site), "tag": aws.String(tag)},
+				PropertyName:       aws.String("temperature"),
+			}, defs)
+		}
+		require.Equal(t, key("s1", "t1"), key("s1", "t1"))
+		require.NotEqual(t, key("s1", "t1"), key("s1", "t2"))
+		require.NotEqual(t, key("s1", "t1"), key("t1", "s1"))
+		require.Equal(t, `["","","temperature","site","s1","tag","t1"]`, key("s1", "t1"))
+	})
+}
+
+func TestMatchesExternalIds(t *testing.T) {
+	property := func(value string) *iottwinmaker.PropertyResponse {
+		return &iottwinmaker.PropertyResponse{
+			Definition: &iottwinmaker.PropertyDefinitionResponse{IsExternalId: aws.Bool(true)},
+			Value:      &iottwinmaker.DataValue{StringValue: aws.String(value)},
+		}
+	}
+	component := &iottwinmaker.ComponentResponse{Properties: map[string]*iottwinmaker.PropertyResponse{
+		"site": property("s1"),
+		"tag":  property("t1"),
+	}}
+
+	require.True(t, matchesExternalIds(component, []string{"site", "s1", "tag", "t1"}))
+	require.True(t, matchesExternalIds(component, []string{"tag", "t1"}))
+	require.False(t, matchesExternalIds(component, []string{"site", "s1", "tag", "t2"}))
+	require.False(t, matchesExternalIds(component, []string{"line", "l1"}))
+	require.False(t, matchesExternalIds(component, nil))
+}
+
+func TestMergePropertyReferences(t *testing.T) {
+	ref := func(entityId string, componentName string, externalId string) *iottwinmaker.EntityPropertyReference {
+		return &iottwinmaker.EntityPropertyReference{
+			EntityId:           aws.String(entityId),
+			ComponentName:      aws.String(componentName),
+			PropertyName:       aws.String("alarm_status"),
+			ExternalIdProperty: map[string]*string{"alarm_key": aws.String(externalId)},
+		}
+	}
+	value := func(ts string) *iottwinmaker.PropertyValue {
+		return &iottwinmaker.PropertyValue{Time: aws.String(ts)}
+	}
+
+	merged := mergePropertyReferences([]PropertyReference{
+		{entityPropertyReference: ref("Mixer_1", "AlarmComponent", "a1"), values: []*iottwinmaker.PropertyValue{value("2022-04-27T10:00:00Z")}},
+		{entityPropertyReference: ref("Mixer_2", "AlarmComponent", "a2"), values: []*iottwinmaker.PropertyValue{value("2022-04-27T10:00:00Z")}},
+		{entityPropertyReference: ref("Mixer_1", "AlarmComponent", "a1"), values: []*iottwinmaker.PropertyValue{value("2022-04-27T09:00:00Z")}},
+		// unresolved components stay separate per externalId
+		{entityPropertyReference: ref("Mixer_3", "", "a3"), values: []*iottwinmaker.PropertyValue{value("2022-04-27T10:00:00Z")}},
+		{entityPropertyReference: ref("Mixer_3", "", "a4"), values: []*iottwinmaker.PropertyValue{value("2022-04-27T10:00:00Z")}},
+	}, models.ResultOrderAsc)
+
+	require.Len(t, merged, 4)
+	require.Equal(t, "Mixer_1", *merged[0].entityPropertyReference.EntityId)
+	require.Len(t, merged[0].values, 2)
+	require.Equal(t, "2022-04-27T09:00:00Z", *merged[0].values[0].Time)
+	require.Equal(t, "Mixer_2", *merged[1].entityPropertyReference.EntityId)
+}
+
+func TestCacheTTLHint(t *testing.T) {
+	from := time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)
+	values := func(step time.Duration, n int) []*iottwinmaker.PropertyValue {
+		values := make([]*iottwinmaker.PropertyValue, n)
+		for i := range values {
+			values[i] = &iottwinmaker.PropertyValue{
+				Time: getTimeStringFromTimeObject(aws.Time(from.Add(time.Duration(i) * step))),
+			}
+		}
+		return values
+	}
+
+	require.Equal(t, time.Minute, cacheTTLHint(values(5*time.Minute, 10)))
+	require.Equal(t, minCacheTTL, cacheTTLHint(values(time.Second, 10)))
+	require.Equal(t, maxCacheTTL, cacheTTLHint(values(24*time.Hour, 10)))
+	require.Equal(t, time.Duration(0), cacheTTLHint(values(time.Minute, 1)))
+
+	// the median ignores a single gap in the data
+	gap := values(5*time.Minute, 10)
+	gap[9].Time = getTimeStringFromTimeObject(aws.Time(from.Add(12 * time.Hour)))
+	require.Equal(t, time.Minute, cacheTTLHint(gap))
+}
+
+// latestValuesMockClient pages the values of a frequent and a sparse property in descending order
+type latestValuesMockClient struct {
+	*twinMakerMockClient
+	pages int
+	// maxResults of each call
+	maxResults []int
+}
+
+func (c *latestValuesMockClient) GetPropertyValueHistory(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueHistoryOutput, error) {
+	page := 0
+	if query.NextToken != "" {
+		page, _ = strconv.Atoi(query.NextToken)
+	}
+	c.pages++
+	c.maxResults = append(c.maxResults, query.MaxResults)
+	history := func(name string, n int) *iottwinmaker.PropertyValueHistory {
+		h := &iottwinmaker.PropertyValueHistory{EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String("Mixer_0"),
+			ComponentName: aws.String("MixerComponent"),
+			PropertyName:  aws.String(name),
+		}}
+		for i := 0; i < n; i++ {
+			h.Values = append(h.Values, &iottwinmaker.PropertyValue{
+				Time:  aws.String(time.Date(2022, 4, 27, 10, 0, 0, 0, time.UTC).Add(-time.Duration(page*n+i) * time.Minute).Format(time.RFC3339)),
+				Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(float64(page*n + i))},
+			})
+		}
+		return h
+	}
+	rsp := &iottwinmaker.GetPropertyValueHistoryOutput{PropertyValues: []*iottwinmaker.PropertyValueHistory{history("RPM", 3)}}
+	if page == 2 {
+		rsp.PropertyValues = append(rsp.PropertyValues, history("Temperature", 1))
+	}
+	if page < 5 {
+		rsp.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return rsp, nil
+}
+
+func TestGetPropertyValueHistoryLimited(t *testing.T) {
+	client := &latestValuesMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("RPM"), aws.String("Temperature")},
+		Order:         models.ResultOrderDesc,
+		Limit:         4,
+	}
+
+	// pages until the sparse property has values, the frequent one keeps its latest 4
+	result, err := handler.GetPropertyValueHistoryLimited(context.Background(), query)
+	require.NoError(t, err)
+	require.Nil(t, result.NextToken)
+	require.Equal(t, 6, client.pages)
+	require.Len(t, result.PropertyValues, 2)
+	require.Len(t, result.PropertyValues[0].Values, 4)
+	require.Equal(t, "2022-04-27T10:00:00Z", *result.PropertyValues[0].Values[0].Time)
+	require.Equal(t, "2022-04-27T09:57:00Z", *result.PropertyValues[0].Values[3].Time)
+	require.Len(t, result.PropertyValues[1].Values, 1)
+
+	// stops as soon as every property has its values
+	client.pages = 0
+	query.Properties = query.Properties[:1]
+	result, err = handler.GetPropertyValueHistoryLimited(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, 2, client.pages)
+	require.Len(t, result.PropertyValues[0].Values, 4)
+
+	// the entity history has a frame per property and no nextToken
+	dr := handler.GetEntityHistory(context.Background(), query)
+	require.NoError(t, dr.Error)
+	require.Len(t, dr.Frames, 1)
+	require.Equal(t, 4, dr.Frames[0].Rows())
+	require.Empty(t, dr.Frames[0].Meta.Custom.(models.TwinMakerCustomMeta).NextToken)
+}
+
+func TestPageSize(t *testing.T) {
+	require.Equal(t, 0, pageSize(models.TwinMakerQuery{}, maxHistoryPageSize))
+	require.Equal(t, 50, pageSize(models.TwinMakerQuery{PageSize: 50}, maxHistoryPageSize))
+	require.Equal(t, maxListEntitiesPageSize, pageSize(models.TwinMakerQuery{PageSize: 1000}, maxListEntitiesPageSize))
+
+	query := models.TwinMakerQuery{
+		EntityId:      "Mixer_0",
+		ComponentName: "MixerComponent",
+		Properties:    []*string{aws.String("RPM")},
+		TimeRange:     backend.TimeRange{From: time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC), To: time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC)},
+	}
+	client := &latestValuesMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler := newTwinMakerHandler(client, nil)
+	_, err := handler.GetPropertyValueHistoryPaginated(context.Background(), query, nil)
+	require.NoError(t, err)
+	// the page size adapts to the data after the first page
+	require.NotEqual(t, 0, client.maxResults[1])
+
+	// a query page size is used for every page
+	client = &latestValuesMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	handler = newTwinMakerHandler(client, nil)
+	query.PageSize = 100
+	_, err = handler.GetPropertyValueHistoryPaginated(context.Background(), query, nil)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 0, 0, 0, 0, 0}, client.maxResults)
+}
@@ -0,0 +1,146 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// ListVariableOptions lists the values of a chained template variable, sorted by text:
+//   - entities of the workspace, only those with a component of ComponentTypeId when it is set
+//   - components of EntityId, only those of ComponentTypeId when it is set
+//   - properties of the EntityId component ComponentName, or of the component type ComponentTypeId
+//   - component types of the workspace
+func (r *twinMakerResource) ListVariableOptions(ctx context.Context, kind models.VariableKind, parents models.VariableParents) ([]models.VariableOption, error) {
+	query := models.TwinMakerQuery{
+		WorkspaceId: parents.WorkspaceId,
+	}
+	if query.WorkspaceId == "" {
+		query.WorkspaceId = r.workspaceId
+	}
+
+	var options []models.VariableOption
+	var err error
+	switch kind {
+	case models.VariableEntities:
+		query.ComponentTypeId = parents.ComponentTypeId
+		options, err = r.entityVariableOptions(ctx, query)
+	case models.VariableComponents:
+		if parents.EntityId == "" {
+			return nil, fmt.Errorf("missing entityId")
+		}
+		query.EntityId = parents.EntityId
+		options, err = r.componentVariableOptions(ctx, query, parents.ComponentTypeId)
+	case models.VariableProperties:
+		options, err = r.propertyVariableOptions(ctx, query, parents)
+	case models.VariableComponentTypes:
+		options, err = r.componentTypeVariableOptions(ctx, query)
+	default:
+		return nil, fmt.Errorf("unknown variable kind %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].Text < options[j].Text
+	})
+	return options, nil
+}
+
+func (r *twinMakerResource) entityVariableOptions(ctx context.Context, query models.TwinMakerQuery) ([]models.VariableOption, error) {
+	options := []models.VariableOption{}
+	for {
+		rsp, err := r.client.ListEntities(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range rsp.EntitySummaries {
+			if e.EntityId == nil {
+				continue
+			}
+			text := *e.EntityId
+			if e.EntityName != nil {
+				text = *e.EntityName
+			}
+			options = append(options, models.VariableOption{Text: text, Value: *e.EntityId})
+		}
+		if rsp.NextToken == nil {
+			return options, nil
+		}
+		query.NextToken = *rsp.NextToken
+	}
+}
+
+func (r *twinMakerResource) componentVariableOptions(ctx context.Context, query models.TwinMakerQuery, componentTypeId string) ([]models.VariableOption, error) {
+	entity, err := r.client.GetEntity(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	options := []models.VariableOption{}
+	for name, c := range entity.Components {
+		if c == nil || (componentTypeId != "" && aws.StringValue(c.ComponentTypeId) != componentTypeId) {
+			continue
+		}
+		options = append(options, models.VariableOption{Text: name, Value: name})
+	}
+	return options, nil
+}
+
+func (r *twinMakerResource) propertyVariableOptions(ctx context.Context, query models.TwinMakerQuery, parents models.VariableParents) ([]models.VariableOption, error) {
+	options := []models.VariableOption{}
+	switch {
+	case parents.EntityId != "" && parents.ComponentName != "":
+		query.EntityId = parents.EntityId
+		entity, err := r.client.GetEntity(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		component := entity.Components[parents.ComponentName]
+		if component == nil {
+			return nil, fmt.Errorf("component %s not found on entity %s", parents.ComponentName, parents.EntityId)
+		}
+		for name := range component.Properties {
+			options = append(options, models.VariableOption{Text: name, Value: name})
+		}
+	case parents.ComponentTypeId != "":
+		query.ComponentTypeId = parents.ComponentTypeId
+		componentType, err := r.client.GetComponentType(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for name := range componentType.PropertyDefinitions {
+			options = append(options, models.VariableOption{Text: name, Value: name})
+		}
+	default:
+		return nil, fmt.Errorf("properties need entityId and componentName, or componentTypeId")
+	}
+	return options, nil
+}
+
+func (r *twinMakerResource) componentTypeVariableOptions(ctx context.Context, query models.TwinMakerQuery) ([]models.VariableOption, error) {
+	options := []models.VariableOption{}
+	for {
+		rsp, err := r.client.ListComponentTypes(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, ct := range rsp.ComponentTypeSummaries {
+			if ct.ComponentTypeId == nil {
+				continue
+			}
+			text := *ct.ComponentTypeId
+			if ct.ComponentTypeName != nil && *ct.ComponentTypeName != "" {
+				text = *ct.ComponentTypeName
+			}
+			options = append(options, models.VariableOption{Text: text, Value: *ct.ComponentTypeId})
+		}
+		if rsp.NextToken == nil {
+			return options, nil
+		}
+		query.NextToken = *rsp.NextToken
+	}
+}
@@ -0,0 +1,40 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListVariableOptions(t *testing.T) {
+	r := newTwinMakerResource(&alarmExportMockClient{twinMakerMockClient: &twinMakerMockClient{}}, "w", nil)
+	ctx := context.Background()
+
+	entities, err := r.ListVariableOptions(ctx, models.VariableEntities, models.VariableParents{})
+	require.NoError(t, err)
+	require.Equal(t, []models.VariableOption{{Text: "Mixer 0", Value: "Mixer_0"}}, entities)
+
+	parents := models.VariableParents{EntityId: "Mixer_0"}
+	components, err := r.ListVariableOptions(ctx, models.VariableComponents, parents)
+	require.NoError(t, err)
+	require.Equal(t, []models.VariableOption{{Text: "TemperatureAlarm", Value: "TemperatureAlarm"}}, components)
+
+	parents.ComponentTypeId = "com.example.mixer"
+	components, err = r.ListVariableOptions(ctx, models.VariableComponents, parents)
+	require.NoError(t, err)
+	require.Empty(t, components)
+
+	parents = models.VariableParents{EntityId: "Mixer_0", ComponentName: "TemperatureAlarm"}
+	properties, err := r.ListVariableOptions(ctx, models.VariableProperties, parents)
+	require.NoError(t, err)
+	require.Equal(t, []models.VariableOption{{Text: alarmExternalIdKey, Value: alarmExternalIdKey}}, properties)
+
+	_, err = r.ListVariableOptions(ctx, models.VariableProperties, models.VariableParents{EntityId: "Mixer_0"})
+	require.Error(t, err)
+	_, err = r.ListVariableOptions(ctx, models.VariableComponents, models.VariableParents{})
+	require.Error(t, err)
+	_, err = r.ListVariableOptions(ctx, "scenes", models.VariableParents{})
+	require.Error(t, err)
+}
@@ -0,0 +1,34 @@
+package twinmaker
+
+import (
+	"context"
+)
+
+type viewerKey struct{}
+
+// WithViewerRole marks the request of an anonymous user, e.g. a kiosk display. Its queries and
+// session tokens use the viewer role when one is configured.
+func WithViewerRole(ctx context.Context) context.Context {
+	return context.WithValue(ctx, viewerKey{}, true)
+}
+
+func usesViewerRole(ctx context.Context) bool {
+	v, _ := ctx.Value(viewerKey{}).(bool)
+	return v
+}
+
+// SetViewerClient adds the viewer role handler and resources, they have their own caches so
+// results of the two roles are not mixed
+func (ds *Datasource) SetViewerClient(c TwinMakerClient) {
+	ds.Viewer = newRoleHandler(NewCachingClient(c, DefaultCacheTTL), ds.Settings, ds.redaction)
+	ds.viewerClient = c
+}
+
+// HandlerFor is the handler of the request role, the primary handler unless the request was
+// marked with WithViewerRole and a viewer role is configured
+func (ds *Datasource) HandlerFor(ctx context.Context) TwinMakerHandler {
+	if ds.Viewer != nil && usesViewerRole(ctx) {
+		return ds.Viewer
+	}
+	return ds.Handler
+}
@@ -0,0 +1,83 @@
+package twinmaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// bounds of the metadata read when the cache is warmed, large workspaces load the rest on demand
+	maxWarmComponentTypes = 100
+	maxWarmEntities       = 100
+	warmCacheTimeout      = 2 * time.Minute
+)
+
+// rootEntityId is the parent of the top level entities of a workspace
+const rootEntityId = "$ROOT"
+
+// WarmCache loads the workspace, its component types and top level entities into the caches, so
+// the first dashboard after the datasource is saved does not wait for a burst of cold lookups.
+// Failures are logged, the data is then loaded on demand as without warming.
+func (ds *Datasource) WarmCache(ctx context.Context) {
+	if ds.Settings.WorkspaceID == "" || ds.cached == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, warmCacheTimeout)
+	defer cancel()
+	start := time.Now()
+	logger := log.DefaultLogger.With("workspaceId", ds.Settings.WorkspaceID)
+
+	// the selectable values of the query editor are cached as a whole
+	if _, err := ds.Resources.ListWorkspaces(ctx); err != nil {
+		logger.Warn("cache warming stopped", "error", err)
+		return
+	}
+	if _, err := ds.Resources.ListOptions(ctx); err != nil {
+		logger.Warn("cache warming stopped", "error", err)
+		return
+	}
+
+	query := models.TwinMakerQuery{WorkspaceId: ds.Settings.WorkspaceID}
+	if _, err := ds.cached.GetWorkspace(ctx, query); err != nil {
+		logger.Warn("cache warming stopped", "error", err)
+		return
+	}
+
+	componentTypes := 0
+	if rsp, err := ds.cached.ListComponentTypes(ctx, query); err == nil {
+		for _, ct := range rsp.ComponentTypeSummaries {
+			if ct.ComponentTypeId == nil || componentTypes >= maxWarmComponentTypes {
+				continue
+			}
+			q := query
+			q.ComponentTypeId = *ct.ComponentTypeId
+			if _, err := ds.cached.GetComponentType(ctx, q); err == nil {
+				componentTypes++
+			}
+		}
+	} else {
+		logger.Warn("component types not warmed", "error", err)
+	}
+
+	entities := 0
+	if rsp, err := ds.cached.ListEntities(ctx, query); err == nil {
+		for _, e := range rsp.EntitySummaries {
+			if e.EntityId == nil || aws.StringValue(e.ParentEntityId) != rootEntityId || entities >= maxWarmEntities {
+				continue
+			}
+			q := query
+			q.EntityId = *e.EntityId
+			if _, err := ds.cached.GetEntity(ctx, q); err == nil {
+				entities++
+			}
+		}
+	} else {
+		logger.Warn("entities not warmed", "error", err)
+	}
+
+	logger.Debug("cache warmed", "componentTypes", componentTypes, "entities", entities, "duration", time.Since(start))
+}
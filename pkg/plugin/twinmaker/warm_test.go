@@ -0,0 +1,66 @@
+package twinmaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type warmMockClient struct {
+	*twinMakerMockClient
+	entities       []string
+	componentTypes []string
+}
+
+func (c *warmMockClient) ListWorkspaces(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListWorkspacesOutput, error) {
+	return &iottwinmaker.ListWorkspacesOutput{WorkspaceSummaries: []*iottwinmaker.WorkspaceSummary{{WorkspaceId: aws.String("AlarmWorkspace")}}}, nil
+}
+
+func (c *warmMockClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
+	return &iottwinmaker.GetWorkspaceOutput{WorkspaceId: aws.String(query.WorkspaceId)}, nil
+}
+
+func (c *warmMockClient) ListComponentTypes(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListComponentTypesOutput, error) {
+	return &iottwinmaker.ListComponentTypesOutput{ComponentTypeSummaries: []*iottwinmaker.ComponentTypeSummary{
+		{ComponentTypeId: aws.String("com.example.alarm")},
+		{ComponentTypeId: aws.String("com.example.mixer")},
+	}}, nil
+}
+
+func (c *warmMockClient) ListEntities(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.ListEntitiesOutput, error) {
+	return &iottwinmaker.ListEntitiesOutput{EntitySummaries: []*iottwinmaker.EntitySummary{
+		{EntityId: aws.String("Factory"), ParentEntityId: aws.String(rootEntityId)},
+		{EntityId: aws.String("Mixer_0"), ParentEntityId: aws.String("Factory")},
+		{EntityId: aws.String("Warehouse"), ParentEntityId: aws.String(rootEntityId)},
+	}}, nil
+}
+
+func (c *warmMockClient) GetComponentType(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetComponentTypeOutput, error) {
+	c.componentTypes = append(c.componentTypes, query.ComponentTypeId)
+	return &iottwinmaker.GetComponentTypeOutput{ComponentTypeId: aws.String(query.ComponentTypeId), IsAbstract: aws.Bool(false)}, nil
+}
+
+func (c *warmMockClient) GetEntity(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetEntityOutput, error) {
+	c.entities = append(c.entities, query.EntityId)
+	return &iottwinmaker.GetEntityOutput{EntityId: aws.String(query.EntityId)}, nil
+}
+
+func TestWarmCache(t *testing.T) {
+	client := &warmMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+
+	ds.WarmCache(context.Background())
+	// read for the editor options and for the queries, which are cached apart
+	require.Equal(t, []string{"com.example.alarm", "com.example.mixer", "com.example.alarm", "com.example.mixer"}, client.componentTypes)
+	// only the top level entities
+	require.Equal(t, []string{"Factory", "Warehouse"}, client.entities)
+
+	// later reads are served from the caches
+	ds.WarmCache(context.Background())
+	require.Len(t, client.componentTypes, 4)
+	require.Len(t, client.entities, 2)
+}
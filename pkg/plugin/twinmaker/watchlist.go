@@ -0,0 +1,242 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// DefaultWatchlistInterval is how often the watchlist properties are polled
+const DefaultWatchlistInterval = time.Minute
+
+const maxWatchlistItems = 200
+
+// Watchlist polls a set of entity properties in the background so a single query can return
+// the latest value of all of them. The items only live in memory and are reset when the
+// datasource instance is recreated.
+type Watchlist struct {
+	client      TwinMakerClient
+	workspaceId string
+	interval    time.Duration
+	wake        chan struct{}
+	redaction   *redactor
+
+	mu     sync.RWMutex
+	items  []models.WatchlistItem
+	values map[models.WatchlistItem]*iottwinmaker.DataValue
+	errors map[models.WatchlistItem]string
+	polled time.Time
+}
+
+// NewWatchlist creates an empty watchlist, the client should not be cached
+func NewWatchlist(client TwinMakerClient, workspaceId string, interval time.Duration) *Watchlist {
+	return &Watchlist{
+		client:      client,
+		workspaceId: workspaceId,
+		interval:    interval,
+		wake:        make(chan struct{}, 1),
+		items:       []models.WatchlistItem{},
+		values:      map[models.WatchlistItem]*iottwinmaker.DataValue{},
+		errors:      map[models.WatchlistItem]string{},
+	}
+}
+
+func (w *Watchlist) Items() []models.WatchlistItem {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]models.WatchlistItem{}, w.items...)
+}
+
+// SetItems replaces the watched properties, duplicates are dropped. The next poll runs right away.
+func (w *Watchlist) SetItems(items []models.WatchlistItem) error {
+	if len(items) > maxWatchlistItems {
+		return fmt.Errorf("watchlist is limited to %d items", maxWatchlistItems)
+	}
+
+	seen := map[models.WatchlistItem]bool{}
+	unique := []models.WatchlistItem{}
+	for _, item := range items {
+		if item.EntityId == "" || item.ComponentName == "" || item.PropertyName == "" {
+			return fmt.Errorf("watchlist items require entityId, componentName and propertyName")
+		}
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+
+	w.mu.Lock()
+	w.items = unique
+	w.values = map[models.WatchlistItem]*iottwinmaker.DataValue{}
+	w.errors = map[models.WatchlistItem]string{}
+	w.polled = time.Time{}
+	w.mu.Unlock()
+
+	w.Refresh()
+	return nil
+}
+
+// Refresh makes Run poll right away instead of waiting for the interval, e.g. after a write
+func (w *Watchlist) Refresh() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls the watchlist on the interval until the context is cancelled
+func (w *Watchlist) Run(ctx context.Context) {
+	ctx = WithFeature(ctx, "watchlist")
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+		w.Poll(ctx)
+	}
+}
+
+// Poll fetches the latest values, with one GetPropertyValue call per entity component
+func (w *Watchlist) Poll(ctx context.Context) {
+	items := w.Items()
+	if len(items) == 0 {
+		return
+	}
+
+	type component struct {
+		entityId      string
+		componentName string
+	}
+	groups := map[component][]*string{}
+	order := []component{}
+	for _, item := range items {
+		c := component{item.EntityId, item.ComponentName}
+		if _, ok := groups[c]; !ok {
+			order = append(order, c)
+		}
+		groups[c] = append(groups[c], aws.String(item.PropertyName))
+	}
+
+	values := map[models.WatchlistItem]*iottwinmaker.DataValue{}
+	errors := map[models.WatchlistItem]string{}
+	// the components are read at once, the client adapts how many calls run at the same time
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range order {
+		wg.Add(1)
+		go func(c component) {
+			defer wg.Done()
+			rsp, err := w.client.GetPropertyValue(ctx, models.TwinMakerQuery{
+				WorkspaceId:   w.workspaceId,
+				EntityId:      c.entityId,
+				ComponentName: c.componentName,
+				Properties:    groups[c],
+			})
+			if err != nil {
+				log.DefaultLogger.Debug("watchlist poll failed", "entityId", c.entityId, "componentName", c.componentName, "error", err)
+			}
+
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			for _, p := range groups[c] {
+				item := models.WatchlistItem{EntityId: c.entityId, ComponentName: c.componentName, PropertyName: *p}
+				if err != nil {
+					errors[item] = err.Error()
+					continue
+				}
+				if rsp == nil || rsp.PropertyValues[*p] == nil || rsp.PropertyValues[*p].PropertyValue == nil {
+					errors[item] = "no value"
+					continue
+				}
+				values[item] = rsp.PropertyValues[*p].PropertyValue
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// skip the results if the items were replaced while polling
+	if len(w.items) != len(items) {
+		return
+	}
+	for i := range items {
+		if w.items[i] != items[i] {
+			return
+		}
+	}
+	w.values = values
+	w.errors = errors
+	w.polled = time.Now()
+}
+
+// Query returns the latest values as a single row frame with one field per watched property
+func (w *Watchlist) Query(ctx context.Context) (dr backend.DataResponse) {
+	w.mu.RLock()
+	polled := w.polled
+	w.mu.RUnlock()
+
+	// not polled yet, e.g. when the watchlist is not running in the background
+	if polled.IsZero() {
+		w.Poll(ctx)
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	fields := newTwinMakerFrameBuilder(1)
+	t := fields.Time()
+	if !w.polled.IsZero() {
+		t.Set(0, &w.polled)
+	}
+	frame := fields.ToFrame("watchlist", nil)
+
+	failures := []data.Notice{}
+	for _, item := range w.items {
+		v, ok := w.values[item]
+		if !ok {
+			if msg, ok := w.errors[item]; ok {
+				failures = append(failures, data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     fmt.Sprintf("%s/%s/%s: %s", item.EntityId, item.ComponentName, item.PropertyName, msg),
+				})
+			}
+			continue
+		}
+		v, ok = w.redaction.value(item.PropertyName, v)
+		if !ok {
+			continue
+		}
+		f, appender, err := newDataValueField(v, 1)
+		if err != nil {
+			failures = append(failures, dataValueNotice(item.PropertyName, err))
+			continue
+		}
+		_ = appender.Set(0, v)
+		f.Name = item.PropertyName
+		f.Labels = data.Labels{
+			"entityId":      item.EntityId,
+			"componentName": item.ComponentName,
+			"propertyName":  item.PropertyName,
+		}
+		frame.Fields = append(frame.Fields, f)
+	}
+	if len(failures) > 0 {
+		frame.Meta.Notices = failures
+	}
+	dr.Frames = append(dr.Frames, frame)
+	return
+}
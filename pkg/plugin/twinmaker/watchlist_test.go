@@ -0,0 +1,92 @@
+package twinmaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+type propertyValueMockClient struct {
+	*twinMakerMockClient
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *propertyValueMockClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	if query.EntityId == "Missing" {
+		return nil, fmt.Errorf("entity not found")
+	}
+	rsp := &iottwinmaker.GetPropertyValueOutput{PropertyValues: map[string]*iottwinmaker.PropertyLatestValue{}}
+	for _, p := range query.Properties {
+		rsp.PropertyValues[*p] = &iottwinmaker.PropertyLatestValue{
+			PropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(query.EntityId),
+				ComponentName: aws.String(query.ComponentName),
+				PropertyName:  p,
+			},
+			PropertyValue: &iottwinmaker.DataValue{DoubleValue: aws.Float64(float64(len(*p)))},
+		}
+	}
+	return rsp, nil
+}
+
+func TestWatchlist(t *testing.T) {
+	client := &propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	w := NewWatchlist(client, "AlarmWorkspace", time.Minute)
+
+	err := w.SetItems([]models.WatchlistItem{
+		{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "Temperature"},
+		{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "RPM"},
+		{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "RPM"},
+		{EntityId: "Missing", ComponentName: "MixerComponent", PropertyName: "RPM"},
+	})
+	require.NoError(t, err)
+	require.Len(t, w.Items(), 3)
+
+	dr := w.Query(context.Background())
+	require.NoError(t, dr.Error)
+	// one call per entity component
+	require.Equal(t, 2, client.calls)
+
+	frame := dr.Frames[0]
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, 1, frame.Rows())
+	require.Equal(t, "Temperature", frame.Fields[1].Name)
+	require.Equal(t, aws.Float64(11), frame.Fields[1].At(0))
+	require.Equal(t, "RPM", frame.Fields[2].Name)
+	require.Equal(t, data.Labels{"entityId": "Mixer_0", "componentName": "MixerComponent", "propertyName": "RPM"}, frame.Fields[2].Labels)
+	require.Len(t, frame.Meta.Notices, 1)
+	require.Contains(t, frame.Meta.Notices[0].Text, "entity not found")
+
+	// already polled, so the query does not call the client again
+	w.Query(context.Background())
+	require.Equal(t, 2, client.calls)
+
+	err = w.SetItems([]models.WatchlistItem{{EntityId: "Mixer_0"}})
+	require.Error(t, err)
+}
+
+func TestWatchlistQueryViewerRole(t *testing.T) {
+	client := &propertyValueMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "AlarmWorkspace"}, client)
+	require.NoError(t, ds.Watchlist.SetItems([]models.WatchlistItem{
+		{EntityId: "Mixer_0", ComponentName: "MixerComponent", PropertyName: "Temperature"},
+	}))
+	query := models.TwinMakerQuery{QueryType: models.QueryTypeWatchlist}
+	require.NoError(t, ds.Query(context.Background(), query).Error)
+
+	// the values are polled with the primary role
+	dr := ds.Query(WithViewerRole(context.Background()), query)
+	require.EqualError(t, dr.Error, "the watchlist is not available to anonymous users")
+}
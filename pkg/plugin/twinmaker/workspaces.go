@@ -0,0 +1,60 @@
+package twinmaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// maxWorkspaceChecks bounds the workspaces validated at the same time
+const maxWorkspaceChecks = 4
+
+// ValidateWorkspaces checks concurrently that each workspace can be read and that the dashboard
+// (and writer) role can be assumed for it. The checks are in the order of the workspaces.
+func (ds *Datasource) ValidateWorkspaces(ctx context.Context, workspaces []string) []models.WorkspaceCheck {
+	checks := make([]models.WorkspaceCheck, len(workspaces))
+	slots := make(chan struct{}, maxWorkspaceChecks)
+	var wg sync.WaitGroup
+	for i, id := range workspaces {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			checks[i] = ds.validateWorkspace(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+	return checks
+}
+
+func (ds *Datasource) validateWorkspace(ctx context.Context, id string) models.WorkspaceCheck {
+	check := models.WorkspaceCheck{WorkspaceId: id}
+	if _, err := ds.Client.GetWorkspace(ctx, models.TwinMakerQuery{WorkspaceId: id}); err != nil {
+		check.Message = "get workspace: " + workspaceCheckMessage(err)
+		return check
+	}
+	if _, err := ds.Client.GetSessionToken(ctx, time.Hour, id); err != nil {
+		check.Message = "dashboard role: " + workspaceCheckMessage(err)
+		return check
+	}
+	if ds.Settings.AssumeRoleARNWriter != "" {
+		if _, err := ds.Client.GetWriteSessionToken(ctx, time.Hour, id); err != nil {
+			check.Message = "writer role: " + workspaceCheckMessage(err)
+			return check
+		}
+	}
+	check.Ok = true
+	return check
+}
+
+// workspaceCheckMessage is the AWS message without the request details
+func workspaceCheckMessage(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() + ": " + awsErr.Message()
+	}
+	return err.Error()
+}
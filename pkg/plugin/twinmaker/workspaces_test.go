@@ -0,0 +1,56 @@
+package twinmaker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type workspaceMockClient struct {
+	*twinMakerMockClient
+	inFlight, maxInFlight int32
+}
+
+func (c *workspaceMockClient) GetWorkspace(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetWorkspaceOutput, error) {
+	n := atomic.AddInt32(&c.inFlight, 1)
+	defer atomic.AddInt32(&c.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&c.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&c.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return &iottwinmaker.GetWorkspaceOutput{WorkspaceId: aws.String(query.WorkspaceId)}, nil
+}
+
+func (c *workspaceMockClient) GetSessionToken(ctx context.Context, duration time.Duration, workspaceId string) (*sts.Credentials, error) {
+	if workspaceId == "denied" {
+		return nil, awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)
+	}
+	return &sts.Credentials{}, nil
+}
+
+func TestValidateWorkspaces(t *testing.T) {
+	client := &workspaceMockClient{twinMakerMockClient: &twinMakerMockClient{}}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "main"}, client)
+
+	workspaces := []string{"main", "denied", "a", "b", "c", "d", "e", "f"}
+	checks := ds.ValidateWorkspaces(context.Background(), workspaces)
+	require.Len(t, checks, len(workspaces))
+	for i, check := range checks {
+		require.Equal(t, workspaces[i], check.WorkspaceId)
+		require.Equal(t, check.WorkspaceId != "denied", check.Ok)
+	}
+	require.Equal(t, "dashboard role: AccessDenied: not authorized to perform sts:AssumeRole", checks[1].Message)
+	require.LessOrEqual(t, atomic.LoadInt32(&client.maxInFlight), int32(maxWorkspaceChecks))
+	require.Greater(t, atomic.LoadInt32(&client.maxInFlight), int32(1))
+}
@@ -0,0 +1,139 @@
+package twinmaker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+const (
+	// WriteVisibilityTimeout bounds how long AwaitWrites reads the written values back
+	WriteVisibilityTimeout = 5 * time.Second
+	// the written values are read back this often until they are visible
+	writeVisibilityInterval = 500 * time.Millisecond
+)
+
+// InvalidateWrites drops the cached reads that a write to the workspace of the request makes stale,
+// the alarm counts of the workspace and the watchlist values, which are polled again right away
+func (ds *Datasource) InvalidateWrites(ctx context.Context) {
+	// the counts of every role
+	prefix := alarmSummaryKey(ds.workspaceFor(ctx), "")
+	for key := range ds.alarmSummaries.Items() {
+		if strings.HasPrefix(key, prefix) {
+			ds.alarmSummaries.Delete(key)
+		}
+	}
+	ds.Watchlist.Refresh()
+}
+
+// AwaitWrites reads the latest values of the written properties until they return the written
+// values, TwinMaker reads are eventually consistent. It gives up after WriteVisibilityTimeout and
+// returns whether all values became visible. Entries referenced by externalId are not checked.
+func (ds *Datasource) AwaitWrites(ctx context.Context, entries []*iottwinmaker.PropertyValueEntry) bool {
+	pending := writtenValues(entries)
+	if len(pending) == 0 {
+		return true
+	}
+	ctx = WithFeature(ctx, "writeVisibility")
+	ctx, cancel := context.WithTimeout(ctx, WriteVisibilityTimeout)
+	defer cancel()
+	ticker := time.NewTicker(writeVisibilityInterval)
+	defer ticker.Stop()
+
+	type component struct {
+		entityId      string
+		componentName string
+	}
+	for {
+		groups := map[component][]*string{}
+		for item := range pending {
+			c := component{item.EntityId, item.ComponentName}
+			groups[c] = append(groups[c], aws.String(item.PropertyName))
+		}
+		for c, properties := range groups {
+			rsp, err := ds.Client.GetPropertyValue(ctx, models.TwinMakerQuery{
+				WorkspaceId:   ds.workspaceFor(ctx),
+				EntityId:      c.entityId,
+				ComponentName: c.componentName,
+				Properties:    properties,
+			})
+			if err != nil || rsp == nil {
+				continue
+			}
+			for _, p := range properties {
+				item := models.WatchlistItem{EntityId: c.entityId, ComponentName: c.componentName, PropertyName: *p}
+				if latest := rsp.PropertyValues[*p]; latest != nil && reflect.DeepEqual(latest.PropertyValue, pending[item]) {
+					delete(pending, item)
+				}
+			}
+		}
+		if len(pending) == 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// writtenValues is the value each written property should read afterwards, the one with the
+// latest time of its entry
+func writtenValues(entries []*iottwinmaker.PropertyValueEntry) map[models.WatchlistItem]*iottwinmaker.DataValue {
+	values := map[models.WatchlistItem]*iottwinmaker.DataValue{}
+	for _, entry := range entries {
+		ref := entry.EntityPropertyReference
+		if ref == nil || aws.StringValue(ref.EntityId) == "" || aws.StringValue(ref.ComponentName) == "" {
+			continue
+		}
+		var latest *iottwinmaker.PropertyValue
+		var latestTime time.Time
+		for _, v := range entry.PropertyValues {
+			if v == nil || v.Value == nil {
+				continue
+			}
+			t := aws.TimeValue(v.Timestamp)
+			if v.Time != nil {
+				t, _ = time.Parse(time.RFC3339Nano, *v.Time)
+			}
+			if latest == nil || !t.Before(latestTime) {
+				latest, latestTime = v, t
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		item := models.WatchlistItem{
+			EntityId:      *ref.EntityId,
+			ComponentName: *ref.ComponentName,
+			PropertyName:  aws.StringValue(ref.PropertyName),
+		}
+		values[item] = latest.Value
+	}
+	return values
+}
+
+// AlarmStatusEntries are the alarm_status writes of alarms set to status, for AwaitWrites
+func AlarmStatusEntries(alarms []models.AlarmReference, status string) []*iottwinmaker.PropertyValueEntry {
+	entries := []*iottwinmaker.PropertyValueEntry{}
+	for _, alarm := range alarms {
+		entries = append(entries, &iottwinmaker.PropertyValueEntry{
+			EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+				EntityId:      aws.String(alarm.EntityId),
+				ComponentName: aws.String(alarm.ComponentName),
+				PropertyName:  aws.String("alarm_status"),
+			},
+			PropertyValues: []*iottwinmaker.PropertyValue{{
+				Value: &iottwinmaker.DataValue{StringValue: aws.String(status)},
+			}},
+		})
+	}
+	return entries
+}
@@ -0,0 +1,86 @@
+package twinmaker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+// staleReadMockClient returns the old alarm status until it was read staleReads times
+type staleReadMockClient struct {
+	*twinMakerMockClient
+	staleReads int32
+	reads      int32
+}
+
+func (c *staleReadMockClient) GetPropertyValue(ctx context.Context, query models.TwinMakerQuery) (*iottwinmaker.GetPropertyValueOutput, error) {
+	status := "ACKNOWLEDGED"
+	if atomic.AddInt32(&c.reads, 1) <= c.staleReads {
+		status = "ACTIVE"
+	}
+	return &iottwinmaker.GetPropertyValueOutput{PropertyValues: map[string]*iottwinmaker.PropertyLatestValue{
+		"alarm_status": {PropertyValue: &iottwinmaker.DataValue{StringValue: aws.String(status)}},
+	}}, nil
+}
+
+func TestAwaitWrites(t *testing.T) {
+	alarms := []models.AlarmReference{{EntityId: "Mixer_0", ComponentName: "Alarm"}}
+
+	client := &staleReadMockClient{twinMakerMockClient: &twinMakerMockClient{}, staleReads: 1}
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, client)
+	require.True(t, ds.AwaitWrites(context.Background(), AlarmStatusEntries(alarms, "ACKNOWLEDGED")))
+	require.Equal(t, int32(2), client.reads)
+
+	// gives up when the value does not show up
+	client = &staleReadMockClient{twinMakerMockClient: &twinMakerMockClient{}, staleReads: 1000}
+	ds = NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, client)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.False(t, ds.AwaitWrites(ctx, AlarmStatusEntries(alarms, "ACKNOWLEDGED")))
+
+	// externalId references are not read back
+	require.True(t, ds.AwaitWrites(context.Background(), []*iottwinmaker.PropertyValueEntry{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			ExternalIdProperty: map[string]*string{"alarm_id": aws.String("a1")},
+			PropertyName:       aws.String("alarm_status"),
+		},
+		PropertyValues: []*iottwinmaker.PropertyValue{{Value: &iottwinmaker.DataValue{StringValue: aws.String("ACKNOWLEDGED")}}},
+	}}))
+}
+
+func TestWrittenValues(t *testing.T) {
+	values := writtenValues([]*iottwinmaker.PropertyValueEntry{{
+		EntityPropertyReference: &iottwinmaker.EntityPropertyReference{
+			EntityId:      aws.String("Mixer_0"),
+			ComponentName: aws.String("Alarm"),
+			PropertyName:  aws.String("alarm_threshold"),
+		},
+		PropertyValues: []*iottwinmaker.PropertyValue{
+			{Time: aws.String("2022-04-27T10:00:05Z"), Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(2)}},
+			{Time: aws.String("2022-04-27T10:00:00Z"), Value: &iottwinmaker.DataValue{DoubleValue: aws.Float64(1)}},
+		},
+	}})
+	require.Equal(t, map[models.WatchlistItem]*iottwinmaker.DataValue{
+		{EntityId: "Mixer_0", ComponentName: "Alarm", PropertyName: "alarm_threshold"}: {DoubleValue: aws.Float64(2)},
+	}, values)
+}
+
+func TestInvalidateWrites(t *testing.T) {
+	ds := NewDatasourceWithClient(models.TwinMakerDataSourceSetting{WorkspaceID: "w"}, &twinMakerMockClient{})
+	ds.alarmSummaries.SetDefault(alarmSummaryKey("w", ""), models.AlarmSummary{WorkspaceId: "w"})
+	ds.alarmSummaries.SetDefault(alarmSummaryKey("w2", ""), models.AlarmSummary{WorkspaceId: "w2"})
+
+	ds.InvalidateWrites(context.Background())
+	_, ok := ds.alarmSummaries.Get(alarmSummaryKey("w", ""))
+	require.False(t, ok)
+	_, ok = ds.alarmSummaries.Get(alarmSummaryKey("w2", ""))
+	require.True(t, ok)
+	// the watchlist polls on the next run
+	require.Len(t, ds.Watchlist.wake, 1)
+}
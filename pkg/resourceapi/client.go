@@ -0,0 +1,412 @@
+// Package resourceapi is a typed client for the datasource resource API described by
+// pkg/plugin/openapi.json. Requests go through the Grafana datasource resource proxy. Every
+// operation of the document has a method named after its operationId, spec_test.go checks the
+// methods, paths and query parameters against the document.
+package resourceapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iottwinmaker"
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+)
+
+// Client calls the resource API of one TwinMaker datasource
+type Client struct {
+	// GrafanaURL is the root URL of the Grafana server, e.g. http://localhost:3000
+	GrafanaURL string
+	// DatasourceUID identifies the TwinMaker datasource
+	DatasourceUID string
+	// APIKey is sent as a bearer token when set
+	APIKey string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the datasource
+func NewClient(grafanaURL string, datasourceUID string, apiKey string) *Client {
+	return &Client{
+		GrafanaURL:    grafanaURL,
+		DatasourceUID: datasourceUID,
+		APIKey:        apiKey,
+	}
+}
+
+// Error is a non 200 response of the resource API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("resource api: %d %s", e.StatusCode, e.Message)
+}
+
+// ResourcePage is one page of /entities or /component-types
+type ResourcePage[T any] struct {
+	Items     []T    `json:"items"`
+	NextToken string `json:"nextToken,omitempty"`
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, params url.Values, body interface{}, rsp interface{}) error {
+	u := strings.TrimSuffix(c.GrafanaURL, "/") + "/api/datasources/uid/" + url.PathEscape(c.DatasourceUID) + "/resources" + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case rawBody:
+		reader = bytes.NewReader(b.data)
+		contentType = b.contentType
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: res.StatusCode}
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		if b, err := io.ReadAll(res.Body); err == nil {
+			if json.Unmarshal(b, &msg) == nil && msg.Message != "" {
+				e.Message = msg.Message
+			} else {
+				e.Message = strings.TrimSpace(string(b))
+			}
+		}
+		return e
+	}
+	if out, ok := rsp.(io.Writer); ok {
+		// not JSON, e.g. CSV exports
+		_, err = io.Copy(out, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(rsp)
+}
+
+// rawBody is sent as is instead of JSON
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+func idParam(id string) url.Values {
+	return url.Values{"id": []string{id}}
+}
+
+func pageParams(nextToken string, maxResults int) url.Values {
+	params := url.Values{}
+	if nextToken != "" {
+		params.Set("nextToken", nextToken)
+	}
+	if maxResults > 0 {
+		params.Set("maxResults", strconv.Itoa(maxResults))
+	}
+	return params
+}
+
+// GetToken returns session credentials of the dashboard role
+func (c *Client) GetToken(ctx context.Context) (*models.TokenInfo, error) {
+	rsp := &models.TokenInfo{}
+	return rsp, c.do(ctx, http.MethodGet, "/token", nil, nil, rsp)
+}
+
+// GetDefaultQuery returns the configured starting point for new queries
+func (c *Client) GetDefaultQuery(ctx context.Context) (*models.TwinMakerDefaultQuery, error) {
+	rsp := &models.TwinMakerDefaultQuery{}
+	return rsp, c.do(ctx, http.MethodGet, "/default-query", nil, nil, rsp)
+}
+
+// ValidateWorkspaces checks the access to the configured workspaces, all of them when none are
+// given. The API key needs the admin role.
+func (c *Client) ValidateWorkspaces(ctx context.Context, workspaceIds ...string) ([]models.WorkspaceCheck, error) {
+	rsp := struct {
+		Workspaces []models.WorkspaceCheck `json:"workspaces"`
+	}{}
+	params := url.Values{}
+	for _, id := range workspaceIds {
+		params.Add("workspaceId", id)
+	}
+	err := c.do(ctx, http.MethodGet, "/workspaces/validate", params, nil, &rsp)
+	return rsp.Workspaces, err
+}
+
+// BatchPutPropertyValues writes property values with the writer role
+func (c *Client) BatchPutPropertyValues(ctx context.Context, entries []*iottwinmaker.PropertyValueEntry) (*iottwinmaker.BatchPutPropertyValuesOutput, error) {
+	body := map[string]interface{}{"entries": entries}
+	rsp := &iottwinmaker.BatchPutPropertyValuesOutput{}
+	return rsp, c.do(ctx, http.MethodPost, "/entity-properties", nil, body, rsp)
+}
+
+// GetEntityTags reads the resource tags of the entities
+func (c *Client) GetEntityTags(ctx context.Context, entityIds ...string) ([]models.EntityTags, error) {
+	rsp := struct {
+		Entities []models.EntityTags `json:"entities"`
+	}{}
+	params := url.Values{}
+	for _, id := range entityIds {
+		params.Add("entityId", id)
+	}
+	err := c.do(ctx, http.MethodGet, "/entities/tags", params, nil, &rsp)
+	return rsp.Entities, err
+}
+
+// UpdateEntityTags sets and removes entity resource tags, failed entities are listed in the report
+func (c *Client) UpdateEntityTags(ctx context.Context, updates []models.EntityTagUpdate) (*models.EntityTagReport, error) {
+	body := map[string]interface{}{"updates": updates}
+	rsp := &models.EntityTagReport{}
+	return rsp, c.do(ctx, http.MethodPost, "/entities/tags", nil, body, rsp)
+}
+
+// InvalidateExternalIds makes component history queries of the workspace resolve their externalIds
+// again, all workspaces when it is empty. It returns the number of dropped resolutions.
+func (c *Client) InvalidateExternalIds(ctx context.Context, workspaceId string) (int, error) {
+	params := url.Values{}
+	if workspaceId != "" {
+		params.Set("workspaceId", workspaceId)
+	}
+	rsp := struct {
+		Invalidated int `json:"invalidated"`
+	}{}
+	err := c.do(ctx, http.MethodPost, "/external-ids/invalidate", params, nil, &rsp)
+	return rsp.Invalidated, err
+}
+
+// AcknowledgeAlarms acknowledges the alarms, failed writes are listed in the report
+func (c *Client) AcknowledgeAlarms(ctx context.Context, alarms []models.AlarmReference) (*models.AlarmAckReport, error) {
+	body := map[string]interface{}{"alarms": alarms}
+	rsp := &models.AlarmAckReport{}
+	return rsp, c.do(ctx, http.MethodPost, "/alarms/acknowledge", nil, body, rsp)
+}
+
+// SnoozeAlarms snoozes alarms of SiteWise alarm models in IoT Events, failed alarms are listed in the report
+func (c *Client) SnoozeAlarms(ctx context.Context, alarms []models.AlarmReference, duration time.Duration) (*models.AlarmSnoozeReport, error) {
+	body := map[string]interface{}{"alarms": alarms, "snoozeSeconds": int64(duration.Seconds())}
+	rsp := &models.AlarmSnoozeReport{}
+	return rsp, c.do(ctx, http.MethodPost, "/alarms/snooze", nil, body, rsp)
+}
+
+// ExportAlarmHistory copies the CSV alarm history of the time range to w
+func (c *Client) ExportAlarmHistory(ctx context.Context, from time.Time, to time.Time, w io.Writer) error {
+	params := url.Values{}
+	params.Set("from", strconv.FormatInt(from.UnixMilli(), 10))
+	params.Set("to", strconv.FormatInt(to.UnixMilli(), 10))
+	return c.do(ctx, http.MethodGet, "/alarms/export", params, nil, w)
+}
+
+// GetAlarmSummary counts the alarms of the workspaces by their latest status, all configured
+// workspaces when none are given. The counts are of the query role, the dashboard role when empty.
+func (c *Client) GetAlarmSummary(ctx context.Context, roleArn string, workspaceIds ...string) ([]models.AlarmSummary, error) {
+	rsp := struct {
+		Workspaces []models.AlarmSummary `json:"workspaces"`
+	}{}
+	params := url.Values{}
+	if roleArn != "" {
+		params.Set("roleArn", roleArn)
+	}
+	for _, id := range workspaceIds {
+		params.Add("workspaceId", id)
+	}
+	err := c.do(ctx, http.MethodGet, "/alarms/summary", params, nil, &rsp)
+	return rsp.Workspaces, err
+}
+
+// WriteAnnotation writes an annotation to the note property of its entity
+func (c *Client) WriteAnnotation(ctx context.Context, note models.AnnotationNote) (*models.AnnotationNoteResult, error) {
+	rsp := &models.AnnotationNoteResult{}
+	return rsp, c.do(ctx, http.MethodPost, "/annotations", nil, note, rsp)
+}
+
+type watchlist struct {
+	Items []models.WatchlistItem `json:"items"`
+}
+
+// GetWatchlist returns the watchlist items
+func (c *Client) GetWatchlist(ctx context.Context) ([]models.WatchlistItem, error) {
+	rsp := &watchlist{}
+	err := c.do(ctx, http.MethodGet, "/watchlist", nil, nil, rsp)
+	return rsp.Items, err
+}
+
+// SetWatchlist replaces the watchlist items
+func (c *Client) SetWatchlist(ctx context.Context, items []models.WatchlistItem) ([]models.WatchlistItem, error) {
+	rsp := &watchlist{}
+	err := c.do(ctx, http.MethodPut, "/watchlist", nil, watchlist{Items: items}, rsp)
+	return rsp.Items, err
+}
+
+type favorites struct {
+	Favorites []models.PropertyFavorite `json:"favorites"`
+}
+
+// GetFavorites returns the property favorites of the user the client signs in as
+func (c *Client) GetFavorites(ctx context.Context) ([]models.PropertyFavorite, error) {
+	rsp := &favorites{}
+	err := c.do(ctx, http.MethodGet, "/favorites", nil, nil, rsp)
+	return rsp.Favorites, err
+}
+
+// SetFavorites replaces the property favorites of the user the client signs in as
+func (c *Client) SetFavorites(ctx context.Context, items []models.PropertyFavorite) ([]models.PropertyFavorite, error) {
+	rsp := &favorites{}
+	err := c.do(ctx, http.MethodPut, "/favorites", nil, favorites{Favorites: items}, rsp)
+	return rsp.Favorites, err
+}
+
+// EvaluateSceneRules evaluates the tag rules of a scene
+func (c *Client) EvaluateSceneRules(ctx context.Context, sceneId string) ([]models.SceneTagState, error) {
+	var rsp []models.SceneTagState
+	return rsp, c.do(ctx, http.MethodGet, "/scene/rules", idParam(sceneId), nil, &rsp)
+}
+
+// UploadSceneAsset uploads a .glb or .gltf model to the workspace bucket
+func (c *Client) UploadSceneAsset(ctx context.Context, name string, data []byte) (*models.SceneAsset, error) {
+	contentType := "model/gltf-binary"
+	if strings.HasSuffix(strings.ToLower(name), ".gltf") {
+		contentType = "model/gltf+json"
+	}
+	rsp := &models.SceneAsset{}
+	params := url.Values{"name": []string{name}}
+	return rsp, c.do(ctx, http.MethodPost, "/scene/assets", params, rawBody{contentType: contentType, data: data}, rsp)
+}
+
+func demoWorkspaceParams(req models.DemoWorkspaceRequest) url.Values {
+	params := url.Values{}
+	if req.WorkspaceId != "" {
+		params.Set("workspaceId", req.WorkspaceId)
+	}
+	params.Set("s3Location", req.S3Location)
+	params.Set("role", req.Role)
+	return params
+}
+
+// PlanDemoWorkspace lists the resources CreateDemoWorkspace would create
+func (c *Client) PlanDemoWorkspace(ctx context.Context, req models.DemoWorkspaceRequest) (*models.DemoWorkspaceReport, error) {
+	rsp := &models.DemoWorkspaceReport{}
+	return rsp, c.do(ctx, http.MethodGet, "/bootstrap/demo", demoWorkspaceParams(req), nil, rsp)
+}
+
+// CreateDemoWorkspace creates the demo workspace, the API key needs the admin role
+func (c *Client) CreateDemoWorkspace(ctx context.Context, req models.DemoWorkspaceRequest) (*models.DemoWorkspaceReport, error) {
+	rsp := &models.DemoWorkspaceReport{}
+	return rsp, c.do(ctx, http.MethodPost, "/bootstrap/demo", nil, req, rsp)
+}
+
+// EstimateQuery estimates the AWS calls of a query. The query is the panel query JSON with
+// queryType and optionally startTime/endTime.
+func (c *Client) EstimateQuery(ctx context.Context, query interface{}) (*models.QueryEstimate, error) {
+	rsp := &models.QueryEstimate{}
+	return rsp, c.do(ctx, http.MethodPost, "/estimate", nil, query, rsp)
+}
+
+// DebugBundle runs the query again for the time range and returns its redacted AWS calls and
+// result, needs the admin role. The query is the panel query JSON like for EstimateQuery, zero
+// times are the last hour.
+func (c *Client) DebugBundle(ctx context.Context, query interface{}, from time.Time, to time.Time) (*models.DebugBundle, error) {
+	rsp := &models.DebugBundle{}
+	params := url.Values{}
+	if !from.IsZero() {
+		params.Set("from", strconv.FormatInt(from.UnixMilli(), 10))
+	}
+	if !to.IsZero() {
+		params.Set("to", strconv.FormatInt(to.UnixMilli(), 10))
+	}
+	return rsp, c.do(ctx, http.MethodPost, "/debug/bundle", params, query, rsp)
+}
+
+// GetEntity returns the (cached) entity
+func (c *Client) GetEntity(ctx context.Context, entityId string) (*iottwinmaker.GetEntityOutput, error) {
+	rsp := &iottwinmaker.GetEntityOutput{}
+	return rsp, c.do(ctx, http.MethodGet, "/entity", idParam(entityId), nil, rsp)
+}
+
+// ListWorkspaces returns the workspaces as selectable values
+func (c *Client) ListWorkspaces(ctx context.Context) ([]models.SelectableString, error) {
+	var rsp []models.SelectableString
+	return rsp, c.do(ctx, http.MethodGet, "/list/workspaces", nil, nil, &rsp)
+}
+
+// ListScenes returns the scenes of the datasource workspace as selectable values
+func (c *Client) ListScenes(ctx context.Context) ([]models.SelectableString, error) {
+	var rsp []models.SelectableString
+	return rsp, c.do(ctx, http.MethodGet, "/list/scenes", nil, nil, &rsp)
+}
+
+// ListOptions returns the entities, components and properties for the query editor
+func (c *Client) ListOptions(ctx context.Context) (*models.OptionsInfo, error) {
+	rsp := &models.OptionsInfo{}
+	return rsp, c.do(ctx, http.MethodGet, "/list/options", nil, nil, rsp)
+}
+
+// ListEntityOptions returns the components and properties of an entity
+func (c *Client) ListEntityOptions(ctx context.Context, entityId string) ([]models.SelectableProps, error) {
+	var rsp []models.SelectableProps
+	return rsp, c.do(ctx, http.MethodGet, "/list/entity", idParam(entityId), nil, &rsp)
+}
+
+// ListVariableOptions returns the options of a chained template variable, empty parents are left out
+func (c *Client) ListVariableOptions(ctx context.Context, kind models.VariableKind, parents models.VariableParents) ([]models.VariableOption, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{
+		"workspaceId":     parents.WorkspaceId,
+		"entityId":        parents.EntityId,
+		"componentName":   parents.ComponentName,
+		"componentTypeId": parents.ComponentTypeId,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	var rsp []models.VariableOption
+	return rsp, c.do(ctx, http.MethodGet, "/variables/"+kind, params, nil, &rsp)
+}
+
+// ListEntitiesPage returns one page of entity summaries
+func (c *Client) ListEntitiesPage(ctx context.Context, nextToken string, maxResults int) (*ResourcePage[*iottwinmaker.EntitySummary], error) {
+	rsp := &ResourcePage[*iottwinmaker.EntitySummary]{}
+	return rsp, c.do(ctx, http.MethodGet, "/entities", pageParams(nextToken, maxResults), nil, rsp)
+}
+
+// ListComponentTypesPage returns one page of component type summaries
+func (c *Client) ListComponentTypesPage(ctx context.Context, nextToken string, maxResults int) (*ResourcePage[*iottwinmaker.ComponentTypeSummary], error) {
+	rsp := &ResourcePage[*iottwinmaker.ComponentTypeSummary]{}
+	return rsp, c.do(ctx, http.MethodGet, "/component-types", pageParams(nextToken, maxResults), nil, rsp)
+}
@@ -0,0 +1,73 @@
+package resourceapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		w.Header().Add("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/datasources/uid/abc/resources/watchlist":
+			require.Equal(t, http.MethodPut, r.Method)
+			_, _ = w.Write([]byte(`{"items":[{"entityId":"e","componentName":"c","propertyName":"p"}]}`))
+		case "/api/datasources/uid/abc/resources/entities":
+			require.Equal(t, "tok", r.URL.Query().Get("nextToken"))
+			require.Equal(t, "5", r.URL.Query().Get("maxResults"))
+			_, _ = w.Write([]byte(`{"items":[{"entityId":"e","entityName":"E"}],"nextToken":"next"}`))
+		case "/api/datasources/uid/abc/resources/scene/assets":
+			require.Equal(t, "model.glb", r.URL.Query().Get("name"))
+			require.Equal(t, "model/gltf-binary", r.Header.Get("Content-Type"))
+			body, _ := io.ReadAll(r.Body)
+			require.Equal(t, "glTF", string(body))
+			_, _ = w.Write([]byte(`{"location":"s3://bucket/model.glb","contentType":"model/gltf-binary","size":4}`))
+		case "/api/datasources/uid/abc/resources/scene/rules":
+			require.Equal(t, "scene", r.URL.Query().Get("id"))
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "scene not found"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL+"/", "abc", "key")
+	ctx := context.Background()
+
+	t.Run("watchlist", func(t *testing.T) {
+		items, err := c.SetWatchlist(ctx, []models.WatchlistItem{{EntityId: "e", ComponentName: "c", PropertyName: "p"}})
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, "p", items[0].PropertyName)
+	})
+
+	t.Run("typed page", func(t *testing.T) {
+		page, err := c.ListEntitiesPage(ctx, "tok", 5)
+		require.NoError(t, err)
+		require.Equal(t, "next", page.NextToken)
+		require.Len(t, page.Items, 1)
+		require.Equal(t, "E", *page.Items[0].EntityName)
+	})
+
+	t.Run("raw body", func(t *testing.T) {
+		asset, err := c.UploadSceneAsset(ctx, "model.glb", []byte("glTF"))
+		require.NoError(t, err)
+		require.Equal(t, "s3://bucket/model.glb", asset.Location)
+	})
+
+	t.Run("error message", func(t *testing.T) {
+		_, err := c.EvaluateSceneRules(ctx, "scene")
+		var apiErr *Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		require.Equal(t, "scene not found", apiErr.Message)
+	})
+}
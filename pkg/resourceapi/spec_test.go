@@ -0,0 +1,191 @@
+package resourceapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/grafana/grafana-iot-twinmaker-app/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+type specOperation struct {
+	method string
+	path   string
+	params map[string]bool
+}
+
+// loadSpec reads the operations of the resource API document by operationId
+func loadSpec(t *testing.T) map[string]specOperation {
+	b, err := os.ReadFile("../plugin/openapi.json")
+	require.NoError(t, err)
+	doc := struct {
+		Paths map[string]map[string]struct {
+			OperationId string `json:"operationId"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				Ref  string `json:"$ref"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+		Components struct {
+			Parameters map[string]struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"components"`
+	}{}
+	require.NoError(t, json.Unmarshal(b, &doc))
+
+	ops := map[string]specOperation{}
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			params := map[string]bool{}
+			for _, p := range op.Parameters {
+				name, in := p.Name, p.In
+				if p.Ref != "" {
+					ref := doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+					name, in = ref.Name, ref.In
+				}
+				if in == "query" {
+					params[name] = true
+				}
+			}
+			ops[op.OperationId] = specOperation{method: strings.ToUpper(method), path: path, params: params}
+		}
+	}
+	return ops
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	params []string
+}
+
+// argument is a non-zero value of a client method parameter, so optional query parameters are sent
+func argument(ctx context.Context, typ reflect.Type) reflect.Value {
+	switch {
+	case typ == reflect.TypeOf((*context.Context)(nil)).Elem():
+		return reflect.ValueOf(ctx)
+	case typ == reflect.TypeOf((*io.Writer)(nil)).Elem():
+		return reflect.ValueOf(io.Discard)
+	case typ == reflect.TypeOf(time.Time{}):
+		return reflect.ValueOf(time.Unix(0, 0))
+	}
+	v := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if f := v.Field(i); f.Kind() == reflect.String && f.CanSet() {
+				f.SetString("x")
+			}
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.String {
+			v = reflect.ValueOf([]string{"x"})
+		}
+	}
+	return v
+}
+
+func lowerFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// TestClientMatchesSpec calls every client method and checks its request against the operation of
+// the same name in openapi.json, and that every operation of the document has a client method
+func TestClientMatchesSpec(t *testing.T) {
+	spec := loadSpec(t)
+
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := []string{}
+		for key := range r.URL.Query() {
+			params = append(params, key)
+		}
+		sort.Strings(params)
+		requests = append(requests, recordedRequest{
+			method: r.Method,
+			path:   strings.TrimPrefix(r.URL.Path, "/api/datasources/uid/abc/resources"),
+			params: params,
+		})
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, "abc", "")
+	ctx := context.Background()
+	covered := map[string]bool{
+		// the document itself
+		"getOpenAPI": true,
+	}
+
+	client := reflect.ValueOf(c)
+	for i := 0; i < client.NumMethod(); i++ {
+		method := client.Type().Method(i)
+		fn := client.Method(i)
+
+		var calls [][]reflect.Value
+		if method.Name == "ListVariableOptions" {
+			// one operation per variable kind
+			for _, kind := range []models.VariableKind{models.VariableEntities, models.VariableComponents, models.VariableProperties, models.VariableComponentTypes} {
+				calls = append(calls, []reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(kind), argument(ctx, fn.Type().In(2))})
+			}
+		} else {
+			args := make([]reflect.Value, fn.Type().NumIn())
+			for j := range args {
+				args[j] = argument(ctx, fn.Type().In(j))
+			}
+			calls = append(calls, args)
+		}
+
+		for _, args := range calls {
+			requests = nil
+			if fn.Type().IsVariadic() {
+				fn.CallSlice(args)
+			} else {
+				fn.Call(args)
+			}
+			require.Len(t, requests, 1, method.Name)
+			req := requests[0]
+
+			opId := lowerFirst(method.Name)
+			if method.Name == "ListVariableOptions" {
+				opId = ""
+				for id, op := range spec {
+					if op.path == req.path {
+						opId = id
+					}
+				}
+			}
+			op, ok := spec[opId]
+			require.True(t, ok, "%s has no operation %s in openapi.json", method.Name, opId)
+			require.Equal(t, op.method, req.method, opId)
+			require.Equal(t, op.path, req.path, opId)
+			for _, p := range req.params {
+				require.True(t, op.params[p], "%s sends query parameter %s that openapi.json does not describe", opId, p)
+			}
+			covered[opId] = true
+		}
+	}
+
+	for opId := range spec {
+		require.True(t, covered[opId], "operation %s of openapi.json has no client method", opId)
+	}
+}
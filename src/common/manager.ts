@@ -15,6 +15,8 @@ export enum TwinMakerQueryType {
   ComponentHistory = 'ComponentHistory',
   EntityHistory = 'EntityHistory',
   GetAlarms = 'GetAlarms',
+  DefinitionDiff = 'DefinitionDiff',
+  Interpolated = 'Interpolated',
 
   // Used for variable queries
   ListComponentTypes = 'ListComponentTypes',
@@ -58,17 +60,34 @@ export interface TwinMakerQuery extends DataQuery {
 
   //  workspaceId?: string;
   entityId?: string;
+  // EntityHistory and GetPropertyValue run for each of these entities, e.g. a multi-value variable
+  entityIds?: string[];
   componentName?: string;
   componentTypeId?: string;
   properties?: string[];
   filter?: TwinMakerPropertyFilter[];
   maxResults?: number;
   order?: TwinMakerResultOrder;
+  // EntityHistory values of each property
+  limit?: number;
+  // values or entities per AWS call of history and ListEntities queries
+  pageSize?: number;
+  // combine history values per bucket of the panel resolution, see twinMakerDownsampleOptions
+  downsample?: 'avg' | 'min' | 'max' | 'last' | 'count';
+  // EntityHistory of SiteWise connected components as SiteWise aggregates, see twinMakerAggregateOptions
+  aggregate?: 'avg' | 'min' | 'max' | 'count';
+  sitewiseResolution?: '1m' | '15m' | '1h';
+  // added as a severity field computed from the fields of each row, e.g. for sorting alarm tables
+  severityExpression?: string;
   grafanaLiveEnabled: boolean;
   isStreaming?: boolean;
   intervalStreaming?: string;
   propertyDisplayNames: { [key: string]: string };
 
+  // Resolve the externalIds of component history results from a snapshot for reproducible queries
+  pinResolution?: boolean;
+  resolutionSnapshot?: string;
+
   // Athena Data Connector parameters for GetPropertyValue query
   tabularConditions?: TwinMakerTabularConditions;
   propertyGroupName?: string;
@@ -5,6 +5,12 @@ import { firstLabelWidth } from '.';
 import { BlurTextInput } from './BlurTextInput';
 import { SelectableValue } from '@grafana/data';
 
+// the comparisons of the built-in connectors, custom connectors may support others
+const operators: Array<SelectableValue<string>> = ['=', '!=', '<', '<=', '>', '>='].map((op) => ({
+  label: op,
+  value: op,
+}));
+
 export interface FilterQueryEditorProps {
   filters: TwinMakerPropertyFilter[];
   properties: Array<SelectableValue<string>>;
@@ -41,6 +47,11 @@ export default function FilterQueryEditor(props: FilterQueryEditorProps) {
       filterVal.longValue = parseFloat(v);
     } else if (propSel?.description?.includes('(STRING)')) {
       filterVal.stringValue = v;
+    } else if (v.trim() !== '' && !isNaN(Number(v))) {
+      // the type is unknown, e.g. the property was typed in
+      filterVal.doubleValue = Number(v);
+    } else {
+      filterVal.stringValue = v;
     }
     return filterVal;
   };
@@ -48,11 +59,11 @@ export default function FilterQueryEditor(props: FilterQueryEditorProps) {
   const filterValueToString = (v: TwinMakerFilterValue): string => {
     if (v.booleanValue !== undefined) {
       return v.booleanValue.toString();
-    } else if (v.doubleValue) {
+    } else if (v.doubleValue !== undefined) {
       return v.doubleValue.toString();
-    } else if (v.integerValue) {
+    } else if (v.integerValue !== undefined) {
       return v.integerValue.toString();
-    } else if (v.longValue) {
+    } else if (v.longValue !== undefined) {
       return v.longValue.toString();
     } else if (v.stringValue) {
       return v.stringValue;
@@ -80,15 +91,14 @@ export default function FilterQueryEditor(props: FilterQueryEditorProps) {
               isClearable={false}
               width={40}
             />
-            <BlurTextInput
+            <Select
+              menuShouldPortal={true}
+              options={operators}
+              value={f.op || DEFAULT_PROPERTY_FILTER_OPERATOR}
+              onChange={(v) => onOpChange(v.value!, index)}
+              allowCustomValue={true}
+              isClearable={false}
               width={14}
-              value={f.op ?? DEFAULT_PROPERTY_FILTER_OPERATOR}
-              onChange={(v) => {
-                if (v) {
-                  onOpChange(v, index);
-                }
-              }}
-              placeholder={DEFAULT_PROPERTY_FILTER_OPERATOR}
             />
             <BlurTextInput
               value={filterValueToString(filters[index].value)}
@@ -16,7 +16,15 @@ import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { TwinMakerDataSource } from '../datasource';
 import { defaultQuery, TwinMakerDataSourceOptions } from '../types';
 import { TwinMakerApiModel } from 'aws-iot-twinmaker-grafana-utils';
-import { changeQueryType, QueryTypeInfo, twinMakerOrderOptions, twinMakerQueryTypes } from 'datasource/queryInfo';
+import {
+  changeQueryType,
+  QueryTypeInfo,
+  twinMakerDownsampleOptions,
+  twinMakerAggregateOptions,
+  twinMakerResolutionOptions,
+  twinMakerOrderOptions,
+  twinMakerQueryTypes,
+} from 'datasource/queryInfo';
 import {
   WorkspaceSelectionInfo,
   SelectableComponentInfo,
@@ -134,6 +142,58 @@ export class QueryEditor extends PureComponent<Props, State> {
     onRunQuery();
   };
 
+  onLimitChange = (event: any) => {
+    const { onChange, query, onRunQuery } = this.props;
+    const limit = event.target.valueAsNumber;
+    onChange({ ...query, limit: limit > 0 ? limit : undefined });
+    onRunQuery();
+  };
+
+  onDownsampleChange = (event: SelectableValue<TwinMakerQuery['downsample']>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, downsample: event?.value });
+    onRunQuery();
+  };
+
+  onAggregateChange = (event: SelectableValue<TwinMakerQuery['aggregate']>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    const aggregate = event?.value;
+    onChange({ ...query, aggregate, sitewiseResolution: aggregate ? query.sitewiseResolution : undefined });
+    onRunQuery();
+  };
+
+  onResolutionChange = (event: SelectableValue<TwinMakerQuery['sitewiseResolution']>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, sitewiseResolution: event?.value });
+    onRunQuery();
+  };
+
+  onSeverityExpressionChange = (severityExpression?: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, severityExpression: severityExpression || undefined });
+    onRunQuery();
+  };
+
+  onTogglePinResolution = () => {
+    const { onChange, query, onRunQuery } = this.props;
+    // unpinned queries resolve from the current twin model
+    onChange({ ...query, pinResolution: !query.pinResolution || undefined, resolutionSnapshot: undefined });
+    onRunQuery();
+  };
+
+  onResolutionSnapshotChange = (resolutionSnapshot?: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, resolutionSnapshot: resolutionSnapshot || undefined });
+    onRunQuery();
+  };
+
+  onPageSizeChange = (event: any) => {
+    const { onChange, query, onRunQuery } = this.props;
+    const pageSize = event.target.valueAsNumber;
+    onChange({ ...query, pageSize: pageSize > 0 ? pageSize : undefined });
+    onRunQuery();
+  };
+
   onAlarmFilterChange = (event: SelectableValue<string>) => {
     const { onChange, query, onRunQuery } = this.props;
     const filter = event?.value
@@ -476,6 +536,51 @@ export class QueryEditor extends PureComponent<Props, State> {
     );
   }
 
+  renderSeverityExpressionInput(query: TwinMakerQuery) {
+    return (
+      <InlineFieldRow>
+        <InlineField
+          label={'Severity'}
+          grow={true}
+          labelWidth={firstLabelWidth}
+          tooltip="Expression of the fields of each row added as a severity field, times are seconds like now. Supports + - * / %, comparisons, && || !, abs, min, max and if(condition, then, else)"
+        >
+          <BlurTextInput
+            value={query.severityExpression ?? ''}
+            onChange={this.onSeverityExpressionChange}
+            placeholder="(alarmStatus == 'ACTIVE') * 10 + (now - Time) / 3600"
+          />
+        </InlineField>
+      </InlineFieldRow>
+    );
+  }
+
+  renderResolutionSnapshotInputs(query: TwinMakerQuery) {
+    return (
+      <InlineFieldRow>
+        <InlineField
+          label={'Pin resolution'}
+          labelWidth={firstLabelWidth}
+          tooltip="Resolve the externalIds of the results from a snapshot taken on the first run, so the query keeps its entities after the twin model changed"
+        >
+          <InlineSwitch value={Boolean(query.pinResolution)} onChange={this.onTogglePinResolution} />
+        </InlineField>
+        <InlineField
+          label={'Snapshot'}
+          grow={true}
+          disabled={!query.pinResolution}
+          tooltip="Time of the snapshot to resolve from, RFC3339 as in the frame meta. The snapshot of the query when empty"
+        >
+          <BlurTextInput
+            value={query.resolutionSnapshot ?? ''}
+            onChange={this.onResolutionSnapshotChange}
+            placeholder="2022-04-27T10:00:00Z"
+          />
+        </InlineField>
+      </InlineFieldRow>
+    );
+  }
+
   getPropertiesMultiSelectionInfo(query: TwinMakerQuery, propOpts?: Array<SelectableValue<string>>) {
     if (!propOpts) {
       propOpts = [];
@@ -738,12 +843,24 @@ export class QueryEditor extends PureComponent<Props, State> {
           <>
             {this.renderAlarmFilterSelector(query, true)}
             {this.renderAlarmMaxResultsInput(query)}
+            {this.renderSeverityExpressionInput(query)}
           </>
         );
       case TwinMakerQueryType.ListEntities:
         return this.renderComponentTypeSelector(query, compType);
       case TwinMakerQueryType.GetEntity:
         return this.renderEntitySelector(query, false);
+      case TwinMakerQueryType.DefinitionDiff: {
+        // the component type defaults to the one of the component
+        const compName = getSelectionInfo(query.componentName, entityInfo, this.state.templateVars);
+        return (
+          <>
+            {this.renderEntitySelector(query, false)}
+            {this.renderComponentNameSelector(query, compName, false)}
+            {this.renderComponentTypeSelector(query, compType)}
+          </>
+        );
+      }
       case TwinMakerQueryType.GetPropertyValue:
         if (query.entityId) {
           const compName = getSelectionInfo(query.componentName, entityInfo, this.state.templateVars);
@@ -783,6 +900,17 @@ export class QueryEditor extends PureComponent<Props, State> {
           </>
         );
       }
+      case TwinMakerQueryType.Interpolated: {
+        const compName = getSelectionInfo(query.componentName, entityInfo, this.state.templateVars);
+        const propOpts = resolvePropsFromComponentSel(compName, ComponentFieldName.timeSeries, entityInfo);
+        return (
+          <>
+            {this.renderEntitySelector(query, false)}
+            {this.renderComponentNameSelector(query, compName, false)}
+            {this.renderPropsSelector(query, propOpts)}
+          </>
+        );
+      }
       case TwinMakerQueryType.ComponentHistory: {
         const propOpts = compType.current?.timeSeries as SelectableQueryResults;
         return (
@@ -790,6 +918,7 @@ export class QueryEditor extends PureComponent<Props, State> {
             {this.renderComponentTypeSelector(query, compType, 'timeSeries', true)}
             {this.renderPropsSelector(query, propOpts)}
             {this.renderPropsFilterSelector(query, propOpts)}
+            {this.renderResolutionSnapshotInputs(query)}
           </>
         );
       }
@@ -814,8 +943,10 @@ export class QueryEditor extends PureComponent<Props, State> {
       </div>
     ) : undefined;
 
-    const sortable =
+    const history =
       query.queryType === TwinMakerQueryType.ComponentHistory || query.queryType === TwinMakerQueryType.EntityHistory;
+    const sortable = history || query.queryType === TwinMakerQueryType.Interpolated;
+    const paged = history || query.queryType === TwinMakerQueryType.ListEntities;
 
     return (
       <div className={'gf-form-group'}>
@@ -843,6 +974,78 @@ export class QueryEditor extends PureComponent<Props, State> {
               />
             </InlineField>
           )}
+          {query.queryType === TwinMakerQueryType.EntityHistory && (
+            <InlineField label="Limit" tooltip="Values of each property, the latest ones with descending order">
+              <Input
+                className="width-8"
+                value={query.limit && query.limit > 0 ? query.limit : ''}
+                type="number"
+                onChange={this.onLimitChange}
+                placeholder="all"
+                min="1"
+              />
+            </InlineField>
+          )}
+          {history && (
+            <InlineField
+              label="Downsample"
+              tooltip="Series with more values than the panel can show are combined per interval in the backend, text and boolean values keep the last value"
+            >
+              <Select
+                menuShouldPortal={true}
+                options={twinMakerDownsampleOptions}
+                value={twinMakerDownsampleOptions.find((v) => v.value === query.downsample)}
+                onChange={this.onDownsampleChange}
+                placeholder="off"
+                isClearable
+                width={12}
+              />
+            </InlineField>
+          )}
+          {query.queryType === TwinMakerQueryType.EntityHistory && (
+            <InlineField
+              label="Aggregate"
+              tooltip="SiteWise aggregates of components of the SiteWise connector instead of the raw values"
+            >
+              <Select
+                menuShouldPortal={true}
+                options={twinMakerAggregateOptions}
+                value={twinMakerAggregateOptions.find((v) => v.value === query.aggregate)}
+                onChange={this.onAggregateChange}
+                placeholder="raw"
+                isClearable
+                width={12}
+              />
+            </InlineField>
+          )}
+          {query.queryType === TwinMakerQueryType.EntityHistory && query.aggregate && (
+            <InlineField label="Resolution">
+              <Select
+                menuShouldPortal={true}
+                options={twinMakerResolutionOptions}
+                value={twinMakerResolutionOptions.find((v) => v.value === query.sitewiseResolution)}
+                onChange={this.onResolutionChange}
+                placeholder="1m"
+                isClearable
+                width={10}
+              />
+            </InlineField>
+          )}
+          {paged && (
+            <InlineField
+              label="Page size"
+              tooltip="Results per AWS call, smaller pages show the first results sooner and larger ones need fewer calls"
+            >
+              <Input
+                className="width-8"
+                value={query.pageSize && query.pageSize > 0 ? query.pageSize : ''}
+                type="number"
+                onChange={this.onPageSizeChange}
+                placeholder="auto"
+                min="1"
+              />
+            </InlineField>
+          )}
         </InlineFieldRow>
 
         {this.renderQuery(query)}
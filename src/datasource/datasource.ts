@@ -12,6 +12,9 @@ import { getRequestLooper, MultiRequestTracker } from './requestLooper';
 import { appendMatchingFrames } from './appendFrames';
 import { BatchPutPropertyValuesResponse, Entries } from 'aws-sdk/clients/iottwinmaker';
 
+// query types that run for each entity of a multi-value entityId variable
+const multiEntityQueryTypes = [TwinMakerQueryType.EntityHistory, TwinMakerQueryType.GetPropertyValue];
+
 export class TwinMakerDataSource extends DataSourceWithBackend<TwinMakerQuery, TwinMakerDataSourceOptions> {
   grafanaLiveEnabled: boolean;
   private workspaceId: string;
@@ -67,18 +70,33 @@ export class TwinMakerDataSource extends DataSourceWithBackend<TwinMakerQuery, T
   }
 
   /**
-   * Supports template variables for entityId, componentName, selectedProperties, componentTypeId
+   * Supports template variables for entityId, componentName, selectedProperties, componentTypeId.
+   * Multi-value entityId variables are expanded to entityIds for the query types that run for several
+   * entities, the backend rejects more than 100.
    */
   applyTemplateVariables(query: TwinMakerQuery, scopedVars: ScopedVars): TwinMakerQuery {
     const templateSrv = getTemplateSrv();
-    return {
+    const applied = {
       ...query,
       entityId: templateSrv.replace(query.entityId || '', scopedVars),
+      entityIds: undefined,
       componentName: templateSrv.replace(query.componentName || '', scopedVars),
       properties: query.properties?.map((p) => templateSrv.replace(p || '', scopedVars)) || [],
       propertyDisplayNames: query.propertyDisplayNames,
       componentTypeId: templateSrv.replace(query.componentTypeId || '', scopedVars),
     };
+    if (!query.queryType || !multiEntityQueryTypes.includes(query.queryType)) {
+      return applied;
+    }
+    // entity ids cannot contain commas
+    const entityIds = [query.entityId || '', ...(query.entityIds || [])]
+      .flatMap((id) => templateSrv.replace(id, scopedVars, 'csv').split(','))
+      .filter((id, i, ids) => id && ids.indexOf(id) === i);
+    return {
+      ...applied,
+      entityId: entityIds[0] || '',
+      entityIds: entityIds.length > 1 ? entityIds : undefined,
+    };
   }
 
   query(options: DataQueryRequest<TwinMakerQuery>): Observable<DataQueryResponse> {
@@ -102,6 +120,8 @@ export class TwinMakerDataSource extends DataSourceWithBackend<TwinMakerQuery, T
                 next.push({
                   ...query,
                   nextToken: meta.nextToken,
+                  // the next pages of pinned queries resolve from the same snapshot
+                  resolutionSnapshot: meta.resolutionSnapshot ?? query.resolutionSnapshot,
                 });
               }
             }
@@ -140,8 +160,9 @@ export class TwinMakerDataSource extends DataSourceWithBackend<TwinMakerQuery, T
     });
   }
 
-  batchPutPropertyValues = async (entries: Entries): Promise<BatchPutPropertyValuesResponse> => {
-    return super.postResource('entity-properties', { entries });
+  // waitVisible returns once the written values are read back, so a refresh right after shows them
+  batchPutPropertyValues = async (entries: Entries, waitVisible = false): Promise<BatchPutPropertyValuesResponse> => {
+    return super.postResource('entity-properties', { entries, waitVisible });
   };
 
   // Fetch temporary AWS tokens from the backend plugin and convert them into JS SDK Credentials
@@ -26,6 +26,12 @@ export const twinMakerQueryTypes: QueryTypeInfo[] = [
     description: `Gets the history of a property within a component of a specific componentType.`,
     defaultQuery: {},
   },
+  {
+    label: 'Get Interpolated Values by Entity',
+    value: TwinMakerQueryType.Interpolated,
+    description: `Gets evenly spaced values of the properties of a SiteWise connected component, one every panel interval.`,
+    defaultQuery: {},
+  },
   {
     label: 'Get Alarms',
     value: TwinMakerQueryType.GetAlarms,
@@ -62,6 +68,12 @@ export const twinMakerQueryTypes: QueryTypeInfo[] = [
     description: `Gets an entity within a workspace.`,
     defaultQuery: {},
   },
+  {
+    label: 'Compare Component with Component Type',
+    value: TwinMakerQueryType.DefinitionDiff,
+    description: `Lists the properties of an entity's component that are missing, extra or of another data type than in the component type definition.`,
+    defaultQuery: {},
+  },
 ];
 
 export function changeQueryType(q: TwinMakerQuery, info: QueryTypeInfo): TwinMakerQuery {
@@ -80,6 +92,7 @@ export function changeQueryType(q: TwinMakerQuery, info: QueryTypeInfo): TwinMak
       delete copy.order;
       break;
     case TwinMakerQueryType.EntityHistory:
+    case TwinMakerQueryType.Interpolated:
       delete copy.order;
       delete copy.componentTypeId;
       break;
@@ -98,6 +111,27 @@ export function changeQueryType(q: TwinMakerQuery, info: QueryTypeInfo): TwinMak
   return copy;
 }
 
+export const twinMakerDownsampleOptions: Array<SelectableValue<TwinMakerQuery['downsample']>> = [
+  { label: 'avg', value: 'avg' },
+  { label: 'min', value: 'min' },
+  { label: 'max', value: 'max' },
+  { label: 'last', value: 'last' },
+  { label: 'count', value: 'count' },
+];
+
+export const twinMakerAggregateOptions: Array<SelectableValue<TwinMakerQuery['aggregate']>> = [
+  { label: 'avg', value: 'avg' },
+  { label: 'min', value: 'min' },
+  { label: 'max', value: 'max' },
+  { label: 'count', value: 'count' },
+];
+
+export const twinMakerResolutionOptions: Array<SelectableValue<TwinMakerQuery['sitewiseResolution']>> = [
+  { label: '1m', value: '1m' },
+  { label: '15m', value: '15m' },
+  { label: '1h', value: '1h' },
+];
+
 export const twinMakerOrderOptions = [
   {
     label: 'ASC',
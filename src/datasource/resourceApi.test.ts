@@ -0,0 +1,17 @@
+import { readFileSync } from 'fs';
+import { join } from 'path';
+
+import { resourceOperations } from './resourceApi';
+
+describe('resource API client', () => {
+  it('matches the operations of openapi.json', () => {
+    const spec = JSON.parse(readFileSync(join(__dirname, '../../pkg/plugin/openapi.json'), 'utf8'));
+    const documented: Record<string, { method: string; path: string }> = {};
+    for (const [path, methods] of Object.entries<Record<string, { operationId: string }>>(spec.paths)) {
+      for (const [method, op] of Object.entries(methods)) {
+        documented[op.operationId] = { method: method.toUpperCase(), path: path.replace(/^\//, '') };
+      }
+    }
+    expect(resourceOperations).toEqual(documented);
+  });
+});
@@ -0,0 +1,156 @@
+import { lastValueFrom } from 'rxjs';
+import { getBackendSrv } from '@grafana/runtime';
+import { BatchPutPropertyValuesResponse, Entries, EntitySummary, ComponentTypeSummary } from 'aws-sdk/clients/iottwinmaker';
+
+import { AWSTokenInfo } from './types';
+
+type Method = 'GET' | 'POST' | 'PUT';
+
+/**
+ * Operations of the resource API as described by pkg/plugin/openapi.json, keyed by operationId.
+ * resourceApi.test.ts checks that they stay in sync with the document.
+ */
+export const resourceOperations = {
+  getOpenAPI: { method: 'GET', path: 'openapi.json' },
+  getToken: { method: 'GET', path: 'token' },
+  getDefaultQuery: { method: 'GET', path: 'default-query' },
+  validateWorkspaces: { method: 'GET', path: 'workspaces/validate' },
+  batchPutPropertyValues: { method: 'POST', path: 'entity-properties' },
+  getEntityTags: { method: 'GET', path: 'entities/tags' },
+  updateEntityTags: { method: 'POST', path: 'entities/tags' },
+  invalidateExternalIds: { method: 'POST', path: 'external-ids/invalidate' },
+  acknowledgeAlarms: { method: 'POST', path: 'alarms/acknowledge' },
+  snoozeAlarms: { method: 'POST', path: 'alarms/snooze' },
+  exportAlarmHistory: { method: 'GET', path: 'alarms/export' },
+  getAlarmSummary: { method: 'GET', path: 'alarms/summary' },
+  writeAnnotation: { method: 'POST', path: 'annotations' },
+  getWatchlist: { method: 'GET', path: 'watchlist' },
+  setWatchlist: { method: 'PUT', path: 'watchlist' },
+  getFavorites: { method: 'GET', path: 'favorites' },
+  setFavorites: { method: 'PUT', path: 'favorites' },
+  evaluateSceneRules: { method: 'GET', path: 'scene/rules' },
+  uploadSceneAsset: { method: 'POST', path: 'scene/assets' },
+  planDemoWorkspace: { method: 'GET', path: 'bootstrap/demo' },
+  createDemoWorkspace: { method: 'POST', path: 'bootstrap/demo' },
+  estimateQuery: { method: 'POST', path: 'estimate' },
+  debugBundle: { method: 'POST', path: 'debug/bundle' },
+  getEntity: { method: 'GET', path: 'entity' },
+  listWorkspaces: { method: 'GET', path: 'list/workspaces' },
+  listScenes: { method: 'GET', path: 'list/scenes' },
+  listOptions: { method: 'GET', path: 'list/options' },
+  listEntityOptions: { method: 'GET', path: 'list/entity' },
+  listEntityVariables: { method: 'GET', path: 'variables/entities' },
+  listComponentVariables: { method: 'GET', path: 'variables/components' },
+  listPropertyVariables: { method: 'GET', path: 'variables/properties' },
+  listComponentTypeVariables: { method: 'GET', path: 'variables/componentTypes' },
+  listEntitiesPage: { method: 'GET', path: 'entities' },
+  listComponentTypesPage: { method: 'GET', path: 'component-types' },
+} as const;
+
+export type ResourceOperationId = keyof typeof resourceOperations;
+
+export interface SelectableString {
+  label: string;
+  value: string;
+  description?: string;
+}
+
+export interface AlarmReference {
+  entityId: string;
+  componentName: string;
+}
+
+export interface ResourcePage<T> {
+  items: T[];
+  nextToken?: string;
+}
+
+export interface VariableParents {
+  workspaceId?: string;
+  entityId?: string;
+  componentName?: string;
+  componentTypeId?: string;
+}
+
+type VariableOperation =
+  | 'listEntityVariables'
+  | 'listComponentVariables'
+  | 'listPropertyVariables'
+  | 'listComponentTypeVariables';
+
+type Params = Record<string, string | number | string[] | undefined>;
+
+/**
+ * Typed client of the resource API of one TwinMaker datasource, for tooling running in the
+ * browser. Responses the document describes as plain objects are returned as they are.
+ */
+export class ResourceApiClient {
+  constructor(private datasourceId: number) {}
+
+  async call<T>(operationId: ResourceOperationId, params?: Params, data?: unknown, headers?: Record<string, string>) {
+    const op = resourceOperations[operationId];
+    const rsp = await lastValueFrom(
+      getBackendSrv().fetch<T>({
+        method: op.method as Method,
+        url: `/api/datasources/${this.datasourceId}/resources/${op.path}`,
+        params,
+        data,
+        headers,
+        responseType: operationId === 'exportAlarmHistory' ? 'text' : 'json',
+      })
+    );
+    return rsp.data;
+  }
+
+  getToken = () => this.call<AWSTokenInfo>('getToken');
+  getDefaultQuery = () => this.call<Record<string, unknown>>('getDefaultQuery');
+  validateWorkspaces = (...workspaceId: string[]) =>
+    this.call<{ workspaces: Array<Record<string, unknown>> }>('validateWorkspaces', { workspaceId });
+  batchPutPropertyValues = (entries: Entries, waitVisible = false) =>
+    this.call<BatchPutPropertyValuesResponse>('batchPutPropertyValues', undefined, { entries, waitVisible });
+  getEntityTags = (...entityId: string[]) =>
+    this.call<{ entities: Array<Record<string, unknown>> }>('getEntityTags', { entityId });
+  updateEntityTags = (updates: Array<Record<string, unknown>>) =>
+    this.call<Record<string, unknown>>('updateEntityTags', undefined, { updates });
+  invalidateExternalIds = (workspaceId?: string) =>
+    this.call<{ invalidated: number }>('invalidateExternalIds', { workspaceId });
+  acknowledgeAlarms = (alarms: AlarmReference[]) =>
+    this.call<Record<string, unknown>>('acknowledgeAlarms', undefined, { alarms });
+  snoozeAlarms = (alarms: AlarmReference[], snoozeSeconds: number) =>
+    this.call<Record<string, unknown>>('snoozeAlarms', undefined, { alarms, snoozeSeconds });
+  // CSV of the alarm history, from and to are epoch milliseconds
+  exportAlarmHistory = (from: number, to: number) => this.call<string>('exportAlarmHistory', { from, to });
+  getAlarmSummary = (workspaceId: string[] = [], roleArn?: string) =>
+    this.call<{ workspaces: Array<Record<string, unknown>> }>('getAlarmSummary', { workspaceId, roleArn });
+  writeAnnotation = (note: Record<string, unknown>) => this.call<Record<string, unknown>>('writeAnnotation', undefined, note);
+  getWatchlist = () => this.call<{ items: Array<Record<string, unknown>> }>('getWatchlist');
+  setWatchlist = (items: Array<Record<string, unknown>>) =>
+    this.call<{ items: Array<Record<string, unknown>> }>('setWatchlist', undefined, { items });
+  getFavorites = () => this.call<{ favorites: Array<Record<string, unknown>> }>('getFavorites');
+  setFavorites = (favorites: Array<Record<string, unknown>>) =>
+    this.call<{ favorites: Array<Record<string, unknown>> }>('setFavorites', undefined, { favorites });
+  evaluateSceneRules = (id: string) => this.call<Array<Record<string, unknown>>>('evaluateSceneRules', { id });
+  uploadSceneAsset = (name: string, data: ArrayBuffer) =>
+    this.call<{ location: string; contentType: string; size: number }>('uploadSceneAsset', { name }, data, {
+      'Content-Type': name.toLowerCase().endsWith('.gltf') ? 'model/gltf+json' : 'model/gltf-binary',
+    });
+  planDemoWorkspace = (params: { workspaceId?: string; s3Location: string; role: string }) =>
+    this.call<Record<string, unknown>>('planDemoWorkspace', params);
+  createDemoWorkspace = (req: { workspaceId?: string; s3Location: string; role: string }) =>
+    this.call<Record<string, unknown>>('createDemoWorkspace', undefined, req);
+  estimateQuery = (query: Record<string, unknown>) => this.call<Record<string, unknown>>('estimateQuery', undefined, query);
+  // from and to are epoch milliseconds, the last hour when omitted
+  debugBundle = (query: Record<string, unknown>, from?: number, to?: number) =>
+    this.call<Record<string, unknown>>('debugBundle', { from, to }, query);
+  getEntity = (id: string) => this.call<Record<string, unknown>>('getEntity', { id });
+  listWorkspaces = () => this.call<SelectableString[]>('listWorkspaces');
+  listScenes = () => this.call<SelectableString[]>('listScenes');
+  listOptions = () => this.call<Record<string, unknown>>('listOptions');
+  listEntityOptions = (id: string) => this.call<Array<Record<string, unknown>>>('listEntityOptions', { id });
+  listVariableOptions = (operationId: VariableOperation, parents: VariableParents) =>
+    this.call<Array<{ text: string; value: string }>>(operationId, { ...parents });
+  listEntitiesPage = (nextToken?: string, maxResults?: number) =>
+    this.call<ResourcePage<EntitySummary>>('listEntitiesPage', { nextToken, maxResults });
+  listComponentTypesPage = (nextToken?: string, maxResults?: number) =>
+    this.call<ResourcePage<ComponentTypeSummary>>('listComponentTypesPage', { nextToken, maxResults });
+}
@@ -16,6 +16,7 @@ export interface AWSTokenInfo {
  */
 export interface TwinMakerCustomMeta {
   nextToken?: string;
+  resolutionSnapshot?: string;
 }
 
 /**
@@ -97,7 +97,7 @@ export const AlarmConfigurationPanel: React.FunctionComponent<Props> = ({ id, da
       ];
       if (dataSource) {
         const doAsync = async () => {
-          await dataSource.batchPutPropertyValues(entries);
+          await dataSource.batchPutPropertyValues(entries, true);
           if (toField && toField === 'now') {
             setAlarmThreshold(newThreshold);
           }